@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expandGlobs expands wildcard patterns, which the Windows shell passes on
+// as they are. Elsewhere the shell has already expanded them. A pattern that
+// matches nothing is kept, so the upload reports the missing file.
+func expandGlobs(args []string) []string {
+	if runtime.GOOS != "windows" {
+		return args
+	}
+	var paths []string
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "*?[") {
+			if matches, err := filepath.Glob(arg); err == nil && len(matches) > 0 {
+				paths = append(paths, matches...)
+				continue
+			}
+		}
+		paths = append(paths, arg)
+	}
+	return paths
+}
+
+// uploadFiles uploads several files with a pool of workers. The returned
+// result summarizes the batch: its status is "success" only if every upload
+// succeeded, and Time is the total elapsed time. With ndjson each file's
+// result is printed as soon as it finishes and the summary carries no
+// per-file results; otherwise they are included in the order given.
+func uploadFiles(paths []string, workers int, ndjson bool, upload func(string) Result) Result {
+	startTime := time.Now()
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]Result, len(paths))
+	jobs := make(chan int)
+	var printMu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(paths); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = upload(paths[i])
+				results[i].Source = paths[i]
+				if ndjson {
+					printMu.Lock()
+					printResult(results[i])
+					printMu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	summary := Result{Status: "success"}
+	failed := 0
+	for _, r := range results {
+		summary.Size += r.Size
+		if summary.Server == "" {
+			summary.Server = r.Server
+		}
+		if r.Status == "failed" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		summary.Status = "failed"
+		summary.Error = fmt.Sprintf("%d of %d uploads failed", failed, len(paths))
+	}
+	summary.Message = fmt.Sprintf("%d of %d files uploaded", len(paths)-failed, len(paths))
+	if !ndjson {
+		summary.Results = results
+	}
+	summary.uploads = results
+	summary.Throughput = bytesPerSecond(summary.Size, time.Since(startTime))
+	summary.Time = time.Since(startTime).Milliseconds()
+	return summary
+}
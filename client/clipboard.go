@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardTools returns the commands that can set the clipboard on this
+// system, in order of preference
+func clipboardTools() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip.exe"}}
+	}
+	var tools [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, []string{"wl-copy"})
+	}
+	tools = append(tools,
+		[]string{"xclip", "-selection", "clipboard"},
+		[]string{"xsel", "--clipboard", "--input"},
+		[]string{"clip.exe"}, // WSL
+	)
+	return tools
+}
+
+// copyToClipboard puts text on the system clipboard with the first tool
+// available
+func copyToClipboard(text string) error {
+	for _, tool := range clipboardTools() {
+		path, err := exec.LookPath(tool[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %v", tool[0], err)
+		}
+		return nil
+	}
+	if runtime.GOOS == "linux" {
+		return errors.New("no clipboard tool found; install wl-copy, xclip or xsel")
+	}
+	return errors.New("no clipboard tool found")
+}
+
+// copyUploadURLs copies the absolute URLs of the successful uploads of result,
+// one per line, and records in result whether that worked. A failure only
+// warns: the uploads themselves went fine.
+func copyUploadURLs(result *Result) {
+	uploads := result.uploads
+	if len(uploads) == 0 {
+		uploads = []Result{*result}
+	}
+	var urls []string
+	for _, upload := range uploads {
+		if upload.Status == "success" && upload.Path != "" {
+			urls = append(urls, fileURL(strings.TrimRight(upload.Server, "/"), upload.Path))
+		}
+	}
+
+	copied := false
+	result.Copied = &copied
+	if len(urls) == 0 {
+		return
+	}
+	if err := copyToClipboard(strings.Join(urls, "\n")); err != nil {
+		warn(fmt.Sprintf("could not copy to the clipboard: %v", err))
+		return
+	}
+	copied = true
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// commands lists the subcommands; anything else is a file to upload
+var commands = []string{"upload", "upload-dir", "delete", "list", "info", "download", "watch", "config", "auth"}
+
+// isCommand reports whether arg names a subcommand
+func isCommand(arg string) bool {
+	for _, c := range commands {
+		if arg == c {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteFile is the metadata the server keeps for an uploaded file
+type RemoteFile struct {
+	FilePath     string `json:"file_path"`
+	OriginalName string `json:"original_name"`
+	FileSize     int64  `json:"file_size"`
+	UploadedAt   string `json:"uploaded_at"`
+	ExpiresAt    string `json:"expires_at,omitempty"` // Empty for files that never expire
+	Slug         string `json:"slug,omitempty"`
+	Tag          string `json:"tag,omitempty"`
+	Hash         string `json:"hash,omitempty"`
+	DownloadURL  string `json:"download_url,omitempty"`
+}
+
+// apiResponse is the part of a server response every command reads
+type apiResponse struct {
+	Success     bool         `json:"success"`
+	Code        string       `json:"code"`
+	Message     string       `json:"message"`
+	Directories []string     `json:"directories"`
+	Files       []RemoteFile `json:"files"`
+}
+
+// apiRequest sends a request with the API key and decodes the JSON response.
+// A non-2xx status or an unsuccessful response is returned as an error, with
+// the server's code and exit code filled in on result.
+func apiRequest(result *Result, method, requestURL, authToken string) (*apiResponse, error) {
+	req, err := http.NewRequest(method, requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-API-Key", authToken)
+	req.Header.Set("Accept", "application/json")
+
+	client := newHTTPClient(time.Minute)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	var parsed apiResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		if resp.StatusCode/100 != 2 {
+			result.exitCode = exitCodeForStatus(resp.StatusCode)
+			return nil, fmt.Errorf("server error (%d): %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		}
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	if resp.StatusCode/100 != 2 || !parsed.Success {
+		result.Code = parsed.Code
+		result.exitCode = exitCodeForStatus(resp.StatusCode)
+		return nil, fmt.Errorf("server error (%d): %s", resp.StatusCode, parsed.Message)
+	}
+	return &parsed, nil
+}
+
+// exitCodeForStatus maps a failed response's status to the exit code
+func exitCodeForStatus(status int) int {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return exitAuth
+	case http.StatusNotFound, http.StatusGone:
+		return exitNotFound
+	}
+	return exitFailed
+}
+
+// resolveFile splits a file argument into the server to ask and the file's
+// stored path. A full URL (as printed by uploads or copied from a browser)
+// names its own server; a bare path uses serverURL.
+func resolveFile(arg, serverURL string) (string, string, error) {
+	filePath := arg
+	if strings.Contains(arg, "://") {
+		u, err := url.Parse(arg)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid URL: %v", err)
+		}
+		serverURL = u.Scheme + "://" + u.Host
+		filePath = u.Path
+	}
+	filePath = strings.TrimPrefix(strings.TrimPrefix(filePath, "/"), "files/")
+	if filePath == "" || strings.Contains(filePath, "..") {
+		return "", "", fmt.Errorf("invalid file path %q", arg)
+	}
+	return strings.TrimRight(serverURL, "/"), filePath, nil
+}
+
+// fileURL returns the download URL of a stored path, escaping each segment
+func fileURL(serverURL, filePath string) string {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return serverURL + "/files/" + strings.Join(segments, "/")
+}
+
+// dateOfPath returns the date directory (YYYYMMDD) a stored path is listed
+// under: its first directory, or the date its generated name starts with
+func dateOfPath(filePath string) string {
+	dir := strings.SplitN(filePath, "/", 2)[0]
+	if isDate(dir) {
+		return dir
+	}
+	if name := path.Base(filePath); len(name) > 8 && name[8] == '-' && isDate(name[:8]) {
+		return name[:8]
+	}
+	return ""
+}
+
+func isDate(s string) bool {
+	if len(s) != 8 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// deleteFile deletes an uploaded file
+func deleteFile(arg, serverURL, authToken string) Result {
+	startTime := time.Now()
+	result := Result{Server: serverURL, Status: "failed"}
+
+	serverURL, filePath, err := resolveFile(arg, serverURL)
+	if err != nil {
+		result.Error = err.Error()
+		result.exitCode = exitUsage
+		result.Time = time.Since(startTime).Milliseconds()
+		return result
+	}
+	result.Server = serverURL
+
+	resp, err := apiRequest(&result, http.MethodDelete, fileURL(serverURL, filePath), authToken)
+	result.Time = time.Since(startTime).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = "success"
+	result.Path = filePath
+	result.Message = resp.Message
+	return result
+}
+
+// listFiles lists the date directories, or the files of one date directory
+func listFiles(date, serverURL, authToken string) Result {
+	startTime := time.Now()
+	serverURL = strings.TrimRight(serverURL, "/")
+	result := Result{Server: serverURL, Status: "failed"}
+
+	if date != "" && !isDate(date) {
+		result.Error = "--date must be a date directory like 20240501"
+		result.exitCode = exitUsage
+		result.Time = time.Since(startTime).Milliseconds()
+		return result
+	}
+
+	requestURL := serverURL + "/api/files"
+	if date != "" {
+		requestURL += "?path=" + url.QueryEscape(date)
+	}
+	resp, err := apiRequest(&result, http.MethodGet, requestURL, authToken)
+	result.Time = time.Since(startTime).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = "success"
+	result.Path = date
+	result.Directories = resp.Directories
+	result.Files = resp.Files
+	if date == "" {
+		result.Message = fmt.Sprintf("%d date directories", len(resp.Directories))
+	} else {
+		result.Message = fmt.Sprintf("%d files", len(resp.Files))
+	}
+	return result
+}
+
+// fileInfo shows the metadata of an uploaded file, found through the listing
+// of its date directory
+func fileInfo(arg, serverURL, authToken string) Result {
+	startTime := time.Now()
+	result := Result{Server: serverURL, Status: "failed"}
+
+	serverURL, filePath, err := resolveFile(arg, serverURL)
+	if err == nil && dateOfPath(filePath) == "" {
+		err = fmt.Errorf("%q is not a stored file path (YYYYMMDD/name.ext)", arg)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.exitCode = exitUsage
+		result.Time = time.Since(startTime).Milliseconds()
+		return result
+	}
+	result.Server = serverURL
+
+	requestURL := serverURL + "/api/files?path=" + url.QueryEscape(dateOfPath(filePath))
+	resp, err := apiRequest(&result, http.MethodGet, requestURL, authToken)
+	result.Time = time.Since(startTime).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for i := range resp.Files {
+		if resp.Files[i].FilePath != filePath {
+			continue
+		}
+		file := resp.Files[i]
+		file.DownloadURL = fileURL(serverURL, file.FilePath)
+		if strings.HasPrefix(file.ExpiresAt, "0001-") {
+			file.ExpiresAt = "" // Never expires
+		}
+		result.Status = "success"
+		result.Path = file.FilePath
+		result.Size = file.FileSize
+		result.File = &file
+		if file.ExpiresAt == "" {
+			result.Message = "never expires"
+		} else {
+			result.Message = fmt.Sprintf("expires at: %s", file.ExpiresAt)
+		}
+		return result
+	}
+
+	result.Error = "file not found"
+	result.Code = "not_found"
+	result.exitCode = exitNotFound
+	return result
+}
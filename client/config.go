@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Environment variables that override the config file
+const (
+	envServer = "HTTP_CLI_SERVER"
+	envToken  = "HTTP_CLI_TOKEN"
+)
+
+// cliConfig holds the defaults kept in the config file. Flags override the
+// environment, which overrides the file.
+type cliConfig struct {
+	Server string `json:"server,omitempty"`
+	Auth   string `json:"auth,omitempty"`
+	TTL    string `json:"ttl,omitempty"`
+
+	// Connection settings (see transportOptions)
+	Proxy      string `json:"proxy,omitempty"`
+	CACert     string `json:"ca_cert,omitempty"`
+	Insecure   bool   `json:"insecure,omitempty"`
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+
+	// Servers whose token `auth login` put in the OS credential store
+	KeychainServers []string `json:"keychain_servers,omitempty"`
+}
+
+// configKeys are the keys `config set` and `config get` accept
+var configKeys = []string{"server", "auth", "ttl", "proxy", "ca_cert", "insecure", "client_cert", "client_key"}
+
+// configPath returns the config file: %APPDATA%\http-cli\config.json on
+// Windows, ~/.http-cli.json elsewhere
+func configPath() (string, error) {
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "http-cli", "config.json"), nil
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("can't locate the config file: %v", err)
+	}
+	return filepath.Join(home, ".http-cli.json"), nil
+}
+
+// loadConfig reads the config file. A missing file is an empty config.
+func loadConfig() (cliConfig, error) {
+	var cfg cliConfig
+	path, err := configPath()
+	if err != nil {
+		return cfg, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// saveConfig writes the config file, readable by the owner only since it
+// holds the API token
+func saveConfig(cfg cliConfig) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return "", fmt.Errorf("failed to write config file: %v", err)
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(path, 0600); err != nil {
+		return "", fmt.Errorf("failed to restrict config file: %v", err)
+	}
+	return path, nil
+}
+
+// field returns a pointer to the value of a string config key, or nil for
+// insecure, the one boolean
+func (cfg *cliConfig) field(key string) *string {
+	switch key {
+	case "server":
+		return &cfg.Server
+	case "auth":
+		return &cfg.Auth
+	case "ttl":
+		return &cfg.TTL
+	case "proxy":
+		return &cfg.Proxy
+	case "ca_cert":
+		return &cfg.CACert
+	case "client_cert":
+		return &cfg.ClientCert
+	case "client_key":
+		return &cfg.ClientKey
+	}
+	return nil
+}
+
+// get returns the value of a config key as text; "" when unset
+func (cfg *cliConfig) get(key string) string {
+	if key == "insecure" {
+		if cfg.Insecure {
+			return "true"
+		}
+		return ""
+	}
+	return *cfg.field(key)
+}
+
+// set changes a config key; an empty value unsets it
+func (cfg *cliConfig) set(key, value string) error {
+	if key != "insecure" {
+		*cfg.field(key) = value
+		return nil
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "1":
+		cfg.Insecure = true
+	case "", "false", "no", "0":
+		cfg.Insecure = false
+	default:
+		return fmt.Errorf("insecure must be true or false")
+	}
+	return nil
+}
+
+// resolve picks a setting: the flag when given on the command line, then
+// the environment variable (if any), then the config file, then the flag's
+// default
+func resolve(flagValue string, flagSet bool, env, fileValue string) string {
+	if flagSet {
+		return flagValue
+	}
+	if env != "" {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	if fileValue != "" {
+		return fileValue
+	}
+	return flagValue
+}
+
+// maskToken hides all but the end of a token
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return strings.Repeat("*", len(token))
+	}
+	return strings.Repeat("*", 8) + token[len(token)-4:]
+}
+
+// configCommand runs `config set <key> <value>` and `config get [key]`.
+// A value of "-" is read from standard input, which keeps tokens out of
+// the shell history.
+func configCommand(args []string) Result {
+	result := Result{Status: "failed", exitCode: exitUsage}
+	if len(args) == 0 || (args[0] != "set" && args[0] != "get") {
+		result.Error = "usage: config set <key> <value> | config get [key]"
+		return result
+	}
+	action, args := args[0], args[1:]
+	if len(args) > 0 && !containsString(configKeys, args[0]) {
+		result.Error = fmt.Sprintf("unknown config key %q (keys: %s)", args[0], strings.Join(configKeys, ", "))
+		return result
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		result.Error = err.Error()
+		result.exitCode = exitFailed
+		return result
+	}
+	path, _ := configPath()
+	result.Path = path
+
+	if action == "get" {
+		if len(args) > 1 {
+			result.Error = "usage: config get [key]"
+			return result
+		}
+		result.Config = map[string]string{}
+		for _, key := range configKeys {
+			if value := cfg.get(key); value != "" && (len(args) == 0 || args[0] == key) {
+				if key == "auth" && len(args) == 0 {
+					value = maskToken(value)
+				}
+				result.Config[key] = value
+			}
+		}
+		result.Status = "success"
+		return result
+	}
+
+	if len(args) != 2 {
+		result.Error = "usage: config set <key> <value> (an empty value removes the key)"
+		return result
+	}
+	key, value := args[0], args[1]
+	if value == stdinPath {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			result.Error = fmt.Sprintf("failed to read %s from standard input: %v", key, err)
+			return result
+		}
+		value = line
+	}
+	value = strings.TrimSpace(value)
+	if key == "server" && value != "" && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		result.Error = "server must be an http:// or https:// URL"
+		return result
+	}
+	if (key == "ca_cert" || key == "client_cert" || key == "client_key") && value != "" {
+		// Relative paths would depend on where the client runs from
+		if abs, err := filepath.Abs(value); err == nil {
+			value = abs
+		}
+	}
+	if err := cfg.set(key, value); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if _, err := saveConfig(cfg); err != nil {
+		result.Error = err.Error()
+		result.exitCode = exitFailed
+		return result
+	}
+	result.Status = "success"
+	if cfg.get(key) == "" {
+		result.Message = fmt.Sprintf("%s removed", key)
+	} else {
+		result.Message = fmt.Sprintf("%s set", key)
+	}
+	return result
+}
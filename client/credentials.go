@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// envTokenFile names a file holding the API token, like --auth-file
+const envTokenFile = "HTTP_CLI_TOKEN_FILE"
+
+// credentialService names the client's entries in the OS credential store
+const credentialService = "http-cli"
+
+// errNoCredential is returned when the credential store has no token for a server
+var errNoCredential = errors.New("no token stored")
+
+// resolveAuth picks the API token, from the first source that has one:
+//
+//  1. -a/--auth
+//  2. --auth-file
+//  3. HTTP_CLI_TOKEN
+//  4. HTTP_CLI_TOKEN_FILE
+//  5. the OS credential store, for servers logged in with `auth login`
+//  6. auth in the config file
+func resolveAuth(flagAuth string, authGiven bool, authFile string, cfg cliConfig, server string) (string, error) {
+	if authGiven {
+		return flagAuth, nil
+	}
+	server = strings.TrimRight(server, "/")
+	if authFile != "" {
+		return readAuthFile(authFile)
+	}
+	if token := os.Getenv(envToken); token != "" {
+		return token, nil
+	}
+	if path := os.Getenv(envTokenFile); path != "" {
+		return readAuthFile(path)
+	}
+	if containsString(cfg.KeychainServers, server) {
+		token, err := lookupCredential(server)
+		if err == nil {
+			return token, nil
+		}
+		if err != errNoCredential {
+			warn(fmt.Sprintf("could not read the token from the %s: %v", credentialStoreName, err))
+		}
+	}
+	if cfg.Auth != "" {
+		return cfg.Auth, nil
+	}
+	return flagAuth, nil
+}
+
+// readAuthFile returns the first line of a token file. On Unix a file that
+// other users can read is used with a warning, since it defeats the purpose.
+func readAuthFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read auth file: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		warn(fmt.Sprintf("%s is accessible by other users (mode %04o); restrict it with chmod 600", path, info.Mode().Perm()))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read auth file: %v", err)
+	}
+	defer file.Close()
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("auth file %s is empty", path)
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", fmt.Errorf("auth file %s is empty", path)
+	}
+	return token, nil
+}
+
+// authCommand runs `auth login`, which reads a token from standard input and
+// keeps it in the OS credential store for server, and `auth logout`, which
+// removes it
+func authCommand(args []string, server string) Result {
+	result := Result{Status: "failed", Server: server, exitCode: exitUsage}
+	if len(args) != 1 || (args[0] != "login" && args[0] != "logout") {
+		result.Error = "usage: auth login | auth logout"
+		return result
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		result.Error = err.Error()
+		result.exitCode = exitFailed
+		return result
+	}
+
+	if args[0] == "logout" {
+		if err := deleteCredential(server); err != nil && err != errNoCredential {
+			result.Error = fmt.Sprintf("failed to remove the token from the %s: %v", credentialStoreName, err)
+			result.exitCode = exitFailed
+			return result
+		}
+		if containsString(cfg.KeychainServers, server) {
+			cfg.KeychainServers = removeString(cfg.KeychainServers, server)
+			if _, err := saveConfig(cfg); err != nil {
+				result.Error = err.Error()
+				result.exitCode = exitFailed
+				return result
+			}
+		}
+		result.Status = "success"
+		result.Message = fmt.Sprintf("token for %s removed from the %s", server, credentialStoreName)
+		return result
+	}
+
+	token, err := readToken()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if err := storeCredential(server, token); err != nil {
+		result.Error = fmt.Sprintf("failed to store the token in the %s: %v", credentialStoreName, err)
+		result.exitCode = exitFailed
+		return result
+	}
+	if !containsString(cfg.KeychainServers, server) {
+		cfg.KeychainServers = append(cfg.KeychainServers, server)
+		if _, err := saveConfig(cfg); err != nil {
+			result.Error = err.Error()
+			result.exitCode = exitFailed
+			return result
+		}
+	}
+	result.Status = "success"
+	result.Message = fmt.Sprintf("token for %s stored in the %s", server, credentialStoreName)
+	return result
+}
+
+// readToken reads a token from standard input, prompting without echo when
+// it is a terminal
+func readToken() (string, error) {
+	info, err := os.Stdin.Stat()
+	terminal := err == nil && info.Mode()&os.ModeCharDevice != 0
+	if terminal {
+		fmt.Fprint(os.Stderr, "API token: ")
+		if runtime.GOOS != "windows" {
+			echo := exec.Command("stty", "-echo")
+			echo.Stdin = os.Stdin
+			if echo.Run() == nil {
+				defer func() {
+					restore := exec.Command("stty", "echo")
+					restore.Stdin = os.Stdin
+					restore.Run()
+					fmt.Fprintln(os.Stderr)
+				}()
+			}
+		}
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read the token from standard input: %v", err)
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", errors.New("no token given")
+	}
+	return token, nil
+}
+
+// removeString returns list without value
+func removeString(list []string, value string) []string {
+	kept := list[:0]
+	for _, v := range list {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// warn writes a warning to stderr
+func warn(message string) {
+	stderrMu.Lock()
+	fmt.Fprintf(os.Stderr, "warning: %s\n", message)
+	stderrMu.Unlock()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeTokenFile writes content to a token file with the given permissions
+func writeTokenFile(t *testing.T, content string, perm os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// captureStderr returns what fn writes to standard error
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+
+	done := make(chan []byte)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		done <- out
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+// clearTokenEnv unsets the token environment variables for the test
+func clearTokenEnv(t *testing.T) {
+	t.Setenv(envToken, "")
+	t.Setenv(envTokenFile, "")
+}
+
+func TestResolveAuthPrecedence(t *testing.T) {
+	flagFile := writeTokenFile(t, "file-token\n", 0600)
+	envFile := writeTokenFile(t, "env-file-token\n", 0600)
+
+	tests := []struct {
+		name      string
+		flag      string
+		authGiven bool
+		authFile  string
+		env       string
+		envFile   string
+		config    string
+		want      string
+	}{
+		{name: "flag beats everything", flag: "flag-token", authGiven: true, authFile: flagFile, env: "env-token", envFile: envFile, config: "config-token", want: "flag-token"},
+		{name: "explicitly empty flag", flag: "", authGiven: true, env: "env-token", config: "config-token", want: ""},
+		{name: "auth file beats environment", authFile: flagFile, env: "env-token", envFile: envFile, config: "config-token", want: "file-token"},
+		{name: "token variable beats token file variable", env: "env-token", envFile: envFile, config: "config-token", want: "env-token"},
+		{name: "token file variable beats config", envFile: envFile, config: "config-token", want: "env-file-token"},
+		{name: "config", config: "config-token", want: "config-token"},
+		{name: "nothing", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envToken, tt.env)
+			t.Setenv(envTokenFile, tt.envFile)
+			got, err := resolveAuth(tt.flag, tt.authGiven, tt.authFile, cliConfig{Auth: tt.config}, "http://localhost:8080")
+			if err != nil {
+				t.Fatalf("resolveAuth: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveAuth = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAuthFileErrorsDoNotFallThrough(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv(envToken, "env-token")
+	t.Setenv(envTokenFile, "")
+	if got, err := resolveAuth("", false, missing, cliConfig{Auth: "config-token"}, "http://localhost:8080"); err == nil {
+		t.Errorf("missing --auth-file gave %q, want an error", got)
+	}
+
+	t.Setenv(envToken, "")
+	t.Setenv(envTokenFile, missing)
+	if got, err := resolveAuth("", false, "", cliConfig{Auth: "config-token"}, "http://localhost:8080"); err == nil {
+		t.Errorf("missing %s gave %q, want an error", envTokenFile, got)
+	}
+}
+
+func TestReadAuthFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "single line", content: "secret\n", want: "secret"},
+		{name: "no trailing newline", content: "secret", want: "secret"},
+		{name: "windows line ending", content: "secret\r\n", want: "secret"},
+		{name: "surrounding spaces", content: "  secret \t\n", want: "secret"},
+		{name: "only the first line", content: "secret\nsecond\n", want: "secret"},
+		{name: "empty", content: "", wantErr: true},
+		{name: "blank first line", content: "  \nsecret\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAuthFile(writeTokenFile(t, tt.content, 0600))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("readAuthFile = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("readAuthFile = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAuthFilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes don't reflect access on Windows")
+	}
+	tests := []struct {
+		perm os.FileMode
+		warn bool
+	}{
+		{0600, false},
+		{0400, false},
+		{0640, true},
+		{0604, true},
+		{0644, true},
+	}
+
+	for _, tt := range tests {
+		path := writeTokenFile(t, "secret\n", tt.perm)
+		var got string
+		var err error
+		stderr := captureStderr(t, func() { got, err = readAuthFile(path) })
+		if err != nil || got != "secret" {
+			t.Errorf("mode %04o: readAuthFile = %q, %v; want the token even with a warning", tt.perm, got, err)
+		}
+		warned := strings.Contains(stderr, "accessible by other users")
+		if warned != tt.warn {
+			t.Errorf("mode %04o: warned = %v, want %v (stderr %q)", tt.perm, warned, tt.warn, stderr)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dedupeUpload asks the server whether it already has a file's content and,
+// if so, has it store the file from its own copy (--dedupe). It reports false
+// when the file has to be uploaded after all: the content is new, the server
+// has no /upload/check, or the check failed. Only the hash has been sent then.
+func dedupeUpload(filePath string, opts uploadOptions) (Result, bool) {
+	startTime := time.Now()
+	hash, size, err := hashFile(filePath)
+	if err != nil {
+		// The regular upload reports the problem
+		return Result{}, false
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"sha256": hash, "size": size, "ttl": opts.TTL,
+		"filename": filepath.Base(filePath), "slug": opts.Slug, "tag": opts.Tag,
+	})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(opts.Server, "/")+"/upload/check", bytes.NewReader(body))
+	if err != nil {
+		return Result{}, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", opts.Auth)
+	resp, err := newHTTPClient(opts.Timeout).Do(req)
+	if err != nil {
+		return Result{}, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		// 200 is a miss; anything else is left to the upload to retry or report
+		return Result{}, false
+	}
+
+	result := Result{Server: opts.Server, Status: "failed", Attempts: 1, localName: filepath.Base(filePath)}
+	readUploadResponse(&result, resp)
+	result.Size = size
+	if result.Status == "success" {
+		result.Deduplicated = true
+		if !opts.NoVerify && result.ServerHash != "" {
+			result.Hash = hash
+			verifyUpload(&result, opts)
+		}
+	}
+	result.Time = time.Since(startTime).Milliseconds()
+	return result, true
+}
+
+// hashFile returns the hex sha256 and size of a file
+func hashFile(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if info.IsDir() {
+		return "", 0, fmt.Errorf("%s is a directory", filePath)
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), info.Size(), nil
+}
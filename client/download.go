@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Partial downloads are kept next to the output file until complete
+const (
+	partSuffix     = ".part"
+	partMetaSuffix = ".part.json"
+)
+
+// partialDownload describes a .part file, so a resume only continues the
+// same content: the URL it came from and the server's validator, its ETag
+// or else its Last-Modified
+type partialDownload struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"`
+	Size      int64  `json:"-"`
+}
+
+// downloadOptions are the settings of a download
+type downloadOptions struct {
+	Server   string
+	Auth     string
+	Output   string // Output file; from the response or the path when empty
+	Force    bool   // Overwrite an existing output file
+	Quiet    bool   // No progress output
+	Terminal bool   // Redraw progress in place
+	Timeout  time.Duration
+}
+
+// downloadFile fetches an uploaded file to disk. An interrupted download is
+// resumed with a Range request when the server supports it and the file is
+// unchanged; otherwise it starts over.
+func downloadFile(arg string, opts downloadOptions) Result {
+	startTime := time.Now()
+	result := Result{Server: opts.Server, Status: "failed"}
+
+	serverURL, filePath, err := resolveFile(arg, opts.Server)
+	if err != nil {
+		result.Error = err.Error()
+		result.exitCode = exitUsage
+		result.Time = time.Since(startTime).Milliseconds()
+		return result
+	}
+	result.Server = serverURL
+	result.Path = filePath
+
+	download(&result, fileURL(serverURL, filePath), path.Base(filePath), opts)
+	result.Time = time.Since(startTime).Milliseconds()
+	return result
+}
+
+// download runs a download into result
+func download(result *Result, requestURL, defaultName string, opts downloadOptions) {
+	client := newHTTPClient(opts.Timeout)
+
+	output := opts.Output
+	var partial partialDownload
+	if output != "" {
+		if err := checkOutput(output, opts.Force); err != nil {
+			result.Error = err.Error()
+			result.exitCode = exitUsage
+			return
+		}
+		partial = loadPartial(output, requestURL)
+	}
+
+	resp, err := getRange(client, requestURL, partial, opts.Auth)
+	if err != nil {
+		result.Error = fmt.Sprintf("download failed: %v", err)
+		return
+	}
+
+	// Without -o the name comes from the response, and only then is a
+	// partial download to resume known
+	if output == "" {
+		output = responseFileName(resp, defaultName)
+		if err := checkOutput(output, opts.Force); err != nil {
+			resp.Body.Close()
+			result.Error = err.Error()
+			result.exitCode = exitUsage
+			return
+		}
+		partial = loadPartial(output, requestURL)
+		if partial.Size > 0 && resp.StatusCode == http.StatusOK && resp.Header.Get("Accept-Ranges") == "bytes" {
+			resp.Body.Close()
+			if resp, err = getRange(client, requestURL, partial, opts.Auth); err != nil {
+				result.Error = fmt.Sprintf("download failed: %v", err)
+				return
+			}
+		}
+	}
+	defer resp.Body.Close()
+
+	// A resume must continue the same bytes of the same content; anything
+	// else starts over
+	offset := int64(0)
+	if resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if resp.StatusCode == http.StatusPartialContent && err == nil && start == partial.Size && responseValidator(resp) == partial.Validator {
+			offset = start
+		} else {
+			resp.Body.Close()
+			if resp, err = getRange(client, requestURL, partialDownload{}, opts.Auth); err != nil {
+				result.Error = fmt.Sprintf("download failed: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+		}
+	}
+	if resp.StatusCode != http.StatusOK && offset == 0 {
+		downloadError(result, resp)
+		return
+	}
+
+	// Record what the .part file holds before writing to it
+	partial = partialDownload{URL: requestURL, Validator: responseValidator(resp)}
+	if data, err := json.Marshal(partial); err == nil {
+		os.WriteFile(output+partMetaSuffix, data, 0644)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(output+partSuffix, flags, 0644)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create output file: %v", err)
+		return
+	}
+
+	start := time.Now()
+	total := resp.ContentLength
+	if total < 0 {
+		total = 0
+	}
+	p := newProgress(filepath.Base(output), total, opts.Quiet, opts.Terminal)
+	written, err := io.Copy(file, &progressReader{r: resp.Body, p: p})
+	p.finish()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		result.Size = offset + written
+		result.Error = fmt.Sprintf("download interrupted after %d bytes, run it again to resume: %v", offset+written, err)
+		return
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		result.Size = offset + written
+		result.Error = fmt.Sprintf("download incomplete: got %d of %d bytes, run it again to resume", written, resp.ContentLength)
+		return
+	}
+
+	if opts.Force {
+		os.Remove(output)
+	}
+	if err := os.Rename(output+partSuffix, output); err != nil {
+		result.Error = fmt.Sprintf("failed to move the download into place: %v", err)
+		return
+	}
+	os.Remove(output + partMetaSuffix)
+
+	result.Status = "success"
+	result.Output = output
+	result.Size = offset + written
+	result.Throughput = bytesPerSecond(written, time.Since(start))
+	if offset > 0 {
+		result.Message = fmt.Sprintf("downloaded to %s (resumed at %d bytes)", output, offset)
+	} else {
+		result.Message = fmt.Sprintf("downloaded to %s", output)
+	}
+}
+
+// getRange requests a file, asking for the rest of a partial download. If-Range
+// makes the server send the whole file instead when it has changed.
+func getRange(client *http.Client, requestURL string, partial partialDownload, authToken string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if authToken != "" {
+		req.Header.Set("X-API-Key", authToken)
+	}
+	// Byte ranges must refer to the stored file, not a compressed rendition
+	req.Header.Set("Accept-Encoding", "identity")
+	// Errors come back as JSON rather than an HTML page
+	req.Header.Set("Accept", "*/*, application/json")
+	if partial.Size > 0 && partial.Validator != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", partial.Size))
+		req.Header.Set("If-Range", partial.Validator)
+	}
+	return client.Do(req)
+}
+
+// loadPartial returns the partial download of output, if it came from
+// requestURL and its validator was recorded
+func loadPartial(output, requestURL string) partialDownload {
+	var partial partialDownload
+	info, err := os.Stat(output + partSuffix)
+	if err != nil || info.Size() == 0 {
+		return partialDownload{}
+	}
+	data, err := os.ReadFile(output + partMetaSuffix)
+	if err != nil || json.Unmarshal(data, &partial) != nil || partial.URL != requestURL {
+		return partialDownload{}
+	}
+	partial.Size = info.Size()
+	return partial
+}
+
+// checkOutput refuses to replace an existing file unless forced
+func checkOutput(output string, force bool) error {
+	info, err := os.Stat(output)
+	if err != nil {
+		return nil
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", output)
+	}
+	if !force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", output)
+	}
+	return nil
+}
+
+// responseFileName picks the output name: the Content-Disposition filename
+// when the server sends one, else the last path segment
+func responseFileName(resp *http.Response, defaultName string) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(filepath.Clean(params["filename"])); name != "." && name != string(filepath.Separator) && name != ".." {
+			return name
+		}
+	}
+	return defaultName
+}
+
+// responseValidator returns the strong ETag of a response, or else its
+// Last-Modified date
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// contentRangeStart returns the first byte of a "bytes start-end/size" range
+func contentRangeStart(header string) (int64, error) {
+	spec := strings.TrimPrefix(header, "bytes ")
+	dash := strings.Index(spec, "-")
+	if spec == header || dash < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return strconv.ParseInt(spec[:dash], 10, 64)
+}
+
+// downloadError fills in result from a failed download response
+func downloadError(result *Result, resp *http.Response) {
+	var parsed apiResponse
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	message := http.StatusText(resp.StatusCode)
+	if json.Unmarshal(body, &parsed) == nil && parsed.Message != "" {
+		message = parsed.Message
+		result.Code = parsed.Code
+	}
+	result.Error = fmt.Sprintf("server error (%d): %s", resp.StatusCode, message)
+	result.exitCode = exitCodeForStatus(resp.StatusCode)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Output formats. json prints the Result documents; the others print one
+// line per file on stdout, and errors on stderr so a pipeline never takes
+// an error message for a URL.
+const (
+	formatJSON     = "json"
+	formatURL      = "url"
+	formatMarkdown = "markdown"
+	formatBBCode   = "bbcode"
+	formatText     = "text"
+)
+
+var outputFormats = []string{formatJSON, formatURL, formatMarkdown, formatBBCode, formatText}
+
+// outputFormat is the format chosen with --format
+var outputFormat = formatJSON
+
+// imageExtensions are embedded as images by the markdown and bbcode formats;
+// other files become links
+var imageExtensions = []string{"png", "jpg", "jpeg", "gif", "webp", "bmp", "svg", "avif"}
+
+// printResult prints a result in the chosen output format
+func printResult(result Result) {
+	if outputFormat == formatJSON {
+		outputJSON(result)
+		return
+	}
+
+	// Several files: a line for each, then the summary
+	for _, r := range result.Results {
+		printResult(r)
+	}
+	if result.Status == "failed" {
+		printError(result)
+		return
+	}
+
+	switch {
+	case result.File != nil:
+		printFileLine(result.File.FilePath, result.File.DownloadURL, result.File.FileSize, result.File.ExpiresAt, "")
+	case len(result.Files) > 0:
+		for _, file := range result.Files {
+			printFileLine(file.FilePath, fileURL(strings.TrimRight(result.Server, "/"), file.FilePath), file.FileSize, file.ExpiresAt, "")
+		}
+	case result.Attempts > 0:
+		// An upload
+		name := result.localName
+		if name == "" {
+			name = result.Path
+		}
+		printFileLine(name, fileURL(strings.TrimRight(result.Server, "/"), result.Path), result.Size, result.ExpiresAt, "uploaded ")
+	case outputFormat == formatText:
+		// Downloads, deletes, listings of directories and config
+		printText(result)
+	}
+}
+
+// printFileLine prints the line of one file: its absolute URL, embedded for
+// markdown and bbcode, or a summary for text
+func printFileLine(name, url string, size int64, expiresAt, verb string) {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	image := containsString(imageExtensions, strings.ToLower(strings.TrimPrefix(path.Ext(name), ".")))
+	switch outputFormat {
+	case formatURL:
+		fmt.Println(url)
+	case formatMarkdown:
+		if image {
+			fmt.Printf("![%s](%s)\n", markdownEscape(name), url)
+		} else {
+			fmt.Printf("[%s](%s)\n", markdownEscape(name), url)
+		}
+	case formatBBCode:
+		if image {
+			fmt.Printf("[img]%s[/img]\n", url)
+		} else {
+			fmt.Printf("[url=%s]%s[/url]\n", url, name)
+		}
+	case formatText:
+		fmt.Printf("%s%s (%s, %s): %s\n", verb, name, formatBytes(size), humanExpiry(expiresAt), url)
+	}
+}
+
+// printText prints the message of a result without a file, such as delete
+// or config
+func printText(result Result) {
+	switch {
+	case len(result.Directories) > 0:
+		for _, dir := range result.Directories {
+			fmt.Println(dir)
+		}
+	case len(result.Config) > 0:
+		for _, key := range configKeys {
+			if value, ok := result.Config[key]; ok {
+				fmt.Printf("%s = %s\n", key, value)
+			}
+		}
+	case result.Message != "":
+		fmt.Println(result.Message)
+	}
+}
+
+// printError writes a failed result to stderr
+func printError(result Result) {
+	message := result.Error
+	if result.Source != "" {
+		message = result.Source + ": " + message
+	}
+	stderrMu.Lock()
+	fmt.Fprintf(os.Stderr, "error: %s\n", message)
+	stderrMu.Unlock()
+}
+
+// humanExpiry renders an RFC 3339 expiry for the text format, with the time
+// left
+func humanExpiry(expiresAt string) string {
+	if expiresAt == "" {
+		return "never expires"
+	}
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return "expires " + expiresAt
+	}
+	if left := time.Until(t); left > 0 {
+		return fmt.Sprintf("expires in %s, %s", humanDuration(left), t.Local().Format("2006-01-02 15:04 MST"))
+	}
+	return "expired " + t.Local().Format("2006-01-02 15:04 MST")
+}
+
+// markdownEscape escapes the characters that would end a link text
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
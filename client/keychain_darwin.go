@@ -0,0 +1,57 @@
+// +build darwin
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// credentialStoreName describes where `auth login` keeps tokens
+const credentialStoreName = "macOS keychain"
+
+// security runs the keychain tool
+func security(input string, args ...string) (string, error) {
+	cmd := exec.Command("/usr/bin/security", args...)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == 44 {
+			// errSecItemNotFound
+			return "", errNoCredential
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("security: %s", msg)
+		}
+		return "", fmt.Errorf("security: %v", err)
+	}
+	return stdout.String(), nil
+}
+
+// storeCredential saves the token for server. The command goes through
+// security's interactive mode so the token never appears in a process list.
+func storeCredential(server, token string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		strconv.Quote(credentialService), strconv.Quote(server), strconv.Quote(token))
+	_, err := security(command, "-i")
+	return err
+}
+
+// lookupCredential returns the token saved for server
+func lookupCredential(server string) (string, error) {
+	out, err := security("", "find-generic-password", "-s", credentialService, "-a", server, "-w")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// deleteCredential removes the token saved for server
+func deleteCredential(server string) error {
+	_, err := security("", "delete-generic-password", "-s", credentialService, "-a", server)
+	return err
+}
@@ -0,0 +1,60 @@
+// +build !windows,!darwin
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// credentialStoreName describes where `auth login` keeps tokens
+const credentialStoreName = "Secret Service keyring"
+
+// secretTool runs libsecret's secret-tool with input on stdin
+func secretTool(input string, args ...string) (string, error) {
+	path, err := exec.LookPath("secret-tool")
+	if err != nil {
+		return "", fmt.Errorf("secret-tool not found; install libsecret-tools")
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok && stderr.Len() == 0 && args[0] != "store" {
+			// lookup and clear fail silently when there is no such secret
+			return "", errNoCredential
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("secret-tool: %s", msg)
+		}
+		return "", fmt.Errorf("secret-tool: %v", err)
+	}
+	return stdout.String(), nil
+}
+
+// storeCredential saves the token for server
+func storeCredential(server, token string) error {
+	_, err := secretTool(token, "store", "--label", credentialService+" "+server, "service", credentialService, "server", server)
+	return err
+}
+
+// lookupCredential returns the token saved for server
+func lookupCredential(server string) (string, error) {
+	out, err := secretTool("", "lookup", "service", credentialService, "server", server)
+	if err != nil {
+		return "", err
+	}
+	if token := strings.TrimSpace(out); token != "" {
+		return token, nil
+	}
+	return "", errNoCredential
+}
+
+// deleteCredential removes the token saved for server
+func deleteCredential(server string) error {
+	_, err := secretTool("", "clear", "service", credentialService, "server", server)
+	return err
+}
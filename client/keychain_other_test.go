@@ -0,0 +1,74 @@
+// +build !windows,!darwin
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSecretTool puts a secret-tool on PATH whose lookup prints token, or
+// fails silently like the real one when token is empty, or fails with
+// message on stderr when it is set
+func fakeSecretTool(t *testing.T, token, message string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n"
+	switch {
+	case message != "":
+		script += "echo '" + message + "' >&2\nexit 1\n"
+	case token != "":
+		script += "[ \"$1\" = lookup ] && printf '%s\\n' '" + token + "' && exit 0\nexit 1\n"
+	default:
+		script += "exit 1\n"
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret-tool"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestResolveAuthKeychain(t *testing.T) {
+	const server = "https://img.example.com"
+	listed := cliConfig{Auth: "config-token", KeychainServers: []string{server}}
+
+	tests := []struct {
+		name    string
+		token   string // What the credential store holds
+		message string // Error the credential store fails with
+		env     string
+		cfg     cliConfig
+		server  string
+		want    string
+		warn    bool
+	}{
+		{name: "keychain beats config", token: "kc-token", cfg: listed, server: server, want: "kc-token"},
+		{name: "trailing slash", token: "kc-token", cfg: listed, server: server + "/", want: "kc-token"},
+		{name: "environment beats keychain", token: "kc-token", env: "env-token", cfg: listed, server: server, want: "env-token"},
+		{name: "server not logged in", token: "kc-token", cfg: cliConfig{Auth: "config-token"}, server: server, want: "config-token"},
+		{name: "other server logged in", token: "kc-token", cfg: listed, server: "https://other.example.com", want: "config-token"},
+		{name: "no stored token", cfg: listed, server: server, want: "config-token"},
+		{name: "store error", message: "cannot unlock the keyring", cfg: listed, server: server, want: "config-token", warn: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearTokenEnv(t)
+			t.Setenv(envToken, tt.env)
+			fakeSecretTool(t, tt.token, tt.message)
+
+			var got string
+			var err error
+			stderr := captureStderr(t, func() { got, err = resolveAuth("", false, "", tt.cfg, tt.server) })
+			if err != nil || got != tt.want {
+				t.Errorf("resolveAuth = %q, %v; want %q", got, err, tt.want)
+			}
+			if warned := strings.Contains(stderr, "cannot unlock the keyring"); warned != tt.warn {
+				t.Errorf("warned = %v, want %v (stderr %q)", warned, tt.warn, stderr)
+			}
+		})
+	}
+}
@@ -1,15 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"mime/multipart"
-	"net/http"
+	"math/rand"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -19,26 +15,87 @@ var (
 	version = "1.0.0"
 )
 
-// UploadResult represents the JSON output structure
-type UploadResult struct {
+// Exit codes
+const (
+	exitOK       = 0
+	exitFailed   = 1 // The request failed (network, server or file error)
+	exitUsage    = 2 // Bad command line
+	exitAuth     = 3 // The server refused the API key
+	exitNotFound = 4 // The file doesn't exist on the server
+)
+
+// Result represents the JSON output structure of every command
+type Result struct {
 	Status  string `json:"status"`  // "success" or "failed"
 	Error   string `json:"error,omitempty"`   // Error message if failed
+	Code    string `json:"code,omitempty"`    // Server error code if failed (see server/httpd/errors.go)
 	Path    string `json:"path,omitempty"`    // File path if successful
 	Message string `json:"message,omitempty"` // Additional information
-	Time    int64  `json:"time"`    // Upload time in milliseconds
+	Time    int64  `json:"time"`    // Request time in milliseconds
 	Size    int64  `json:"size,omitempty"`    // File size in bytes
+	Throughput int64 `json:"throughput,omitempty"` // Average upload rate in bytes per second
+	Attempts int `json:"attempts,omitempty"` // Upload attempts made, retries included
+	Hash string `json:"sha256,omitempty"` // sha256 of the content sent, when verified
+	ServerHash string `json:"server_sha256,omitempty"` // sha256 the server computed for the upload
+	ExpiresAt string `json:"expires_at,omitempty"` // Expiry of an upload (RFC 3339)
+	ExpiresIn string `json:"expires_in,omitempty"` // Time left until then by the local clock, like 23h59m
+	Note string `json:"note,omitempty"` // Caveat about the result, such as clock skew with the server
+	Copied *bool `json:"copied,omitempty"` // Whether the URLs were put on the clipboard (--copy)
+	Deduplicated bool `json:"deduplicated,omitempty"` // Stored from content the server already had, without sending it (--dedupe)
+	Config map[string]string `json:"config,omitempty"` // Config file values (config get)
 	Server  string `json:"server,omitempty"`  // Server address
+
+	Directories []string     `json:"directories,omitempty"` // Date directories (list)
+	Files       []RemoteFile `json:"files,omitempty"`       // Files of a date directory (list)
+	File        *RemoteFile  `json:"file,omitempty"`        // File metadata (info)
+	Results     []Result     `json:"results,omitempty"`     // Per-file results (upload of several files)
+	Source      string       `json:"source,omitempty"`      // Local file of a per-file result
+	Output      string       `json:"output,omitempty"`      // Local file written (download)
+
+	exitCode  int    // Process exit code for a failed result; exitFailed when unset
+	localName string // Local name of an upload, for the markdown, bbcode and text formats
+	uploads   []Result // Per-file results of a batch, also when not printed (--ndjson)
 }
 
 func main() {
-	// Preprocess args to handle common Windows command line issues
-	args := preprocessArgs(os.Args)
-
 	// Define command line flags
 	var (
 		flagServer  string
 		flagAuth    string
-		flagTTL     int
+		flagAuthFile string
+		flagTTL     string
+		flagSlug    string
+		flagTag     string
+		flagName    string
+		flagDate    string
+		flagNDJSON  bool
+		flagWorkers int
+		flagQuiet   bool
+		flagRetries int
+		flagTimeout time.Duration
+		flagNoVerify bool
+		flagResumable bool
+		flagDedupe  bool
+		flagCopy    bool
+		flagOutput  string
+		flagForce   bool
+		flagInterval time.Duration
+		flagExt     string
+		flagDeleteAfter bool
+		flagState   string
+		flagRecursive bool
+		flagInclude string
+		flagExclude string
+		flagManifest string
+		flagResume  bool
+		flagLimitRate string
+		flagLimitRateTotal string
+		flagFormat  string
+		flagProxy   string
+		flagCACert  string
+		flagInsecure bool
+		flagClientCert string
+		flagClientKey string
 		flagVersion bool
 		flagHelp    bool
 	)
@@ -48,8 +105,43 @@ func main() {
 	flagSet.StringVar(&flagServer, "server", "http://localhost:8080", "Server address")
 	flagSet.StringVar(&flagAuth, "a", "", "API authentication token (required)")
 	flagSet.StringVar(&flagAuth, "auth", "", "API authentication token (required)")
-	flagSet.IntVar(&flagTTL, "t", 1, "File TTL in hours (default: 1)")
-	flagSet.IntVar(&flagTTL, "ttl", 1, "File TTL in hours (default: 1)")
+	flagSet.StringVar(&flagAuthFile, "auth-file", "", "File whose first line is the API token")
+	flagSet.StringVar(&flagTTL, "t", "1", "File TTL: hours, a duration like 45m/36h/14d, or never (default: 1)")
+	flagSet.StringVar(&flagTTL, "ttl", "1", "File TTL: hours, a duration like 45m/36h/14d, or never (default: 1)")
+	flagSet.StringVar(&flagSlug, "slug", "", "Custom slug for a memorable URL (optional)")
+	flagSet.StringVar(&flagTag, "tag", "", "Tag/album to group the upload under (optional)")
+	flagSet.StringVar(&flagName, "filename", "", "File name sent when uploading standard input (default: stdin.bin)")
+	flagSet.StringVar(&flagDate, "date", "", "Date directory to list, YYYYMMDD (list)")
+	flagSet.BoolVar(&flagNDJSON, "ndjson", false, "Print one JSON line per file instead of a single document")
+	flagSet.IntVar(&flagWorkers, "concurrency", 3, "Files uploaded at the same time")
+	flagSet.IntVar(&flagRetries, "retries", defaultRetries, "Retries after a network error or a 5xx/429 response")
+	flagSet.DurationVar(&flagTimeout, "timeout", defaultTimeout, "Time limit for each upload attempt")
+	flagSet.StringVar(&flagOutput, "o", "", "Output file (download)")
+	flagSet.StringVar(&flagOutput, "output", "", "Output file (download)")
+	flagSet.BoolVar(&flagForce, "force", false, "Overwrite an existing output file (download)")
+	flagSet.DurationVar(&flagInterval, "interval", defaultWatchInterval, "Time between scans of the watched directory (watch)")
+	flagSet.StringVar(&flagExt, "ext", "", "Comma-separated extensions to upload, like png,jpg (watch)")
+	flagSet.BoolVar(&flagDeleteAfter, "delete-after-upload", false, "Delete local files once uploaded (watch)")
+	flagSet.StringVar(&flagState, "state", "", "State file of uploaded files (watch)")
+	flagSet.BoolVar(&flagRecursive, "recursive", false, "Upload subdirectories too (upload-dir)")
+	flagSet.StringVar(&flagInclude, "include", "", "Comma-separated globs of files to upload, like *.png,*.jpg (upload-dir)")
+	flagSet.StringVar(&flagExclude, "exclude", "", "Comma-separated globs of files to leave out (upload-dir)")
+	flagSet.StringVar(&flagManifest, "manifest", "", "Manifest file of the uploads (upload-dir, default: <dir>/"+manifestName+")")
+	flagSet.BoolVar(&flagResume, "resume", false, "Skip files the manifest has as uploaded and unchanged (upload-dir)")
+	flagSet.StringVar(&flagFormat, "format", formatJSON, "Output format: json, url, markdown, bbcode or text")
+	flagSet.StringVar(&flagProxy, "proxy", "", "Proxy URL (default: HTTPS_PROXY/HTTP_PROXY)")
+	flagSet.StringVar(&flagCACert, "ca-cert", "", "PEM file of CA certificates to trust")
+	flagSet.BoolVar(&flagInsecure, "insecure", false, "Don't verify the server's TLS certificate")
+	flagSet.StringVar(&flagClientCert, "client-cert", "", "PEM client certificate for mutual TLS")
+	flagSet.StringVar(&flagClientKey, "client-key", "", "PEM key of the client certificate")
+	flagSet.BoolVar(&flagNoVerify, "no-verify", false, "Don't check the upload against the server's sha256")
+	flagSet.BoolVar(&flagResumable, "resumable", false, "Upload in chunks that a later run can resume")
+	flagSet.BoolVar(&flagDedupe, "dedupe", false, "Skip sending files whose content the server already has")
+	flagSet.StringVar(&flagLimitRate, "limit-rate", "", "Upload rate limit of each file in bytes per second, like 500k or 2m")
+	flagSet.StringVar(&flagLimitRateTotal, "limit-rate-total", "", "Upload rate limit shared by all files, like 500k or 2m")
+	flagSet.BoolVar(&flagCopy, "copy", false, "Copy the URLs of the uploads to the clipboard")
+	flagSet.BoolVar(&flagQuiet, "q", false, "Don't show upload progress on stderr")
+	flagSet.BoolVar(&flagQuiet, "quiet", false, "Don't show upload progress on stderr")
 	flagSet.BoolVar(&flagVersion, "v", false, "Show version information")
 	flagSet.BoolVar(&flagVersion, "version", false, "Show version information")
 	flagSet.BoolVar(&flagHelp, "h", false, "Show help information")
@@ -57,24 +149,30 @@ func main() {
 
 	flagSet.Usage = printHelp
 
+	rand.Seed(time.Now().UnixNano()) // Retry jitter
+
+	// Preprocess args to handle common Windows command line issues
+	args := preprocessArgs(os.Args, flagSet)
+
 	// Parse flags
 	if err := flagSet.Parse(args); err != nil {
-		result := UploadResult{
-			Status: "failed",
-			Error:  err.Error(),
-		}
-		outputJSON(result)
-		os.Exit(1)
+		exitWith(Result{Status: "failed", Error: err.Error(), exitCode: exitUsage})
 		return
 	}
 
+	if !containsString(outputFormats, flagFormat) {
+		exitWith(Result{Status: "failed", Error: "--format must be one of: " + strings.Join(outputFormats, ", "), exitCode: exitUsage})
+		return
+	}
+	outputFormat = flagFormat
+
 	// Show version
 	if flagVersion {
-		result := UploadResult{
+		result := Result{
 			Status:  "success",
 			Message: fmt.Sprintf("HTTP Image Hosting Client v%s, Built for %s/%s", version, runtime.GOOS, runtime.GOARCH),
 		}
-		outputJSON(result)
+		printResult(result)
 		return
 	}
 
@@ -84,272 +182,368 @@ func main() {
 		return
 	}
 
-	// Get file path (remaining args)
-	filePathArgs := flagSet.Args()
-	if len(filePathArgs) < 1 {
-		result := UploadResult{
-			Status: "failed",
-			Error:  "file path is required",
-		}
-		outputJSON(result)
-		os.Exit(1)
+	// Pick the command; a bare file path uploads it
+	cmdArgs := flagSet.Args()
+	command := "upload"
+	if len(cmdArgs) > 0 && isCommand(cmdArgs[0]) {
+		command, cmdArgs = cmdArgs[0], cmdArgs[1:]
+	}
+	if command == "config" {
+		exitWith(configCommand(cmdArgs))
 		return
 	}
-
-	filePath := filePathArgs[0]
-
-	// Check API key
-	if flagAuth == "" {
-		result := UploadResult{
-			Status: "failed",
-			Error:  "API authentication token is required (-a flag)",
-		}
-		outputJSON(result)
-		os.Exit(1)
+	if command != "list" && command != "auth" && len(cmdArgs) < 1 {
+		exitWith(Result{Status: "failed", Error: fmt.Sprintf("%s needs a file path", command), exitCode: exitUsage})
 		return
 	}
 
-	// Upload file
-	result := uploadFile(filePath, flagServer, flagAuth, flagTTL)
-	outputJSON(result)
-
-	// Exit with error code if failed
-	if result.Status == "failed" {
-		os.Exit(1)
+	if flagRetries < 0 || flagTimeout <= 0 {
+		exitWith(Result{Status: "failed", Error: "--retries can't be negative and --timeout must be positive", exitCode: exitUsage})
+		return
 	}
-}
-
-// outputJSON prints the result as JSON to stdout
-func outputJSON(result UploadResult) {
-	data, err := json.Marshal(result)
+	limitRate, err := parseRate(flagLimitRate)
 	if err != nil {
-		// Fallback to plain text if JSON marshaling fails
-		fmt.Printf(`{"status":"failed","error":"failed to marshal output"}`)
+		exitWith(Result{Status: "failed", Error: "--limit-rate: " + err.Error(), exitCode: exitUsage})
 		return
 	}
-	fmt.Println(string(data))
-}
-
-// preprocessArgs preprocesses arguments to handle Windows command line issues
-func preprocessArgs(originalArgs []string) []string {
-	if len(originalArgs) <= 1 {
-		return originalArgs[1:]
-	}
-
-	// Skip the program name (first argument)
-	args := originalArgs[1:]
-
-	// Reorder args: move all flags to the front, file path to the end
-	var flags []string
-	var filePath string
-	var otherArgs []string
-
-	// First, identify flags and file path
-	for i := 0; i < len(args); i++ {
-		arg := args[i]
-		if strings.HasPrefix(arg, "-") {
-			// It's a flag
-			flags = append(flags, arg)
-			// Check if next arg is a value (not a flag)
-			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
-				i++
-				flags = append(flags, args[i])
-			}
-		} else if filePath == "" && !strings.Contains(arg, "/") && !strings.Contains(arg, "\\") {
-			// Could be a file path (no slashes)
-			// Check if file exists
-			if _, err := os.Stat(arg); err == nil {
-				filePath = arg
-			} else {
-				otherArgs = append(otherArgs, arg)
-			}
-		} else if strings.Contains(arg, "/") || strings.Contains(arg, "\\") {
-			// It's likely a file path
-			if filePath == "" {
-				filePath = arg
-			} else {
-				otherArgs = append(otherArgs, arg)
-			}
-		} else {
-			otherArgs = append(otherArgs, arg)
-		}
-	}
-
-	// If we found a file path among the args, move it to the end
-	if filePath != "" {
-		result := append(flags, otherArgs...)
-		result = append(result, filePath)
-		return result
-	}
-
-	// No file path found, return original
-	return args
-}
-
-// uploadFile uploads a file to the server
-func uploadFile(filePath, serverURL, authToken string, ttl int) UploadResult {
-	startTime := time.Now()
-	result := UploadResult{
-		Server: serverURL,
-		Status: "failed",
-	}
-
-	// Get file info
-	fileInfo, err := os.Stat(filePath)
+	limitRateTotal, err := parseRate(flagLimitRateTotal)
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to access file: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
-	}
-
-	if fileInfo.IsDir() {
-		result.Error = "path is a directory, not a file"
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+		exitWith(Result{Status: "failed", Error: "--limit-rate-total: " + err.Error(), exitCode: exitUsage})
+		return
 	}
+	sharedLimit := newTokenBucket(limitRateTotal)
 
-	result.Size = fileInfo.Size()
-
-	// Get absolute path
-	absPath, err := filepath.Abs(filePath)
+	// Fill in what the command line left out from the environment and the
+	// config file
+	fileConfig, err := loadConfig()
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to get absolute path: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+		exitWith(Result{Status: "failed", Error: err.Error(), exitCode: exitUsage})
+		return
 	}
-
-	// Get filename
-	filename := filepath.Base(absPath)
-
-	// Open file
-	file, err := os.Open(absPath)
+	given := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	flagServer = resolve(flagServer, given["s"] || given["server"], envServer, fileConfig.Server)
+	flagAuth, err = resolveAuth(flagAuth, given["a"] || given["auth"], flagAuthFile, fileConfig, flagServer)
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to open file: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+		exitWith(Result{Status: "failed", Error: err.Error(), exitCode: exitUsage})
+		return
 	}
-	defer file.Close()
-
-	// Create multipart form body
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
-
-	// Create form file
-	part, err := writer.CreateFormFile("file", filename)
-	if err != nil {
-		result.Error = fmt.Sprintf("failed to create form file: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+	flagTTL = resolve(flagTTL, given["t"] || given["ttl"], "", fileConfig.TTL)
+	transportOpts := transportOptions{
+		Proxy:      resolve(flagProxy, given["proxy"], "", fileConfig.Proxy),
+		CACert:     resolve(flagCACert, given["ca-cert"], "", fileConfig.CACert),
+		Insecure:   flagInsecure || (!given["insecure"] && fileConfig.Insecure),
+		ClientCert: resolve(flagClientCert, given["client-cert"], "", fileConfig.ClientCert),
+		ClientKey:  resolve(flagClientKey, given["client-key"], "", fileConfig.ClientKey),
 	}
-
-	// Copy file content
-	_, err = io.Copy(part, file)
+	transport, err := newTransport(transportOpts)
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to copy file content: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+		exitWith(Result{Status: "failed", Error: err.Error(), exitCode: exitUsage})
+		return
 	}
+	httpTransport = transport
 
-	// Add TTL field
-	writer.WriteField("ttl", fmt.Sprintf("%d", ttl))
-	writer.WriteField("filename", filename)
-
-	// Close multipart writer
-	if err := writer.Close(); err != nil {
-		result.Error = fmt.Sprintf("failed to close multipart writer: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+	if command == "auth" {
+		exitWith(authCommand(cmdArgs, strings.TrimRight(flagServer, "/")))
+		return
 	}
 
-	// Create request
-	serverURL = strings.TrimRight(serverURL, "/")
-	url := serverURL + "/upload"
-
-	req, err := http.NewRequest("POST", url, &body)
-	if err != nil {
-		result.Error = fmt.Sprintf("failed to create request: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+	// Check API key; files can be downloaded without one
+	if flagAuth == "" && command != "download" {
+		exitWith(Result{Status: "failed", Error: "API authentication token is required (-a, --auth-file, " + envToken + ", auth login or config set auth)", exitCode: exitUsage})
+		return
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("X-API-Key", authToken)
+	if command == "upload" || command == "upload-dir" || command == "watch" {
+		if err := checkTTL(flagTTL, flagServer); err != nil {
+			exitWith(Result{Status: "failed", Error: err.Error(), Code: "invalid_ttl", exitCode: exitUsage})
+			return
+		}
+	}
 
-	// Execute request
-	client := &http.Client{
-		Timeout: 5 * time.Minute,
+	var result Result
+	switch command {
+	case "upload":
+		opts := uploadOptions{Server: flagServer, Auth: flagAuth, TTL: flagTTL, Slug: flagSlug, Tag: flagTag, Filename: flagName, Quiet: flagQuiet, Retries: flagRetries, Timeout: flagTimeout, NoVerify: flagNoVerify, Dedupe: flagDedupe, Resumable: flagResumable, LimitRate: limitRate, sharedLimit: sharedLimit}
+		paths := expandGlobs(cmdArgs)
+		if flagResumable && containsString(paths, stdinPath) {
+			exitWith(Result{Status: "failed", Error: "standard input (-) can't be uploaded with --resumable", exitCode: exitUsage})
+			return
+		}
+		// Concurrent uploads can't share one redrawn line, so they print milestones
+		opts.Terminal = stderrIsTerminal() && (len(paths) == 1 || flagWorkers == 1)
+		if len(paths) == 1 {
+			result = uploadFile(paths[0], opts)
+			if flagCopy {
+				copyUploadURLs(&result)
+			}
+			break
+		}
+		if flagSlug != "" {
+			exitWith(Result{Status: "failed", Error: "--slug can only be used when uploading a single file", exitCode: exitUsage})
+			return
+		}
+		if containsString(paths, stdinPath) {
+			exitWith(Result{Status: "failed", Error: "standard input (-) can only be uploaded on its own", exitCode: exitUsage})
+			return
+		}
+		opts.Slug = ""
+		result = uploadFiles(paths, flagWorkers, flagNDJSON, func(filePath string) Result {
+			return uploadFile(filePath, opts)
+		})
+		if flagCopy {
+			copyUploadURLs(&result)
+		}
+	case "upload-dir":
+		if flagSlug != "" {
+			exitWith(Result{Status: "failed", Error: "--slug can only be used when uploading a single file", exitCode: exitUsage})
+			return
+		}
+		opts := uploadOptions{Server: flagServer, Auth: flagAuth, TTL: flagTTL, Tag: flagTag, Quiet: flagQuiet, Retries: flagRetries, Timeout: flagTimeout, NoVerify: flagNoVerify, Dedupe: flagDedupe, Resumable: flagResumable, LimitRate: limitRate, sharedLimit: sharedLimit}
+		opts.Terminal = stderrIsTerminal() && flagWorkers == 1
+		result = uploadDirectory(cmdArgs[0], uploadDirOptions{
+			Recursive: flagRecursive, Include: parseGlobs(flagInclude), Exclude: parseGlobs(flagExclude),
+			Manifest: flagManifest, Resume: flagResume, Workers: flagWorkers, NDJSON: flagNDJSON, UploadConfig: opts,
+		})
+		if flagCopy {
+			copyUploadURLs(&result)
+		}
+	case "delete":
+		result = deleteFile(cmdArgs[0], flagServer, flagAuth)
+	case "list":
+		result = listFiles(flagDate, flagServer, flagAuth)
+	case "info":
+		result = fileInfo(cmdArgs[0], flagServer, flagAuth)
+	case "watch":
+		if flagInterval <= 0 {
+			exitWith(Result{Status: "failed", Error: "--interval must be positive", exitCode: exitUsage})
+			return
+		}
+		opts := uploadOptions{Server: flagServer, Auth: flagAuth, TTL: flagTTL, Tag: flagTag, Quiet: flagQuiet, Retries: flagRetries, Timeout: flagTimeout, NoVerify: flagNoVerify, Dedupe: flagDedupe, LimitRate: limitRate, sharedLimit: sharedLimit}
+		result = watchDirectory(cmdArgs[0], watchOptions{
+			Interval: flagInterval, Extensions: parseExtensions(flagExt), DeleteAfter: flagDeleteAfter,
+			StateFile: flagState, UploadConfig: opts,
+		})
+	case "download":
+		result = downloadFile(cmdArgs[0], downloadOptions{
+			Server: flagServer, Auth: flagAuth, Output: flagOutput, Force: flagForce,
+			Quiet: flagQuiet, Terminal: stderrIsTerminal(), Timeout: flagTimeout,
+		})
 	}
+	exitWith(result)
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		result.Error = fmt.Sprintf("upload failed: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+// exitWith prints the result and exits with its exit code
+func exitWith(result Result) {
+	printResult(result)
+	if result.Status != "failed" {
+		os.Exit(exitOK)
+	}
+	if result.exitCode == 0 {
+		os.Exit(exitFailed)
 	}
-	defer resp.Body.Close()
+	os.Exit(result.exitCode)
+}
 
-	// Read response
-	respBody, err := io.ReadAll(resp.Body)
+// outputJSON prints the result as JSON to stdout
+func outputJSON(result Result) {
+	data, err := json.Marshal(result)
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to read response: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+		// Fallback to plain text if JSON marshaling fails
+		fmt.Printf(`{"status":"failed","error":"failed to marshal output"}`)
+		return
 	}
+	fmt.Println(string(data))
+}
 
-	// Parse response
-	var serverResult struct {
-		Success   bool   `json:"success"`
-		Message   string `json:"message"`
-		FilePath  string `json:"file_path"`
-		ExpiresAt string `json:"expires_at"`
+// preprocessArgs lets flags follow the file paths, as Windows users tend to
+// write them: flags (with their values) are collected in order ahead of the
+// positional arguments, which are passed after a "--" so the flag package
+// never reads a path such as "-draft.png" as a flag.
+//
+// An argument following a flag that takes a value is always that value,
+// whatever it starts with, unless given as -flag=value. Everything after a
+// "--" is positional, and "-" on its own means standard input. Nothing is
+// inspected on disk, and positional arguments keep their order.
+func preprocessArgs(originalArgs []string, flagSet *flag.FlagSet) []string {
+	if len(originalArgs) <= 1 {
+		return nil
 	}
 
-	if err := json.Unmarshal(respBody, &serverResult); err != nil {
-		result.Error = fmt.Sprintf("failed to parse response: %v", err)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
-	}
+	// Skip the program name (first argument)
+	args := originalArgs[1:]
 
-	// Check response
-	if resp.StatusCode != http.StatusOK {
-		result.Error = fmt.Sprintf("server error (%d): %s", resp.StatusCode, serverResult.Message)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
-	}
+	var flags []string
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == stdinPath {
+			positional = append(positional, arg)
+			continue
+		}
 
-	if !serverResult.Success {
-		result.Error = fmt.Sprintf("upload failed: %s", serverResult.Message)
-		result.Time = time.Since(startTime).Milliseconds()
-		return result
+		// It's a flag; unless it's -flag=value or boolean, the next
+		// argument is its value
+		flags = append(flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") || isBoolFlag(flagSet, name) || flagSet.Lookup(name) == nil {
+			continue
+		}
+		if i+1 == len(args) {
+			// Missing value: leave the flag last for the flag package to report
+			return flags
+		}
+		i++
+		flags = append(flags, args[i])
 	}
+	return append(append(flags, "--"), positional...)
+}
 
-	// Success
-	result.Status = "success"
-	result.Path = serverResult.FilePath
-	result.Message = serverResult.Message
-	result.Time = time.Since(startTime).Milliseconds()
-	if serverResult.ExpiresAt != "" {
-		result.Message = fmt.Sprintf("%s (expires at: %s)", result.Message, serverResult.ExpiresAt)
+// isBoolFlag reports whether name is a boolean flag, which takes no value
+func isBoolFlag(flagSet *flag.FlagSet, name string) bool {
+	f := flagSet.Lookup(name)
+	if f == nil {
+		return false
 	}
-
-	return result
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
 }
 
 func printHelp() {
 	fmt.Printf("HTTP Image Hosting Client v%s\n\n", version)
 	fmt.Println("Usage:")
-	fmt.Println("  http-cli [options] <file_path>")
+	fmt.Println("  http-cli [options] <file_path>...        Upload files")
+	fmt.Println("  http-cli [options] upload <file_path>... Upload files")
+	fmt.Println("  http-cli [options] delete <path-or-url>  Delete an uploaded file")
+	fmt.Println("  http-cli [options] list [--date YYYYMMDD] List date directories, or the files of one")
+	fmt.Println("  http-cli [options] info <path-or-url>    Show a file's metadata and expiry")
+	fmt.Println("  http-cli [options] download <path-or-url> [-o file]  Download a file, resuming")
+	fmt.Println("                                           an interrupted download")
+	fmt.Println("  http-cli [options] upload-dir <directory> Upload the files of a directory and write")
+	fmt.Println("                                           a manifest of their URLs")
+	fmt.Println("  http-cli [options] watch <directory>     Upload new files as they appear, until Ctrl+C")
+	fmt.Println("  http-cli config set <key> <value>        Save a default: server, auth, ttl, proxy,")
+	fmt.Println("                                           ca_cert, insecure, client_cert or client_key")
+	fmt.Println("  http-cli config get [key]                Show the saved defaults")
+	fmt.Println("  http-cli [-s url] auth login             Keep a token, read from standard input, in")
+	fmt.Println("                                           the OS credential store for the server")
+	fmt.Println("  http-cli [-s url] auth logout            Remove the server's token from the store")
+	fmt.Println()
+	fmt.Println("  list and info need an API token with the list scope; delete needs the delete scope.")
 	fmt.Println()
 	fmt.Println("Options:")
-	fmt.Println("  -a, --auth <token>    API authentication token (required)")
+	fmt.Println("  -a, --auth <token>    API authentication token (required unless set below); other")
+	fmt.Println("                        users can see it in the process list, prefer --auth-file")
+	fmt.Println("  --auth-file <file>    Read the token from the first line of a file, which should")
+	fmt.Println("                        be readable by you only (chmod 600)")
 	fmt.Println("  -s, --server <url>    Server address (default: http://localhost:8080)")
-	fmt.Println("  -t, --ttl <hours>     File TTL in hours (default: 1, max: 8760)")
+	fmt.Println("  -t, --ttl <ttl>       File TTL: hours (24), a duration (45m, 36h, 14d, 2w),")
+	fmt.Println("                        or never/0 when the server allows it (default: 1)")
+	fmt.Println("  --slug <name>         Custom slug served at /s/<name> ([a-z0-9-_], 3-64 chars)")
+	fmt.Println("  --tag <name>          Group the upload under a tag/album ([a-z0-9-_])")
+	fmt.Println("  --date <YYYYMMDD>     Date directory to list (list)")
+	fmt.Println("  --filename <name>     Name for an upload read from standard input with \"-\"")
+	fmt.Println("                        (default: stdin.bin); the server keeps its extension")
+	fmt.Println("  -o, --output <file>   File to download to (default: the server's file name)")
+	fmt.Println("  --force               Overwrite an existing file when downloading")
+	fmt.Println("  --interval <duration> Time between scans of a watched directory (default: 2s)")
+	fmt.Println("  --ext <list>          Extensions a watched directory uploads, like png,jpg (default: all)")
+	fmt.Println("  --delete-after-upload Delete watched files once uploaded")
+	fmt.Println("  --state <file>        Record of the watched files already uploaded")
+	fmt.Println("                        (default: .http-cli-watch.json in the directory)")
+	fmt.Println("  --recursive           Upload the subdirectories of upload-dir too")
+	fmt.Println("  --include <globs>     Comma-separated globs upload-dir uploads, like *.png,raw/*")
+	fmt.Println("  --exclude <globs>     Comma-separated globs upload-dir leaves out")
+	fmt.Println("  --manifest <file>     Manifest upload-dir writes (default: " + manifestName)
+	fmt.Println("                        in the directory)")
+	fmt.Println("  --resume              Skip the files the manifest has as uploaded, unchanged and")
+	fmt.Println("                        not expired")
+	fmt.Println("  --concurrency <n>     Files uploaded at the same time (default: 3)")
+	fmt.Println("  --retries <n>         Retries after a network error or a 5xx/429 response, with")
+	fmt.Println("                        backoff and the server's Retry-After (default: 2);")
+	fmt.Println("                        standard input isn't retried")
+	fmt.Println("  --timeout <duration>  Time limit for each upload attempt or download, like 90s or")
+	fmt.Println("                        10m (default: 5m)")
+	fmt.Println("  --no-verify           Don't compare the file with the sha256 the server returns; a")
+	fmt.Println("                        mismatch otherwise fails with hash_mismatch after one resend")
+	fmt.Println("  --copy                Copy the URL of the upload to the clipboard, one line per")
+	fmt.Println("                        file (pbcopy, clip.exe, wl-copy, xclip or xsel); sets")
+	fmt.Println("                        \"copied\" in the JSON output")
+	fmt.Println("  --resumable           Upload in chunks, each retried on its own, for large files")
+	fmt.Println("                        over bad links; running the same upload again resumes it")
+	fmt.Println("  --dedupe              Send each file's sha256 first; when the server already has")
+	fmt.Println("                        the content it stores the file from its own copy and")
+	fmt.Println("                        nothing is sent (\"deduplicated\" in the JSON output)")
+	fmt.Println("  --limit-rate <rate>   Upload each file at most this fast, in bytes per second")
+	fmt.Println("                        with an optional k, m or g suffix (KiB, MiB, GiB), like 500k")
+	fmt.Println("  --limit-rate-total <rate>  Upload at most this fast in all, shared by the files")
+	fmt.Println("                        uploaded at the same time; --timeout is extended by the")
+	fmt.Println("                        time a limit makes an upload take")
+	fmt.Println("  --proxy <url>         Proxy for every request (default: HTTPS_PROXY, HTTP_PROXY")
+	fmt.Println("                        and NO_PROXY from the environment)")
+	fmt.Println("  --ca-cert <file>      Also trust the CA certificates of a PEM file")
+	fmt.Println("  --insecure            Don't verify the server's TLS certificate (warns on stderr)")
+	fmt.Println("  --client-cert <file>  PEM client certificate for mutual TLS, with --client-key <file>")
+	fmt.Println("  --format <format>     Output: json (default), url, markdown, bbcode or text. All but")
+	fmt.Println("                        json print a line per file and send errors to stderr")
+	fmt.Println("  -q, --quiet           Don't show upload progress (it goes to stderr, never stdout)")
+	fmt.Println("  --ndjson              With several files, print one JSON line per file as it")
+	fmt.Println("                        finishes and a summary line, instead of one document")
 	fmt.Println("  -v, --version         Show version information")
 	fmt.Println("  -h, --help            Show this help message")
 	fmt.Println()
+	fmt.Println("Watch mode:")
+	fmt.Println("  Files already in the directory the first time it is watched are skipped. A new")
+	fmt.Println("  file is uploaded once its size stops changing, and each upload prints a JSON")
+	fmt.Println("  line. The state file keeps what was uploaded across restarts; subdirectories")
+	fmt.Println("  and hidden files are ignored.")
+	fmt.Println()
+	fmt.Println("Directory uploads:")
+	fmt.Println("  upload-dir maps each file's path, relative to the directory, to its URL, sha256,")
+	fmt.Println("  size and expiry in the manifest, which is saved after every upload and on")
+	fmt.Println("  Ctrl+C. Hidden files and directories are skipped. A glob without a slash")
+	fmt.Println("  matches file names in any subdirectory; one with a slash the relative path.")
+	fmt.Println()
+	fmt.Println("Defaults:")
+	fmt.Println("  Options left out are taken from HTTP_CLI_SERVER and HTTP_CLI_TOKEN, then from")
+	fmt.Println("  the config file (~/.http-cli.json, %APPDATA%\\http-cli\\config.json on Windows)")
+	fmt.Println("  written by \"config set\" with owner-only permissions. \"config set auth -\" reads")
+	fmt.Println("  the token from standard input, keeping it out of the shell history.")
+	fmt.Println()
+	fmt.Println("  The API token comes from the first of: -a/--auth, --auth-file, HTTP_CLI_TOKEN,")
+	fmt.Println("  HTTP_CLI_TOKEN_FILE (a file like --auth-file), the OS credential store after")
+	fmt.Println("  \"auth login\" (Windows Credential Manager, macOS keychain, or Secret Service")
+	fmt.Println("  through secret-tool), then auth in the config file.")
+	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  http-cli -a my-token photo.jpg")
+	fmt.Println("  http-cli config set server https://img.example.com")
+	fmt.Println("  http-cli -s https://img.example.com auth login < token.txt")
+	fmt.Println("  http-cli --auth-file ~/.config/http-cli/token photo.jpg")
 	fmt.Println("  http-cli -a abc123 -t 24 C:/Users/Zoo/image.png")
-	fmt.Println("  http-cli -a my-token -s http://192.168.1.100:8080 -t 48 photo.jpg")
+	fmt.Println("  http-cli -a my-token -s http://192.168.1.100:8080 -t 14d photo.jpg")
+	fmt.Println("  http-cli -a my-token -t 45m screenshot.png")
+	fmt.Println("  http-cli -a my-token -t never logo.png")
+	fmt.Println("  http-cli -a my-token --resumable -t 7d backup.tar.gz")
+	fmt.Println("  http-cli -a my-token --slug team-offsite-map map.png")
+	fmt.Println("  http-cli -a my-token --format url screenshot.png | xclip -selection clipboard")
+	fmt.Println("  http-cli -a my-token --concurrency 5 *.png")
+	fmt.Println("  http-cli -a my-token --limit-rate-total 500k --recursive upload-dir ~/Videos")
+	fmt.Println("  http-cli -a my-token --ndjson shots/*.png logs/today.txt")
+	fmt.Println("  import png:- | http-cli -a my-token --filename screen.png -")
+	fmt.Println("  http-cli -a my-token delete 20240501/20240501-101502123-3f2a9c.png")
+	fmt.Println("  http-cli -a my-token list --date 20240501")
+	fmt.Println("  http-cli -a my-token --recursive --include '*.jpg,*.png' upload-dir ~/Pictures/trip")
+	fmt.Println("  http-cli -a my-token --recursive --resume upload-dir ~/Pictures/trip")
+	fmt.Println("  http-cli -a my-token -t 24 --ext png,jpg watch ~/Screenshots")
+	fmt.Println("  http-cli download 20240501/20240501-101502123-3f2a9c.png -o map.png")
+	fmt.Println("  http-cli -a my-token info http://192.168.1.100:8080/files/20240501/20240501-101502123-3f2a9c.png")
+	fmt.Println()
+	fmt.Println("Exit codes:")
+	fmt.Println("  0 success, 1 request failed, 2 bad arguments, 3 API key refused, 4 file not found")
+	fmt.Println("  With several files: 0 when all were uploaded, 1 when any failed")
 }
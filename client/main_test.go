@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// testFlagSet declares a few flags of each kind the client has
+func testFlagSet() *flag.FlagSet {
+	flagSet := flag.NewFlagSet("http-cli", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	flagSet.String("a", "", "")
+	flagSet.String("auth", "", "")
+	flagSet.String("t", "1", "")
+	flagSet.String("ttl", "1", "")
+	flagSet.String("date", "", "")
+	flagSet.Bool("ndjson", false, "")
+	flagSet.Bool("force", false, "")
+	return flagSet
+}
+
+func TestPreprocessArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		want       []string
+		positional []string // Left for the command after parsing; nil when parsing fails
+	}{
+		{
+			name: "no arguments",
+			args: nil,
+			want: nil,
+		},
+		{
+			name:       "flags after the file",
+			args:       []string{"a.png", "-a", "token"},
+			want:       []string{"-a", "token", "--", "a.png"},
+			positional: []string{"a.png"},
+		},
+		{
+			name:       "positional order kept",
+			args:       []string{"b.png", "-t", "2", "a.png", "--ndjson", "c.png"},
+			want:       []string{"-t", "2", "--ndjson", "--", "b.png", "a.png", "c.png"},
+			positional: []string{"b.png", "a.png", "c.png"},
+		},
+		{
+			name:       "windows paths",
+			args:       []string{`C:\Users\me\Pictures\a.png`, "-a", "token", `D:\shots\b.png`},
+			want:       []string{"-a", "token", "--", `C:\Users\me\Pictures\a.png`, `D:\shots\b.png`},
+			positional: []string{`C:\Users\me\Pictures\a.png`, `D:\shots\b.png`},
+		},
+		{
+			name:       "spaces in names",
+			args:       []string{"-a", "token", `C:\My Pictures\summer day.png`, "two words.jpg"},
+			want:       []string{"-a", "token", "--", `C:\My Pictures\summer day.png`, "two words.jpg"},
+			positional: []string{`C:\My Pictures\summer day.png`, "two words.jpg"},
+		},
+		{
+			name:       "flag value with spaces",
+			args:       []string{"a.png", "--auth", "token with spaces"},
+			want:       []string{"--auth", "token with spaces", "--", "a.png"},
+			positional: []string{"a.png"},
+		},
+		{
+			name:       "dash-leading names after --",
+			args:       []string{"-a", "token", "--", "-weird.png", "--also-weird.png", "-a"},
+			want:       []string{"-a", "token", "--", "-weird.png", "--also-weird.png", "-a"},
+			positional: []string{"-weird.png", "--also-weird.png", "-a"},
+		},
+		{
+			name:       "files before and after --",
+			args:       []string{"a.png", "--", "-b.png"},
+			want:       []string{"--", "a.png", "-b.png"},
+			positional: []string{"a.png", "-b.png"},
+		},
+		{
+			name:       "dash-leading flag value",
+			args:       []string{"a.png", "-a", "-secret-"},
+			want:       []string{"-a", "-secret-", "--", "a.png"},
+			positional: []string{"a.png"},
+		},
+		{
+			name:       "flag=value takes no next argument",
+			args:       []string{"-ttl=2h", "a.png", "--auth=tok=en", "b.png"},
+			want:       []string{"-ttl=2h", "--auth=tok=en", "--", "a.png", "b.png"},
+			positional: []string{"a.png", "b.png"},
+		},
+		{
+			name:       "boolean flag takes no value",
+			args:       []string{"--force", "a.png", "-ndjson", "b.png"},
+			want:       []string{"--force", "-ndjson", "--", "a.png", "b.png"},
+			positional: []string{"a.png", "b.png"},
+		},
+		{
+			name:       "boolean flag with explicit value",
+			args:       []string{"--force=false", "a.png"},
+			want:       []string{"--force=false", "--", "a.png"},
+			positional: []string{"a.png"},
+		},
+		{
+			name:       "standard input",
+			args:       []string{"-", "-a", "token"},
+			want:       []string{"-a", "token", "--", "-"},
+			positional: []string{"-"},
+		},
+		{
+			name:       "subcommand with flags after it",
+			args:       []string{"list", "--date", "20240101", "-a", "token"},
+			want:       []string{"--date", "20240101", "-a", "token", "--", "list"},
+			positional: []string{"list"},
+		},
+		{
+			name: "unknown flag stays for the flag package",
+			args: []string{"-x", "a.png"},
+			want: []string{"-x", "--", "a.png"},
+		},
+		{
+			name: "missing value",
+			args: []string{"a.png", "-a"},
+			want: []string{"-a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flagSet := testFlagSet()
+			got := preprocessArgs(append([]string{"http-cli"}, tt.args...), flagSet)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("preprocessArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+
+			err := flagSet.Parse(got)
+			if tt.positional == nil {
+				if err == nil && len(tt.args) > 0 {
+					t.Errorf("Parse(%q) succeeded, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", got, err)
+			}
+			if !reflect.DeepEqual(flagSet.Args(), tt.positional) {
+				t.Errorf("positional arguments %q, want %q", flagSet.Args(), tt.positional)
+			}
+		})
+	}
+}
+
+func TestPreprocessArgsFlagValues(t *testing.T) {
+	flagSet := testFlagSet()
+	args := preprocessArgs([]string{"http-cli", `C:\a b.png`, "-a", "-tok", "--ttl=14d", "--force"}, flagSet)
+	if err := flagSet.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a": "-tok", "ttl": "14d", "force": "true", "t": "1"} {
+		if got := flagSet.Lookup(name).Value.String(); got != want {
+			t.Errorf("-%s = %q, want %q", name, got, want)
+		}
+	}
+}
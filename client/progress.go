@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Progress output: redrawn in place on a terminal, otherwise written as plain
+// lines at every quarter so logs stay readable
+const (
+	progressRedrawInterval = 200 * time.Millisecond
+	progressLineStep       = 25 // Percent between lines when not on a terminal
+)
+
+// stderrMu keeps progress lines from different uploads whole
+var stderrMu sync.Mutex
+
+// progress reports how far an upload has got on stderr; stdout is reserved
+// for the JSON result. A nil *progress reports nothing.
+type progress struct {
+	name     string
+	total    int64 // Bytes expected; 0 when unknown (standard input)
+	terminal bool  // Redraw one line instead of printing milestones
+
+	start    time.Time
+	done     int64
+	resumed  int64 // Bytes done by an earlier run, left out of the rate
+	lastDraw time.Time
+	lastStep int64
+}
+
+// newProgress returns a progress reporter, or nil when quiet is set
+func newProgress(name string, total int64, quiet, terminal bool) *progress {
+	if quiet {
+		return nil
+	}
+	return &progress{name: name, total: total, terminal: terminal, start: time.Now()}
+}
+
+// stderrIsTerminal reports whether stderr is an interactive terminal
+func stderrIsTerminal() bool {
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// add records n more bytes sent
+func (p *progress) add(n int) {
+	if p == nil || n == 0 {
+		return
+	}
+	p.done += int64(n)
+	now := time.Now()
+
+	if p.terminal {
+		if now.Sub(p.lastDraw) >= progressRedrawInterval {
+			p.lastDraw = now
+			p.draw(now, "\r", "")
+		}
+		return
+	}
+	if p.total > 0 {
+		if step := p.done * 100 / p.total / progressLineStep; step > p.lastStep {
+			p.lastStep = step
+			p.draw(now, "", "\n")
+		}
+	}
+}
+
+// resume records n bytes already sent by an earlier run
+func (p *progress) resume(n int64) {
+	if p == nil {
+		return
+	}
+	p.done += n
+	p.resumed += n
+}
+
+// finish ends the progress line
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	if p.terminal {
+		p.draw(time.Now(), "\r", "\n")
+	} else if p.total == 0 {
+		p.draw(time.Now(), "", "\n")
+	}
+}
+
+// draw writes the current state: percentage, throughput and time left when
+// the size is known, otherwise bytes sent and throughput
+func (p *progress) draw(now time.Time, prefix, suffix string) {
+	elapsed := now.Sub(p.start)
+	rate := bytesPerSecond(p.done-p.resumed, elapsed)
+
+	line := fmt.Sprintf("%s  %s  %s/s", p.name, formatBytes(p.done), formatBytes(rate))
+	if p.total > 0 {
+		eta := "--:--"
+		if rate > 0 {
+			eta = formatETA(time.Duration(float64(p.total-p.done) / float64(rate) * float64(time.Second)))
+		}
+		line = fmt.Sprintf("%s  %3d%%  %s/s  ETA %s", p.name, p.done*100/p.total, formatBytes(rate), eta)
+	}
+
+	stderrMu.Lock()
+	fmt.Fprintf(os.Stderr, "%s%-70s%s", prefix, line, suffix)
+	stderrMu.Unlock()
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.add(n)
+	return n, err
+}
+
+// bytesPerSecond returns the average rate of n bytes over elapsed
+func bytesPerSecond(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatETA renders a duration as m:ss, or h:mm:ss past an hour
+func formatETA(d time.Duration) string {
+	s := int64(d.Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
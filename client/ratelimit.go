@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limits are bytes per second, with an optional k, m or g suffix for
+// KiB, MiB or GiB, as curl's --limit-rate
+var rateUnits = map[string]int64{
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+}
+
+// minRateLimit keeps a limit from being so low that an upload never ends
+const minRateLimit = 1024
+
+// rateBurst is how much of a second's worth of bytes may go out at once; a
+// smaller burst is smoother, a larger one costs fewer wakeups
+const rateBurst = 100 * time.Millisecond
+
+// parseRate checks a --limit-rate value and returns it in bytes per second,
+// 0 for no limit
+func parseRate(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	number, unit := value, int64(1)
+	if u, ok := rateUnits[value[len(value)-1:]]; ok {
+		number, unit = value[:len(value)-1], u
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 || n*float64(unit) > 1<<40 {
+		return 0, fmt.Errorf("invalid rate %q: use bytes per second, like 500k or 2m", value)
+	}
+	rate := int64(n * float64(unit))
+	if rate < minRateLimit {
+		return 0, fmt.Errorf("rate %q is too low: the minimum is 1k", value)
+	}
+	return rate, nil
+}
+
+// tokenBucket paces bytes to a rate. Each byte takes a token; tokens come
+// back at rate per second, up to a burst of rateBurst worth. A bucket is safe
+// to share between uploads, which then split the rate between them.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   int64
+	burst  int64
+	tokens float64
+	last   time.Time
+
+	// The clock, replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// newTokenBucket returns a bucket for rate bytes per second, or nil for no
+// limit. It starts full.
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := int64(float64(rate) * rateBurst.Seconds())
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: float64(burst), last: time.Now(), now: time.Now, sleep: time.Sleep}
+}
+
+// take waits until n bytes may go out. Taking more than there are tokens
+// leaves the bucket in debt, which the wait pays off; the lock is released
+// during the wait so others sharing the bucket queue up behind the debt.
+func (b *tokenBucket) take(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if wait > 0 {
+		b.sleep(wait)
+	}
+}
+
+// throttledReader paces reads through each of its buckets
+type throttledReader struct {
+	r       io.Reader
+	buckets []*tokenBucket
+	chunk   int // Largest read, so one read never waits much longer than a burst
+}
+
+func (tr *throttledReader) Read(b []byte) (int, error) {
+	if len(b) > tr.chunk {
+		b = b[:tr.chunk]
+	}
+	n, err := tr.r.Read(b)
+	for _, bucket := range tr.buckets {
+		bucket.take(n)
+	}
+	return n, err
+}
+
+// throttle paces r to the upload's limits: its own --limit-rate, and the
+// --limit-rate-total bucket shared with the other uploads. It returns r
+// itself when there is no limit.
+func (opts uploadOptions) throttle(r io.Reader) io.Reader {
+	var buckets []*tokenBucket
+	chunk := 32 * 1024
+	if bucket := newTokenBucket(opts.LimitRate); bucket != nil {
+		buckets = append(buckets, bucket)
+	}
+	if opts.sharedLimit != nil {
+		buckets = append(buckets, opts.sharedLimit)
+	}
+	if len(buckets) == 0 {
+		return r
+	}
+	for _, bucket := range buckets {
+		if int(bucket.burst) < chunk {
+			chunk = int(bucket.burst)
+		}
+	}
+	return &throttledReader{r: r, buckets: buckets, chunk: chunk}
+}
+
+// throttledTimeout returns the time limit of a request sending n bytes under
+// the upload's limits: the usual --timeout on top of the time the limits
+// alone make it take, so a throttled upload isn't cut off for being slow.
+// Uploads sharing --limit-rate-total may each get less than the total.
+func (opts uploadOptions) throttledTimeout(n int64) time.Duration {
+	rate := opts.LimitRate
+	if opts.sharedLimit != nil && (rate == 0 || opts.sharedLimit.rate < rate) {
+		rate = opts.sharedLimit.rate
+	}
+	if rate <= 0 || n <= 0 {
+		return opts.Timeout
+	}
+	return opts.Timeout + time.Duration(float64(n)/float64(rate)*float64(time.Second))
+}
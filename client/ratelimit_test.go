@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose sleeps return at once, moving its time on
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time                   { return c.t }
+func (c *fakeClock) sleep(d time.Duration)            { c.t = c.t.Add(d) }
+func (c *fakeClock) since(t0 time.Time) time.Duration { return c.t.Sub(t0) }
+
+// use makes bucket run on the clock
+func (c *fakeClock) use(bucket *tokenBucket) *tokenBucket {
+	bucket.now, bucket.sleep, bucket.last = c.now, c.sleep, c.t
+	return bucket
+}
+
+// maxReadRecorder remembers the largest read asked of it
+type maxReadRecorder struct {
+	r   io.Reader
+	max int
+}
+
+func (m *maxReadRecorder) Read(b []byte) (int, error) {
+	if len(b) > m.max {
+		m.max = len(b)
+	}
+	return m.r.Read(b)
+}
+
+// within reports whether got is want give or take margin
+func within(got, want, margin time.Duration) bool {
+	return got >= want-margin && got <= want+margin
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "0", want: 0},
+		{value: "2048", want: 2048},
+		{value: "100k", want: 100 << 10},
+		{value: "100K", want: 100 << 10},
+		{value: " 2m ", want: 2 << 20},
+		{value: "1.5m", want: 3 << 19},
+		{value: "1g", want: 1 << 30},
+		{value: "1k", want: minRateLimit},
+		{value: "1023", wantErr: true},
+		{value: "0.5k", wantErr: true},
+		{value: "-1k", wantErr: true},
+		{value: "fast", wantErr: true},
+		{value: "10x", wantErr: true},
+		{value: "2000g", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRate(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRate(%q) = %d, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseRate(%q) = %d, %v; want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestThrottleNoLimit(t *testing.T) {
+	r := bytes.NewReader(nil)
+	if got := (uploadOptions{}).throttle(r); got != io.Reader(r) {
+		t.Errorf("throttle without limits wrapped the reader")
+	}
+	if newTokenBucket(0) != nil {
+		t.Errorf("newTokenBucket(0) is not nil")
+	}
+	var none *tokenBucket
+	none.take(1 << 20) // A nil bucket doesn't limit
+}
+
+func TestThrottleRate(t *testing.T) {
+	const size = 1 << 20
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	start := clock.t
+	opts := uploadOptions{LimitRate: 100 << 10}
+	recorder := &maxReadRecorder{r: bytes.NewReader(make([]byte, size))}
+
+	tr := opts.throttle(recorder).(*throttledReader)
+	clock.use(tr.buckets[0])
+	n, err := io.Copy(ioutil.Discard, tr)
+	if err != nil || n != size {
+		t.Fatalf("copied %d bytes, %v", n, err)
+	}
+
+	// The first burst goes out at once; the rest at the rate
+	want := time.Duration(float64(size-tr.buckets[0].burst) / float64(opts.LimitRate) * float64(time.Second))
+	if got := clock.since(start); !within(got, want, 50*time.Millisecond) {
+		t.Errorf("1 MiB at 100 KiB/s took %v, want about %v", got, want)
+	}
+	if recorder.max > int(tr.buckets[0].burst) {
+		t.Errorf("read %d bytes at once, more than the %d byte burst", recorder.max, tr.buckets[0].burst)
+	}
+}
+
+func TestThrottleSharedLimit(t *testing.T) {
+	const size = 512 << 10
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	start := clock.t
+	shared := clock.use(newTokenBucket(100 << 10))
+	opts := uploadOptions{LimitRate: 1 << 20, sharedLimit: shared}
+
+	a := opts.throttle(bytes.NewReader(make([]byte, size))).(*throttledReader)
+	b := opts.throttle(bytes.NewReader(make([]byte, size))).(*throttledReader)
+	clock.use(a.buckets[0])
+	clock.use(b.buckets[0])
+
+	// Two uploads take turns; together they go at the shared rate
+	buf := make([]byte, 32*1024)
+	done := 0
+	for done < 2 {
+		done = 0
+		for _, r := range []io.Reader{a, b} {
+			if _, err := r.Read(buf); err == io.EOF {
+				done++
+			}
+		}
+	}
+
+	want := time.Duration(float64(2*size-shared.burst) / float64(shared.rate) * float64(time.Second))
+	if got := clock.since(start); !within(got, want, 100*time.Millisecond) {
+		t.Errorf("2 x 512 KiB sharing 100 KiB/s took %v, want about %v", got, want)
+	}
+}
+
+func TestThrottleRealClock(t *testing.T) {
+	const size = 40 << 10
+	opts := uploadOptions{LimitRate: 100 << 10}
+	start := time.Now()
+	if _, err := io.Copy(ioutil.Discard, opts.throttle(bytes.NewReader(make([]byte, size)))); err != nil {
+		t.Fatal(err)
+	}
+	// 10 KiB of burst, then 30 KiB at 100 KiB/s
+	if got := time.Since(start); got < 250*time.Millisecond || got > 2*time.Second {
+		t.Errorf("40 KiB at 100 KiB/s took %v, want about 300ms", got)
+	}
+}
+
+func TestThrottledTimeout(t *testing.T) {
+	shared := newTokenBucket(50 << 10)
+	tests := []struct {
+		name string
+		opts uploadOptions
+		n    int64
+		want time.Duration
+	}{
+		{name: "no limit", opts: uploadOptions{Timeout: time.Minute}, n: 1 << 20, want: time.Minute},
+		{name: "own limit", opts: uploadOptions{Timeout: time.Minute, LimitRate: 100 << 10}, n: 1 << 20, want: time.Minute + 10*time.Second + 240*time.Millisecond},
+		{name: "slower shared limit", opts: uploadOptions{Timeout: time.Minute, LimitRate: 100 << 10, sharedLimit: shared}, n: 1 << 20, want: time.Minute + 20*time.Second + 480*time.Millisecond},
+		{name: "only shared limit", opts: uploadOptions{Timeout: time.Minute, sharedLimit: shared}, n: 50 << 10, want: time.Minute + time.Second},
+		{name: "empty body", opts: uploadOptions{Timeout: time.Minute, LimitRate: 100 << 10}, n: 0, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := tt.opts.throttledTimeout(tt.n); !within(got, tt.want, 10*time.Millisecond) {
+			t.Errorf("%s: throttledTimeout(%d) = %v, want %v", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
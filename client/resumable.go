@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultChunkSize is the chunk size asked for by --resumable. Smaller chunks
+// lose less to a dropped connection but cost a request each.
+const defaultChunkSize = 8 * 1024 * 1024
+
+// resumableState remembers a chunked upload in progress so a later run can
+// continue it. It is kept per file and server, and only used while the file
+// still has the size and modification time it had when the upload started.
+type resumableState struct {
+	Server   string    `json:"server"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	SHA256   string    `json:"sha256"`
+	UploadID string    `json:"upload_id"`
+}
+
+// resumableStatus is the server's description of a chunked upload
+type resumableStatus struct {
+	Success   bool   `json:"success"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	UploadID  string `json:"upload_id"`
+	ChunkSize int64  `json:"chunk_size"`
+	NextIndex int    `json:"next_index"`
+	Received  int64  `json:"received"`
+}
+
+// uploadResumable uploads a file in chunks over several requests, each retried
+// on its own, so a bad link costs at most a chunk. The upload ID is stored
+// locally; running the same upload again after a failure or an interruption
+// picks up after the last chunk the server has.
+func uploadResumable(filePath string, opts uploadOptions) Result {
+	startTime := time.Now()
+	result := Result{Server: opts.Server, Status: "failed", Attempts: 1, localName: filepath.Base(filePath)}
+	uploadChunks(&result, filePath, opts)
+	result.Time = time.Since(startTime).Milliseconds()
+	return result
+}
+
+// uploadChunks runs a resumable upload into result
+func uploadChunks(result *Result, filePath string, opts uploadOptions) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to access file: %v", err)
+		return
+	}
+	if info.IsDir() {
+		result.Error = "path is a directory, not a file"
+		return
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to get absolute path: %v", err)
+		return
+	}
+	file, err := os.Open(absPath)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to open file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	server := strings.TrimRight(opts.Server, "/")
+	statePath := resumableStatePath(server, absPath)
+	state := loadResumableState(statePath)
+	if state.Server != server || state.Path != absPath || state.Size != info.Size() || !state.ModTime.Equal(info.ModTime()) {
+		state = resumableState{Server: server, Path: absPath, Size: info.Size(), ModTime: info.ModTime()}
+	}
+
+	// Find out where the server is, or start over
+	var status resumableStatus
+	if state.UploadID != "" {
+		code := resumableCall(result, http.MethodGet, server+"/upload/"+state.UploadID, nil, nil, opts, &status)
+		if code == http.StatusNotFound {
+			// Expired, abandoned, or finished by a run that lost the response
+			state.UploadID = ""
+		} else if result.Error != "" {
+			return
+		}
+	}
+	if state.UploadID == "" {
+		if state.SHA256 == "" {
+			hasher := sha256.New()
+			if _, err := io.Copy(hasher, file); err != nil {
+				result.Error = fmt.Sprintf("failed to read file: %v", err)
+				return
+			}
+			state.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+		}
+		init, _ := json.Marshal(map[string]interface{}{
+			"name": filepath.Base(absPath), "size": info.Size(), "sha256": state.SHA256,
+			"chunk_size": defaultChunkSize, "ttl": opts.TTL, "slug": opts.Slug, "tag": opts.Tag,
+		})
+		resumableCall(result, http.MethodPost, server+"/upload/init", init, nil, opts, &status)
+		if result.Error != "" {
+			return
+		}
+		state.UploadID = status.UploadID
+		if err := saveResumableState(statePath, state); err != nil {
+			result.Error = err.Error()
+			return
+		}
+	} else if !opts.Quiet {
+		stderrMu.Lock()
+		fmt.Fprintf(os.Stderr, "%s: resuming at %s of %s\n", result.localName, formatBytes(status.Received), formatBytes(info.Size()))
+		stderrMu.Unlock()
+	}
+
+	// Send the chunks the server doesn't have yet
+	start := time.Now()
+	sent := int64(0)
+	p := newProgress(result.localName, info.Size(), opts.Quiet, opts.Terminal)
+	p.resume(status.Received)
+	chunk := make([]byte, status.ChunkSize)
+	for offset := status.Received; offset < info.Size(); {
+		n, err := file.ReadAt(chunk, offset)
+		if err != nil && err != io.EOF {
+			p.finish()
+			result.Error = fmt.Sprintf("failed to read file: %v", err)
+			return
+		}
+		sum := sha256.Sum256(chunk[:n])
+		header := http.Header{"X-Chunk-SHA256": {hex.EncodeToString(sum[:])}}
+		chunkURL := fmt.Sprintf("%s/upload/chunk/%s?index=%d", server, state.UploadID, status.NextIndex)
+		code := resumableCall(result, http.MethodPut, chunkURL, chunk[:n], header, opts, &status)
+		if code == http.StatusConflict {
+			// Out of step with the server, which knows best where to go on
+			code = resumableCall(result, http.MethodGet, server+"/upload/"+state.UploadID, nil, nil, opts, &status)
+		}
+		if result.Error != "" {
+			p.finish()
+			if code == 0 || retryableStatus(code) {
+				result.Error += "; run the same upload again to resume it"
+			}
+			return
+		}
+		p.add(int(status.Received - offset))
+		sent += status.Received - offset
+		offset = status.Received
+	}
+	p.finish()
+
+	// The server checks the whole file against the hash sent at init
+	code := resumableCall(result, http.MethodPost, server+"/upload/complete/"+state.UploadID, nil, nil, opts, nil)
+	if result.Error != "" {
+		if code == http.StatusBadRequest && result.Code == codeHashMismatch {
+			// The server dropped the upload; the next run starts a fresh one
+			os.Remove(statePath)
+		}
+		return
+	}
+	os.Remove(statePath)
+	result.Size = info.Size()
+	result.Throughput = bytesPerSecond(sent, time.Since(start))
+	if !opts.NoVerify && result.ServerHash != "" {
+		result.Hash = state.SHA256
+		verifyUpload(result, opts)
+	}
+}
+
+// resumableCall makes one request of a resumable upload, retrying transient
+// failures, and decodes the JSON reply into out. The completing request has no
+// out: its reply is that of a regular upload and fills in result. Failures set
+// result.Error; the response status is returned, or 0 when none arrived.
+func resumableCall(result *Result, method, requestURL string, body []byte, header http.Header, opts uploadOptions, out *resumableStatus) int {
+	name := result.localName
+	for attempt := 1; ; attempt++ {
+		result.Error, result.Code, result.exitCode = "", "", 0
+		status, hint := resumableAttempt(result, method, requestURL, body, header, opts, out)
+		if result.Error == "" || !hint.retryable || attempt > opts.Retries {
+			return status
+		}
+		delay, ok := retryDelay(attempt, hint)
+		if !ok {
+			return status
+		}
+		result.Attempts++
+		announceRetry(name, *result, attempt+1, opts.Retries+1, delay, opts.Quiet)
+		time.Sleep(delay)
+	}
+}
+
+// resumableAttempt makes a single request of a resumable upload
+func resumableAttempt(result *Result, method, requestURL string, body []byte, header http.Header, opts uploadOptions, out *resumableStatus) (int, retryHint) {
+	var content io.Reader = bytes.NewReader(body)
+	if method == http.MethodPut {
+		content = opts.throttle(content)
+	}
+	req, err := http.NewRequest(method, requestURL, content)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create request: %v", err)
+		return 0, retryHint{}
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if method == http.MethodPost && body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	} else if method == http.MethodPut {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	req.Header.Set("X-API-Key", opts.Auth)
+	req.Header.Set("Accept", "application/json")
+
+	req.ContentLength = int64(len(body))
+	resp, err := newHTTPClient(opts.throttledTimeout(int64(len(body)))).Do(req)
+	if err != nil {
+		result.Error = fmt.Sprintf("upload failed: %v", err)
+		return 0, retryHint{retryable: !isCertificateError(err)}
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return resp.StatusCode, readUploadResponse(result, resp)
+	}
+
+	hint := retryHint{}
+	if retryableStatus(resp.StatusCode) {
+		hint = retryHint{retryable: true, wait: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read response: %v", err)
+		return resp.StatusCode, retryHint{retryable: true}
+	}
+	var parsed resumableStatus
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		result.Error = fmt.Sprintf("server error (%d): %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		result.exitCode = exitCodeForStatus(resp.StatusCode)
+		return resp.StatusCode, hint
+	}
+	if resp.StatusCode != http.StatusOK || !parsed.Success {
+		result.Error = fmt.Sprintf("server error (%d): %s", resp.StatusCode, parsed.Message)
+		result.Code = parsed.Code
+		result.exitCode = exitCodeForStatus(resp.StatusCode)
+		if parsed.Code == codeHashMismatch {
+			// The chunk was damaged on the way; sending it again fixes that
+			hint.retryable = true
+		}
+		return resp.StatusCode, hint
+	}
+	*out = parsed
+	return resp.StatusCode, hint
+}
+
+// resumableStatePath returns where the state of an upload of absPath to
+// server is kept: the user's cache directory, or the system's temporary one
+func resumableStatePath(server, absPath string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	key := sha256.Sum256([]byte(server + "\n" + absPath))
+	return filepath.Join(dir, "http-cli", "uploads", hex.EncodeToString(key[:16])+".json")
+}
+
+// loadResumableState reads the state of an upload, empty when there is none
+func loadResumableState(path string) resumableState {
+	var state resumableState
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+// saveResumableState writes the state of an upload through a temporary file
+func saveResumableState(path string, state resumableState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to save upload state: %v", err)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + "." + strconv.Itoa(os.Getpid()) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save upload state: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save upload state: %v", err)
+	}
+	return nil
+}
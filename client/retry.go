@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Upload retries back off exponentially from retryBaseDelay up to
+// retryMaxDelay. A server asking for a longer Retry-After than retryMaxWait
+// (quota resets, maintenance) fails the upload instead of stalling it.
+const (
+	defaultRetries = 2
+	defaultTimeout = 5 * time.Minute
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+	retryMaxWait   = time.Minute
+)
+
+// retryHint is what a failed upload attempt tells the retry loop
+type retryHint struct {
+	retryable bool          // The failure may be transient: network error, 5xx or 429
+	wait      time.Duration // The server's Retry-After; 0 when not given
+}
+
+// retryableStatus reports whether a failed response is worth repeating.
+// Other 4xx responses reject the upload itself and would fail again.
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// parseRetryAfter reads a Retry-After header: delay seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// retryDelay returns how long to wait before retrying after the given
+// attempt (1 for the first), and false when the wait is too long to be worth
+// it. The server's Retry-After wins; otherwise the delay doubles each attempt,
+// with jitter so several clients don't come back in step.
+func retryDelay(attempt int, hint retryHint) (time.Duration, bool) {
+	if hint.wait > 0 {
+		return hint.wait, hint.wait <= retryMaxWait
+	}
+	delay := retryMaxDelay
+	if attempt < 6 {
+		delay = retryBaseDelay << uint(attempt-1)
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true
+}
+
+// announceRetry notes a retry on stderr, where progress goes. A zero delay
+// retries straight away.
+func announceRetry(name string, result Result, attempt, attempts int, delay time.Duration, quiet bool) {
+	if quiet {
+		return
+	}
+	when := "now"
+	if delay > 0 {
+		when = "in " + delay.Round(100*time.Millisecond).String()
+	}
+	stderrMu.Lock()
+	fmt.Fprintf(os.Stderr, "%s: %s; retrying %s (attempt %d of %d)\n", name, result.Error, when, attempt, attempts)
+	stderrMu.Unlock()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// transportOptions are the connection settings shared by every command
+type transportOptions struct {
+	Proxy      string // Proxy URL; HTTPS_PROXY/HTTP_PROXY/NO_PROXY apply when empty
+	CACert     string // PEM file of extra CAs to trust
+	Insecure   bool   // Skip server certificate verification
+	ClientCert string // PEM client certificate for mutual TLS
+	ClientKey  string // PEM key of ClientCert
+}
+
+// httpTransport carries every request the client makes
+var httpTransport http.RoundTripper = http.DefaultTransport
+
+// newHTTPClient returns a client over httpTransport with a time limit
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: httpTransport, Timeout: timeout}
+}
+
+// newTransport builds the transport for the connection settings
+func newTransport(opts transportOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", opts.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", opts.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, fmt.Errorf("--client-cert and --client-key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if opts.Insecure {
+		stderrMu.Lock()
+		fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is disabled (insecure); the server's identity is not checked")
+		stderrMu.Unlock()
+	}
+	return transport, nil
+}
+
+// isCertificateError reports whether a request failed on the server's
+// certificate, which retrying won't change
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
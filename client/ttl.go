@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTLs follow the server's syntax: a plain number of hours, a number with an
+// m, h, d or w suffix, or "never"/"0" for files kept until deleted
+const (
+	ttlFormats     = `hours (24), a duration like 45m, 36h, 14d or 2w, or "never"`
+	maxTTLDuration = 100 * 365 * 24 * time.Hour
+)
+
+var ttlUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// clockSkewTolerance is how far the server's clock may be from ours before
+// the expiry shown gets a note
+const clockSkewTolerance = time.Minute
+
+// parseTTL checks a TTL and returns its length, 0 for "never"
+func parseTTL(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "never" || value == "0" {
+		return 0, nil
+	}
+	number, unit := value, time.Hour
+	if len(value) > 0 {
+		if u, ok := ttlUnits[value[len(value)-1:]]; ok {
+			number, unit = value[:len(value)-1], u
+		}
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > int(maxTTLDuration/unit) {
+		return 0, fmt.Errorf("invalid TTL %q: use %s", value, ttlFormats)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// serverCapabilities is the /api/capabilities response
+type serverCapabilities struct {
+	MaxTTL         string `json:"max_ttl"`
+	MaxTTLSeconds  int64  `json:"max_ttl_seconds"`
+	AllowPermanent bool   `json:"allow_permanent"`
+}
+
+// checkTTL validates a TTL, then against the server's limits when it
+// publishes them. Servers without /api/capabilities are left to reject a TTL
+// themselves.
+func checkTTL(ttl, server string) error {
+	d, err := parseTTL(ttl)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(server, "/")+"/api/capabilities", nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := newHTTPClient(10 * time.Second).Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	var caps serverCapabilities
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&caps) != nil {
+		return nil
+	}
+
+	if d == 0 && !caps.AllowPermanent {
+		return fmt.Errorf("TTL %q: the server doesn't allow files that never expire", ttl)
+	}
+	if max := time.Duration(caps.MaxTTLSeconds) * time.Second; max > 0 && d > max {
+		return fmt.Errorf("TTL %q is too long: the server allows at most %s (%dh)", ttl, caps.MaxTTL, max/time.Hour)
+	}
+	return nil
+}
+
+// describeExpiry fills in how long until an upload expires, by our clock.
+// serverDate is the response's Date header; when the server's clock is off
+// from ours by more than clockSkewTolerance the result says so, since the
+// server expires the file by its own clock.
+func describeExpiry(result *Result, serverDate string) {
+	if result.ExpiresAt == "" {
+		return
+	}
+	expiresAt, err := time.Parse(time.RFC3339, result.ExpiresAt)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	result.ExpiresIn = humanDuration(expiresAt.Sub(now))
+	if date, err := http.ParseTime(serverDate); err == nil {
+		skew := date.Sub(now)
+		if skew > clockSkewTolerance || skew < -clockSkewTolerance {
+			direction := "ahead of"
+			if skew < 0 {
+				direction, skew = "behind", -skew
+			}
+			result.Note = fmt.Sprintf("the server's clock is %s %s this machine's; expires_in is by this machine's clock", humanDuration(skew.Round(time.Minute)), direction)
+		}
+	}
+}
+
+// humanDuration renders a duration in its two largest units, like 23h59m or
+// 6d23h
+func humanDuration(d time.Duration) string {
+	if d <= 0 {
+		return "0m"
+	}
+	d = d.Round(time.Second)
+	days, hours, minutes := d/(24*time.Hour), d%(24*time.Hour)/time.Hour, d%time.Hour/time.Minute
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
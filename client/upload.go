@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stdinPath is the path argument that uploads standard input
+const stdinPath = "-"
+
+// defaultStdinName names standard input uploads when --filename isn't given;
+// the server takes the extension from it
+const defaultStdinName = "stdin.bin"
+
+// uploadOptions are the settings shared by every file of an upload
+type uploadOptions struct {
+	Server    string
+	Auth      string
+	TTL       string
+	Slug      string
+	Tag       string
+	Filename  string        // Name sent for standard input
+	Quiet     bool          // No progress output
+	Terminal  bool          // Redraw progress in place (stderr is a terminal of its own)
+	Retries   int           // Extra attempts after a transient failure
+	Timeout   time.Duration // Limit for each attempt
+	NoVerify  bool          // Skip comparing the server's sha256 with the content sent
+	Resumable bool          // Send files in chunks over several requests
+	Dedupe    bool          // Ask the server for the content before sending it
+	LimitRate int64         // Bytes per second for each file, 0 for no limit
+
+	sharedLimit    *tokenBucket // Rate shared by every upload (--limit-rate-total)
+	idempotencyKey string       // Names one file's upload so the server stores it only once
+}
+
+// newIdempotencyKey returns a random Idempotency-Key for one file's upload
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// codeHashMismatch is the result code of an upload whose content arrived
+// corrupted, as in the server's errors
+const codeHashMismatch = "hash_mismatch"
+
+// uploadFile uploads a file to the server, or standard input for "-".
+// With opts.Dedupe a file whose content the server has isn't sent at all; with
+// opts.Resumable a file goes through uploadResumable instead.
+// Transient failures are retried up to opts.Retries times, and a file that
+// arrived corrupted is sent once more on top of those; the result keeps the
+// last attempt and counts them all.
+func uploadFile(filePath string, opts uploadOptions) Result {
+	if opts.Dedupe && filePath != stdinPath {
+		if result, ok := dedupeUpload(filePath, opts); ok {
+			return result
+		}
+	}
+	if opts.Resumable && filePath != stdinPath {
+		return uploadResumable(filePath, opts)
+	}
+	startTime := time.Now()
+	var result Result
+	resent := false
+	opts.idempotencyKey = newIdempotencyKey()
+	for attempt := 1; ; attempt++ {
+		result = Result{
+			Server: opts.Server,
+			Status: "failed",
+		}
+		var hint retryHint
+		if filePath == stdinPath {
+			uploadStdin(&result, opts)
+		} else {
+			hint = uploadPath(&result, filePath, opts)
+		}
+		result.Attempts = attempt
+		result.localName = filepath.Base(filePath)
+		if filePath == stdinPath {
+			result.localName = opts.Filename
+		}
+		if result.Code == codeHashMismatch && !resent && filePath != stdinPath {
+			resent = true
+			// The corrupted copy was stored under the key; sending it again is a new upload
+			opts.idempotencyKey = newIdempotencyKey()
+			announceRetry(filepath.Base(filePath), result, attempt+1, attempt+1, 0, opts.Quiet)
+			continue
+		}
+		if result.Status == "success" || !hint.retryable || attempt > opts.Retries {
+			break
+		}
+		delay, ok := retryDelay(attempt, hint)
+		if !ok {
+			break
+		}
+		announceRetry(filepath.Base(filePath), result, attempt+1, opts.Retries+1, delay, opts.Quiet)
+		time.Sleep(delay)
+	}
+	result.Time = time.Since(startTime).Milliseconds()
+	return result
+}
+
+// uploadPath makes one attempt at uploading a file from disk
+func uploadPath(result *Result, filePath string, opts uploadOptions) retryHint {
+	// Get file info
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to access file: %v", err)
+		return retryHint{}
+	}
+
+	if fileInfo.IsDir() {
+		result.Error = "path is a directory, not a file"
+		return retryHint{}
+	}
+
+	// Get absolute path
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to get absolute path: %v", err)
+		return retryHint{}
+	}
+
+	// Get filename
+	filename := filepath.Base(absPath)
+
+	// Open file
+	file, err := os.Open(absPath)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to open file: %v", err)
+		return retryHint{}
+	}
+	defer file.Close()
+
+	return streamUpload(result, filename, file, fileInfo.Size(), opts)
+}
+
+// uploadStdin streams standard input to the server. Its length isn't known
+// up front, so the reported size is the number of bytes that went out. What
+// was read is gone, so a failed stdin upload is never retried.
+func uploadStdin(result *Result, opts uploadOptions) {
+	filename := opts.Filename
+	if filename == "" {
+		filename = defaultStdinName
+	}
+	streamUpload(result, filename, os.Stdin, -1, opts)
+}
+
+// streamUpload sends content as the file of a multipart upload without
+// holding it in memory: the body is written through a pipe as the request
+// reads it. size is the content length, or -1 when unknown; a known size
+// lets the request carry a Content-Length.
+//
+// A network error is only reported as retryable while the body was still
+// going out: once all of it was sent the server may have stored the file,
+// and a second attempt would upload it twice.
+//
+// The content is hashed as it is sent and compared with the sha256 the
+// server returns, unless opts.NoVerify is set.
+func streamUpload(result *Result, filename string, content io.Reader, size int64, opts uploadOptions) retryHint {
+	start := time.Now()
+	total := size
+	if total < 0 {
+		total = 0
+	}
+	p := newProgress(filename, total, opts.Quiet, opts.Terminal)
+	hasher := sha256.New()
+	if !opts.NoVerify {
+		content = io.TeeReader(content, hasher)
+	}
+	counter := &countingReader{r: &progressReader{r: opts.throttle(content), p: p}}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	contentLength := int64(-1)
+	if size >= 0 {
+		if overhead, err := formOverhead(writer.Boundary(), filename, opts); err == nil {
+			contentLength = overhead + size
+		}
+	}
+	if contentLength >= 0 {
+		opts.Timeout = opts.throttledTimeout(contentLength)
+	}
+	formErr := make(chan error, 1)
+	go func() {
+		err := writeUploadForm(writer, filename, counter, opts)
+		pw.CloseWithError(err)
+		formErr <- err
+	}()
+
+	hint, responded := sendUpload(result, pr, contentLength, writer.FormDataContentType(), opts)
+	pr.Close()
+	p.finish()
+	if !responded && size >= 0 && <-formErr == nil {
+		hint.retryable = false
+	}
+
+	result.Size = counter.n
+	if result.Status == "success" {
+		result.Throughput = bytesPerSecond(counter.n, time.Since(start))
+		if !opts.NoVerify && result.ServerHash != "" {
+			result.Hash = hex.EncodeToString(hasher.Sum(nil))
+			verifyUpload(result, opts)
+		}
+	}
+	return hint
+}
+
+// verifyUpload fails a result whose server hash differs from the local one.
+// The corrupted copy is deleted when the API key may do so.
+func verifyUpload(result *Result, opts uploadOptions) {
+	if strings.EqualFold(result.Hash, result.ServerHash) {
+		return
+	}
+	result.Status = "failed"
+	result.Code = codeHashMismatch
+	result.Message = ""
+	result.ExpiresAt, result.ExpiresIn, result.Note = "", "", ""
+	result.Error = "the server's sha256 doesn't match the content sent; the file was corrupted in transit"
+
+	server := strings.TrimRight(opts.Server, "/")
+	var scratch Result
+	if _, err := apiRequest(&scratch, http.MethodDelete, fileURL(server, result.Path), opts.Auth); err != nil {
+		result.Error += fmt.Sprintf(" (the corrupted copy at %s could not be deleted: %v)", result.Path, err)
+	}
+}
+
+// formOverhead returns the length of the upload form minus the file content,
+// by writing it with the same boundary and an empty file
+func formOverhead(boundary, filename string, opts uploadOptions) (int64, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+	if err := writeUploadForm(writer, filename, strings.NewReader(""), opts); err != nil {
+		return 0, err
+	}
+	return int64(buf.Len()), nil
+}
+
+// writeUploadForm writes the file part and the upload fields, then closes the
+// multipart writer
+func writeUploadForm(writer *multipart.Writer, filename string, content io.Reader, opts uploadOptions) error {
+	// Create form file
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %v", err)
+	}
+
+	// Copy file content
+	if _, err := io.Copy(part, content); err != nil {
+		return fmt.Errorf("failed to copy file content: %v", err)
+	}
+
+	// Add TTL field
+	writer.WriteField("ttl", opts.TTL)
+	writer.WriteField("filename", filename)
+	if opts.Slug != "" {
+		writer.WriteField("slug", opts.Slug)
+	}
+	if opts.Tag != "" {
+		writer.WriteField("tag", opts.Tag)
+	}
+
+	// Close multipart writer
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart writer: %v", err)
+	}
+	return nil
+}
+
+// sendUpload posts a multipart body of contentLength bytes (-1 when unknown)
+// to the server and fills in result from the response. It reports whether a
+// failure may be retried, and whether the server answered at all.
+func sendUpload(result *Result, body io.Reader, contentLength int64, contentType string, opts uploadOptions) (retryHint, bool) {
+	// Create request
+	serverURL := strings.TrimRight(opts.Server, "/")
+	url := serverURL + "/upload"
+
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create request: %v", err)
+		return retryHint{}, false
+	}
+	req.ContentLength = contentLength
+
+	// Set headers
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-API-Key", opts.Auth)
+	if opts.idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", opts.idempotencyKey)
+	}
+
+	// Execute request
+	client := newHTTPClient(opts.Timeout)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = fmt.Sprintf("upload failed: %v", err)
+		return retryHint{retryable: !isCertificateError(err)}, false
+	}
+	defer resp.Body.Close()
+	return readUploadResponse(result, resp), true
+}
+
+// uploadStored reports whether an upload's status means the file was stored:
+// 201 Created, or 200 from servers before that
+func uploadStored(status int) bool {
+	return status == http.StatusCreated || status == http.StatusOK
+}
+
+// readUploadResponse fills in result from the response to an upload, and
+// reports whether a failure may be retried
+func readUploadResponse(result *Result, resp *http.Response) retryHint {
+	// Failed responses may be retried; a 2xx whose body is lost isn't, since
+	// the file was stored
+	hint := retryHint{}
+	if retryableStatus(resp.StatusCode) {
+		hint = retryHint{retryable: true, wait: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	// Read response
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read response: %v", err)
+		return hint
+	}
+
+	// Parse response
+	var serverResult struct {
+		Success   bool   `json:"success"`
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		FilePath  string `json:"file_path"`
+		ExpiresAt string `json:"expires_at"`
+		SHA256    string `json:"sha256"`
+	}
+
+	if err := json.Unmarshal(respBody, &serverResult); err != nil {
+		if !uploadStored(resp.StatusCode) {
+			// Proxies answer errors with HTML
+			result.Error = fmt.Sprintf("server error (%d): %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+			result.exitCode = exitCodeForStatus(resp.StatusCode)
+			return hint
+		}
+		result.Error = fmt.Sprintf("failed to parse response: %v", err)
+		return hint
+	}
+
+	// Check response
+	if !uploadStored(resp.StatusCode) {
+		result.Error = fmt.Sprintf("server error (%d): %s", resp.StatusCode, serverResult.Message)
+		result.Code = serverResult.Code
+		result.exitCode = exitCodeForStatus(resp.StatusCode)
+		return hint
+	}
+
+	if !serverResult.Success {
+		result.Error = fmt.Sprintf("upload failed: %s", serverResult.Message)
+		result.Code = serverResult.Code
+		return hint
+	}
+
+	// Success
+	result.Status = "success"
+	result.Path = serverResult.FilePath
+	result.ServerHash = serverResult.SHA256
+	result.ExpiresAt = serverResult.ExpiresAt
+	describeExpiry(result, resp.Header.Get("Date"))
+	result.Message = serverResult.Message
+	if serverResult.ExpiresAt != "" {
+		result.Message = fmt.Sprintf("%s (expires at: %s)", result.Message, serverResult.ExpiresAt)
+	}
+	return hint
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// manifestName is the default manifest of upload-dir, inside the directory
+const manifestName = ".http-cli-manifest.json"
+
+// uploadDirOptions are the settings of upload-dir
+type uploadDirOptions struct {
+	Recursive    bool
+	Include      []string // Globs a file must match, if any are given
+	Exclude      []string // Globs of files to leave out
+	Manifest     string   // Defaults to manifestName inside the directory
+	Resume       bool     // Skip files the manifest already has
+	Workers      int
+	NDJSON       bool // Print each file's result as it finishes
+	UploadConfig uploadOptions
+}
+
+// manifestEntry records one uploaded file
+type manifestEntry struct {
+	URL        string    `json:"url"`
+	Path       string    `json:"path"`
+	SHA256     string    `json:"sha256,omitempty"`
+	Size       int64     `json:"size"`
+	ExpiresAt  string    `json:"expires_at,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// manifest maps the paths of uploaded files, relative to the directory and
+// with forward slashes, to where they went
+type manifest struct {
+	Server    string                   `json:"server"`
+	Directory string                   `json:"directory"`
+	Files     map[string]manifestEntry `json:"files"`
+
+	mu   sync.Mutex
+	path string
+}
+
+// uploadDirectory uploads the files of dir with a pool of workers and returns
+// the summary, which names the manifest written. The manifest is rewritten
+// after every upload, and on Ctrl+C before exiting, so --resume can carry on
+// from wherever a run stopped.
+func uploadDirectory(dir string, opts uploadDirOptions) Result {
+	result := Result{Server: opts.UploadConfig.Server, Status: "failed", Path: dir}
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		result.Error = fmt.Sprintf("%s is not a directory", dir)
+		result.exitCode = exitUsage
+		return result
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if opts.Manifest == "" {
+		opts.Manifest = filepath.Join(absDir, manifestName)
+	}
+	manifestPath, _ := filepath.Abs(opts.Manifest)
+
+	m := &manifest{Server: opts.UploadConfig.Server, Directory: absDir, Files: map[string]manifestEntry{}, path: manifestPath}
+	if opts.Resume {
+		if err := m.load(); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	files, err := collectFiles(absDir, manifestPath, opts)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read %s: %v", dir, err)
+		return result
+	}
+
+	// Files the manifest has, unchanged and not expired, are done already
+	var pending []string
+	skipped := 0
+	for _, rel := range files {
+		if opts.Resume && m.has(absDir, rel) {
+			skipped++
+			continue
+		}
+		pending = append(pending, rel)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		if _, ok := <-stop; !ok {
+			return
+		}
+		m.mu.Lock()
+		err := m.save()
+		m.mu.Unlock()
+		interrupted := Result{Status: "failed", Server: result.Server, Path: dir, Output: manifestPath, exitCode: exitFailed}
+		interrupted.Error = "interrupted; run again with --resume to upload the rest"
+		if err != nil {
+			interrupted.Error += " (the manifest could not be saved: " + err.Error() + ")"
+		}
+		exitWith(interrupted)
+	}()
+
+	paths := make([]string, len(pending))
+	for i, rel := range pending {
+		paths[i] = filepath.Join(absDir, filepath.FromSlash(rel))
+	}
+	summary := uploadFiles(paths, opts.Workers, opts.NDJSON, func(filePath string) Result {
+		upload := uploadFile(filePath, opts.UploadConfig)
+		if upload.Status == "success" {
+			rel, _ := filepath.Rel(absDir, filePath)
+			m.record(filepath.ToSlash(rel), upload)
+		}
+		return upload
+	})
+	m.mu.Lock()
+	err = m.save()
+	m.mu.Unlock()
+	if err != nil && summary.Status == "success" {
+		summary.Status = "failed"
+		summary.Error = err.Error()
+	}
+
+	summary.Path = dir
+	summary.Output = manifestPath
+	if summary.Server == "" {
+		summary.Server = result.Server
+	}
+	if opts.Resume {
+		summary.Message += fmt.Sprintf(", %d already uploaded", skipped)
+	}
+	if len(pending) == 0 {
+		summary.Message = fmt.Sprintf("nothing to upload, %d already uploaded", skipped)
+	}
+	return summary
+}
+
+// collectFiles lists the files to upload, relative to dir with forward
+// slashes. Hidden files and directories, and the manifest, are left out.
+func collectFiles(dir, manifestPath string, opts uploadDirOptions) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		hidden := strings.HasPrefix(entry.Name(), ".")
+		if entry.IsDir() {
+			if hidden || !opts.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if hidden || !entry.Type().IsRegular() || p == manifestPath {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if len(opts.Include) > 0 && !matchesAny(opts.Include, rel) {
+			return nil
+		}
+		if matchesAny(opts.Exclude, rel) {
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+// matchesAny reports whether a relative path matches one of the globs. A
+// glob without a slash is matched against the file name, so *.jpg matches in
+// every subdirectory; one with a slash against the whole path.
+func matchesAny(globs []string, rel string) bool {
+	for _, glob := range globs {
+		target := rel
+		if !strings.Contains(glob, "/") {
+			target = path.Base(rel)
+		}
+		if ok, _ := path.Match(glob, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGlobs splits a comma-separated --include or --exclude list
+func parseGlobs(list string) []string {
+	var globs []string
+	for _, glob := range strings.Split(list, ",") {
+		if glob = strings.TrimSpace(glob); glob != "" {
+			globs = append(globs, filepath.ToSlash(glob))
+		}
+	}
+	return globs
+}
+
+// load reads the manifest, if there is one yet
+func (m *manifest) load() error {
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return fmt.Errorf("invalid manifest %s: %v", m.path, err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]manifestEntry{}
+	}
+	return nil
+}
+
+// has reports whether the manifest holds rel as it is now on disk: same size
+// and hash, and not expired on the server yet
+func (m *manifest) has(dir, rel string) bool {
+	entry, ok := m.Files[rel]
+	if !ok {
+		return false
+	}
+	if entry.ExpiresAt != "" {
+		if expiresAt, err := time.Parse(time.RFC3339, entry.ExpiresAt); err != nil || !expiresAt.After(time.Now()) {
+			return false
+		}
+	}
+	local := filepath.Join(dir, filepath.FromSlash(rel))
+	info, err := os.Stat(local)
+	if err != nil || info.Size() != entry.Size {
+		return false
+	}
+	if entry.SHA256 == "" {
+		return true
+	}
+	file, err := os.Open(local)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return false
+	}
+	return strings.EqualFold(hex.EncodeToString(hasher.Sum(nil)), entry.SHA256)
+}
+
+// record adds a successful upload and saves the manifest
+func (m *manifest) record(rel string, upload Result) {
+	hash := upload.Hash
+	if hash == "" {
+		hash = upload.ServerHash
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Files[rel] = manifestEntry{
+		URL:        fileURL(strings.TrimRight(upload.Server, "/"), upload.Path),
+		Path:       upload.Path,
+		SHA256:     hash,
+		Size:       upload.Size,
+		ExpiresAt:  upload.ExpiresAt,
+		UploadedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := m.save(); err != nil {
+		warn(err.Error())
+	}
+}
+
+// save writes the manifest through a temporary file; callers hold mu
+func (m *manifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
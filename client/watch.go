@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Watch mode polls a directory. A file is uploaded once its size and
+// modification time held for watchStableScans scans, so files still being
+// written are left alone; one that keeps failing is given up on after
+// watchMaxFailures attempts until it changes.
+const (
+	defaultWatchInterval = 2 * time.Second
+	watchStableScans     = 2
+	watchMaxFailures     = 3
+	watchStateName       = ".http-cli-watch.json"
+)
+
+// watchOptions are the settings of watch mode
+type watchOptions struct {
+	Interval     time.Duration
+	Extensions   []string // Lowercase, without the dot; empty for all files
+	DeleteAfter  bool     // Remove local files once uploaded
+	StateFile    string   // Defaults to watchStateName inside the directory
+	UploadConfig uploadOptions
+}
+
+// watchedFile is a file as last seen by a scan, or as recorded in the state
+type watchedFile struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Path     string    `json:"path,omitempty"`   // Server path once uploaded
+	Failed   bool      `json:"failed,omitempty"` // Given up on after repeated failures
+	failures int       // Failed uploads of this version
+	scans    int       // Scans the file has looked the same
+}
+
+// same reports whether two sightings are the same version of a file
+func (f watchedFile) same(other watchedFile) bool {
+	return f.Size == other.Size && f.ModTime.Equal(other.ModTime)
+}
+
+// watchState is the state file: the files already handled, by name
+type watchState struct {
+	Files map[string]watchedFile `json:"files"`
+}
+
+// watchDirectory uploads new files appearing in dir until interrupted. Files
+// present when the directory is first watched are only recorded; after that
+// the state file remembers what was handled, so a restart uploads just what
+// appeared in the meantime. Each upload prints a JSON line; a summary line
+// is printed on exit.
+func watchDirectory(dir string, opts watchOptions) Result {
+	result := Result{Server: opts.UploadConfig.Server, Status: "failed", Path: dir}
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		result.Error = fmt.Sprintf("%s is not a directory", dir)
+		result.exitCode = exitUsage
+		return result
+	}
+	if opts.StateFile == "" {
+		opts.StateFile = filepath.Join(dir, watchStateName)
+	}
+
+	state, fresh, err := loadWatchState(opts.StateFile)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if fresh {
+		for name, file := range scanDirectory(dir, opts) {
+			state.Files[name] = file
+		}
+		if err := saveWatchState(opts.StateFile, dir, state); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	uploaded, failed := 0, 0
+	pending := map[string]watchedFile{}
+	for {
+		scan := scanDirectory(dir, opts)
+		for name := range pending {
+			if _, ok := scan[name]; !ok {
+				delete(pending, name)
+			}
+		}
+		for name, seen := range scan {
+			if done, ok := state.Files[name]; ok && done.same(seen) {
+				continue
+			}
+			if prev, ok := pending[name]; ok && prev.same(seen) {
+				seen.scans = prev.scans + 1
+				seen.failures = prev.failures
+			}
+			pending[name] = seen
+		}
+
+		for name, file := range pending {
+			if file.scans < watchStableScans {
+				continue
+			}
+			select {
+			case <-stop:
+				return stopWatching(result, opts.StateFile, dir, state, uploaded, failed)
+			default:
+			}
+
+			filePath := filepath.Join(dir, name)
+			upload := uploadFile(filePath, opts.UploadConfig)
+			upload.Source = filePath
+			printResult(upload)
+			if upload.Status != "success" {
+				failed++
+				file.failures++
+				pending[name] = file
+				if file.failures < watchMaxFailures {
+					continue
+				}
+				file.Failed = true
+			} else {
+				uploaded++
+				file.Path = upload.Path
+				if opts.DeleteAfter {
+					os.Remove(filePath)
+				}
+			}
+			delete(pending, name)
+			state.Files[name] = file
+			if err := saveWatchState(opts.StateFile, dir, state); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+		}
+
+		select {
+		case <-stop:
+			return stopWatching(result, opts.StateFile, dir, state, uploaded, failed)
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopWatching saves the state and summarizes the session
+func stopWatching(result Result, stateFile, dir string, state *watchState, uploaded, failed int) Result {
+	if err := saveWatchState(stateFile, dir, state); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = "success"
+	result.Message = fmt.Sprintf("stopped watching: %d uploaded, %d failed", uploaded, failed)
+	return result
+}
+
+// scanDirectory lists the files of dir that watch mode uploads: regular
+// files, not hidden, not partial downloads, and with an accepted extension.
+// Subdirectories aren't watched.
+func scanDirectory(dir string, opts watchOptions) map[string]watchedFile {
+	files := map[string]watchedFile{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return files
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, partSuffix) {
+			continue
+		}
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+		if len(opts.Extensions) > 0 && !containsString(opts.Extensions, ext) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files[name] = watchedFile{Size: info.Size(), ModTime: info.ModTime()}
+	}
+	return files
+}
+
+// loadWatchState reads the state file; fresh is true when there is none yet
+func loadWatchState(path string) (state *watchState, fresh bool, err error) {
+	state = &watchState{Files: map[string]watchedFile{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read watch state: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, false, fmt.Errorf("invalid watch state file %s: %v", path, err)
+	}
+	if state.Files == nil {
+		state.Files = map[string]watchedFile{}
+	}
+	return state, false, nil
+}
+
+// saveWatchState writes the state file through a temporary file, so an
+// interruption never leaves it half written. Entries of files that are gone
+// from dir are dropped.
+func saveWatchState(path, dir string, state *watchState) error {
+	for name := range state.Files {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			delete(state.Files, name)
+		}
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write watch state: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write watch state: %v", err)
+	}
+	return nil
+}
+
+// parseExtensions splits an --ext list like "png,.JPG" into lowercase
+// extensions without dots
+func parseExtensions(list string) []string {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		if ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")); ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
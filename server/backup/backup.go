@@ -0,0 +1,175 @@
+// Package backup writes and reads export archives: a gzipped tarball holding a
+// database snapshot and, optionally, the Images tree.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"httpserver/server/cleanup"
+	"httpserver/server/db"
+	"httpserver/server/storage"
+)
+
+// Archive entry names
+const (
+	SnapshotName = "metadata.json"
+	FilesDir     = "Images"
+)
+
+// Write creates an archive from a database snapshot (see db.Database.Snapshot).
+// With withFiles set, every stored file is added except in-progress uploads and
+// the regenerable thumbnail cache; files deleted while the export runs are skipped.
+func Write(w io.Writer, snapshot []byte, store storage.Backend, withFiles bool) (int, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    SnapshotName,
+		Mode:    0600,
+		Size:    int64(len(snapshot)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return 0, err
+	}
+	if _, err := tw.Write(snapshot); err != nil {
+		return 0, err
+	}
+
+	files := 0
+	if withFiles {
+		var err error
+		if files, err = addFiles(tw, store); err != nil {
+			return files, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return files, err
+	}
+	return files, gz.Close()
+}
+
+// addFiles adds the stored files to the archive
+func addFiles(tw *tar.Writer, store storage.Backend) (int, error) {
+	files := 0
+	err := store.Walk(func(rel string, info storage.FileInfo) error {
+		if storage.IsReserved(rel, cleanup.UploadTempDir, cleanup.CacheDir) {
+			return nil
+		}
+
+		f, err := store.Get(rel)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    path.Join(FilesDir, rel),
+			Mode:    0644,
+			Size:    info.Size,
+			ModTime: info.ModTime,
+		}); err != nil {
+			return err
+		}
+		// The header fixes the size, so copy exactly that much
+		if _, err := io.CopyN(tw, f, info.Size); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", rel, err)
+		}
+		files++
+		return nil
+	})
+	return files, err
+}
+
+// Reader reads an archive created by Write
+type Reader struct {
+	// Data is the database snapshot from the archive
+	Data *db.DatabaseData
+
+	gz *gzip.Reader
+	tr *tar.Reader
+}
+
+// NewReader opens an archive and reads its database snapshot, which Write always
+// puts first
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzipped archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != SnapshotName {
+		gz.Close()
+		return nil, fmt.Errorf("archive does not start with %s", SnapshotName)
+	}
+	data := &db.DatabaseData{}
+	if err := json.NewDecoder(tr).Decode(data); err != nil {
+		gz.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", SnapshotName, err)
+	}
+	if data.Files == nil {
+		data.Files = make(map[int64]*db.FileMetadata)
+	}
+	if data.Config == nil {
+		data.Config = make(map[string]string)
+	}
+
+	return &Reader{Data: data, gz: gz, tr: tr}, nil
+}
+
+// ExtractFiles restores the archived Images tree into store. Existing files are
+// kept unless overwrite is set. It returns the number of files written.
+func (r *Reader) ExtractFiles(store storage.Backend, overwrite bool) (int, error) {
+	files := 0
+	for {
+		hdr, err := r.tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		rel := strings.TrimPrefix(hdr.Name, FilesDir+"/")
+		if rel == hdr.Name || !isSafePath(rel) {
+			return files, fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+		if !overwrite {
+			if exists, _ := store.Exists(rel); exists {
+				continue
+			}
+		}
+
+		if _, err := store.Put(r.tr, rel); err != nil {
+			return files, fmt.Errorf("failed to restore %s: %w", hdr.Name, err)
+		}
+		files++
+	}
+}
+
+// Close releases the archive
+func (r *Reader) Close() error {
+	return r.gz.Close()
+}
+
+// isSafePath rejects archive paths that would escape the target directory
+func isSafePath(rel string) bool {
+	clean := path.Clean(rel)
+	return clean != "." && !path.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, "../")
+}
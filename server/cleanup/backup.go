@@ -0,0 +1,177 @@
+package cleanup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"httpserver/server/logging"
+)
+
+// backupTimeFormat stamps backup file names, e.g. metadata-20240501T030000.db
+const backupTimeFormat = "20060102T150405"
+
+// BackupInfo describes one metadata backup on disk
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BackupDir returns the directory backups are written to: the configured one, or
+// a backups directory next to the database
+func (cm *CleanupManager) BackupDir() string {
+	if cm.cfg.BackupDir != "" {
+		return cm.cfg.BackupDir
+	}
+	return filepath.Join(filepath.Dir(cm.db.Path()), "backups")
+}
+
+// backupPrefix is the file name prefix shared by all backups of this database
+func (cm *CleanupManager) backupPrefix() string {
+	base := filepath.Base(cm.db.Path())
+	return strings.TrimSuffix(base, filepath.Ext(base)) + "-"
+}
+
+// backupLoop takes a backup every BackupIntervalHours
+func (cm *CleanupManager) backupLoop() {
+	interval := time.Duration(cm.cfg.BackupIntervalHours) * time.Hour
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logging.Info("Metadata backups enabled", logging.Fields{"interval": interval, "keep": cm.cfg.BackupKeepCount, "dir": cm.BackupDir()})
+
+	// Catch up when the last backup (possibly from before a restart) is overdue
+	if _, last, err := cm.Backups(); err == nil && time.Since(last) >= interval {
+		if _, err := cm.Backup(); err != nil {
+			logging.Error("Error backing up database", logging.Fields{"error": err})
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := cm.Backup(); err != nil {
+				logging.Error("Error backing up database", logging.Fields{"error": err})
+			}
+		case <-cm.stopChan:
+			return
+		}
+	}
+}
+
+// Backup writes a snapshot of the database, taken under its read lock, to a
+// timestamped file and prunes backups beyond BackupKeepCount
+func (cm *CleanupManager) Backup() (*BackupInfo, error) {
+	cm.backupMux.Lock()
+	defer cm.backupMux.Unlock()
+
+	snapshot, err := cm.db.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+
+	dir := cm.BackupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	now := time.Now()
+	name := cm.backupPrefix() + now.Format(backupTimeFormat) + ".db"
+	path := filepath.Join(dir, name)
+
+	// Write to a temporary file first so a partial backup never looks complete
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, snapshot, 0600); err != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	cm.lastBackupAt = now
+	logging.Info("Database backed up", logging.Fields{"path": path, "size": len(snapshot)})
+
+	cm.pruneBackups()
+	return &BackupInfo{Name: name, Path: path, Size: int64(len(snapshot)), CreatedAt: now}, nil
+}
+
+// pruneBackups removes the oldest backups beyond BackupKeepCount (caller must
+// hold backupMux)
+func (cm *CleanupManager) pruneBackups() {
+	if cm.cfg.BackupKeepCount <= 0 {
+		return
+	}
+	backups, err := cm.listBackups()
+	if err != nil {
+		logging.Error("Error listing backups", logging.Fields{"error": err})
+		return
+	}
+	for i := cm.cfg.BackupKeepCount; i < len(backups); i++ {
+		if err := os.Remove(backups[i].Path); err != nil {
+			logging.Error("Error removing old backup", logging.Fields{"path": backups[i].Path, "error": err})
+			continue
+		}
+		logging.Info("Removed old backup", logging.Fields{"path": backups[i].Path})
+	}
+}
+
+// Backups lists the backups on disk, newest first, and the time of the last one
+func (cm *CleanupManager) Backups() ([]BackupInfo, time.Time, error) {
+	cm.backupMux.Lock()
+	defer cm.backupMux.Unlock()
+
+	backups, err := cm.listBackups()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	last := cm.lastBackupAt
+	if last.IsZero() && len(backups) > 0 {
+		// Taken before this process started
+		last = backups[0].CreatedAt
+	}
+	return backups, last, nil
+}
+
+// listBackups returns the backups on disk, newest first (caller must hold backupMux)
+func (cm *CleanupManager) listBackups() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(cm.BackupDir())
+	if os.IsNotExist(err) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := cm.backupPrefix()
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".db")
+		createdAt, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{
+			Name:      name,
+			Path:      filepath.Join(cm.BackupDir(), name),
+			Size:      info.Size(),
+			CreatedAt: createdAt,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
@@ -1,53 +1,105 @@
 package cleanup
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
 	"httpserver/server/db"
-	"httpserver/server/naming"
+	"httpserver/server/events"
+	"httpserver/server/logging"
+	"httpserver/server/notify"
+	"httpserver/server/replication"
+	"httpserver/server/resumable"
+	"httpserver/server/storage"
 )
 
 // CleanupManager handles file cleanup operations
 type CleanupManager struct {
-	cfg            *Config
-	db             *db.Database
-	stopChan       chan struct{}
+	cfg          *Config
+	db           *db.Database
+	store        storage.Backend
+	notifier     *notify.Notifier
+	replicator   *replication.Replicator
+	events       *events.Hub
+	stopChan     chan struct{}
+	runMux       sync.Mutex // Serializes periodic and manual runs
+	verifyMux    sync.Mutex
+	verifyCancel context.CancelFunc // Non-nil while a verification is running
+	backupMux    sync.Mutex         // Serializes backups and guards lastBackupAt
+	lastBackupAt time.Time
 }
 
 type Config struct {
-	ImagesDir       string
-	CleanupInterval int // minutes
+	CleanupInterval     int // minutes
+	ExpiryWarningHours  int // 0 disables expiry warnings
+	TrashRetentionHours int // 0 deletes files permanently instead of using the trash
+	OrphanGraceHours    int // Minimum age before reconcile deletes an untracked file
+	VerifyReadRateMB    int // Disk read cap for verification in MB/s (0 = unlimited)
+	BackupIntervalHours int    // 0 disables scheduled metadata backups
+	BackupDir           string // Empty means a backups directory next to the database
+	BackupKeepCount     int    // Older backups are pruned (0 keeps all)
+	ResumableDir        string // Directory of resumable uploads in progress
+	ResumableExpiryHours int   // Resumable uploads idle this long are abandoned (0 keeps them)
+	UploadTempDir        string // Directory uploads are received in; swept of leftovers at startup
 }
 
-// NewCleanupManager creates a new cleanup manager
-func NewCleanupManager(cfg *Config, database *db.Database) *CleanupManager {
+// NewCleanupManager creates a new cleanup manager for files held in store
+func NewCleanupManager(cfg *Config, database *db.Database, store storage.Backend) *CleanupManager {
 	return &CleanupManager{
 		cfg:      cfg,
 		db:       database,
+		store:    store,
 		stopChan: make(chan struct{}),
 	}
 }
 
+// SetNotifier sets the webhook notifier used to report deletions
+func (cm *CleanupManager) SetNotifier(notifier *notify.Notifier) {
+	cm.notifier = notifier
+}
+
+// SetReplicator sets the replicator that mirrors expired file deletions
+func (cm *CleanupManager) SetReplicator(replicator *replication.Replicator) {
+	cm.replicator = replicator
+}
+
+// SetEvents sets the hub that cleanup runs are published to
+func (cm *CleanupManager) SetEvents(hub *events.Hub) {
+	cm.events = hub
+}
+
 // Start starts the cleanup manager
 func (cm *CleanupManager) Start() {
 	interval := time.Duration(cm.cfg.CleanupInterval) * time.Minute
 	ticker := time.NewTicker(interval)
 
-	log.Printf("Cleanup manager started (interval: %v)", interval)
+	logging.Info("Cleanup manager started", logging.Fields{"interval": interval})
+
+	// Nothing is being uploaded yet, so any temporary upload file is a leftover
+	cm.sweepTempFiles()
 
 	// Run initial cleanup
-	go cm.runCleanup()
+	go cm.runCleanup(false)
+
+	// Run expiry warnings alongside cleanup
+	if cm.cfg.ExpiryWarningHours > 0 {
+		go cm.warnLoop(interval)
+	}
+
+	// Back up the metadata on its own schedule
+	if cm.cfg.BackupIntervalHours > 0 {
+		go cm.backupLoop()
+	}
 
 	// Run periodic cleanup
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
-				cm.runCleanup()
+				cm.runCleanup(false)
 			case <-cm.stopChan:
 				ticker.Stop()
 				return
@@ -61,89 +113,205 @@ func (cm *CleanupManager) Stop() {
 	close(cm.stopChan)
 }
 
-// runCleanup executes the cleanup process
-func (cm *CleanupManager) runCleanup() {
-	log.Println("Starting cleanup process...")
+// Report summarizes a cleanup run
+type Report struct {
+	DryRun       bool      `json:"dry_run"`
+	StartedAt    time.Time `json:"started_at"`
+	DurationMs   int64     `json:"duration_ms"`
+	FilesDeleted int       `json:"files_deleted"`
+	BytesFreed   int64     `json:"bytes_freed"`
+	Files        []string  `json:"files"`
+	Errors       []string  `json:"errors,omitempty"`
+}
+
+// runCleanup executes the cleanup process. In dry-run mode it only reports
+// what would be deleted without touching disk or metadata.
+func (cm *CleanupManager) runCleanup(dryRun bool) *Report {
+	cm.runMux.Lock()
+	defer cm.runMux.Unlock()
+
+	report := &Report{DryRun: dryRun, StartedAt: time.Now(), Files: []string{}}
+	defer cm.finishReport(report)
+
+	if dryRun {
+		logging.Info("Starting cleanup process", logging.Fields{"dry_run": true})
+	} else {
+		logging.Info("Starting cleanup process", nil)
+	}
+
+	// Permanently remove trash entries past the retention window
+	if cm.cfg.TrashRetentionHours > 0 && !dryRun {
+		cm.purgeTrash()
+	}
+
+	// Drop resumable uploads the client gave up on
+	if cm.cfg.ResumableExpiryHours > 0 && !dryRun {
+		cm.purgeResumable()
+	}
 
 	// Get expired files
 	expiredFiles, err := cm.db.GetExpiredFiles()
 	if err != nil {
-		log.Printf("Error getting expired files: %v", err)
-		return
+		logging.Error("Error getting expired files", logging.Fields{"error": err})
+		report.Errors = append(report.Errors, err.Error())
+		return report
 	}
 
 	if len(expiredFiles) == 0 {
-		log.Println("No expired files to clean up")
-		return
+		logging.Info("No expired files to clean up", nil)
+		return report
 	}
 
-	deletedCount := 0
-	freedSpace := int64(0)
-
 	for _, file := range expiredFiles {
-		// Delete physical file
-		fullPath := naming.GetStoragePath(cm.cfg.ImagesDir, file.FilePath)
-		if err := os.Remove(fullPath); err != nil {
-			if !os.IsNotExist(err) {
-				log.Printf("Error deleting file %s: %v", file.FilePath, err)
-			}
-			// Still remove from database if file doesn't exist
-		} else {
-			deletedCount++
-			freedSpace += file.FileSize
+		if dryRun {
+			report.FilesDeleted++
+			report.BytesFreed += file.FileSize
+			report.Files = append(report.Files, file.FilePath)
+			continue
 		}
 
-		// Delete metadata from database
-		if err := cm.db.DeleteFileMetadata(file.FilePath); err != nil {
-			log.Printf("Error deleting metadata for %s: %v", file.FilePath, err)
-		} else {
-			log.Printf("Deleted expired file: %s (original: %s, size: %d bytes)",
-				file.FilePath, file.OriginalName, file.FileSize)
+		// Delete the stored file (or move it to the trash) and its metadata
+		if err := DeleteFile(cm.db, cm.store, file, cm.cfg.TrashRetentionHours); err != nil {
+			logging.Error("Error deleting file", logging.Fields{"path": file.FilePath, "error": err})
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", file.FilePath, err))
+			continue
 		}
+		report.FilesDeleted++
+		report.BytesFreed += file.FileSize
+		report.Files = append(report.Files, file.FilePath)
+		logging.Info("Deleted expired file", logging.Fields{"path": file.FilePath, "original": file.OriginalName, "size": file.FileSize})
+		cm.notifier.NotifyFile(notify.EventCleanup, file)
+		cm.replicator.Enqueue(db.ReplicateDelete, file.FilePath)
+	}
 
-		// Try to remove empty date directory
-		dateDir := naming.ParseDateFromPath(file.FilePath)
-		if dateDir != "" {
-			fullDirPath := filepath.Join(cm.cfg.ImagesDir, dateDir)
-			if err := removeEmptyDir(fullDirPath); err != nil {
-				log.Printf("Note: could not remove directory %s: %v", dateDir, err)
-			}
-		}
+	if dryRun {
+		logging.Info("Cleanup dry run complete", logging.Fields{"files": report.FilesDeleted, "size": report.BytesFreed, "duration": time.Since(report.StartedAt)})
+	} else {
+		logging.Info("Cleanup complete", logging.Fields{"files": report.FilesDeleted, "size": report.BytesFreed, "duration": time.Since(report.StartedAt)})
 	}
+	return report
+}
 
-	log.Printf("Cleanup complete: deleted %d files, freed %s", deletedCount, formatBytes(freedSpace))
+// finishReport stamps the run duration and persists real (non dry-run) reports
+func (cm *CleanupManager) finishReport(report *Report) {
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	if report.DryRun {
+		return
+	}
+
+	if err := cm.db.SaveCleanupReport(db.CleanupReport{
+		StartedAt:    report.StartedAt,
+		DurationMs:   report.DurationMs,
+		FilesDeleted: report.FilesDeleted,
+		BytesFreed:   report.BytesFreed,
+		Errors:       report.Errors,
+	}); err != nil {
+		logging.Error("Error saving cleanup report", logging.Fields{"error": err})
+	}
+	cm.events.Publish(events.Event{Type: events.TypeCleanup, Data: map[string]interface{}{
+		"files_deleted": report.FilesDeleted,
+		"bytes_freed":   report.BytesFreed,
+		"duration_ms":   report.DurationMs,
+		"errors":        len(report.Errors),
+	}})
 }
 
-// removeEmptyDir removes a directory if it's empty
-func removeEmptyDir(dirPath string) error {
-	// Check if directory is empty
-	entries, err := os.ReadDir(dirPath)
+// purgeTrash permanently deletes trashed files older than the retention window
+func (cm *CleanupManager) purgeTrash() {
+	cutoff := time.Now().Add(-time.Duration(cm.cfg.TrashRetentionHours) * time.Hour)
+	trashed, err := cm.db.GetTrashedFiles(cutoff)
 	if err != nil {
-		return err
+		logging.Error("Error getting trashed files", logging.Fields{"error": err})
+		return
+	}
+
+	purged := 0
+	for _, file := range trashed {
+		if err := cm.store.Delete(TrashPath(file.FilePath)); err != nil && !os.IsNotExist(err) {
+			logging.Error("Error purging trashed file", logging.Fields{"path": file.FilePath, "error": err})
+			continue
+		}
+		if err := cm.db.DeleteFileMetadata(file.FilePath); err != nil {
+			logging.Error("Error deleting metadata", logging.Fields{"path": file.FilePath, "error": err})
+			continue
+		}
+		purged++
 	}
 
-	if len(entries) == 0 {
-		return os.Remove(dirPath)
+	if purged > 0 {
+		logging.Info("Purged files from trash", logging.Fields{"files": purged})
 	}
+}
+
+// purgeResumable removes resumable uploads that received no chunk within the
+// expiry window
+func (cm *CleanupManager) purgeResumable() {
+	removed, freed, err := resumable.PurgeExpired(cm.cfg.ResumableDir, time.Duration(cm.cfg.ResumableExpiryHours)*time.Hour)
+	if err != nil {
+		logging.Error("Error purging abandoned uploads", logging.Fields{"error": err})
+		return
+	}
+	if removed > 0 {
+		logging.Info("Purged abandoned resumable uploads", logging.Fields{"uploads": removed, "size": freed})
+	}
+}
 
-	return nil
+// warnLoop periodically emits warnings for files about to expire
+func (cm *CleanupManager) warnLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	cm.runExpiryWarnings()
+	for {
+		select {
+		case <-ticker.C:
+			cm.runExpiryWarnings()
+		case <-cm.stopChan:
+			return
+		}
+	}
 }
 
-// formatBytes formats bytes to human readable string
-func formatBytes(b int64) string {
-	const unit = 1024
-	if b < unit {
-		return "0 B"
+// runExpiryWarnings warns once about each file expiring within the warning window
+func (cm *CleanupManager) runExpiryWarnings() {
+	window := time.Duration(cm.cfg.ExpiryWarningHours) * time.Hour
+	expiring, err := cm.db.GetExpiringFiles(window)
+	if err != nil {
+		logging.Error("Error getting expiring files", logging.Fields{"error": err})
+		return
+	}
+	if len(expiring) == 0 {
+		return
 	}
-	div, exp := int64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+
+	entries := make([]notify.FileEntry, 0, len(expiring))
+	ids := make([]int64, 0, len(expiring))
+	for _, file := range expiring {
+		entries = append(entries, notify.FileEntry{
+			FilePath:     file.FilePath,
+			OriginalName: file.OriginalName,
+			Size:         file.FileSize,
+			ExpiresAt:    file.ExpiresAt,
+		})
+		ids = append(ids, file.ID)
+	}
+
+	if cm.notifier.Enabled(notify.EventExpiring) {
+		cm.notifier.Notify(notify.Payload{Event: notify.EventExpiring, Files: entries})
+	} else {
+		for _, e := range entries {
+			logging.Info("Expiring soon", logging.Fields{"path": e.FilePath, "original": e.OriginalName, "expires_at": e.ExpiresAt.Format(time.RFC3339)})
+		}
+	}
+
+	if err := cm.db.MarkFilesWarned(ids, time.Now()); err != nil {
+		logging.Error("Error recording expiry warnings", logging.Fields{"error": err})
+		return
 	}
-	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+	logging.Info("Sent expiry warning", logging.Fields{"files": len(entries), "window": window})
 }
 
-// RunOnce runs cleanup once (for manual trigger)
-func (cm *CleanupManager) RunOnce() {
-	cm.runCleanup()
+// RunOnce runs cleanup once (for manual trigger) and returns its report
+func (cm *CleanupManager) RunOnce(dryRun bool) *Report {
+	return cm.runCleanup(dryRun)
 }
@@ -0,0 +1,72 @@
+package cleanup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+	"httpserver/server/storage"
+)
+
+// PurgeReport summarizes the removal of everything uploaded from one IP address
+type PurgeReport struct {
+	RemoteIP       string   `json:"remote_ip"`
+	DryRun         bool     `json:"dry_run"`
+	FilesFound     int      `json:"files_found"`
+	BytesFound     int64    `json:"bytes_found"`
+	FilesRemoved   int      `json:"files_removed"`
+	RecordsRemoved int      `json:"records_removed"`
+	MissingFiles   int      `json:"missing_files"` // Records whose file was already gone
+	Files          []string `json:"files"`
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// PurgeByIP permanently removes every file uploaded from an IP address, trashed
+// ones included, together with their records. The trash is bypassed. Failures are
+// collected in the report and don't stop the remaining files from being purged.
+func PurgeByIP(database *db.Database, store storage.Backend, ip string, dryRun bool) (*PurgeReport, error) {
+	files, err := database.GetFilesByRemoteIP(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PurgeReport{RemoteIP: ip, DryRun: dryRun, Files: []string{}}
+	for _, meta := range files {
+		report.FilesFound++
+		report.BytesFound += meta.FileSize
+		report.Files = append(report.Files, filepath.ToSlash(meta.FilePath))
+		if dryRun {
+			continue
+		}
+
+		storedPath := meta.FilePath
+		if meta.IsDeleted() {
+			storedPath = TrashPath(meta.FilePath)
+		}
+		switch err := store.Delete(storedPath); {
+		case err == nil:
+			report.FilesRemoved++
+		case os.IsNotExist(err):
+			report.MissingFiles++
+		default:
+			// Keep the record so the file isn't left behind untracked
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", meta.FilePath, err))
+			continue
+		}
+
+		if err := database.DeleteFileMetadata(meta.FilePath); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", meta.FilePath, err))
+			continue
+		}
+		report.RecordsRemoved++
+	}
+
+	if dryRun {
+		logging.Info("Purge dry run complete", logging.Fields{"ip": ip, "files": report.FilesFound, "size": report.BytesFound})
+	} else {
+		logging.Info("Purged uploads", logging.Fields{"ip": ip, "files": report.FilesRemoved, "records": report.RecordsRemoved, "missing": report.MissingFiles, "errors": len(report.Errors)})
+	}
+	return report, nil
+}
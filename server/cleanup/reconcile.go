@@ -0,0 +1,144 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+	"httpserver/server/storage"
+)
+
+// CacheDir is reserved for derived data and, like the trash, is never reconciled
+const CacheDir = "Cache"
+
+// UploadTempDir holds uploads that are still being received; it is never reconciled
+const UploadTempDir = ".uploads"
+
+// reservedDirs are the top-level directories that don't hold live files
+var reservedDirs = []string{TrashDir, CacheDir, UploadTempDir}
+
+// ReconcileReport lists files and metadata that are out of sync
+type ReconcileReport struct {
+	Fixed           bool         `json:"fixed"`
+	DiskOrphans     []OrphanFile `json:"disk_orphans"`
+	MetadataOrphans []string     `json:"metadata_orphans"`
+	FilesRemoved    int          `json:"files_removed"`
+	RecordsRemoved  int          `json:"records_removed"`
+	Errors          []string     `json:"errors,omitempty"`
+}
+
+// OrphanFile is a stored file with no metadata record
+type OrphanFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Reconcile cross-references stored files against the database. With fix set, orphaned
+// files older than the grace period are deleted and metadata rows pointing at
+// missing files are dropped. The database lock is only held while taking a snapshot.
+func (cm *CleanupManager) Reconcile(fix bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		Fixed:           fix,
+		DiskOrphans:     []OrphanFile{},
+		MetadataOrphans: []string{},
+	}
+
+	known := cm.db.GetFilePathIndex()
+	seen := make(map[string]bool, len(known))
+	graceCutoff := time.Now().Add(-time.Duration(cm.cfg.OrphanGraceHours) * time.Hour)
+
+	err := cm.store.Walk(func(rel string, info storage.FileInfo) error {
+		if storage.IsReserved(rel, reservedDirs...) {
+			return nil
+		}
+		if _, ok := known[rel]; ok {
+			seen[rel] = true
+			return nil
+		}
+
+		report.DiskOrphans = append(report.DiskOrphans, OrphanFile{Path: rel, Size: info.Size, ModTime: info.ModTime})
+		if fix && info.ModTime.Before(graceCutoff) {
+			if err := cm.store.Delete(rel); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", rel, err))
+			} else {
+				report.FilesRemoved++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for rel := range known {
+		if seen[rel] {
+			continue
+		}
+		// Double-check the file is really gone; it may have been written after the walk passed it
+		if exists, err := cm.store.Exists(rel); exists || err != nil {
+			continue
+		}
+
+		report.MetadataOrphans = append(report.MetadataOrphans, rel)
+		if fix {
+			if err := cm.db.DeleteFileMetadata(filepath.FromSlash(rel)); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", rel, err))
+			} else {
+				report.RecordsRemoved++
+			}
+		}
+	}
+
+	logging.Info("Reconcile complete", logging.Fields{
+		"orphaned_files":   len(report.DiskOrphans),
+		"orphaned_records": len(report.MetadataOrphans),
+		"fix":              fix,
+		"files_removed":    report.FilesRemoved,
+		"records_removed":  report.RecordsRemoved,
+	})
+	return report, nil
+}
+
+// RebuildRecords adds metadata for stored files that have no record, e.g. after
+// recovering a damaged database. Rebuilt records never expire (so nothing is
+// cleaned up before an admin has looked at them) and carry the file's content
+// hash; the original upload name is unknown, so the stored name is used.
+func RebuildRecords(database *db.Database, store storage.Backend) (int, error) {
+	known := database.GetFilePathIndex()
+	rebuilt := 0
+
+	err := store.Walk(func(rel string, info storage.FileInfo) error {
+		if storage.IsReserved(rel, reservedDirs...) {
+			return nil
+		}
+		if _, ok := known[rel]; ok {
+			return nil
+		}
+
+		hash, err := hashFile(context.Background(), store, rel, nil)
+		if err != nil {
+			logging.Warn("Skipping unreadable file", logging.Fields{"path": rel, "error": err})
+			return nil
+		}
+		name := path.Base(rel)
+		meta := &db.FileMetadata{
+			FileName:     name,
+			OriginalName: name,
+			FilePath:     filepath.FromSlash(rel),
+			FileSize:     info.Size,
+			UploadedAt:   info.ModTime,
+			Hash:         hash,
+		}
+		if err := database.SaveFileMetadata(meta); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		rebuilt++
+		return nil
+	})
+	return rebuilt, err
+}
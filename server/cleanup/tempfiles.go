@@ -0,0 +1,70 @@
+package cleanup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"httpserver/server/logging"
+	"httpserver/server/storage"
+)
+
+// UploadTempPrefix starts the names of the files uploads are received into,
+// directly under UploadTempDir
+const UploadTempPrefix = "upload-"
+
+// staleSpoolAge is how old an S3 spool file in the OS temp dir must be before
+// the startup sweep takes it for one left behind; another server on the same
+// machine may still be using a newer one
+const staleSpoolAge = 24 * time.Hour
+
+// sweepTempFiles removes the temporary files a previous run left behind when it
+// stopped mid-upload: partly received uploads, which nothing can be writing to
+// before the server starts, and stale S3 spool files in the OS temp dir.
+// Resumable uploads live in a subdirectory and expire on their own schedule.
+func (cm *CleanupManager) sweepTempFiles() {
+	removed, freed := 0, int64(0)
+	if cm.cfg.UploadTempDir != "" {
+		n, size := removeTempFiles(cm.cfg.UploadTempDir, UploadTempPrefix, time.Time{})
+		removed, freed = removed+n, freed+size
+	}
+	n, size := removeTempFiles(os.TempDir(), storage.S3SpoolPrefix, time.Now().Add(-staleSpoolAge))
+	removed, freed = removed+n, freed+size
+
+	if removed > 0 {
+		logging.Info("Removed temporary files left by an earlier run", logging.Fields{"files": removed, "size": freed})
+	}
+}
+
+// removeTempFiles deletes the regular files in dir whose names start with
+// prefix and, when cutoff is set, that were last modified before it. It
+// returns how many it removed and their total size.
+func removeTempFiles(dir, prefix string, cutoff time.Time) (int, int64) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Warn("Error listing temporary files", logging.Fields{"dir": dir, "error": err})
+		}
+		return 0, 0
+	}
+
+	removed, freed := 0, int64(0)
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		if !cutoff.IsZero() && entry.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			logging.Warn("Error removing temporary file", logging.Fields{"path": path, "error": err})
+			continue
+		}
+		removed++
+		freed += entry.Size()
+	}
+	return removed, freed
+}
@@ -0,0 +1,119 @@
+package cleanup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"httpserver/server/storage"
+)
+
+// plant creates a file in dir last modified age ago
+func plant(t *testing.T, dir, name string, size int, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now().Add(-age)
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listDir returns the sorted names in dir
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	plant(t, dir, "upload-old", 100, 48*time.Hour)
+	plant(t, dir, "upload-new", 10, time.Minute)
+	plant(t, dir, "keep.png", 1, 48*time.Hour)
+	if err := os.Mkdir(filepath.Join(dir, "upload-dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// With a cutoff, only older files go
+	n, size := removeTempFiles(dir, "upload-", time.Now().Add(-24*time.Hour))
+	if n != 1 || size != 100 {
+		t.Errorf("removed %d files of %d bytes, want 1 of 100", n, size)
+	}
+	if got, want := listDir(t, dir), []string{"keep.png", "upload-dir", "upload-new"}; !equalNames(got, want) {
+		t.Errorf("left %v, want %v", got, want)
+	}
+
+	// Without one, every matching regular file goes
+	n, size = removeTempFiles(dir, "upload-", time.Time{})
+	if n != 1 || size != 10 {
+		t.Errorf("removed %d files of %d bytes, want 1 of 10", n, size)
+	}
+	if got, want := listDir(t, dir), []string{"keep.png", "upload-dir"}; !equalNames(got, want) {
+		t.Errorf("left %v, want %v", got, want)
+	}
+
+	if n, size := removeTempFiles(filepath.Join(dir, "missing"), "upload-", time.Time{}); n != 0 || size != 0 {
+		t.Errorf("missing directory: removed %d files of %d bytes", n, size)
+	}
+}
+
+func TestSweepTempFiles(t *testing.T) {
+	uploads := t.TempDir()
+	osTemp := t.TempDir()
+	t.Setenv("TMPDIR", osTemp)
+	if os.TempDir() != osTemp {
+		t.Skip("the OS temp dir can't be redirected here")
+	}
+
+	resumable := filepath.Join(uploads, "resumable")
+	if err := os.Mkdir(resumable, 0755); err != nil {
+		t.Fatal(err)
+	}
+	plant(t, uploads, UploadTempPrefix+"123", 10, time.Second)
+	plant(t, uploads, UploadTempPrefix+"456", 10, 72*time.Hour)
+	plant(t, resumable, UploadTempPrefix+"abc.data", 10, 72*time.Hour)
+	plant(t, osTemp, storage.S3SpoolPrefix+"stale", 10, 2*staleSpoolAge)
+	plant(t, osTemp, storage.S3SpoolPrefix+"fresh", 10, time.Minute)
+	plant(t, osTemp, "multipart-789", 10, 72*time.Hour)
+
+	cm := NewCleanupManager(&Config{UploadTempDir: uploads}, nil, nil)
+	cm.sweepTempFiles()
+
+	// Every partly received upload goes, whatever its age; resumable uploads
+	// and spool files another server may be using stay
+	if got, want := listDir(t, uploads), []string{"resumable"}; !equalNames(got, want) {
+		t.Errorf("upload dir left %v, want %v", got, want)
+	}
+	if got, want := listDir(t, resumable), []string{UploadTempPrefix + "abc.data"}; !equalNames(got, want) {
+		t.Errorf("resumable dir left %v, want %v", got, want)
+	}
+	if got, want := listDir(t, osTemp), []string{storage.S3SpoolPrefix + "fresh", "multipart-789"}; !equalNames(got, want) {
+		t.Errorf("OS temp dir left %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package cleanup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/storage"
+)
+
+// TrashDir is the top-level storage directory holding soft-deleted files
+const TrashDir = "Trash"
+
+// TrashPath returns the trash location for a relative file path (Trash/YYYYMMDD/name)
+func TrashPath(relativePath string) string {
+	return path.Join(TrashDir, filepath.ToSlash(relativePath))
+}
+
+// DeleteFile removes a stored file. With a non-zero trash retention the file is moved
+// into the trash and its metadata flagged; otherwise both are removed permanently.
+func DeleteFile(database *db.Database, store storage.Backend, meta *db.FileMetadata, trashRetentionHours int) error {
+	if trashRetentionHours <= 0 {
+		if err := store.Delete(meta.FilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return database.DeleteFileMetadata(meta.FilePath)
+	}
+
+	if err := store.Rename(meta.FilePath, TrashPath(meta.FilePath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move file to trash: %w", err)
+	}
+	return database.MarkFileDeleted(meta.FilePath, time.Now())
+}
+
+// RestoreFile moves a trashed file back into place and clears its deleted flag
+func RestoreFile(database *db.Database, store storage.Backend, relativePath string) (*db.FileMetadata, error) {
+	trashPath := TrashPath(relativePath)
+	if _, err := store.Stat(trashPath); err != nil {
+		return nil, fmt.Errorf("file is not in the trash: %w", err)
+	}
+
+	if exists, _ := store.Exists(relativePath); exists {
+		return nil, fmt.Errorf("a file already exists at %s", relativePath)
+	}
+	if err := store.Rename(trashPath, relativePath); err != nil {
+		return nil, fmt.Errorf("failed to restore file: %w", err)
+	}
+
+	meta, err := database.RestoreFileMetadata(relativePath)
+	if err == nil && meta == nil {
+		err = fmt.Errorf("no trashed metadata for %s", relativePath)
+	}
+	if err != nil {
+		// Put the file back so storage and metadata stay consistent
+		store.Rename(relativePath, trashPath)
+		return nil, err
+	}
+	return meta, nil
+}
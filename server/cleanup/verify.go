@@ -0,0 +1,209 @@
+package cleanup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+	"httpserver/server/storage"
+	"httpserver/server/throttle"
+)
+
+// verifyWorkers bounds how many files are hashed concurrently
+const verifyWorkers = 2
+
+// ErrVerifyRunning is returned when a verification is already in progress
+var ErrVerifyRunning = errors.New("verification is already running")
+
+// VerifyOptions limits which files are verified
+type VerifyOptions struct {
+	Date string // Only files in this date directory
+	ID   int64  // Only the file with this ID
+}
+
+// VerifyReport summarizes an integrity verification run
+type VerifyReport struct {
+	StartedAt  time.Time       `json:"started_at"`
+	DurationMs int64           `json:"duration_ms"`
+	Checked    int             `json:"checked"`
+	Skipped    int             `json:"skipped"` // Files uploaded before hashes were recorded
+	Mismatched []VerifyFailure `json:"mismatched"`
+	Unreadable []VerifyFailure `json:"unreadable"`
+	Cancelled  bool            `json:"cancelled"`
+}
+
+// VerifyFailure describes a file that failed verification
+type VerifyFailure struct {
+	ID       int64  `json:"id"`
+	Path     string `json:"path"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Verify re-hashes stored files and compares them with the recorded hashes.
+// Only one verification runs at a time; CancelVerify stops it early.
+func (cm *CleanupManager) Verify(opts VerifyOptions) (*VerifyReport, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cm.verifyMux.Lock()
+	if cm.verifyCancel != nil {
+		cm.verifyMux.Unlock()
+		return nil, ErrVerifyRunning
+	}
+	cm.verifyCancel = cancel
+	cm.verifyMux.Unlock()
+
+	defer func() {
+		cm.verifyMux.Lock()
+		cm.verifyCancel = nil
+		cm.verifyMux.Unlock()
+	}()
+
+	files, err := cm.selectVerifyFiles(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{
+		StartedAt:  time.Now(),
+		Mismatched: []VerifyFailure{},
+		Unreadable: []VerifyFailure{},
+	}
+	limiter := throttle.NewLimiter(cm.cfg.VerifyReadRateMB * 1024 * 1024)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan *db.FileMetadata)
+
+	for i := 0; i < verifyWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for meta := range jobs {
+				actual, err := hashFile(ctx, cm.store, meta.FilePath, limiter)
+				if ctx.Err() != nil {
+					continue
+				}
+
+				mu.Lock()
+				report.Checked++
+				switch {
+				case err != nil:
+					report.Unreadable = append(report.Unreadable, VerifyFailure{ID: meta.ID, Path: meta.FilePath, Error: err.Error()})
+				case actual != meta.Hash:
+					report.Mismatched = append(report.Mismatched, VerifyFailure{ID: meta.ID, Path: meta.FilePath, Expected: meta.Hash, Actual: actual})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, meta := range files {
+		if meta.Hash == "" {
+			report.Skipped++
+			continue
+		}
+		select {
+		case jobs <- meta:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	report.Cancelled = ctx.Err() != nil
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+
+	logging.Info("Verification complete", logging.Fields{
+		"checked":    report.Checked,
+		"mismatched": len(report.Mismatched),
+		"unreadable": len(report.Unreadable),
+		"skipped":    report.Skipped,
+		"cancelled":  report.Cancelled,
+		"duration":   time.Duration(report.DurationMs) * time.Millisecond,
+	})
+
+	if err := cm.db.SaveVerifyReport(db.VerifyReport{
+		StartedAt:  report.StartedAt,
+		DurationMs: report.DurationMs,
+		Checked:    report.Checked,
+		Mismatched: len(report.Mismatched),
+		Unreadable: len(report.Unreadable),
+		Cancelled:  report.Cancelled,
+	}); err != nil {
+		logging.Error("Error saving verify report", logging.Fields{"error": err})
+	}
+
+	return report, nil
+}
+
+// CancelVerify stops a running verification, reporting whether one was running
+func (cm *CleanupManager) CancelVerify() bool {
+	cm.verifyMux.Lock()
+	defer cm.verifyMux.Unlock()
+
+	if cm.verifyCancel == nil {
+		return false
+	}
+	cm.verifyCancel()
+	return true
+}
+
+// selectVerifyFiles returns the live files matching the options
+func (cm *CleanupManager) selectVerifyFiles(opts VerifyOptions) ([]*db.FileMetadata, error) {
+	switch {
+	case opts.ID != 0:
+		meta, err := cm.db.GetFileMetadataByID(opts.ID)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil || meta.IsDeleted() {
+			return nil, fmt.Errorf("file %d not found", opts.ID)
+		}
+		return []*db.FileMetadata{meta}, nil
+	case opts.Date != "":
+		return cm.db.ListFilesByDate(opts.Date, true)
+	default:
+		return cm.db.ListAllFiles()
+	}
+}
+
+// hashFile computes the SHA-256 of a stored file, pacing reads through the limiter
+func hashFile(ctx context.Context, store storage.Backend, relativePath string, limiter *throttle.Limiter) (string, error) {
+	f, err := store.Get(relativePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			hasher.Write(buf[:n])
+			if err := limiter.Wait(ctx, n); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
 // Config represents the server configuration
@@ -17,19 +18,97 @@ type Config struct {
 	Security SecurityConfig `json:"security"`
 	Database DatabaseConfig `json:"database"`
 	AutoRestart AutoRestartConfig `json:"auto_restart"`
+	Notifications NotificationsConfig `json:"notifications"`
+	Replication   ReplicationConfig   `json:"replication"`
+	Backup   BackupConfig   `json:"backup"`
+	Logging  LoggingConfig  `json:"logging"`
 }
 
 type ServerConfig struct {
-	Host string `json:"host"`
-	Port int    `json:"port"`
+	Host                   string `json:"host"`
+	Port                   int    `json:"port"`
+	MinFreeDiskMB          int    `json:"min_free_disk_mb"`
+	MaxConcurrentUploads   int    `json:"max_concurrent_uploads"`
+	MaxConcurrentDownloads int    `json:"max_concurrent_downloads"`
+	ConcurrencyWaitSeconds int    `json:"concurrency_wait_seconds"`
+	PIDFile                string `json:"pid_file"`
+
+	// Listen overrides Host and Port with tcp:// and unix:// addresses
+	Listen      []string `json:"listen"`
+	SocketMode  string   `json:"socket_mode"`  // Octal permissions for unix sockets
+	SocketGroup string   `json:"socket_group"` // Group owning unix sockets ("" keeps the default)
+
+	// ReadOnly refuses uploads and deletions while downloads keep working
+	ReadOnly           bool   `json:"read_only"`
+	MaintenanceMessage string `json:"maintenance_message"`
+
+	// EnableCompression gzips JSON and HTML responses for clients that accept it
+	EnableCompression bool `json:"enable_compression"`
+
+	// TemplatesDir holds HTML error pages (404.html, error.html, ...) overriding the built-in one
+	TemplatesDir string `json:"templates_dir"`
+
+	// AllowIndexing lets search engines crawl downloads; otherwise robots.txt
+	// disallows everything and downloads carry X-Robots-Tag: noindex
+	AllowIndexing bool `json:"allow_indexing"`
+
+	// ACMEDomains turns on HTTPS with Let's Encrypt certificates for these
+	// domains, served on HTTPSPort; HTTPPort answers ACME challenges and, with
+	// RedirectHTTP, redirects to HTTPS (otherwise it serves the site too).
+	// Host and Port/Listen are not used then.
+	ACMEDomains  []string `json:"acme_domains"`
+	ACMEEmail    string   `json:"acme_email"`
+	HTTPSPort    int      `json:"https_port"`
+	HTTPPort     int      `json:"http_port"`
+	RedirectHTTP bool     `json:"redirect_http"`
 }
 
 type StorageConfig struct {
-	ImagesDir        string `json:"images_dir"`
-	MaxFileSize      int64  `json:"max_file_size"`
-	CleanupInterval  int    `json:"cleanup_interval"`
-	DefaultTTL       int    `json:"default_ttl"`
-	MaxTTL           int    `json:"max_ttl"`
+	ImagesDir           string `json:"images_dir"`
+	MaxFileSize         int64  `json:"max_file_size"`
+	CleanupInterval     int    `json:"cleanup_interval"`
+	MaxArchiveSize      int64  `json:"max_archive_size"`
+	TrashRetentionHours int    `json:"trash_retention_hours"`
+	OrphanGraceHours    int    `json:"orphan_grace_hours"`
+	ResumableExpiryHours int   `json:"resumable_expiry_hours"` // Idle resumable uploads are dropped after this (0 = never)
+	IdempotencyWindowHours int `json:"idempotency_window_hours"` // Uploads' Idempotency-Keys are remembered this long (0 = ignore them)
+
+	// Downloads are shown in the browser or saved by extension: ForceDownloadExtensions
+	// wins over InlineExtensions, and other files go by their media type
+	InlineExtensions        []string `json:"inline_extensions"`
+	ForceDownloadExtensions []string `json:"force_download_extensions"`
+	VerifyReadRateMB    int    `json:"verify_read_rate_mb"`
+
+	// TTLs; NeverExpires as DefaultTTL pins uploads that don't ask for a TTL,
+	// and as MaxTTL puts no upper bound on requested TTLs
+	DefaultTTL     TTL  `json:"default_ttl"`
+	MaxTTL         TTL  `json:"max_ttl"`
+	AllowPermanent bool `json:"allow_permanent"` // Uploads may ask for a TTL of "never"
+
+	// RetentionRules override requested TTLs by extension, size or uploader;
+	// the first matching rule applies (see ParseRetentionRules)
+	RetentionRules []RetentionRule `json:"retention_rules"`
+
+	// PathLayout nests new uploads "daily" (YYYYMMDD/), "hourly" (YYYYMMDD/HH/)
+	// or "hashed" (two levels from the random part of the name)
+	PathLayout           string `json:"path_layout"`
+	PreserveOriginalName bool   `json:"preserve_original_name"` // Append the sanitized upload name
+	NameEntropyBytes     int    `json:"name_entropy_bytes"`     // Random bytes in generated names
+	NameStyle            string `json:"name_style"`             // "hex", or "short" for base62
+
+	DownloadRateLimitKbps       int `json:"download_rate_limit_kbps"`
+	GlobalDownloadRateLimitKbps int `json:"global_download_rate_limit_kbps"`
+
+	// Backend selects where file bytes live: "local" (ImagesDir) or "s3".
+	// ImagesDir still holds uploads in progress with the s3 backend.
+	Backend     string `json:"backend"`
+	S3Endpoint  string `json:"s3_endpoint"`
+	S3Bucket    string `json:"s3_bucket"`
+	S3Region    string `json:"s3_region"`
+	S3AccessKey string `json:"s3_access_key"`
+	S3Secret    string `json:"s3_secret"`
+	S3Prefix    string `json:"s3_prefix"`
+	S3PathStyle bool   `json:"s3_path_style"`
 }
 
 type AuthConfig struct {
@@ -37,18 +116,64 @@ type AuthConfig struct {
 	AdminUsername string `json:"admin_username"`
 	AdminPassword string `json:"admin_password"`
 	ListPassword  string `json:"list_password"`
+
+	// TOTPSecret enables two-factor authentication for the admin endpoints when set
+	TOTPSecret string `json:"totp_secret"`
+	// TOTPRecoveryCodes are SHA-256 hashes of the unused recovery codes
+	TOTPRecoveryCodes []string `json:"totp_recovery_codes"`
 }
 
 type SecurityConfig struct {
 	IPWhitelist          []string `json:"ip_whitelist"`
+	TrustedProxies       []string `json:"trusted_proxies"`
 	RateLimitPerMinute   int      `json:"rate_limit_per_minute"`
-	SessionTimeout       int      `json:"session_timeout"`
+	SessionTimeout       int      `json:"session_timeout"`          // Idle seconds before a session expires; each request restarts it
+	SessionAbsoluteTimeout int    `json:"session_absolute_timeout"` // Seconds after login a session ends however active (0 = no cap)
+	AllowRememberMe        bool   `json:"allow_remember_me"`        // Logins may ask to stay valid, idle or not, until the absolute timeout
+	CookieSecure           string `json:"cookie_secure"`            // "auto" (Secure on HTTPS requests), "always" or "never"
+	CookieSameSiteStrict   bool   `json:"cookie_samesite_strict"`   // SameSite=Strict instead of Lax on the session cookie
+	AllowInlineHTML        bool   `json:"allow_inline_html"`        // Let HTML, SVG and XML downloads render in the browser
+	UploadQuotaPerDayBytes int64  `json:"upload_quota_per_day_bytes"` // 0 = unlimited
+	AutobanThreshold       int    `json:"autoban_threshold"`          // Failures within the window that trigger a ban (0 = off)
+	AutobanWindowMinutes   int    `json:"autoban_window_minutes"`
+	AutobanDurationMinutes int    `json:"autoban_duration_minutes"`
+	HSTSMaxAge             int    `json:"hsts_max_age"` // Strict-Transport-Security max-age in seconds on HTTPS (0 = off)
+	// RequireNonDefaultCredentials refuses to start while a credential still has its built-in default
+	RequireNonDefaultCredentials bool `json:"require_non_default_credentials"`
+}
+
+type NotificationsConfig struct {
+	WebhookURL         string   `json:"webhook_url"`
+	WebhookSecret      string   `json:"webhook_secret"`
+	Events             []string `json:"events"`
+	ExpiryWarningHours int      `json:"expiry_warning_hours"`
+}
+
+// ReplicationConfig pushes uploads, deletions and expiry changes to a standby server
+type ReplicationConfig struct {
+	TargetURL string `json:"target_url"` // Base URL of the standby ("" disables replication)
+	APIKey    string `json:"api_key"`    // Token with the replicate scope on the standby
+}
+
+type BackupConfig struct {
+	IntervalHours int    `json:"interval_hours"`
+	Dir           string `json:"dir"`
+	KeepCount     int    `json:"keep_count"`
 }
 
 type DatabaseConfig struct {
 	Path string `json:"path"`
 }
 
+type LoggingConfig struct {
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+	Stdout     bool   `json:"stdout"`
+	Format     string `json:"format"`
+	AccessLog  bool   `json:"access_log"`
+}
+
 type AutoRestartConfig struct {
 	Enabled         bool `json:"enabled"`
 	MaxRestartCount int  `json:"max_restart_count"`
@@ -128,15 +253,29 @@ func getDefaultConfig() *Config {
 	dataDir := getDataDir()
 	return &Config{
 		Server: ServerConfig{
-			Host: "0.0.0.0",
-			Port: 8080,
+			Host:                   "0.0.0.0",
+			Port:                   8080,
+			MinFreeDiskMB:          100,
+			ConcurrencyWaitSeconds: 5,
+			EnableCompression:      true,
+			HTTPSPort:              443,
+			HTTPPort:               80,
+			RedirectHTTP:           true,
 		},
 		Storage: StorageConfig{
 			ImagesDir:       filepath.Join(dataDir, "Images"),
 			MaxFileSize:     100 * 1024 * 1024, // 100MB
 			CleanupInterval: 60,
-			DefaultTTL:      1,
-			MaxTTL:          8760, // 365 days
+			DefaultTTL:      TTL(time.Hour),
+			MaxTTL:          TTL(365 * 24 * time.Hour),
+			MaxArchiveSize:  2 * 1024 * 1024 * 1024, // 2GB
+			PathLayout:      "daily",
+			NameEntropyBytes: 16,
+			NameStyle:       "hex",
+			OrphanGraceHours: 24,
+			ResumableExpiryHours: 24,
+			IdempotencyWindowHours: 24,
+			VerifyReadRateMB: 20,
 		},
 		Auth: AuthConfig{
 			APIKey:        "change-me-api-key",
@@ -148,6 +287,8 @@ func getDefaultConfig() *Config {
 			IPWhitelist:        []string{},
 			RateLimitPerMinute: 60,
 			SessionTimeout:     300, // 5 minutes
+			SessionAbsoluteTimeout: 86400,
+			CookieSecure:           "auto",
 		},
 		Database: DatabaseConfig{
 			Path: filepath.Join(dataDir, "metadata.db"),
@@ -156,6 +297,9 @@ func getDefaultConfig() *Config {
 			Enabled:         true,
 			MaxRestartCount: 10,
 		},
+		Notifications: NotificationsConfig{
+			Events: []string{"upload", "delete", "cleanup", "expiring"},
+		},
 	}
 }
 
@@ -201,3 +345,12 @@ func EnsureDirectories(cfg *Config) error {
 	}
 	return nil
 }
+
+// EnvPrefix is prepended to environment variables that override config keys
+const EnvPrefix = "HTTPSERVER_"
+
+// EnvName returns the environment variable that overrides a dotted config key,
+// e.g. server.port -> HTTPSERVER_SERVER_PORT
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
@@ -0,0 +1,21 @@
+package config
+
+import "testing"
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"server.port", "HTTPSERVER_SERVER_PORT"},
+		{"auth.api_key", "HTTPSERVER_AUTH_API_KEY"},
+		{"security.ip_whitelist", "HTTPSERVER_SECURITY_IP_WHITELIST"},
+		{"auto_restart.max_restart_count", "HTTPSERVER_AUTO_RESTART_MAX_RESTART_COUNT"},
+		{"storage.s3-bucket", "HTTPSERVER_STORAGE_S3_BUCKET"},
+		{"logging", "HTTPSERVER_LOGGING"},
+	}
+	for _, tt := range tests {
+		if got := EnvName(tt.key); got != tt.want {
+			t.Errorf("EnvName(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package config
+
+import "strings"
+
+// NormalizeExtension returns a file extension in the form config lists use:
+// lowercase, without the dot
+func NormalizeExtension(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+}
+
+// ParseExtensionList splits a comma-separated list of extensions, normalizing
+// each and dropping empty items
+func ParseExtensionList(value string) []string {
+	var exts []string
+	for _, item := range strings.Split(value, ",") {
+		if ext := NormalizeExtension(item); ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeExtension(t *testing.T) {
+	tests := map[string]string{
+		"png":    "png",
+		".png":   "png",
+		"PNG":    "png",
+		" .Jpg ": "jpg",
+		"tar.gz": "tar.gz",
+		"":       "",
+		".":      "",
+	}
+	for ext, want := range tests {
+		if got := NormalizeExtension(ext); got != want {
+			t.Errorf("NormalizeExtension(%q) = %q, want %q", ext, got, want)
+		}
+	}
+}
+
+func TestParseExtensionList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"pdf", []string{"pdf"}},
+		{"pdf,txt", []string{"pdf", "txt"}},
+		{" .PDF , .Txt ,, ", []string{"pdf", "txt"}},
+		{",", nil},
+	}
+	for _, tt := range tests {
+		if got := ParseExtensionList(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseExtensionList(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateExtensionLists(t *testing.T) {
+	for _, key := range []string{"storage.inline_extensions", "storage.force_download_extensions"} {
+		for _, value := range []string{"", "pdf", ".pdf, TXT", "pdf,,txt"} {
+			if err := ValidateValue(key, value); err != nil {
+				t.Errorf("ValidateValue(%s, %q): %v", key, value, err)
+			}
+		}
+		for _, value := range []string{"pdf,tar.gz", "a/b", `a\\b`, "my ext", "."} {
+			if err := ValidateValue(key, value); err == nil {
+				t.Errorf("ValidateValue(%s, %q) accepted it", key, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ListenAddress is one socket the server listens on
+type ListenAddress struct {
+	Network string // "tcp" or "unix"
+	Address string // host:port for tcp, a file path for unix
+}
+
+// String formats the address the way server.listen accepts it
+func (a ListenAddress) String() string {
+	return a.Network + "://" + a.Address
+}
+
+// ParseListenAddress parses a server.listen entry: tcp://host:port (IPv6 hosts
+// in brackets, e.g. tcp://[::1]:8080) or unix:///path/to/socket. A bare
+// host:port is taken as TCP.
+func ParseListenAddress(entry string) (ListenAddress, error) {
+	entry = strings.TrimSpace(entry)
+	switch {
+	case strings.HasPrefix(entry, "unix://"):
+		path := strings.TrimPrefix(entry, "unix://")
+		if path == "" {
+			return ListenAddress{}, fmt.Errorf("%q has no socket path", entry)
+		}
+		return ListenAddress{Network: "unix", Address: path}, nil
+	case strings.HasPrefix(entry, "tcp://"):
+		entry = strings.TrimPrefix(entry, "tcp://")
+	case strings.Contains(entry, "://"):
+		return ListenAddress{}, fmt.Errorf("%q: only tcp:// and unix:// are supported", entry)
+	}
+
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil {
+		return ListenAddress{}, fmt.Errorf("%q is not a host:port address (IPv6 hosts need brackets): %v", entry, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > maxPort {
+		return ListenAddress{}, fmt.Errorf("%q has an invalid port", entry)
+	}
+	return ListenAddress{Network: "tcp", Address: net.JoinHostPort(host, port)}, nil
+}
+
+// ListenAddresses returns the sockets to listen on: the server.listen entries,
+// or server.host and server.port when that is empty
+func (c *Config) ListenAddresses() []ListenAddress {
+	var addrs []ListenAddress
+	for _, entry := range c.Server.Listen {
+		if addr, err := ParseListenAddress(entry); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		addrs = append(addrs, ListenAddress{
+			Network: "tcp",
+			Address: net.JoinHostPort(c.Server.Host, strconv.Itoa(c.Server.Port)),
+		})
+	}
+	return addrs
+}
+
+// ParseSocketMode parses server.socket_mode, an octal permission like 0660
+func ParseSocketMode(value string) (uint32, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q is not an octal file mode like 0660", value)
+	}
+	return uint32(mode), nil
+}
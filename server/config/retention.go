@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RetentionRule overrides the TTL of uploads it matches. Every criterion that
+// is set must match; a rule without criteria matches every upload.
+type RetentionRule struct {
+	Name string `json:"name"` // Recorded on matching uploads; "rule-<n>" when not given
+
+	// Match criteria
+	Extensions []string `json:"extensions,omitempty"` // File extensions, without the dot
+	MinSize    int64    `json:"min_size,omitempty"`   // Bytes
+	IPs        []string `json:"ips,omitempty"`        // Uploader IPs or CIDR ranges
+	APIKeys    []string `json:"api_keys,omitempty"`   // API token names; "api-key" is auth.api_key
+
+	// Action: exactly one of these
+	MaxTTL   *TTL `json:"max_ttl,omitempty"`   // Caps the requested TTL
+	ForceTTL *TTL `json:"force_ttl,omitempty"` // Replaces the requested TTL
+}
+
+// RetentionUpload describes an upload for matching against retention rules
+type RetentionUpload struct {
+	FileName string
+	Size     int64
+	IP       net.IP
+	APIKey   string // Name of the API token used
+}
+
+// ParseRetentionRules parses storage.retention_rules, a JSON array of rules.
+// An empty value means no rules.
+func ParseRetentionRules(value string) ([]RetentionRule, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var rules []RetentionRule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("retention rules must be a JSON array of rules: %v", err)
+	}
+	for i := range rules {
+		if err := rules[i].normalize(i); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// normalize checks the rule at index i and puts its criteria in the form
+// Matches expects
+func (rule *RetentionRule) normalize(i int) error {
+	if rule.Name == "" {
+		rule.Name = "rule-" + strconv.Itoa(i+1)
+	}
+	switch {
+	case rule.MaxTTL == nil && rule.ForceTTL == nil:
+		return fmt.Errorf("retention rule %q needs an action: max_ttl or force_ttl", rule.Name)
+	case rule.MaxTTL != nil && rule.ForceTTL != nil:
+		return fmt.Errorf("retention rule %q has both max_ttl and force_ttl; use one", rule.Name)
+	case rule.MaxTTL != nil && *rule.MaxTTL == NeverExpires:
+		return fmt.Errorf("retention rule %q: max_ttl can't be \"never\"", rule.Name)
+	case rule.MinSize < 0:
+		return fmt.Errorf("retention rule %q: min_size can't be negative", rule.Name)
+	}
+	for j, ext := range rule.Extensions {
+		rule.Extensions[j] = NormalizeExtension(ext)
+	}
+	for _, ip := range rule.IPs {
+		if err := checkIPOrCIDR(ip); err != nil {
+			return fmt.Errorf("retention rule %q: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to an upload
+func (rule *RetentionRule) Matches(upload RetentionUpload) bool {
+	if len(rule.Extensions) > 0 {
+		ext := NormalizeExtension(filepath.Ext(upload.FileName))
+		if !contains(rule.Extensions, ext) {
+			return false
+		}
+	}
+	if upload.Size < rule.MinSize {
+		return false
+	}
+	if len(rule.IPs) > 0 && !ipInNetworks(upload.IP, rule.IPs) {
+		return false
+	}
+	if len(rule.APIKeys) > 0 && !contains(rule.APIKeys, upload.APIKey) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the TTL the rule gives an upload that asked for ttl
+func (rule *RetentionRule) Apply(ttl TTL) TTL {
+	if rule.ForceTTL != nil {
+		return *rule.ForceTTL
+	}
+	if ttl == NeverExpires || ttl > *rule.MaxTTL {
+		return *rule.MaxTTL
+	}
+	return ttl
+}
+
+// ApplyRetention evaluates the rules in order; the first that matches sets
+// the TTL. It returns the effective TTL and the rule, or nil when none matched.
+func ApplyRetention(rules []RetentionRule, upload RetentionUpload, ttl TTL) (TTL, *RetentionRule) {
+	for i := range rules {
+		if rules[i].Matches(upload) {
+			return rules[i].Apply(ttl), &rules[i]
+		}
+	}
+	return ttl, nil
+}
+
+// ipInNetworks reports whether ip matches one of the IPs or CIDR ranges in list
+func ipInNetworks(ip net.IP, list []string) bool {
+	if ip == nil {
+		return false
+	}
+	for _, entry := range list {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkRetentionRules(value string) error {
+	_, err := ParseRetentionRules(value)
+	return err
+}
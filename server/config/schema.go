@@ -0,0 +1,275 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownKey is returned by ValidateValue for keys missing from the schema
+var ErrUnknownKey = errors.New("unknown config key")
+
+type keyKind int
+
+const (
+	kindString keyKind = iota
+	kindInt
+	kindBool
+	kindEnum
+	kindList
+)
+
+// keySpec describes the type and allowed range of a config key
+type keySpec struct {
+	kind     keyKind
+	min, max int64    // Inclusive bounds for kindInt; max 0 means unbounded
+	required bool     // Value may not be empty
+	options  []string // Allowed values for kindEnum, or list items for kindList
+	check    func(string) error
+}
+
+const (
+	minFileSize = 1024
+	maxPort     = 65535
+)
+
+// schema lists every config key the server understands
+var schema = map[string]keySpec{
+	"server.host":                     {kind: kindString, required: true},
+	"server.port":                     {kind: kindInt, max: maxPort}, // 0 picks a free port
+	"server.min_free_disk_mb":         {kind: kindInt},
+	"server.max_concurrent_uploads":   {kind: kindInt},
+	"server.max_concurrent_downloads": {kind: kindInt},
+	"server.concurrency_wait_seconds": {kind: kindInt},
+	"server.pid_file":                 {kind: kindString},
+	"server.listen":                   {kind: kindList, check: checkListenAddress},
+	"server.socket_mode":              {kind: kindString, required: true, check: checkSocketMode},
+	"server.socket_group":             {kind: kindString},
+	"server.read_only":                {kind: kindBool},
+	"server.maintenance_message":      {kind: kindString},
+	"server.enable_compression":       {kind: kindBool},
+	"server.templates_dir":            {kind: kindString},
+	"server.allow_indexing":           {kind: kindBool},
+	"server.acme_domains":             {kind: kindList, check: checkDomain},
+	"server.acme_email":               {kind: kindString},
+	"server.https_port":               {kind: kindInt, max: maxPort},
+	"server.http_port":                {kind: kindInt, max: maxPort},
+	"server.redirect_http":            {kind: kindBool},
+
+	"storage.images_dir":                      {kind: kindString, required: true},
+	"storage.max_file_size":                   {kind: kindInt, min: minFileSize},
+	"storage.cleanup_interval":                {kind: kindInt, min: 1},
+	"storage.default_ttl":                     {kind: kindString, required: true, check: checkTTL},
+	"storage.max_ttl":                         {kind: kindString, required: true, check: checkTTL},
+	"storage.allow_permanent":                 {kind: kindBool},
+	"storage.retention_rules":                 {kind: kindString, check: checkRetentionRules},
+	"storage.path_layout":                     {kind: kindEnum, options: []string{"daily", "hourly", "hashed"}},
+	"storage.preserve_original_name":          {kind: kindBool},
+	"storage.name_entropy_bytes":              {kind: kindInt, min: 4, max: 64},
+	"storage.name_style":                      {kind: kindEnum, options: []string{"hex", "short"}},
+	"storage.max_archive_size":                {kind: kindInt},
+	"storage.trash_retention_hours":           {kind: kindInt},
+	"storage.orphan_grace_hours":              {kind: kindInt},
+	"storage.resumable_expiry_hours":          {kind: kindInt},
+	"storage.idempotency_window_hours":        {kind: kindInt},
+	"storage.inline_extensions":               {kind: kindList, check: checkExtension},
+	"storage.force_download_extensions":       {kind: kindList, check: checkExtension},
+	"storage.verify_read_rate_mb":             {kind: kindInt},
+	"storage.download_rate_limit_kbps":        {kind: kindInt},
+	"storage.global_download_rate_limit_kbps": {kind: kindInt},
+	"storage.backend":                         {kind: kindEnum, options: []string{"local", "s3"}},
+	"storage.s3_endpoint":                     {kind: kindString, check: checkHTTPURL},
+	"storage.s3_bucket":                       {kind: kindString},
+	"storage.s3_region":                       {kind: kindString},
+	"storage.s3_access_key":                   {kind: kindString},
+	"storage.s3_secret":                       {kind: kindString},
+	"storage.s3_prefix":                       {kind: kindString},
+	"storage.s3_path_style":                   {kind: kindBool},
+
+	"auth.api_key":             {kind: kindString, required: true},
+	"auth.admin_username":      {kind: kindString, required: true},
+	"auth.admin_password":      {kind: kindString, required: true},
+	"auth.list_password":       {kind: kindString, required: true},
+	"auth.totp_secret":         {kind: kindString},
+	"auth.totp_recovery_codes": {kind: kindList},
+
+	"security.ip_whitelist":                    {kind: kindList, check: checkIPOrCIDR},
+	"security.trusted_proxies":                 {kind: kindList, check: checkIPOrCIDR},
+	"security.rate_limit_per_minute":           {kind: kindInt},
+	"security.session_timeout":                 {kind: kindInt, min: 1},
+	"security.session_absolute_timeout":        {kind: kindInt},
+	"security.allow_remember_me":               {kind: kindBool},
+	"security.cookie_secure":                   {kind: kindEnum, options: []string{"auto", "always", "never"}},
+	"security.cookie_samesite_strict":          {kind: kindBool},
+	"security.allow_inline_html":               {kind: kindBool},
+	"security.upload_quota_per_day_bytes":      {kind: kindInt},
+	"security.autoban_threshold":               {kind: kindInt},
+	"security.autoban_window_minutes":          {kind: kindInt, min: 1},
+	"security.autoban_duration_minutes":        {kind: kindInt, min: 1},
+	"security.hsts_max_age":                    {kind: kindInt},
+	"security.require_non_default_credentials": {kind: kindBool},
+
+	"notifications.webhook_url":          {kind: kindString, check: checkHTTPURL},
+	"replication.target_url":             {kind: kindString, check: checkHTTPURL},
+	"replication.api_key":                {kind: kindString},
+	"notifications.webhook_secret":       {kind: kindString},
+	"notifications.events":               {kind: kindList, options: []string{"upload", "delete", "cleanup", "expiring"}},
+	"notifications.expiry_warning_hours": {kind: kindInt},
+
+	"backup.interval_hours": {kind: kindInt},
+	"backup.dir":            {kind: kindString},
+	"backup.keep_count":     {kind: kindInt},
+
+	"database.driver": {kind: kindEnum, options: []string{"json", "sqlite"}},
+	"database.path":   {kind: kindString},
+
+	"logging.file":        {kind: kindString},
+	"logging.max_size_mb": {kind: kindInt, min: 1},
+	"logging.max_backups": {kind: kindInt},
+	"logging.stdout":      {kind: kindBool},
+	"logging.format":      {kind: kindEnum, options: []string{"text", "json"}},
+	"logging.access_log":  {kind: kindBool},
+
+	"auto_restart.enabled":           {kind: kindBool},
+	"auto_restart.max_restart_count": {kind: kindInt},
+}
+
+// IsKnownKey reports whether key is part of the config schema
+func IsKnownKey(key string) bool {
+	_, ok := schema[key]
+	return ok
+}
+
+// KnownKeys returns all keys in the config schema, sorted
+func KnownKeys() []string {
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateValue checks a value against the schema. Unknown keys return an error
+// wrapping ErrUnknownKey so callers can decide whether to accept them.
+func ValidateValue(key, value string) error {
+	spec, ok := schema[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, key)
+	}
+
+	if value == "" {
+		if spec.required {
+			return fmt.Errorf("%s must not be empty", key)
+		}
+		return nil
+	}
+
+	switch spec.kind {
+	case kindInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer, got %q", key, value)
+		}
+		if n < spec.min {
+			return fmt.Errorf("%s must be at least %d, got %d", key, spec.min, n)
+		}
+		if spec.max > 0 && n > spec.max {
+			return fmt.Errorf("%s must be at most %d, got %d", key, spec.max, n)
+		}
+	case kindBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%s must be true or false, got %q", key, value)
+		}
+	case kindEnum:
+		if !contains(spec.options, value) {
+			return fmt.Errorf("%s must be one of %s, got %q", key, strings.Join(spec.options, ", "), value)
+		}
+	case kindList:
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if len(spec.options) > 0 && !contains(spec.options, item) {
+				return fmt.Errorf("%s items must be among %s, got %q", key, strings.Join(spec.options, ", "), item)
+			}
+			if spec.check != nil {
+				if err := spec.check(item); err != nil {
+					return fmt.Errorf("%s: %v", key, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	if spec.check != nil {
+		if err := spec.check(value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func checkIPOrCIDR(value string) error {
+	if net.ParseIP(value) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%q is not an IP address or CIDR range", value)
+}
+
+func checkListenAddress(value string) error {
+	_, err := ParseListenAddress(value)
+	return err
+}
+
+// checkDomain accepts a bare host name that a certificate can be issued for
+func checkDomain(value string) error {
+	if strings.ContainsAny(value, ":/*") || net.ParseIP(value) != nil || !strings.Contains(value, ".") {
+		return fmt.Errorf("%q is not a domain name", value)
+	}
+	return nil
+}
+
+// checkExtension accepts a file extension, with or without the dot
+func checkExtension(value string) error {
+	ext := NormalizeExtension(value)
+	if ext == "" || strings.ContainsAny(ext, "./\\ ") {
+		return fmt.Errorf("%q is not a file extension", value)
+	}
+	return nil
+}
+
+func checkTTL(value string) error {
+	_, err := ParseTTL(value)
+	return err
+}
+
+func checkSocketMode(value string) error {
+	_, err := ParseSocketMode(value)
+	return err
+}
+
+func checkHTTPURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", value)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package config
+
+import "strings"
+
+// maskVisible is how many trailing characters of a secret stay readable
+const maskVisible = 4
+
+// IsSecretKey reports whether a config key holds a credential: everything under
+// auth.* plus any *_secret or *.api_key key
+func IsSecretKey(key string) bool {
+	return strings.HasPrefix(key, "auth.") || strings.HasSuffix(key, "_secret") || strings.HasSuffix(key, ".api_key")
+}
+
+// MaskValue hides all but the last four characters of a secret, e.g. "****1234".
+// Short values are hidden completely; empty values stay empty.
+func MaskValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) <= maskVisible {
+		return "****"
+	}
+	return "****" + value[len(value)-maskVisible:]
+}
+
+// MaskConfigValue masks value when key is a secret key
+func MaskConfigValue(key, value string) string {
+	if IsSecretKey(key) {
+		return MaskValue(value)
+	}
+	return value
+}
+
+// MaskSecrets returns a copy of a key/value config map with secrets masked
+func MaskSecrets(values map[string]string) map[string]string {
+	masked := make(map[string]string, len(values))
+	for k, v := range values {
+		masked[k] = MaskConfigValue(k, v)
+	}
+	return masked
+}
+
+// Masked returns a copy of the config with credentials masked
+func (c *Config) Masked() *Config {
+	masked := *c
+	masked.Auth = AuthConfig{
+		APIKey:        MaskValue(c.Auth.APIKey),
+		AdminUsername: MaskValue(c.Auth.AdminUsername),
+		AdminPassword: MaskValue(c.Auth.AdminPassword),
+		ListPassword:  MaskValue(c.Auth.ListPassword),
+		TOTPSecret:    MaskValue(c.Auth.TOTPSecret),
+	}
+	for _, hash := range c.Auth.TOTPRecoveryCodes {
+		masked.Auth.TOTPRecoveryCodes = append(masked.Auth.TOTPRecoveryCodes, MaskValue(hash))
+	}
+	masked.Notifications.WebhookSecret = MaskValue(c.Notifications.WebhookSecret)
+	return &masked
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTL is how long an upload is kept. It is written as a plain number of hours
+// (the original format), a number with an m, h, d or w suffix, or "never".
+type TTL time.Duration
+
+// NeverExpires is the TTL of files that are kept until deleted ("never" or "0")
+const NeverExpires TTL = 0
+
+// ttlFormats describes the accepted TTL syntax for error messages
+const ttlFormats = `hours (24), a duration like 45m, 36h, 14d or 2w, or "never"`
+
+// maxTTLDuration keeps expiry times representable (about 100 years)
+const maxTTLDuration = 100 * 365 * 24 * time.Hour
+
+// ttlUnits maps TTL suffixes to their length
+var ttlUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseTTL parses a TTL: a plain number of hours, a number with an m, h, d or
+// w suffix, or "never" / "0" for NeverExpires
+func ParseTTL(value string) (TTL, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "never" || value == "0" {
+		return NeverExpires, nil
+	}
+
+	number, unit := value, time.Hour
+	if len(value) > 0 {
+		if u, ok := ttlUnits[value[len(value)-1:]]; ok {
+			number, unit = value[:len(value)-1], u
+		}
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > int(maxTTLDuration/unit) {
+		return 0, fmt.Errorf("invalid TTL %q: use %s", value, ttlFormats)
+	}
+	return TTL(time.Duration(n) * unit), nil
+}
+
+// Duration returns the TTL as a time.Duration
+func (t TTL) Duration() time.Duration {
+	return time.Duration(t)
+}
+
+// Hours returns the TTL in whole hours, rounded up, for records that store
+// hours; NeverExpires stays 0
+func (t TTL) Hours() int {
+	return int((time.Duration(t) + time.Hour - 1) / time.Hour)
+}
+
+// String renders the TTL in the largest of days, hours or minutes that
+// represents it exactly, or "never"
+func (t TTL) String() string {
+	d := time.Duration(t)
+	switch {
+	case t == NeverExpires:
+		return "never"
+	case d%(24*time.Hour) == 0:
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	}
+	return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+}
+
+// MarshalJSON writes the TTL in its string form
+func (t TTL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON reads a TTL string, or a number of hours as config files
+// written before TTL units existed have it
+func (t *TTL) UnmarshalJSON(data []byte) error {
+	var hours int
+	if err := json.Unmarshal(data, &hours); err == nil {
+		data, _ = json.Marshal(strconv.Itoa(hours))
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid TTL %s: use %s", data, ttlFormats)
+	}
+	ttl, err := ParseTTL(value)
+	if err != nil {
+		return err
+	}
+	*t = ttl
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpserver/server/config"
+	"httpserver/server/httpd"
+)
+
+// controlInfo tells local CLI commands how to reach the running server. It is
+// written next to the database (readable only by the owner) while the server runs.
+type controlInfo struct {
+	PID    int    `json:"pid"`
+	URL    string `json:"url"`
+	Socket string `json:"socket,omitempty"` // Set when the server only listens on a unix socket
+	Token  string `json:"token"`
+}
+
+func controlFilePath(dbPath string) string {
+	return dbPath + ".control"
+}
+
+// newControlToken returns a random token for the control file
+func newControlToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// localEndpoint returns how local commands reach a server listening on addrs:
+// the URL of its first TCP listener, with wildcard hosts replaced by loopback,
+// or failing that the path of its unix socket
+func localEndpoint(addrs []config.ListenAddress) (string, string) {
+	for _, addr := range addrs {
+		if addr.Network != "tcp" {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(addr.Address)
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		return "http://" + net.JoinHostPort(host, port), ""
+	}
+	return "http://localhost", addrs[0].Address
+}
+
+// controlClient returns an HTTP client for the local server, dialing socket
+// instead of the URL's host when it is set
+func controlClient(socket string, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if socket != "" {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+	return client
+}
+
+// boundAddresses returns the addresses the server actually listens on, with
+// the ports picked for server.port 0 filled in
+func boundAddresses(server *httpd.Server) []config.ListenAddress {
+	var addrs []config.ListenAddress
+	for _, addr := range server.Addrs() {
+		addrs = append(addrs, config.ListenAddress{Network: addr.Network(), Address: addr.String()})
+	}
+	return addrs
+}
+
+// writeControlFile records how to reach this server's admin API
+func writeControlFile(dbPath string, addrs []config.ListenAddress, token string) error {
+	endpoint, socket := localEndpoint(addrs)
+	info := controlInfo{
+		PID:    os.Getpid(),
+		URL:    endpoint,
+		Socket: socket,
+		Token:  token,
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(controlFilePath(dbPath), data, 0600)
+}
+
+func removeControlFile(dbPath string) {
+	os.Remove(controlFilePath(dbPath))
+}
+
+// readControlFile returns the control info of a running server, if any. The
+// recorded PID must still be alive.
+func readControlFile(dbPath string) (*controlInfo, error) {
+	data, err := os.ReadFile(controlFilePath(dbPath))
+	if err != nil {
+		return nil, err
+	}
+	var info controlInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	// Left behind by a server that crashed
+	if !processAlive(info.PID) {
+		return nil, fmt.Errorf("control file is stale (PID %d is not running)", info.PID)
+	}
+	return &info, nil
+}
+
+// controlRequest calls the running server's admin API; path is relative to
+// /api/admin, e.g. "/config"
+func controlRequest(info *controlInfo, method, path string, query url.Values, body interface{}) (map[string]interface{}, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	reqURL := info.URL + "/api/admin" + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, reqURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(httpd.ControlTokenHeader, info.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := controlClient(info.Socket, 10*time.Second).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach running server (PID %d) at %s: %w", info.PID, info.URL, err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("unexpected response from server (status %d)", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server rejected request: %v", result["message"])
+	}
+	return result, nil
+}
+
+// controlDownload streams a GET from the running server's admin API into dst.
+// There is no overall timeout since exports can be large.
+func controlDownload(info *controlInfo, path string, query url.Values, dst io.Writer) error {
+	reqURL := info.URL + "/api/admin" + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(httpd.ControlTokenHeader, info.Token)
+
+	resp, err := controlClient(info.Socket, 0).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach running server (PID %d) at %s: %w", info.PID, info.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return fmt.Errorf("server rejected request (status %d): %v", resp.StatusCode, result["message"])
+	}
+	_, err = io.Copy(dst, resp.Body)
+	return err
+}
+
+// printListening shows where the server can be reached, with example URLs.
+// Wildcard hosts are shown as loopback, since 0.0.0.0 isn't a usable address.
+func printListening(addrs []config.ListenAddress) {
+	fmt.Println()
+	fmt.Println("HTTP Image Hosting Server is listening on:")
+	for _, addr := range addrs {
+		if addr.Network == "unix" {
+			fmt.Printf("  unix socket %s\n", addr.Address)
+			continue
+		}
+		endpoint, _ := localEndpoint([]config.ListenAddress{addr})
+		host, _, _ := net.SplitHostPort(addr.Address)
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			fmt.Printf("  %s (all interfaces)\n", endpoint)
+		} else {
+			fmt.Printf("  %s\n", endpoint)
+		}
+	}
+
+	endpoint, socket := localEndpoint(addrs)
+	curl := "curl"
+	if socket != "" {
+		curl = "curl --unix-socket " + socket
+	}
+	fmt.Println()
+	fmt.Printf("  Upload:    %s -H \"X-API-Key: <key>\" -F file=@image.png %s/upload\n", curl, endpoint)
+	fmt.Printf("  File list: %s/list.html\n", endpoint)
+	fmt.Printf("  Health:    %s/health\n", endpoint)
+	fmt.Println()
+}
+
+// printHTTPSListening shows the HTTPS URLs of a server using ACME certificates
+func printHTTPSListening(domains []string, port int) {
+	fmt.Println()
+	fmt.Println("HTTP Image Hosting Server is listening on:")
+	var first string
+	for _, domain := range domains {
+		domain = strings.TrimSpace(domain)
+		if domain == "" {
+			continue
+		}
+		endpoint := "https://" + domain
+		if port != 443 {
+			endpoint += ":" + strconv.Itoa(port)
+		}
+		if first == "" {
+			first = endpoint
+		}
+		fmt.Printf("  %s\n", endpoint)
+	}
+	fmt.Println()
+	fmt.Printf("  Upload:    curl -H \"X-API-Key: <key>\" -F file=@image.png %s/upload\n", first)
+	fmt.Printf("  File list: %s/list.html\n", first)
+	fmt.Printf("  Health:    %s/health\n", first)
+	fmt.Println()
+}
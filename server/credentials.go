@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"httpserver/server/config"
+	"httpserver/server/db"
+)
+
+// credentialKeys are the secrets a fresh database is given the same value for
+// on every install
+var credentialKeys = []string{"auth.api_key", "auth.admin_password", "auth.list_password"}
+
+// defaultCredentials returns the keys in credentialKeys still set to their
+// built-in defaults
+func defaultCredentials(cfg *config.Config) []string {
+	values := map[string]string{
+		"auth.api_key":        cfg.Auth.APIKey,
+		"auth.admin_password": cfg.Auth.AdminPassword,
+		"auth.list_password":  cfg.Auth.ListPassword,
+	}
+	var keys []string
+	for _, key := range credentialKeys {
+		if values[key] == db.DefaultConfigValue(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// warnDefaultCredentials logs which credentials are still the defaults shipped
+// with every install, and the commands that change them
+func warnDefaultCredentials(keys []string, dbFlag string) {
+	command := "httpserver set"
+	if dbFlag != "" {
+		command += " -c " + dbFlag
+	}
+	var b strings.Builder
+	b.WriteString("WARNING: the server is using default credentials that anyone can look up.\n")
+	b.WriteString("Until they are changed, anyone who can reach it can upload, list and delete files.\n")
+	b.WriteString("Change them with:\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "    %s %s <new-value>\n", command, key)
+	}
+	b.WriteString("Set security.require_non_default_credentials to true to refuse to start until they are changed.")
+	for _, line := range strings.Split(b.String(), "\n") {
+		log.Print(line)
+	}
+}
+
+// randomSecret returns n random bytes, hex encoded
+func randomSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// generateAPIKey stores a new random API key and prints it; it isn't shown again
+func generateAPIKey(database *db.Database) (string, error) {
+	key, err := randomSecret(24)
+	if err != nil {
+		return "", err
+	}
+	if err := database.SetConfig("auth.api_key", key); err != nil {
+		return "", err
+	}
+	fmt.Printf("Generated API key: %s\n", key)
+	fmt.Println("Clients send it in the X-API-Key header; store it somewhere safe.")
+	return key, nil
+}
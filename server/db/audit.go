@@ -0,0 +1,88 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// maxAuditEntries bounds the stored audit trail; the oldest entries are dropped first
+const maxAuditEntries = 5000
+
+// AuditEntry records one admin or destructive action
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	RemoteIP string    `json:"remote_ip"`
+	Identity string    `json:"identity"` // Who made the request, e.g. admin:alice, api-key, cli
+	Action   string    `json:"action"`   // What was done, e.g. config.set, file.delete
+	Target   string    `json:"target,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	Success  bool      `json:"success"`
+}
+
+// AuditFilter selects entries from the audit trail. Zero values match everything.
+type AuditFilter struct {
+	Action string    // Exact action, or a prefix ending in "." such as "config."
+	From   time.Time // Inclusive
+	To     time.Time // Exclusive
+	Offset int
+	Limit  int
+}
+
+// AppendAudit adds an entry to the audit trail
+func (d *Database) AppendAudit(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.data.AuditLog = append(d.data.AuditLog, entry)
+	if excess := len(d.data.AuditLog) - maxAuditEntries; excess > 0 {
+		d.data.AuditLog = append([]AuditEntry(nil), d.data.AuditLog[excess:]...)
+	}
+	d.triggerSave()
+
+	return nil
+}
+
+// GetAuditLog returns the entries matching filter, newest first, and the total
+// number of matches before paging
+func (d *Database) GetAuditLog(filter AuditFilter) ([]AuditEntry, int) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	entries := []AuditEntry{}
+	total := 0
+	for i := len(d.data.AuditLog) - 1; i >= 0; i-- {
+		entry := d.data.AuditLog[i]
+		if !filter.matches(entry) {
+			continue
+		}
+		total++
+		if total <= filter.Offset || (filter.Limit > 0 && len(entries) >= filter.Limit) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			if !strings.HasPrefix(entry.Action, f.Action) {
+				return false
+			}
+		} else if entry.Action != f.Action {
+			return false
+		}
+	}
+	if !f.From.IsZero() && entry.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
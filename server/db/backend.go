@@ -0,0 +1,383 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"httpserver/server/logging"
+)
+
+// Driver names accepted by the database.driver config key
+const (
+	DriverJSON   = "json"
+	DriverSQLite = "sqlite"
+)
+
+// Backend persists the database contents. The Database keeps every record in
+// memory and serves reads from there; a backend only has to load the data once
+// and write changes back. Record-level calls happen as each change is made so a
+// backend can write them through; Flush persists everything else (and, for
+// backends without record-level writes, the records too).
+type Backend interface {
+	// Name returns the driver name
+	Name() string
+	// Load fills data from storage; missing storage leaves data untouched
+	Load(data *DatabaseData) error
+	// PutFile stores a new or modified file record
+	PutFile(meta *FileMetadata) error
+	// DeleteFile removes a file record
+	DeleteFile(id int64) error
+	// PutConfig stores a configuration value
+	PutConfig(key, value string) error
+	// DeleteConfig removes a configuration value
+	DeleteConfig(key string) error
+	// Flush persists the complete state
+	Flush(data *DatabaseData) error
+	// Close releases the storage
+	Close() error
+}
+
+// jsonBackend stores the whole database as a single JSON document. Record-level
+// changes are appended to a write-ahead log (<file>.wal) and fsynced, so changes
+// made since the last snapshot survive a crash; Load replays the log on top of the
+// snapshot and Flush truncates it once a new snapshot is safely on disk.
+type jsonBackend struct {
+	filePath string
+	wal      *os.File
+}
+
+// walRecord is one line of the write-ahead log
+type walRecord struct {
+	Op    string        `json:"op"`
+	File  *FileMetadata `json:"file,omitempty"`
+	ID    int64         `json:"id,omitempty"`
+	Key   string        `json:"key,omitempty"`
+	Value string        `json:"value,omitempty"`
+}
+
+// Write-ahead log operations
+const (
+	walPutFile      = "put_file"
+	walDeleteFile   = "delete_file"
+	walSetConfig    = "set_config"
+	walDeleteConfig = "delete_config"
+)
+
+func newJSONBackend(filePath string) *jsonBackend {
+	return &jsonBackend{filePath: filePath}
+}
+
+func (b *jsonBackend) walPath() string {
+	return b.filePath + ".wal"
+}
+
+func (b *jsonBackend) Name() string { return DriverJSON }
+
+func (b *jsonBackend) Load(data *DatabaseData) error {
+	raw, err := os.ReadFile(b.filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		// An empty or unparseable file is never a valid database; starting empty
+		// would silently drop every record and reset the config
+		if len(raw) == 0 {
+			return &CorruptError{Path: b.filePath, Err: fmt.Errorf("file is empty")}
+		}
+		if !utf8.Valid(raw) {
+			return &CorruptError{Path: b.filePath, Err: fmt.Errorf("file contains invalid UTF-8")}
+		}
+		if err := json.Unmarshal(raw, data); err != nil {
+			return &CorruptError{Path: b.filePath, Err: err}
+		}
+	}
+
+	replayed, err := b.replayWAL(data)
+	if err != nil {
+		return err
+	}
+	if replayed > 0 {
+		logging.Info("Replayed write-ahead log records", logging.Fields{"records": replayed, "path": b.walPath()})
+	}
+	return nil
+}
+
+// replayWAL applies logged changes on top of the loaded snapshot. A torn final
+// line (from a crash mid-append) is ignored.
+func (b *jsonBackend) replayWAL(data *DatabaseData) (int, error) {
+	f, err := os.Open(b.walPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	replayed := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			logging.Warn("Skipping unreadable write-ahead log record", logging.Fields{"error": err})
+			continue
+		}
+		switch rec.Op {
+		case walPutFile:
+			if rec.File == nil {
+				continue
+			}
+			data.Files[rec.File.ID] = rec.File
+			if rec.File.ID >= data.NextID {
+				data.NextID = rec.File.ID + 1
+			}
+		case walDeleteFile:
+			delete(data.Files, rec.ID)
+		case walSetConfig:
+			data.Config[rec.Key] = rec.Value
+		case walDeleteConfig:
+			delete(data.Config, rec.Key)
+		default:
+			continue
+		}
+		replayed++
+	}
+	return replayed, scanner.Err()
+}
+
+// appendWAL writes one record to the log and fsyncs it
+func (b *jsonBackend) appendWAL(rec walRecord) error {
+	if b.wal == nil {
+		f, err := os.OpenFile(b.walPath(), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open write-ahead log: %w", err)
+		}
+		// A torn line left by a crash would swallow the next record
+		if err := endWALLine(f); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to repair write-ahead log: %w", err)
+		}
+		b.wal = f
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := b.wal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append to write-ahead log: %w", err)
+	}
+	return b.wal.Sync()
+}
+
+// endWALLine terminates a log that doesn't end with a newline, so appends start
+// on a line of their own
+func endWALLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = f.Write([]byte{'\n'})
+	return err
+}
+
+func (b *jsonBackend) PutFile(meta *FileMetadata) error {
+	return b.appendWAL(walRecord{Op: walPutFile, File: meta})
+}
+
+func (b *jsonBackend) DeleteFile(id int64) error {
+	return b.appendWAL(walRecord{Op: walDeleteFile, ID: id})
+}
+
+func (b *jsonBackend) PutConfig(key, value string) error {
+	return b.appendWAL(walRecord{Op: walSetConfig, Key: key, Value: value})
+}
+
+func (b *jsonBackend) DeleteConfig(key string) error {
+	return b.appendWAL(walRecord{Op: walDeleteConfig, Key: key})
+}
+
+// Flush writes a snapshot via an fsynced temporary file, then truncates the log.
+// The snapshot is compact JSON: indenting large databases costs about twice the
+// memory and a third more CPU on every save.
+func (b *jsonBackend) Flush(data *DatabaseData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal database: %w", err)
+	}
+
+	// Write to temporary file first
+	tempPath := b.filePath + ".tmp"
+	if err := writeFileSync(tempPath, raw); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+
+	// Rename to actual file, and make the rename itself durable
+	if err := os.Rename(tempPath, b.filePath); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(b.filePath)); err != nil {
+		return fmt.Errorf("failed to sync database directory: %w", err)
+	}
+
+	// Everything in the log is now part of the snapshot
+	if b.wal != nil {
+		if err := b.wal.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate write-ahead log: %w", err)
+		}
+	} else if err := os.Remove(b.walPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove write-ahead log: %w", err)
+	}
+	return nil
+}
+
+func (b *jsonBackend) Close() error {
+	if b.wal == nil {
+		return nil
+	}
+	err := b.wal.Close()
+	b.wal = nil
+	return err
+}
+
+// writeFileSync writes data to a file and fsyncs it before closing
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir fsyncs a directory so renames within it survive a crash. Windows
+// can't open directories for syncing; its renames are durable once they return.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// isSQLitePath reports whether a database path selects the SQLite driver by extension
+func isSQLitePath(dbPath string) bool {
+	switch strings.ToLower(filepath.Ext(dbPath)) {
+	case ".sqlite", ".sqlite3":
+		return true
+	}
+	return false
+}
+
+// swapExt replaces the extension of a database path
+func swapExt(dbPath, ext string) string {
+	return strings.TrimSuffix(dbPath, filepath.Ext(dbPath)) + ext
+}
+
+// openBackend picks the storage backend for a database path. A .sqlite path selects
+// SQLite directly; a JSON path is migrated to a sibling .sqlite file once its
+// database.driver key is set to "sqlite". Either way an existing JSON database is
+// imported on first start and renamed to <name>.migrated.
+func openBackend(dbPath string) (Backend, string, error) {
+	if isSQLitePath(dbPath) {
+		legacy := swapExt(dbPath, ".db")
+		if !fileExists(dbPath) && fileExists(legacy) {
+			b, err := migrateJSONToSQLite(legacy, dbPath)
+			return b, dbPath, err
+		}
+		b, err := openSQLiteBackend(dbPath)
+		return b, dbPath, err
+	}
+
+	sqlitePath := swapExt(dbPath, ".sqlite")
+	if !fileExists(dbPath) && fileExists(sqlitePath) {
+		// Migrated on an earlier start
+		b, err := openSQLiteBackend(sqlitePath)
+		return b, sqlitePath, err
+	}
+	if configuredDriver(dbPath) == DriverSQLite {
+		if !sqliteAvailable() {
+			logging.Warn("database.driver is sqlite but SQLite support is not compiled in (rebuild with -tags sqlite); the server will not start until the driver is set back to json", nil)
+		} else {
+			b, err := migrateJSONToSQLite(dbPath, sqlitePath)
+			return b, sqlitePath, err
+		}
+	}
+
+	return newJSONBackend(dbPath), dbPath, nil
+}
+
+// configuredDriver reads database.driver from a JSON database file
+func configuredDriver(jsonPath string) string {
+	raw, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return ""
+	}
+	var partial struct {
+		Config map[string]string `json:"config"`
+	}
+	if err := json.Unmarshal(raw, &partial); err != nil {
+		return ""
+	}
+	return partial.Config["database.driver"]
+}
+
+// migrateJSONToSQLite imports a JSON database into a new SQLite database and
+// renames the JSON file so the import only ever happens once
+func migrateJSONToSQLite(jsonPath, sqlitePath string) (Backend, error) {
+	data := newDatabaseData()
+	if err := newJSONBackend(jsonPath).Load(data); err != nil {
+		return nil, fmt.Errorf("failed to read %s for migration: %w", jsonPath, err)
+	}
+
+	b, err := openSQLiteBackend(sqlitePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.importData(data); err != nil {
+		b.Close()
+		os.Remove(sqlitePath)
+		return nil, fmt.Errorf("failed to migrate %s: %w", jsonPath, err)
+	}
+	if err := os.Rename(jsonPath, jsonPath+".migrated"); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("migrated %s but failed to rename it: %w", jsonPath, err)
+	}
+	os.Remove(jsonPath + ".wal")
+
+	logging.Info("Migrated file records", logging.Fields{"records": len(data.Files), "from": jsonPath, "to": sqlitePath})
+	return b, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
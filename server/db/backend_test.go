@@ -0,0 +1,190 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteDriverWithoutSupport(t *testing.T) {
+	if sqliteAvailable() {
+		t.Skip("built with SQLite support")
+	}
+
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := d.SetConfig("database.driver", DriverSQLite); !errors.Is(err, ErrSQLiteUnavailable) {
+		t.Errorf("SetConfig(database.driver, sqlite) = %v, want ErrSQLiteUnavailable", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Set by hand, or by a SQLite build that never got to migrate
+	data := readSnapshot(t, path)
+	data.Config["database.driver"] = DriverSQLite
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer d.Close()
+	if err := d.CheckDriver(); !errors.Is(err, ErrSQLiteUnavailable) {
+		t.Errorf("CheckDriver() = %v, want ErrSQLiteUnavailable", err)
+	}
+	if got := d.GetConfig("database.driver"); got != DriverSQLite {
+		t.Errorf("database.driver = %q, want the configured sqlite kept", got)
+	}
+
+	if err := d.SetConfig("database.driver", DriverJSON); err != nil {
+		t.Fatalf("SetConfig(database.driver, json): %v", err)
+	}
+	if err := d.CheckDriver(); err != nil {
+		t.Errorf("CheckDriver() after switching back to json = %v", err)
+	}
+}
+
+// crashedBackend writes a snapshot holding the first files, then logs the rest
+// without a Flush or Close, as if the process died before its next save
+func crashedBackend(t *testing.T, path string, snapshot, logged []*FileMetadata) *jsonBackend {
+	t.Helper()
+	b := newJSONBackend(path)
+	t.Cleanup(func() { b.Close() })
+
+	data := newDatabaseData()
+	for _, meta := range snapshot {
+		data.Files[meta.ID] = meta
+		data.NextID = meta.ID + 1
+	}
+	if err := b.Flush(data); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	for _, meta := range logged {
+		if err := b.PutFile(meta); err != nil {
+			t.Fatalf("PutFile: %v", err)
+		}
+	}
+	return b
+}
+
+// numberedFiles returns records with IDs from..to-1
+func numberedFiles(from, to int) []*FileMetadata {
+	var files []*FileMetadata
+	for n := from; n < to; n++ {
+		meta := testFile("20240101", n, "")
+		meta.ID = int64(n)
+		files = append(files, meta)
+	}
+	return files
+}
+
+func TestWALReplayAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	b := crashedBackend(t, path, numberedFiles(1, 4), numberedFiles(4, 10))
+	for _, step := range []error{
+		b.DeleteFile(2),
+		b.PutConfig("test.kept", "value"),
+		b.PutConfig("test.removed", "value"),
+		b.DeleteConfig("test.removed"),
+	} {
+		if step != nil {
+			t.Fatal(step)
+		}
+	}
+
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer d.Close()
+
+	for _, meta := range numberedFiles(1, 10) {
+		got, _ := d.GetFileMetadata(meta.FilePath)
+		if meta.ID == 2 {
+			if got != nil {
+				t.Errorf("file %d was deleted in the log but came back", meta.ID)
+			}
+			continue
+		}
+		if got == nil || got.ID != meta.ID {
+			t.Errorf("file %d is missing after replay (got %+v)", meta.ID, got)
+		}
+	}
+	if got := d.GetConfig("test.kept"); got != "value" {
+		t.Errorf("test.kept = %q, want \"value\"", got)
+	}
+	if _, ok := d.GetAllConfig()["test.removed"]; ok {
+		t.Errorf("test.removed was deleted in the log but came back")
+	}
+
+	// New records don't reuse IDs that only the log knew about
+	meta := testFile("20240102", 1, "")
+	if err := d.SaveFileMetadata(meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != 10 {
+		t.Errorf("next record got ID %d, want 10", meta.ID)
+	}
+}
+
+func TestWALTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	crashedBackend(t, path, nil, numberedFiles(1, 4))
+
+	// The process died partway through appending the next record
+	line, err := json.Marshal(walRecord{Op: walPutFile, File: numberedFiles(4, 5)[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(line[:len(line)/2])
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := newDatabaseData()
+	if err := newJSONBackend(path).Load(data); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(data.Files) != 3 {
+		t.Fatalf("replayed %d files, want the 3 written before the torn record", len(data.Files))
+	}
+	for id := int64(1); id <= 3; id++ {
+		if data.Files[id] == nil {
+			t.Errorf("file %d is missing after replay", id)
+		}
+	}
+
+	// Records logged after recovery aren't glued to the torn line
+	b := newJSONBackend(path)
+	defer b.Close()
+	if err := b.Load(newDatabaseData()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PutFile(numberedFiles(5, 6)[0]); err != nil {
+		t.Fatal(err)
+	}
+	data = newDatabaseData()
+	if err := newJSONBackend(path).Load(data); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if data.Files[5] == nil {
+		t.Errorf("record logged after the torn line was lost")
+	}
+}
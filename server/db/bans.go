@@ -0,0 +1,155 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ban blocks an IP address or CIDR range
+type Ban struct {
+	Target    string     `json:"target"` // IP address or CIDR range
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil bans permanently
+	CreatedBy string     `json:"created_by"`
+}
+
+// IsExpired reports whether a temporary ban has run out
+func (b *Ban) IsExpired() bool {
+	return b.ExpiresAt != nil && !time.Now().Before(*b.ExpiresAt)
+}
+
+// Matches reports whether ip falls under the ban
+func (b *Ban) Matches(ip net.IP) bool {
+	if strings.Contains(b.Target, "/") {
+		_, network, err := net.ParseCIDR(b.Target)
+		return err == nil && network.Contains(ip)
+	}
+	banned := net.ParseIP(b.Target)
+	return banned != nil && banned.Equal(ip)
+}
+
+// NormalizeBanTarget validates an IP address or CIDR range and returns it in
+// canonical form
+func NormalizeBanTarget(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if strings.Contains(target, "/") {
+		_, network, err := net.ParseCIDR(target)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR range %q", target)
+		}
+		return network.String(), nil
+	}
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", target)
+	}
+	return ip.String(), nil
+}
+
+// AddBan stores a ban, replacing any existing ban on the same target
+func (d *Database) AddBan(ban Ban) error {
+	if d.readOnly {
+		return fmt.Errorf("database is open read-only")
+	}
+	target, err := NormalizeBanTarget(ban.Target)
+	if err != nil {
+		return err
+	}
+	ban.Target = target
+	if ban.CreatedAt.IsZero() {
+		ban.CreatedAt = time.Now()
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.removeBanLocked(target)
+	d.data.Bans = append(d.data.Bans, &ban)
+	return d.save()
+}
+
+// RemoveBan lifts the ban on target and reports whether there was one
+func (d *Database) RemoveBan(target string) (bool, error) {
+	if d.readOnly {
+		return false, fmt.Errorf("database is open read-only")
+	}
+	target, err := NormalizeBanTarget(target)
+	if err != nil {
+		return false, err
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if !d.removeBanLocked(target) {
+		return false, nil
+	}
+	return true, d.save()
+}
+
+// removeBanLocked deletes the ban on target (caller must hold the write lock)
+func (d *Database) removeBanLocked(target string) bool {
+	for i, ban := range d.data.Bans {
+		if ban.Target == target {
+			d.data.Bans = append(d.data.Bans[:i:i], d.data.Bans[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// FindBan returns the active ban covering ip, or nil
+func (d *Database) FindBan(ip net.IP) *Ban {
+	if ip == nil {
+		return nil
+	}
+
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	for _, ban := range d.data.Bans {
+		if !ban.IsExpired() && ban.Matches(ip) {
+			copied := *ban
+			return &copied
+		}
+	}
+	return nil
+}
+
+// ListBans returns the active bans, newest first
+func (d *Database) ListBans() []Ban {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	bans := []Ban{}
+	for _, ban := range d.data.Bans {
+		if !ban.IsExpired() {
+			bans = append(bans, *ban)
+		}
+	}
+	sort.SliceStable(bans, func(i, j int) bool { return bans[i].CreatedAt.After(bans[j].CreatedAt) })
+	return bans
+}
+
+// PruneBans deletes expired bans and returns how many were removed
+func (d *Database) PruneBans() (int, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	kept := d.data.Bans[:0]
+	for _, ban := range d.data.Bans {
+		if !ban.IsExpired() {
+			kept = append(kept, ban)
+		}
+	}
+	removed := len(d.data.Bans) - len(kept)
+	d.data.Bans = kept
+	if removed == 0 || d.readOnly {
+		return removed, nil
+	}
+	return removed, d.save()
+}
@@ -1,44 +1,130 @@
 package db
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"httpserver/server/config"
+	"httpserver/server/logging"
 )
 
-// Database handles all file metadata operations using JSON storage
+// Database handles all file metadata operations. Records are held in memory and
+// persisted through a Backend (a JSON file by default, or SQLite).
 type Database struct {
 	filePath   string
+	backend    Backend
+	lock       *dbLock
+	readOnly   bool
 	data       *DatabaseData
+	index      *fileIndex // Secondary lookups over data.Files, guarded by mux
+	changes    uint64            // Count of file changes, guarded by mux
+	dateGens   map[string]uint64 // Date directory -> changes at its last file change, guarded by mux
+	genEpoch   uint64            // changes when every date last changed at once (import)
 	mux        sync.RWMutex
 	autoSave   chan struct{}
+	done       chan struct{}  // Closed by Close to stop the auto-save loop
+	stopOnce   sync.Once
+	saver      sync.WaitGroup // The auto-save loop, while it runs
+	saveMux    sync.Mutex     // Serializes writes to the backend; saves may run under the read lock
+	dirty      uint32         // 1 while data has changes the last save doesn't have (atomic)
+
+	saveStatusMux sync.Mutex
+	lastSaveAt    time.Time // Time of the last successful save
+	lastSaveErr   error     // Error from the most recent save attempt, if it failed
 }
 
 // DatabaseData represents the complete database structure
 type DatabaseData struct {
-	Files       map[int64]*FileMetadata `json:"files"`
-	NextID      int64                   `json:"next_id"`
-	Config      map[string]string        `json:"config"`
+	Files          map[int64]*FileMetadata `json:"files"`
+	NextID         int64                   `json:"next_id"`
+	Config         map[string]string       `json:"config"`
+	CleanupReports []CleanupReport         `json:"cleanup_reports,omitempty"`
+	VerifyReports  []VerifyReport          `json:"verify_reports,omitempty"`
+	AuditLog       []AuditEntry            `json:"audit_log,omitempty"`
+	APITokens      []*APIToken             `json:"api_tokens,omitempty"`
+	UploadUsage    []UsageBucket           `json:"upload_usage,omitempty"`
+	Bans           []*Ban                  `json:"bans,omitempty"`
+	IdempotencyKeys []*IdempotencyRecord   `json:"idempotency_keys,omitempty"`
+
+	ReplicationQueue []*ReplicationItem `json:"replication_queue,omitempty"`
+}
+
+// CleanupReport records the outcome of one cleanup run
+type CleanupReport struct {
+	StartedAt    time.Time `json:"started_at"`
+	DurationMs   int64     `json:"duration_ms"`
+	FilesDeleted int       `json:"files_deleted"`
+	BytesFreed   int64     `json:"bytes_freed"`
+	Errors       []string  `json:"errors,omitempty"`
+}
+
+// VerifyReport records the summary of one integrity verification run
+type VerifyReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Checked    int       `json:"checked"`
+	Mismatched int       `json:"mismatched"`
+	Unreadable int       `json:"unreadable"`
+	Cancelled  bool      `json:"cancelled"`
 }
 
+// maxCleanupReports bounds the stored cleanup and verify history
+const maxCleanupReports = 100
+
 // FileMetadata represents metadata for a stored file
 type FileMetadata struct {
-	ID           int64     `json:"id"`
-	FileName     string    `json:"file_name"`      // Generated filename
-	OriginalName string    `json:"original_name"`  // Original filename
-	FilePath     string    `json:"file_path"`      // Relative path from Images root
-	FileSize     int64     `json:"file_size"`
-	UploadedAt   time.Time `json:"uploaded_at"`
-	ExpiresAt    time.Time `json:"expires_at"`
-	TTL          int       `json:"ttl"`
-	RemoteIP     string    `json:"remote_ip"`
+	ID           int64      `json:"id"`
+	FileName     string     `json:"file_name"`     // Generated filename
+	OriginalName string     `json:"original_name"` // Original filename
+	FilePath     string     `json:"file_path"`     // Relative path from Images root
+	FileSize     int64      `json:"file_size"`
+	UploadedAt   time.Time  `json:"uploaded_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	TTL          int        `json:"ttl"`
+	RemoteIP     string     `json:"remote_ip"`
+	Slug         string     `json:"slug,omitempty"`       // Optional memorable alias served via /s/{slug}
+	Tag          string     `json:"tag,omitempty"`        // Optional album/group name
+	WarnedAt     *time.Time `json:"warned_at,omitempty"`  // When an expiry warning was sent
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"` // When the file was moved to the trash
+	Hash         string     `json:"hash,omitempty"`       // SHA-256 of the content (hex)
+	// RetentionRule names the storage.retention_rules entry that set the TTL
+	RetentionRule string `json:"retention_rule,omitempty"`
+}
+
+// IsPinned reports whether the file never expires
+func (m *FileMetadata) IsPinned() bool {
+	return m.ExpiresAt.IsZero()
+}
+
+// IsExpired reports whether the file's TTL has passed at now. Expired files
+// are kept until the next cleanup run removes them.
+func (m *FileMetadata) IsExpired(now time.Time) bool {
+	return !m.IsPinned() && !m.ExpiresAt.After(now)
 }
 
+// IsDeleted reports whether the file has been moved to the trash
+func (m *FileMetadata) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// TagCount represents a distinct tag and how many files carry it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ErrSlugTaken is returned when a slug is already owned by a non-expired file
+var ErrSlugTaken = errors.New("slug is already in use")
+
 var globalDB *Database
 
 // Default configuration values
@@ -55,125 +141,448 @@ const (
 	defaultAdminPass     = "490003219"
 	defaultListPass      = "490003219"
 	defaultIPWhitelist   = ""
+	defaultTrustedProxies = "127.0.0.1,::1"
 	defaultRateLimit    = 60
 	defaultSessionTimeout = 300
+	defaultSessionAbsoluteTimeout = 86400 // 1 day
+	defaultMaxArchiveSize = 2 * 1024 * 1024 * 1024 // 2GB
+	defaultNotifyEvents   = "upload,delete,cleanup,expiring"
+	defaultOrphanGraceHours = 24
+	defaultResumableExpiry  = 24
+	defaultIdempotencyWindow = 24
+	defaultVerifyReadRateMB = 20
+	defaultMinFreeDiskMB    = 100
+	defaultConcurrencyWait  = 5
+	defaultBackupInterval   = 24
+	defaultBackupKeepCount  = 7
+	defaultAutobanWindow    = 10
+	defaultAutobanDuration  = 60
+	defaultMaxRestartCount  = 10
+	defaultLogMaxSizeMB     = 100
+	defaultLogMaxBackups    = 5
 )
 
-// Open opens the database connection and initializes storage
+// Open opens the database connection and initializes storage. It takes an
+// exclusive lock, failing with a *LockedError if another process holds the database.
 func Open(dbPath string) (*Database, error) {
+	return open(dbPath, false)
+}
+
+// OpenReadOnly opens the database for reading under a shared lock, so it can be
+// used alongside other readers but not while a server or writer holds it. Nothing
+// is written back, and Close does not save.
+func OpenReadOnly(dbPath string) (*Database, error) {
+	return open(dbPath, true)
+}
+
+func open(dbPath string, readOnly bool) (*Database, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
+	lock, err := acquireLock(dbPath, !readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, storePath, err := openBackend(dbPath)
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+
 	database := &Database{
-		filePath: dbPath,
-		data: &DatabaseData{
-			Files:  make(map[int64]*FileMetadata),
-			NextID: 1,
-			Config: make(map[string]string),
-		},
+		filePath: storePath,
+		backend:  backend,
+		lock:     lock,
+		readOnly: readOnly,
+		data:     newDatabaseData(),
+		dateGens: make(map[string]uint64),
 		autoSave: make(chan struct{}, 1),
+		done:     make(chan struct{}),
 	}
 
-	// Load existing data if file exists
-	if _, err := os.Stat(dbPath); err == nil {
-		data, err := os.ReadFile(dbPath)
-		if err == nil {
-			if err := json.Unmarshal(data, database.data); err == nil {
-				// Successfully loaded
-			}
-		}
+	// Load existing data
+	if err := backend.Load(database.data); err != nil {
+		backend.Close()
+		lock.release()
+		return nil, fmt.Errorf("failed to load database: %w", err)
 	}
 
-	// Initialize default config if not exists
-	if len(database.data.Config) == 0 {
-		database.initDefaultConfig()
+	// Build in-memory indexes over the loaded records
+	database.index = newFileIndex(database.data.Files)
+	if !readOnly {
+		database.normalizeRemoteIPs()
+	}
+
+	// Initialize default config for any missing keys
+	database.initDefaultConfig()
+	if database.data.Config["database.driver"] != backend.Name() && !database.sqliteMissing() {
+		database.data.Config["database.driver"] = backend.Name()
+		database.configChanged("database.driver")
 	}
 
 	// Start auto-save goroutine
-	go database.autoSaveLoop()
+	if !readOnly {
+		database.saver.Add(1)
+		go database.autoSaveLoop()
+	}
 
 	globalDB = database
 	return database, nil
 }
 
-// initDefaultConfig initializes default configuration values
-func (d *Database) initDefaultConfig() {
-	d.data.Config = map[string]string{
+// normalizeRemoteIPs rewrites uploader addresses stored before they were
+// normalized, which kept the port and any IPv6 brackets. Once done, later
+// opens find nothing to change. Values that aren't addresses are kept.
+func (d *Database) normalizeRemoteIPs() {
+	changed := 0
+	for _, meta := range d.data.Files {
+		ip := NormalizeIP(meta.RemoteIP)
+		if ip == "" || ip == meta.RemoteIP {
+			continue
+		}
+		meta.RemoteIP = ip
+		if err := d.fileChanged(meta); err != nil {
+			logging.Warn("Failed to save normalized uploader address", logging.Fields{"path": meta.FilePath, "error": err})
+			continue
+		}
+		changed++
+	}
+	if changed > 0 {
+		logging.Info("Normalized stored uploader addresses", logging.Fields{"records": changed})
+	}
+}
+
+// newDatabaseData returns an empty database
+func newDatabaseData() *DatabaseData {
+	return &DatabaseData{
+		Files:  make(map[int64]*FileMetadata),
+		NextID: 1,
+		Config: make(map[string]string),
+	}
+}
+
+// Path returns the path of the underlying storage file
+func (d *Database) Path() string {
+	return d.filePath
+}
+
+// CheckDriver reports whether the configured database.driver is the backend in
+// use. It only fails when the driver is sqlite in a build without SQLite support;
+// the setting is kept so the database is migrated once a SQLite build starts.
+func (d *Database) CheckDriver() error {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	if d.sqliteMissing() {
+		return fmt.Errorf("database.driver is sqlite but %w, or run \"httpserver set database.driver json\"", ErrSQLiteUnavailable)
+	}
+	return nil
+}
+
+// sqliteMissing reports whether SQLite was configured but couldn't be opened
+func (d *Database) sqliteMissing() bool {
+	return d.data.Config["database.driver"] == DriverSQLite && d.backend.Name() != DriverSQLite && !sqliteAvailable()
+}
+
+// defaultConfig returns the built-in default configuration values
+func defaultConfig() map[string]string {
+	return map[string]string{
+		"database.driver":              DriverJSON,
 		"server.host":                  defaultServerHost,
 		"server.port":                  strconv.Itoa(defaultServerPort),
+		"server.min_free_disk_mb":      strconv.Itoa(defaultMinFreeDiskMB),
+		"server.max_concurrent_uploads":   "0",
+		"server.max_concurrent_downloads": "0",
+		"server.concurrency_wait_seconds": strconv.Itoa(defaultConcurrencyWait),
+		"server.pid_file":                 "",
+		"server.listen":                   "",
+		"server.socket_mode":              "0660",
+		"server.socket_group":             "",
+		"server.read_only":                "false",
+		"server.maintenance_message":      "",
+		"server.enable_compression":       "true",
+		"server.templates_dir":            "",
+		"server.allow_indexing":           "false",
+		"server.acme_domains":             "",
+		"server.acme_email":               "",
+		"server.https_port":               "443",
+		"server.http_port":                "80",
+		"server.redirect_http":            "true",
 		"storage.images_dir":           defaultImagesDir,
 		"storage.max_file_size":         strconv.FormatInt(defaultMaxFileSize, 10),
 		"storage.cleanup_interval":      strconv.Itoa(defaultCleanupInterval),
 		"storage.default_ttl":           strconv.Itoa(defaultDefaultTTL),
 		"storage.max_ttl":               strconv.Itoa(defaultMaxTTL),
+		"storage.max_archive_size":      strconv.FormatInt(defaultMaxArchiveSize, 10),
+		"storage.trash_retention_hours": "0",
+		"storage.allow_permanent":       "false",
+		"storage.retention_rules":       "",
+		"storage.path_layout":           "daily",
+		"storage.preserve_original_name": "false",
+		"storage.name_entropy_bytes":    "16",
+		"storage.name_style":            "hex",
+		"storage.orphan_grace_hours":    strconv.Itoa(defaultOrphanGraceHours),
+		"storage.resumable_expiry_hours": strconv.Itoa(defaultResumableExpiry),
+		"storage.idempotency_window_hours": strconv.Itoa(defaultIdempotencyWindow),
+		"storage.inline_extensions":        "",
+		"storage.force_download_extensions": "",
+		"storage.verify_read_rate_mb":   strconv.Itoa(defaultVerifyReadRateMB),
+		"storage.download_rate_limit_kbps":        "0",
+		"storage.global_download_rate_limit_kbps": "0",
+		"storage.backend":                         "local",
+		"storage.s3_endpoint":                     "",
+		"storage.s3_bucket":                       "",
+		"storage.s3_region":                       "us-east-1",
+		"storage.s3_access_key":                   "",
+		"storage.s3_secret":                       "",
+		"storage.s3_prefix":                       "",
+		"storage.s3_path_style":                   "true",
 		"auth.api_key":                 defaultAPIKey,
 		"auth.admin_username":           defaultAdminUser,
 		"auth.admin_password":           defaultAdminPass,
 		"auth.list_password":            defaultListPass,
+		"auth.totp_secret":              "",
+		"auth.totp_recovery_codes":      "",
 		"security.ip_whitelist":         defaultIPWhitelist,
+		"security.trusted_proxies":      defaultTrustedProxies,
 		"security.rate_limit_per_minute": strconv.Itoa(defaultRateLimit),
 		"security.session_timeout":       strconv.Itoa(defaultSessionTimeout),
+		"security.session_absolute_timeout": strconv.Itoa(defaultSessionAbsoluteTimeout),
+		"security.allow_remember_me":      "false",
+		"security.cookie_secure":          "auto",
+		"security.cookie_samesite_strict": "false",
+		"security.allow_inline_html":      "false",
+		"security.upload_quota_per_day_bytes": "0",
+		"security.autoban_threshold":           "0",
+		"security.autoban_window_minutes":      strconv.Itoa(defaultAutobanWindow),
+		"security.autoban_duration_minutes":    strconv.Itoa(defaultAutobanDuration),
+		"security.hsts_max_age":               "0",
+		"security.require_non_default_credentials": "false",
+		"notifications.webhook_url":     "",
+		"notifications.webhook_secret":  "",
+		"notifications.events":          defaultNotifyEvents,
+		"notifications.expiry_warning_hours": "0",
+		"replication.target_url":             "",
+		"replication.api_key":                "",
+		"backup.interval_hours":        strconv.Itoa(defaultBackupInterval),
+		"backup.dir":                   "",
+		"backup.keep_count":            strconv.Itoa(defaultBackupKeepCount),
+		"logging.file":                 "",
+		"logging.max_size_mb":          strconv.Itoa(defaultLogMaxSizeMB),
+		"logging.max_backups":          strconv.Itoa(defaultLogMaxBackups),
+		"logging.stdout":               "true",
+		"logging.format":               "text",
+		"logging.access_log":           "false",
+		"auto_restart.enabled":         "true",
+		"auto_restart.max_restart_count": strconv.Itoa(defaultMaxRestartCount),
 	}
-	d.triggerSave()
 }
 
-// Close closes the database and saves to disk
+// DefaultConfigValue returns the built-in default for a config key ("" if none)
+func DefaultConfigValue(key string) string {
+	return defaultConfig()[key]
+}
+
+// initDefaultConfig fills in default values for any missing configuration keys,
+// so databases created by older versions pick up newly added settings
+func (d *Database) initDefaultConfig() {
+	if d.data.Config == nil {
+		d.data.Config = make(map[string]string)
+	}
+
+	for key, value := range defaultConfig() {
+		if _, ok := d.data.Config[key]; !ok {
+			d.data.Config[key] = value
+			d.configChanged(key)
+		}
+	}
+}
+
+// Close stops auto-saving, saves to disk and closes the database
 func (d *Database) Close() error {
+	// A periodic save still running finishes before the final one starts
+	d.stopOnce.Do(func() { close(d.done) })
+	d.saver.Wait()
+
 	d.mux.Lock()
 	defer d.mux.Unlock()
-	return d.save()
+
+	err := d.saveIfDirty()
+	if closeErr := d.backend.Close(); err == nil {
+		err = closeErr
+	}
+	d.lock.release()
+	return err
 }
 
-// save saves the database to disk
+// save flushes the database to its backend and records the outcome
 func (d *Database) save() error {
-	data, err := json.MarshalIndent(d.data, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal database: %w", err)
+	if d.readOnly {
+		return nil
 	}
+	d.saveMux.Lock()
+	defer d.saveMux.Unlock()
+	err := d.backend.Flush(d.data)
+	if err == nil {
+		// Changes need the write lock, so none came in since the flush began
+		atomic.StoreUint32(&d.dirty, 0)
+	}
+	d.recordSave(err)
+	return err
+}
 
-	// Write to temporary file first
-	tempPath := d.filePath + ".tmp"
-	if err := os.WriteFile(tempPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write database: %w", err)
+// saveIfDirty saves unless nothing has changed since the last save, so an
+// idle database isn't rewritten
+func (d *Database) saveIfDirty() error {
+	if atomic.LoadUint32(&d.dirty) == 0 {
+		return nil
 	}
+	return d.save()
+}
 
-	// Rename to actual file
-	return os.Rename(tempPath, d.filePath)
+// recordSave records the outcome of a write for SaveStatus
+func (d *Database) recordSave(err error) {
+	d.saveStatusMux.Lock()
+	d.lastSaveErr = err
+	if err == nil {
+		d.lastSaveAt = time.Now()
+	}
+	d.saveStatusMux.Unlock()
 }
 
-// autoSaveLoop handles periodic auto-saving
+// SaveStatus returns the time of the last successful save and the error
+// from the most recent attempt (nil if it succeeded)
+func (d *Database) SaveStatus() (time.Time, error) {
+	d.saveStatusMux.Lock()
+	defer d.saveStatusMux.Unlock()
+	return d.lastSaveAt, d.lastSaveErr
+}
+
+// autoSaveLoop handles periodic auto-saving until Close
 func (d *Database) autoSaveLoop() {
+	defer d.saver.Done()
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-d.done:
+			return
 		case <-ticker.C:
 			d.mux.RLock()
-			d.save()
+			d.saveIfDirty()
 			d.mux.RUnlock()
 		case <-d.autoSave:
 			d.mux.RLock()
-			d.save()
+			d.saveIfDirty()
 			d.mux.RUnlock()
 		}
 	}
 }
 
-// triggerSave triggers an immediate save
+// triggerSave marks the data changed and triggers an immediate save
 func (d *Database) triggerSave() {
+	atomic.StoreUint32(&d.dirty, 1)
 	select {
 	case d.autoSave <- struct{}{}:
 	default:
 	}
 }
 
+// fileChanged writes a new or modified record through to the backend and
+// schedules a save (caller must hold the write lock)
+func (d *Database) fileChanged(meta *FileMetadata) error {
+	d.touchDateLocked(meta.FilePath)
+	if d.readOnly {
+		return nil
+	}
+	err := d.backend.PutFile(meta)
+	if err != nil {
+		d.recordSave(err)
+	}
+	d.triggerSave()
+	return err
+}
+
+// fileRemoved removes a record from the backend and schedules a save
+// (caller must hold the write lock)
+func (d *Database) fileRemoved(id int64) error {
+	if d.readOnly {
+		return nil
+	}
+	err := d.backend.DeleteFile(id)
+	if err != nil {
+		d.recordSave(err)
+	}
+	d.triggerSave()
+	return err
+}
+
+// touchDateLocked records a change to a file of the date directory of filePath
+// (caller must hold the write lock)
+func (d *Database) touchDateLocked(filePath string) {
+	d.changes++
+	if date := indexDate(filePath); date != "" {
+		d.dateGens[date] = d.changes
+	}
+}
+
+// DateGeneration returns a number that changes whenever a file of the date
+// directory is added, modified or removed, so a cached listing of the date can
+// tell whether it is stale. The numbers start over when the server restarts.
+func (d *Database) DateGeneration(date string) uint64 {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	if gen, ok := d.dateGens[date]; ok {
+		return gen
+	}
+	return d.genEpoch
+}
+
+// configChanged writes a config value through to the backend and schedules a
+// save (caller must hold the write lock)
+func (d *Database) configChanged(key string) error {
+	if d.readOnly {
+		return nil
+	}
+	err := d.backend.PutConfig(key, d.data.Config[key])
+	if err != nil {
+		d.recordSave(err)
+	}
+	d.triggerSave()
+	return err
+}
+
+// configRemoved removes a config value from the backend and schedules a save
+// (caller must hold the write lock)
+func (d *Database) configRemoved(key string) error {
+	if d.readOnly {
+		return nil
+	}
+	err := d.backend.DeleteConfig(key)
+	if err != nil {
+		d.recordSave(err)
+	}
+	d.triggerSave()
+	return err
+}
+
 // ========== Config Management ==========
 
-// GetConfig retrieves a configuration value by key
+// ConfigChange describes one config value changed by ResetConfig
+type ConfigChange struct {
+	Key      string `json:"key"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// GetConfig retrieves a configuration value by key, falling back to the built-in
+// default for keys that aren't stored
 func (d *Database) GetConfig(key string) string {
 	d.mux.RLock()
 	defer d.mux.RUnlock()
@@ -181,17 +590,79 @@ func (d *Database) GetConfig(key string) string {
 	if val, ok := d.data.Config[key]; ok {
 		return val
 	}
-	return ""
+	return DefaultConfigValue(key)
 }
 
-// SetConfig sets a configuration value by key
+// SetConfig sets a configuration value by key. Values of known keys are validated
+// against the config schema; unknown keys are stored as-is for forward compatibility.
 func (d *Database) SetConfig(key, value string) error {
+	if err := config.ValidateValue(key, value); err != nil && !errors.Is(err, config.ErrUnknownKey) {
+		return err
+	}
+	if key == "database.driver" && value == DriverSQLite && !sqliteAvailable() {
+		return ErrSQLiteUnavailable
+	}
+
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
 	d.data.Config[key] = value
-	d.triggerSave()
-	return nil
+	return d.configChanged(key)
+}
+
+// DeleteConfig removes a configuration key and reports whether it was stored.
+// Keys with a built-in default read as that default afterwards.
+func (d *Database) DeleteConfig(key string) (bool, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if _, ok := d.data.Config[key]; !ok {
+		return false, nil
+	}
+	delete(d.data.Config, key)
+	return true, d.configRemoved(key)
+}
+
+// ResetConfig restores the built-in defaults for every key in a group ("server",
+// "auth", ...), for a single key, or for everything with "all". database.driver is
+// left alone since it records the backend in use. Only values that actually
+// changed are returned.
+func (d *Database) ResetConfig(prefix string) ([]ConfigChange, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	matched := false
+	var changes []ConfigChange
+	for key, value := range defaultConfig() {
+		if key == "database.driver" || !configKeyMatches(key, prefix) {
+			continue
+		}
+		matched = true
+		if old, ok := d.data.Config[key]; ok && old == value {
+			continue
+		}
+		changes = append(changes, ConfigChange{Key: key, OldValue: d.data.Config[key], NewValue: value})
+		d.data.Config[key] = value
+		if err := d.configChanged(key); err != nil {
+			return changes, err
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("no config keys with defaults match '%s'", prefix)
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes, nil
+}
+
+// configKeyMatches reports whether key is prefix itself or belongs to the group
+// named by prefix; "all" matches every key
+func configKeyMatches(key, prefix string) bool {
+	if prefix == "all" {
+		return true
+	}
+	prefix = strings.TrimSuffix(prefix, ".")
+	return key == prefix || strings.HasPrefix(key, prefix+".")
 }
 
 // GetAllConfig returns all configuration as a map
@@ -220,18 +691,36 @@ func (d *Database) GetConfigInt(key string) int {
 	return num
 }
 
+// GetConfigInt64 returns a configuration value as a 64-bit integer
+func (d *Database) GetConfigInt64(key string) int64 {
+	val := d.GetConfig(key)
+	if val == "" {
+		return 0
+	}
+	num, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return num
+}
+
 // SaveFileMetadata saves file metadata to the database
 func (d *Database) SaveFileMetadata(meta *FileMetadata) error {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
+	// Slugs must be unique among files that have not yet expired
+	if meta.Slug != "" && d.findSlugLocked(meta.Slug) != nil {
+		return ErrSlugTaken
+	}
+
 	meta.ID = d.data.NextID
 	d.data.NextID++
 
 	d.data.Files[meta.ID] = meta
-	d.triggerSave()
+	d.index.add(meta)
 
-	return nil
+	return d.fileChanged(meta)
 }
 
 // GetFileMetadata retrieves file metadata by path
@@ -239,12 +728,70 @@ func (d *Database) GetFileMetadata(filePath string) (*FileMetadata, error) {
 	d.mux.RLock()
 	defer d.mux.RUnlock()
 
+	if meta := d.findPathLocked(filePath); meta != nil && !meta.IsDeleted() {
+		return meta, nil
+	}
+	return nil, nil
+}
+
+// findPathLocked returns the record stored at a path, trashed or not (caller must hold the lock)
+func (d *Database) findPathLocked(filePath string) *FileMetadata {
+	id, ok := d.index.byPath[indexPath(filePath)]
+	if !ok {
+		return nil
+	}
+	return d.data.Files[id]
+}
+
+// GetFilesByHash returns the live files whose content has the given hash
+func (d *Database) GetFilesByHash(hash string) ([]*FileMetadata, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	var files []*FileMetadata
+	for id := range d.index.byHash[hash] {
+		if meta := d.data.Files[id]; meta != nil && !meta.IsDeleted() {
+			files = append(files, meta)
+		}
+	}
+	return files, nil
+}
+
+// GetFilesByRemoteIP returns every record uploaded from an IP address, including
+// trashed ones, oldest first
+func (d *Database) GetFilesByRemoteIP(ip string) ([]*FileMetadata, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	target := net.ParseIP(ip)
+	var files []*FileMetadata
 	for _, meta := range d.data.Files {
-		if meta.FilePath == filePath {
-			return meta, nil
+		stored := uploaderIP(meta.RemoteIP)
+		if stored == ip || (target != nil && target.Equal(net.ParseIP(stored))) {
+			files = append(files, meta)
 		}
 	}
-	return nil, nil
+	SortFiles(files, OrderOldest)
+	return files, nil
+}
+
+// GetFileMetadataBySlug retrieves the non-expired file metadata owning a slug
+func (d *Database) GetFileMetadataBySlug(slug string) (*FileMetadata, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	return d.findSlugLocked(slug), nil
+}
+
+// findSlugLocked returns the non-expired file using the slug (caller must hold the lock)
+func (d *Database) findSlugLocked(slug string) *FileMetadata {
+	now := time.Now()
+	for _, meta := range d.data.Files {
+		if meta.Slug == slug && (meta.IsPinned() || meta.ExpiresAt.After(now)) && !meta.IsDeleted() {
+			return meta
+		}
+	}
+	return nil
 }
 
 // GetFileMetadataByID retrieves file metadata by ID
@@ -264,14 +811,134 @@ func (d *Database) DeleteFileMetadata(filePath string) error {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 
+	if meta := d.findPathLocked(filePath); meta != nil {
+		delete(d.data.Files, meta.ID)
+		d.index.remove(meta)
+		d.touchDateLocked(meta.FilePath)
+		return d.fileRemoved(meta.ID)
+	}
+	return nil
+}
+
+// MarkFileDeleted flags file metadata as moved to the trash
+func (d *Database) MarkFileDeleted(filePath string, deletedAt time.Time) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if meta := d.findPathLocked(filePath); meta != nil && !meta.IsDeleted() {
+		t := deletedAt
+		meta.DeletedAt = &t
+		return d.fileChanged(meta)
+	}
+	return nil
+}
+
+// SetFileExpiry changes a live file's TTL and expiry time, returning nil if
+// there is no such file
+func (d *Database) SetFileExpiry(filePath string, ttl int, expiresAt time.Time) (*FileMetadata, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	meta := d.findPathLocked(filePath)
+	if meta == nil || meta.IsDeleted() {
+		return nil, nil
+	}
+	meta.TTL = ttl
+	meta.ExpiresAt = expiresAt
+	meta.WarnedAt = nil
+	if err := d.fileChanged(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// FileUpdate lists the editable fields of a file; nil fields are left alone,
+// and an empty slug or tag removes it
+type FileUpdate struct {
+	OriginalName *string
+	Slug         *string
+	Tag          *string
+}
+
+// UpdateFileMetadata applies an update to a live file, returning nil if there
+// is no such file, or ErrSlugTaken if another live file owns the new slug
+func (d *Database) UpdateFileMetadata(id int64, update FileUpdate) (*FileMetadata, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	meta, ok := d.data.Files[id]
+	if !ok || meta.IsDeleted() {
+		return nil, nil
+	}
+	if update.Slug != nil && *update.Slug != "" && *update.Slug != meta.Slug {
+		if owner := d.findSlugLocked(*update.Slug); owner != nil && owner.ID != meta.ID {
+			return nil, ErrSlugTaken
+		}
+	}
+
+	if update.OriginalName != nil {
+		meta.OriginalName = *update.OriginalName
+	}
+	if update.Slug != nil {
+		meta.Slug = *update.Slug
+	}
+	if update.Tag != nil {
+		meta.Tag = *update.Tag
+	}
+	if err := d.fileChanged(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// RestoreFileMetadata clears the trash flag on a file, returning nil if it isn't trashed.
+// A slug claimed by another file in the meantime is dropped from the restored file.
+func (d *Database) RestoreFileMetadata(filePath string) (*FileMetadata, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	meta := d.findPathLocked(filePath)
+	if meta == nil || !meta.IsDeleted() {
+		return nil, nil
+	}
+	if meta.Slug != "" && d.findSlugLocked(meta.Slug) != nil {
+		meta.Slug = ""
+	}
+	meta.DeletedAt = nil
+	if err := d.fileChanged(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// GetTrashedFiles returns trashed files deleted before the cutoff
+func (d *Database) GetTrashedFiles(before time.Time) ([]*FileMetadata, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	var trashed []*FileMetadata
+
+	for _, meta := range d.data.Files {
+		if meta.IsDeleted() && meta.DeletedAt.Before(before) {
+			trashed = append(trashed, meta)
+		}
+	}
+
+	return trashed, nil
+}
+
+// GetFilePathIndex returns a snapshot of live file paths (slash-separated) mapped to their IDs
+func (d *Database) GetFilePathIndex() map[string]int64 {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	index := make(map[string]int64, len(d.data.Files))
 	for id, meta := range d.data.Files {
-		if meta.FilePath == filePath {
-			delete(d.data.Files, id)
-			d.triggerSave()
-			return nil
+		if !meta.IsDeleted() {
+			index[filepath.ToSlash(meta.FilePath)] = id
 		}
 	}
-	return nil
+	return index
 }
 
 // GetExpiredFiles returns all files that have expired
@@ -283,7 +950,7 @@ func (d *Database) GetExpiredFiles() ([]*FileMetadata, error) {
 	var expired []*FileMetadata
 
 	for _, meta := range d.data.Files {
-		if meta.ExpiresAt.Before(now) {
+		if !meta.IsPinned() && meta.ExpiresAt.Before(now) && !meta.IsDeleted() {
 			expired = append(expired, meta)
 		}
 	}
@@ -291,18 +958,109 @@ func (d *Database) GetExpiredFiles() ([]*FileMetadata, error) {
 	return expired, nil
 }
 
-// ListFilesByDate returns all files for a specific date directory
-func (d *Database) ListFilesByDate(date string) ([]*FileMetadata, error) {
+// GetExpiringFiles returns files expiring within the window that haven't been warned yet
+func (d *Database) GetExpiringFiles(window time.Duration) ([]*FileMetadata, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	now := time.Now()
+	deadline := now.Add(window)
+	var expiring []*FileMetadata
+
+	for _, meta := range d.data.Files {
+		if meta.IsPinned() || meta.IsDeleted() || meta.WarnedAt != nil {
+			continue
+		}
+		if meta.ExpiresAt.After(now) && !meta.ExpiresAt.After(deadline) {
+			expiring = append(expiring, meta)
+		}
+	}
+
+	return expiring, nil
+}
+
+// MarkFilesWarned records that expiry warnings were sent for the given file IDs
+func (d *Database) MarkFilesWarned(ids []int64, warnedAt time.Time) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	var firstErr error
+	for _, id := range ids {
+		if meta, ok := d.data.Files[id]; ok {
+			t := warnedAt
+			meta.WarnedAt = &t
+			if err := d.fileChanged(meta); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// ListFilesByDate returns the live files of a date directory, leaving out
+// expired ones unless includeExpired is set
+func (d *Database) ListFilesByDate(date string, includeExpired bool) ([]*FileMetadata, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	now := time.Now()
+	var files []*FileMetadata
+
+	for id := range d.index.byDate[date] {
+		if meta := d.data.Files[id]; meta != nil && !meta.IsDeleted() && (includeExpired || !meta.IsExpired(now)) {
+			files = append(files, meta)
+		}
+	}
+
+	SortFiles(files, OrderNewest)
+	return files, nil
+}
+
+// ListRecentFiles returns up to limit of the most recently uploaded live
+// files, newest first, leaving out expired ones unless includeExpired is set.
+// Files are stored under their upload date, so the date directories are
+// walked newest first and the walk stops once a date fills the limit, rather
+// than sorting every record.
+func (d *Database) ListRecentFiles(limit int, includeExpired bool) ([]*FileMetadata, error) {
 	d.mux.RLock()
 	defer d.mux.RUnlock()
 
+	dates := make([]string, 0, len(d.index.byDate))
+	for date := range d.index.byDate {
+		dates = append(dates, date)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+
+	now := time.Now()
 	var files []*FileMetadata
+	for _, date := range dates {
+		if len(files) >= limit {
+			break
+		}
+		for id := range d.index.byDate[date] {
+			meta := d.data.Files[id]
+			if meta != nil && !meta.IsDeleted() && (includeExpired || !meta.IsExpired(now)) {
+				files = append(files, meta)
+			}
+		}
+	}
+
+	SortFiles(files, OrderNewest)
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	return files, nil
+}
+
+// ListAllFiles returns all live (non-trashed) files
+func (d *Database) ListAllFiles() ([]*FileMetadata, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
 
+	files := make([]*FileMetadata, 0, len(d.data.Files))
 	for _, meta := range d.data.Files {
-		// Normalize path separators for comparison
-		filePath := filepath.ToSlash(meta.FilePath)
-		// Check if file starts with date + "/"
-		if strings.HasPrefix(filePath, date+"/") {
+		if !meta.IsDeleted() {
 			files = append(files, meta)
 		}
 	}
@@ -310,44 +1068,168 @@ func (d *Database) ListFilesByDate(date string) ([]*FileMetadata, error) {
 	return files, nil
 }
 
-// ListAllDates returns all unique date directories
-func (d *Database) ListAllDates() ([]string, error) {
+// ListFilesByTag returns the live files carrying the given tag, leaving out
+// expired ones unless includeExpired is set
+func (d *Database) ListFilesByTag(tag string, includeExpired bool) ([]*FileMetadata, error) {
 	d.mux.RLock()
 	defer d.mux.RUnlock()
 
-	dateMap := make(map[string]bool)
+	now := time.Now()
+	var files []*FileMetadata
 
 	for _, meta := range d.data.Files {
-		// Extract date from path (YYYYMMDD/)
-		// Normalize path separators first
-		filePath := filepath.ToSlash(meta.FilePath)
-		parts := strings.Split(filePath, "/")
-		if len(parts) > 0 {
-			dateMap[parts[0]] = true
+		if meta.Tag == tag && !meta.IsDeleted() && (includeExpired || !meta.IsExpired(now)) {
+			files = append(files, meta)
 		}
 	}
 
+	return files, nil
+}
+
+// ListTags returns all distinct tags with their file counts, sorted by tag
+func (d *Database) ListTags() ([]TagCount, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	counts := make(map[string]int)
+	for _, meta := range d.data.Files {
+		if meta.Tag != "" && !meta.IsDeleted() {
+			counts[meta.Tag]++
+		}
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Tag < tags[j].Tag })
+
+	return tags, nil
+}
+
+// ListAllDates returns the date directories holding live files, newest first.
+// Dates whose files have all expired are left out unless includeExpired is set.
+func (d *Database) ListAllDates(includeExpired bool) ([]string, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	now := time.Now()
 	var dates []string
-	for date := range dateMap {
-		dates = append(dates, date)
+
+	for date, ids := range d.index.byDate {
+		for id := range ids {
+			meta := d.data.Files[id]
+			if meta != nil && !meta.IsDeleted() && (includeExpired || !meta.IsExpired(now)) {
+				dates = append(dates, date)
+				break
+			}
+		}
 	}
 
+	// Date directories are YYYYMMDD, so string order is chronological
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
 	return dates, nil
 }
 
+// SaveCleanupReport appends a cleanup report, keeping only the most recent ones
+func (d *Database) SaveCleanupReport(report CleanupReport) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.data.CleanupReports = append(d.data.CleanupReports, report)
+	if excess := len(d.data.CleanupReports) - maxCleanupReports; excess > 0 {
+		d.data.CleanupReports = append([]CleanupReport(nil), d.data.CleanupReports[excess:]...)
+	}
+	d.triggerSave()
+
+	return nil
+}
+
+// GetCleanupReports returns stored cleanup reports, newest first
+func (d *Database) GetCleanupReports() ([]CleanupReport, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	reports := make([]CleanupReport, 0, len(d.data.CleanupReports))
+	for i := len(d.data.CleanupReports) - 1; i >= 0; i-- {
+		reports = append(reports, d.data.CleanupReports[i])
+	}
+
+	return reports, nil
+}
+
+// GetLastCleanupReport returns the most recent cleanup report, or nil if none exist
+func (d *Database) GetLastCleanupReport() *CleanupReport {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	if len(d.data.CleanupReports) == 0 {
+		return nil
+	}
+	report := d.data.CleanupReports[len(d.data.CleanupReports)-1]
+	return &report
+}
+
+// SaveVerifyReport appends a verification summary, keeping only the most recent ones
+func (d *Database) SaveVerifyReport(report VerifyReport) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.data.VerifyReports = append(d.data.VerifyReports, report)
+	if excess := len(d.data.VerifyReports) - maxCleanupReports; excess > 0 {
+		d.data.VerifyReports = append([]VerifyReport(nil), d.data.VerifyReports[excess:]...)
+	}
+	d.triggerSave()
+
+	return nil
+}
+
 // GetStats returns database statistics
 func (d *Database) GetStats() (totalFiles int, totalSize int64, err error) {
 	d.mux.RLock()
 	defer d.mux.RUnlock()
 
-	totalFiles = len(d.data.Files)
 	for _, meta := range d.data.Files {
+		if meta.IsDeleted() {
+			continue
+		}
+		totalFiles++
 		totalSize += meta.FileSize
 	}
 
 	return totalFiles, totalSize, nil
 }
 
+// FileCounts splits the stored, untrashed files into live ones and expired
+// ones awaiting cleanup
+type FileCounts struct {
+	LiveFiles    int   `json:"live_files"`
+	LiveSize     int64 `json:"live_size"`
+	ExpiredFiles int   `json:"expired_files"`
+	ExpiredSize  int64 `json:"expired_size"`
+}
+
+// GetFileCounts is GetStats with the expired files counted apart
+func (d *Database) GetFileCounts() (FileCounts, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	now := time.Now()
+	var counts FileCounts
+	for _, meta := range d.data.Files {
+		switch {
+		case meta.IsDeleted():
+		case meta.IsExpired(now):
+			counts.ExpiredFiles++
+			counts.ExpiredSize += meta.FileSize
+		default:
+			counts.LiveFiles++
+			counts.LiveSize += meta.FileSize
+		}
+	}
+	return counts, nil
+}
+
 // GetGlobalDB returns the global database instance
 func GetGlobalDB() *Database {
 	return globalDB
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readSnapshot parses the database file the way Load does
+func readSnapshot(t *testing.T, path string) *DatabaseData {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading the database: %v", err)
+	}
+	data := newDatabaseData()
+	if err := json.Unmarshal(raw, data); err != nil {
+		t.Fatalf("database doesn't parse: %v", err)
+	}
+	return data
+}
+
+func TestCloseDuringWrites(t *testing.T) {
+	const writers = 8
+	for round := 0; round < 20; round++ {
+		path := filepath.Join(t.TempDir(), "metadata.db")
+		d, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+
+		// Writers run until told to stop, past Close. A write that returned
+		// before Close began must survive it.
+		var closing uint32
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		acked := make([][]string, writers)
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for n := 0; ; n++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					meta := testFile("20240101", w*100000+n, "")
+					if n%2 == 0 {
+						if err := d.SaveFileMetadata(meta); err == nil && atomic.LoadUint32(&closing) == 0 {
+							acked[w] = append(acked[w], meta.FilePath)
+						}
+					} else {
+						d.SetConfig(fmt.Sprintf("test.writer%d", w), fmt.Sprint(n))
+					}
+				}
+			}(w)
+		}
+
+		time.Sleep(time.Duration(round%5) * time.Millisecond)
+		atomic.StoreUint32(&closing, 1)
+		if err := d.Close(); err != nil {
+			t.Errorf("round %d: Close: %v", round, err)
+		}
+		close(stop)
+		wg.Wait()
+
+		readSnapshot(t, path)
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("round %d: temporary file left behind", round)
+		}
+
+		reopened, err := Open(path)
+		if err != nil {
+			t.Fatalf("round %d: reopening: %v", round, err)
+		}
+		for w := range acked {
+			for _, p := range acked[w] {
+				if meta, _ := reopened.GetFileMetadata(p); meta == nil {
+					t.Errorf("round %d: %s was saved before Close but is gone", round, p)
+				}
+			}
+		}
+		reopened.Close()
+	}
+}
+
+func TestCloseSavesSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 10; n++ {
+		if err := d.SaveFileMetadata(testFile("20240101", n, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.SetConfig("test.key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything is in the snapshot, so the log is empty
+	data := readSnapshot(t, path)
+	if len(data.Files) != 10 || data.Config["test.key"] != "value" {
+		t.Errorf("snapshot has %d files and test.key %q, want 10 and \"value\"", len(data.Files), data.Config["test.key"])
+	}
+	if info, err := os.Stat(path + ".wal"); err == nil && info.Size() != 0 {
+		t.Errorf("write-ahead log holds %d bytes after Close", info.Size())
+	}
+}
+
+// waitForSave waits until a save after since has finished
+func waitForSave(t *testing.T, d *Database, since time.Time) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if at, err := d.SaveStatus(); err != nil {
+			t.Fatalf("save failed: %v", err)
+		} else if at.After(since) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("no save happened")
+}
+
+// modTime returns a file's modification time
+func modTime(t *testing.T, path string) time.Time {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ModTime()
+}
+
+func TestIdleDatabaseNotRewritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	start := time.Now()
+	if err := d.SaveFileMetadata(testFile("20240101", 1, "")); err != nil {
+		t.Fatal(err)
+	}
+	waitForSave(t, d, start)
+	saved := modTime(t, path)
+
+	// Periodic saves while nothing changes leave the file alone
+	for i := 0; i < 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		d.autoSave <- struct{}{}
+		d.mux.RLock()
+		err := d.saveIfDirty()
+		d.mux.RUnlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := modTime(t, path); !got.Equal(saved) {
+		t.Errorf("idle database rewritten: mtime %v, was %v", got, saved)
+	}
+
+	// A change makes the next save write again
+	start = time.Now()
+	if err := d.SetConfig("test.key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	waitForSave(t, d, start)
+	if got := modTime(t, path); !got.After(saved) {
+		t.Errorf("changed database not rewritten: mtime %v, was %v", got, saved)
+	}
+}
+
+func TestCloseCleanDatabaseNotRewritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	saved := modTime(t, path)
+
+	time.Sleep(20 * time.Millisecond)
+	d, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := modTime(t, path); !got.Equal(saved) {
+		t.Errorf("reopening and closing rewrote the database: mtime %v, was %v", got, saved)
+	}
+}
+
+// BenchmarkMarshal compares the compact snapshot with the indented one saves
+// used to write; run with -benchmem to see the allocations
+func BenchmarkMarshal(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		d, _ := benchmarkDatabase(n)
+		b.Run(fmt.Sprintf("indent/files=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := json.MarshalIndent(d.data, "", "  "); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("compact/files=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := json.Marshal(d.data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkIdleSave is the periodic save of a database with no changes, which
+// should cost next to nothing
+func BenchmarkIdleSave(b *testing.B) {
+	d := openTestDB(b)
+	if err := d.save(); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.mux.RLock()
+		d.saveIfDirty()
+		d.mux.RUnlock()
+	}
+}
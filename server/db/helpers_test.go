@@ -0,0 +1,38 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestDB opens an empty database in a temporary directory, closed when
+// the test ends
+func openTestDB(t testing.TB) *Database {
+	t.Helper()
+	d, err := Open(filepath.Join(t.TempDir(), "metadata.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// testFile returns an unsaved record for the nth file of a date directory,
+// uploaded n minutes into the day and expiring in an hour
+func testFile(date string, n int, hash string) *FileMetadata {
+	uploaded, _ := time.Parse("20060102", date)
+	uploaded = uploaded.Add(time.Duration(n) * time.Minute)
+	name := fmt.Sprintf("%s-%09d-%032x.png", date, n, n)
+	return &FileMetadata{
+		FileName:     name,
+		OriginalName: fmt.Sprintf("photo-%d.png", n),
+		FilePath:     filepath.Join(date, name),
+		FileSize:     int64(100 + n),
+		UploadedAt:   uploaded,
+		ExpiresAt:    time.Now().Add(time.Hour),
+		TTL:          1,
+		Hash:         hash,
+	}
+}
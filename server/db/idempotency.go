@@ -0,0 +1,55 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// IdempotencyRecord remembers the result of an upload made with an
+// Idempotency-Key, so a retry of it can be answered without storing the file
+// again
+type IdempotencyRecord struct {
+	Subject   string          `json:"subject"` // Uploader the key belongs to, as in usage tracking
+	Key       string          `json:"key"`
+	FilePath  string          `json:"file_path"`
+	Response  json.RawMessage `json:"response"` // Response body of the original upload
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// FindIdempotencyKey returns the result stored for subject's key within the
+// last window, or nil
+func (d *Database) FindIdempotencyKey(subject, key string, window time.Duration) *IdempotencyRecord {
+	cutoff := time.Now().Add(-window)
+
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	for _, rec := range d.data.IdempotencyKeys {
+		if rec.Subject == subject && rec.Key == key && rec.CreatedAt.After(cutoff) {
+			copied := *rec
+			return &copied
+		}
+	}
+	return nil
+}
+
+// PutIdempotencyKey stores the result of an upload, replacing any earlier one
+// for the same key, and drops records older than window
+func (d *Database) PutIdempotencyKey(rec IdempotencyRecord, window time.Duration) {
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now()
+	}
+	cutoff := time.Now().Add(-window)
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	kept := d.data.IdempotencyKeys[:0]
+	for _, existing := range d.data.IdempotencyKeys {
+		if existing.CreatedAt.After(cutoff) && (existing.Subject != rec.Subject || existing.Key != rec.Key) {
+			kept = append(kept, existing)
+		}
+	}
+	d.data.IdempotencyKeys = append(kept, &rec)
+	d.triggerSave()
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"path/filepath"
+
+	"httpserver/server/naming"
+)
+
+// fileIndex holds secondary lookups over DatabaseData.Files. It is never persisted:
+// Open rebuilds it from the loaded records, and every method that adds or removes a
+// record updates it under the database lock.
+type fileIndex struct {
+	byPath map[string]int64          // Slash-separated path -> ID
+	byHash map[string]map[int64]bool // Content hash -> IDs
+	byDate map[string]map[int64]bool // Date directory -> IDs
+}
+
+// newFileIndex builds an index over the given records
+func newFileIndex(files map[int64]*FileMetadata) *fileIndex {
+	idx := &fileIndex{
+		byPath: make(map[string]int64, len(files)),
+		byHash: make(map[string]map[int64]bool),
+		byDate: make(map[string]map[int64]bool),
+	}
+	for _, meta := range files {
+		idx.add(meta)
+	}
+	return idx
+}
+
+// add indexes a record
+func (idx *fileIndex) add(meta *FileMetadata) {
+	idx.byPath[indexPath(meta.FilePath)] = meta.ID
+	if meta.Hash != "" {
+		addToSet(idx.byHash, meta.Hash, meta.ID)
+	}
+	if date := indexDate(meta.FilePath); date != "" {
+		addToSet(idx.byDate, date, meta.ID)
+	}
+}
+
+// remove drops a record from the index
+func (idx *fileIndex) remove(meta *FileMetadata) {
+	path := indexPath(meta.FilePath)
+	if idx.byPath[path] == meta.ID {
+		delete(idx.byPath, path)
+	}
+	if meta.Hash != "" {
+		removeFromSet(idx.byHash, meta.Hash, meta.ID)
+	}
+	if date := indexDate(meta.FilePath); date != "" {
+		removeFromSet(idx.byDate, date, meta.ID)
+	}
+}
+
+// indexPath normalizes a stored path to the form used as the index key
+func indexPath(filePath string) string {
+	return filepath.ToSlash(filePath)
+}
+
+// indexDate returns the upload date (YYYYMMDD) of a stored path, which is its
+// date directory in the daily and hourly layouts
+func indexDate(filePath string) string {
+	return naming.ParseDateFromPath(filePath)
+}
+
+func addToSet(sets map[string]map[int64]bool, key string, id int64) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[int64]bool)
+		sets[key] = set
+	}
+	set[id] = true
+}
+
+func removeFromSet(sets map[string]map[int64]bool, key string, id int64) {
+	set, ok := sets[key]
+	if !ok {
+		return
+	}
+	delete(set, id)
+	if len(set) == 0 {
+		delete(sets, key)
+	}
+}
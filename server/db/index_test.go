@@ -0,0 +1,198 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// checkIndex fails the test unless the live index matches one rebuilt from the
+// records, which is what the next Open would load
+func checkIndex(t *testing.T, d *Database) {
+	t.Helper()
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	if want := newFileIndex(d.data.Files); !reflect.DeepEqual(d.index, want) {
+		t.Fatalf("index drifted from the records:\n got %+v\nwant %+v", d.index, want)
+	}
+}
+
+func TestIndexConsistentAcrossAddDeleteCycles(t *testing.T) {
+	d := openTestDB(t)
+	dates := []string{"20240101", "20240102", "20240103"}
+
+	var saved []*FileMetadata
+	for cycle := 0; cycle < 5; cycle++ {
+		for i := 0; i < 30; i++ {
+			n := cycle*30 + i
+			meta := testFile(dates[n%len(dates)], n, fmt.Sprintf("hash-%d", n%7))
+			if err := d.SaveFileMetadata(meta); err != nil {
+				t.Fatalf("SaveFileMetadata: %v", err)
+			}
+			saved = append(saved, meta)
+		}
+		checkIndex(t, d)
+
+		// Delete every third file saved so far, including ones from earlier cycles
+		var kept []*FileMetadata
+		for i, meta := range saved {
+			if i%3 != cycle%3 {
+				kept = append(kept, meta)
+				continue
+			}
+			if err := d.DeleteFileMetadata(meta.FilePath); err != nil {
+				t.Fatalf("DeleteFileMetadata: %v", err)
+			}
+		}
+		saved = kept
+		checkIndex(t, d)
+	}
+
+	for _, meta := range saved {
+		got, _ := d.GetFileMetadata(meta.FilePath)
+		if got == nil || got.ID != meta.ID {
+			t.Fatalf("GetFileMetadata(%s) = %v, want ID %d", meta.FilePath, got, meta.ID)
+		}
+	}
+	byHash := make(map[string]int)
+	byDate := make(map[string]int)
+	for _, meta := range saved {
+		byHash[meta.Hash]++
+		byDate[meta.FilePath[:8]]++
+	}
+	for hash, want := range byHash {
+		if files, _ := d.GetFilesByHash(hash); len(files) != want {
+			t.Errorf("GetFilesByHash(%s) returned %d files, want %d", hash, len(files), want)
+		}
+	}
+	for _, date := range dates {
+		if files, _ := d.ListFilesByDate(date, false); len(files) != byDate[date] {
+			t.Errorf("ListFilesByDate(%s) returned %d files, want %d", date, len(files), byDate[date])
+		}
+	}
+}
+
+func TestIndexDropsDeletedPathsAndEmptyDates(t *testing.T) {
+	d := openTestDB(t)
+	meta := testFile("20240105", 1, "only")
+	if err := d.SaveFileMetadata(meta); err != nil {
+		t.Fatalf("SaveFileMetadata: %v", err)
+	}
+	if err := d.DeleteFileMetadata(meta.FilePath); err != nil {
+		t.Fatalf("DeleteFileMetadata: %v", err)
+	}
+
+	if got, _ := d.GetFileMetadata(meta.FilePath); got != nil {
+		t.Errorf("GetFileMetadata found the deleted record %d", got.ID)
+	}
+	if files, _ := d.GetFilesByHash("only"); len(files) != 0 {
+		t.Errorf("GetFilesByHash still returns %d files", len(files))
+	}
+	if dates, _ := d.ListAllDates(true); len(dates) != 0 {
+		t.Errorf("ListAllDates = %v, want none", dates)
+	}
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	if len(d.index.byPath) != 0 || len(d.index.byHash) != 0 || len(d.index.byDate) != 0 {
+		t.Errorf("index keeps empty entries: %+v", d.index)
+	}
+}
+
+func TestIndexRebuiltOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	meta := testFile("20240201", 3, "abc")
+	if err := d.SaveFileMetadata(meta); err != nil {
+		t.Fatalf("SaveFileMetadata: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer d.Close()
+	if got, _ := d.GetFileMetadata(meta.FilePath); got == nil || got.ID != meta.ID {
+		t.Fatalf("GetFileMetadata after reopen = %v, want ID %d", got, meta.ID)
+	}
+	if files, _ := d.GetFilesByHash("abc"); len(files) != 1 {
+		t.Errorf("GetFilesByHash after reopen returned %d files, want 1", len(files))
+	}
+	if dates, _ := d.ListAllDates(false); !reflect.DeepEqual(dates, []string{"20240201"}) {
+		t.Errorf("ListAllDates after reopen = %v", dates)
+	}
+}
+
+// benchmarkDatabase returns an in-memory database holding n records spread
+// over 30 dates, without a backend or autosave
+func benchmarkDatabase(n int) (*Database, []string) {
+	d := &Database{data: newDatabaseData()}
+	paths := make([]string, n)
+	for i := 0; i < n; i++ {
+		meta := testFile(fmt.Sprintf("202403%02d", i%30+1), i, fmt.Sprintf("hash-%d", i))
+		meta.ID = int64(i + 1)
+		d.data.Files[meta.ID] = meta
+		paths[i] = meta.FilePath
+	}
+	d.index = newFileIndex(d.data.Files)
+	return d, paths
+}
+
+var benchmarkSizes = []int{1000, 10000, 100000}
+
+// BenchmarkGetFileMetadata looks files up by path through the index; the time
+// per lookup should stay flat as the database grows
+func BenchmarkGetFileMetadata(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		d, paths := benchmarkDatabase(n)
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if meta, _ := d.GetFileMetadata(paths[i%len(paths)]); meta == nil {
+					b.Fatal("lookup failed")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkScanFileMetadata is the full scan lookups used to make, for
+// comparison with BenchmarkGetFileMetadata; it grows with the database
+func BenchmarkScanFileMetadata(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		d, paths := benchmarkDatabase(n)
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				target := filepath.ToSlash(paths[i%len(paths)])
+				var found *FileMetadata
+				for _, meta := range d.data.Files {
+					if filepath.ToSlash(meta.FilePath) == target {
+						found = meta
+						break
+					}
+				}
+				if found == nil {
+					b.Fatal("lookup failed")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkListFilesByDate lists one date's files; the time depends on the
+// size of the date, not of the database
+func BenchmarkListFilesByDate(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		d, _ := benchmarkDatabase(n)
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				d.ListFilesByDate("20240301", true)
+			}
+		})
+	}
+}
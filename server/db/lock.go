@@ -0,0 +1,83 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errWouldBlock is returned by lockFile when another process holds a conflicting lock
+var errWouldBlock = errors.New("lock is held by another process")
+
+// LockedError is returned by Open when another process is using the database
+type LockedError struct {
+	Path string
+	PID  int // 0 if the holder's PID could not be read
+}
+
+func (e *LockedError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("database %s is in use by PID %d", e.Path, e.PID)
+	}
+	return fmt.Sprintf("database %s is in use by another process", e.Path)
+}
+
+// dbLock is an advisory lock on <database>.lock. The operating system drops the
+// lock when the holder exits, so a lock file left behind by a crash is stale and
+// is simply locked again by the next process.
+type dbLock struct {
+	file      *os.File
+	exclusive bool
+}
+
+// acquireLock locks the lock file for dbPath without blocking. An exclusive lock
+// records the holder's PID in the file for the error reported to other processes.
+func acquireLock(dbPath string, exclusive bool) (*dbLock, error) {
+	lockPath := dbPath + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		if errors.Is(err, errWouldBlock) {
+			return nil, &LockedError{Path: dbPath, PID: readLockPID(lockPath)}
+		}
+		return nil, fmt.Errorf("failed to lock database: %w", err)
+	}
+
+	if exclusive {
+		f.Truncate(0)
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &dbLock{file: f, exclusive: exclusive}, nil
+}
+
+// release unlocks the lock file. The file itself is kept (only the PID is
+// cleared): removing it could let two processes lock different inodes.
+func (l *dbLock) release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	if l.exclusive {
+		l.file.Truncate(0)
+	}
+	l.file.Close()
+	l.file = nil
+}
+
+// readLockPID returns the PID recorded in a lock file, or 0
+func readLockPID(lockPath string) int {
+	raw, err := os.ReadFile(lockPath)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,21 @@
+// +build !windows
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes a non-blocking flock on f
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errWouldBlock
+	}
+	return err
+}
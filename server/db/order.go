@@ -0,0 +1,57 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FileOrder is a sort order for file listings
+type FileOrder string
+
+// Supported file orders
+const (
+	OrderNewest FileOrder = "newest" // Upload time, newest first (the default)
+	OrderOldest FileOrder = "oldest" // Upload time, oldest first
+	OrderName   FileOrder = "name"   // Original name, A to Z
+	OrderSize   FileOrder = "size"   // File size, largest first
+)
+
+// ParseFileOrder validates a sort order name; an empty name selects OrderNewest
+func ParseFileOrder(name string) (FileOrder, error) {
+	switch order := FileOrder(strings.ToLower(name)); order {
+	case "":
+		return OrderNewest, nil
+	case OrderNewest, OrderOldest, OrderName, OrderSize:
+		return order, nil
+	}
+	return "", fmt.Errorf("invalid sort order '%s' (use newest, oldest, name or size)", name)
+}
+
+// SortFiles sorts files in place. Ties are broken by ID so the result is the same
+// on every call, whatever order the records came out of the map in.
+func SortFiles(files []*FileMetadata, order FileOrder) {
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		switch order {
+		case OrderOldest:
+			if !a.UploadedAt.Equal(b.UploadedAt) {
+				return a.UploadedAt.Before(b.UploadedAt)
+			}
+			return a.ID < b.ID
+		case OrderName:
+			if an, bn := strings.ToLower(a.OriginalName), strings.ToLower(b.OriginalName); an != bn {
+				return an < bn
+			}
+		case OrderSize:
+			if a.FileSize != b.FileSize {
+				return a.FileSize > b.FileSize
+			}
+		default:
+			if !a.UploadedAt.Equal(b.UploadedAt) {
+				return a.UploadedAt.After(b.UploadedAt)
+			}
+		}
+		return a.ID > b.ID
+	})
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fileIDs returns the IDs of files in order
+func fileIDs(files []*FileMetadata) []int64 {
+	ids := make([]int64, len(files))
+	for i, meta := range files {
+		ids[i] = meta.ID
+	}
+	return ids
+}
+
+func TestListAllDatesNewestFirstWithoutExpiredOnlyDates(t *testing.T) {
+	d := openTestDB(t)
+	for _, date := range []string{"20240503", "20240101", "20241231", "20240315"} {
+		if err := d.SaveFileMetadata(testFile(date, 1, "")); err != nil {
+			t.Fatalf("SaveFileMetadata: %v", err)
+		}
+	}
+	expired := testFile("20240601", 1, "")
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := d.SaveFileMetadata(expired); err != nil {
+		t.Fatalf("SaveFileMetadata: %v", err)
+	}
+
+	want := []string{"20241231", "20240503", "20240315", "20240101"}
+	for run := 0; run < 20; run++ {
+		if dates, _ := d.ListAllDates(false); !reflect.DeepEqual(dates, want) {
+			t.Fatalf("run %d: ListAllDates = %v, want %v", run, dates, want)
+		}
+	}
+	want = []string{"20241231", "20240601", "20240503", "20240315", "20240101"}
+	if dates, _ := d.ListAllDates(true); !reflect.DeepEqual(dates, want) {
+		t.Errorf("ListAllDates(includeExpired) = %v, want %v", dates, want)
+	}
+}
+
+func TestListFilesByDateNewestFirstAndStable(t *testing.T) {
+	d := openTestDB(t)
+	// Uploaded at minutes 5, 1, 9 and twice at 3, where the later ID comes first
+	base, _ := time.Parse("20060102", "20240101")
+	for i, minute := range []int{5, 1, 9, 3, 3} {
+		meta := testFile("20240101", i, "")
+		meta.UploadedAt = base.Add(time.Duration(minute) * time.Minute)
+		if err := d.SaveFileMetadata(meta); err != nil {
+			t.Fatalf("SaveFileMetadata: %v", err)
+		}
+	}
+
+	want := []int64{3, 1, 5, 4, 2}
+	for run := 0; run < 20; run++ {
+		files, _ := d.ListFilesByDate("20240101", false)
+		if got := fileIDs(files); !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: ListFilesByDate IDs = %v, want %v", run, got, want)
+		}
+	}
+}
+
+func TestSortFilesOrders(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []*FileMetadata{
+		{ID: 1, OriginalName: "b.png", FileSize: 10, UploadedAt: base.Add(2 * time.Minute)},
+		{ID: 2, OriginalName: "A.png", FileSize: 30, UploadedAt: base},
+		{ID: 3, OriginalName: "c.png", FileSize: 30, UploadedAt: base.Add(time.Minute)},
+		{ID: 4, OriginalName: "a.png", FileSize: 20, UploadedAt: base.Add(time.Minute)},
+	}
+	tests := []struct {
+		order FileOrder
+		want  []int64
+	}{
+		{OrderNewest, []int64{1, 4, 3, 2}},
+		{OrderOldest, []int64{2, 3, 4, 1}},
+		{OrderName, []int64{4, 2, 1, 3}},
+		{OrderSize, []int64{3, 2, 4, 1}},
+	}
+	for _, tt := range tests {
+		for run := 0; run < 10; run++ {
+			shuffled := make([]*FileMetadata, len(files))
+			for i, j := range []int{(run + 0) % 4, (run + 1) % 4, (run + 2) % 4, (run + 3) % 4} {
+				shuffled[i] = files[j]
+			}
+			SortFiles(shuffled, tt.order)
+			if got := fileIDs(shuffled); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("SortFiles(%s) = %v, want %v", tt.order, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestParseFileOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    FileOrder
+		wantErr bool
+	}{
+		{"", OrderNewest, false},
+		{"newest", OrderNewest, false},
+		{"Oldest", OrderOldest, false},
+		{"NAME", OrderName, false},
+		{"size", OrderSize, false},
+		{"random", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFileOrder(tt.name)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseFileOrder(%q) = %q, %v", tt.name, got, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CorruptError is returned by Open when the database file can't be parsed
+type CorruptError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("database file %s is corrupt: %v", e.Path, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// RecoveryReport describes the outcome of Recover
+type RecoveryReport struct {
+	CorruptPath     string            // Where the damaged file was moved
+	FilesRecovered  int               // File records salvaged from the damaged file and its log
+	ConfigRecovered int               // Config values salvaged
+	Credentials     map[string]string // Credentials that couldn't be salvaged and were regenerated
+}
+
+// regeneratedOnRecovery are the secrets Recover replaces with random values when
+// they can't be salvaged, rather than falling back to the well-known defaults
+var regeneratedOnRecovery = []string{"auth.api_key", "auth.admin_password", "auth.list_password"}
+
+// Recover salvages a corrupt JSON database. The damaged file is renamed to
+// <path>.corrupt-<timestamp>, every file record and config value that can still
+// be parsed is kept (plus anything in the write-ahead log), and a fresh database is
+// written in its place. Lost credentials are regenerated and returned in the report.
+// Records for files on disk that couldn't be salvaged are not restored here;
+// callers rebuild them from a scan of the images directory.
+func Recover(dbPath string) (*RecoveryReport, error) {
+	if isSQLitePath(dbPath) {
+		return nil, fmt.Errorf("recovery is only supported for JSON databases")
+	}
+
+	lock, err := acquireLock(dbPath, true)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	raw, err := os.ReadFile(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dbPath, err)
+	}
+
+	report := &RecoveryReport{
+		CorruptPath: fmt.Sprintf("%s.corrupt-%s", dbPath, time.Now().Format("20060102T150405")),
+		Credentials: make(map[string]string),
+	}
+	if err := os.Rename(dbPath, report.CorruptPath); err != nil {
+		return nil, fmt.Errorf("failed to move corrupt database aside: %w", err)
+	}
+
+	data := salvageJSON(raw)
+	backend := newJSONBackend(dbPath)
+	defer backend.Close()
+	if _, err := backend.replayWAL(data); err != nil {
+		return nil, fmt.Errorf("failed to replay write-ahead log: %w", err)
+	}
+	for id := range data.Files {
+		if id >= data.NextID {
+			data.NextID = id + 1
+		}
+	}
+	report.FilesRecovered = len(data.Files)
+	report.ConfigRecovered = len(data.Config)
+
+	for _, key := range regeneratedOnRecovery {
+		if data.Config[key] != "" {
+			continue
+		}
+		secret, err := randomSecret()
+		if err != nil {
+			return nil, err
+		}
+		data.Config[key] = secret
+		report.Credentials[key] = secret
+	}
+
+	if err := backend.Flush(data); err != nil {
+		return nil, fmt.Errorf("failed to write recovered database: %w", err)
+	}
+	return report, nil
+}
+
+// salvageJSON parses as much of a damaged database document as possible. Invalid
+// UTF-8 is replaced, and decoding stops at the first unreadable element, keeping
+// every complete file record and config value before it.
+func salvageJSON(raw []byte) *DatabaseData {
+	data := newDatabaseData()
+	dec := json.NewDecoder(bytes.NewReader(bytes.ToValidUTF8(raw, []byte("\uFFFD"))))
+
+	if !expectDelim(dec, '{') {
+		return data
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return data
+		}
+		key, _ := tok.(string)
+
+		switch key {
+		case "files":
+			if !expectDelim(dec, '{') {
+				return data
+			}
+			for dec.More() {
+				if _, err := dec.Token(); err != nil {
+					return data
+				}
+				meta := &FileMetadata{}
+				if err := dec.Decode(meta); err != nil {
+					return data
+				}
+				if meta.ID > 0 && meta.FilePath != "" {
+					data.Files[meta.ID] = meta
+				}
+			}
+			if !expectDelim(dec, '}') {
+				return data
+			}
+		case "config":
+			if !expectDelim(dec, '{') {
+				return data
+			}
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return data
+				}
+				var value string
+				if err := dec.Decode(&value); err != nil {
+					return data
+				}
+				if k, ok := tok.(string); ok {
+					data.Config[k] = value
+				}
+			}
+			if !expectDelim(dec, '}') {
+				return data
+			}
+		case "api_tokens":
+			if err := dec.Decode(&data.APITokens); err != nil {
+				return data
+			}
+		case "next_id":
+			if err := dec.Decode(&data.NextID); err != nil {
+				return data
+			}
+		default:
+			// Report histories are expendable
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return data
+			}
+		}
+	}
+	return data
+}
+
+// expectDelim reads the next token and reports whether it is the given delimiter
+func expectDelim(dec *json.Decoder, delim json.Delim) bool {
+	tok, err := dec.Token()
+	if err != nil {
+		return false
+	}
+	d, ok := tok.(json.Delim)
+	return ok && d == delim
+}
+
+// randomSecret returns a random hex string for a regenerated credential
+func randomSecret() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
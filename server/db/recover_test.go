@@ -0,0 +1,162 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDatabase saves a database with five file records and returns its path
+// and contents
+func writeDatabase(t *testing.T) (string, []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for n := 1; n <= 5; n++ {
+		if err := d.SaveFileMetadata(testFile("20240101", n, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.SetConfig("auth.api_key", "kept-api-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, raw
+}
+
+// damage rewrites a database file with broken contents
+func damage(t *testing.T, path string, raw []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// truncated cuts a database partway through the record with the given ID
+func truncated(t *testing.T, raw []byte, id string) []byte {
+	t.Helper()
+	at := bytes.Index(raw, []byte(`"`+id+`":{`))
+	if at < 0 {
+		t.Fatalf("record %s not found in the database", id)
+	}
+	return raw[:at+10]
+}
+
+// invalidUTF8 puts a byte that can't start a UTF-8 sequence into a file name
+func invalidUTF8(t *testing.T, raw []byte) []byte {
+	t.Helper()
+	damaged := append([]byte(nil), raw...)
+	at := bytes.Index(damaged, []byte("photo-3"))
+	if at < 0 {
+		t.Fatal("original name not found in the database")
+	}
+	damaged[at] = 0xff
+	return damaged
+}
+
+func TestOpenCorruptDatabase(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, raw []byte) []byte
+	}{
+		{"truncated JSON", func(t *testing.T, raw []byte) []byte { return truncated(t, raw, "3") }},
+		{"invalid UTF-8", invalidUTF8},
+		{"empty file", func(*testing.T, []byte) []byte { return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, raw := writeDatabase(t)
+			damaged := tt.damage(t, raw)
+			damage(t, path, damaged)
+
+			d, err := Open(path)
+			if err == nil {
+				d.Close()
+				t.Fatal("Open succeeded on a corrupt database")
+			}
+			var corrupt *CorruptError
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("Open returned %v, want a *CorruptError", err)
+			}
+			if corrupt.Path != path {
+				t.Errorf("CorruptError.Path = %q, want %q", corrupt.Path, path)
+			}
+
+			// Nothing is written over the damaged file
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, damaged) {
+				t.Error("the corrupt database was modified by Open")
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name      string
+		damage    func(t *testing.T, raw []byte) []byte
+		wantFiles []int64
+		keptKey   bool
+	}{
+		// Decoding stops inside record 4, after which the config is lost too
+		{"truncated JSON", func(t *testing.T, raw []byte) []byte { return truncated(t, raw, "4") }, []int64{1, 2, 3}, false},
+		{"invalid UTF-8", invalidUTF8, []int64{1, 2, 3, 4, 5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, raw := writeDatabase(t)
+			damaged := tt.damage(t, raw)
+			damage(t, path, damaged)
+
+			report, err := Recover(path)
+			if err != nil {
+				t.Fatalf("Recover: %v", err)
+			}
+			if report.FilesRecovered != len(tt.wantFiles) {
+				t.Errorf("recovered %d files, want %d", report.FilesRecovered, len(tt.wantFiles))
+			}
+
+			// The damaged file is kept as it was
+			kept, err := os.ReadFile(report.CorruptPath)
+			if err != nil {
+				t.Fatalf("damaged file not kept: %v", err)
+			}
+			if !bytes.Equal(kept, damaged) {
+				t.Error("the kept copy differs from the damaged file")
+			}
+
+			d, err := Open(path)
+			if err != nil {
+				t.Fatalf("opening the recovered database: %v", err)
+			}
+			defer d.Close()
+			for _, id := range tt.wantFiles {
+				if meta, _ := d.GetFileMetadataByID(id); meta == nil {
+					t.Errorf("file %d was not salvaged", id)
+				}
+			}
+			if n := len(d.GetFilePathIndex()); n != len(tt.wantFiles) {
+				t.Errorf("recovered database holds %d files, want %d", n, len(tt.wantFiles))
+			}
+
+			apiKey := d.GetConfig("auth.api_key")
+			if tt.keptKey {
+				if apiKey != "kept-api-key" || len(report.Credentials) != 0 {
+					t.Errorf("api key %q and regenerated %v, want the salvaged key kept", apiKey, report.Credentials)
+				}
+			} else if apiKey == "" || apiKey != report.Credentials["auth.api_key"] || apiKey == DefaultConfigValue("auth.api_key") {
+				t.Errorf("api key %q, want the regenerated %q", apiKey, report.Credentials["auth.api_key"])
+			}
+		})
+	}
+}
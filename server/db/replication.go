@@ -0,0 +1,113 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Replication operations
+const (
+	ReplicateUpload = "upload" // Send the file and its metadata
+	ReplicateDelete = "delete" // Remove the file on the target
+	ReplicateExpiry = "expiry" // Update the file's TTL and expiry time
+)
+
+// ReplicationItem is a change waiting to be pushed to the replication target
+type ReplicationItem struct {
+	ID          int64     `json:"id"`
+	Op          string    `json:"op"`
+	FilePath    string    `json:"file_path"`
+	CreatedAt   time.Time `json:"created_at"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// ReplicationStatus summarizes the replication queue for the stats endpoint
+type ReplicationStatus struct {
+	Target        string     `json:"target"`
+	QueueDepth    int        `json:"queue_depth"`
+	OldestPending *time.Time `json:"oldest_pending,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+}
+
+// EnqueueReplication appends a change to the replication queue
+func (d *Database) EnqueueReplication(op, filePath string) error {
+	if d.readOnly {
+		return fmt.Errorf("database is open read-only")
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	var id int64 = 1
+	if n := len(d.data.ReplicationQueue); n > 0 {
+		id = d.data.ReplicationQueue[n-1].ID + 1
+	}
+	d.data.ReplicationQueue = append(d.data.ReplicationQueue, &ReplicationItem{
+		ID:        id,
+		Op:        op,
+		FilePath:  filePath,
+		CreatedAt: time.Now(),
+	})
+	d.triggerSave()
+	return nil
+}
+
+// PendingReplication returns the queued changes, oldest first
+func (d *Database) PendingReplication() []ReplicationItem {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	items := make([]ReplicationItem, 0, len(d.data.ReplicationQueue))
+	for _, item := range d.data.ReplicationQueue {
+		items = append(items, *item)
+	}
+	return items
+}
+
+// ReplicationQueueDepth returns the number of queued changes and the time the
+// oldest was queued (zero when the queue is empty)
+func (d *Database) ReplicationQueueDepth() (int, time.Time) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	if len(d.data.ReplicationQueue) == 0 {
+		return 0, time.Time{}
+	}
+	return len(d.data.ReplicationQueue), d.data.ReplicationQueue[0].CreatedAt
+}
+
+// UpdateReplication records a failed attempt on a queued change
+func (d *Database) UpdateReplication(item ReplicationItem) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	for _, queued := range d.data.ReplicationQueue {
+		if queued.ID == item.ID {
+			queued.Attempts = item.Attempts
+			queued.NextAttempt = item.NextAttempt
+			queued.LastError = item.LastError
+			d.triggerSave()
+			return nil
+		}
+	}
+	return nil
+}
+
+// RemoveReplication drops a change from the queue once it has been replicated
+func (d *Database) RemoveReplication(id int64) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	for i, queued := range d.data.ReplicationQueue {
+		if queued.ID == id {
+			d.data.ReplicationQueue = append(d.data.ReplicationQueue[:i:i], d.data.ReplicationQueue[i+1:]...)
+			d.triggerSave()
+			return nil
+		}
+	}
+	return nil
+}
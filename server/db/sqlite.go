@@ -0,0 +1,318 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// sqliteDriverName is the database/sql driver registered by modernc.org/sqlite.
+// The driver is linked in by sqlite_driver.go when building with -tags sqlite.
+const sqliteDriverName = "sqlite"
+
+// ErrSQLiteUnavailable is returned when the sqlite driver is requested from a
+// build without SQLite support
+var ErrSQLiteUnavailable = errors.New("SQLite support is not compiled in (rebuild with -tags sqlite)")
+
+// sqliteSchema stores each file record as a JSON document keyed by ID, so new
+// FileMetadata fields need no migrations. Small singleton state (next ID and the
+// report histories) lives in the state table.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS files (
+	id        INTEGER PRIMARY KEY,
+	file_path TEXT NOT NULL,
+	data      TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS config (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS state (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);`
+
+// sqliteBackend writes every record change through to a SQLite database
+type sqliteBackend struct {
+	conn *sql.DB
+}
+
+// openSQLiteBackend opens (creating if needed) a SQLite database
+func openSQLiteBackend(path string) (*sqliteBackend, error) {
+	if !sqliteAvailable() {
+		return nil, ErrSQLiteUnavailable
+	}
+
+	conn, err := sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	// A single connection keeps writes serialized and pragmas in effect
+	conn.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=NORMAL",
+		"PRAGMA busy_timeout=5000",
+		sqliteSchema,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to initialize %s: %w", path, err)
+		}
+	}
+
+	return &sqliteBackend{conn: conn}, nil
+}
+
+// sqliteAvailable reports whether the SQLite driver is registered
+func sqliteAvailable() bool {
+	for _, name := range sql.Drivers() {
+		if name == sqliteDriverName {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *sqliteBackend) Name() string { return DriverSQLite }
+
+func (b *sqliteBackend) Load(data *DatabaseData) error {
+	rows, err := b.conn.Query("SELECT data FROM files")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return err
+		}
+		meta := &FileMetadata{}
+		if err := json.Unmarshal([]byte(raw), meta); err != nil {
+			return fmt.Errorf("corrupt file record: %w", err)
+		}
+		data.Files[meta.ID] = meta
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cfgRows, err := b.conn.Query("SELECT key, value FROM config")
+	if err != nil {
+		return err
+	}
+	defer cfgRows.Close()
+	for cfgRows.Next() {
+		var key, value string
+		if err := cfgRows.Scan(&key, &value); err != nil {
+			return err
+		}
+		data.Config[key] = value
+	}
+	if err := cfgRows.Err(); err != nil {
+		return err
+	}
+
+	state, err := b.loadState()
+	if err != nil {
+		return err
+	}
+	if v, ok := state["next_id"]; ok {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			data.NextID = id
+		}
+	}
+	// next_id is only written on flush, so never trust it below a stored record
+	for id := range data.Files {
+		if id >= data.NextID {
+			data.NextID = id + 1
+		}
+	}
+	if v, ok := state["cleanup_reports"]; ok {
+		json.Unmarshal([]byte(v), &data.CleanupReports)
+	}
+	if v, ok := state["verify_reports"]; ok {
+		json.Unmarshal([]byte(v), &data.VerifyReports)
+	}
+	if v, ok := state["audit_log"]; ok {
+		json.Unmarshal([]byte(v), &data.AuditLog)
+	}
+	if v, ok := state["api_tokens"]; ok {
+		json.Unmarshal([]byte(v), &data.APITokens)
+	}
+	if v, ok := state["upload_usage"]; ok {
+		json.Unmarshal([]byte(v), &data.UploadUsage)
+	}
+	if v, ok := state["bans"]; ok {
+		json.Unmarshal([]byte(v), &data.Bans)
+	}
+	if v, ok := state["idempotency_keys"]; ok {
+		json.Unmarshal([]byte(v), &data.IdempotencyKeys)
+	}
+	if v, ok := state["replication_queue"]; ok {
+		json.Unmarshal([]byte(v), &data.ReplicationQueue)
+	}
+
+	return nil
+}
+
+func (b *sqliteBackend) loadState() (map[string]string, error) {
+	rows, err := b.conn.Query("SELECT key, value FROM state")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		state[key] = value
+	}
+	return state, rows.Err()
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func putFile(e execer, meta *FileMetadata) error {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = e.Exec("INSERT OR REPLACE INTO files (id, file_path, data) VALUES (?, ?, ?)", meta.ID, meta.FilePath, string(raw))
+	return err
+}
+
+func putKey(e execer, table, key, value string) error {
+	_, err := e.Exec("INSERT OR REPLACE INTO "+table+" (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+func (b *sqliteBackend) PutFile(meta *FileMetadata) error {
+	return putFile(b.conn, meta)
+}
+
+func (b *sqliteBackend) DeleteFile(id int64) error {
+	_, err := b.conn.Exec("DELETE FROM files WHERE id = ?", id)
+	return err
+}
+
+func (b *sqliteBackend) PutConfig(key, value string) error {
+	return putKey(b.conn, "config", key, value)
+}
+
+func (b *sqliteBackend) DeleteConfig(key string) error {
+	_, err := b.conn.Exec("DELETE FROM config WHERE key = ?", key)
+	return err
+}
+
+// Flush stores the state that isn't written per record
+func (b *sqliteBackend) Flush(data *DatabaseData) error {
+	tx, err := b.conn.Begin()
+	if err != nil {
+		return err
+	}
+	if err := flushState(tx, data); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func flushState(e execer, data *DatabaseData) error {
+	cleanupReports, err := json.Marshal(data.CleanupReports)
+	if err != nil {
+		return err
+	}
+	verifyReports, err := json.Marshal(data.VerifyReports)
+	if err != nil {
+		return err
+	}
+	auditLog, err := json.Marshal(data.AuditLog)
+	if err != nil {
+		return err
+	}
+	apiTokens, err := json.Marshal(data.APITokens)
+	if err != nil {
+		return err
+	}
+	uploadUsage, err := json.Marshal(data.UploadUsage)
+	if err != nil {
+		return err
+	}
+	bans, err := json.Marshal(data.Bans)
+	if err != nil {
+		return err
+	}
+	idempotencyKeys, err := json.Marshal(data.IdempotencyKeys)
+	if err != nil {
+		return err
+	}
+	replicationQueue, err := json.Marshal(data.ReplicationQueue)
+	if err != nil {
+		return err
+	}
+
+	if err := putKey(e, "state", "next_id", strconv.FormatInt(data.NextID, 10)); err != nil {
+		return err
+	}
+	if err := putKey(e, "state", "cleanup_reports", string(cleanupReports)); err != nil {
+		return err
+	}
+	if err := putKey(e, "state", "verify_reports", string(verifyReports)); err != nil {
+		return err
+	}
+	if err := putKey(e, "state", "audit_log", string(auditLog)); err != nil {
+		return err
+	}
+	if err := putKey(e, "state", "api_tokens", string(apiTokens)); err != nil {
+		return err
+	}
+	if err := putKey(e, "state", "upload_usage", string(uploadUsage)); err != nil {
+		return err
+	}
+	if err := putKey(e, "state", "bans", string(bans)); err != nil {
+		return err
+	}
+	if err := putKey(e, "state", "idempotency_keys", string(idempotencyKeys)); err != nil {
+		return err
+	}
+	return putKey(e, "state", "replication_queue", string(replicationQueue))
+}
+
+// importData writes a complete database in a single transaction
+func (b *sqliteBackend) importData(data *DatabaseData) error {
+	tx, err := b.conn.Begin()
+	if err != nil {
+		return err
+	}
+	for _, meta := range data.Files {
+		if err := putFile(tx, meta); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for key, value := range data.Config {
+		if err := putKey(tx, "config", key, value); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := flushState(tx, data); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *sqliteBackend) Close() error {
+	return b.conn.Close()
+}
@@ -0,0 +1,6 @@
+// +build sqlite
+
+package db
+
+// Registers the pure-Go (CGO-free) SQLite driver used by the SQLite backend
+import _ "modernc.org/sqlite"
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DayStats counts the uploads stored under one date directory
+type DayStats struct {
+	Date    string `json:"date"`
+	Uploads int    `json:"uploads"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// UploaderStats counts the uploads from one IP address
+type UploaderStats struct {
+	IP      string `json:"ip"`
+	Uploads int    `json:"uploads"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// DetailedStats breaks stored files down by day and uploader. The totals
+// include expired files awaiting cleanup, which are also counted apart.
+type DetailedStats struct {
+	TotalFiles        int             `json:"total_files"`
+	TotalSize         int64           `json:"total_size"`
+	ExpiredFiles      int             `json:"expired_files"`
+	ExpiredSize       int64           `json:"expired_size"`
+	Days              []DayStats      `json:"days"`                   // Oldest first
+	TopUploadersCount []UploaderStats `json:"top_uploaders_by_count"` // Most uploads first
+	TopUploadersSize  []UploaderStats `json:"top_uploaders_by_size"`  // Most bytes first
+	Expiring24h       int             `json:"expiring_24h"`
+	Expiring7d        int             `json:"expiring_7d"`
+
+	// Filled in by the caller: rolling 24h upload usage per API key and IP
+	UploadUsage      []Usage            `json:"upload_usage"`
+	UploadQuotaBytes int64              `json:"upload_quota_per_day_bytes"`
+	Replication      *ReplicationStatus `json:"replication,omitempty"`
+}
+
+// GetDetailedStats aggregates live files uploaded in [from, to) in a single pass
+// under the read lock. A zero from or to leaves that end unbounded; topN limits the
+// uploader lists. Expiry counts cover the same files.
+func (d *Database) GetDetailedStats(from, to time.Time, topN int) (*DetailedStats, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	now := time.Now()
+	stats := &DetailedStats{}
+	days := make(map[string]*DayStats)
+	uploaders := make(map[string]*UploaderStats)
+
+	for _, meta := range d.data.Files {
+		if meta.IsDeleted() {
+			continue
+		}
+		if (!from.IsZero() && meta.UploadedAt.Before(from)) || (!to.IsZero() && !meta.UploadedAt.Before(to)) {
+			continue
+		}
+
+		stats.TotalFiles++
+		stats.TotalSize += meta.FileSize
+
+		date := indexDate(meta.FilePath)
+		day := days[date]
+		if day == nil {
+			day = &DayStats{Date: date}
+			days[date] = day
+		}
+		day.Uploads++
+		day.Bytes += meta.FileSize
+
+		ip := uploaderIP(meta.RemoteIP)
+		uploader := uploaders[ip]
+		if uploader == nil {
+			uploader = &UploaderStats{IP: ip}
+			uploaders[ip] = uploader
+		}
+		uploader.Uploads++
+		uploader.Bytes += meta.FileSize
+
+		if meta.IsExpired(now) {
+			stats.ExpiredFiles++
+			stats.ExpiredSize += meta.FileSize
+		} else if !meta.IsPinned() {
+			if left := meta.ExpiresAt.Sub(now); left <= 24*time.Hour {
+				stats.Expiring24h++
+				stats.Expiring7d++
+			} else if left <= 7*24*time.Hour {
+				stats.Expiring7d++
+			}
+		}
+	}
+
+	stats.Days = make([]DayStats, 0, len(days))
+	for _, day := range days {
+		stats.Days = append(stats.Days, *day)
+	}
+	sort.Slice(stats.Days, func(i, j int) bool { return stats.Days[i].Date < stats.Days[j].Date })
+
+	all := make([]UploaderStats, 0, len(uploaders))
+	for _, uploader := range uploaders {
+		all = append(all, *uploader)
+	}
+	stats.TopUploadersCount = topUploaders(all, topN, func(a, b UploaderStats) bool {
+		if a.Uploads != b.Uploads {
+			return a.Uploads > b.Uploads
+		}
+		return a.Bytes > b.Bytes
+	})
+	stats.TopUploadersSize = topUploaders(all, topN, func(a, b UploaderStats) bool {
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Uploads > b.Uploads
+	})
+
+	return stats, nil
+}
+
+// uploaderIP returns the normalized address of a record's uploader; records
+// from before addresses were normalized may carry a port
+func uploaderIP(remote string) string {
+	if ip := NormalizeIP(remote); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+// NormalizeIP reduces a client address to the canonical form of its IP: any
+// port, brackets and IPv6 zone are dropped, and IPv4-mapped IPv6 addresses
+// become plain IPv4. It returns "" for a value that isn't an IP address.
+func NormalizeIP(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// topUploaders returns the first n uploaders under the given order, breaking ties
+// by IP so the result is stable
+func topUploaders(all []UploaderStats, n int, less func(a, b UploaderStats) bool) []UploaderStats {
+	sorted := make([]UploaderStats, len(all))
+	copy(sorted, all)
+	sort.Slice(sorted, func(i, j int) bool {
+		if less(sorted[i], sorted[j]) {
+			return true
+		}
+		if less(sorted[j], sorted[i]) {
+			return false
+		}
+		return sorted[i].IP < sorted[j].IP
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
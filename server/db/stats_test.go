@@ -0,0 +1,49 @@
+package db
+
+import "testing"
+
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		// IPv4
+		{"192.0.2.1", "192.0.2.1"},
+		{"192.0.2.1:1234", "192.0.2.1"},
+		{" 192.0.2.1 ", "192.0.2.1"},
+		{"010.000.000.001", ""},
+
+		// IPv6, compressed to one spelling
+		{"2001:db8::1", "2001:db8::1"},
+		{"2001:DB8:0:0:0:0:0:1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[2001:db8::1]:8080", "2001:db8::1"},
+		{"::1", "::1"},
+
+		// Zones are dropped
+		{"fe80::1%eth0", "fe80::1"},
+		{"[fe80::1%eth0]:8080", "fe80::1"},
+		{"fe80::1%25en0", "fe80::1"},
+
+		// IPv4-mapped IPv6 is the IPv4 address
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"[::ffff:192.0.2.1]:443", "192.0.2.1"},
+		{"::ffff:c000:201", "192.0.2.1"},
+
+		// Malformed
+		{"", ""},
+		{"unknown", ""},
+		{"192.0.2", ""},
+		{"192.0.2.256", ""},
+		{"192.0.2.1:", "192.0.2.1"},
+		{"2001:db8::g", ""},
+		{"example.com:80", ""},
+		{"192.0.2.1, 198.51.100.1", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeIP(tt.addr); got != tt.want {
+			t.Errorf("NormalizeIP(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
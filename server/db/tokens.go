@@ -0,0 +1,168 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// API token scopes
+const (
+	ScopeUpload    = "upload"
+	ScopeDelete    = "delete"
+	ScopeList      = "list"
+	ScopeAdminRead = "admin-read"
+	ScopeReplicate = "replicate" // Push files from a primary server (see replication.*)
+)
+
+// Scopes lists every scope a token can be granted
+var Scopes = []string{ScopeUpload, ScopeDelete, ScopeList, ScopeAdminRead, ScopeReplicate}
+
+// tokenPrefix marks API tokens so they are easy to recognise in logs and configs
+const tokenPrefix = "hst_"
+
+// APIToken is a scoped API key. Only a hash of the key is stored.
+type APIToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// QuotaPerDayBytes overrides security.upload_quota_per_day_bytes for this
+	// token: 0 uses the global quota, a negative value means unlimited
+	QuotaPerDayBytes int64 `json:"quota_per_day_bytes,omitempty"`
+}
+
+// HasScope reports whether the token grants scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the token has passed its expiry time
+func (t *APIToken) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// ValidateScopes checks that every scope is known
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		known := false
+		for _, s := range Scopes {
+			if scope == s {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown scope %q (valid: %v)", scope, Scopes)
+		}
+	}
+	return nil
+}
+
+// hashToken returns the stored form of an API token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken mints a token with the given scopes and upload quota (see
+// APIToken.QuotaPerDayBytes). expiresAt may be nil for a token that never
+// expires. The key itself is returned only here.
+func (d *Database) CreateAPIToken(name string, scopes []string, expiresAt *time.Time, quota int64) (string, *APIToken, error) {
+	if d.readOnly {
+		return "", nil, fmt.Errorf("database is open read-only")
+	}
+	if err := ValidateScopes(scopes); err != nil {
+		return "", nil, err
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret := hex.EncodeToString(b)
+	key := tokenPrefix + secret
+	token := &APIToken{
+		ID:        secret[:8],
+		Name:      name,
+		Hash:      hashToken(key),
+		Scopes:    append([]string(nil), scopes...),
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+
+		QuotaPerDayBytes: quota,
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.data.APITokens = append(d.data.APITokens, token)
+	if err := d.save(); err != nil {
+		d.data.APITokens = d.data.APITokens[:len(d.data.APITokens)-1]
+		return "", nil, err
+	}
+	copied := *token
+	return key, &copied, nil
+}
+
+// LookupAPIToken returns the token matching key, expired or not, or nil
+func (d *Database) LookupAPIToken(key string) *APIToken {
+	hash := hashToken(key)
+
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	for _, token := range d.data.APITokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) == 1 {
+			copied := *token
+			return &copied
+		}
+	}
+	return nil
+}
+
+// ListAPITokens returns all tokens, newest first
+func (d *Database) ListAPITokens() []APIToken {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	tokens := make([]APIToken, 0, len(d.data.APITokens))
+	for _, token := range d.data.APITokens {
+		tokens = append(tokens, *token)
+	}
+	sort.SliceStable(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// RevokeAPIToken deletes the token with the given ID and reports whether it existed
+func (d *Database) RevokeAPIToken(id string) (bool, error) {
+	if d.readOnly {
+		return false, fmt.Errorf("database is open read-only")
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	for i, token := range d.data.APITokens {
+		if token.ID != id {
+			continue
+		}
+		d.data.APITokens = append(d.data.APITokens[:i:i], d.data.APITokens[i+1:]...)
+		return true, d.save()
+	}
+	return false, nil
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ImportMode selects how Import treats a database that already holds files
+type ImportMode int
+
+const (
+	// ImportNew only imports into a database without file records
+	ImportNew ImportMode = iota
+	// ImportMerge keeps existing records and adds the imported ones with new IDs
+	ImportMerge
+	// ImportReplace discards existing records and config in favour of the import
+	ImportReplace
+)
+
+// ImportResult summarizes an Import
+type ImportResult struct {
+	Imported int // Records added
+	Skipped  int // Records whose path was already present (merge only)
+	Replaced int // Existing records discarded (replace only)
+}
+
+// preservedOnImport are config keys describing the local installation rather
+// than the exported data, so a replacing import keeps the target's values
+var preservedOnImport = []string{
+	"storage.images_dir", "backup.dir", "database.driver", "database.path",
+	"storage.backend", "storage.s3_endpoint", "storage.s3_bucket", "storage.s3_region",
+	"storage.s3_access_key", "storage.s3_secret", "storage.s3_prefix", "storage.s3_path_style",
+}
+
+// Snapshot returns the complete database as JSON. It is taken under the read
+// lock, so it is consistent even while a server is writing.
+func (d *Database) Snapshot() ([]byte, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	return json.MarshalIndent(d.data, "", "  ")
+}
+
+// IsEmpty reports whether the database holds no file records
+func (d *Database) IsEmpty() bool {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	return len(d.data.Files) == 0
+}
+
+// Import loads exported data. With ImportNew the database must not hold any file
+// records; ImportMerge adds records whose path isn't already present under fresh
+// IDs (dropping slugs that are taken); ImportReplace swaps in the exported
+// records, config and history wholesale, keeping the local storage paths.
+func (d *Database) Import(data *DatabaseData, mode ImportMode) (ImportResult, error) {
+	if d.readOnly {
+		return ImportResult{}, fmt.Errorf("database is open read-only")
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	var result ImportResult
+	switch mode {
+	case ImportNew:
+		if len(d.data.Files) > 0 {
+			return result, fmt.Errorf("database already holds %d files; choose merge or replace", len(d.data.Files))
+		}
+		fallthrough
+	case ImportReplace:
+		result.Replaced = len(d.data.Files)
+		if err := d.replaceLocked(data); err != nil {
+			return result, err
+		}
+		result.Imported = len(data.Files)
+	case ImportMerge:
+		ids := make([]int64, 0, len(data.Files))
+		for id := range data.Files {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		for _, id := range ids {
+			meta := *data.Files[id]
+			if d.findPathLocked(meta.FilePath) != nil {
+				result.Skipped++
+				continue
+			}
+			if meta.Slug != "" && d.findSlugLocked(meta.Slug) != nil {
+				meta.Slug = ""
+			}
+			meta.ID = d.data.NextID
+			d.data.NextID++
+			d.data.Files[meta.ID] = &meta
+			d.index.add(&meta)
+			if err := d.fileChanged(&meta); err != nil {
+				return result, err
+			}
+			result.Imported++
+		}
+	default:
+		return result, fmt.Errorf("unknown import mode %d", mode)
+	}
+
+	return result, d.save()
+}
+
+// replaceLocked swaps the database contents for data (caller must hold the write lock)
+func (d *Database) replaceLocked(data *DatabaseData) error {
+	for id := range d.data.Files {
+		if err := d.fileRemoved(id); err != nil {
+			return err
+		}
+	}
+
+	cfg := make(map[string]string, len(data.Config))
+	for key, value := range data.Config {
+		cfg[key] = value
+	}
+	for _, key := range preservedOnImport {
+		if value, ok := d.data.Config[key]; ok {
+			cfg[key] = value
+		} else {
+			delete(cfg, key)
+		}
+	}
+	for key := range d.data.Config {
+		if _, ok := cfg[key]; !ok {
+			if err := d.configRemoved(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	nextID := data.NextID
+	if nextID < 1 {
+		nextID = 1
+	}
+	files := make(map[int64]*FileMetadata, len(data.Files))
+	for id, meta := range data.Files {
+		files[id] = meta
+		if id >= nextID {
+			nextID = id + 1
+		}
+	}
+	d.data = &DatabaseData{
+		Files:          files,
+		NextID:         nextID,
+		Config:         cfg,
+		CleanupReports: data.CleanupReports,
+		VerifyReports:  data.VerifyReports,
+		APITokens:      data.APITokens,
+		// The audit trail, bans and replication queue belong to this installation,
+		// not the imported data
+		AuditLog:         d.data.AuditLog,
+		Bans:             d.data.Bans,
+		ReplicationQueue: d.data.ReplicationQueue,
+	}
+	d.index = newFileIndex(files)
+	d.changes++
+	d.genEpoch = d.changes
+	d.dateGens = make(map[string]uint64)
+
+	for _, meta := range files {
+		if err := d.fileChanged(meta); err != nil {
+			return err
+		}
+	}
+	for key := range cfg {
+		if err := d.configChanged(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// UsageWindow is the rolling period upload quotas are measured over
+const UsageWindow = 24 * time.Hour
+
+// usageBucketSize is the granularity of usage tracking; usage leaves the window
+// one bucket at a time
+const usageBucketSize = time.Hour
+
+// UsageBucket counts the uploads by one subject (an API key or IP address) in
+// one hour
+type UsageBucket struct {
+	Subject string    `json:"subject"`
+	Start   time.Time `json:"start"`
+	Bytes   int64     `json:"bytes"`
+	Count   int       `json:"count"`
+}
+
+// Usage is a subject's upload total over the rolling window
+type Usage struct {
+	Subject string    `json:"subject"`
+	Bytes   int64     `json:"bytes"`
+	Count   int       `json:"count"`
+	ResetAt time.Time `json:"reset_at,omitempty"` // When the oldest counted upload leaves the window
+}
+
+// RecordUpload adds an upload of size bytes to each subject's usage and prunes
+// buckets that have left the window
+func (d *Database) RecordUpload(subjects []string, size int64) {
+	now := time.Now()
+	start := now.Truncate(usageBucketSize)
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.pruneUsageLocked(now)
+	for _, subject := range subjects {
+		var bucket *UsageBucket
+		for i := range d.data.UploadUsage {
+			if b := &d.data.UploadUsage[i]; b.Subject == subject && b.Start.Equal(start) {
+				bucket = b
+				break
+			}
+		}
+		if bucket == nil {
+			d.data.UploadUsage = append(d.data.UploadUsage, UsageBucket{Subject: subject, Start: start})
+			bucket = &d.data.UploadUsage[len(d.data.UploadUsage)-1]
+		}
+		bucket.Bytes += size
+		bucket.Count++
+	}
+	d.triggerSave()
+}
+
+// pruneUsageLocked drops buckets that have left the window (caller must hold the
+// write lock)
+func (d *Database) pruneUsageLocked(now time.Time) {
+	kept := d.data.UploadUsage[:0]
+	for _, b := range d.data.UploadUsage {
+		if inUsageWindow(b, now) {
+			kept = append(kept, b)
+		}
+	}
+	d.data.UploadUsage = kept
+}
+
+func inUsageWindow(b UsageBucket, now time.Time) bool {
+	return b.Start.Add(UsageWindow).After(now)
+}
+
+// GetUsage returns a subject's usage over the rolling window
+func (d *Database) GetUsage(subject string) Usage {
+	now := time.Now()
+	usage := Usage{Subject: subject}
+
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	for _, b := range d.data.UploadUsage {
+		if b.Subject != subject || !inUsageWindow(b, now) {
+			continue
+		}
+		usage.add(b)
+	}
+	return usage
+}
+
+func (u *Usage) add(b UsageBucket) {
+	u.Bytes += b.Bytes
+	u.Count += b.Count
+	if reset := b.Start.Add(UsageWindow); u.ResetAt.IsZero() || reset.Before(u.ResetAt) {
+		u.ResetAt = reset
+	}
+}
+
+// GetAllUsage returns every subject's usage over the rolling window, largest first
+func (d *Database) GetAllUsage() []Usage {
+	now := time.Now()
+	bySubject := make(map[string]*Usage)
+
+	d.mux.RLock()
+	for _, b := range d.data.UploadUsage {
+		if !inUsageWindow(b, now) {
+			continue
+		}
+		u, ok := bySubject[b.Subject]
+		if !ok {
+			u = &Usage{Subject: b.Subject}
+			bySubject[b.Subject] = u
+		}
+		u.add(b)
+	}
+	d.mux.RUnlock()
+
+	all := make([]Usage, 0, len(bySubject))
+	for _, u := range bySubject {
+		all = append(all, *u)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Bytes != all[j].Bytes {
+			return all[i].Bytes > all[j].Bytes
+		}
+		return all[i].Subject < all[j].Subject
+	})
+	return all
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpserver/server/config"
+	"httpserver/server/db"
+	"httpserver/server/httpd"
+	"httpserver/server/service"
+)
+
+// Outcomes of a doctor check, from best to worst; the worst one is the exit code
+const (
+	doctorPass = "PASS"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+)
+
+var doctorExitCodes = map[string]int{doctorPass: 0, doctorWarn: 1, doctorFail: 2}
+
+// clockSkewTolerance is how far stored timestamps may be ahead of the clock
+// before doctor suspects the clock went back
+const clockSkewTolerance = 5 * time.Minute
+
+// doctorCheck is the outcome of one doctor check
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"` // What to do about a WARN or FAIL
+}
+
+// doctor checks the environment a server would run in
+type doctor struct {
+	dbPath   string
+	command  string // How to run this binary on the same database, for hints
+	cfg      *config.Config
+	database *db.Database // Nil unless it could be opened here
+	running  *controlInfo // The server holding the database, if any
+	checks   []doctorCheck
+}
+
+func (d *doctor) add(name, status, detail, hint string) {
+	d.checks = append(d.checks, doctorCheck{Name: name, Status: status, Detail: detail, Hint: hint})
+}
+
+func handleDoctorCommand(args []string, dbPath string) {
+	// --json prints the results for scripts
+	asJSON := false
+	filtered := args[:0:0]
+	for _, arg := range args {
+		if arg == "--json" || arg == "-json" {
+			asJSON = true
+			continue
+		}
+		filtered = append(filtered, arg)
+	}
+	args = filtered
+
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: httpserver doctor [-c <path>] [--json]")
+		os.Exit(doctorExitCodes[doctorFail])
+	}
+
+	fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
+	d := &doctor{dbPath: dbPath, command: "httpserver"}
+	if dbPath != getDefaultDBPath() {
+		d.command += " -c " + dbPath
+	}
+
+	d.checkDatabase()
+	if d.cfg == nil {
+		// Nothing readable; the remaining checks go by the defaults
+		d.cfg = buildConfigFromDB(configMap{})
+	}
+	d.checkImagesDir()
+	d.checkListeners()
+	d.checkLimits()
+	d.checkCredentials()
+	d.checkDiskSpace()
+	d.checkService()
+	d.checkClock()
+	if d.database != nil {
+		d.database.Close()
+	}
+
+	worst := doctorPass
+	for _, check := range d.checks {
+		if doctorExitCodes[check.Status] > doctorExitCodes[worst] {
+			worst = check.Status
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		enc.Encode(struct {
+			Status string        `json:"status"`
+			Checks []doctorCheck `json:"checks"`
+		}{worst, d.checks})
+	} else {
+		fmt.Printf("%-6s %-18s %s\n", "STATUS", "CHECK", "DETAIL")
+		for _, check := range d.checks {
+			fmt.Printf("%-6s %-18s %s\n", check.Status, check.Name, check.Detail)
+			if check.Hint != "" {
+				fmt.Printf("%-6s %-18s -> %s\n", "", "", check.Hint)
+			}
+		}
+	}
+	os.Exit(doctorExitCodes[worst])
+}
+
+// checkDatabase opens the database read-only, or reads the settings through
+// the running server when that holds it
+func (d *doctor) checkDatabase() {
+	const name = "Database"
+	if _, err := os.Stat(d.dbPath); os.IsNotExist(err) {
+		d.add(name, doctorWarn, "No database at "+d.dbPath+" yet", "Run '"+d.command+" init' to create and set it up")
+		return
+	} else if err != nil {
+		d.add(name, doctorFail, err.Error(), "Check the permissions of the directories above it")
+		return
+	}
+
+	// Opened without truncating, only to learn whether the server could write it
+	var writeErr error
+	if f, err := os.OpenFile(d.dbPath, os.O_RDWR, 0); err != nil {
+		writeErr = err
+	} else {
+		f.Close()
+	}
+	permHint := "Make the user the server runs as the owner of " + d.dbPath
+
+	database, err := db.OpenReadOnly(d.dbPath)
+	var corruptErr *db.CorruptError
+	var lockedErr *db.LockedError
+	switch {
+	case errors.As(err, &corruptErr):
+		d.add(name, doctorFail, "Can't be parsed: "+err.Error(), "Start the server once with --recover to salvage it (the damaged file is kept)")
+	case errors.As(err, &lockedErr):
+		if info, infoErr := readControlFile(d.dbPath); infoErr == nil {
+			if values, err := serverConfig(info, true); err == nil {
+				d.running = info
+				d.cfg = buildConfigFromDB(values)
+				d.add(name, doctorPass, fmt.Sprintf("In use by the running server (PID %d); settings read through it", info.PID), "")
+				return
+			}
+		}
+		d.add(name, doctorFail, "Locked by a process that doesn't answer: "+err.Error(), "Stop the process holding it")
+	case err != nil:
+		d.add(name, doctorFail, "Can't be opened: "+err.Error(), permHint)
+	default:
+		d.database = database
+		d.cfg = buildConfigFromDB(database)
+		counts, _ := database.GetFileCounts()
+		detail := fmt.Sprintf("Parsed; %d files recorded", counts.LiveFiles+counts.ExpiredFiles)
+		if writeErr != nil {
+			d.add(name, doctorFail, detail+", but not writable: "+writeErr.Error(), permHint)
+			return
+		}
+		d.add(name, doctorPass, detail+"; readable and writable", "")
+	}
+}
+
+// checkImagesDir writes and removes a file in the storage directory
+func (d *doctor) checkImagesDir() {
+	const name = "Storage directory"
+	dir := d.cfg.Storage.ImagesDir
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		d.add(name, doctorWarn, dir+" doesn't exist yet; the server creates it at start", "Make sure the server's user can create it")
+		return
+	case err != nil:
+		d.add(name, doctorFail, err.Error(), "Check the permissions of "+dir)
+		return
+	case !info.IsDir():
+		d.add(name, doctorFail, dir+" is not a directory", "Point storage.images_dir at a directory with '"+d.command+" set storage.images_dir <dir>'")
+		return
+	}
+
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err == nil {
+		_, err = f.WriteString("doctor")
+		f.Close()
+		if removeErr := os.Remove(f.Name()); err == nil {
+			err = removeErr
+		}
+	}
+	if err != nil {
+		d.add(name, doctorFail, "Can't write to "+dir+": "+err.Error(), "Make the user the server runs as the owner of "+dir)
+		return
+	}
+	d.add(name, doctorPass, dir+" is writable", "")
+}
+
+// checkListeners tries to bind each configured TCP address, naming whoever
+// holds one that is taken
+func (d *doctor) checkListeners() {
+	const name = "Listen address"
+	moveHint := "Stop it, or move this server with '" + d.command + " set server.port <port>'"
+	for _, addr := range d.cfg.ListenAddresses() {
+		if addr.Network != "tcp" {
+			if _, err := os.Stat(filepath.Dir(addr.Address)); err != nil {
+				d.add(name, doctorFail, addr.String()+": "+err.Error(), "Create the socket's directory")
+			} else {
+				d.add(name, doctorPass, addr.String()+": socket directory exists", "")
+			}
+			continue
+		}
+		_, portText, _ := net.SplitHostPort(addr.Address)
+		port, _ := strconv.Atoi(portText)
+		if port == 0 {
+			d.add(name, doctorPass, addr.String()+": a free port is picked at start", "")
+			continue
+		}
+
+		ln, err := net.Listen("tcp", addr.Address)
+		if err == nil {
+			ln.Close()
+			d.add(name, doctorPass, addr.String()+" is free", "")
+			continue
+		}
+		switch {
+		case d.running != nil:
+			d.add(name, doctorPass, fmt.Sprintf("%s is in use by the running server (PID %d)", addr, d.running.PID), "")
+		case errors.Is(err, os.ErrPermission):
+			d.add(name, doctorFail, "Not allowed to bind "+addr.String(), "Ports below 1024 need root or CAP_NET_BIND_SERVICE; or pick another with '"+d.command+" set server.port <port>'")
+		default:
+			if version, ok := probeServer(d.cfg); ok {
+				d.add(name, doctorWarn, fmt.Sprintf("%s is in use by a server (version %s) on another database", addr, version), moveHint)
+			} else if holder := portHolder(port); holder != "" {
+				d.add(name, doctorFail, fmt.Sprintf("%s is in use by %s", addr, holder), moveHint)
+			} else {
+				d.add(name, doctorFail, fmt.Sprintf("%s can't be bound: %v", addr, err), moveHint)
+			}
+		}
+	}
+}
+
+// checkLimits looks for size and TTL settings that can't work together
+func (d *doctor) checkLimits() {
+	const name = "Limits"
+	storage := d.cfg.Storage
+	ok := true
+	if storage.MaxFileSize <= 0 {
+		ok = false
+		d.add(name, doctorFail, fmt.Sprintf("storage.max_file_size is %d, so every upload is refused", storage.MaxFileSize), "Set it in bytes with '"+d.command+" set storage.max_file_size <bytes>'")
+	}
+	if storage.CleanupInterval <= 0 {
+		ok = false
+		d.add(name, doctorFail, fmt.Sprintf("storage.cleanup_interval is %d, so expired files are never removed", storage.CleanupInterval), "Set it in minutes with '"+d.command+" set storage.cleanup_interval 60'")
+	}
+	if storage.MaxTTL != config.NeverExpires && (storage.DefaultTTL == config.NeverExpires || storage.DefaultTTL > storage.MaxTTL) {
+		ok = false
+		d.add(name, doctorWarn, fmt.Sprintf("storage.default_ttl (%s) is longer than storage.max_ttl (%s)", storage.DefaultTTL, storage.MaxTTL), "Lower storage.default_ttl or raise storage.max_ttl")
+	}
+	if storage.DefaultTTL == config.NeverExpires && !storage.AllowPermanent {
+		ok = false
+		d.add(name, doctorWarn, "storage.default_ttl is never, but storage.allow_permanent is off", "Set storage.default_ttl to a duration, or storage.allow_permanent to true")
+	}
+	if ok {
+		d.add(name, doctorPass, fmt.Sprintf("Uploads up to %d MB, TTL %s by default and at most %s", storage.MaxFileSize/(1024*1024), storage.DefaultTTL, storage.MaxTTL), "")
+	}
+}
+
+// checkCredentials reports credentials left at their built-in defaults
+func (d *doctor) checkCredentials() {
+	const name = "Credentials"
+	defaults := defaultCredentials(d.cfg)
+	if len(defaults) == 0 {
+		d.add(name, doctorPass, "Changed from the defaults", "")
+		return
+	}
+	status := doctorWarn
+	if d.cfg.Security.RequireNonDefaultCredentials {
+		// The server won't start like this
+		status = doctorFail
+	}
+	d.add(name, status, "Still the defaults: "+strings.Join(defaults, ", "), "Run '"+d.command+" set <key> <new-value>' for each, or '"+d.command+" init --force'")
+}
+
+// checkDiskSpace compares the free space for the storage directory with
+// server.min_free_disk_mb, below which uploads are refused
+func (d *doctor) checkDiskSpace() {
+	const name = "Disk space"
+	// The directory may not exist yet; its volume is the nearest existing parent's
+	dir := d.cfg.Storage.ImagesDir
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	free, err := httpd.DiskFree(dir)
+	if err != nil {
+		d.add(name, doctorWarn, "Can't read free space: "+err.Error(), "")
+		return
+	}
+	minFree := uint64(d.cfg.Server.MinFreeDiskMB) * 1024 * 1024
+	detail := fmt.Sprintf("%d MB free for %s", free/(1024*1024), d.cfg.Storage.ImagesDir)
+	switch {
+	case free < minFree:
+		d.add(name, doctorFail, fmt.Sprintf("%s, below server.min_free_disk_mb (%d MB), so uploads are refused", detail, d.cfg.Server.MinFreeDiskMB), "Free up space or move storage.images_dir")
+	case free < minFree+uint64(d.cfg.Storage.MaxFileSize):
+		d.add(name, doctorWarn, detail+", less than one upload of storage.max_file_size above the minimum", "Free up space or move storage.images_dir")
+	default:
+		d.add(name, doctorPass, detail, "")
+	}
+}
+
+// checkService reports whether an installed service is running
+func (d *doctor) checkService() {
+	const name = "Service"
+	switch {
+	case !service.IsInstalled():
+		d.add(name, doctorPass, "Not installed as a service", "")
+	case service.IsActive():
+		d.add(name, doctorPass, "Installed and running", "")
+	default:
+		d.add(name, doctorWarn, "Installed but not running", "Start it with the service manager (on Linux: systemctl start httpserver; journalctl -u httpserver shows why it stopped)")
+	}
+}
+
+// checkClock looks for stored timestamps ahead of the clock, which expiry
+// depends on
+func (d *doctor) checkClock() {
+	const name = "Clock"
+	hint := "Synchronize the system clock (NTP); expiry times are computed from it"
+	now := time.Now()
+	var newest time.Time
+	source := ""
+	if info, err := os.Stat(d.dbPath); err == nil {
+		newest, source = info.ModTime(), "the database was last written"
+	}
+	if d.database != nil {
+		if recent, err := d.database.ListRecentFiles(1, true); err == nil && len(recent) > 0 && recent[0].UploadedAt.After(newest) {
+			newest, source = recent[0].UploadedAt, "the newest upload is recorded"
+		}
+	}
+
+	switch {
+	case newest.After(now.Add(clockSkewTolerance)):
+		d.add(name, doctorFail, fmt.Sprintf("The clock reads %s, but %s at %s", now.Format(time.RFC3339), source, newest.Format(time.RFC3339)), hint)
+	case now.Year() < 2020:
+		d.add(name, doctorFail, "The clock reads "+now.Format(time.RFC3339), hint)
+	default:
+		d.add(name, doctorPass, "The clock reads "+now.Format(time.RFC3339)+", after every stored timestamp", "")
+	}
+}
@@ -0,0 +1,47 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// portHolder names the process listening on a TCP port, or returns "" if it
+// can't be found (processes of other users are hidden without root)
+func portHolder(port int) string {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(table)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n")[1:] {
+			// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[3] != "0A" { // 0A is LISTEN
+				continue
+			}
+			colon := strings.LastIndexByte(fields[1], ':')
+			if p, err := strconv.ParseInt(fields[1][colon+1:], 16, 32); err == nil && int(p) == port {
+				inodes["socket:["+fields[9]+"]"] = true
+			}
+		}
+	}
+	if len(inodes) == 0 {
+		return ""
+	}
+
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if target, err := os.Readlink(fd); err == nil && inodes[target] {
+			pidDir := filepath.Dir(filepath.Dir(fd))
+			name, _ := os.ReadFile(filepath.Join(pidDir, "comm"))
+			return fmt.Sprintf("%s (PID %s)", strings.TrimSpace(string(name)), filepath.Base(pidDir))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package main
+
+// portHolder would name the process listening on a TCP port; there is no
+// portable way to find it here
+func portHolder(port int) string {
+	return ""
+}
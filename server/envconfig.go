@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"httpserver/server/config"
+	"httpserver/server/db"
+)
+
+// configReader is where stored config values come from: the database, or the
+// running server when it holds the database
+type configReader interface {
+	GetConfig(key string) string
+}
+
+// configMap is a set of config values read from the running server
+type configMap map[string]string
+
+// GetConfig returns the value for key, or the built-in default if it's missing
+func (m configMap) GetConfig(key string) string {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	return db.DefaultConfigValue(key)
+}
+
+// envConfigSource reads config values with environment overrides applied on top
+// of the database, so precedence is flags > env > database > defaults
+type envConfigSource struct {
+	database configReader
+}
+
+// lookup returns the environment override for key, if set
+func (s *envConfigSource) lookup(key string) (string, bool) {
+	return os.LookupEnv(config.EnvName(key))
+}
+
+// GetConfig returns the value for key. Invalid values are logged and replaced by
+// the stored value (for a bad environment override) or the built-in default.
+func (s *envConfigSource) GetConfig(key string) string {
+	if v, ok := s.lookup(key); ok {
+		err := config.ValidateValue(key, v)
+		if err == nil || errors.Is(err, config.ErrUnknownKey) {
+			return v
+		}
+		log.Printf("Warning: ignoring %s: %v", config.EnvName(key), err)
+	}
+
+	v := s.database.GetConfig(key)
+	if err := config.ValidateValue(key, v); err != nil && !errors.Is(err, config.ErrUnknownKey) {
+		def := db.DefaultConfigValue(key)
+		log.Printf("Warning: invalid stored config: %v; using default %q", err, config.MaskConfigValue(key, def))
+		return def
+	}
+	return v
+}
+
+// GetConfigInt returns the value for key as an integer
+func (s *envConfigSource) GetConfigInt(key string) int {
+	n, _ := strconv.Atoi(s.GetConfig(key))
+	return n
+}
+
+// GetConfigInt64 returns the value for key as a 64-bit integer
+func (s *envConfigSource) GetConfigInt64(key string) int64 {
+	n, _ := strconv.ParseInt(s.GetConfig(key), 10, 64)
+	return n
+}
+
+// envOverrides returns the known config keys that are overridden by the environment
+func envOverrides(database *db.Database) map[string]string {
+	overrides := make(map[string]string)
+	for key := range database.GetAllConfig() {
+		if v, ok := os.LookupEnv(config.EnvName(key)); ok {
+			overrides[key] = v
+		}
+	}
+	return overrides
+}
+
+// logEnvOverrides lists the keys taken from the environment, without their values
+// since many of them are secrets
+func logEnvOverrides(overrides map[string]string) {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		log.Printf("Config %s overridden by %s", key, config.EnvName(key))
+	}
+}
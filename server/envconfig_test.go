@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"httpserver/server/config"
+)
+
+func TestEnvConfigOverrides(t *testing.T) {
+	stored := configMap{
+		"server.port":           "4900",
+		"server.read_only":      "false",
+		"security.ip_whitelist": "10.0.0.1",
+	}
+	src := &envConfigSource{database: stored}
+
+	t.Setenv("HTTPSERVER_SERVER_PORT", "8443")
+	t.Setenv("HTTPSERVER_SERVER_READ_ONLY", "true")
+	t.Setenv("HTTPSERVER_SECURITY_IP_WHITELIST", "192.0.2.1,198.51.100.0/24")
+
+	if got := src.GetConfigInt("server.port"); got != 8443 {
+		t.Errorf("server.port = %d, want 8443 from the environment", got)
+	}
+	if got := src.GetConfig("server.read_only"); got != "true" {
+		t.Errorf("server.read_only = %q, want \"true\" from the environment", got)
+	}
+
+	cfg := buildConfigFromDB(stored)
+	if cfg.Server.Port != 8443 || !cfg.Server.ReadOnly {
+		t.Errorf("config has port %d and read-only %v, want 8443 and true", cfg.Server.Port, cfg.Server.ReadOnly)
+	}
+	if want := []string{"192.0.2.1", "198.51.100.0/24"}; !reflect.DeepEqual(cfg.Security.IPWhitelist, want) {
+		t.Errorf("IP whitelist = %q, want %q", cfg.Security.IPWhitelist, want)
+	}
+}
+
+func TestEnvConfigInvalidValues(t *testing.T) {
+	tests := []struct {
+		key, env string
+		stored   configMap
+		want     string
+	}{
+		// A bad override falls back to the stored value
+		{"server.port", "eighty", configMap{"server.port": "4900"}, "4900"},
+		{"server.port", "70000", configMap{"server.port": "4900"}, "4900"},
+		{"server.read_only", "yes please", configMap{"server.read_only": "true"}, "true"},
+		{"security.ip_whitelist", "192.0.2.1,not-an-ip", configMap{"security.ip_whitelist": "10.0.0.1"}, "10.0.0.1"},
+		// ...and to the default when the stored value is bad too
+		{"server.port", "eighty", configMap{"server.port": "-1"}, "8080"},
+		{"server.read_only", "maybe", configMap{"server.read_only": "maybe"}, "false"},
+		// Nothing stored reads as the default
+		{"server.port", "eighty", configMap{}, "8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.env, func(t *testing.T) {
+			t.Setenv(config.EnvName(tt.key), tt.env)
+			src := &envConfigSource{database: tt.stored}
+			if got := src.GetConfig(tt.key); got != tt.want {
+				t.Errorf("GetConfig(%s) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Package events fans out server activity (uploads, deletions, cleanup runs)
+// to live subscribers such as the admin dashboard's event stream.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"httpserver/server/db"
+)
+
+// Types of event
+const (
+	TypeUpload     = "upload"
+	TypeDelete     = "delete"
+	TypeBulkDelete = "bulk_delete" // Many files deleted at once; counts only
+	TypeCleanup    = "cleanup"
+	TypeStats      = "stats"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind by. One
+// that falls further is dropped rather than holding up the publisher.
+const subscriberBuffer = 64
+
+// Event is one thing that happened
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// File is the data of an upload or delete event
+type File struct {
+	FilePath     string     `json:"file_path"`
+	OriginalName string     `json:"original_name"`
+	Size         int64      `json:"size"`
+	UploaderIP   string     `json:"uploader_ip,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// FileEvent returns an event of the given type about a file
+func FileEvent(eventType string, meta *db.FileMetadata) Event {
+	file := File{
+		FilePath:     meta.FilePath,
+		OriginalName: meta.OriginalName,
+		Size:         meta.FileSize,
+		UploaderIP:   meta.RemoteIP,
+	}
+	if !meta.IsPinned() {
+		expiresAt := meta.ExpiresAt
+		file.ExpiresAt = &expiresAt
+	}
+	return Event{Type: eventType, Data: file}
+}
+
+// Hub delivers published events to every subscriber. Publishing never
+// blocks: a subscriber whose buffer is full is closed, and must subscribe
+// again to get events, having missed some. A nil *Hub drops everything.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+// Subscription receives events on C until it is closed, by Unsubscribe or by
+// the hub when the subscriber falls behind
+type Subscription struct {
+	C <-chan Event
+
+	ch     chan Event
+	closed bool // Guarded by the hub's mu
+}
+
+// NewHub returns a hub without subscribers
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]bool)}
+}
+
+// Publish sends an event to every subscriber, stamping its time if unset
+func (h *Hub) Publish(event Event) {
+	if h == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		select {
+		case sub.ch <- event:
+		default:
+			h.closeLocked(sub)
+		}
+	}
+}
+
+// Subscribe returns a new subscription, or nil on a nil hub
+func (h *Hub) Subscribe() *Subscription {
+	if h == nil {
+		return nil
+	}
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch}
+
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe ends a subscription; it may already have been closed
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	if h == nil || sub == nil {
+		return
+	}
+	h.mu.Lock()
+	h.closeLocked(sub)
+	h.mu.Unlock()
+}
+
+// Subscribers returns the number of open subscriptions
+func (h *Hub) Subscribers() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// closeLocked removes a subscription and closes its channel (caller must hold mu)
+func (h *Hub) closeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(h.subs, sub)
+	close(sub.ch)
+}
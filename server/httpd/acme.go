@@ -0,0 +1,139 @@
+package httpd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"httpserver/server/logging"
+)
+
+// acmeDomains returns the domains to obtain certificates for; HTTPS with ACME
+// is enabled when there are any
+func (s *Server) acmeDomains() []string {
+	var domains []string
+	for _, domain := range s.cfg().Server.ACMEDomains {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// acmeCacheDir is where certificates and the account key are kept, next to the database
+func (s *Server) acmeCacheDir() string {
+	return filepath.Join(filepath.Dir(s.db.Path()), "acme")
+}
+
+// listenACME opens the HTTPS listener, with certificates obtained and renewed
+// from Let's Encrypt, and the plain HTTP listener answering HTTP-01 challenges.
+// Hosts outside server.acme_domains are refused a certificate.
+func (s *Server) listenACME(domains []string) error {
+	cfg := s.cfg().Server
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(s.acmeCacheDir()),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      cfg.ACMEEmail,
+	}
+
+	httpsAddr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.HTTPSPort))
+	httpsLn, err := net.Listen("tcp", httpsAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s for HTTPS: %w%s", httpsAddr, err, privilegedPortHint(cfg.HTTPSPort))
+	}
+	httpAddr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.HTTPPort))
+	httpLn, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		httpsLn.Close()
+		return fmt.Errorf("failed to listen on %s for ACME challenges: %w%s", httpAddr, err, privilegedPortHint(cfg.HTTPPort))
+	}
+
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	s.listeners = []net.Listener{tls.NewListener(httpsLn, tlsConfig)}
+	s.httpListener = httpLn
+	s.httpServer = &http.Server{Handler: s.acmeHTTPHandler(manager)}
+
+	logging.Info("Listening", logging.Fields{"addr": "https://" + httpsLn.Addr().String(), "domains": strings.Join(domains, ",")})
+	logging.Info("Listening", logging.Fields{"addr": "http://" + httpLn.Addr().String(), "purpose": "ACME challenges and HTTPS redirects"})
+	return nil
+}
+
+// acmeHTTPHandler answers HTTP-01 challenges and, with server.redirect_http,
+// redirects everything else to HTTPS; without it the site is served over plain
+// HTTP as well. Local CLI commands are always served: the certificate doesn't
+// cover loopback, so they use this listener.
+func (s *Server) acmeHTTPHandler(manager *autocert.Manager) http.Handler {
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg().Server.RedirectHTTP && !s.isControlRequest(r) {
+			s.redirectToHTTPS(w, r)
+			return
+		}
+		s.server.Handler.ServeHTTP(w, r)
+	})
+	return manager.HTTPHandler(fallback)
+}
+
+// withHSTS adds Strict-Transport-Security to HTTPS responses when
+// security.hsts_max_age is set
+func (s *Server) withHSTS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxAge := s.cfg().Security.HSTSMaxAge; maxAge > 0 && r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", "max-age="+strconv.Itoa(maxAge))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HTTPAddr returns the address of the plain HTTP listener running next to
+// HTTPS, or nil when there is none
+func (s *Server) HTTPAddr() net.Addr {
+	if s.httpListener == nil {
+		return nil
+	}
+	return s.httpListener.Addr()
+}
+
+// privilegedPortHint explains the usual reason binding a low port fails
+func privilegedPortHint(port int) string {
+	if port > 0 && port < 1024 {
+		return " (ports below 1024 need root or CAP_NET_BIND_SERVICE)"
+	}
+	return ""
+}
+
+// redirectToHTTPS sends a plain HTTP request to the same path and query on
+// the HTTPS listener. Hosts without a certificate are sent to the first
+// configured domain rather than wherever the Host header points.
+func (s *Server) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if domains := s.acmeDomains(); len(domains) > 0 && !containsString(domains, strings.ToLower(host)) {
+		host = domains[0]
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	if port := s.cfg().Server.HTTPSPort; port != 443 {
+		host += ":" + strconv.Itoa(port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpserver/server/cleanup"
+	"httpserver/server/db"
+	"httpserver/server/events"
+	"httpserver/server/logging"
+	"httpserver/server/naming"
+	"httpserver/server/notify"
+	"httpserver/server/storage"
+)
+
+// isAdminFilePath reports whether an admin path names one file: /api/admin/files/{id}
+func isAdminFilePath(urlPath string) bool {
+	id := strings.TrimPrefix(urlPath, "/api/admin/files/")
+	return id != urlPath && id != "" && isAllDigits(id)
+}
+
+// handleAdminFile handles PATCH /api/admin/files/{id}, which edits a file's
+// original name, slug or tag. The stored path never changes.
+func (s *Server) handleAdminFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/admin/files/"), 10, 64)
+	if err != nil {
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+
+	var req struct {
+		OriginalName *string `json:"original_name"`
+		Slug         *string `json:"slug"`
+		Tag          *string `json:"tag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+		return
+	}
+	if req.OriginalName == nil && req.Slug == nil && req.Tag == nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Nothing to update: set original_name, slug or tag")
+		return
+	}
+	if req.OriginalName != nil {
+		if err := naming.ValidateOriginalName(*req.OriginalName); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+	}
+	// An empty slug or tag removes it
+	if req.Slug != nil && *req.Slug != "" {
+		if err := naming.ValidateSlug(*req.Slug); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidSlug, err.Error())
+			return
+		}
+	}
+	if req.Tag != nil && *req.Tag != "" {
+		if err := naming.ValidateTag(*req.Tag); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidTag, err.Error())
+			return
+		}
+	}
+
+	before, _ := s.db.GetFileMetadataByID(id)
+	if before == nil || before.IsDeleted() {
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+	old := *before
+
+	meta, err := s.db.UpdateFileMetadata(id, db.FileUpdate{OriginalName: req.OriginalName, Slug: req.Slug, Tag: req.Tag})
+	switch {
+	case errors.Is(err, db.ErrSlugTaken):
+		s.audit(r, AuditFileUpdate, old.FilePath, false, "slug taken: "+*req.Slug)
+		s.writeJSONError(w, http.StatusConflict, CodeSlugTaken, fmt.Sprintf("Slug '%s' is already in use", *req.Slug))
+		return
+	case err != nil:
+		s.audit(r, AuditFileUpdate, old.FilePath, false, err.Error())
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save metadata: %v", err))
+		return
+	case meta == nil:
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+
+	var changes []string
+	for _, field := range []struct{ name, from, to string }{
+		{"original_name", old.OriginalName, meta.OriginalName},
+		{"slug", old.Slug, meta.Slug},
+		{"tag", old.Tag, meta.Tag},
+	} {
+		if field.from != field.to {
+			changes = append(changes, fmt.Sprintf("%s %q -> %q", field.name, field.from, field.to))
+		}
+	}
+	s.audit(r, AuditFileUpdate, meta.FilePath, true, strings.Join(changes, ", "))
+	// The standby takes the whole record again, as it would a restored file
+	s.replicator.Enqueue(db.ReplicateUpload, meta.FilePath)
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "File updated",
+		"file":    newFileInfo(meta, time.Now()),
+	})
+	logging.Info("File metadata updated", logging.Fields{"path": meta.FilePath, "changes": strings.Join(changes, ", "), "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+// maxBulkDelete bounds the entries of one bulk delete request
+const maxBulkDelete = 1000
+
+// Outcomes of one entry of a bulk delete
+const (
+	bulkDeleted   = "deleted"
+	bulkNotFound  = "not_found"
+	bulkInvalid   = "invalid"
+	bulkDuplicate = "duplicate" // Names a file an earlier entry already named
+	bulkFailed    = "error"
+)
+
+// bulkDeleteResult is the outcome of one entry of a bulk delete
+type bulkDeleteResult struct {
+	ID     int64  `json:"id,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Status string `json:"status"`
+	Size   int64  `json:"size,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleAdminBulkDelete handles POST /api/admin/files/delete, which deletes
+// the files named by ID or path, going on past entries that fail. Each file
+// is deleted once however many entries name it; the batch is audited as one
+// entry.
+func (s *Server) handleAdminBulkDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+
+	var req struct {
+		IDs   []int64  `json:"ids"`
+		Paths []string `json:"paths"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+		return
+	}
+	total := len(req.IDs) + len(req.Paths)
+	if total == 0 {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Nothing to delete: set ids or paths")
+		return
+	}
+	if total > maxBulkDelete {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Too many files: at most %d per request", maxBulkDelete))
+		return
+	}
+
+	results := make([]bulkDeleteResult, 0, total)
+	seen := make(map[string]bool, total) // Stored paths already handled
+	seenIDs := make(map[int64]bool, len(req.IDs))
+	var deleted []*db.FileMetadata
+	var freed int64
+	failed := 0
+	trashHours := s.cfg().Storage.TrashRetentionHours
+
+	// deleteOne deletes the file of an entry, given its metadata if it has any
+	deleteOne := func(result bulkDeleteResult, meta *db.FileMetadata) bulkDeleteResult {
+		if seen[filepath.ToSlash(result.Path)] {
+			result.Status = bulkDuplicate
+			return result
+		}
+		seen[filepath.ToSlash(result.Path)] = true
+
+		var err error
+		if meta != nil {
+			result.Size = meta.FileSize
+			err = cleanup.DeleteFile(s.db, s.store, meta, trashHours)
+		} else {
+			// No metadata: only an untracked stored file can be removed
+			var info storage.FileInfo
+			if info, err = s.store.Stat(result.Path); err == nil {
+				result.Size = info.Size
+				err = s.store.Delete(result.Path)
+			}
+			if os.IsNotExist(err) {
+				result.Status, result.Size = bulkNotFound, 0
+				return result
+			}
+		}
+		if err != nil {
+			failed++
+			result.Status, result.Size, result.Error = bulkFailed, 0, err.Error()
+			return result
+		}
+		result.Status = bulkDeleted
+		freed += result.Size
+		if meta != nil {
+			deleted = append(deleted, meta)
+		}
+		s.replicator.Enqueue(db.ReplicateDelete, result.Path)
+		return result
+	}
+
+	for _, id := range req.IDs {
+		result := bulkDeleteResult{ID: id}
+		// Checked before the lookup: without a trash, the record is gone
+		if seenIDs[id] {
+			result.Status = bulkDuplicate
+			results = append(results, result)
+			continue
+		}
+		seenIDs[id] = true
+		meta, _ := s.db.GetFileMetadataByID(id)
+		if meta == nil || meta.IsDeleted() {
+			result.Status = bulkNotFound
+			results = append(results, result)
+			continue
+		}
+		result.Path = meta.FilePath
+		results = append(results, deleteOne(result, meta))
+	}
+	for _, filePath := range req.Paths {
+		result := bulkDeleteResult{Path: filePath}
+		if filePath == "" || strings.Contains(filePath, "..") || isReservedPath(filePath) {
+			result.Status = bulkInvalid
+			results = append(results, result)
+			continue
+		}
+		meta, _ := s.db.GetFileMetadata(filePath)
+		if meta != nil {
+			result.ID = meta.ID
+		}
+		results = append(results, deleteOne(result, meta))
+	}
+
+	deletedCount := 0
+	for _, result := range results {
+		if result.Status == bulkDeleted {
+			deletedCount++
+		}
+	}
+	s.audit(r, AuditFilesDelete, fmt.Sprintf("%d entries", total), failed == 0,
+		fmt.Sprintf("%d files deleted, %d bytes freed, %d failed", deletedCount, freed, failed))
+
+	if len(deleted) > 0 {
+		entries := make([]notify.FileEntry, 0, len(deleted))
+		for _, meta := range deleted {
+			entries = append(entries, notify.FileEntry{
+				FilePath:     meta.FilePath,
+				OriginalName: meta.OriginalName,
+				Size:         meta.FileSize,
+				ExpiresAt:    meta.ExpiresAt,
+			})
+		}
+		s.notifier.Notify(notify.Payload{Event: notify.EventDelete, Files: entries})
+	}
+	if deletedCount > 0 {
+		s.events.Publish(events.Event{Type: events.TypeBulkDelete, Data: map[string]interface{}{
+			"files_deleted": deletedCount,
+			"bytes_freed":   freed,
+		}})
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"results":       results,
+		"files_deleted": deletedCount,
+		"bytes_freed":   freed,
+		"failed":        failed,
+	})
+	logging.Info("Bulk delete", logging.Fields{"entries": total, "deleted": deletedCount, "failed": failed, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
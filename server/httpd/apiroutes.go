@@ -0,0 +1,532 @@
+package httpd
+
+import (
+	"net/http"
+
+	"httpserver/server/cleanup"
+	"httpserver/server/config"
+	"httpserver/server/db"
+	"httpserver/server/events"
+	"httpserver/server/totp"
+)
+
+// Route descriptors for the OpenAPI document, registered alongside their
+// handlers in NewServer. Update them whenever a handler's parameters or
+// response shape change.
+
+var (
+	listAuth  = []string{authSession, authAPIKey, authAdmin}
+	adminRead = []string{authAdmin, authAPIKey} // Tokens with the admin-read scope may read
+	adminOnly = []string{authAdmin}
+)
+
+// Common parameters
+var (
+	dateParam = apiParam{Name: "path", In: "query", Description: "Date directory (YYYYMMDD)", Schema: ""}
+	tagParam  = apiParam{Name: "tag", In: "query", Description: "Tag (album) name", Schema: ""}
+	fromParam = apiParam{Name: "from", In: "query", Description: "First day, YYYYMMDD or YYYY-MM-DD", Schema: ""}
+	toParam   = apiParam{Name: "to", In: "query", Description: "Last day, YYYYMMDD or YYYY-MM-DD", Schema: ""}
+
+	includeExpiredParam = apiParam{Name: "include_expired", In: "query",
+		Description: "1 to also list expired files awaiting cleanup; admin credentials only", Schema: ""}
+)
+
+var uploadAPI = []apiOperation{{
+	Method:  http.MethodPost,
+	Path:    "/upload",
+	Summary: "Upload a file; Location points at the stored file",
+	Tag:     "files",
+	Auth:    []string{authAPIKey},
+	Params: []apiParam{{Name: IdempotencyKeyHeader, In: "header", Schema: "",
+		Description: "Names the upload so a retry within storage.idempotency_window_hours returns the first result instead of storing the file again"}},
+	Request: &apiBody{ContentType: "multipart/form-data", Schema: apiObject{
+		"file":            binarySchema,
+		"idempotency_key": apiSchema{"type": "string", "description": "Alternative to the Idempotency-Key header"},
+		"ttl":             apiSchema{"type": "string", "description": "Lifetime: hours (24), a duration (45m, 36h, 14d, 2w), or \"never\"/\"0\" when storage.allow_permanent is on; storage.default_ttl when omitted"},
+		"slug":            apiSchema{"type": "string", "description": "Memorable alias served via /s/{slug}"},
+		"tag":             apiSchema{"type": "string", "description": "Album name; \"album\" is accepted as an alias"},
+		"album":           "",
+	}},
+	Response: jsonBody(apiObject{
+		"success":        true,
+		"message":        "",
+		"file_path":      "",
+		"download_url":   "",
+		"ttl":            apiSchema{"type": "string", "description": "Effective TTL, e.g. 36h or never"},
+		"expires_at":     apiSchema{"type": "string", "format": "date-time", "description": "Absent for files that never expire"},
+		"retention_rule": apiSchema{"type": "string", "description": "storage.retention_rules entry that set the TTL, if any"},
+		"requested_ttl":  apiSchema{"type": "string", "description": "TTL asked for, when a retention rule applied"},
+		"sha256":         "",
+		"slug":           "",
+		"slug_url":       "",
+		"tag":            "",
+	}),
+	Status: http.StatusCreated,
+	Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict,
+		http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusServiceUnavailable},
+}}
+
+var uploadCheckAPI = []apiOperation{{
+	Method:  http.MethodPost,
+	Path:    "/upload/check",
+	Summary: "Ask whether content is already stored; with a ttl, a hit is stored again as a new file without sending it and answered like /upload (201)",
+	Tag:     "files",
+	Auth:    []string{authAPIKey},
+	Request: jsonBody(apiObject{
+		"sha256":   "",
+		"size":     int64(0),
+		"ttl":      apiSchema{"type": "string", "description": "Store a new file from the existing content, as in /upload"},
+		"filename": apiSchema{"type": "string", "description": "Name of the new file; the existing file's when omitted"},
+		"slug":     "",
+		"tag":      "",
+	}),
+	Response: jsonBody(apiObject{"success": true, "exists": false}),
+	Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict,
+		http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusServiceUnavailable},
+}}
+
+// resumableUploadStatus describes a chunked upload in progress
+var resumableUploadStatus = jsonBody(apiObject{
+	"success":    true,
+	"upload_id":  "",
+	"size":       int64(0),
+	"chunk_size": int64(0),
+	"chunks":     int64(0),
+	"next_index": 0,
+	"received":   int64(0),
+	"complete":   true,
+	"updated_at": apiSchema{"type": "string", "format": "date-time"},
+})
+
+var uploadIDParam = apiParam{Name: "id", In: "path", Description: "Upload ID returned by /upload/init", Schema: ""}
+
+var resumableAPI = []apiOperation{
+	{
+		Method:  http.MethodPost,
+		Path:    "/upload/init",
+		Summary: "Start a chunked upload that can be resumed",
+		Tag:     "files",
+		Auth:    []string{authAPIKey},
+		Request: jsonBody(apiObject{
+			"name":       "",
+			"size":       int64(0),
+			"sha256":     apiSchema{"type": "string", "description": "Hex SHA-256 of the whole file, checked on completion"},
+			"chunk_size": apiSchema{"type": "integer", "description": "Bytes per chunk, 256 KiB to 64 MiB; 8 MiB when omitted"},
+			"ttl":        "",
+			"slug":       "",
+			"tag":        "",
+		}),
+		Response: resumableUploadStatus,
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict,
+			http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusServiceUnavailable},
+	},
+	{
+		Method:  http.MethodPut,
+		Path:    "/upload/chunk/{id}",
+		Summary: "Send the next chunk of a chunked upload",
+		Tag:     "files",
+		Auth:    []string{authAPIKey},
+		Params: []apiParam{uploadIDParam,
+			{Name: "index", In: "query", Required: true, Description: "Chunk number, from 0", Schema: apiSchema{"type": "integer"}},
+			{Name: ChunkSHA256Header, In: "header", Required: true, Description: "Hex SHA-256 of the chunk", Schema: ""}},
+		Request:  &apiBody{ContentType: "application/octet-stream", Schema: binarySchema},
+		Response: resumableUploadStatus,
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/upload/{id}",
+		Summary:  "Progress of a chunked upload, to resume it",
+		Tag:      "files",
+		Auth:     []string{authAPIKey},
+		Params:   []apiParam{uploadIDParam},
+		Response: resumableUploadStatus,
+		Errors:   []int{http.StatusUnauthorized, http.StatusNotFound},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/upload/{id}",
+		Summary: "Abandon a chunked upload",
+		Tag:     "files",
+		Auth:    []string{authAPIKey},
+		Params:  []apiParam{uploadIDParam},
+		Errors:  []int{http.StatusUnauthorized, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/upload/complete/{id}",
+		Summary:  "Finish a chunked upload; the response is that of /upload",
+		Tag:      "files",
+		Auth:     []string{authAPIKey},
+		Params:   []apiParam{uploadIDParam},
+		Response: uploadAPI[0].Response,
+		Status:   http.StatusCreated,
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict,
+			http.StatusTooManyRequests, http.StatusServiceUnavailable},
+	},
+}
+
+// rangeParam asks a download for part of the file
+var rangeParam = apiParam{Name: "Range", In: "header", Description: "Byte range such as bytes=0-1023, answered with 206 Partial Content", Schema: ""}
+
+var filesAPI = []apiOperation{
+	{
+		Method:   http.MethodGet,
+		Path:     "/files/{path}",
+		Summary:  "Download a file; also served at /{path}, and answers HEAD and Range requests",
+		Tag:      "files",
+		Params:   []apiParam{{Name: "path", In: "path", Description: "Stored path (YYYYMMDD/name)", Schema: ""}, rangeParam},
+		Response: &apiBody{ContentType: "application/octet-stream", Schema: binarySchema},
+		Errors:   []int{http.StatusNotFound, http.StatusGone, http.StatusRequestedRangeNotSatisfiable, http.StatusTooManyRequests},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/files/{path}",
+		Summary: "Delete a file (into the trash when storage.trash_retention_hours is set)",
+		Tag:     "files",
+		Auth:    []string{authAPIKey},
+		Params:  []apiParam{{Name: "path", In: "path", Description: "Stored path (YYYYMMDD/name)", Schema: ""}},
+		Errors:  []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusServiceUnavailable},
+	},
+}
+
+var slugAPI = []apiOperation{{
+	Method:   http.MethodGet,
+	Path:     "/s/{slug}",
+	Summary:  "Download a file by its slug",
+	Tag:      "files",
+	Params:   []apiParam{{Name: "slug", In: "path", Schema: ""}, rangeParam},
+	Response: &apiBody{ContentType: "application/octet-stream", Schema: binarySchema},
+	Errors:   []int{http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable},
+}}
+
+var fileListAPI = []apiOperation{{
+	Method:  http.MethodGet,
+	Path:    "/api/files",
+	Summary: "List date directories, or the files of a date directory or tag; expired files are left out",
+	Tag:     "files",
+	Auth:    listAuth,
+	Params: []apiParam{dateParam, tagParam,
+		{Name: "sort", In: "query", Description: "newest, oldest, name or size", Schema: ""}, includeExpiredParam,
+		{Name: "If-None-Match", In: "header", Description: "ETag of a date directory's listing; 304 Not Modified while it is current", Schema: ""}},
+	Response: jsonBody(apiObject{
+		"success":      true,
+		"current_path": "",
+		"current_tag":  "",
+		"files":        []db.FileMetadata{},
+		"directories":  []string{},
+	}),
+	Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+}}
+
+var archiveAPI = []apiOperation{{
+	Method:   http.MethodGet,
+	Path:     "/api/files/archive",
+	Summary:  "Download a date directory or tag as a zip archive",
+	Tag:      "files",
+	Auth:     listAuth,
+	Params:   []apiParam{dateParam, tagParam},
+	Response: &apiBody{ContentType: "application/zip", Schema: binarySchema},
+	Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge},
+}}
+
+var recentAPI = []apiOperation{{
+	Method:  http.MethodGet,
+	Path:    "/api/files/recent",
+	Summary: "List the most recent unexpired uploads across all dates, newest first",
+	Tag:     "files",
+	Auth:    listAuth,
+	Params: []apiParam{{Name: "limit", In: "query", Description: "Files to return, 1 to 500; 50 when omitted",
+		Schema: apiSchema{"type": "integer"}}, includeExpiredParam},
+	Response: jsonBody(apiObject{"success": true, "files": []db.FileMetadata{}}),
+	Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+}}
+
+// fileInfoSchema describes one file, as returned by the file info routes
+var fileInfoSchema = apiObject{
+	"id":                   int64(0),
+	"file_name":            "",
+	"original_name":        "",
+	"file_path":            "",
+	"file_size":            int64(0),
+	"uploaded_at":          apiSchema{"type": "string", "format": "date-time"},
+	"expires_at":           apiSchema{"type": "string", "format": "date-time", "description": "Zero time for files that never expire"},
+	"ttl":                  0,
+	"remote_ip":            "",
+	"slug":                 "",
+	"tag":                  "",
+	"hash":                 "",
+	"retention_rule":       "",
+	"seconds_until_expiry": apiSchema{"type": "integer", "description": "Absent for files that never expire"},
+	"download_url":         "",
+	"slug_url":             "",
+}
+
+var fileInfoResponse = jsonBody(apiObject{"success": true, "file": fileInfoSchema})
+
+var fileInfoAPI = []apiOperation{
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/files/{id}",
+		Summary:  "Describe a file by its ID",
+		Tag:      "files",
+		Auth:     listAuth,
+		Params:   []apiParam{{Name: "id", In: "path", Description: "File ID", Schema: apiSchema{"type": "integer"}}},
+		Response: fileInfoResponse,
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusGone},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/files/by-path",
+		Summary:  "Describe a file by its stored path",
+		Tag:      "files",
+		Auth:     listAuth,
+		Params:   []apiParam{{Name: "path", In: "query", Required: true, Description: "Stored path (YYYYMMDD/name)", Schema: ""}},
+		Response: fileInfoResponse,
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusGone},
+	},
+}
+
+var tagsAPI = []apiOperation{{
+	Method:   http.MethodGet,
+	Path:     "/api/tags",
+	Summary:  "List tags with their file counts",
+	Tag:      "files",
+	Auth:     listAuth,
+	Response: jsonBody(apiObject{"success": true, "tags": []db.TagCount{}}),
+	Errors:   []int{http.StatusUnauthorized, http.StatusForbidden},
+}}
+
+var loginAPI = []apiOperation{{
+	Method:   http.MethodGet,
+	Path:     "/api/login",
+	Summary:  "Login options: whether remember me is offered",
+	Tag:      "auth",
+	Response: jsonBody(apiObject{"success": true, "remember_me": false}),
+}, {
+	Method:   http.MethodPost,
+	Path:     "/api/login",
+	Summary:  "Log in to the file list; sets the session_token cookie, which each request renews up to security.session_absolute_timeout",
+	Tag:      "auth",
+	Request:  jsonBody(apiObject{"password": "", "remember": false}),
+	Response: jsonBody(apiObject{"success": true, "csrf_token": "", "expires_at": apiSchema{"type": "string", "format": "date-time"}, "remember": false}),
+	Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusTooManyRequests},
+}, {
+	Method:   http.MethodDelete,
+	Path:     "/api/login",
+	Summary:  "Log out: ends the session and clears the session_token cookie",
+	Tag:      "auth",
+	Auth:     []string{authSession},
+	Response: jsonBody(apiObject{"success": true}),
+	Errors:   []int{http.StatusForbidden},
+}}
+
+var healthAPI = []apiOperation{{
+	Method:  http.MethodGet,
+	Path:    "/health",
+	Summary: "Health check; ?verbose=1 adds runtime, storage and database details",
+	Tag:     "meta",
+	Params:  []apiParam{{Name: "verbose", In: "query", Schema: apiSchema{"type": "string", "enum": []string{"1"}}}},
+	Response: jsonBody(apiObject{
+		"status":              apiSchema{"type": "string", "enum": []string{"ok", "degraded"}},
+		"mode":                apiSchema{"type": "string", "enum": []string{"normal", "read_only"}},
+		"maintenance_message": "",
+		"problems":            []string{},
+		"version":             "",
+		"go_version":          "",
+		"uptime":              int64(0),
+		"active_sessions":     0,
+		"in_flight":           apiObject{"uploads": 0, "downloads": 0},
+		"storage_info":        apiObject{"total_files": 0, "total_size": ""},
+		"disk_free":           uint64(0),
+		"last_db_save":        apiSchema{"type": "string", "format": "date-time"},
+		"last_cleanup":        anySchema,
+	}),
+	Errors: []int{http.StatusServiceUnavailable},
+}}
+
+var versionAPI = []apiOperation{{
+	Method:   http.MethodGet,
+	Path:     "/api/version",
+	Summary:  "Version, build time and git commit of the running server",
+	Tag:      "meta",
+	Response: jsonBody(versionInfo{}),
+}}
+
+var capabilitiesAPI = []apiOperation{{
+	Method:   http.MethodGet,
+	Path:     "/api/capabilities",
+	Summary:  "Upload limits: TTL bounds, permanent files and the maximum file size",
+	Tag:      "meta",
+	Response: jsonBody(capabilities{}),
+}}
+
+var openAPIAPI = []apiOperation{{
+	Method:   http.MethodGet,
+	Path:     "/api/openapi.json",
+	Summary:  "This OpenAPI description",
+	Tag:      "meta",
+	Response: jsonBody(anySchema),
+}}
+
+var replicationAPI = []apiOperation{
+	{
+		Method:  http.MethodPost,
+		Path:    "/api/replication/files",
+		Summary: "Store a file replicated from a primary server (replicate scope)",
+		Tag:     "replication",
+		Auth:    []string{authAPIKey},
+		Request: &apiBody{ContentType: "multipart/form-data", Schema: apiObject{
+			"metadata": apiSchema{"type": "string", "description": "JSON-encoded file record"},
+			"file":     binarySchema,
+		}},
+		Response: jsonBody(apiObject{"success": true, "message": "", "file_path": ""}),
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusServiceUnavailable},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/replication/files",
+		Summary: "Delete a replicated file (replicate scope)",
+		Tag:     "replication",
+		Auth:    []string{authAPIKey},
+		Params:  []apiParam{{Name: "path", In: "query", Required: true, Schema: ""}},
+		Errors:  []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusServiceUnavailable},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/api/replication/expiry",
+		Summary:  "Change a replicated file's expiry (replicate scope)",
+		Tag:      "replication",
+		Auth:     []string{authAPIKey},
+		Request:  jsonBody(apiObject{"file_path": "", "ttl": 0, "expires_at": apiSchema{"type": "string", "format": "date-time"}}),
+		Response: jsonBody(apiObject{"success": true, "message": "", "expires_at": apiSchema{"type": "string", "format": "date-time"}}),
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusServiceUnavailable},
+	},
+}
+
+// adminErrors are returned by every admin route on top of its own errors
+var adminErrors = []int{http.StatusUnauthorized, http.StatusForbidden}
+
+// adminOp describes an admin route
+func adminOp(method, path, summary string, request, response *apiBody, errors ...int) apiOperation {
+	auth := adminOnly
+	if method == http.MethodGet {
+		auth = adminRead
+	}
+	return apiOperation{
+		Method:   method,
+		Path:     "/api/admin" + path,
+		Summary:  summary,
+		Tag:      "admin",
+		Auth:     auth,
+		Request:  request,
+		Response: response,
+		Errors:   append(append([]int(nil), adminErrors...), errors...),
+	}
+}
+
+// withParams adds parameters to an operation
+func withParams(op apiOperation, params ...apiParam) apiOperation {
+	op.Params = append(op.Params, params...)
+	return op
+}
+
+var (
+	reportResponse    = func(report interface{}) *apiBody { return jsonBody(apiObject{"success": true, "report": report}) }
+	configKeyParam    = apiParam{Name: "key", In: "query", Description: "Config key, or \"all\" for every stored value", Schema: ""}
+	revealParam       = apiParam{Name: "reveal", In: "query", Description: "1 to include secrets unmasked", Schema: ""}
+	configKeyResponse = jsonBody(apiObject{"success": true, "key": "", "value": "", "live": true})
+)
+
+var adminAPI = []apiOperation{
+	withParams(adminOp(http.MethodGet, "/config", "Active configuration, or stored values with ?key=", nil,
+		jsonBody(config.Config{})), configKeyParam, revealParam),
+	adminOp(http.MethodPut, "/config", "Store a config key, applying it live where possible",
+		jsonBody(apiObject{"key": "", "value": "", "force": false}), configKeyResponse, http.StatusBadRequest),
+	withParams(adminOp(http.MethodDelete, "/config", "Remove a stored config key", nil,
+		jsonBody(apiObject{"success": true, "key": "", "old_value": "", "default": "", "live": true}),
+		http.StatusBadRequest, http.StatusNotFound), apiParam{Name: "key", In: "query", Required: true, Schema: ""}),
+	adminOp(http.MethodPost, "/config/reset", "Restore built-in defaults for a group, key or \"all\"",
+		jsonBody(apiObject{"prefix": ""}),
+		jsonBody(apiObject{"success": true, "changes": []db.ConfigChange{}, "live": []string{}}), http.StatusBadRequest),
+	withParams(adminOp(http.MethodGet, "/stats", "Detailed upload, storage and replication statistics", nil,
+		jsonBody(db.DetailedStats{}), http.StatusBadRequest),
+		fromParam, toParam, apiParam{Name: "top", In: "query", Description: "Number of top uploaders", Schema: 0}),
+	adminOp(http.MethodGet, "/events", "Server-Sent Events stream of uploads, deletes, cleanup runs and periodic totals", nil,
+		&apiBody{ContentType: "text/event-stream", Schema: events.Event{}}, http.StatusServiceUnavailable),
+	withParams(adminOp(http.MethodGet, "/audit", "Audit log, newest first", nil,
+		jsonBody(apiObject{"success": true, "entries": []db.AuditEntry{}, "total": 0, "offset": 0, "limit": 0}),
+		http.StatusBadRequest),
+		apiParam{Name: "action", In: "query", Schema: ""}, fromParam, toParam,
+		apiParam{Name: "offset", In: "query", Schema: 0}, apiParam{Name: "limit", In: "query", Schema: 0}),
+	adminOp(http.MethodGet, "/bans", "List bans", nil, jsonBody(apiObject{"success": true, "bans": []db.Ban{}})),
+	adminOp(http.MethodPost, "/bans", "Ban an IP address or CIDR range",
+		jsonBody(apiObject{"target": "", "reason": "", "duration_minutes": 0}), nil, http.StatusBadRequest),
+	withParams(adminOp(http.MethodDelete, "/bans", "Lift a ban", nil, nil, http.StatusBadRequest, http.StatusNotFound),
+		apiParam{Name: "target", In: "query", Required: true, Schema: ""}),
+	adminOp(http.MethodGet, "/tokens", "List API tokens", nil, jsonBody(apiObject{"success": true, "tokens": []db.APIToken{}})),
+	adminOp(http.MethodPost, "/tokens", "Create an API token; the key is only returned once",
+		jsonBody(apiObject{"name": "", "scopes": []string{}, "valid_days": 0, "quota_per_day_bytes": int64(0)}),
+		jsonBody(apiObject{"success": true, "key": "", "token": db.APIToken{}}), http.StatusBadRequest),
+	withParams(adminOp(http.MethodDelete, "/tokens/{id}", "Revoke an API token", nil, nil, http.StatusNotFound),
+		apiParam{Name: "id", In: "path", Schema: ""}),
+	adminOp(http.MethodGet, "/2fa", "Two-factor authentication status", nil,
+		jsonBody(apiObject{"success": true, "enabled": true, "recovery_codes_left": 0})),
+	adminOp(http.MethodPost, "/2fa/enable", "Enable two-factor authentication", nil,
+		jsonBody(apiObject{"success": true, "enrollment": totp.Enrollment{}}), http.StatusConflict),
+	adminOp(http.MethodPost, "/2fa/disable", "Disable two-factor authentication",
+		jsonBody(apiObject{"code": ""}), nil, http.StatusBadRequest, http.StatusConflict),
+	adminOp(http.MethodGet, "/sessions", "List file list sessions", nil,
+		jsonBody(apiObject{"success": true, "sessions": []SessionInfo{}})),
+	adminOp(http.MethodDelete, "/sessions", "Revoke every session", nil,
+		jsonBody(apiObject{"success": true, "revoked": 0}), http.StatusConflict),
+	withParams(adminOp(http.MethodDelete, "/sessions/{prefix}", "Revoke the session whose token starts with prefix", nil,
+		jsonBody(apiObject{"success": true, "revoked": 0}), http.StatusConflict, http.StatusNotFound),
+		apiParam{Name: "prefix", In: "path", Schema: ""}),
+	adminOp(http.MethodGet, "/backups", "List metadata backups", nil,
+		jsonBody(apiObject{"success": true, "directory": "", "backups": []cleanup.BackupInfo{}, "last_backup_at": apiSchema{"type": "string", "format": "date-time"}}),
+		http.StatusServiceUnavailable),
+	adminOp(http.MethodPost, "/backups", "Take a metadata backup now", nil,
+		jsonBody(apiObject{"success": true, "backup": cleanup.BackupInfo{}}), http.StatusServiceUnavailable),
+	withParams(adminOp(http.MethodGet, "/export", "Export archive of the database", nil,
+		&apiBody{ContentType: "application/gzip", Schema: binarySchema}),
+		apiParam{Name: "files", In: "query", Description: "1 to include the stored files", Schema: ""}),
+	adminOp(http.MethodGet, "/maintenance", "Read-only maintenance mode status", nil,
+		jsonBody(apiObject{"success": true, "read_only": true, "message": ""})),
+	adminOp(http.MethodPost, "/maintenance", "Enable or disable read-only maintenance mode",
+		jsonBody(apiObject{"read_only": true, "message": ""}),
+		jsonBody(apiObject{"success": true, "message": "", "read_only": true}), http.StatusBadRequest),
+	adminOp(http.MethodPost, "/files/restore", "Restore a file from the trash",
+		jsonBody(apiObject{"path": ""}),
+		jsonBody(apiObject{"success": true, "message": "", "file_path": "", "expires_at": apiSchema{"type": "string", "format": "date-time"}}),
+		http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable),
+	adminOp(http.MethodPost, "/files/delete", "Delete up to 1000 files by ID or path, going on past failures",
+		jsonBody(apiObject{"ids": []int64{}, "paths": []string{}}),
+		jsonBody(apiObject{
+			"success":       true,
+			"results":       []bulkDeleteResult{},
+			"files_deleted": 0,
+			"bytes_freed":   int64(0),
+			"failed":        0,
+		}),
+		http.StatusBadRequest, http.StatusServiceUnavailable),
+	withParams(adminOp(http.MethodPatch, "/files/{id}", "Change a file's original name, slug or tag; an empty slug or tag removes it",
+		jsonBody(apiObject{"original_name": "", "slug": "", "tag": ""}),
+		jsonBody(apiObject{"success": true, "message": "", "file": fileInfoSchema}),
+		http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable),
+		apiParam{Name: "id", In: "path", Description: "File ID", Schema: apiSchema{"type": "integer"}}),
+	withParams(adminOp(http.MethodPost, "/cleanup", "Run a cleanup now", nil, reportResponse(cleanup.Report{}),
+		http.StatusServiceUnavailable), apiParam{Name: "dry_run", In: "query", Description: "1 to only report", Schema: ""}),
+	adminOp(http.MethodGet, "/cleanup/history", "Stored cleanup reports, newest first", nil,
+		jsonBody(apiObject{"success": true, "reports": []db.CleanupReport{}})),
+	adminOp(http.MethodPost, "/purge", "Permanently remove everything uploaded from an IP address",
+		jsonBody(apiObject{"remote_ip": "", "dry_run": false}), reportResponse(cleanup.PurgeReport{}),
+		http.StatusBadRequest, http.StatusServiceUnavailable),
+	withParams(adminOp(http.MethodPost, "/reconcile", "Find orphaned files and records", nil,
+		reportResponse(cleanup.ReconcileReport{}), http.StatusServiceUnavailable),
+		apiParam{Name: "fix", In: "query", Description: "1 to remove what is found", Schema: ""}),
+	withParams(adminOp(http.MethodPost, "/verify", "Re-hash stored files and report mismatches", nil,
+		reportResponse(cleanup.VerifyReport{}), http.StatusBadRequest, http.StatusConflict, http.StatusServiceUnavailable),
+		dateParam, apiParam{Name: "id", In: "query", Description: "Verify a single file", Schema: int64(0)}),
+	adminOp(http.MethodPost, "/verify/cancel", "Stop a running verification", nil, nil, http.StatusNotFound),
+}
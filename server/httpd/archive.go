@@ -0,0 +1,160 @@
+package httpd
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+	"httpserver/server/naming"
+)
+
+// handleAPIArchive streams every non-expired file of a date directory or tag as a ZIP
+func (s *Server) handleAPIArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check session or list token
+	if !s.checkListAccess(w, r) {
+		return
+	}
+
+	date := r.URL.Query().Get("path")
+	tag := r.URL.Query().Get("tag")
+
+	var files []*db.FileMetadata
+	var archiveName string
+	var err error
+
+	switch {
+	case tag != "":
+		if err := naming.ValidateTag(tag); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidTag, err.Error())
+			return
+		}
+		files, err = s.db.ListFilesByTag(tag, false)
+		archiveName = "tag-" + tag + ".zip"
+	case date != "":
+		if len(date) != 8 || !isAllDigits(date) {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidPath, "Invalid date directory")
+			return
+		}
+		files, err = s.db.ListFilesByDate(date, false)
+		archiveName = date + ".zip"
+	default:
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Either path or tag is required")
+		return
+	}
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to list files: %v", err))
+		return
+	}
+
+	// The listings leave out expired files; enforce the size cap before streaming anything
+	var totalSize int64
+	for _, meta := range files {
+		totalSize += meta.FileSize
+	}
+	if len(files) == 0 {
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "No files to archive")
+		return
+	}
+	if s.cfg().Storage.MaxArchiveSize > 0 && totalSize > s.cfg().Storage.MaxArchiveSize {
+		s.writeJSONError(w, http.StatusRequestEntityTooLarge, CodeArchiveTooLarge, fmt.Sprintf("Archive would be %s, exceeding the %s limit",
+			formatBytes(totalSize), formatBytes(s.cfg().Storage.MaxArchiveSize)))
+		return
+	}
+
+	// Archives are the largest downloads, so they share the download limit
+	if !s.acquireSlot(w, r, s.downloads, "downloads") {
+		return
+	}
+	defer s.downloads.release()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, archiveName))
+
+	// Stream the archive directly to the response
+	zw := zip.NewWriter(s.throttleDownload(w, r))
+	names := make(map[string]bool)
+	var written int64
+	for _, meta := range files {
+		n, err := s.addArchiveEntry(zw, meta, uniqueArchiveName(names, meta))
+		written += n
+		if err != nil {
+			// Headers are already sent, so the best we can do is log and truncate
+			logging.Error("Archive aborted", logging.Fields{"archive": archiveName, "path": meta.FilePath, "request_id": RequestID(r), "error": err})
+			return
+		}
+		if s.cfg().Storage.MaxArchiveSize > 0 && written > s.cfg().Storage.MaxArchiveSize {
+			logging.Error("Archive aborted: size limit exceeded while streaming", logging.Fields{"archive": archiveName, "request_id": RequestID(r)})
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		logging.Error("Failed to finish archive", logging.Fields{"archive": archiveName, "request_id": RequestID(r), "error": err})
+		return
+	}
+	logging.Info("Archive downloaded", logging.Fields{"archive": archiveName, "files": len(files), "size": written, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+// addArchiveEntry copies a stored file into the ZIP under the given name
+func (s *Server) addArchiveEntry(zw *zip.Writer, meta *db.FileMetadata, name string) (int64, error) {
+	f, err := s.store.Get(meta.FilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Most uploads are already-compressed media, so store rather than deflate
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: meta.UploadedAt,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return io.Copy(entry, f)
+}
+
+// uniqueArchiveName returns the original filename, adding " (n)" suffixes on
+// collisions. Names that are no file name on their own, like "..", fall back
+// to the stored name, then to "file".
+func uniqueArchiveName(used map[string]bool, meta *db.FileMetadata) string {
+	base := archiveBaseName(meta.OriginalName)
+	if base == "" {
+		base = archiveBaseName(meta.FileName)
+	}
+	if base == "" {
+		base = "file"
+	}
+
+	name := base
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; used[name]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+	}
+	used[name] = true
+	return name
+}
+
+// archiveBaseName returns the last element of a name, or "" when that is
+// empty, "." or "..", which unzipping tools may take as a directory
+func archiveBaseName(name string) string {
+	base := filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	switch base {
+	case ".", "..", "/":
+		return ""
+	}
+	return base
+}
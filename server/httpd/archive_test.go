@@ -0,0 +1,98 @@
+package httpd
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"httpserver/server/db"
+)
+
+func TestUniqueArchiveName(t *testing.T) {
+	tests := []struct {
+		original string
+		stored   string
+		want     string
+	}{
+		{"photo.png", "20240101-abc.png", "photo.png"},
+		{"../../etc/passwd", "20240101-abc.png", "passwd"},
+		{`C:\Users\me\photo.png`, "20240101-abc.png", "photo.png"},
+		{"album/", "20240101-abc.png", "album"},
+		{"", "20240101-abc.png", "20240101-abc.png"},
+		{".", "20240101-abc.png", "20240101-abc.png"},
+		{"..", "20240101-abc.png", "20240101-abc.png"},
+		{"a/..", "20240101-abc.png", "20240101-abc.png"},
+		{`a\..`, "20240101-abc.png", "20240101-abc.png"},
+		{"/", "20240101-abc.png", "20240101-abc.png"},
+		{"..", "..", "file"},
+		{"", "", "file"},
+		{"...", "20240101-abc.png", "..."},
+	}
+
+	for _, tt := range tests {
+		meta := &db.FileMetadata{OriginalName: tt.original, FileName: tt.stored}
+		if got := uniqueArchiveName(map[string]bool{}, meta); got != tt.want {
+			t.Errorf("uniqueArchiveName(%q, %q) = %q, want %q", tt.original, tt.stored, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueArchiveNameCollisions(t *testing.T) {
+	used := map[string]bool{}
+	var got []string
+	for _, original := range []string{"photo.png", "photo.png", "x/photo.png", "..", ".", "noext", "noext"} {
+		got = append(got, uniqueArchiveName(used, &db.FileMetadata{OriginalName: original}))
+	}
+	want := []string{"photo.png", "photo (1).png", "photo (2).png", "file", "file (1)", "noext", "noext (1)"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("names %q, want %q", got, want)
+	}
+}
+
+func TestArchiveEntryNames(t *testing.T) {
+	s := newTestServer(t, nil)
+	for i, original := range []string{"..", "../escape.png", "."} {
+		name := fmt.Sprintf("20240101-%032x.png", i)
+		filePath := "20240101/" + name
+		if _, err := s.store.Put(strings.NewReader("content"), filePath); err != nil {
+			t.Fatal(err)
+		}
+		meta := &db.FileMetadata{
+			FileName:     name,
+			OriginalName: original,
+			FilePath:     filePath,
+			FileSize:     7,
+			UploadedAt:   time.Date(2024, 1, 1, 0, i, 0, 0, time.Local),
+			ExpiresAt:    time.Now().Add(time.Hour),
+			TTL:          1,
+		}
+		if err := s.db.SaveFileMetadata(meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cookie, _ := login(t, s, `{"password":"`+testAdminPass+`"}`)
+	r := httptest.NewRequest(http.MethodGet, "/api/files/archive?path=20240101", nil)
+	r.AddCookie(cookie)
+	rec := s.serve(r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("archive: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 3 {
+		t.Fatalf("archive has %d entries, want 3", len(zr.File))
+	}
+	for _, f := range zr.File {
+		if f.Name == "." || f.Name == ".." || strings.ContainsAny(f.Name, `/\`) {
+			t.Errorf("entry %q isn't a plain file name", f.Name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+)
+
+// Audited actions
+const (
+	AuditLoginSuccess      = "login.success"
+	AuditLoginFailure      = "login.failure"
+	AuditAdminLoginFailure = "admin.login_failure"
+	AuditConfigSet         = "config.set"
+	AuditConfigUnset       = "config.unset"
+	AuditConfigReset       = "config.reset"
+	AuditFileDelete        = "file.delete"
+	AuditFileRestore       = "file.restore"
+	AuditFileUpdate        = "file.update"
+	AuditFilesDelete       = "files.delete"
+	AuditFilesPurge        = "files.purge"
+	AuditCleanupRun        = "cleanup.run"
+	AuditReconcileFix      = "reconcile.fix"
+	AuditBackupCreate      = "backup.create"
+	AuditDatabaseExport    = "database.export"
+	AuditSessionRevoke     = "session.revoke"
+	AuditTOTPFailure       = "admin.totp_failure"
+	AuditTOTPEnable        = "2fa.enable"
+	AuditTOTPDisable       = "2fa.disable"
+	AuditTokenCreate       = "token.create"
+	AuditTokenRevoke       = "token.revoke"
+	AuditBanAdd            = "ban.add"
+	AuditBanLift           = "ban.lift"
+	AuditBanAuto           = "ban.auto"
+	AuditMaintenance       = "maintenance.set"
+)
+
+// Page sizes for the audit endpoint
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// audit records an action in the audit trail. Failures to record are logged but
+// never fail the request.
+func (s *Server) audit(r *http.Request, action, target string, success bool, detail string) {
+	entry := db.AuditEntry{
+		RemoteIP: getRemoteIP(r),
+		Identity: s.requestIdentity(r),
+		Action:   action,
+		Target:   target,
+		Detail:   detail,
+		Success:  success,
+	}
+	if err := s.db.AppendAudit(entry); err != nil {
+		logging.Error("Error recording audit entry", logging.Fields{"action": action, "error": err})
+	}
+}
+
+// requestIdentity describes who made a request: the local CLI, the admin user,
+// the legacy API key or a scoped token
+func (s *Server) requestIdentity(r *http.Request) string {
+	if s.isControlRequest(r) {
+		return "cli"
+	}
+	if username, _, ok := r.BasicAuth(); ok {
+		return "admin:" + username
+	}
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return s.tokenIdentity(key)
+	}
+	return "anonymous"
+}
+
+// handleAdminAudit returns audit entries, newest first. ?action= filters by an
+// action or a prefix such as "config."; ?from= and ?to= (YYYYMMDD or YYYY-MM-DD,
+// both inclusive) bound the date; ?offset= and ?limit= page through the results.
+func (s *Server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := db.AuditFilter{Action: query.Get("action"), Limit: defaultAuditLimit}
+	from, err := parseStatsDate(query.Get("from"))
+	if err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid from date")
+		return
+	}
+	to, err := parseStatsDate(query.Get("to"))
+	if err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid to date")
+		return
+	}
+	filter.From = from
+	if !to.IsZero() {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid offset")
+			return
+		}
+		filter.Offset = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxAuditLimit))
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, total := s.db.GetAuditLog(filter)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"entries": entries,
+		"total":   total,
+		"offset":  filter.Offset,
+		"limit":   filter.Limit,
+	})
+}
@@ -0,0 +1,219 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+)
+
+// failureWindow counts an IP's failed requests within the current autoban window
+type failureWindow struct {
+	start time.Time
+	count int
+}
+
+// isWhitelisted reports whether ip is covered by security.ip_whitelist
+func (s *Server) isWhitelisted(ip net.IP) bool {
+	return ipInList(ip, s.cfg().Security.IPWhitelist)
+}
+
+// ipInList reports whether ip matches one of the IPs or CIDR ranges in list
+func ipInList(ip net.IP, list []string) bool {
+	if ip == nil {
+		return false
+	}
+	for _, entry := range list {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// guard is the access middleware in front of every route. Banned clients are
+// refused, and clients collecting 401 and 429 responses are banned automatically
+// once they pass security.autoban_threshold. Whitelisted IPs and local CLI
+// commands are never banned.
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(getRemoteIP(r))
+		if s.isWhitelisted(ip) || s.isControlRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ban := s.db.FindBan(ip); ban != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"code":    CodeBanned,
+				"message": "Your address has been banned",
+				"reason":  "banned",
+			}
+			if ban.ExpiresAt != nil {
+				response["expires_at"] = ban.ExpiresAt.Format(time.RFC3339)
+			}
+			s.writeJSON(w, http.StatusForbidden, response)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == http.StatusUnauthorized || rec.status == http.StatusTooManyRequests {
+			s.recordFailure(r, ip)
+		}
+	})
+}
+
+// recordFailure counts a failed request and bans the IP once it reaches the
+// threshold within the window
+func (s *Server) recordFailure(r *http.Request, ip net.IP) {
+	sec := s.cfg().Security
+	if sec.AutobanThreshold <= 0 || ip == nil || ip.IsLoopback() {
+		return
+	}
+	window := time.Duration(sec.AutobanWindowMinutes) * time.Minute
+	now := time.Now()
+	key := ip.String()
+
+	s.failureMux.Lock()
+	f, ok := s.failures[key]
+	if !ok || now.Sub(f.start) > window {
+		f = &failureWindow{start: now}
+		s.failures[key] = f
+	}
+	f.count++
+	trip := f.count >= sec.AutobanThreshold
+	if trip {
+		delete(s.failures, key)
+	}
+	s.failureMux.Unlock()
+
+	if !trip {
+		return
+	}
+	expiresAt := now.Add(time.Duration(sec.AutobanDurationMinutes) * time.Minute)
+	reason := fmt.Sprintf("%d failed requests within %v", sec.AutobanThreshold, window)
+	if err := s.db.AddBan(db.Ban{Target: key, Reason: reason, ExpiresAt: &expiresAt, CreatedBy: "autoban"}); err != nil {
+		logging.Error("Error banning client", logging.Fields{"ip": key, "error": err})
+		return
+	}
+	s.audit(r, AuditBanAuto, key, true, reason)
+	logging.Warn("Client banned", logging.Fields{"ip": key, "until": expiresAt.Format(time.RFC3339), "reason": reason})
+}
+
+// pruneBans drops expired bans and stale failure counts
+func (s *Server) pruneBans() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if n, err := s.db.PruneBans(); err != nil {
+			logging.Error("Error pruning bans", logging.Fields{"error": err})
+		} else if n > 0 {
+			logging.Info("Expired bans lifted", logging.Fields{"count": n})
+		}
+
+		window := time.Duration(s.cfg().Security.AutobanWindowMinutes) * time.Minute
+		now := time.Now()
+		s.failureMux.Lock()
+		for ip, f := range s.failures {
+			if now.Sub(f.start) > window {
+				delete(s.failures, ip)
+			}
+		}
+		s.failureMux.Unlock()
+	}
+}
+
+// handleAdminBans lists active bans (GET), bans an IP or range (POST
+// {"target","reason","duration_minutes"}, 0 minutes for a permanent ban) or
+// lifts a ban (DELETE ?target=)
+func (s *Server) handleAdminBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"bans":    s.db.ListBans(),
+		})
+	case http.MethodPost:
+		var req struct {
+			Target          string `json:"target"`
+			Reason          string `json:"reason"`
+			DurationMinutes int    `json:"duration_minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DurationMinutes < 0 {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+			return
+		}
+		ban := db.Ban{Target: req.Target, Reason: req.Reason, CreatedBy: s.requestIdentity(r)}
+		if req.DurationMinutes > 0 {
+			expiresAt := time.Now().Add(time.Duration(req.DurationMinutes) * time.Minute)
+			ban.ExpiresAt = &expiresAt
+		}
+		if err := s.db.AddBan(ban); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		s.audit(r, AuditBanAdd, req.Target, true, req.Reason)
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Ban added",
+		})
+		logging.Info("Ban added", logging.Fields{"target": req.Target, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	case http.MethodDelete:
+		target := r.URL.Query().Get("target")
+		removed, err := s.db.RemoveBan(target)
+		if err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		if !removed {
+			s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "No ban for that address")
+			return
+		}
+		s.audit(r, AuditBanLift, target, true, "")
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Ban lifted",
+		})
+		logging.Info("Ban lifted", logging.Fields{"target": target, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
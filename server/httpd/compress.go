@@ -0,0 +1,227 @@
+package httpd
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest body worth compressing; shorter responses
+// are sent as they are
+const compressMinSize = 1024
+
+// compressibleTypes are the media types compressed on the fly. Images and
+// archives are already compressed.
+var compressibleTypes = map[string]bool{
+	"application/json": true,
+	"text/html":        true,
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	zlibWriters = sync.Pool{New: func() interface{} { return zlib.NewWriter(nil) }}
+)
+
+// withCompression compresses JSON and HTML responses with gzip or deflate when
+// the client accepts it and server.enable_compression is set. The body is
+// streamed through the compressor; only the first compressMinSize bytes are held
+// back to decide whether compressing is worthwhile.
+func (s *Server) withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg().Server.EnableCompression {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		// HEAD responses have no body, so they keep the uncompressed headers
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, or ""
+// when neither is acceptable
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = strings.TrimSpace(part[:i])
+			if param := strings.TrimSpace(part[i+1:]); strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[strings.ToLower(name)] = q > 0
+	}
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if ok, listed := accepted[encoding]; ok || (!listed && accepted["*"]) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter holds back the start of the body until it knows whether to
+// compress: the status and headers must allow it, and the body must reach
+// compressMinSize (or be flushed) before the response ends.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status     int
+	decided    bool           // Headers have been sent
+	compressor io.WriteCloser // Non-nil once compressing
+	buf        []byte         // Body held back while undecided
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if !cw.compressible() {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if cw.status == 0 {
+			if cw.Header().Get("Content-Type") == "" {
+				// net/http would sniff this; do it now so the type can be checked
+				cw.Header().Set("Content-Type", http.DetectContentType(p))
+			}
+			cw.WriteHeader(http.StatusOK)
+		}
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < compressMinSize {
+			return len(p), nil
+		}
+		cw.start(true)
+		return len(p), cw.flushBuffer()
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been written so far, compressing it if the response
+// qualifies, so streaming handlers aren't held back by the size threshold
+func (cw *compressWriter) Flush() {
+	if !cw.decided && cw.status != 0 {
+		cw.start(true)
+		cw.flushBuffer()
+	}
+	if f, ok := cw.compressor.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response: a short body still held back is sent
+// uncompressed, and a compressed stream is terminated
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			return nil // Nothing was written; let net/http answer
+		}
+		cw.start(false)
+		if err := cw.flushBuffer(); err != nil {
+			return err
+		}
+	}
+	if cw.compressor == nil {
+		return nil
+	}
+	err := cw.compressor.Close()
+	switch c := cw.compressor.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(c)
+	case *zlib.Writer:
+		zlibWriters.Put(c)
+	}
+	cw.compressor = nil
+	return err
+}
+
+// compressible reports whether the status and headers allow compression
+func (cw *compressWriter) compressible() bool {
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified ||
+		cw.status == http.StatusPartialContent {
+		return false
+	}
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if length, err := strconv.Atoi(h.Get("Content-Length")); err == nil && length < compressMinSize {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && compressibleTypes[mediaType]
+}
+
+// start sends the headers, switching to a compressed body if compress is set
+// and the response qualifies
+func (cw *compressWriter) start(compress bool) {
+	cw.decided = true
+	if compress && cw.compressible() {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// The compressed bytes differ, so the tag is no longer strong
+			h.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "gzip" {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.compressor = gz
+		} else {
+			zw := zlibWriters.Get().(*zlib.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.compressor = zw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// flushBuffer writes out the held-back body
+func (cw *compressWriter) flushBuffer() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if cw.compressor != nil {
+		_, err := cw.compressor.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
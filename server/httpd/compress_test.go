@@ -0,0 +1,230 @@
+package httpd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"httpserver/server/config"
+)
+
+// compressionServer returns a test server with server.enable_compression set as given
+func compressionServer(t *testing.T, enabled bool) *Server {
+	return newTestServer(t, func(cfg *config.Config) {
+		cfg.Server.EnableCompression = enabled
+	})
+}
+
+// decompress decodes a response body according to its Content-Encoding
+func decompress(t *testing.T, rec *httptest.ResponseRecorder) []byte {
+	t.Helper()
+	var r io.Reader = rec.Body
+	switch enc := rec.Header().Get("Content-Encoding"); enc {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("gzip body: %v", err)
+		}
+		r = gz
+	case "deflate":
+		zr, err := zlib.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("deflate body: %v", err)
+		}
+		r = zr
+	default:
+		t.Fatalf("unexpected Content-Encoding %q", enc)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	return body
+}
+
+// hasVary reports whether a response varies on the given request header
+func hasVary(h http.Header, name string) bool {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestCompression(t *testing.T) {
+	large := []byte(`{"files":[` + strings.Repeat(`{"name":"photo.png"},`, 200) + `{}]}`)
+	small := []byte(`{"ok":true}`)
+	gzipped := func() []byte {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		gz.Write(large)
+		gz.Close()
+		return b.Bytes()
+	}()
+
+	// jsonHandler writes body as JSON, after setting the given headers
+	jsonHandler := func(status int, body []byte, headers map[string]string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			for k, v := range headers {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(status)
+			if r.Method != http.MethodHead {
+				w.Write(body)
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		accept   string
+		handler  http.HandlerFunc
+		encoding string // Expected Content-Encoding
+		status   int
+		body     []byte // Expected body after decoding; nil for none
+	}{
+		{"gzip", http.MethodGet, "gzip, deflate", jsonHandler(http.StatusOK, large, nil), "gzip", http.StatusOK, large},
+		{"deflate", http.MethodGet, "deflate", jsonHandler(http.StatusOK, large, nil), "deflate", http.StatusOK, large},
+		{"gzip refused", http.MethodGet, "gzip;q=0, deflate", jsonHandler(http.StatusOK, large, nil), "deflate", http.StatusOK, large},
+		{"no Accept-Encoding", http.MethodGet, "", jsonHandler(http.StatusOK, large, nil), "", http.StatusOK, large},
+		{"below the threshold", http.MethodGet, "gzip", jsonHandler(http.StatusOK, small, nil), "", http.StatusOK, small},
+		{"short Content-Length", http.MethodGet, "gzip", jsonHandler(http.StatusOK, small, map[string]string{"Content-Length": strconv.Itoa(len(small))}), "", http.StatusOK, small},
+		{"HEAD", http.MethodHead, "gzip", jsonHandler(http.StatusOK, nil, map[string]string{"Content-Length": strconv.Itoa(len(large))}), "", http.StatusOK, nil},
+		{"already encoded", http.MethodGet, "gzip", jsonHandler(http.StatusOK, gzipped, map[string]string{"Content-Encoding": "gzip"}), "gzip", http.StatusOK, large},
+		{"ranged", http.MethodGet, "gzip", jsonHandler(http.StatusPartialContent, large[:1500], map[string]string{"Content-Range": "bytes 0-1499/" + strconv.Itoa(len(large))}), "", http.StatusPartialContent, large[:1500]},
+		{"image", http.MethodGet, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(large)
+		}, "", http.StatusOK, large},
+		{"sniffed HTML", http.MethodGet, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<!DOCTYPE html>" + strings.Repeat("<p>hello</p>", 200)))
+		}, "gzip", http.StatusOK, []byte("<!DOCTYPE html>" + strings.Repeat("<p>hello</p>", 200))},
+	}
+
+	s := compressionServer(t, true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/test", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			s.withCompression(tt.handler).ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding %q, want %q", got, tt.encoding)
+			}
+			if !hasVary(rec.Header(), "Accept-Encoding") {
+				t.Errorf("Vary %q doesn't list Accept-Encoding", rec.Header().Values("Vary"))
+			}
+			if tt.method == http.MethodHead && rec.Header().Get("Content-Length") != strconv.Itoa(len(large)) {
+				t.Errorf("HEAD Content-Length %q, want the uncompressed %d", rec.Header().Get("Content-Length"), len(large))
+			}
+			if body := decompress(t, rec); !bytes.Equal(body, tt.body) {
+				t.Errorf("body %q, want %q", truncate(body), truncate(tt.body))
+			}
+		})
+	}
+}
+
+func TestCompressionHeaders(t *testing.T) {
+	s := compressionServer(t, true)
+	large := strings.Repeat(`{"name":"photo.png"},`, 200)
+	h := s.withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Add("Vary", "Cookie")
+		io.WriteString(w, large)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != "" {
+		t.Errorf("Content-Length %s kept for the compressed body", cl)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `W/"v1"` {
+		t.Errorf("ETag %s, want the weak W/\"v1\"", etag)
+	}
+	if !hasVary(rec.Header(), "Accept-Encoding") || !hasVary(rec.Header(), "Cookie") {
+		t.Errorf("Vary %q, want both Accept-Encoding and the handler's Cookie", rec.Header().Values("Vary"))
+	}
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	s := compressionServer(t, false)
+	body := strings.Repeat(`{"name":"photo.png"},`, 200)
+	h := s.withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != body {
+		t.Errorf("Content-Encoding %q with compression off", rec.Header().Get("Content-Encoding"))
+	}
+	if hasVary(rec.Header(), "Accept-Encoding") {
+		t.Error("Vary lists Accept-Encoding with compression off")
+	}
+}
+
+func TestCompressionRangedDownload(t *testing.T) {
+	s := compressionServer(t, true)
+	payload := []byte(`[` + strings.Repeat(`{"name":"photo.png"},`, 200) + `{}]`)
+	location := uploadFile(t, s, "data.json", payload)
+
+	// Without a range the download is compressed
+	r := httptest.NewRequest(http.MethodGet, location, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := s.serve(r)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status %d, Content-Encoding %q; want 200 and gzip", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, location, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=0-1499")
+	rec = s.serve(r)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status %d, want 206", rec.Code)
+	}
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("ranged response compressed with %s", enc)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), payload[:1500]) {
+		t.Errorf("ranged body %q, want the first 1500 bytes", truncate(rec.Body.Bytes()))
+	}
+}
+
+// truncate shortens a body for error messages
+func truncate(b []byte) []byte {
+	if len(b) > 64 {
+		return b[:64]
+	}
+	return b
+}
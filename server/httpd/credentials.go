@@ -0,0 +1,56 @@
+package httpd
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"httpserver/server/logging"
+)
+
+// Every check of a configured secret goes through these helpers, so none is
+// compared with == (which leaks timing, and lets an empty secret match an
+// empty credential). An empty configured secret matches nothing.
+
+// secretMatches compares a presented credential with a configured secret in
+// constant time
+func secretMatches(presented, configured string) bool {
+	if configured == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(configured)) == 1
+}
+
+// checkSecret is secretMatches for the config key named, warning the
+// operator, once per key, that an empty one locks its users out
+func (s *Server) checkSecret(key, presented, configured string) bool {
+	if configured == "" {
+		if _, warned := s.emptySecrets.LoadOrStore(key, true); !warned {
+			logging.Warn("Refusing credentials: the configured secret is empty", logging.Fields{"key": key, "fix": "httpserver set " + key + " <value>"})
+		}
+		return false
+	}
+	return secretMatches(presented, configured)
+}
+
+// isLegacyAPIKey reports whether key is auth.api_key
+func (s *Server) isLegacyAPIKey(key string) bool {
+	return s.checkSecret("auth.api_key", key, s.cfg().Auth.APIKey)
+}
+
+// isListPassword reports whether password is auth.list_password
+func (s *Server) isListPassword(password string) bool {
+	return s.checkSecret("auth.list_password", password, s.cfg().Auth.ListPassword)
+}
+
+// adminBasicAuth reports whether a request carries basic auth credentials,
+// and whether they are the admin's. Both halves are always compared.
+func (s *Server) adminBasicAuth(r *http.Request) (presented, valid bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false, false
+	}
+	cfg := s.cfg()
+	userOK := s.checkSecret("auth.admin_username", username, cfg.Auth.AdminUsername)
+	passOK := s.checkSecret("auth.admin_password", password, cfg.Auth.AdminPassword)
+	return true, userOK && passOK
+}
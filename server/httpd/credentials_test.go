@@ -0,0 +1,247 @@
+package httpd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"httpserver/server/config"
+)
+
+func TestSecretMatches(t *testing.T) {
+	tests := []struct {
+		presented  string
+		configured string
+		want       bool
+	}{
+		{"secret", "secret", true},
+		{"Secret", "secret", false},
+		{"secre", "secret", false},
+		{"secrets", "secret", false},
+		{"", "secret", false},
+		{"secret", "", false},
+		{"", "", false},
+		{"sécret", "sécret", true},
+	}
+
+	for _, tt := range tests {
+		if got := secretMatches(tt.presented, tt.configured); got != tt.want {
+			t.Errorf("secretMatches(%q, %q) = %v, want %v", tt.presented, tt.configured, got, tt.want)
+		}
+	}
+}
+
+// basicAuthRequest returns a request to path with basic auth credentials
+func basicAuthRequest(method, path, user, pass string) *http.Request {
+	r := httptest.NewRequest(method, path, nil)
+	r.SetBasicAuth(user, pass)
+	return r
+}
+
+func TestAdminBasicAuth(t *testing.T) {
+	tests := []struct {
+		name          string
+		username      string // Configured
+		password      string // Configured
+		request       *http.Request
+		wantPresented bool
+		wantValid     bool
+	}{
+		{
+			name: "no credentials", username: testAdminUser, password: testAdminPass,
+			request: httptest.NewRequest(http.MethodGet, "/", nil),
+		},
+		{
+			name: "bearer token", username: testAdminUser, password: testAdminPass,
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("Authorization", "Bearer "+testAdminPass)
+				return r
+			}(),
+		},
+		{
+			name: "correct", username: testAdminUser, password: testAdminPass,
+			request:       basicAuthRequest(http.MethodGet, "/", testAdminUser, testAdminPass),
+			wantPresented: true, wantValid: true,
+		},
+		{
+			name: "wrong user", username: testAdminUser, password: testAdminPass,
+			request:       basicAuthRequest(http.MethodGet, "/", "root", testAdminPass),
+			wantPresented: true,
+		},
+		{
+			name: "wrong password", username: testAdminUser, password: testAdminPass,
+			request:       basicAuthRequest(http.MethodGet, "/", testAdminUser, "guess"),
+			wantPresented: true,
+		},
+		{
+			name: "both wrong", username: testAdminUser, password: testAdminPass,
+			request:       basicAuthRequest(http.MethodGet, "/", "root", "guess"),
+			wantPresented: true,
+		},
+		{
+			name: "swapped", username: testAdminUser, password: testAdminPass,
+			request:       basicAuthRequest(http.MethodGet, "/", testAdminPass, testAdminUser),
+			wantPresented: true,
+		},
+		{
+			name: "empty configured username", username: "", password: testAdminPass,
+			request:       basicAuthRequest(http.MethodGet, "/", "", testAdminPass),
+			wantPresented: true,
+		},
+		{
+			name: "empty configured password", username: testAdminUser, password: "",
+			request:       basicAuthRequest(http.MethodGet, "/", testAdminUser, ""),
+			wantPresented: true,
+		},
+		{
+			name: "both configured empty", username: "", password: "",
+			request:       basicAuthRequest(http.MethodGet, "/", "", ""),
+			wantPresented: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(cfg *config.Config) {
+				cfg.Auth.AdminUsername = tt.username
+				cfg.Auth.AdminPassword = tt.password
+			})
+			presented, valid := s.adminBasicAuth(tt.request)
+			if presented != tt.wantPresented || valid != tt.wantValid {
+				t.Errorf("adminBasicAuth = %v, %v; want %v, %v", presented, valid, tt.wantPresented, tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestAdminBasicAuthComparesBothHalves(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.AdminUsername = ""
+		cfg.Auth.AdminPassword = ""
+	})
+	s.adminBasicAuth(basicAuthRequest(http.MethodGet, "/", "root", "guess"))
+
+	// A wrong user name doesn't skip the password check, so both empty
+	// secrets were seen and warned about
+	for _, key := range []string{"auth.admin_username", "auth.admin_password"} {
+		if _, warned := s.emptySecrets.Load(key); !warned {
+			t.Errorf("%s wasn't checked", key)
+		}
+	}
+}
+
+// withAuth sets one of the configured secrets
+func withAuth(set func(auth *config.AuthConfig)) func(cfg *config.Config) {
+	return func(cfg *config.Config) { set(&cfg.Auth) }
+}
+
+// loginRequest posts the list password
+func loginRequest(password string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"password":"`+password+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestAuthPaths(t *testing.T) {
+	noAPIKey := withAuth(func(auth *config.AuthConfig) { auth.APIKey = "" })
+	noListPassword := withAuth(func(auth *config.AuthConfig) { auth.ListPassword = "" })
+	noAdminUser := withAuth(func(auth *config.AuthConfig) { auth.AdminUsername = "" })
+	noAdminPass := withAuth(func(auth *config.AuthConfig) { auth.AdminPassword = "" })
+
+	upload := func(key string) func(t *testing.T) *http.Request {
+		return func(t *testing.T) *http.Request {
+			r := uploadRequest(t, "photo.png", "hello", map[string]string{"ttl": "1h"})
+			if key == "" {
+				r.Header.Del(APIKeyHeader)
+			} else {
+				r.Header.Set(APIKeyHeader, key)
+			}
+			return r
+		}
+	}
+	login := func(password string) func(t *testing.T) *http.Request {
+		return func(t *testing.T) *http.Request { return loginRequest(password) }
+	}
+	basic := func(method, path, user, pass string) func(t *testing.T) *http.Request {
+		return func(t *testing.T) *http.Request {
+			r := basicAuthRequest(method, path, user, pass)
+			if method == "PROPFIND" {
+				r.Header.Set("Depth", "1")
+			}
+			return r
+		}
+	}
+
+	tests := []struct {
+		name      string
+		configure func(cfg *config.Config)
+		request   func(t *testing.T) *http.Request
+		want      int
+	}{
+		{name: "api key", request: upload(testAPIKey), want: http.StatusCreated},
+		{name: "wrong api key", request: upload("guess"), want: http.StatusUnauthorized},
+		{name: "no api key", request: upload(""), want: http.StatusUnauthorized},
+		{name: "api key unset", configure: noAPIKey, request: upload(testAPIKey), want: http.StatusUnauthorized},
+
+		{name: "list password", request: login(testAdminPass), want: http.StatusOK},
+		{name: "wrong list password", request: login("guess"), want: http.StatusUnauthorized},
+		{name: "empty list password", request: login(""), want: http.StatusUnauthorized},
+		{name: "list password unset", configure: noListPassword, request: login(""), want: http.StatusUnauthorized},
+
+		{name: "admin api", request: basic(http.MethodGet, "/api/admin/stats", testAdminUser, testAdminPass), want: http.StatusOK},
+		{name: "admin api wrong user", request: basic(http.MethodGet, "/api/admin/stats", "root", testAdminPass), want: http.StatusUnauthorized},
+		{name: "admin api wrong password", request: basic(http.MethodGet, "/api/admin/stats", testAdminUser, "guess"), want: http.StatusUnauthorized},
+		{name: "admin api both wrong", request: basic(http.MethodGet, "/api/admin/stats", "root", "guess"), want: http.StatusUnauthorized},
+		{name: "admin api username unset", configure: noAdminUser, request: basic(http.MethodGet, "/api/admin/stats", "", testAdminPass), want: http.StatusUnauthorized},
+		{name: "admin api password unset", configure: noAdminPass, request: basic(http.MethodGet, "/api/admin/stats", testAdminUser, ""), want: http.StatusUnauthorized},
+
+		{name: "manager page", request: basic(http.MethodGet, "/manager.html", testAdminUser, testAdminPass), want: http.StatusOK},
+		{name: "manager page wrong password", request: basic(http.MethodGet, "/manager.html", testAdminUser, "guess"), want: http.StatusUnauthorized},
+		{name: "manager page password unset", configure: noAdminPass, request: basic(http.MethodGet, "/manager.html", testAdminUser, ""), want: http.StatusUnauthorized},
+
+		{name: "webdav", request: basic("PROPFIND", "/dav/", testAdminUser, testAdminPass), want: http.StatusMultiStatus},
+		{name: "webdav wrong user", request: basic("PROPFIND", "/dav/", "root", testAdminPass), want: http.StatusUnauthorized},
+		{name: "webdav both wrong", request: basic("PROPFIND", "/dav/", "root", "guess"), want: http.StatusUnauthorized},
+		{name: "webdav username unset", configure: noAdminUser, request: basic("PROPFIND", "/dav/", "", testAdminPass), want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.configure)
+			rec := s.serve(tt.request(t))
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d; body %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if rec.Code == http.StatusUnauthorized && strings.HasPrefix(tt.name, "admin api") && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("401 without a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestListLoginSession(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	rec := s.serve(loginRequest(testAdminPass))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("login set no cookie")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	if rec := s.serve(r); rec.Code != http.StatusUnauthorized {
+		t.Errorf("file list without a session: status %d, want 401", rec.Code)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	if rec := s.serve(r); rec.Code != http.StatusOK {
+		t.Errorf("file list with the session: status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
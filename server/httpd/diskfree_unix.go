@@ -0,0 +1,14 @@
+// +build !windows
+
+package httpd
+
+import "syscall"
+
+// DiskFree returns the bytes available to unprivileged users on the volume holding path
+func DiskFree(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,123 @@
+package httpd
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"httpserver/server/logging"
+)
+
+// errorPage is the data passed to error page templates
+type errorPage struct {
+	Status     int
+	StatusText string
+	Code       string
+	Message    string
+	RequestID  string
+}
+
+// defaultErrorPage is used for statuses without an override in server.templates_dir
+var defaultErrorPage = template.Must(template.New("error").Parse(errorPageHTML))
+
+// writeError answers a failed request: API clients (/api/ paths, or an Accept
+// header asking for JSON) get the usual JSON error, browsers an HTML page
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if wantsJSON(r) {
+		s.writeJSONError(w, status, code, message)
+		return
+	}
+
+	var body bytes.Buffer
+	page := errorPage{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Code:       code,
+		Message:    message,
+		RequestID:  RequestID(r),
+	}
+	if err := s.errorTemplate(status).Execute(&body, page); err != nil {
+		logging.Warn("Failed to render error page", logging.Fields{"status": status, "request_id": page.RequestID, "error": err})
+		body.Reset()
+		defaultErrorPage.Execute(&body, page)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// wantsJSON reports whether a request should get JSON rather than HTML errors
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// errorTemplate returns the template for a status: <status>.html or error.html
+// from server.templates_dir, or the embedded default. Templates are read on
+// each use, so they can be edited without a restart.
+func (s *Server) errorTemplate(status int) *template.Template {
+	dir := s.cfg().Server.TemplatesDir
+	if dir == "" {
+		return defaultErrorPage
+	}
+	for _, name := range []string{strconv.Itoa(status) + ".html", "error.html"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			var tmpl *template.Template
+			if tmpl, err = template.New(name).Parse(string(content)); err == nil {
+				return tmpl
+			}
+		}
+		logging.Warn("Failed to load error page template", logging.Fields{"path": filepath.Join(dir, name), "error": err})
+		break
+	}
+	return defaultErrorPage
+}
+
+const errorPageHTML = `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Status}} {{.StatusText}} - HTTP Image Hosting</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: Arial, sans-serif; margin: 0; background: #f5f5f5; color: #333; display: flex; justify-content: center; align-items: center; min-height: 100vh; }
+        .error-box { background: white; padding: 40px; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); max-width: 480px; text-align: center; }
+        .status { font-size: 64px; font-weight: bold; color: #007bff; margin: 0; }
+        h1 { font-size: 22px; margin: 10px 0 20px; }
+        p { line-height: 1.5; }
+        .request-id { margin-top: 30px; font-size: 12px; color: #888; }
+        a { color: #007bff; text-decoration: none; }
+        a:hover { text-decoration: underline; }
+    </style>
+</head>
+<body>
+    <div class="error-box">
+        <p class="status">{{.Status}}</p>
+        <h1>{{.StatusText}}</h1>
+        {{if eq .Status 404}}<p>The file you are looking for doesn't exist. Check the link for typos.</p>
+        {{else if eq .Status 410}}<p>This file has expired and is no longer available.</p>
+        {{else}}<p>{{.Message}}</p>{{end}}
+        <p><a href="/">Home</a></p>
+        {{if .RequestID}}<p class="request-id">Request ID: {{.RequestID}}</p>{{end}}
+    </div>
+</body>
+</html>`
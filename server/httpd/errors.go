@@ -0,0 +1,68 @@
+package httpd
+
+import "net/http"
+
+// Error codes returned as "code" in JSON error bodies. The "message" is meant
+// for people and may change; clients should branch on the code instead.
+const (
+	// Malformed or invalid requests (400)
+	CodeBadRequest    = "bad_request"    // Unparseable body or missing/invalid parameter
+	CodeInvalidPath   = "invalid_path"   // File path or date directory is not acceptable
+	CodeInvalidTTL    = "invalid_ttl"    // TTL is not a number or outside 1..storage.max_ttl
+	CodeInvalidSlug   = "invalid_slug"   // Slug has the wrong length or characters
+	CodeInvalidTag    = "invalid_tag"    // Tag has the wrong length or characters
+	CodeInvalidConfig = "invalid_config" // Unknown config key or a value its schema rejects
+	CodeHashMismatch  = "hash_mismatch"  // Replicated or chunked content doesn't match its hash
+
+	// Authentication and authorization (401, 403)
+	CodeUnauthorized      = "unauthorized"          // Missing or wrong password or credentials
+	CodeSessionExpired    = "session_expired"       // File list session has expired; log in again
+	CodeInvalidCSRF       = "invalid_csrf"          // Missing or wrong X-CSRF-Token
+	CodeTOTPRequired      = "totp_required"         // Admin request lacks a valid second factor
+	CodeInvalidTOTP       = "invalid_totp"          // Two-factor code is wrong
+	CodeInvalidToken      = ReasonInvalidToken      // API key is missing or unknown
+	CodeTokenExpired      = ReasonTokenExpired      // API token has expired
+	CodeInsufficientScope = ReasonInsufficientScope // API token lacks the scope for the request
+	CodeBanned            = "banned"                // Client address is banned
+
+	// Missing resources and conflicts (404, 409, 410)
+	CodeNotFound  = "not_found"
+	CodeSlugTaken = "slug_taken" // Another live file owns the slug
+	CodeConflict  = "conflict"   // The request conflicts with the current state
+	CodeExpired   = "expired"    // The file's TTL has passed
+
+	// Limits (413, 429, 503)
+	CodeFileTooLarge    = "file_too_large"    // Upload exceeds storage.max_file_size
+	CodeArchiveTooLarge = "archive_too_large" // Archive would exceed the archive size cap
+	CodeQuotaExceeded   = "quota_exceeded"    // Daily upload quota used up; see Retry-After
+	CodeRateLimited     = "rate_limited"      // Too many concurrent requests; retry later
+	CodeReadOnly        = "read_only"         // Server is in read-only maintenance mode
+	CodeUnavailable     = "unavailable"       // A required component is not running
+
+	// Server-side failures (500)
+	CodeInternal = "internal_error"
+)
+
+// codeForStatus returns the generic code for an error status, for errors whose
+// status is decided elsewhere (e.g. by readUploadForm)
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusGone:
+		return CodeExpired
+	case http.StatusRequestEntityTooLarge:
+		return CodeFileTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
@@ -0,0 +1,89 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"httpserver/server/events"
+	"httpserver/server/logging"
+)
+
+// eventsHeartbeat is how often the admin event stream sends the totals, which
+// also keeps an idle connection from being closed by proxies
+const eventsHeartbeat = 15 * time.Second
+
+// handleAdminEvents streams server activity as Server-Sent Events: an event
+// per upload, delete and cleanup run, and the file totals on connecting and
+// every eventsHeartbeat. A client too slow to keep up is sent a "dropped"
+// event and disconnected; it should reconnect and reload what it shows.
+func (s *Server) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, "Streaming is not supported")
+		return
+	}
+	sub := s.events.Subscribe()
+	if sub == nil {
+		s.writeJSONError(w, http.StatusServiceUnavailable, CodeUnavailable, "Live events are not available")
+		return
+	}
+	defer s.events.Unsubscribe(sub)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	s.writeEvent(w, s.statsEvent())
+	flusher.Flush()
+
+	logging.Info("Admin event stream opened", logging.Fields{"ip": getRemoteIP(r), "subscribers": s.events.Subscribers(), "request_id": RequestID(r)})
+	ticker := time.NewTicker(eventsHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				s.writeEvent(w, events.Event{Type: "dropped", Time: time.Now().UTC()})
+				flusher.Flush()
+				logging.Warn("Admin event stream dropped a slow client", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r)})
+				return
+			}
+			s.writeEvent(w, event)
+		case <-ticker.C:
+			s.writeEvent(w, s.statsEvent())
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// statsEvent returns the current file totals as an event
+func (s *Server) statsEvent() events.Event {
+	counts, _ := s.db.GetFileCounts()
+	return events.Event{Type: events.TypeStats, Time: time.Now().UTC(), Data: map[string]interface{}{
+		"total_files":   counts.LiveFiles + counts.ExpiredFiles,
+		"total_size":    counts.LiveSize + counts.ExpiredSize,
+		"expired_files": counts.ExpiredFiles,
+		"expired_size":  counts.ExpiredSize,
+	}}
+}
+
+// writeEvent writes one Server-Sent Event, named after its type
+func (s *Server) writeEvent(w http.ResponseWriter, event events.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
@@ -0,0 +1,88 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpserver/server/db"
+)
+
+// fileInfo is a file's metadata with the fields computed for API clients
+type fileInfo struct {
+	*db.FileMetadata
+	SecondsUntilExpiry *int64 `json:"seconds_until_expiry,omitempty"` // Absent for files that never expire
+	DownloadURL        string `json:"download_url"`
+	SlugURL            string `json:"slug_url,omitempty"`
+}
+
+func newFileInfo(meta *db.FileMetadata, now time.Time) fileInfo {
+	info := fileInfo{
+		FileMetadata: meta,
+		DownloadURL:  "/files/" + filepath.ToSlash(meta.FilePath),
+	}
+	if !meta.IsPinned() {
+		seconds := int64(meta.ExpiresAt.Sub(now) / time.Second)
+		info.SecondsUntilExpiry = &seconds
+	}
+	if meta.Slug != "" {
+		info.SlugURL = "/s/" + meta.Slug
+	}
+	return info
+}
+
+// handleAPIFileInfo handles GET /api/files/{id} and /api/files/by-path?path=...,
+// which describe one file. The mux sends every /api/files/ path without a
+// pattern of its own here; /api/files, /api/files/archive and /api/files/recent
+// are exact patterns and win over this subtree.
+func (s *Server) handleAPIFileInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check session or list token
+	if !s.checkListAccess(w, r) {
+		return
+	}
+
+	var meta *db.FileMetadata
+	switch rest := strings.TrimPrefix(r.URL.Path, "/api/files/"); {
+	case rest == "by-path":
+		filePath := r.URL.Query().Get("path")
+		if filePath == "" || strings.Contains(filePath, "..") {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidPath, "Invalid file path")
+			return
+		}
+		meta, _ = s.db.GetFileMetadata(filePath)
+	case rest != "" && isAllDigits(rest):
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "File not found")
+			return
+		}
+		meta, _ = s.db.GetFileMetadataByID(id)
+	default:
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "Not found")
+		return
+	}
+
+	if meta == nil || meta.IsDeleted() {
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+	// Expired files stay in the database until the next cleanup run
+	now := time.Now()
+	if !meta.IsPinned() && !meta.ExpiresAt.After(now) {
+		s.writeJSONError(w, http.StatusGone, CodeExpired, fmt.Sprintf("File expired at %s", meta.ExpiresAt.UTC().Format(time.RFC3339)))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"file":    newFileInfo(meta, now),
+	})
+}
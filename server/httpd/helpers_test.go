@@ -0,0 +1,58 @@
+package httpd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"httpserver/server/config"
+	"httpserver/server/db"
+)
+
+// Credentials of the test server, the defaults of a new installation
+const (
+	testAPIKey    = "change-me-api-key"
+	testAdminUser = "276793422"
+	testAdminPass = "490003219"
+)
+
+// testClientAddr is where httptest.NewRequest says requests come from: a
+// client, not one of the trusted proxies
+const testClientAddr = "192.0.2.1:1234"
+
+// newTestServer returns a server with the default configuration, storing
+// files and metadata in temporary directories. configure, if set, adjusts the
+// configuration before the server is created.
+func newTestServer(t *testing.T, configure func(cfg *config.Config)) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	cfg, err := config.Load(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	cfg.Storage.ImagesDir = filepath.Join(dir, "Images")
+	cfg.Database.Path = filepath.Join(dir, "metadata.db")
+	cfg.Security.TrustedProxies = []string{"127.0.0.1", "::1"}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	database, err := db.Open(cfg.Database.Path)
+	if err != nil {
+		t.Fatalf("db.Open: %v", err)
+	}
+	s := NewServer(cfg, database)
+	t.Cleanup(func() {
+		s.closeOnce.Do(func() { close(s.closing) })
+		database.Close()
+	})
+	return s
+}
+
+// serve runs a request through the server's full middleware chain
+func (s *Server) serve(r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, r)
+	return rec
+}
@@ -0,0 +1,88 @@
+package httpd
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+)
+
+// IdempotencyKeyHeader names an upload so a retry of it returns the first
+// result instead of storing the file twice; uploads may send it as the
+// idempotency_key form field instead
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyReplayedHeader marks a response repeated from an earlier upload
+const idempotencyReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLen bounds the keys clients may send
+const maxIdempotencyKeyLen = 255
+
+// validIdempotencyKey accepts up to maxIdempotencyKeyLen printable ASCII
+// characters
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyWindow returns how long upload keys are remembered, 0 when they
+// are ignored
+func (s *Server) idempotencyWindow() time.Duration {
+	return time.Duration(s.cfg().Storage.IdempotencyWindowHours) * time.Hour
+}
+
+// idempotencySubject is the uploader a key belongs to: the API key when there
+// is one, else the client address, so clients can't replay each other's uploads
+func idempotencySubject(quotas []uploadQuota) string {
+	return quotas[len(quotas)-1].subject
+}
+
+// startIdempotent claims an upload's key until the returned release is called.
+// It returns false after writing the response: the original result when the key
+// was used within the window, or a conflict while another request holds it.
+func (s *Server) startIdempotent(w http.ResponseWriter, r *http.Request, subject, key string) (release func(), ok bool) {
+	if !validIdempotencyKey(key) {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Idempotency-Key must be 1-255 printable ASCII characters")
+		return nil, false
+	}
+	claim := subject + "\x00" + key
+	if _, busy := s.idempotent.LoadOrStore(claim, struct{}{}); busy {
+		s.writeJSONError(w, http.StatusConflict, CodeConflict, "An upload with this Idempotency-Key is still in progress")
+		return nil, false
+	}
+	release = func() { s.idempotent.Delete(claim) }
+
+	if rec := s.db.FindIdempotencyKey(subject, key, s.idempotencyWindow()); rec != nil {
+		release()
+		w.Header().Set("Location", "/files/"+rec.FilePath)
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		s.writeJSON(w, http.StatusCreated, rec.Response)
+		logging.Info("Upload replayed", logging.Fields{"path": rec.FilePath, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+		return nil, false
+	}
+	return release, true
+}
+
+// rememberIdempotent stores a finished upload's response under its key
+func (s *Server) rememberIdempotent(r *http.Request, subject, key, filePath string, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		logging.Warn("Failed to remember upload for its Idempotency-Key", logging.Fields{"path": filePath, "request_id": RequestID(r), "error": err})
+		return
+	}
+	s.db.PutIdempotencyKey(db.IdempotencyRecord{
+		Subject:  subject,
+		Key:      key,
+		FilePath: filePath,
+		Response: body,
+	}, s.idempotencyWindow())
+}
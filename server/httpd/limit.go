@@ -0,0 +1,121 @@
+package httpd
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"httpserver/server/throttle"
+)
+
+// semaphore bounds how many requests of one kind run at once. A limit of zero
+// means unlimited; in-flight requests are counted either way for /health.
+type semaphore struct {
+	slots    chan struct{} // nil when unlimited
+	inFlight int64
+}
+
+// newSemaphore creates a semaphore with the given number of slots
+func newSemaphore(limit int) *semaphore {
+	sem := &semaphore{}
+	if limit > 0 {
+		sem.slots = make(chan struct{}, limit)
+	}
+	return sem
+}
+
+// acquire takes a slot, waiting up to wait for one to free up. It gives up early
+// when the request's context is done, e.g. because the client disconnected.
+func (sem *semaphore) acquire(r *http.Request, wait time.Duration) bool {
+	if sem.slots != nil {
+		select {
+		case sem.slots <- struct{}{}:
+		default:
+			if wait <= 0 {
+				return false
+			}
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case sem.slots <- struct{}{}:
+			case <-timer.C:
+				return false
+			case <-r.Context().Done():
+				return false
+			}
+		}
+	}
+	atomic.AddInt64(&sem.inFlight, 1)
+	return true
+}
+
+// release returns a slot taken by acquire
+func (sem *semaphore) release() {
+	atomic.AddInt64(&sem.inFlight, -1)
+	if sem.slots != nil {
+		<-sem.slots
+	}
+}
+
+// active returns the number of requests currently holding a slot
+func (sem *semaphore) active() int64 {
+	return atomic.LoadInt64(&sem.inFlight)
+}
+
+// acquireSlot takes a slot from sem or responds with 503 and Retry-After.
+// Callers must release the slot when acquireSlot returns true.
+func (s *Server) acquireSlot(w http.ResponseWriter, r *http.Request, sem *semaphore, kind string) bool {
+	wait := time.Duration(s.cfg().Server.ConcurrencyWaitSeconds) * time.Second
+	if sem.acquire(r, wait) {
+		return true
+	}
+	if r.Context().Err() != nil {
+		// Client went away while queued; nobody is left to answer
+		return false
+	}
+
+	retryAfter := s.cfg().Server.ConcurrencyWaitSeconds
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	s.writeJSONError(w, http.StatusServiceUnavailable, CodeRateLimited, "Too many concurrent "+kind+", please retry later")
+	return false
+}
+
+// throttledResponseWriter paces the response body. Only Write is overridden, so
+// io.ReaderFrom is hidden and ServeFile falls back to a plain copy (Range requests
+// keep working; only sendfile is lost while throttling is enabled).
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	body *throttle.Writer
+}
+
+func (tw *throttledResponseWriter) Write(p []byte) (int, error) {
+	return tw.body.Write(p)
+}
+
+// kbpsToBytes converts a rate in kilobits per second to bytes per second
+func kbpsToBytes(kbps int) int {
+	return kbps * 1000 / 8
+}
+
+// throttleDownload wraps w with the configured per-download and global rate caps;
+// w is returned unchanged when neither is set
+func (s *Server) throttleDownload(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	var limiters []*throttle.Limiter
+	if kbps := s.cfg().Storage.DownloadRateLimitKbps; kbps > 0 {
+		limiters = append(limiters, throttle.NewLimiter(kbpsToBytes(kbps)))
+	}
+	if s.downloadLimiter != nil {
+		limiters = append(limiters, s.downloadLimiter)
+	}
+	if len(limiters) == 0 {
+		return w
+	}
+	return &throttledResponseWriter{
+		ResponseWriter: w,
+		body:           throttle.NewWriter(r.Context(), w, limiters...),
+	}
+}
@@ -0,0 +1,200 @@
+package httpd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"httpserver/server/db"
+)
+
+// Bounds of the listing cache. A date with tens of thousands of uploads
+// serializes to several megabytes, so the bytes are bounded as well as the
+// number of dates.
+const (
+	listingCacheDates    = 32
+	listingCacheMaxBytes = 64 * 1024 * 1024
+)
+
+// listingCache keeps the serialized /api/files response of recently listed
+// date directories, plain and gzipped, so a large day isn't marshaled and
+// compressed again on every request. An entry is valid for the date
+// generation it was built at (see db.DateGeneration); the least recently
+// used dates are dropped to stay within the bounds. A listing that leaves out
+// expired files is also only valid until the first of its files expires.
+type listingCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // key -> element holding a *cachedListing
+	lru     *list.List               // Most recently used at the front
+	size    int64                    // Bytes held, both encodings
+}
+
+// cachedListing is one serialized listing
+type cachedListing struct {
+	key        string
+	generation uint64
+	validUntil time.Time // Zero if the listing doesn't change with time
+	etag       string    // Quoted, of the plain body; the gzipped one adds -gzip
+	body       []byte
+	gzipped    []byte // Built on the first request that accepts gzip
+}
+
+func newListingCache() *listingCache {
+	return &listingCache{entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get returns the listing cached under key at generation, or nil
+func (c *listingCache) get(key string, generation uint64) *cachedListing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cachedListing)
+	if entry.generation != generation || (!entry.validUntil.IsZero() && !time.Now().Before(entry.validUntil)) {
+		c.removeLocked(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry
+}
+
+// put caches a listing, evicting the least recently used ones past the bounds.
+// A listing too large for the cache on its own isn't kept.
+func (c *listingCache) put(entry *cachedListing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.removeLocked(elem)
+	}
+	if int64(len(entry.body)) > listingCacheMaxBytes/2 {
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += int64(len(entry.body))
+	c.trimLocked()
+}
+
+// gzip returns the gzipped body of a cached listing, compressing it once
+func (c *listingCache) gzip(entry *cachedListing) []byte {
+	c.mu.Lock()
+	gzipped := entry.gzipped
+	c.mu.Unlock()
+	if gzipped != nil {
+		return gzipped
+	}
+
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	gz.Write(entry.body)
+	gz.Close()
+	gzipped = buf.Bytes()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.gzipped == nil {
+		entry.gzipped = gzipped
+		if _, cached := c.entries[entry.key]; cached {
+			c.size += int64(len(gzipped))
+			c.trimLocked()
+		}
+	}
+	return entry.gzipped
+}
+
+// trimLocked evicts entries until the cache is within its bounds (caller must hold mu)
+func (c *listingCache) trimLocked() {
+	for c.lru.Len() > listingCacheDates || c.size > listingCacheMaxBytes {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// removeLocked drops an entry (caller must hold mu)
+func (c *listingCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*cachedListing)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.body) + len(entry.gzipped))
+}
+
+// newCachedListing serializes a listing response the way writeJSON does
+func newCachedListing(key string, generation uint64, validUntil time.Time, response interface{}) (*cachedListing, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(response); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return &cachedListing{
+		key:        key,
+		generation: generation,
+		validUntil: validUntil,
+		etag:       `"` + hex.EncodeToString(sum[:16]) + `"`,
+		body:       buf.Bytes(),
+	}, nil
+}
+
+// firstExpiry returns the earliest expiry time among files, zero if none expire
+func firstExpiry(files []*db.FileMetadata) time.Time {
+	var first time.Time
+	for _, meta := range files {
+		if !meta.IsPinned() && (first.IsZero() || meta.ExpiresAt.Before(first)) {
+			first = meta.ExpiresAt
+		}
+	}
+	return first
+}
+
+// serveCachedListing writes a cached listing, gzipped when the client accepts
+// it and compression is on, or 304 Not Modified when the client's copy is
+// current. Each encoding has its own ETag, as the bytes differ. A body that
+// is already gzipped passes through withCompression, which adds the Vary.
+func (s *Server) serveCachedListing(w http.ResponseWriter, r *http.Request, entry *cachedListing) {
+	body, etag := entry.body, entry.etag
+	h := w.Header()
+	if s.cfg().Server.EnableCompression {
+		if negotiateEncoding(r.Header.Get("Accept-Encoding")) == "gzip" {
+			body = s.listings.gzip(entry)
+			etag = strings.TrimSuffix(etag, `"`) + `-gzip"`
+			h.Set("Content-Encoding", "gzip")
+		}
+	}
+	h.Set("Content-Type", "application/json")
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "private, no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 asks for GET
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package httpd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"httpserver/server/config"
+)
+
+// listen opens one listener. Unix sockets left behind by a crash are removed
+// first, and new ones get server.socket_mode and server.socket_group.
+func (s *Server) listen(addr config.ListenAddress) (net.Listener, error) {
+	if addr.Network != "unix" {
+		return net.Listen(addr.Network, addr.Address)
+	}
+
+	if err := removeStaleSocket(addr.Address); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", addr.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setSocketOwnership(addr.Address); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes a socket file nothing is listening on any more
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+// setSocketOwnership applies the configured mode and group to a unix socket
+func (s *Server) setSocketOwnership(path string) error {
+	cfg := s.cfg().Server
+	mode, err := config.ParseSocketMode(cfg.SocketMode)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", path, err)
+	}
+
+	if cfg.SocketGroup == "" {
+		return nil
+	}
+	group, err := user.LookupGroup(cfg.SocketGroup)
+	if err != nil {
+		return fmt.Errorf("unknown socket group %q: %w", cfg.SocketGroup, err)
+	}
+	gid, err := strconv.Atoi(group.Gid)
+	if err != nil {
+		return fmt.Errorf("socket groups are not supported on this platform")
+	}
+	if err := os.Chown(path, -1, gid); err != nil {
+		return fmt.Errorf("failed to set group of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package httpd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"httpserver/server/config"
+	"httpserver/server/db"
+	"httpserver/server/logging"
+)
+
+// ControlTokenHeader carries the token local CLI commands use to reach the admin API
+const ControlTokenHeader = "X-Control-Token"
+
+// liveConfigKeys maps config keys that the server reads per request to setters
+// on a config copy. Other keys are stored but only take effect after a restart.
+var liveConfigKeys = map[string]func(c *config.Config, v string) error{
+	"server.min_free_disk_mb":          func(c *config.Config, v string) error { return parseInt(v, &c.Server.MinFreeDiskMB) },
+	"server.concurrency_wait_seconds":  func(c *config.Config, v string) error { return parseInt(v, &c.Server.ConcurrencyWaitSeconds) },
+	"server.read_only":                 func(c *config.Config, v string) error { c.Server.ReadOnly = v == "true"; return nil },
+	"server.maintenance_message":       func(c *config.Config, v string) error { c.Server.MaintenanceMessage = v; return nil },
+	"server.enable_compression":        func(c *config.Config, v string) error { c.Server.EnableCompression = v == "true"; return nil },
+	"server.templates_dir":             func(c *config.Config, v string) error { c.Server.TemplatesDir = v; return nil },
+	"server.allow_indexing":            func(c *config.Config, v string) error { c.Server.AllowIndexing = v == "true"; return nil },
+	"server.redirect_http":             func(c *config.Config, v string) error { c.Server.RedirectHTTP = v == "true"; return nil },
+	"storage.max_file_size":            func(c *config.Config, v string) error { return parseInt64(v, &c.Storage.MaxFileSize) },
+	"storage.default_ttl":              func(c *config.Config, v string) error { return parseTTL(v, &c.Storage.DefaultTTL) },
+	"storage.max_ttl":                  func(c *config.Config, v string) error { return parseTTL(v, &c.Storage.MaxTTL) },
+	"storage.allow_permanent":          func(c *config.Config, v string) error { c.Storage.AllowPermanent = v == "true"; return nil },
+	"storage.retention_rules":          parseRetentionRules,
+	"storage.path_layout":              func(c *config.Config, v string) error { c.Storage.PathLayout = v; return nil },
+	"storage.preserve_original_name":   func(c *config.Config, v string) error { c.Storage.PreserveOriginalName = v == "true"; return nil },
+	"storage.name_entropy_bytes":       func(c *config.Config, v string) error { return parseInt(v, &c.Storage.NameEntropyBytes) },
+	"storage.name_style":               func(c *config.Config, v string) error { c.Storage.NameStyle = v; return nil },
+	"storage.max_archive_size":         func(c *config.Config, v string) error { return parseInt64(v, &c.Storage.MaxArchiveSize) },
+	"storage.download_rate_limit_kbps": func(c *config.Config, v string) error { return parseInt(v, &c.Storage.DownloadRateLimitKbps) },
+	"storage.idempotency_window_hours": func(c *config.Config, v string) error { return parseInt(v, &c.Storage.IdempotencyWindowHours) },
+	"storage.inline_extensions": func(c *config.Config, v string) error {
+		c.Storage.InlineExtensions = config.ParseExtensionList(v)
+		return nil
+	},
+	"storage.force_download_extensions": func(c *config.Config, v string) error {
+		c.Storage.ForceDownloadExtensions = config.ParseExtensionList(v)
+		return nil
+	},
+	"auth.api_key":                        func(c *config.Config, v string) error { c.Auth.APIKey = v; return nil },
+	"auth.admin_username":                 func(c *config.Config, v string) error { c.Auth.AdminUsername = v; return nil },
+	"auth.admin_password":                 func(c *config.Config, v string) error { c.Auth.AdminPassword = v; return nil },
+	"auth.list_password":                  func(c *config.Config, v string) error { c.Auth.ListPassword = v; return nil },
+	"auth.totp_secret":                    func(c *config.Config, v string) error { c.Auth.TOTPSecret = v; return nil },
+	"auth.totp_recovery_codes":            func(c *config.Config, v string) error { c.Auth.TOTPRecoveryCodes = splitList(v); return nil },
+	"security.session_timeout":            func(c *config.Config, v string) error { return parseInt(v, &c.Security.SessionTimeout) },
+	"security.session_absolute_timeout":   func(c *config.Config, v string) error { return parseInt(v, &c.Security.SessionAbsoluteTimeout) },
+	"security.allow_remember_me":          func(c *config.Config, v string) error { c.Security.AllowRememberMe = v == "true"; return nil },
+	"security.cookie_secure":              func(c *config.Config, v string) error { c.Security.CookieSecure = v; return nil },
+	"security.cookie_samesite_strict":     func(c *config.Config, v string) error { c.Security.CookieSameSiteStrict = v == "true"; return nil },
+	"security.allow_inline_html":          func(c *config.Config, v string) error { c.Security.AllowInlineHTML = v == "true"; return nil },
+	"security.upload_quota_per_day_bytes": func(c *config.Config, v string) error { return parseInt64(v, &c.Security.UploadQuotaPerDayBytes) },
+	"security.ip_whitelist":               func(c *config.Config, v string) error { c.Security.IPWhitelist = splitList(v); return nil },
+	"security.trusted_proxies":            func(c *config.Config, v string) error { c.Security.TrustedProxies = splitList(v); return nil },
+	"security.autoban_threshold":          func(c *config.Config, v string) error { return parseInt(v, &c.Security.AutobanThreshold) },
+	"security.autoban_window_minutes":     func(c *config.Config, v string) error { return parseInt(v, &c.Security.AutobanWindowMinutes) },
+	"security.autoban_duration_minutes":   func(c *config.Config, v string) error { return parseInt(v, &c.Security.AutobanDurationMinutes) },
+	"security.hsts_max_age":               func(c *config.Config, v string) error { return parseInt(v, &c.Security.HSTSMaxAge) },
+	"logging.access_log":                  func(c *config.Config, v string) error { c.Logging.AccessLog = v == "true"; return nil },
+}
+
+// updateConfig stores a config value and, for keys the server reads per request,
+// applies it to the running server. It reports whether the change is live.
+func (s *Server) updateConfig(key, value string) (bool, error) {
+	s.cfgMux.Lock()
+	defer s.cfgMux.Unlock()
+
+	apply, live := liveConfigKeys[key]
+	var next config.Config
+	if live {
+		next = *s.cfg()
+		if err := apply(&next, value); err != nil {
+			return false, fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+	}
+
+	if err := s.db.SetConfig(key, value); err != nil {
+		return false, err
+	}
+	if live {
+		s.cfgValue.Store(&next)
+	}
+	return live, nil
+}
+
+// deleteConfig removes a stored config key. A live key falls back to its built-in
+// default on the running server; the result reports whether it was stored and
+// whether the change is live.
+func (s *Server) deleteConfig(key string) (removed, live bool, err error) {
+	s.cfgMux.Lock()
+	defer s.cfgMux.Unlock()
+
+	removed, err = s.db.DeleteConfig(key)
+	if err != nil || !removed {
+		return removed, false, err
+	}
+	if def := db.DefaultConfigValue(key); def != "" {
+		live = len(s.applyLiveLocked([]db.ConfigChange{{Key: key, NewValue: def}})) > 0
+	}
+	return removed, live, nil
+}
+
+// resetConfig restores the built-in defaults for a key group (see db.ResetConfig)
+// and applies the live keys among them. It returns the changes and the live keys.
+func (s *Server) resetConfig(prefix string) ([]db.ConfigChange, []string, error) {
+	s.cfgMux.Lock()
+	defer s.cfgMux.Unlock()
+
+	changes, err := s.db.ResetConfig(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, s.applyLiveLocked(changes), nil
+}
+
+// applyLiveLocked applies changed values of live keys to a copy of the running
+// config and swaps it in, returning the keys applied (caller must hold cfgMux)
+func (s *Server) applyLiveLocked(changes []db.ConfigChange) []string {
+	next := *s.cfg()
+	live := []string{}
+	for _, change := range changes {
+		apply, ok := liveConfigKeys[change.Key]
+		if !ok {
+			continue
+		}
+		if err := apply(&next, change.NewValue); err != nil {
+			logging.Warn("Failed to apply config change", logging.Fields{"key": change.Key, "error": err})
+			continue
+		}
+		live = append(live, change.Key)
+	}
+	if len(live) > 0 {
+		s.cfgValue.Store(&next)
+	}
+	return live
+}
+
+// isControlRequest reports whether a request carries the local control token and
+// comes from the loopback interface
+func (s *Server) isControlRequest(r *http.Request) bool {
+	token := r.Header.Get(ControlTokenHeader)
+	if token == "" || !isLocalConn(r) {
+		return false
+	}
+	return secretMatches(token, s.controlToken)
+}
+
+// isLocalConn reports whether the request arrived over loopback or a unix socket
+func isLocalConn(r *http.Request) bool {
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	return err == nil && net.ParseIP(host).IsLoopback()
+}
+
+func parseInt(v string, dst *int) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func parseTTL(v string, dst *config.TTL) error {
+	ttl, err := config.ParseTTL(v)
+	if err != nil {
+		return err
+	}
+	*dst = ttl
+	return nil
+}
+
+func parseRetentionRules(c *config.Config, v string) error {
+	rules, err := config.ParseRetentionRules(v)
+	if err != nil {
+		return err
+	}
+	c.Storage.RetentionRules = rules
+	return nil
+}
+
+func parseInt64(v string, dst *int64) error {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+// splitList parses a comma-separated config value
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
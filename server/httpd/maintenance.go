@@ -0,0 +1,87 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"httpserver/server/logging"
+)
+
+// maintenanceRetryAfter is the Retry-After (seconds) sent while the server is read-only
+const maintenanceRetryAfter = 300
+
+// defaultMaintenanceMessage is returned when server.maintenance_message is empty
+const defaultMaintenanceMessage = "The server is in read-only maintenance mode, please retry later"
+
+// refuseIfReadOnly responds with 503 and Retry-After while server.read_only is
+// set. Handlers that create, modify or delete files call it before doing any work.
+func (s *Server) refuseIfReadOnly(w http.ResponseWriter) bool {
+	cfg := s.cfg()
+	if !cfg.Server.ReadOnly {
+		return false
+	}
+
+	message := cfg.Server.MaintenanceMessage
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+	s.writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+		"success": false,
+		"code":    CodeReadOnly,
+		"message": message,
+		"reason":  "read_only",
+	})
+	return true
+}
+
+// handleAdminMaintenance reports (GET) or toggles (POST) read-only maintenance
+// mode. The change is persisted and applies immediately.
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cfg := s.cfg()
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":   true,
+			"read_only": cfg.Server.ReadOnly,
+			"message":   cfg.Server.MaintenanceMessage,
+		})
+	case http.MethodPost:
+		var req struct {
+			ReadOnly *bool  `json:"read_only"`
+			Message  string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ReadOnly == nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request: read_only is required")
+			return
+		}
+
+		// Store the message first so requests refused right after the switch see it
+		if _, err := s.updateConfig("server.maintenance_message", req.Message); err != nil {
+			s.audit(r, AuditMaintenance, "", false, err.Error())
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save config: %v", err))
+			return
+		}
+		if _, err := s.updateConfig("server.read_only", strconv.FormatBool(*req.ReadOnly)); err != nil {
+			s.audit(r, AuditMaintenance, "", false, err.Error())
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save config: %v", err))
+			return
+		}
+		s.audit(r, AuditMaintenance, strconv.FormatBool(*req.ReadOnly), true, req.Message)
+
+		message := "Read-only mode disabled"
+		if *req.ReadOnly {
+			message = "Read-only mode enabled"
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":   true,
+			"message":   message,
+			"read_only": *req.ReadOnly,
+		})
+		logging.Info(message, logging.Fields{"reason": req.Message, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,102 @@
+package httpd
+
+import (
+	"mime"
+	"path"
+	"strings"
+
+	"httpserver/server/config"
+)
+
+// mediaTypes maps the video and audio extensions people upload to their media
+// types. The system tables mime.TypeByExtension reads differ between
+// platforms and often lack these, which makes browsers download the file
+// instead of playing it.
+var mediaTypes = map[string]string{
+	".3gp":  "video/3gpp",
+	".avi":  "video/x-msvideo",
+	".flv":  "video/x-flv",
+	".m4v":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".mpeg": "video/mpeg",
+	".mpg":  "video/mpeg",
+	".ogv":  "video/ogg",
+	".ts":   "video/mp2t",
+	".webm": "video/webm",
+	".wmv":  "video/x-ms-wmv",
+	".aac":  "audio/aac",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mid":  "audio/midi",
+	".midi": "audio/midi",
+	".mp3":  "audio/mpeg",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".weba": "audio/webm",
+}
+
+// contentType returns the media type of a file by its extension, defaulting
+// to application/octet-stream
+func contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if mediaType, ok := mediaTypes[ext]; ok {
+		return mediaType
+	}
+	if mediaType := mime.TypeByExtension(ext); mediaType != "" {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// activeTypes can run script in the browser when shown inline, on the
+// server's own origin. They are only served inline when
+// security.allow_inline_html is on, whatever the extension lists say.
+var activeTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"image/svg+xml":         true,
+	"text/xml":              true,
+	"application/xml":       true,
+}
+
+// inlineByDefault reports whether browsers should show a media type rather
+// than save it when no extension list names the file
+func inlineByDefault(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"):
+		return true
+	}
+	return mediaType == "text/plain" || mediaType == "application/pdf"
+}
+
+// dispositionType returns "inline" or "attachment" for a download. Active
+// content is always an attachment unless allowed; otherwise
+// storage.force_download_extensions wins over storage.inline_extensions, and
+// files neither names go by their media type.
+func (s *Server) dispositionType(name string) string {
+	cfg := s.cfg()
+	mediaType, _, err := mime.ParseMediaType(contentType(name))
+	if err != nil {
+		return "attachment"
+	}
+	if activeTypes[mediaType] && !cfg.Security.AllowInlineHTML {
+		return "attachment"
+	}
+	ext := config.NormalizeExtension(path.Ext(name))
+	if ext != "" && containsString(cfg.Storage.ForceDownloadExtensions, ext) {
+		return "attachment"
+	}
+	if ext != "" && containsString(cfg.Storage.InlineExtensions, ext) {
+		return "inline"
+	}
+	if activeTypes[mediaType] || inlineByDefault(mediaType) {
+		return "inline"
+	}
+	return "attachment"
+}
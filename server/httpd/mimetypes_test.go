@@ -0,0 +1,151 @@
+package httpd
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"httpserver/server/config"
+)
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		// Video and audio come from the built-in table on every platform
+		{"clip.mp4", "video/mp4"},
+		{"CLIP.MP4", "video/mp4"},
+		{"clip.m4v", "video/mp4"},
+		{"clip.mkv", "video/x-matroska"},
+		{"clip.webm", "video/webm"},
+		{"clip.mov", "video/quicktime"},
+		{"clip.ogv", "video/ogg"},
+		{"song.mp3", "audio/mpeg"},
+		{"song.flac", "audio/flac"},
+		{"song.m4a", "audio/mp4"},
+		{"song.opus", "audio/ogg"},
+		{"20240101/20240101-abc.webm", "video/webm"},
+
+		// Go's own table covers the common image types
+		{"photo.png", "image/png"},
+		{"photo.jpg", "image/jpeg"},
+		{"photo.gif", "image/gif"},
+		{"photo.webp", "image/webp"},
+
+		{"blob", "application/octet-stream"},
+		{"blob.unknownext", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		if got := contentType(tt.name); got != tt.want {
+			t.Errorf("contentType(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDispositionType(t *testing.T) {
+	tests := []struct {
+		name      string
+		inline    []string
+		download  []string
+		allowHTML bool
+		file      string
+		want      string
+	}{
+		// Neither list names the file: its media type decides
+		{name: "image", file: "a.png", want: "inline"},
+		{name: "video", file: "a.mp4", want: "inline"},
+		{name: "audio", file: "a.mp3", want: "inline"},
+		{name: "pdf", file: "a.pdf", want: "inline"},
+		{name: "text", file: "a.txt", want: "inline"},
+		{name: "archive", file: "a.zip", want: "attachment"},
+		{name: "unknown", file: "a.unknownext", want: "attachment"},
+		{name: "no extension", file: "blob", want: "attachment"},
+
+		// The lists override the media type, and downloading wins
+		{name: "forced download", download: []string{"png"}, file: "a.png", want: "attachment"},
+		{name: "forced download any case", download: []string{"png"}, file: "20240101/A.PNG", want: "attachment"},
+		{name: "forced inline", inline: []string{"zip"}, file: "a.zip", want: "inline"},
+		{name: "forced inline unknown", inline: []string{"unknownext"}, file: "a.unknownext", want: "inline"},
+		{name: "both lists", inline: []string{"pdf"}, download: []string{"pdf"}, file: "a.pdf", want: "attachment"},
+		{name: "other extension listed", inline: []string{"zip"}, download: []string{"png"}, file: "a.gif", want: "inline"},
+
+		// Active content is an attachment unless allowed, whatever the lists say
+		{name: "html", file: "a.html", want: "attachment"},
+		{name: "htm", file: "a.htm", want: "attachment"},
+		{name: "svg", file: "a.svg", want: "attachment"},
+		{name: "xml", file: "a.xml", want: "attachment"},
+		{name: "html listed inline", inline: []string{"html"}, file: "a.html", want: "attachment"},
+		{name: "svg listed inline", inline: []string{"svg"}, file: "a.svg", want: "attachment"},
+
+		// Once allowed, active content is inline unless the download list names it
+		{name: "allowed html", allowHTML: true, file: "a.html", want: "inline"},
+		{name: "allowed svg", allowHTML: true, file: "a.svg", want: "inline"},
+		{name: "allowed html forced download", allowHTML: true, download: []string{"html"}, file: "a.html", want: "attachment"},
+		{name: "allowed html listed inline", allowHTML: true, inline: []string{"html"}, file: "a.html", want: "inline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(cfg *config.Config) {
+				cfg.Storage.InlineExtensions = tt.inline
+				cfg.Storage.ForceDownloadExtensions = tt.download
+				cfg.Security.AllowInlineHTML = tt.allowHTML
+			})
+			if got := s.dispositionType(tt.file); got != tt.want {
+				t.Errorf("dispositionType(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+// downloadHeaders fetches url and returns the response
+func downloadHeaders(t *testing.T, s *Server, url string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := s.serve(httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d, body %s", url, rec.Code, rec.Body.String())
+	}
+	return rec
+}
+
+func TestServeFileDisposition(t *testing.T) {
+	s := newTestServer(t, nil)
+	photo := uploadFile(t, s, "My Photo.png", []byte("not really a png"))
+	page := "20240101/page.html"
+	if _, err := s.store.Put(strings.NewReader("<script>alert(1)</script>"), page); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(url, wantType, wantName string) {
+		t.Helper()
+		rec := downloadHeaders(t, s, url)
+		disposition, params, err := mime.ParseMediaType(rec.Header().Get("Content-Disposition"))
+		if err != nil || disposition != wantType || params["filename"] != wantName {
+			t.Errorf("GET %s: Content-Disposition %q, want %s with filename %q", url, rec.Header().Get("Content-Disposition"), wantType, wantName)
+		}
+		if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("GET %s: X-Content-Type-Options %q, want nosniff", url, got)
+		}
+	}
+
+	// The original name is offered for saving, on both routes
+	check(photo, "inline", "My Photo.png")
+	check(strings.TrimPrefix(photo, "/files"), "inline", "My Photo.png")
+	check("/files/"+page, "attachment", "")
+
+	// The lists and the safety switch apply without a restart
+	for key, value := range map[string]string{
+		"storage.force_download_extensions": " .PNG, jpg",
+		"security.allow_inline_html":        "true",
+	} {
+		if _, err := s.updateConfig(key, value); err != nil {
+			t.Fatalf("updateConfig(%s): %v", key, err)
+		}
+	}
+	check(photo, "attachment", "My Photo.png")
+	check("/files/"+page, "inline", "")
+}
@@ -0,0 +1,398 @@
+package httpd
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Security scheme names used in route descriptors
+const (
+	authAPIKey  = "apiKey"     // X-API-Key header carrying an API token
+	authAdmin   = "adminBasic" // Admin basic auth
+	authSession = "session"    // session_token cookie from /api/login
+)
+
+// apiOperation describes one method on an API path. Handlers register their
+// operations with handle, and /api/openapi.json is generated from them.
+type apiOperation struct {
+	Method   string
+	Path     string // OpenAPI path template, e.g. /files/{path}
+	Summary  string
+	Tag      string
+	Auth     []string // Accepted security schemes, any one suffices; empty means public
+	Params   []apiParam
+	Request  *apiBody
+	Response *apiBody // Successful response; nil means a plain success message
+	Status   int      // Success status (200 when zero)
+	Errors   []int    // Error statuses, all answered with the Error schema
+}
+
+// apiParam is a path, query or header parameter
+type apiParam struct {
+	Name        string
+	In          string // "path", "query" or "header"
+	Description string
+	Schema      interface{}
+	Required    bool
+}
+
+// apiBody is a request or response body. Schema is a Go value whose type is
+// described by reflection, an apiObject, or a literal apiSchema.
+type apiBody struct {
+	ContentType string
+	Schema      interface{}
+}
+
+// apiSchema is a literal OpenAPI schema object
+type apiSchema map[string]interface{}
+
+// apiObject describes an ad-hoc JSON object; each value is a Go value whose type
+// gives the property schema, or a literal apiSchema
+type apiObject map[string]interface{}
+
+// Schemas that reflection can't derive
+var (
+	binarySchema = apiSchema{"type": "string", "format": "binary"}
+	anySchema    = apiSchema{}
+)
+
+// jsonBody returns an application/json body described by schema
+func jsonBody(schema interface{}) *apiBody {
+	return &apiBody{ContentType: "application/json", Schema: schema}
+}
+
+// handle registers a handler along with the API operations it serves
+func (s *Server) handle(mux *http.ServeMux, pattern string, handler http.HandlerFunc, ops ...apiOperation) {
+	mux.HandleFunc(pattern, handler)
+	s.apiOps = append(s.apiOps, ops...)
+}
+
+// handleOpenAPI serves the OpenAPI 3 description of the registered routes
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, buildOpenAPI(s.version, s.apiOps))
+}
+
+// handleVersion reports the build the server is running
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, versionInfo{
+		Version:   s.version,
+		BuildTime: s.buildTime,
+		GitCommit: s.gitCommit,
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+	})
+}
+
+// handleCapabilities reports the upload limits, so clients can check a
+// request before sending the file
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	storageCfg := s.cfg().Storage
+	s.writeJSON(w, http.StatusOK, capabilities{
+		DefaultTTL:       storageCfg.DefaultTTL.String(),
+		MaxTTL:           storageCfg.MaxTTL.String(),
+		MaxTTLSeconds:    int64(storageCfg.MaxTTL.Duration() / time.Second),
+		AllowPermanent:   storageCfg.AllowPermanent,
+		MaxFileSize:      storageCfg.MaxFileSize,
+		ResumableUploads: true,
+		ReadOnly:         s.cfg().Server.ReadOnly,
+	})
+}
+
+// capabilities is the /api/capabilities response
+type capabilities struct {
+	DefaultTTL       string `json:"default_ttl"`
+	MaxTTL           string `json:"max_ttl"`         // "never" when TTLs are unbounded
+	MaxTTLSeconds    int64  `json:"max_ttl_seconds"` // 0 when TTLs are unbounded
+	AllowPermanent   bool   `json:"allow_permanent"` // Uploads may ask for a TTL of "never"
+	MaxFileSize      int64  `json:"max_file_size"`   // Bytes; 0 when unlimited
+	ResumableUploads bool   `json:"resumable_uploads"`
+	ReadOnly         bool   `json:"read_only"`
+}
+
+// versionInfo is the /api/version response
+type versionInfo struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time,omitempty"`
+	GitCommit string `json:"git_commit,omitempty"`
+	GoVersion string `json:"go_version"`
+	GOOS      string `json:"os"`
+	GOARCH    string `json:"arch"`
+}
+
+// errorResponse is the body of every JSON error
+type errorResponse struct {
+	Success   bool   `json:"success"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Reason    string `json:"reason,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// buildOpenAPI assembles the OpenAPI document for a set of operations
+func buildOpenAPI(version string, ops []apiOperation) map[string]interface{} {
+	gen := &schemaGenerator{schemas: make(map[string]interface{}), types: make(map[string]reflect.Type)}
+	errorRef := gen.schema(errorResponse{})
+
+	paths := make(map[string]map[string]interface{})
+	for _, op := range ops {
+		item := paths[op.Path]
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+
+		operation := map[string]interface{}{
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+		}
+		if op.Tag != "" {
+			operation["tags"] = []string{op.Tag}
+		}
+		if len(op.Auth) > 0 {
+			security := make([]map[string][]string, 0, len(op.Auth))
+			for _, scheme := range op.Auth {
+				security = append(security, map[string][]string{scheme: {}})
+			}
+			operation["security"] = security
+		}
+
+		var params []map[string]interface{}
+		for _, p := range op.Params {
+			param := map[string]interface{}{
+				"name":     p.Name,
+				"in":       p.In,
+				"required": p.Required || p.In == "path",
+				"schema":   gen.schema(p.Schema),
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  gen.content(op.Request),
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := op.Response
+		if response == nil {
+			response = jsonBody(apiObject{"success": true, "message": ""})
+		}
+		responses := map[string]interface{}{
+			statusKey(status): map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     gen.content(response),
+			},
+		}
+		for _, code := range op.Errors {
+			responses[statusKey(code)] = map[string]interface{}{
+				"description": http.StatusText(code),
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": errorRef},
+				},
+			}
+		}
+		operation["responses"] = responses
+
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "HTTP Image Hosting Server API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]interface{}{
+				authAPIKey:  map[string]interface{}{"type": "apiKey", "in": "header", "name": APIKeyHeader},
+				authAdmin:   map[string]interface{}{"type": "http", "scheme": "basic"},
+				authSession: map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "session_token"},
+			},
+		},
+	}
+}
+
+// operationID derives a unique operation ID from the method and path, e.g.
+// "post_api_admin_files_restore"
+func operationID(op apiOperation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.Split(op.Path, "/") {
+		part = strings.Trim(part, "{}")
+		if part != "" {
+			id += "_" + strings.ReplaceAll(part, "-", "_")
+		}
+	}
+	return id
+}
+
+// statusKey formats a status code as a responses key
+func statusKey(code int) string {
+	return strconv.Itoa(code)
+}
+
+// schemaGenerator converts Go types to OpenAPI schemas, collecting named
+// structs as shared components
+type schemaGenerator struct {
+	schemas map[string]interface{}
+	types   map[string]reflect.Type
+}
+
+// content returns the content map of a body
+func (g *schemaGenerator) content(body *apiBody) map[string]interface{} {
+	return map[string]interface{}{
+		body.ContentType: map[string]interface{}{"schema": g.schema(body.Schema)},
+	}
+}
+
+// schema describes a sample value, an apiObject or a literal apiSchema
+func (g *schemaGenerator) schema(v interface{}) interface{} {
+	switch v := v.(type) {
+	case apiSchema:
+		return map[string]interface{}(v)
+	case apiObject:
+		props := make(map[string]interface{}, len(v))
+		for name, value := range v {
+			props[name] = g.schema(value)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	case nil:
+		return anySchema
+	}
+	return g.typeSchema(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema describes a Go type the way encoding/json encodes it
+func (g *schemaGenerator) typeSchema(t reflect.Type) interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		return g.typeSchema(t.Elem())
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := g.componentName(t)
+		if _, done := g.schemas[name]; !done {
+			g.schemas[name] = anySchema // Placeholder for recursive types
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return anySchema
+}
+
+// componentName names a struct's component schema, qualifying it with the
+// package name when two packages use the same type name
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	name := t.Name()
+	if existing, ok := g.types[name]; ok && existing != t {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	g.types[name] = t
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// structSchema describes the JSON-encoded fields of a struct
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	g.addFields(t, props, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds a struct's fields, flattening embedded structs as encoding/json does
+func (g *schemaGenerator) addFields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, props, required)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue // Unexported
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		props[name] = g.typeSchema(field.Type)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The parts of the OpenAPI 3.0 schema the generated document has to satisfy
+var (
+	openAPIVersion   = regexp.MustCompile(`^3\.0\.\d+$`)
+	responseKey      = regexp.MustCompile(`^([1-5][0-9][0-9]|[1-5]XX|default)$`)
+	pathTemplate     = regexp.MustCompile(`\{([^{}]+)\}`)
+	openAPIMethods   = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+	parameterIn      = map[string]bool{"query": true, "header": true, "path": true, "cookie": true}
+	schemaTypes      = map[string]bool{"string": true, "number": true, "integer": true, "boolean": true, "array": true, "object": true}
+	securitySchemeIn = map[string]bool{"query": true, "header": true, "cookie": true}
+)
+
+// openAPIValidator collects the problems found in a document
+type openAPIValidator struct {
+	doc      map[string]interface{}
+	problems []string
+}
+
+func (v *openAPIValidator) fail(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func object(value interface{}) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	return m
+}
+
+func nonEmptyString(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && s != ""
+}
+
+// validateOpenAPI checks a decoded document against the OpenAPI 3.0 rules for
+// the constructs the generator emits
+func validateOpenAPI(doc map[string]interface{}) []string {
+	v := &openAPIValidator{doc: doc}
+	if version, _ := doc["openapi"].(string); !openAPIVersion.MatchString(version) {
+		v.fail("openapi is %q, want 3.0.x", doc["openapi"])
+	}
+	info := object(doc["info"])
+	if !nonEmptyString(info["title"]) || !nonEmptyString(info["version"]) {
+		v.fail("info needs a title and version: %v", info)
+	}
+
+	schemes := object(object(doc["components"])["securitySchemes"])
+	for name, value := range schemes {
+		scheme := object(value)
+		switch scheme["type"] {
+		case "apiKey":
+			if !nonEmptyString(scheme["name"]) || !securitySchemeIn[fmt.Sprint(scheme["in"])] {
+				v.fail("security scheme %s: apiKey needs a name and a valid in", name)
+			}
+		case "http":
+			if !nonEmptyString(scheme["scheme"]) {
+				v.fail("security scheme %s: http needs a scheme", name)
+			}
+		default:
+			v.fail("security scheme %s has type %v", name, scheme["type"])
+		}
+	}
+	for name, schema := range object(object(doc["components"])["schemas"]) {
+		v.schema("components.schemas."+name, schema)
+	}
+
+	paths := object(doc["paths"])
+	if paths == nil {
+		v.fail("paths is missing")
+	}
+	operationIDs := make(map[string]string)
+	for path, item := range paths {
+		if !strings.HasPrefix(path, "/") {
+			v.fail("path %q doesn't start with /", path)
+		}
+		for method, value := range object(item) {
+			where := strings.ToUpper(method) + " " + path
+			if !openAPIMethods[method] {
+				v.fail("%s: %q is not an HTTP method", where, method)
+				continue
+			}
+			op := object(value)
+			id, _ := op["operationId"].(string)
+			if other, dup := operationIDs[id]; dup || id == "" {
+				v.fail("%s: operationId %q is empty or also used by %s", where, id, other)
+			}
+			operationIDs[id] = where
+			v.operation(where, path, op, schemes)
+		}
+	}
+	v.refs("#", doc)
+	return v.problems
+}
+
+// operation checks an operation's parameters, body, responses and security
+func (v *openAPIValidator) operation(where, path string, op map[string]interface{}, schemes map[string]interface{}) {
+	declared := make(map[string]bool)
+	params, _ := op["parameters"].([]interface{})
+	for _, value := range params {
+		param := object(value)
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		if name == "" || !parameterIn[in] {
+			v.fail("%s: parameter %v needs a name and a valid in", where, param)
+		}
+		if in == "path" {
+			declared[name] = true
+			if param["required"] != true {
+				v.fail("%s: path parameter %s must be required", where, name)
+			}
+		}
+		v.schema(where+" parameter "+name, param["schema"])
+	}
+	for _, m := range pathTemplate.FindAllStringSubmatch(path, -1) {
+		if !declared[m[1]] {
+			v.fail("%s: path parameter {%s} is not declared", where, m[1])
+		}
+		delete(declared, m[1])
+	}
+	for name := range declared {
+		v.fail("%s: path parameter %s is not in the path", where, name)
+	}
+
+	if body := object(op["requestBody"]); body != nil {
+		v.content(where+" requestBody", body["content"])
+	}
+	responses := object(op["responses"])
+	if len(responses) == 0 {
+		v.fail("%s: no responses", where)
+	}
+	for code, value := range responses {
+		response := object(value)
+		if !responseKey.MatchString(code) {
+			v.fail("%s: response key %q", where, code)
+		}
+		if _, ok := response["description"].(string); !ok {
+			v.fail("%s: response %s has no description", where, code)
+		}
+		if content, ok := response["content"]; ok {
+			v.content(where+" response "+code, content)
+		}
+	}
+
+	security, _ := op["security"].([]interface{})
+	for _, value := range security {
+		for name := range object(value) {
+			if _, ok := schemes[name]; !ok {
+				v.fail("%s: unknown security scheme %s", where, name)
+			}
+		}
+	}
+}
+
+// content checks a media type map
+func (v *openAPIValidator) content(where string, value interface{}) {
+	content := object(value)
+	if len(content) == 0 {
+		v.fail("%s: empty content", where)
+	}
+	for mediaType, media := range content {
+		if !strings.Contains(mediaType, "/") {
+			v.fail("%s: media type %q", where, mediaType)
+		}
+		v.schema(where+" "+mediaType, object(media)["schema"])
+	}
+}
+
+// schema checks a schema object and the schemas nested in it
+func (v *openAPIValidator) schema(where string, value interface{}) {
+	schema, ok := value.(map[string]interface{})
+	if !ok {
+		v.fail("%s: schema is %T, not an object", where, value)
+		return
+	}
+	if ref, ok := schema["$ref"]; ok {
+		if len(schema) != 1 || !nonEmptyString(ref) {
+			v.fail("%s: $ref must stand alone", where)
+		}
+		return
+	}
+	if t, ok := schema["type"]; ok && !schemaTypes[fmt.Sprint(t)] {
+		v.fail("%s: type %v", where, t)
+	}
+	if schema["type"] == "array" {
+		if _, ok := schema["items"]; !ok {
+			v.fail("%s: array without items", where)
+		}
+	}
+	if items, ok := schema["items"]; ok {
+		v.schema(where+"[]", items)
+	}
+	for name, prop := range object(schema["properties"]) {
+		v.schema(where+"."+name, prop)
+	}
+	if extra, ok := schema["additionalProperties"]; ok {
+		v.schema(where+"{}", extra)
+	}
+	if required, ok := schema["required"]; ok {
+		names, _ := required.([]interface{})
+		if len(names) == 0 {
+			v.fail("%s: required must be a non-empty list", where)
+		}
+		for _, name := range names {
+			if _, ok := object(schema["properties"])[fmt.Sprint(name)]; !ok {
+				v.fail("%s: required property %v is not defined", where, name)
+			}
+		}
+	}
+}
+
+// refs checks that every $ref in the document points at something in it
+func (v *openAPIValidator) refs(where string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if ref, ok := value["$ref"].(string); ok && v.resolve(ref) == nil {
+			v.fail("%s: $ref %s doesn't resolve", where, ref)
+		}
+		for key, child := range value {
+			v.refs(where+"/"+key, child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			v.refs(fmt.Sprintf("%s/%d", where, i), child)
+		}
+	}
+}
+
+// resolve follows a local JSON pointer
+func (v *openAPIValidator) resolve(ref string) interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var node interface{} = v.doc
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		node = object(node)[part]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// fetchOpenAPI returns the document the server serves
+func fetchOpenAPI(t *testing.T, s *Server) map[string]interface{} {
+	t.Helper()
+	rec := s.serve(httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/openapi.json: status %d", rec.Code)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("document is not JSON: %v", err)
+	}
+	return doc
+}
+
+func TestOpenAPIDocumentIsValid(t *testing.T) {
+	s := newTestServer(t, nil)
+	doc := fetchOpenAPI(t, s)
+	for _, problem := range validateOpenAPI(doc) {
+		t.Error(problem)
+	}
+}
+
+func TestOpenAPIDocumentCoversRoutes(t *testing.T) {
+	s := newTestServer(t, nil)
+	paths := object(fetchOpenAPI(t, s)["paths"])
+	want := map[string][]string{
+		"/upload":           {"post"},
+		"/files/{path}":     {"get", "delete"},
+		"/api/files":        {"get"},
+		"/api/login":        {"post"},
+		"/health":           {"get"},
+		"/api/version":      {"get"},
+		"/api/openapi.json": {"get"},
+	}
+	for path, methods := range want {
+		for _, method := range methods {
+			if object(paths[path])[method] == nil {
+				t.Errorf("%s %s is not described", strings.ToUpper(method), path)
+			}
+		}
+	}
+	admin := 0
+	for path := range paths {
+		if strings.HasPrefix(path, "/api/admin/") {
+			admin++
+		}
+	}
+	if admin == 0 {
+		t.Error("no admin routes are described")
+	}
+}
+
+func TestOpenAPIValidatorCatchesProblems(t *testing.T) {
+	doc := map[string]interface{}{
+		"openapi": "2.0",
+		"info":    map[string]interface{}{"title": "x"},
+		"paths": map[string]interface{}{
+			"/files/{path}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"operationId": "get_files",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "OK",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{"$ref": "#/components/schemas/Missing"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if problems := validateOpenAPI(doc); len(problems) < 4 {
+		t.Errorf("found %d problems, want at least 4 (version, info.version, path parameter, $ref): %v", len(problems), problems)
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.SetVersion("1.2.3")
+	s.SetBuildInfo("2024-01-02T03:04:05Z", "abc1234")
+	rec := s.serve(httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+	var info versionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("status %d, body is not JSON: %v", rec.Code, err)
+	}
+	if info.Version != "1.2.3" || info.BuildTime != "2024-01-02T03:04:05Z" || info.GitCommit != "abc1234" || info.GoVersion == "" {
+		t.Errorf("version info = %+v", info)
+	}
+	if doc := fetchOpenAPI(t, s); object(doc["info"])["version"] != "1.2.3" {
+		t.Errorf("OpenAPI info.version = %v, want the server version", object(doc["info"])["version"])
+	}
+}
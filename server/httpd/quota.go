@@ -0,0 +1,77 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// uploadQuota is a rolling 24h upload allowance for one usage subject: the API
+// key or the client IP. A limit of 0 or less means unlimited.
+type uploadQuota struct {
+	subject string
+	limit   int64
+}
+
+// uploadQuotas returns the quotas an upload counts against. Scoped tokens may
+// override the global quota; the legacy API key and client IPs use it as is.
+func (s *Server) uploadQuotas(r *http.Request) []uploadQuota {
+	global := s.cfg().Security.UploadQuotaPerDayBytes
+
+	quotas := []uploadQuota{{subject: "ip:" + getRemoteIP(r), limit: global}}
+
+	key := r.Header.Get(APIKeyHeader)
+	if s.isLegacyAPIKey(key) {
+		quotas = append(quotas, uploadQuota{subject: "key:api-key", limit: global})
+	} else if token := s.db.LookupAPIToken(key); token != nil {
+		limit := global
+		if token.QuotaPerDayBytes != 0 {
+			limit = token.QuotaPerDayBytes
+		}
+		quotas = append(quotas, uploadQuota{subject: "key:" + token.ID, limit: limit})
+	}
+	return quotas
+}
+
+// checkQuotas rejects an upload of size bytes that would take any subject past
+// its quota, reporting the usage and when it starts to free up
+func (s *Server) checkQuotas(w http.ResponseWriter, quotas []uploadQuota, size int64) bool {
+	for _, q := range quotas {
+		if q.limit <= 0 {
+			continue
+		}
+		usage := s.db.GetUsage(q.subject)
+		// An empty probe only fails once the quota is used up
+		if (size == 0 && usage.Bytes < q.limit) || (size > 0 && usage.Bytes+size <= q.limit) {
+			continue
+		}
+
+		response := map[string]interface{}{
+			"success":     false,
+			"code":        CodeQuotaExceeded,
+			"message":     fmt.Sprintf("Upload quota exceeded for %s: %d of %d bytes used in the last 24 hours", q.subject, usage.Bytes, q.limit),
+			"subject":     q.subject,
+			"quota_bytes": q.limit,
+			"used_bytes":  usage.Bytes,
+			"used_count":  usage.Count,
+		}
+		if !usage.ResetAt.IsZero() {
+			response["reset_at"] = usage.ResetAt.Format(time.RFC3339)
+			if wait := int(time.Until(usage.ResetAt).Seconds()) + 1; wait > 0 {
+				w.Header().Set("Retry-After", fmt.Sprint(wait))
+			}
+		}
+		s.writeJSON(w, http.StatusTooManyRequests, response)
+		return false
+	}
+	return true
+}
+
+// recordUpload adds a completed upload to the usage of every subject
+func (s *Server) recordUpload(quotas []uploadQuota, size int64) {
+	subjects := make([]string, 0, len(quotas))
+	for _, q := range quotas {
+		subjects = append(subjects, q.subject)
+	}
+	s.db.RecordUpload(subjects, size)
+}
@@ -0,0 +1,62 @@
+package httpd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"httpserver/server/db"
+)
+
+type remoteIPKey struct{}
+
+// getRemoteIP returns the client address of a request, as resolved by
+// withRemoteIP: a normalized IP without a port
+func getRemoteIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(remoteIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the address of the connection's other end
+func peerIP(r *http.Request) string {
+	if ip := db.NormalizeIP(r.RemoteAddr); ip != "" {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// withRemoteIP resolves each request's client address once, for getRemoteIP.
+// X-Forwarded-For is only believed from security.trusted_proxies: its entries
+// are read from the right, skipping further trusted proxies, and the first
+// other address is the client. Anyone else gets the connection's address.
+func (s *Server) withRemoteIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := peerIP(r)
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 && s.isTrustedProxy(r) {
+			if client := s.forwardedClient(strings.Join(forwarded, ",")); client != "" {
+				ip = client
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), remoteIPKey{}, ip)))
+	})
+}
+
+// forwardedClient picks the client out of an X-Forwarded-For chain sent by a
+// trusted proxy, or returns "" if the chain holds no usable address
+func (s *Server) forwardedClient(chain string) string {
+	trusted := s.cfg().Security.TrustedProxies
+	hops := strings.Split(chain, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := db.NormalizeIP(hops[i])
+		if ip == "" {
+			return ""
+		}
+		if i == 0 || !ipInList(net.ParseIP(ip), trusted) {
+			return ip
+		}
+	}
+	return ""
+}
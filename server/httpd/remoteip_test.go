@@ -0,0 +1,96 @@
+package httpd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"httpserver/server/config"
+)
+
+// proxyChain trusts a load balancer network and a local proxy
+func proxyChain(cfg *config.Config) {
+	cfg.Security.TrustedProxies = []string{"10.0.0.0/8", "127.0.0.1", "2001:db8:ffff::/48"}
+}
+
+func TestForwardedClient(t *testing.T) {
+	s := newTestServer(t, proxyChain)
+
+	tests := []struct {
+		name  string
+		chain string
+		want  string
+	}{
+		{"single client", "198.51.100.7", "198.51.100.7"},
+		{"spaces", " 198.51.100.7 ", "198.51.100.7"},
+		{"client with port", "198.51.100.7:4711", "198.51.100.7"},
+		{"rightmost untrusted wins", "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{"skips trusted proxies", "198.51.100.7, 10.1.2.3, 127.0.0.1", "198.51.100.7"},
+		{"spoofed left entries ignored", "1.1.1.1, 198.51.100.7, 10.1.2.3", "198.51.100.7"},
+		{"all trusted gives the leftmost", "10.9.9.9, 10.1.2.3", "10.9.9.9"},
+		{"ipv6 client", "2001:db8::7, 10.1.2.3", "2001:db8::7"},
+		{"ipv6 client in brackets", "[2001:db8::7]:4711, 10.1.2.3", "2001:db8::7"},
+		{"ipv6 proxies skipped", "198.51.100.7, 2001:db8:ffff::2", "198.51.100.7"},
+		{"ipv4-mapped client", "::ffff:198.51.100.7, 10.1.2.3", "198.51.100.7"},
+		{"ipv4-mapped proxy skipped", "198.51.100.7, ::ffff:10.1.2.3", "198.51.100.7"},
+		{"garbage after the client", "198.51.100.7, unknown", ""},
+		{"garbage behind the client", "unknown, 198.51.100.7", "198.51.100.7"},
+		{"garbage behind trusted proxies", "unknown, 10.1.2.3", ""},
+		{"empty hop", "198.51.100.7,, 10.1.2.3", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := s.forwardedClient(tt.chain); got != tt.want {
+			t.Errorf("%s: forwardedClient(%q) = %q, want %q", tt.name, tt.chain, got, tt.want)
+		}
+	}
+}
+
+func TestWithRemoteIP(t *testing.T) {
+	s := newTestServer(t, proxyChain)
+	var got string
+	handler := s.withRemoteIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = getRemoteIP(r)
+	}))
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		want      string
+	}{
+		{name: "direct client", peer: testClientAddr, want: "192.0.2.1"},
+		{name: "direct ipv6 client", peer: "[2001:db8::5%eth0]:1234", want: "2001:db8::5"},
+		{name: "untrusted peer can't forward", peer: testClientAddr, forwarded: []string{"198.51.100.7"}, want: "192.0.2.1"},
+		{name: "trusted proxy", peer: "10.0.0.2:80", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "trusted proxy chain", peer: "127.0.0.1:80", forwarded: []string{"203.0.113.9, 198.51.100.7, 10.1.2.3"}, want: "198.51.100.7"},
+		{name: "repeated headers joined", peer: "10.0.0.2:80", forwarded: []string{"198.51.100.7", "10.1.2.3"}, want: "198.51.100.7"},
+		{name: "unusable chain keeps the peer", peer: "10.0.0.2:80", forwarded: []string{"unknown"}, want: "10.0.0.2"},
+		{name: "trusted proxy without header", peer: "10.0.0.2:80", want: "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.peer
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		got = ""
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if got != tt.want {
+			t.Errorf("%s: remote IP %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetRemoteIPWithoutMiddleware(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := getRemoteIP(r); got != "192.0.2.1" {
+		t.Errorf("getRemoteIP = %q, want the peer address", got)
+	}
+	r.RemoteAddr = "not an address"
+	if got := getRemoteIP(r); got != "not an address" {
+		t.Errorf("getRemoteIP = %q, want the raw RemoteAddr", got)
+	}
+}
@@ -0,0 +1,183 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"httpserver/server/cleanup"
+	"httpserver/server/db"
+	"httpserver/server/logging"
+	"httpserver/server/replication"
+	"httpserver/server/storage"
+)
+
+// handleReplication receives changes pushed by a primary server's replicator.
+// Callers need an API token with the replicate scope.
+func (s *Server) handleReplication(w http.ResponseWriter, r *http.Request) {
+	if !s.requireScope(w, r, db.ScopeReplicate) {
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/replication") {
+	case "/files":
+		switch r.Method {
+		case http.MethodPost:
+			s.handleReplicatedUpload(w, r)
+		case http.MethodDelete:
+			s.handleReplicatedDelete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/expiry":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleReplicatedExpiry(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// validReplicatedPath reports whether a path sent by the primary is safe to store
+func validReplicatedPath(filePath string) bool {
+	return filePath != "" && !strings.Contains(filePath, "..") && !strings.HasPrefix(filePath, "/") && !isReservedPath(filePath)
+}
+
+// handleReplicatedUpload stores a file under the path the primary generated,
+// keeping its original metadata and replacing any earlier copy
+func (s *Server) handleReplicatedUpload(w http.ResponseWriter, r *http.Request) {
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+
+	form, status, err := s.readUploadForm(w, r)
+	if err != nil {
+		s.writeJSONError(w, status, codeForStatus(status), err.Error())
+		return
+	}
+	defer form.discard()
+
+	var record replication.FileRecord
+	if err := json.Unmarshal([]byte(form.value("metadata")), &record); err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid metadata")
+		return
+	}
+	if !validReplicatedPath(record.FilePath) {
+		s.writeJSONError(w, http.StatusBadRequest, CodeInvalidPath, "Invalid file path")
+		return
+	}
+	if record.Hash != "" && record.Hash != form.hash {
+		s.writeJSONError(w, http.StatusBadRequest, CodeHashMismatch, "File content does not match the replicated hash")
+		return
+	}
+
+	moved, err := storage.PutFile(s.store, form.tempPath, record.FilePath)
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save file: %v", err))
+		return
+	}
+	if moved {
+		form.tempPath = ""
+	}
+
+	if err := s.db.DeleteFileMetadata(record.FilePath); err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save metadata: %v", err))
+		return
+	}
+	meta := &db.FileMetadata{
+		FileName:      filepath.Base(record.FilePath),
+		OriginalName:  record.OriginalName,
+		FilePath:      record.FilePath,
+		FileSize:      form.size,
+		UploadedAt:    record.UploadedAt,
+		ExpiresAt:     record.ExpiresAt,
+		TTL:           record.TTL,
+		RemoteIP:      record.RemoteIP,
+		Slug:          record.Slug,
+		Tag:           record.Tag,
+		Hash:          form.hash,
+		RetentionRule: record.RetentionRule,
+	}
+	err = s.db.SaveFileMetadata(meta)
+	if errors.Is(err, db.ErrSlugTaken) {
+		// A local file owns the slug; keep the file reachable by path
+		meta.Slug = ""
+		err = s.db.SaveFileMetadata(meta)
+	}
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save metadata: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"message":   "File replicated",
+		"file_path": record.FilePath,
+	})
+	logging.Info("Replicated file stored", logging.Fields{"path": record.FilePath, "size": form.size, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+// handleReplicatedDelete deletes a file the primary deleted, honouring the local
+// trash retention
+func (s *Server) handleReplicatedDelete(w http.ResponseWriter, r *http.Request) {
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+
+	filePath := r.URL.Query().Get("path")
+	if !validReplicatedPath(filePath) {
+		s.writeJSONError(w, http.StatusBadRequest, CodeInvalidPath, "Invalid file path")
+		return
+	}
+
+	meta, _ := s.db.GetFileMetadata(filePath)
+	if meta == nil {
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+	if err := cleanup.DeleteFile(s.db, s.store, meta, s.cfg().Storage.TrashRetentionHours); err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to delete file: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "File deleted successfully",
+	})
+	logging.Info("Replicated file deleted", logging.Fields{"path": filePath, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+// handleReplicatedExpiry applies a TTL change made on the primary
+func (s *Server) handleReplicatedExpiry(w http.ResponseWriter, r *http.Request) {
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+
+	var change replication.ExpiryChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil || !validReplicatedPath(change.FilePath) {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+		return
+	}
+
+	meta, err := s.db.SetFileExpiry(change.FilePath, change.TTL, change.ExpiresAt)
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save metadata: %v", err))
+		return
+	}
+	if meta == nil {
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"message":    "Expiry updated",
+		"expires_at": meta.ExpiresAt,
+	})
+	logging.Info("Replicated expiry change", logging.Fields{"path": change.FilePath, "ttl_hours": change.TTL, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
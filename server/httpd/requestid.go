@@ -0,0 +1,92 @@
+package httpd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"httpserver/server/logging"
+)
+
+// RequestIDHeader carries the request ID on responses, and on requests from a
+// trusted proxy that has already assigned one
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs accepted from a proxy
+const maxRequestIDLength = 64
+
+type requestIDKey struct{}
+
+// RequestID returns the ID assigned to a request by the server's middleware
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a short random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether an incoming ID is safe to echo and log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isTrustedProxy reports whether the request comes directly from one of
+// security.trusted_proxies
+func (s *Server) isTrustedProxy(r *http.Request) bool {
+	return ipInList(net.ParseIP(peerIP(r)), s.cfg().Security.TrustedProxies)
+}
+
+// withRequestID tags every request with an ID, kept from a trusted proxy's
+// X-Request-ID header or generated, and returns it in the response header.
+// With logging.access_log set, each request is logged with its ID.
+func (s *Server) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) || !s.isTrustedProxy(r) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		if !s.cfg().Logging.AccessLog {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logging.Info("Request", logging.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     status,
+			"duration":   time.Since(start),
+			"ip":         getRemoteIP(r),
+			"request_id": id,
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package httpd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var generatedID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+func TestRequestIDHeader(t *testing.T) {
+	s := newTestServer(t, nil)
+	tests := []struct {
+		name     string
+		peer     string
+		incoming string
+		echoed   bool
+	}{
+		{"absent", testClientAddr, "", false},
+		{"from a trusted proxy", "127.0.0.1:5000", "proxy-id.42_x", true},
+		{"from a trusted IPv6 proxy", "[::1]:5000", "abc-123", true},
+		{"from an untrusted client", testClientAddr, "client-chosen", false},
+		{"malformed from a trusted proxy", "127.0.0.1:5000", "bad id\r\n", false},
+		{"too long from a trusted proxy", "127.0.0.1:5000", string(make([]byte, maxRequestIDLength+1)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := s.withRemoteIP(s.withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestID(r)
+			})))
+			r := httptest.NewRequest(http.MethodGet, "/health", nil)
+			r.RemoteAddr = tt.peer
+			if tt.incoming != "" {
+				r.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			got := rec.Header().Get(RequestIDHeader)
+			if tt.echoed && got != tt.incoming {
+				t.Errorf("X-Request-ID = %q, want the incoming %q", got, tt.incoming)
+			}
+			if !tt.echoed && !generatedID.MatchString(got) {
+				t.Errorf("X-Request-ID = %q, want a generated ID", got)
+			}
+			if seen != got {
+				t.Errorf("handler saw request ID %q, response carries %q", seen, got)
+			}
+		})
+	}
+}
+
+func TestRequestIDsDiffer(t *testing.T) {
+	s := newTestServer(t, nil)
+	first := s.serve(httptest.NewRequest(http.MethodGet, "/health", nil)).Header().Get(RequestIDHeader)
+	second := s.serve(httptest.NewRequest(http.MethodGet, "/health", nil)).Header().Get(RequestIDHeader)
+	if first == "" || first == second {
+		t.Errorf("two requests got IDs %q and %q", first, second)
+	}
+}
+
+func TestErrorBodyCarriesRequestID(t *testing.T) {
+	s := newTestServer(t, nil)
+	r := httptest.NewRequest(http.MethodGet, "/files/20240101/missing.png", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set(RequestIDHeader, "trace-1")
+	r.Header.Set("Accept", "application/json")
+	rec := s.serve(r)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("status %d, body %q is not JSON: %v", rec.Code, rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusNotFound || body["request_id"] != "trace-1" {
+		t.Errorf("status %d, body %v; want 404 with request_id trace-1", rec.Code, body)
+	}
+}
@@ -0,0 +1,272 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+	"httpserver/server/resumable"
+)
+
+// ChunkSHA256Header carries the hex sha256 of a chunk's body
+const ChunkSHA256Header = "X-Chunk-SHA256"
+
+// resumableInit is the body of POST /upload/init
+type resumableInit struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	ChunkSize int64  `json:"chunk_size"` // Optional; the server picks when 0
+	TTL       string `json:"ttl"`
+	Slug      string `json:"slug"`
+	Tag       string `json:"tag"`
+}
+
+// handleResumable serves chunked uploads for large files over unreliable links:
+// POST /upload/init starts one, PUT /upload/chunk/{id}?index=n sends its chunks
+// in order, POST /upload/complete/{id} stores the file like a regular upload.
+// GET /upload/{id} reports how far an upload got, so a client can resume it,
+// and DELETE /upload/{id} abandons it.
+func (s *Server) handleResumable(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/upload/")
+	switch {
+	case rest == "init":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleResumableInit(w, r)
+	case strings.HasPrefix(rest, "chunk/"):
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleResumableChunk(w, r, strings.TrimPrefix(rest, "chunk/"))
+	case strings.HasPrefix(rest, "complete/"):
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleResumableComplete(w, r, strings.TrimPrefix(rest, "complete/"))
+	case rest != "" && !strings.Contains(rest, "/"):
+		switch r.Method {
+		case http.MethodGet:
+			if upload := s.ownUpload(w, r, rest, db.ScopeUpload); upload != nil {
+				s.writeJSON(w, http.StatusOK, resumableStatus(upload))
+			}
+		case http.MethodDelete:
+			if upload := s.ownUpload(w, r, rest, db.ScopeUpload); upload != nil {
+				s.resumables.Remove(upload.ID)
+				s.writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": "Upload abandoned"})
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "Not found")
+	}
+}
+
+// handleResumableInit validates a chunked upload up front, so a client learns
+// about a bad TTL or a full quota before sending gigabytes
+func (s *Server) handleResumableInit(w http.ResponseWriter, r *http.Request) {
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+	if !s.requireScope(w, r, db.ScopeUpload) {
+		return
+	}
+
+	var req resumableInit
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFieldSize*4)).Decode(&req); err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	req.SHA256 = strings.ToLower(req.SHA256)
+	switch {
+	case req.Size <= 0:
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "size must be positive")
+		return
+	case len(req.SHA256) != 64 || strings.Trim(req.SHA256, "0123456789abcdef") != "":
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "sha256 must be the hex SHA-256 of the file")
+		return
+	case req.ChunkSize != 0 && (req.ChunkSize < resumable.MinChunkSize || req.ChunkSize > resumable.MaxChunkSize):
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("chunk_size must be between %s and %s", formatBytes(resumable.MinChunkSize), formatBytes(resumable.MaxChunkSize)))
+		return
+	}
+	if maxSize := s.cfg().Storage.MaxFileSize; maxSize > 0 && req.Size > maxSize {
+		s.writeJSONError(w, http.StatusRequestEntityTooLarge, CodeFileTooLarge, fmt.Sprintf("File exceeds the maximum size of %s", formatBytes(maxSize)))
+		return
+	}
+	if !s.checkQuotas(w, s.uploadQuotas(r), req.Size) {
+		return
+	}
+
+	name := filepath.Base(strings.ReplaceAll(req.Name, "\\", "/"))
+	if name == "." || name == "/" {
+		name = ""
+	}
+	fields := map[string]string{}
+	for key, value := range map[string]string{"ttl": req.TTL, "slug": req.Slug, "tag": req.Tag} {
+		if value = strings.TrimSpace(value); value != "" {
+			fields[key] = value
+		}
+	}
+	if _, ok := s.parseUploadParams(w, r, fields, name, req.Size); !ok {
+		return
+	}
+
+	chunkSize := req.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = resumable.DefaultChunkSize
+	}
+	upload := &resumable.Upload{
+		FileName:  name,
+		Size:      req.Size,
+		SHA256:    req.SHA256,
+		ChunkSize: chunkSize,
+		Fields:    fields,
+		Owner:     s.tokenIdentity(r.Header.Get(APIKeyHeader)),
+	}
+	if err := s.resumables.Create(upload); err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to start upload: %v", err))
+		return
+	}
+
+	logging.Info("Resumable upload started", logging.Fields{"upload_id": upload.ID, "original": name, "size": req.Size, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	s.writeJSON(w, http.StatusOK, resumableStatus(upload))
+}
+
+// handleResumableChunk appends one chunk, checked against X-Chunk-SHA256
+func (s *Server) handleResumableChunk(w http.ResponseWriter, r *http.Request, id string) {
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+	upload := s.ownUpload(w, r, id, db.ScopeUpload)
+	if upload == nil {
+		return
+	}
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "index must be a chunk number from 0")
+		return
+	}
+	checksum := r.Header.Get(ChunkSHA256Header)
+	if checksum == "" {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, ChunkSHA256Header+" header is required")
+		return
+	}
+
+	if !s.acquireSlot(w, r, s.uploads, "uploads") {
+		return
+	}
+	defer s.uploads.release()
+
+	upload, err = s.resumables.Append(id, index, http.MaxBytesReader(w, r.Body, upload.ChunkSize+1), checksum)
+	switch {
+	case errors.Is(err, resumable.ErrChecksumMismatch):
+		s.writeJSONError(w, http.StatusBadRequest, CodeHashMismatch, fmt.Sprintf("Chunk %d doesn't match its %s", index, ChunkSHA256Header))
+	case errors.Is(err, resumable.ErrChunkOutOfOrder):
+		s.writeJSONError(w, http.StatusConflict, CodeConflict, fmt.Sprintf("Expected chunk %d", upload.NextIndex()))
+	case errors.Is(err, resumable.ErrChunkSize):
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Chunk %d has the wrong size", index))
+	case errors.Is(err, resumable.ErrNotFound):
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "Upload not found")
+	case err != nil:
+		if r.Context().Err() != nil {
+			// The client went away mid-chunk; it resumes from the last whole one
+			return
+		}
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save chunk: %v", err))
+	default:
+		s.writeJSON(w, http.StatusOK, resumableStatus(upload))
+	}
+}
+
+// handleResumableComplete checks the whole file against the hash declared at
+// init and stores it like a regular upload
+func (s *Server) handleResumableComplete(w http.ResponseWriter, r *http.Request, id string) {
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+	if s.ownUpload(w, r, id, db.ScopeUpload) == nil {
+		return
+	}
+
+	upload, hash, err := s.resumables.Finish(id)
+	switch {
+	case errors.Is(err, resumable.ErrIncomplete):
+		s.writeJSONError(w, http.StatusConflict, CodeConflict, fmt.Sprintf("Upload is incomplete: %d of %d bytes received", upload.Received, upload.Size))
+		return
+	case err != nil:
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to complete upload: %v", err))
+		return
+	}
+	if hash != upload.SHA256 {
+		// The chunks were fine but don't add up to the file announced; start over
+		s.resumables.Remove(id)
+		s.writeJSONError(w, http.StatusBadRequest, CodeHashMismatch, "Received file doesn't match the sha256 given at init")
+		return
+	}
+
+	quotas := s.uploadQuotas(r)
+	if !s.checkQuotas(w, quotas, upload.Size) {
+		return
+	}
+	params, ok := s.parseUploadParams(w, r, upload.Fields, upload.FileName, upload.Size)
+	if !ok {
+		return
+	}
+
+	form := &uploadForm{
+		fields:   upload.Fields,
+		fileName: upload.FileName,
+		tempPath: s.resumables.DataPath(id),
+		size:     upload.Size,
+		hash:     hash,
+	}
+	// Once the data has left the store, or the file is stored, the upload is done
+	if s.storeUpload(w, r, form, params, quotas) || form.tempPath == "" {
+		s.resumables.Remove(id)
+	}
+}
+
+// ownUpload returns a resumable upload if the request's API key started it,
+// writing the rejection otherwise. Another key gets the same 404 as an unknown
+// upload.
+func (s *Server) ownUpload(w http.ResponseWriter, r *http.Request, id, scope string) *resumable.Upload {
+	if !s.requireScope(w, r, scope) {
+		return nil
+	}
+	upload, err := s.resumables.Get(id)
+	if err != nil && !errors.Is(err, resumable.ErrNotFound) {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return nil
+	}
+	if upload == nil || upload.Owner != s.tokenIdentity(r.Header.Get(APIKeyHeader)) {
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "Upload not found")
+		return nil
+	}
+	return upload
+}
+
+// resumableStatus is the response describing an upload in progress
+func resumableStatus(upload *resumable.Upload) map[string]interface{} {
+	return map[string]interface{}{
+		"success":    true,
+		"upload_id":  upload.ID,
+		"size":       upload.Size,
+		"chunk_size": upload.ChunkSize,
+		"chunks":     (upload.Size + upload.ChunkSize - 1) / upload.ChunkSize,
+		"next_index": upload.NextIndex(),
+		"received":   upload.Received,
+		"complete":   upload.Complete(),
+		"updated_at": upload.UpdatedAt,
+	}
+}
@@ -1,34 +1,75 @@
 package httpd
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"httpserver/server/backup"
+	"httpserver/server/cleanup"
 	"httpserver/server/config"
 	"httpserver/server/db"
+	"httpserver/server/events"
+	"httpserver/server/logging"
 	"httpserver/server/naming"
+	"httpserver/server/notify"
+	"httpserver/server/replication"
+	"httpserver/server/resumable"
+	"httpserver/server/storage"
+	"httpserver/server/throttle"
 )
 
 // Server represents the HTTP server
 type Server struct {
-	cfg         *config.Config
-	db          *db.Database
-	server      *http.Server
-	sessions    map[string]time.Time // session token -> expiry
-	sessionMux  sync.RWMutex
+	cfgValue     atomic.Value // *config.Config, replaced wholesale on live config changes
+	cfgMux       sync.Mutex   // Serializes live config changes
+	controlToken string
+	db           *db.Database
+	store        storage.Backend
+	server       *http.Server
+	notifier     *notify.Notifier
+	replicator   *replication.Replicator
+	events       *events.Hub // Live activity for /api/admin/events
+	closing      chan struct{} // Closed by Shutdown, ending event streams
+	closeOnce    sync.Once
+	cleanupMgr   *cleanup.CleanupManager
+	version      string
+	buildTime    string
+	gitCommit    string
+	apiOps       []apiOperation // Registered routes, for /api/openapi.json
+	onReady      func() // Called once the listener is up
+	listeners    []net.Listener
+	httpListener net.Listener // Plain HTTP next to HTTPS, for ACME challenges
+	httpServer   *http.Server
+	startedAt    time.Time
+	sessions     map[string]*session // session token -> session
+	sessionMux   sync.RWMutex
+	totpMux      sync.Mutex // Serializes two-factor enrollment and recovery code use
+	failures     map[string]*failureWindow // client IP -> recent 401/429 count
+	failureMux   sync.Mutex
+	uploads      *semaphore
+	downloads    *semaphore
+	resumables   *resumable.Store // Chunked uploads in progress
+	idempotent   sync.Map         // Idempotency-Keys of uploads in progress
+	listings     *listingCache    // Serialized file listings of recent dates
+	emptySecrets sync.Map         // Config keys of empty secrets already warned about
+
+	downloadLimiter *throttle.Limiter // Global download cap, nil when unlimited
 }
 
 // NewServer creates a new HTTP server
@@ -36,39 +77,195 @@ func NewServer(cfg *config.Config, database *db.Database) *Server {
 	mux := http.NewServeMux()
 
 	s := &Server{
-		cfg:      cfg,
-		db:       database,
-		sessions: make(map[string]time.Time),
-	}
-
-	// Register routes
-	mux.HandleFunc("/upload", s.handleUpload)
-	mux.HandleFunc("/files/", s.handleFiles)
-	mux.HandleFunc("/api/files", s.handleAPIFiles)
-	mux.HandleFunc("/api/login", s.handleLogin)
-	mux.HandleFunc("/api/admin/", s.handleAdminAPI)
-	mux.HandleFunc("/list.html", s.handleListPage)
-	mux.HandleFunc("/manager.html", s.handleManagerPage)
-	mux.HandleFunc("/health", s.handleHealth)
+		db:        database,
+		store:     storage.NewLocal(cfg.Storage.ImagesDir),
+		sessions:  make(map[string]*session),
+		failures:  make(map[string]*failureWindow),
+		version:   "dev",
+		startedAt: time.Now(),
+		uploads:   newSemaphore(cfg.Server.MaxConcurrentUploads),
+		downloads: newSemaphore(cfg.Server.MaxConcurrentDownloads),
+		resumables: resumable.NewStore(filepath.Join(cfg.Storage.ImagesDir, cleanup.UploadTempDir, resumable.DirName)),
+		listings:   newListingCache(),
+		closing:    make(chan struct{}),
+	}
+	s.cfgValue.Store(cfg)
+	if cfg.Storage.GlobalDownloadRateLimitKbps > 0 {
+		s.downloadLimiter = throttle.NewLimiter(kbpsToBytes(cfg.Storage.GlobalDownloadRateLimitKbps))
+	}
+
+	// Register routes along with their API descriptions (see apiroutes.go)
+	s.handle(mux, "/upload", s.handleUpload, uploadAPI...)
+	s.handle(mux, "/upload/", s.handleResumable, resumableAPI...)
+	s.handle(mux, "/upload/check", s.handleUploadCheck, uploadCheckAPI...)
+	s.handle(mux, "/files/", s.handleFiles, filesAPI...)
+	s.handle(mux, "/s/", s.handleSlug, slugAPI...)
+	s.handle(mux, "/api/files", s.handleAPIFiles, fileListAPI...)
+	s.handle(mux, "/api/files/archive", s.handleAPIArchive, archiveAPI...)
+	s.handle(mux, "/api/files/recent", s.handleAPIRecent, recentAPI...)
+	s.handle(mux, "/api/files/", s.handleAPIFileInfo, fileInfoAPI...)
+	s.handle(mux, "/api/tags", s.handleAPITags, tagsAPI...)
+	s.handle(mux, "/api/login", s.handleLogin, loginAPI...)
+	s.handle(mux, "/api/admin/", s.handleAdminAPI, adminAPI...)
+	s.handle(mux, "/api/replication/", s.handleReplication, replicationAPI...)
+	s.handle(mux, "/api/version", s.handleVersion, versionAPI...)
+	s.handle(mux, "/api/capabilities", s.handleCapabilities, capabilitiesAPI...)
+	s.handle(mux, "/api/openapi.json", s.handleOpenAPI, openAPIAPI...)
+	s.handle(mux, "/dav", s.handleDAV)
+	s.handle(mux, "/dav/", s.handleDAV)
+	s.handle(mux, "/list.html", s.handleListPage)
+	s.handle(mux, "/manager.html", s.handleManagerPage)
+	s.handle(mux, "/health", s.handleHealth, healthAPI...)
+	s.handle(mux, "/robots.txt", s.handleRobots)
+	s.handle(mux, "/favicon.ico", s.handleFavicon)
 	// Register catch-all route for root and direct file access
-	mux.HandleFunc("/", s.handleCatchAll)
+	s.handle(mux, "/", s.handleCatchAll)
 
-	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 	s.server = &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Handler: s.withHSTS(s.withRemoteIP(s.withRequestID(s.guard(s.withCompression(mux))))),
 	}
 
 	// Start session cleanup goroutine
 	go s.cleanupSessions()
+	go s.pruneBans()
 
 	return s
 }
 
-// Start starts the HTTP server
+// cfg returns the current configuration. It must not be modified; live changes
+// swap in a new copy instead.
+func (s *Server) cfg() *config.Config {
+	return s.cfgValue.Load().(*config.Config)
+}
+
+// SetControlToken sets the token that lets local CLI commands call the admin API
+func (s *Server) SetControlToken(token string) {
+	s.controlToken = token
+}
+
+// SetNotifier sets the webhook notifier used to report uploads and deletes
+func (s *Server) SetNotifier(notifier *notify.Notifier) {
+	s.notifier = notifier
+}
+
+// SetReplicator sets the replicator mirroring uploads and deletes to a standby server
+func (s *Server) SetReplicator(replicator *replication.Replicator) {
+	s.replicator = replicator
+}
+
+// SetEvents sets the hub uploads and deletes are published to, and the admin
+// event stream subscribes to
+func (s *Server) SetEvents(hub *events.Hub) {
+	s.events = hub
+}
+
+// SetVersion sets the version reported by the health endpoint
+func (s *Server) SetVersion(version string) {
+	s.version = version
+}
+
+// SetBuildInfo sets the build time and git commit reported by /api/version
+func (s *Server) SetBuildInfo(buildTime, gitCommit string) {
+	s.buildTime = buildTime
+	s.gitCommit = gitCommit
+}
+
+// SetCleanupManager sets the cleanup manager used by the admin cleanup endpoint
+func (s *Server) SetCleanupManager(cleanupMgr *cleanup.CleanupManager) {
+	s.cleanupMgr = cleanupMgr
+}
+
+// SetStorage sets the backend holding file contents (the images directory by default)
+func (s *Server) SetStorage(store storage.Backend) {
+	s.store = store
+}
+
+// SetOnReady sets a function called once the server is accepting connections
+func (s *Server) SetOnReady(fn func()) {
+	s.onReady = fn
+}
+
+// Listen opens every configured listener without serving yet, so callers can
+// learn the bound addresses (e.g. the port picked for server.port 0) first.
+// Start calls it if it hasn't been called.
+func (s *Server) Listen() error {
+	if s.listeners != nil {
+		return nil
+	}
+	if domains := s.acmeDomains(); len(domains) > 0 {
+		return s.listenACME(domains)
+	}
+
+	addrs := s.cfg().ListenAddresses()
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := s.listen(addr)
+		if err != nil {
+			for _, open := range listeners {
+				open.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, ln)
+		logging.Info("Listening", logging.Fields{"addr": ln.Addr().Network() + "://" + ln.Addr().String()})
+	}
+	s.listeners = listeners
+	return nil
+}
+
+// Addr returns the address of the first listener, or nil before Listen
+func (s *Server) Addr() net.Addr {
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of all listeners
+func (s *Server) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// Start serves on every listener, opening them first if Listen wasn't called.
+// It returns when any of them fails.
 func (s *Server) Start() error {
-	log.Printf("Starting HTTP server on %s", s.server.Addr)
-	return s.server.ListenAndServe()
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	if s.onReady != nil {
+		s.onReady()
+	}
+
+	errs := make(chan error, len(s.listeners)+1)
+	for _, ln := range s.listeners {
+		go func(ln net.Listener) {
+			errs <- s.server.Serve(ln)
+		}(ln)
+	}
+	if s.httpListener != nil {
+		go func() {
+			errs <- s.httpServer.Serve(s.httpListener)
+		}()
+	}
+	return <-errs
+}
+
+// Shutdown stops accepting connections on every listener and waits for
+// requests in flight to finish, or for ctx to end. Start then returns
+// http.ErrServerClosed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	err := s.server.Shutdown(ctx)
+	if s.httpServer != nil {
+		if httpErr := s.httpServer.Shutdown(ctx); err == nil {
+			err = httpErr
+		}
+	}
+	return err
 }
 
 // handleUpload handles file upload requests
@@ -77,289 +274,1261 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+
+	if !s.requireScope(w, r, db.ScopeUpload) {
+		return
+	}
+
+	// A retry of an upload that already went through gets the first result,
+	// even when the uploader has since run out of quota
+	quotas := s.uploadQuotas(r)
+	remember := s.idempotencyWindow() > 0
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if remember && key != "" {
+		release, ok := s.startIdempotent(w, r, idempotencySubject(quotas), key)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	// Refuse uploaders already over quota before reading the body
+	if !s.checkQuotas(w, quotas, 0) {
+		return
+	}
+
+	// Bound concurrent uploads before reading any of the body
+	if !s.acquireSlot(w, r, s.uploads, "uploads") {
+		return
+	}
+	defer s.uploads.release()
+
+	// Stream the multipart body; the file lands in a temporary file until validated
+	form, status, err := s.readUploadForm(w, r)
+	if err != nil {
+		s.writeJSONError(w, status, codeForStatus(status), err.Error())
+		return
+	}
+	defer form.discard()
+	if remember && key == "" {
+		if key = form.fields["idempotency_key"]; key != "" {
+			release, ok := s.startIdempotent(w, r, idempotencySubject(quotas), key)
+			if !ok {
+				return
+			}
+			defer release()
+		}
+	}
+	if !s.checkQuotas(w, quotas, form.size) {
+		return
+	}
+
+	params, ok := s.parseUploadParams(w, r, form.fields, form.fileName, form.size)
+	if !ok {
+		return
+	}
+	if remember {
+		params.idempotencyKey = key
+	}
+	s.storeUpload(w, r, form, params, quotas)
+}
+
+// uploadParams holds the validated settings of an upload
+type uploadParams struct {
+	ttl          config.TTL
+	requestedTTL config.TTL // TTL asked for, before retention rules
+	rule         string     // Retention rule that applied, if any
+	slug         string
+	tag          string
+	idempotencyKey string // The result is remembered under this key when set
+}
+
+// parseUploadParams validates the TTL, slug and tag fields of an upload of the given
+// file, writing an error response when they are invalid
+func (s *Server) parseUploadParams(w http.ResponseWriter, r *http.Request, fields map[string]string, fileName string, size int64) (*uploadParams, bool) {
+	// Get TTL: hours, a duration like 45m or 14d, or "never"
+	var err error
+	ttl := s.cfg().Storage.DefaultTTL
+	if ttlStr := fields["ttl"]; ttlStr != "" {
+		ttl, err = config.ParseTTL(ttlStr)
+		if err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidTTL, err.Error())
+			return nil, false
+		}
+	}
+
+	// Validate TTL
+	storageCfg := s.cfg().Storage
+	if ttl == config.NeverExpires && !storageCfg.AllowPermanent {
+		s.writeJSONError(w, http.StatusBadRequest, CodeInvalidTTL, "Files that never expire are not allowed here (storage.allow_permanent is off); use hours (24) or a duration like 45m, 36h or 14d")
+		return nil, false
+	}
+	if ttl != config.NeverExpires && storageCfg.MaxTTL != config.NeverExpires && ttl > storageCfg.MaxTTL {
+		s.writeJSONError(w, http.StatusBadRequest, CodeInvalidTTL, fmt.Sprintf("TTL must be at most %s; use hours (24) or a duration like 45m, 36h or 14d", storageCfg.MaxTTL))
+		return nil, false
+	}
+
+	// Retention rules may cap or replace the requested TTL
+	requestedTTL := ttl
+	ttl, rule := config.ApplyRetention(storageCfg.RetentionRules, config.RetentionUpload{
+		FileName: fileName,
+		Size:     size,
+		IP:       net.ParseIP(getRemoteIP(r)),
+		APIKey:   s.tokenName(r.Header.Get(APIKeyHeader)),
+	}, ttl)
+	ruleName := ""
+	if rule != nil {
+		ruleName = rule.Name
+	}
+
+	// Validate optional slug
+	slug := fields["slug"]
+	if slug != "" {
+		if err := naming.ValidateSlug(slug); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidSlug, err.Error())
+			return nil, false
+		}
+		if existing, _ := s.db.GetFileMetadataBySlug(slug); existing != nil {
+			s.writeJSONError(w, http.StatusConflict, CodeSlugTaken, fmt.Sprintf("Slug '%s' is already in use", slug))
+			return nil, false
+		}
+	}
+
+	// Validate optional tag ("album" is accepted as an alias)
+	tag := fields["tag"]
+	if tag == "" {
+		tag = fields["album"]
+	}
+	if tag != "" {
+		if err := naming.ValidateTag(tag); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidTag, err.Error())
+			return nil, false
+		}
+	}
+
+	return &uploadParams{ttl: ttl, requestedTTL: requestedTTL, rule: ruleName, slug: slug, tag: tag}, true
+}
+
+// storeUpload moves a received file into storage, records its metadata and
+// writes the upload response. It reports whether the file was stored.
+func (s *Server) storeUpload(w http.ResponseWriter, r *http.Request, form *uploadForm, params *uploadParams, quotas []uploadQuota) bool {
+	ttl, slug, tag, ruleName := params.ttl, params.slug, params.tag, params.rule
+
+	// Generate file path
+	relativePath, err := s.generateFilePath(form.fileName)
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to generate file path: %v", err))
+		return false
+	}
+
+	// Move the received file into storage
+	moved, err := storage.PutFile(s.store, form.tempPath, relativePath)
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to save file: %v", err))
+		return false
+	}
+	if moved {
+		form.tempPath = ""
+	}
+	size := form.size
+	hash := form.hash
+
+	// Calculate expiry time; files that never expire have none
+	uploadedAt := time.Now()
+	var expiresAt time.Time
+	if ttl != config.NeverExpires {
+		expiresAt = uploadedAt.Add(ttl.Duration())
+	}
+
+	// Save metadata to database
+	metadata := &db.FileMetadata{
+		FileName:      filepath.Base(relativePath),
+		OriginalName:  form.fileName,
+		FilePath:      relativePath,
+		FileSize:      size,
+		UploadedAt:    uploadedAt,
+		ExpiresAt:     expiresAt,
+		TTL:           ttl.Hours(),
+		RemoteIP:      getRemoteIP(r),
+		Slug:          slug,
+		Tag:           tag,
+		Hash:          hash,
+		RetentionRule: ruleName,
+	}
+
+	if err := s.db.SaveFileMetadata(metadata); err != nil {
+		if errors.Is(err, db.ErrSlugTaken) {
+			// Lost a race with a concurrent upload claiming the same slug
+			s.store.Delete(relativePath)
+			s.writeJSONError(w, http.StatusConflict, CodeSlugTaken, fmt.Sprintf("Slug '%s' is already in use", slug))
+			return false
+		}
+		logging.Warn("Failed to save metadata", logging.Fields{"path": relativePath, "request_id": RequestID(r), "error": err})
+	}
+	s.recordUpload(quotas, size)
+
+	// Return success response
+	response := map[string]interface{}{
+		"success":     true,
+		"message":     "File uploaded successfully",
+		"file_path":   relativePath,
+		"download_url": fmt.Sprintf("/files/%s", relativePath),
+		"ttl":         ttl.String(),
+		"sha256":      hash,
+	}
+	if !expiresAt.IsZero() {
+		response["expires_at"] = expiresAt.Format(time.RFC3339)
+	}
+	if slug != "" {
+		response["slug"] = slug
+		response["slug_url"] = fmt.Sprintf("/s/%s", slug)
+	}
+	if tag != "" {
+		response["tag"] = tag
+	}
+	if ruleName != "" {
+		response["retention_rule"] = ruleName
+		response["requested_ttl"] = params.requestedTTL.String()
+	}
+
+	if params.idempotencyKey != "" {
+		s.rememberIdempotent(r, idempotencySubject(quotas), params.idempotencyKey, relativePath, response)
+	}
+	w.Header().Set("Location", fmt.Sprintf("/files/%s", relativePath))
+	s.writeJSON(w, http.StatusCreated, response)
+	s.notifier.NotifyFile(notify.EventUpload, metadata)
+	s.events.Publish(events.FileEvent(events.TypeUpload, metadata))
+	s.replicator.Enqueue(db.ReplicateUpload, relativePath)
+	logging.Info("File uploaded", logging.Fields{"path": relativePath, "original": form.fileName, "size": size, "ttl": ttl.String(), "retention_rule": ruleName, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	return true
+}
+
+// maxNameAttempts bounds how often a colliding generated name is replaced
+const maxNameAttempts = 5
+
+// generateFilePath picks a path for a new upload under the storage.path_layout
+// and naming settings. Short random components can collide, so a path that is
+// already stored or recorded is generated again.
+func (s *Server) generateFilePath(originalName string) (string, error) {
+	storageCfg := s.cfg().Storage
+	opts := naming.Options{
+		Layout:       storageCfg.PathLayout,
+		PreserveName: storageCfg.PreserveOriginalName,
+		EntropyBytes: storageCfg.NameEntropyBytes,
+		Style:        storageCfg.NameStyle,
+	}
+	for attempt := 1; attempt <= maxNameAttempts; attempt++ {
+		relativePath, err := naming.GenerateFilePath(originalName, opts)
+		if err != nil {
+			return "", err
+		}
+		meta, _ := s.db.GetFileMetadata(relativePath)
+		exists, err := s.store.Exists(relativePath)
+		if err != nil {
+			return "", err
+		}
+		if meta == nil && !exists {
+			return relativePath, nil
+		}
+		logging.Warn("Generated file name already taken", logging.Fields{"path": relativePath, "attempt": attempt})
+	}
+	return "", fmt.Errorf("no free name after %d attempts; raise storage.name_entropy_bytes", maxNameAttempts)
+}
+
+// handleFiles handles file download and delete requests
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	s.setRobotsTag(w)
+	if r.Method == http.MethodDelete {
+		s.handleDeleteFile(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract file path from URL (the catch-all passes /YYYYMMDD/... without /files)
+	filePath := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/files/"), "/")
+	if filePath == "" {
+		s.writeError(w, r, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+
+	// /files/s/{slug} is an alias for /s/{slug}
+	if strings.HasPrefix(filePath, "s/") {
+		s.serveSlug(w, r, strings.TrimPrefix(filePath, "s/"))
+		return
+	}
+
+	s.serveFile(w, r, filePath)
+}
+
+// handleDeleteFile handles DELETE /files/{path}
+func (s *Server) handleDeleteFile(w http.ResponseWriter, r *http.Request) {
+	if !s.requireScope(w, r, db.ScopeDelete) {
+		return
+	}
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+
+	filePath := strings.TrimPrefix(r.URL.Path, "/files/")
+	if filePath == "" || strings.Contains(filePath, "..") || isReservedPath(filePath) {
+		s.writeJSONError(w, http.StatusBadRequest, CodeInvalidPath, "Invalid file path")
+		return
+	}
+
+	meta, _ := s.db.GetFileMetadata(filePath)
+	if meta == nil {
+		// No metadata: only an untracked stored file can be removed
+		if err := s.store.Delete(filePath); err != nil {
+			if os.IsNotExist(err) {
+				s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "File not found")
+			} else {
+				s.audit(r, AuditFileDelete, filePath, false, err.Error())
+				s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to delete file: %v", err))
+			}
+			return
+		}
+	} else {
+		if err := cleanup.DeleteFile(s.db, s.store, meta, s.cfg().Storage.TrashRetentionHours); err != nil {
+			s.audit(r, AuditFileDelete, filePath, false, err.Error())
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to delete file: %v", err))
+			return
+		}
+		s.notifier.NotifyFile(notify.EventDelete, meta)
+		s.events.Publish(events.FileEvent(events.TypeDelete, meta))
+		s.replicator.Enqueue(db.ReplicateDelete, meta.FilePath)
+	}
+	s.audit(r, AuditFileDelete, filePath, true, "")
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "File deleted successfully",
+	})
+	logging.Info("File deleted", logging.Fields{"path": filePath, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+// handleSlug handles downloads addressed by a custom slug
+func (s *Server) handleSlug(w http.ResponseWriter, r *http.Request) {
+	s.setRobotsTag(w)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.serveSlug(w, r, strings.TrimPrefix(r.URL.Path, "/s/"))
+}
+
+// serveSlug resolves a slug to its file and serves it
+func (s *Server) serveSlug(w http.ResponseWriter, r *http.Request, slug string) {
+	if naming.ValidateSlug(slug) != nil {
+		s.writeError(w, r, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+
+	meta, err := s.db.GetFileMetadataBySlug(slug)
+	if err != nil || meta == nil {
+		s.writeError(w, r, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+
+	s.serveFile(w, r, filepath.ToSlash(meta.FilePath))
+}
+
+// isReservedPath reports whether a path lies in a directory the server keeps
+// for itself, such as the trash or resumable upload state, rather than one
+// holding live files
+func isReservedPath(filePath string) bool {
+	return storage.IsReserved(filePath, cleanup.TrashDir, cleanup.CacheDir, cleanup.UploadTempDir)
+}
+
+// serveFile serves a stored file by its relative path
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	// Deleted files wait in the trash under their old paths and must stay
+	// gone; partial uploads and their state are nobody's to read
+	if isReservedPath(filePath) {
+		s.writeError(w, r, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+
+	// Expired files stay on disk until the next cleanup run
+	meta, _ := s.db.GetFileMetadata(filePath)
+	if meta != nil && !meta.IsPinned() && !meta.ExpiresAt.After(time.Now()) {
+		s.writeError(w, r, http.StatusGone, CodeExpired, "File has expired")
+		return
+	}
+
+	// Check if file exists
+	info, err := s.store.Stat(filePath)
+	if os.IsNotExist(err) {
+		s.writeError(w, r, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		logging.Error("Failed to stat file", logging.Fields{"path": filePath, "request_id": RequestID(r), "error": err})
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	// Bound concurrent downloads
+	if !s.acquireSlot(w, r, s.downloads, "downloads") {
+		return
+	}
+	defer s.downloads.release()
+
+	// Set content type, and tell players and download managers they can seek
+	// even before they send a Range header
+	w.Header().Set("Content-Type", contentType(filePath))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Saving the file offers its original name rather than the generated one
+	params := map[string]string{}
+	if meta != nil && meta.OriginalName != "" {
+		params["filename"] = meta.OriginalName
+	}
+	if disposition := mime.FormatMediaType(s.dispositionType(filePath), params); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+
+	// Serve file; range requests become seeks on the stored file
+	f, err := s.store.Get(filePath)
+	if err != nil {
+		logging.Error("Failed to open file", logging.Fields{"path": filePath, "request_id": RequestID(r), "error": err})
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(s.throttleDownload(w, r), r, filePath, info.ModTime, f)
+	if r.Method == http.MethodHead {
+		return
+	}
+	logging.Info("File downloaded", logging.Fields{"path": filePath, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+// handleAPIFiles handles the file list API
+func (s *Server) handleAPIFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check session or list token
+	if !s.checkListAccess(w, r) {
+		return
+	}
+
+	// Get date, tag and sort parameters
+	date := r.URL.Query().Get("path")
+	tag := r.URL.Query().Get("tag")
+	if tag != "" {
+		if err := naming.ValidateTag(tag); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidTag, err.Error())
+			return
+		}
+	}
+	order, err := db.ParseFileOrder(r.URL.Query().Get("sort"))
+	if err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	includeExpired, ok := s.includeExpired(w, r)
+	if !ok {
+		return
+	}
+
+	var files []*db.FileMetadata
+	var dates []string
+
+	if tag != "" {
+		// List files carrying the tag, optionally narrowed to one date directory
+		files, err = s.db.ListFilesByTag(tag, includeExpired)
+		if err != nil {
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to list files: %v", err))
+			return
+		}
+		if date != "" {
+			filtered := files[:0]
+			for _, meta := range files {
+				if naming.ParseDateFromPath(meta.FilePath) == date {
+					filtered = append(filtered, meta)
+				}
+			}
+			files = filtered
+		}
+	} else if date != "" {
+		// List files in specific date directory. The response is cached per
+		// date and order until a file of the date changes, or one of its
+		// files expires; the generation is read first, so a change made
+		// meanwhile makes the entry stale.
+		key := date + "|" + string(order)
+		if includeExpired {
+			key += "|expired"
+		}
+		generation := s.db.DateGeneration(date)
+		if cached := s.listings.get(key, generation); cached != nil {
+			s.serveCachedListing(w, r, cached)
+			return
+		}
+		files, err = s.db.ListFilesByDate(date, includeExpired)
+		if err != nil {
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to list files: %v", err))
+			return
+		}
+		db.SortFiles(files, order)
+		var validUntil time.Time
+		if !includeExpired {
+			validUntil = firstExpiry(files)
+		}
+		cached, err := newCachedListing(key, generation, validUntil, map[string]interface{}{
+			"success":      true,
+			"current_path": date,
+			"current_tag":  tag,
+			"files":        files,
+			"directories":  dates,
+		})
+		if err != nil {
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to list files: %v", err))
+			return
+		}
+		s.listings.put(cached)
+		s.serveCachedListing(w, r, cached)
+		return
+	} else {
+		// List all date directories
+		dates, err = s.db.ListAllDates(includeExpired)
+		if err != nil {
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to list dates: %v", err))
+			return
+		}
+	}
+
+	db.SortFiles(files, order)
+
+	response := map[string]interface{}{
+		"success":      true,
+		"current_path": date,
+		"current_tag":  tag,
+		"files":        files,
+		"directories":  dates,
+	}
+
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// Bounds of the recent uploads feed
+const (
+	defaultRecentLimit = 50
+	maxRecentLimit     = 500
+)
+
+// handleAPIRecent lists the most recent live uploads across all dates
+func (s *Server) handleAPIRecent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check session or list token
+	if !s.checkListAccess(w, r) {
+		return
+	}
+
+	limit := defaultRecentLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRecentLimit {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxRecentLimit))
+			return
+		}
+		limit = n
+	}
+	includeExpired, ok := s.includeExpired(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := s.db.ListRecentFiles(limit, includeExpired)
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to list files: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"files":   files,
+	})
+}
 
-	// Check API Key
-	apiKey := r.Header.Get("X-API-Key")
-	if apiKey != s.cfg.Auth.APIKey {
-		s.writeJSONError(w, http.StatusUnauthorized, "Invalid or missing API key")
+// handleAPITags handles the tag list API
+func (s *Server) handleAPITags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parse multipart form (max 100MB)
-	if err := r.ParseMultipartForm(s.cfg.Storage.MaxFileSize); err != nil {
-		s.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to parse form: %v", err))
+	// Check session or list token
+	if !s.checkListAccess(w, r) {
 		return
 	}
 
-	// Get file from form
-	file, header, err := r.FormFile("file")
+	tags, err := s.db.ListTags()
 	if err != nil {
-		s.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to get file: %v", err))
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to list tags: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"tags":    tags,
+	})
+}
+
+// handleLogin handles login requests
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	// GET tells the login form which options to offer
+	if r.Method == http.MethodGet {
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":     true,
+			"remember_me": s.rememberMeAllowed(),
+		})
+		return
+	}
+	// DELETE logs out; the cookie is HttpOnly, so only the server can clear it
+	if r.Method == http.MethodDelete {
+		s.handleLogout(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		Remember bool   `json:"remember"` // Ignored unless security.allow_remember_me is set
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+		return
+	}
+
+	if !s.isListPassword(req.Password) {
+		s.audit(r, AuditLoginFailure, "list", false, "")
+		s.writeJSONError(w, http.StatusUnauthorized, CodeUnauthorized, "Invalid password")
+		return
+	}
+
+	// A remembered session lasts until the absolute timeout, idle or not
+	security := s.cfg().Security
+	idle := time.Duration(security.SessionTimeout) * time.Second
+	absolute := time.Duration(security.SessionAbsoluteTimeout) * time.Second
+	remember := req.Remember && s.rememberMeAllowed()
+	if remember {
+		idle = absolute
+	}
+	token, sess := s.addSession(r, idle, absolute)
+	s.setSessionCookie(w, r, token, sess.ExpiresAt)
+
+	detail := ""
+	if remember {
+		detail = "remember me"
+	}
+	s.audit(r, AuditLoginSuccess, "list", true, detail)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"csrf_token": sess.CSRFToken,
+		"expires_at": sess.ExpiresAt,
+		"remember":   remember,
+	})
+	logging.Info("User logged in", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+// handleLogout ends the request's session, if any, and clears its cookie
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if sess := s.lookupSession(cookie.Value); sess != nil {
+			if !validCSRF(r, sess) {
+				s.writeJSONError(w, http.StatusForbidden, CodeInvalidCSRF, "Invalid or missing CSRF token")
+				return
+			}
+			s.endSession(cookie.Value)
+			logging.Info("User logged out", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r)})
+		}
+	}
+	s.clearSessionCookie(w, r)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// handleAdminAPI handles admin API requests
+func (s *Server) handleAdminAPI(w http.ResponseWriter, r *http.Request) {
+	// Basic auth for admin; local CLI commands authenticate with the control token,
+	// and tokens with the admin-read scope may make read-only requests
+	presented, valid := s.adminBasicAuth(r)
+	switch {
+	case s.isControlRequest(r):
+	case !presented && r.Header.Get(APIKeyHeader) != "":
+		if !tokenReadable(r) {
+			s.writeKeyError(w, http.StatusForbidden, ReasonInsufficientScope, "admin")
+			return
+		}
+		if !s.requireScope(w, r, db.ScopeAdminRead) {
+			return
+		}
+	case !valid:
+		if presented {
+			s.audit(r, AuditAdminLoginFailure, r.URL.Path, false, "")
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="Admin"`)
+		s.writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Admin credentials are required")
+		return
+	case !s.checkTOTP(r):
+		// Everyone but the local CLI needs the second factor once it's enabled
+		if r.Header.Get(TOTPHeader) != "" {
+			s.audit(r, AuditTOTPFailure, r.URL.Path, false, "")
+		}
+		s.writeTOTPRequired(w)
 		return
 	}
-	defer file.Close()
 
-	// Get TTL
-	ttlStr := r.FormValue("ttl")
-	ttl := s.cfg.Storage.DefaultTTL
-	if ttlStr != "" {
-		ttl, err = strconv.Atoi(ttlStr)
+	// Handle different admin endpoints
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/config"):
+		s.handleAdminConfig(w, r)
+	case strings.HasSuffix(r.URL.Path, "/config/reset"):
+		s.handleAdminConfigReset(w, r)
+	case strings.HasSuffix(r.URL.Path, "/stats"):
+		s.handleAdminStats(w, r)
+	case strings.HasSuffix(r.URL.Path, "/events"):
+		s.handleAdminEvents(w, r)
+	case strings.HasSuffix(r.URL.Path, "/audit"):
+		s.handleAdminAudit(w, r)
+	case strings.HasSuffix(r.URL.Path, "/bans"):
+		s.handleAdminBans(w, r)
+	case strings.HasSuffix(r.URL.Path, "/tokens") || strings.Contains(r.URL.Path, "/tokens/"):
+		s.handleAdminTokens(w, r)
+	case strings.HasSuffix(r.URL.Path, "/2fa") || strings.Contains(r.URL.Path, "/2fa/"):
+		s.handleAdminTwoFactor(w, r)
+	case strings.HasSuffix(r.URL.Path, "/sessions") || strings.Contains(r.URL.Path, "/sessions/"):
+		s.handleAdminSessions(w, r)
+	case strings.HasSuffix(r.URL.Path, "/backups"):
+		s.handleAdminBackups(w, r)
+	case strings.HasSuffix(r.URL.Path, "/export"):
+		s.handleAdminExport(w, r)
+	case strings.HasSuffix(r.URL.Path, "/logs"):
+		s.handleAdminLogs(w, r)
+	case strings.HasSuffix(r.URL.Path, "/maintenance"):
+		s.handleAdminMaintenance(w, r)
+	case strings.HasSuffix(r.URL.Path, "/files/restore"):
+		s.handleAdminRestore(w, r)
+	case strings.HasSuffix(r.URL.Path, "/files/delete"):
+		s.handleAdminBulkDelete(w, r)
+	case isAdminFilePath(r.URL.Path):
+		s.handleAdminFile(w, r)
+	case strings.HasSuffix(r.URL.Path, "/cleanup"):
+		s.handleAdminCleanup(w, r)
+	case strings.HasSuffix(r.URL.Path, "/cleanup/history"):
+		s.handleAdminCleanupHistory(w, r)
+	case strings.HasSuffix(r.URL.Path, "/purge"):
+		s.handleAdminPurge(w, r)
+	case strings.HasSuffix(r.URL.Path, "/reconcile"):
+		s.handleAdminReconcile(w, r)
+	case strings.HasSuffix(r.URL.Path, "/verify"):
+		s.handleAdminVerify(w, r)
+	case strings.HasSuffix(r.URL.Path, "/verify/cancel"):
+		s.handleAdminVerifyCancel(w, r)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleAdminConfig handles config management. GET returns the active config, or
+// stored values with ?key=<key> or ?key=all; PUT stores a key and applies it live
+// where possible; DELETE ?key=<key> removes a stored key. Secrets are masked in
+// responses unless a GET passes ?reveal=1.
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		key := r.URL.Query().Get("key")
+		reveal := r.URL.Query().Get("reveal") == "1"
+		switch key {
+		case "":
+			cfg := s.cfg()
+			if !reveal {
+				cfg = cfg.Masked()
+			}
+			s.writeJSON(w, http.StatusOK, cfg)
+		case "all":
+			values := s.db.GetAllConfig()
+			if !reveal {
+				values = config.MaskSecrets(values)
+			}
+			s.writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"config":  values,
+			})
+		default:
+			value := s.db.GetConfig(key)
+			if !reveal {
+				value = config.MaskConfigValue(key, value)
+			}
+			s.writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"key":     key,
+				"value":   value,
+			})
+		}
+		if reveal {
+			logging.Warn("Config secrets revealed via admin API", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r)})
+		}
+	} else if r.Method == http.MethodPut {
+		var req struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+			Force bool   `json:"force"` // Store keys missing from the config schema
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+			return
+		}
+		if !req.Force && !config.IsKnownKey(req.Key) {
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidConfig, fmt.Sprintf("Unknown config key '%s'", req.Key))
+			return
+		}
+
+		live, err := s.updateConfig(req.Key, req.Value)
+		if err != nil {
+			s.audit(r, AuditConfigSet, req.Key, false, err.Error())
+			s.writeJSONError(w, http.StatusBadRequest, CodeInvalidConfig, err.Error())
+			return
+		}
+		s.audit(r, AuditConfigSet, req.Key, true, "")
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"key":     req.Key,
+			"value":   config.MaskConfigValue(req.Key, req.Value),
+			"live":    live,
+		})
+		logging.Info("Config updated via admin API", logging.Fields{"key": req.Key, "live": live, "request_id": RequestID(r)})
+	} else if r.Method == http.MethodDelete {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Missing key parameter")
+			return
+		}
+
+		oldValue := s.db.GetAllConfig()[key]
+		removed, live, err := s.deleteConfig(key)
 		if err != nil {
-			s.writeJSONError(w, http.StatusBadRequest, "Invalid TTL value")
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to remove config: %v", err))
 			return
 		}
+		if !removed {
+			s.writeJSONError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Config key '%s' is not set", key))
+			return
+		}
+		s.audit(r, AuditConfigUnset, key, true, "")
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":   true,
+			"key":       key,
+			"old_value": config.MaskConfigValue(key, oldValue),
+			"default":   config.MaskConfigValue(key, db.DefaultConfigValue(key)),
+			"live":      live,
+		})
+		logging.Info("Config removed via admin API", logging.Fields{"key": key, "live": live, "request_id": RequestID(r)})
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminConfigReset restores the built-in defaults for a config group
+func (s *Server) handleAdminConfigReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Prefix string `json:"prefix"` // Group name, single key, or "all"
 	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Prefix == "" {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+		return
+	}
+
+	changes, live, err := s.resetConfig(req.Prefix)
+	if err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeInvalidConfig, err.Error())
+		return
+	}
+	s.audit(r, AuditConfigReset, req.Prefix, true, fmt.Sprintf("%d keys changed", len(changes)))
+	masked := make([]db.ConfigChange, 0, len(changes))
+	for _, change := range changes {
+		change.OldValue = config.MaskConfigValue(change.Key, change.OldValue)
+		change.NewValue = config.MaskConfigValue(change.Key, change.NewValue)
+		masked = append(masked, change)
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"changes": masked,
+		"live":    live,
+	})
+	logging.Info("Config reset via admin API", logging.Fields{"prefix": req.Prefix, "changed": len(changes), "live": len(live), "request_id": RequestID(r)})
+}
 
-	// Validate TTL
-	if ttl < 1 || ttl > s.cfg.Storage.MaxTTL {
-		s.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("TTL must be between 1 and %d hours", s.cfg.Storage.MaxTTL))
+// handleAdminExport streams an export archive built from a consistent snapshot of
+// the database; ?files=1 includes the Images tree
+func (s *Server) handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Generate file path
-	relativePath, err := naming.GenerateFilePath(header.Filename)
+	snapshot, err := s.db.Snapshot()
 	if err != nil {
-		s.writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to generate file path: %v", err))
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to snapshot database: %v", err))
 		return
 	}
+	withFiles := r.URL.Query().Get("files") == "1"
 
-	// Create date directory
-	dateDir := naming.ParseDateFromPath(relativePath)
-	fullDirPath := filepath.Join(s.cfg.Storage.ImagesDir, dateDir)
-	if err := os.MkdirAll(fullDirPath, 0755); err != nil {
-		s.writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create directory: %v", err))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.tar.gz"`, time.Now().Format("20060102-150405")))
+	files, err := backup.Write(w, snapshot, s.store, withFiles)
+	if err != nil {
+		// Headers are already sent; the truncated archive fails to decompress
+		s.audit(r, AuditDatabaseExport, "", false, err.Error())
+		logging.Error("Export failed", logging.Fields{"files": files, "request_id": RequestID(r), "error": err})
 		return
 	}
+	s.audit(r, AuditDatabaseExport, "", true, fmt.Sprintf("%d files included", files))
+	logging.Info("Exported database", logging.Fields{"files": files, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+// defaultTopUploaders is how many uploader IPs the stats endpoint lists by default
+const defaultTopUploaders = 10
 
-	// Save file
-	fullPath := naming.GetStoragePath(s.cfg.Storage.ImagesDir, relativePath)
-	dst, err := os.Create(fullPath)
+// handleAdminStats handles stats requests. ?from= and ?to= (YYYYMMDD or
+// YYYY-MM-DD, both inclusive) bound the aggregation by upload date; ?top= sets
+// how many uploader IPs are listed.
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	from, err := parseStatsDate(r.URL.Query().Get("from"))
 	if err != nil {
-		s.writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create file: %v", err))
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid from date: %v", err))
 		return
 	}
-	defer dst.Close()
-
-	size, err := io.Copy(dst, file)
+	to, err := parseStatsDate(r.URL.Query().Get("to"))
 	if err != nil {
-		s.writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save file: %v", err))
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Invalid to date: %v", err))
 		return
 	}
-
-	// Calculate expiry time
-	uploadedAt := time.Now()
-	expiresAt := uploadedAt.Add(time.Duration(ttl) * time.Hour)
-
-	// Save metadata to database
-	metadata := &db.FileMetadata{
-		FileName:     filepath.Base(relativePath),
-		OriginalName: header.Filename,
-		FilePath:     relativePath,
-		FileSize:     size,
-		UploadedAt:   uploadedAt,
-		ExpiresAt:    expiresAt,
-		TTL:          ttl,
-		RemoteIP:     getRemoteIP(r),
+	if !to.IsZero() {
+		// Include the whole last day
+		to = to.AddDate(0, 0, 1)
+	}
+	top := defaultTopUploaders
+	if v := r.URL.Query().Get("top"); v != "" {
+		if top, err = strconv.Atoi(v); err != nil || top < 1 {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid top parameter")
+			return
+		}
 	}
 
-	if err := s.db.SaveFileMetadata(metadata); err != nil {
-		log.Printf("Warning: failed to save metadata: %v", err)
+	stats, err := s.db.GetDetailedStats(from, to, top)
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to get stats: %v", err))
+		return
 	}
+	stats.UploadUsage = s.db.GetAllUsage()
+	stats.UploadQuotaBytes = s.cfg().Security.UploadQuotaPerDayBytes
+	stats.Replication = s.replicator.Status()
 
-	// Return success response
-	response := map[string]interface{}{
-		"success":     true,
-		"message":     "File uploaded successfully",
-		"file_path":   relativePath,
-		"download_url": fmt.Sprintf("/files/%s", relativePath),
-		"expires_at":  expiresAt.Format(time.RFC3339),
+	s.writeJSON(w, http.StatusOK, stats)
+}
+
+// parseStatsDate parses a stats range bound; an empty value means unbounded
+func parseStatsDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	layout := "20060102"
+	if strings.Contains(value, "-") {
+		layout = "2006-01-02"
 	}
+	return time.ParseInLocation(layout, value, time.Local)
+}
 
-	s.writeJSON(w, http.StatusOK, response)
-	log.Printf("File uploaded: %s (original: %s, size: %d bytes, TTL: %dh)", relativePath, header.Filename, size, ttl)
+// handleAdminLogs handles log requests
+func (s *Server) handleAdminLogs(w http.ResponseWriter, r *http.Request) {
+	// Return recent logs (implementation needed)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"logs": []string{},
+	})
 }
 
-// handleFiles handles file download requests
-func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// handleAdminRestore moves a trashed file back into place
+func (s *Server) handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Extract file path from URL
-	filePath := strings.TrimPrefix(r.URL.Path, "/files/")
-	if filePath == "" {
-		http.Error(w, "File not found", http.StatusNotFound)
+	if s.refuseIfReadOnly(w) {
 		return
 	}
 
-	// Build full file path
-	fullPath := naming.GetStoragePath(s.cfg.Storage.ImagesDir, filePath)
-
-	// Check if file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
+	// Path may come from the query string or a JSON body
+	var req struct {
+		Path string `json:"path"`
+	}
+	req.Path = r.URL.Query().Get("path")
+	if req.Path == "" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+			return
+		}
+	}
+	if req.Path == "" || strings.Contains(req.Path, "..") {
+		s.writeJSONError(w, http.StatusBadRequest, CodeInvalidPath, "Invalid file path")
 		return
 	}
 
-	// Set content type
-	ext := filepath.Ext(filePath)
-	mimeType := mime.TypeByExtension(ext)
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	meta, err := cleanup.RestoreFile(s.db, s.store, req.Path)
+	if err != nil {
+		s.audit(r, AuditFileRestore, req.Path, false, err.Error())
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Failed to restore file: %v", err))
+		return
 	}
-	w.Header().Set("Content-Type", mimeType)
+	s.audit(r, AuditFileRestore, meta.FilePath, true, "")
+	s.replicator.Enqueue(db.ReplicateUpload, meta.FilePath)
 
-	// Serve file
-	http.ServeFile(w, r, fullPath)
-	log.Printf("File downloaded: %s from %s", filePath, getRemoteIP(r))
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"message":    "File restored successfully",
+		"file_path":  meta.FilePath,
+		"expires_at": meta.ExpiresAt.Format(time.RFC3339),
+	})
+	logging.Info("File restored from trash", logging.Fields{"path": meta.FilePath, "ip": getRemoteIP(r), "request_id": RequestID(r)})
 }
 
-// handleAPIFiles handles the file list API
-func (s *Server) handleAPIFiles(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// handleAdminCleanup triggers a cleanup run, optionally as a dry run
+func (s *Server) handleAdminCleanup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if s.cleanupMgr == nil {
+		s.writeJSONError(w, http.StatusServiceUnavailable, CodeUnavailable, "Cleanup manager is not available")
+		return
+	}
 
-	// Check session
-	if !s.checkSession(w, r) {
+	dryRun := r.URL.Query().Get("dry_run") == "1"
+	if !dryRun && s.refuseIfReadOnly(w) {
 		return
 	}
+	report := s.cleanupMgr.RunOnce(dryRun)
+	if !dryRun {
+		s.audit(r, AuditCleanupRun, "", len(report.Errors) == 0, fmt.Sprintf("%d files deleted", report.FilesDeleted))
+	}
 
-	// Get date parameter
-	date := r.URL.Query().Get("path")
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"report":  report,
+	})
+	logging.Info("Cleanup triggered", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r), "dry_run": dryRun})
+}
 
-	var files []*db.FileMetadata
-	var dates []string
-	var err error
+// handleAdminBackups lists metadata backups (GET) or takes one immediately (POST)
+func (s *Server) handleAdminBackups(w http.ResponseWriter, r *http.Request) {
+	if s.cleanupMgr == nil {
+		s.writeJSONError(w, http.StatusServiceUnavailable, CodeUnavailable, "Cleanup manager is not available")
+		return
+	}
 
-	if date != "" {
-		// List files in specific date directory
-		files, err = s.db.ListFilesByDate(date)
+	switch r.Method {
+	case http.MethodGet:
+		backups, last, err := s.cleanupMgr.Backups()
 		if err != nil {
-			s.writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list files: %v", err))
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to list backups: %v", err))
 			return
 		}
-	} else {
-		// List all date directories
-		dates, err = s.db.ListAllDates()
+		response := map[string]interface{}{
+			"success":   true,
+			"directory": s.cleanupMgr.BackupDir(),
+			"backups":   backups,
+		}
+		if !last.IsZero() {
+			response["last_backup_at"] = last
+		}
+		s.writeJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		backup, err := s.cleanupMgr.Backup()
 		if err != nil {
-			s.writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list dates: %v", err))
+			s.audit(r, AuditBackupCreate, "", false, err.Error())
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Backup failed: %v", err))
 			return
 		}
+		s.audit(r, AuditBackupCreate, backup.Name, true, "")
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"backup":  backup,
+		})
+		logging.Info("Backup triggered", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r)})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
+}
 
-	response := map[string]interface{}{
-		"success":      true,
-		"current_path": date,
-		"files":        files,
-		"directories":  dates,
+// handleAdminCleanupHistory returns stored cleanup reports, newest first
+func (s *Server) handleAdminCleanupHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	s.writeJSON(w, http.StatusOK, response)
+	reports, err := s.db.GetCleanupReports()
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to get cleanup history: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"reports": reports,
+	})
 }
 
-// handleLogin handles login requests
-func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+// handleAdminPurge permanently removes everything uploaded from one IP address.
+// With "dry_run" set it only reports what would be removed.
+func (s *Server) handleAdminPurge(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req struct {
-		Password string `json:"password"`
+		RemoteIP string `json:"remote_ip"`
+		DryRun   bool   `json:"dry_run"`
 	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.writeJSONError(w, http.StatusBadRequest, "Invalid request")
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || net.ParseIP(req.RemoteIP) == nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request: remote_ip must be an IP address")
 		return
 	}
-
-	if req.Password != s.cfg.Auth.ListPassword {
-		s.writeJSONError(w, http.StatusUnauthorized, "Invalid password")
+	if !req.DryRun && s.refuseIfReadOnly(w) {
 		return
 	}
 
-	// Generate session token
-	token := generateToken()
-
-	// Store session with expiry
-	s.sessionMux.Lock()
-	s.sessions[token] = time.Now().Add(time.Duration(s.cfg.Security.SessionTimeout) * time.Second)
-	s.sessionMux.Unlock()
+	report, err := cleanup.PurgeByIP(s.db, s.store, req.RemoteIP, req.DryRun)
+	if err != nil {
+		if !req.DryRun {
+			s.audit(r, AuditFilesPurge, req.RemoteIP, false, err.Error())
+		}
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Purge failed: %v", err))
+		return
+	}
+	if !req.DryRun {
+		s.audit(r, AuditFilesPurge, req.RemoteIP, len(report.Errors) == 0, fmt.Sprintf("%d files and %d records removed", report.FilesRemoved, report.RecordsRemoved))
+		for _, filePath := range report.Files {
+			s.replicator.Enqueue(db.ReplicateDelete, filePath)
+		}
+	}
 
-	// Set cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    token,
-		MaxAge:   s.cfg.Security.SessionTimeout,
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"report":  report,
 	})
-
-	s.writeJSON(w, http.StatusOK, map[string]bool{"success": true})
-	log.Printf("User logged in from %s", getRemoteIP(r))
+	logging.Info("Purge of uploads requested", logging.Fields{"target": req.RemoteIP, "ip": getRemoteIP(r), "request_id": RequestID(r), "dry_run": req.DryRun})
 }
 
-// handleAdminAPI handles admin API requests
-func (s *Server) handleAdminAPI(w http.ResponseWriter, r *http.Request) {
-	// Basic auth for admin
-	username, password, ok := r.BasicAuth()
-	if !ok || username != s.cfg.Auth.AdminUsername || password != s.cfg.Auth.AdminPassword {
-		w.Header().Set("WWW-Authenticate", `Basic realm="Admin"`)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// handleAdminReconcile reports (and optionally fixes) orphaned files and metadata
+func (s *Server) handleAdminReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.cleanupMgr == nil {
+		s.writeJSONError(w, http.StatusServiceUnavailable, CodeUnavailable, "Cleanup manager is not available")
 		return
 	}
 
-	// Handle different admin endpoints
-	switch {
-	case strings.HasSuffix(r.URL.Path, "/config"):
-		s.handleAdminConfig(w, r)
-	case strings.HasSuffix(r.URL.Path, "/stats"):
-		s.handleAdminStats(w, r)
-	case strings.HasSuffix(r.URL.Path, "/logs"):
-		s.handleAdminLogs(w, r)
-	default:
-		http.Error(w, "Not found", http.StatusNotFound)
+	fix := r.URL.Query().Get("fix") == "1"
+	if fix && s.refuseIfReadOnly(w) {
+		return
+	}
+	report, err := s.cleanupMgr.Reconcile(fix)
+	if err != nil {
+		if fix {
+			s.audit(r, AuditReconcileFix, "", false, err.Error())
+		}
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to reconcile: %v", err))
+		return
+	}
+	if fix {
+		s.audit(r, AuditReconcileFix, "", len(report.Errors) == 0, fmt.Sprintf("%d files and %d records removed", report.FilesRemoved, report.RecordsRemoved))
 	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"report":  report,
+	})
+	logging.Info("Reconcile triggered", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r), "fix": fix})
 }
 
-// handleAdminConfig handles config management
-func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		s.writeJSON(w, http.StatusOK, s.cfg)
-	} else if r.Method == http.MethodPut {
-		// Update config (implementation needed)
-		s.writeJSON(w, http.StatusOK, map[string]bool{"success": true})
-	} else {
+// handleAdminVerify re-hashes stored files and reports mismatches
+func (s *Server) handleAdminVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-}
-
-// handleAdminStats handles stats requests
-func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
-	totalFiles, totalSize, err := s.db.GetStats()
-	if err != nil {
-		s.writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get stats: %v", err))
+	if s.cleanupMgr == nil {
+		s.writeJSONError(w, http.StatusServiceUnavailable, CodeUnavailable, "Cleanup manager is not available")
 		return
 	}
 
-	response := map[string]interface{}{
-		"total_files": totalFiles,
-		"total_size":  totalSize,
+	opts := cleanup.VerifyOptions{Date: r.URL.Query().Get("path")}
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid id")
+			return
+		}
+		opts.ID = id
 	}
 
-	s.writeJSON(w, http.StatusOK, response)
+	report, err := s.cleanupMgr.Verify(opts)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, cleanup.ErrVerifyRunning) {
+			status = http.StatusConflict
+		}
+		s.writeJSONError(w, status, codeForStatus(status), fmt.Sprintf("Failed to verify: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"report":  report,
+	})
 }
 
-// handleAdminLogs handles log requests
-func (s *Server) handleAdminLogs(w http.ResponseWriter, r *http.Request) {
-	// Return recent logs (implementation needed)
+// handleAdminVerifyCancel stops a running verification
+func (s *Server) handleAdminVerifyCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.cleanupMgr == nil || !s.cleanupMgr.CancelVerify() {
+		s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "No verification is running")
+		return
+	}
+
 	s.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"logs": []string{},
+		"success": true,
+		"message": "Verification cancelled",
 	})
+	logging.Info("Verification cancelled", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r)})
 }
 
 // handleListPage handles the file list page
@@ -371,10 +1540,12 @@ func (s *Server) handleListPage(w http.ResponseWriter, r *http.Request) {
 // handleManagerPage handles the admin manager page
 func (s *Server) handleManagerPage(w http.ResponseWriter, r *http.Request) {
 	// Check basic auth
-	username, password, ok := r.BasicAuth()
-	if !ok || username != s.cfg.Auth.AdminUsername || password != s.cfg.Auth.AdminPassword {
+	if presented, valid := s.adminBasicAuth(r); !valid {
+		if presented {
+			s.audit(r, AuditAdminLoginFailure, r.URL.Path, false, "")
+		}
 		w.Header().Set("WWW-Authenticate", `Basic realm="Admin"`)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		s.writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Admin credentials are required")
 		return
 	}
 
@@ -382,19 +1553,77 @@ func (s *Server) handleManagerPage(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(managerPageHTML))
 }
 
-// handleHealth handles health check requests
+// handleHealth handles health check requests. The default response is a minimal
+// status; ?verbose=1 adds runtime, storage, and database details.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	totalFiles, totalSize, _ := s.db.GetStats()
+	status := "ok"
+	var problems []string
+
+	lastSaveAt, saveErr := s.db.SaveStatus()
+	if saveErr != nil {
+		problems = append(problems, fmt.Sprintf("last database save failed: %v", saveErr))
+	}
+
+	freeBytes, diskErr := DiskFree(s.cfg().Storage.ImagesDir)
+	minFree := uint64(s.cfg().Server.MinFreeDiskMB) * 1024 * 1024
+	if diskErr == nil && minFree > 0 && freeBytes < minFree {
+		problems = append(problems, fmt.Sprintf("free disk space %s is below %s", formatBytes(int64(freeBytes)), formatBytes(int64(minFree))))
+	}
+
+	httpStatus := http.StatusOK
+	if len(problems) > 0 {
+		status = "degraded"
+		httpStatus = http.StatusServiceUnavailable
+	}
 
 	response := map[string]interface{}{
-		"status": "ok",
-		"storage_info": map[string]interface{}{
-			"total_files": totalFiles,
-			"total_size":  formatBytes(totalSize),
-		},
+		"status": status,
+		"mode":   "normal",
+	}
+	if len(problems) > 0 {
+		response["problems"] = problems
+	}
+	if cfg := s.cfg(); cfg.Server.ReadOnly {
+		response["mode"] = "read_only"
+		if cfg.Server.MaintenanceMessage != "" {
+			response["maintenance_message"] = cfg.Server.MaintenanceMessage
+		}
 	}
 
-	s.writeJSON(w, http.StatusOK, response)
+	if r.URL.Query().Get("verbose") == "1" {
+		counts, _ := s.db.GetFileCounts()
+
+		response["version"] = s.version
+		response["go_version"] = runtime.Version()
+		response["uptime"] = int64(time.Since(s.startedAt).Seconds())
+		response["active_sessions"] = s.activeSessionCount()
+		response["in_flight"] = map[string]interface{}{
+			"uploads":   s.uploads.active(),
+			"downloads": s.downloads.active(),
+		}
+		response["storage_info"] = map[string]interface{}{
+			"total_files":   counts.LiveFiles + counts.ExpiredFiles,
+			"total_size":    formatBytes(counts.LiveSize + counts.ExpiredSize),
+			"expired_files": counts.ExpiredFiles,
+			"expired_size":  formatBytes(counts.ExpiredSize),
+		}
+		if diskErr == nil {
+			response["disk_free"] = freeBytes
+		}
+		if !lastSaveAt.IsZero() {
+			response["last_db_save"] = lastSaveAt.Format(time.RFC3339)
+		}
+		if last := s.db.GetLastCleanupReport(); last != nil {
+			response["last_cleanup"] = map[string]interface{}{
+				"started_at":    last.StartedAt.Format(time.RFC3339),
+				"files_deleted": last.FilesDeleted,
+				"bytes_freed":   last.BytesFreed,
+				"errors":        len(last.Errors),
+			}
+		}
+	}
+
+	s.writeJSON(w, httpStatus, response)
 }
 
 // handleCatchAll handles root path and direct file access
@@ -406,12 +1635,13 @@ func (s *Server) handleCatchAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if request is for a file (pattern: YYYYMMDD/filename.ext)
+	// Check if request is for a file (pattern: YYYYMMDD/filename.ext, or
+	// nested per storage.path_layout)
 	requestPath := strings.TrimPrefix(r.URL.Path, "/")
-	parts := strings.Split(requestPath, "/")
 
-	// Check if pattern matches: date directory + file with extension
-	if len(parts) >= 2 && len(parts[0]) == 8 && isAllDigits(parts[0]) && filepath.Ext(parts[1]) != "" {
+	// Check if pattern matches: a dated file name with an extension outside
+	// the server's own directories
+	if naming.IsFilePath(requestPath) && !isReservedPath(requestPath) {
 		// This looks like a direct file access request
 		// Delegate to handleFiles logic
 		s.handleFiles(w, r)
@@ -419,7 +1649,7 @@ func (s *Server) handleCatchAll(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Not found
-	http.NotFound(w, r)
+	s.writeError(w, r, http.StatusNotFound, CodeNotFound, "Page not found")
 }
 
 func isAllDigits(s string) bool {
@@ -431,26 +1661,57 @@ func isAllDigits(s string) bool {
 	return true
 }
 
-// checkSession checks if the user has a valid session
+// checkSession checks if the user has a valid session and, for requests that
+// change state, the matching CSRF token
 func (s *Server) checkSession(w http.ResponseWriter, r *http.Request) bool {
-	cookie, err := r.Cookie("session_token")
+	cookie, err := r.Cookie(sessionCookie)
 	if err != nil {
-		s.writeJSONError(w, http.StatusUnauthorized, "Not authenticated")
+		s.writeJSONError(w, http.StatusUnauthorized, CodeUnauthorized, "Not authenticated")
 		return false
 	}
 
-	s.sessionMux.RLock()
-	expiresAt, exists := s.sessions[cookie.Value]
-	s.sessionMux.RUnlock()
-
-	if !exists || time.Now().After(expiresAt) {
-		s.writeJSONError(w, http.StatusUnauthorized, "Session expired")
+	sess := s.lookupSession(cookie.Value)
+	if sess == nil {
+		s.writeJSONError(w, http.StatusUnauthorized, CodeSessionExpired, "Session expired")
+		return false
+	}
+	if !validCSRF(r, sess) {
+		s.writeJSONError(w, http.StatusForbidden, CodeInvalidCSRF, "Invalid or missing CSRF token")
 		return false
 	}
 
+	// Sliding expiry: the session and its cookie last Idle from this request
+	expires, ok := s.renewSession(cookie.Value)
+	if !ok {
+		s.writeJSONError(w, http.StatusUnauthorized, CodeSessionExpired, "Session expired")
+		return false
+	}
+	s.setSessionCookie(w, r, cookie.Value, expires)
 	return true
 }
 
+// rememberMeAllowed reports whether logins may ask for a remembered session;
+// that needs an absolute timeout to end it
+func (s *Server) rememberMeAllowed() bool {
+	security := s.cfg().Security
+	return security.AllowRememberMe && security.SessionAbsoluteTimeout > 0
+}
+
+// activeSessionCount returns the number of unexpired sessions
+func (s *Server) activeSessionCount() int {
+	s.sessionMux.RLock()
+	defer s.sessionMux.RUnlock()
+
+	count := 0
+	now := time.Now()
+	for _, sess := range s.sessions {
+		if now.Before(sess.ExpiresAt) {
+			count++
+		}
+	}
+	return count
+}
+
 // cleanupSessions removes expired sessions
 func (s *Server) cleanupSessions() {
 	ticker := time.NewTicker(time.Minute)
@@ -459,8 +1720,8 @@ func (s *Server) cleanupSessions() {
 	for range ticker.C {
 		s.sessionMux.Lock()
 		now := time.Now()
-		for token, expiresAt := range s.sessions {
-			if now.After(expiresAt) {
+		for token, sess := range s.sessions {
+			if now.After(sess.ExpiresAt) {
 				delete(s.sessions, token)
 			}
 		}
@@ -478,27 +1739,27 @@ func generateToken() string {
 
 // writeJSON writes a JSON response
 func (s *Server) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	// Error bodies carry the request ID (set by withRequestID), so users can
+	// quote it when reporting a failure
+	if response, ok := data.(map[string]interface{}); ok && status >= http.StatusBadRequest {
+		if id := w.Header().Get(RequestIDHeader); id != "" {
+			response["request_id"] = id
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
 
 // writeJSONError writes a JSON error response
-func (s *Server) writeJSONError(w http.ResponseWriter, status int, message string) {
+func (s *Server) writeJSONError(w http.ResponseWriter, status int, code, message string) {
 	s.writeJSON(w, status, map[string]interface{}{
 		"success": false,
+		"code":    code,
 		"message": message,
 	})
 }
 
-// getRemoteIP gets the remote IP address
-func getRemoteIP(r *http.Request) string {
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		return strings.Split(forwarded, ",")[0]
-	}
-	return r.RemoteAddr
-}
-
 // formatBytes formats bytes to human readable string
 func formatBytes(b int64) string {
 	const unit = 1024
@@ -537,6 +1798,9 @@ const listPageHTML = `<!DOCTYPE html>
         .file-item a:hover { text-decoration: underline; }
         .dir-item { padding: 10px; border-bottom: 1px solid #eee; }
         .dir-item a { color: #333; text-decoration: none; font-weight: bold; }
+        .tag-chips { margin: 10px 0; }
+        .tag-chip { display: inline-block; padding: 2px 10px; margin: 2px 4px 2px 0; background: #e7f1ff; color: #0056b3; border-radius: 12px; font-size: 0.9em; cursor: pointer; text-decoration: none; }
+        .tag-chip:hover { background: #cfe2ff; }
         .hidden { display: none; }
     </style>
 </head>
@@ -547,23 +1811,38 @@ const listPageHTML = `<!DOCTYPE html>
         <div class="login-box">
             <h2>Login Required</h2>
             <input type="password" id="password" placeholder="Enter password" onkeypress="if(event.key==='Enter') login()">
+            <label id="remember-row" class="hidden"><br><input type="checkbox" id="remember"> Remember me</label>
             <br><button onclick="login()">Login</button>
         </div>
     </div>
     <div id="content" class="hidden">
-        <p>Current: <span id="current-path">/</span> <a href="#" onclick="loadFiles('')">[Root]</a></p>
+        <p>Current: <span id="current-path">/</span> <a href="#" onclick="loadFiles('')">[Root]</a> <a href="#" onclick="loadRecent()">[Recent]</a></p>
+        <div id="tag-chips" class="tag-chips"></div>
         <div id="file-list"></div>
     </div>
 
     <script>
+        // Requests other than GET must echo the CSRF token issued at login
+        function apiFetch(url, options) {
+            options = options || {};
+            const method = (options.method || 'GET').toUpperCase();
+            const token = localStorage.getItem('csrf_token');
+            if (method !== 'GET' && method !== 'HEAD' && token) {
+                options.headers = Object.assign({}, options.headers, { 'X-CSRF-Token': token });
+            }
+            return fetch(url, options);
+        }
+
         async function login() {
             const password = document.getElementById('password').value;
             const res = await fetch('/api/login', {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ password })
+                body: JSON.stringify({ password, remember: document.getElementById('remember').checked })
             });
             if (res.ok) {
+                const data = await res.json();
+                localStorage.setItem('csrf_token', data.csrf_token);
                 document.getElementById('login-overlay').classList.add('hidden');
                 document.getElementById('content').classList.remove('hidden');
                 loadFiles('');
@@ -573,32 +1852,87 @@ const listPageHTML = `<!DOCTYPE html>
         }
 
         async function loadFiles(path) {
-            const res = await fetch('/api/files?path=' + encodeURIComponent(path));
+            const res = await apiFetch('/api/files?path=' + encodeURIComponent(path));
+            renderFiles(await res.json(), path || '/');
+            loadTags(path === '');
+        }
+
+        async function loadTag(tag) {
+            const res = await apiFetch('/api/files?tag=' + encodeURIComponent(tag));
+            renderFiles(await res.json(), 'tag: ' + tag);
+            loadTags(false);
+        }
+
+        async function loadTags(show) {
+            const chips = document.getElementById('tag-chips');
+            chips.innerHTML = '';
+            if (!show) return;
+            const res = await apiFetch('/api/tags');
             const data = await res.json();
-            document.getElementById('current-path').textContent = path || '/';
+            (data.tags || []).forEach(t => chips.appendChild(tagChip(t.tag, t.tag + ' (' + t.count + ')')));
+        }
+
+        async function loadRecent() {
+            const res = await apiFetch('/api/files/recent?limit=50');
+            const data = await res.json();
+            loadTags(false);
+            document.getElementById('current-path').textContent = 'recent uploads';
+            const list = document.getElementById('file-list');
+            list.innerHTML = '';
+            (data.files || []).forEach(file => {
+                const div = document.createElement('div');
+                div.className = 'file-item';
+                const link = document.createElement('a');
+                link.href = '/files/' + file.file_path;
+                link.download = file.original_name;
+                link.textContent = file.original_name;
+                const info = document.createElement('span');
+                const expires = file.expires_at.startsWith('0001-') ? 'never' : new Date(file.expires_at).toLocaleString();
+                info.textContent = formatSize(file.file_size) + ' | ' + new Date(file.uploaded_at).toLocaleString() +
+                    ' from ' + (file.remote_ip || 'unknown') + ' | Expires: ' + expires;
+                div.appendChild(link);
+                div.appendChild(info);
+                list.appendChild(div);
+            });
+        }
+
+        function tagChip(tag, label) {
+            const a = document.createElement('a');
+            a.href = '#';
+            a.className = 'tag-chip';
+            a.textContent = '#' + label;
+            a.onclick = () => { loadTag(tag); return false; };
+            return a;
+        }
+
+        function renderFiles(data, current) {
+            document.getElementById('current-path').textContent = current;
             const list = document.getElementById('file-list');
             list.innerHTML = '';
 
-            data.directories.forEach(dir => {
+            (data.directories || []).forEach(dir => {
                 const div = document.createElement('div');
                 div.className = 'dir-item';
                 div.innerHTML = '<a href="#" onclick="loadFiles(\'' + dir + '\')">📁 ' + dir + '</a>';
                 list.appendChild(div);
             });
 
-            data.files.forEach(file => {
+            (data.files || []).forEach(file => {
                 const div = document.createElement('div');
                 div.className = 'file-item';
                 const size = formatSize(file.file_size);
                 const expires = new Date(file.expires_at).toLocaleString();
                 div.innerHTML = '<a href="/files/' + file.file_path + '" download>' + file.file_name + '</a> <span>' + size + ' | Expires: ' + expires + '</span>';
+                if (file.tag) div.querySelector('span').prepend(tagChip(file.tag, file.tag));
                 list.appendChild(div);
             });
         }
 
         function logout() {
-            document.cookie = 'session_token=; expires=Thu, 01 Jan 1970 00:00:00 UTC; path=/;';
-            location.reload();
+            apiFetch('/api/login', {method: 'DELETE'}).finally(() => {
+                localStorage.removeItem('csrf_token');
+                location.reload();
+            });
         }
 
         function formatSize(bytes) {
@@ -608,11 +1942,15 @@ const listPageHTML = `<!DOCTYPE html>
         }
 
         // Check session on load
-        fetch('/api/files').then(res => {
+        apiFetch('/api/files').then(res => {
             if (res.ok) {
                 document.getElementById('login-overlay').classList.add('hidden');
                 document.getElementById('content').classList.remove('hidden');
                 loadFiles('');
+            } else {
+                fetch('/api/login').then(res => res.json()).then(options => {
+                    if (options.remember_me) document.getElementById('remember-row').classList.remove('hidden');
+                });
             }
         });
     </script>
@@ -632,6 +1970,14 @@ const managerPageHTML = `<!DOCTYPE html>
         button:hover { background: #0056b3; }
         .stat { display: inline-block; margin: 10px 20px 10px 0; }
         .stat-label { font-weight: bold; }
+        .chart { display: flex; align-items: flex-end; gap: 2px; height: 120px; margin-top: 10px; border-bottom: 1px solid #ccc; }
+        .bar { background: #007bff; min-width: 8px; flex: 1; max-width: 40px; }
+        .bar:hover { background: #0056b3; }
+        table.uploaders td, table.uploaders th { padding: 2px 12px 2px 0; text-align: left; }
+        .live { font-size: 0.6em; font-weight: normal; color: #888; margin-left: 10px; }
+        #activity { list-style: none; padding: 0; margin: 0; max-height: 300px; overflow-y: auto; font-size: 0.9em; }
+        #activity li { padding: 3px 0; border-bottom: 1px solid #eee; }
+        #activity .time { color: #888; margin-right: 8px; }
     </style>
 </head>
 <body>
@@ -641,7 +1987,20 @@ const managerPageHTML = `<!DOCTYPE html>
         <h2>Statistics</h2>
         <div class="stat"><span class="stat-label">Total Files:</span> <span id="total-files">-</span></div>
         <div class="stat"><span class="stat-label">Total Size:</span> <span id="total-size">-</span></div>
-        <button onclick="loadStats()">Refresh</button>
+        <div class="stat"><span class="stat-label">Expired, awaiting cleanup:</span> <span id="expired">-</span></div>
+        <div class="stat"><span class="stat-label">Expiring in 24h / 7d:</span> <span id="expiring">-</span></div>
+        <div>
+            From <input type="date" id="stats-from"> to <input type="date" id="stats-to">
+            <button onclick="loadStats()">Refresh</button>
+        </div>
+        <div class="chart" id="day-chart"></div>
+        <h3>Top uploaders</h3>
+        <table class="uploaders"><thead><tr><th>IP</th><th>Uploads</th><th>Size</th></tr></thead><tbody id="uploaders"></tbody></table>
+    </div>
+
+    <div class="section">
+        <h2>Recent Activity <span class="live" id="live-status">connecting...</span></h2>
+        <ul id="activity"></ul>
     </div>
 
     <div class="section">
@@ -654,22 +2013,178 @@ const managerPageHTML = `<!DOCTYPE html>
     <div class="section">
         <h2>Actions</h2>
         <button onclick="cleanupExpired()">Cleanup Expired Files</button>
+        <pre id="cleanup-report"></pre>
     </div>
 
     <script>
+        // Once two-factor authentication is enabled every admin request carries
+        // the current code; prompt for a new one whenever it's rejected
+        async function adminFetch(url, options) {
+            options = options || {};
+            for (;;) {
+                const code = sessionStorage.getItem('totp_code');
+                if (code) options.headers = Object.assign({}, options.headers, { 'X-TOTP-Code': code });
+                const res = await fetch(url, options);
+                if (res.status !== 401) return res;
+                const data = await res.clone().json().catch(() => ({}));
+                if (!data.totp_required) return res;
+                const next = prompt(code ? 'Code rejected or expired. Enter your current two-factor code:' : 'Enter your two-factor code:');
+                if (!next) return res;
+                sessionStorage.setItem('totp_code', next.trim());
+            }
+        }
+
         async function loadStats() {
-            const res = await fetch('/api/admin/stats');
+            const params = new URLSearchParams();
+            const from = document.getElementById('stats-from').value;
+            const to = document.getElementById('stats-to').value;
+            if (from) params.set('from', from);
+            if (to) params.set('to', to);
+            const res = await adminFetch('/api/admin/stats?' + params.toString());
             const data = await res.json();
-            document.getElementById('total-files').textContent = data.total_files;
-            document.getElementById('total-size').textContent = formatSize(data.total_size);
+            showTotals(data.total_files, data.total_size);
+            showExpired(data.expired_files, data.expired_size);
+            document.getElementById('expiring').textContent = data.expiring_24h + ' / ' + data.expiring_7d;
+
+            // One bar per day, scaled to the busiest day
+            const chart = document.getElementById('day-chart');
+            chart.innerHTML = '';
+            const max = Math.max(1, ...data.days.map(d => d.bytes));
+            data.days.forEach(d => {
+                const bar = document.createElement('div');
+                bar.className = 'bar';
+                bar.style.height = Math.max(2, Math.round(d.bytes / max * 100)) + '%';
+                bar.title = d.date + ': ' + d.uploads + ' uploads, ' + formatSize(d.bytes);
+                chart.appendChild(bar);
+            });
+
+            const tbody = document.getElementById('uploaders');
+            tbody.innerHTML = '';
+            data.top_uploaders_by_size.forEach(u => {
+                const row = tbody.insertRow();
+                row.insertCell().textContent = u.ip || '(unknown)';
+                row.insertCell().textContent = u.uploads;
+                row.insertCell().textContent = formatSize(u.bytes);
+            });
         }
 
         async function loadConfig() {
-            const res = await fetch('/api/admin/config');
+            const res = await adminFetch('/api/admin/config');
             const data = await res.json();
             document.getElementById('config-display').textContent = JSON.stringify(data, null, 2);
         }
 
+        async function cleanupExpired() {
+            const preview = await (await adminFetch('/api/admin/cleanup?dry_run=1', { method: 'POST' })).json();
+            const p = preview.report;
+            if (p.files_deleted === 0) {
+                document.getElementById('cleanup-report').textContent = 'No expired files to clean up';
+                return;
+            }
+            if (!confirm('Delete ' + p.files_deleted + ' expired files (' + formatSize(p.bytes_freed) + ')?')) return;
+            const res = await adminFetch('/api/admin/cleanup', { method: 'POST' });
+            const data = await res.json();
+            const r = data.report;
+            let text = 'Deleted ' + r.files_deleted + ' files, freed ' + formatSize(r.bytes_freed);
+            if (r.errors && r.errors.length) text += '\nErrors:\n' + r.errors.join('\n');
+            document.getElementById('cleanup-report').textContent = text;
+            loadStats();
+        }
+
+        // Totals shown, kept current by live events while no date range is set
+        const totals = { files: 0, size: 0 };
+
+        function showTotals(files, size) {
+            totals.files = files;
+            totals.size = size;
+            document.getElementById('total-files').textContent = files;
+            document.getElementById('total-size').textContent = formatSize(size);
+        }
+
+        function showExpired(files, size) {
+            document.getElementById('expired').textContent = files + ' (' + formatSize(size) + ')';
+        }
+
+        function liveTotals() {
+            return !document.getElementById('stats-from').value && !document.getElementById('stats-to').value;
+        }
+
+        function addActivity(time, text) {
+            const list = document.getElementById('activity');
+            const item = document.createElement('li');
+            const stamp = document.createElement('span');
+            stamp.className = 'time';
+            stamp.textContent = new Date(time).toLocaleTimeString();
+            item.appendChild(stamp);
+            item.appendChild(document.createTextNode(text));
+            list.insertBefore(item, list.firstChild);
+            while (list.children.length > 50) list.removeChild(list.lastChild);
+        }
+
+        function handleEvent(ev) {
+            const d = ev.data || {};
+            switch (ev.type) {
+            case 'stats':
+                if (liveTotals()) {
+                    showTotals(d.total_files, d.total_size);
+                    showExpired(d.expired_files, d.expired_size);
+                }
+                break;
+            case 'upload':
+                if (liveTotals()) showTotals(totals.files + 1, totals.size + d.size);
+                addActivity(ev.time, 'Uploaded ' + d.original_name + ' (' + formatSize(d.size) + ') from ' + (d.uploader_ip || 'unknown') + ' as ' + d.file_path);
+                break;
+            case 'delete':
+                if (liveTotals()) showTotals(totals.files - 1, totals.size - d.size);
+                addActivity(ev.time, 'Deleted ' + d.file_path + ' (' + d.original_name + ')');
+                break;
+            case 'bulk_delete':
+                if (liveTotals()) showTotals(totals.files - d.files_deleted, totals.size - d.bytes_freed);
+                addActivity(ev.time, 'Bulk delete removed ' + d.files_deleted + ' files, freed ' + formatSize(d.bytes_freed));
+                break;
+            case 'cleanup':
+                if (liveTotals()) showTotals(totals.files - d.files_deleted, totals.size - d.bytes_freed);
+                addActivity(ev.time, 'Cleanup removed ' + d.files_deleted + ' files, freed ' + formatSize(d.bytes_freed) +
+                    (d.errors ? ' (' + d.errors + ' errors)' : ''));
+                break;
+            }
+        }
+
+        // Follow /api/admin/events. It is read with fetch rather than
+        // EventSource, which can't send the two-factor code. When the stream
+        // ends (the server restarted, or dropped us for falling behind) the
+        // stats are reloaded to catch up and the stream is opened again.
+        async function watchEvents() {
+            const status = document.getElementById('live-status');
+            for (;;) {
+                try {
+                    const res = await adminFetch('/api/admin/events');
+                    if (!res.ok || !res.body) throw new Error('HTTP ' + res.status);
+                    status.textContent = 'live';
+                    const reader = res.body.getReader();
+                    const decoder = new TextDecoder();
+                    let buffer = '';
+                    for (;;) {
+                        const { value, done } = await reader.read();
+                        if (done) break;
+                        buffer += decoder.decode(value, { stream: true });
+                        let end;
+                        while ((end = buffer.indexOf('\n\n')) >= 0) {
+                            const frame = buffer.slice(0, end);
+                            buffer = buffer.slice(end + 2);
+                            const data = frame.split('\n').filter(l => l.startsWith('data: ')).map(l => l.slice(6)).join('\n');
+                            if (data) handleEvent(JSON.parse(data));
+                        }
+                    }
+                } catch (e) {
+                    console.error('Event stream:', e);
+                }
+                status.textContent = 'reconnecting...';
+                await new Promise(resolve => setTimeout(resolve, 5000));
+                loadStats();
+            }
+        }
+
         function showConfigForm() {
             alert('Config editing UI to be implemented');
         }
@@ -682,6 +2197,7 @@ const managerPageHTML = `<!DOCTYPE html>
 
         loadStats();
         loadConfig();
+        watchEvents();
     </script>
 </body>
 </html>`
@@ -0,0 +1,260 @@
+package httpd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"httpserver/server/config"
+	"httpserver/server/naming"
+	"httpserver/server/storage"
+)
+
+// collidingStore reports the first taken paths it is asked about as already
+// stored, as if short random names had collided
+type collidingStore struct {
+	storage.Backend
+	taken   int
+	queried []string
+}
+
+func (c *collidingStore) Exists(relativePath string) (bool, error) {
+	c.queried = append(c.queried, relativePath)
+	if len(c.queried) <= c.taken {
+		return true, nil
+	}
+	return c.Backend.Exists(relativePath)
+}
+
+// useCollidingStore swaps the server's store for one where the first taken
+// generated names collide
+func useCollidingStore(s *Server, taken int) *collidingStore {
+	store := &collidingStore{Backend: s.store, taken: taken}
+	s.SetStorage(store)
+	return store
+}
+
+// uploadRequest builds a multipart upload of content as name
+func uploadRequest(t *testing.T, name, content string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for key, value := range fields {
+		mw.WriteField(key, value)
+	}
+	part, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set(APIKeyHeader, testAPIKey)
+	return r
+}
+
+func shortNames(cfg *config.Config) {
+	cfg.Storage.NameEntropyBytes = naming.MinEntropyBytes
+	cfg.Storage.NameStyle = naming.StyleShort
+}
+
+func TestGenerateFilePathRetriesCollisions(t *testing.T) {
+	s := newTestServer(t, shortNames)
+	store := useCollidingStore(s, 2)
+
+	got, err := s.generateFilePath("photo.png")
+	if err != nil {
+		t.Fatalf("generateFilePath: %v", err)
+	}
+	if len(store.queried) != 3 {
+		t.Fatalf("checked %d names, want 3", len(store.queried))
+	}
+	if got != store.queried[2] {
+		t.Errorf("returned %q, want the first free name %q", got, store.queried[2])
+	}
+	for _, taken := range store.queried[:2] {
+		if taken == got {
+			t.Errorf("returned the colliding name %q", got)
+		}
+	}
+}
+
+func TestGenerateFilePathGivesUp(t *testing.T) {
+	s := newTestServer(t, shortNames)
+	store := useCollidingStore(s, maxNameAttempts)
+
+	if got, err := s.generateFilePath("photo.png"); err == nil || !strings.Contains(err.Error(), "name_entropy_bytes") {
+		t.Fatalf("generateFilePath = %q, %v; want an error suggesting more entropy", got, err)
+	}
+	if len(store.queried) != maxNameAttempts {
+		t.Errorf("checked %d names, want %d", len(store.queried), maxNameAttempts)
+	}
+}
+
+func TestUploadSurvivesNameCollision(t *testing.T) {
+	s := newTestServer(t, shortNames)
+	store := useCollidingStore(s, 1)
+
+	rec := s.serve(uploadRequest(t, "photo.png", "hello", map[string]string{"ttl": "1h"}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if len(store.queried) < 2 || !strings.Contains(rec.Header().Get("Location"), store.queried[1]) {
+		t.Errorf("Location %q, want the second generated name of %v", rec.Header().Get("Location"), store.queried)
+	}
+}
+
+// mp4Payload returns n bytes that start like an MP4 file: an ftyp box, then
+// bytes that differ at every offset so a wrong range shows
+func mp4Payload(n int) []byte {
+	data := make([]byte, n)
+	copy(data, []byte{0, 0, 0, 0x18, 'f', 't', 'y', 'p', 'i', 's', 'o', 'm', 0, 0, 2, 0, 'i', 's', 'o', 'm', 'm', 'p', '4', '1'})
+	for i := 24; i < n; i++ {
+		data[i] = byte(i*7 + i/251)
+	}
+	return data
+}
+
+// uploadFile uploads content as name and returns its download path
+func uploadFile(t *testing.T, s *Server, name string, content []byte) string {
+	t.Helper()
+	rec := s.serve(uploadRequest(t, name, string(content), map[string]string{"ttl": "1h"}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	return rec.Header().Get("Location")
+}
+
+func TestRangedVideoRequests(t *testing.T) {
+	const size = 256 * 1024
+	s := newTestServer(t, nil)
+	payload := mp4Payload(size)
+	filesURL := uploadFile(t, s, "clip.mp4", payload)
+	if !strings.HasPrefix(filesURL, "/files/") {
+		t.Fatalf("Location %q isn't under /files/", filesURL)
+	}
+	routes := map[string]string{
+		"files":     filesURL,
+		"catch-all": strings.TrimPrefix(filesURL, "/files"),
+	}
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"whole file", "", http.StatusOK, "", payload},
+		{"first bytes", "bytes=0-99", http.StatusPartialContent, fmt.Sprintf("bytes 0-99/%d", size), payload[:100]},
+		{"middle", "bytes=100000-100999", http.StatusPartialContent, fmt.Sprintf("bytes 100000-100999/%d", size), payload[100000:101000]},
+		{"open ended", fmt.Sprintf("bytes=%d-", size-10), http.StatusPartialContent, fmt.Sprintf("bytes %d-%d/%d", size-10, size-1, size), payload[size-10:]},
+		{"suffix", "bytes=-500", http.StatusPartialContent, fmt.Sprintf("bytes %d-%d/%d", size-500, size-1, size), payload[size-500:]},
+		{"end past the file", fmt.Sprintf("bytes=%d-%d", size-5, size+1000), http.StatusPartialContent, fmt.Sprintf("bytes %d-%d/%d", size-5, size-1, size), payload[size-5:]},
+		{"unsatisfiable", fmt.Sprintf("bytes=%d-", size), http.StatusRequestedRangeNotSatisfiable, fmt.Sprintf("bytes */%d", size), nil},
+	}
+
+	for route, url := range routes {
+		for _, tt := range tests {
+			t.Run(route+"/"+tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, url, nil)
+				if tt.rangeHeader != "" {
+					r.Header.Set("Range", tt.rangeHeader)
+				}
+				rec := s.serve(r)
+				if rec.Code != tt.status {
+					t.Fatalf("status %d, want %d; body %.200s", rec.Code, tt.status, rec.Body.String())
+				}
+				if got := rec.Header().Get("Content-Range"); got != tt.contentRange {
+					t.Errorf("Content-Range %q, want %q", got, tt.contentRange)
+				}
+				if got := rec.Header().Get("Accept-Ranges"); got != "bytes" {
+					t.Errorf("Accept-Ranges %q, want bytes", got)
+				}
+				if tt.body == nil {
+					return
+				}
+				if got := rec.Header().Get("Content-Type"); got != "video/mp4" {
+					t.Errorf("Content-Type %q, want video/mp4", got)
+				}
+				if got := rec.Header().Get("Content-Length"); got != fmt.Sprint(len(tt.body)) {
+					t.Errorf("Content-Length %s, want %d", got, len(tt.body))
+				}
+				if !bytes.Equal(rec.Body.Bytes(), tt.body) {
+					t.Errorf("body of %d bytes doesn't match the requested range", rec.Body.Len())
+				}
+			})
+		}
+	}
+}
+
+func TestVideoHeadAndMultipleRanges(t *testing.T) {
+	const size = 64 * 1024
+	s := newTestServer(t, nil)
+	payload := mp4Payload(size)
+	filesURL := uploadFile(t, s, "clip.mp4", payload)
+
+	for _, url := range []string{filesURL, strings.TrimPrefix(filesURL, "/files")} {
+		// HEAD advertises ranges and the size, without a body
+		rec := s.serve(httptest.NewRequest(http.MethodHead, url, nil))
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+			t.Errorf("HEAD %s: status %d with %d body bytes", url, rec.Code, rec.Body.Len())
+		}
+		for header, want := range map[string]string{
+			"Accept-Ranges":  "bytes",
+			"Content-Type":   "video/mp4",
+			"Content-Length": fmt.Sprint(size),
+		} {
+			if got := rec.Header().Get(header); got != want {
+				t.Errorf("HEAD %s: %s %q, want %q", url, header, got, want)
+			}
+		}
+
+		// HEAD with a range answers as the GET would
+		r := httptest.NewRequest(http.MethodHead, url, nil)
+		r.Header.Set("Range", "bytes=0-1023")
+		rec = s.serve(r)
+		if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Range") != fmt.Sprintf("bytes 0-1023/%d", size) {
+			t.Errorf("ranged HEAD %s: status %d, Content-Range %q", url, rec.Code, rec.Header().Get("Content-Range"))
+		}
+
+		// Two ranges come back as multipart/byteranges of the right parts
+		r = httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("Range", "bytes=0-9,1000-1009")
+		rec = s.serve(r)
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("GET %s with two ranges: status %d", url, rec.Code)
+		}
+		mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type %q, want multipart/byteranges", rec.Header().Get("Content-Type"))
+		}
+		mr := multipart.NewReader(rec.Body, params["boundary"])
+		for _, want := range [][]byte{payload[0:10], payload[1000:1010]} {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := ioutil.ReadAll(part)
+			if part.Header.Get("Content-Type") != "video/mp4" || !bytes.Equal(got, want) {
+				t.Errorf("part %q of type %q, want %q", got, part.Header.Get("Content-Type"), want)
+			}
+		}
+
+		// A stale If-Range gets the whole file
+		r = httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("Range", "bytes=0-9")
+		r.Header.Set("If-Range", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if rec = s.serve(r); rec.Code != http.StatusOK || rec.Body.Len() != size {
+			t.Errorf("stale If-Range: status %d with %d bytes, want the whole file", rec.Code, rec.Body.Len())
+		}
+	}
+}
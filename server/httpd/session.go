@@ -0,0 +1,274 @@
+package httpd
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"httpserver/server/logging"
+)
+
+// sessionPrefixLen is how much of a session token is shown to admins; it is
+// enough to identify a session for revocation without exposing the token
+const sessionPrefixLen = 8
+
+// CSRFHeader carries the session's CSRF token on state-changing requests that
+// authenticate with the session cookie
+const CSRFHeader = "X-CSRF-Token"
+
+// sessionCookie is the cookie carrying the session token
+const sessionCookie = "session_token"
+
+// sessionCookiePath scopes the session cookie to the API, which is where the
+// list page sends it; file downloads and other pages don't need it
+const sessionCookiePath = "/api"
+
+// session is a logged-in list page session. Each authenticated request moves
+// ExpiresAt to Idle from then, but never past MaxExpiresAt.
+type session struct {
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	MaxExpiresAt time.Time // Zero when there is no absolute cap
+	Idle         time.Duration
+	RemoteIP     string
+	CSRFToken    string
+}
+
+// expiryAfter returns when the session expires if it is used at now
+func (sess *session) expiryAfter(now time.Time) time.Time {
+	expires := now.Add(sess.Idle)
+	if !sess.MaxExpiresAt.IsZero() && expires.After(sess.MaxExpiresAt) {
+		return sess.MaxExpiresAt
+	}
+	return expires
+}
+
+// SessionInfo describes an active session to admins
+type SessionInfo struct {
+	TokenPrefix string    `json:"token_prefix"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	RemoteIP    string    `json:"remote_ip"`
+}
+
+// addSession stores a new session that expires after idle without requests, or
+// absolute after login (0 for no cap), and returns its token
+func (s *Server) addSession(r *http.Request, idle, absolute time.Duration) (string, *session) {
+	token := generateToken()
+	now := time.Now()
+	sess := &session{
+		CreatedAt: now,
+		Idle:      idle,
+		RemoteIP:  getRemoteIP(r),
+		CSRFToken: generateToken(),
+	}
+	if absolute > 0 {
+		sess.MaxExpiresAt = now.Add(absolute)
+	}
+	sess.ExpiresAt = sess.expiryAfter(now)
+
+	s.sessionMux.Lock()
+	s.sessions[token] = sess
+	s.sessionMux.Unlock()
+	return token, sess
+}
+
+// lookupSession returns the unexpired session for token, or nil
+func (s *Server) lookupSession(token string) *session {
+	s.sessionMux.RLock()
+	defer s.sessionMux.RUnlock()
+
+	sess, ok := s.sessions[token]
+	if !ok || time.Now().After(sess.ExpiresAt) {
+		return nil
+	}
+	return sess
+}
+
+// renewSession extends an unexpired session after a request made with it and
+// returns its new expiry; ok is false once it has expired
+func (s *Server) renewSession(token string) (expires time.Time, ok bool) {
+	s.sessionMux.Lock()
+	defer s.sessionMux.Unlock()
+
+	sess, found := s.sessions[token]
+	now := time.Now()
+	if !found || now.After(sess.ExpiresAt) {
+		return time.Time{}, false
+	}
+	sess.ExpiresAt = sess.expiryAfter(now)
+	return sess.ExpiresAt, true
+}
+
+// isHTTPS reports whether r arrived over HTTPS, directly or through a trusted
+// proxy that says so in X-Forwarded-Proto
+func (s *Server) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") && s.isTrustedProxy(r)
+}
+
+// sessionCookieFor returns the session cookie for r with the attributes
+// security.cookie_secure and security.cookie_samesite_strict ask for
+func (s *Server) sessionCookieFor(r *http.Request, token string, maxAge int) *http.Cookie {
+	security := s.cfg().Security
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		MaxAge:   maxAge,
+		Path:     sessionCookiePath,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	switch security.CookieSecure {
+	case "always":
+		cookie.Secure = true
+	case "never":
+	default:
+		cookie.Secure = s.isHTTPS(r)
+	}
+	if security.CookieSameSiteStrict {
+		cookie.SameSite = http.SameSiteStrictMode
+	}
+	return cookie
+}
+
+// setSessionCookie sets the session cookie to last as long as the session
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	maxAge := int((time.Until(expires) + time.Second - 1) / time.Second)
+	if maxAge < 1 {
+		maxAge = 1
+	}
+	http.SetCookie(w, s.sessionCookieFor(r, token, maxAge))
+}
+
+// clearSessionCookie tells the browser to drop the session cookie, including
+// one set with Path=/ before the cookie was scoped to the API
+func (s *Server) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	cookie := s.sessionCookieFor(r, "", -1)
+	http.SetCookie(w, cookie)
+	cookie.Path = "/"
+	http.SetCookie(w, cookie)
+}
+
+// endSession removes the session for token and reports whether there was one
+func (s *Server) endSession(token string) bool {
+	s.sessionMux.Lock()
+	defer s.sessionMux.Unlock()
+
+	_, ok := s.sessions[token]
+	delete(s.sessions, token)
+	return ok
+}
+
+// validCSRF reports whether a cookie-authenticated request may proceed: safe
+// methods always can, anything else must echo the session's CSRF token
+func validCSRF(r *http.Request, sess *session) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	token := r.Header.Get(CSRFHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) == 1
+}
+
+// listSessions returns the unexpired sessions, newest first
+func (s *Server) listSessions() []SessionInfo {
+	s.sessionMux.RLock()
+	defer s.sessionMux.RUnlock()
+
+	now := time.Now()
+	infos := []SessionInfo{}
+	for token, sess := range s.sessions {
+		if now.After(sess.ExpiresAt) {
+			continue
+		}
+		infos = append(infos, SessionInfo{
+			TokenPrefix: token[:sessionPrefixLen],
+			CreatedAt:   sess.CreatedAt,
+			ExpiresAt:   sess.ExpiresAt,
+			RemoteIP:    sess.RemoteIP,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.After(infos[j].CreatedAt) })
+	return infos
+}
+
+// revokeSessions removes the sessions whose token starts with prefix, or every
+// session when prefix is empty, and returns how many were removed. A prefix
+// matching more than one session is rejected so a short prefix can't revoke
+// sessions by accident.
+func (s *Server) revokeSessions(prefix string) (int, error) {
+	s.sessionMux.Lock()
+	defer s.sessionMux.Unlock()
+
+	if prefix == "" {
+		count := len(s.sessions)
+		for token := range s.sessions {
+			delete(s.sessions, token)
+		}
+		return count, nil
+	}
+
+	var matches []string
+	for token := range s.sessions {
+		if strings.HasPrefix(token, prefix) {
+			matches = append(matches, token)
+		}
+	}
+	if len(matches) > 1 {
+		return 0, fmt.Errorf("prefix %q matches %d sessions", prefix, len(matches))
+	}
+	for _, token := range matches {
+		delete(s.sessions, token)
+	}
+	return len(matches), nil
+}
+
+// handleAdminSessions lists active sessions (GET /api/admin/sessions) or revokes
+// them: DELETE /api/admin/sessions/{tokenPrefix} removes one, DELETE
+// /api/admin/sessions removes all.
+func (s *Server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	prefix := ""
+	if i := strings.Index(r.URL.Path, "/sessions/"); i >= 0 {
+		prefix = r.URL.Path[i+len("/sessions/"):]
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if prefix != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":  true,
+			"sessions": s.listSessions(),
+		})
+	case http.MethodDelete:
+		revoked, err := s.revokeSessions(prefix)
+		if err != nil {
+			s.writeJSONError(w, http.StatusConflict, CodeConflict, err.Error())
+			return
+		}
+		if prefix != "" && revoked == 0 {
+			s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "Session not found")
+			return
+		}
+		target := prefix
+		if target == "" {
+			target = "all"
+		}
+		s.audit(r, AuditSessionRevoke, target, true, fmt.Sprintf("%d sessions revoked", revoked))
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"revoked": revoked,
+		})
+		logging.Info("Sessions revoked", logging.Fields{"count": revoked, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
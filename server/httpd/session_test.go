@@ -0,0 +1,454 @@
+package httpd
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"httpserver/server/config"
+)
+
+func TestSessionExpiryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	idle := 10 * time.Minute
+
+	tests := []struct {
+		name string
+		max  time.Time
+		want time.Time
+	}{
+		{"no cap", time.Time{}, now.Add(idle)},
+		{"cap far off", now.Add(time.Hour), now.Add(idle)},
+		{"cap just after the idle expiry", now.Add(idle + time.Nanosecond), now.Add(idle)},
+		{"cap at the idle expiry", now.Add(idle), now.Add(idle)},
+		{"cap just before the idle expiry", now.Add(idle - time.Nanosecond), now.Add(idle - time.Nanosecond)},
+		{"cap reached", now, now},
+		{"cap passed", now.Add(-time.Minute), now.Add(-time.Minute)},
+	}
+
+	for _, tt := range tests {
+		sess := &session{Idle: idle, MaxExpiresAt: tt.max}
+		if got := sess.expiryAfter(now); !got.Equal(tt.want) {
+			t.Errorf("%s: expiryAfter = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// newSession adds a session and returns its token and record
+func newSession(t *testing.T, s *Server, idle, absolute time.Duration) (string, *session) {
+	t.Helper()
+	return s.addSession(httptest.NewRequest(http.MethodPost, "/api/login", nil), idle, absolute)
+}
+
+// near reports whether got is within a second of want, allowing for the time
+// the test takes
+func near(got, want time.Time) bool {
+	d := got.Sub(want)
+	return d > -time.Second && d < time.Second
+}
+
+func TestAddSession(t *testing.T) {
+	s := newTestServer(t, nil)
+	now := time.Now()
+
+	_, sess := newSession(t, s, 10*time.Minute, time.Hour)
+	if !near(sess.ExpiresAt, now.Add(10*time.Minute)) || !near(sess.MaxExpiresAt, now.Add(time.Hour)) {
+		t.Errorf("expires %v, capped at %v; want in 10m and 1h", sess.ExpiresAt, sess.MaxExpiresAt)
+	}
+
+	// An idle time past the cap starts out capped
+	_, sess = newSession(t, s, 2*time.Hour, time.Hour)
+	if !sess.ExpiresAt.Equal(sess.MaxExpiresAt) {
+		t.Errorf("expires %v, after the cap %v", sess.ExpiresAt, sess.MaxExpiresAt)
+	}
+
+	// No absolute timeout, no cap
+	_, sess = newSession(t, s, 10*time.Minute, 0)
+	if !sess.MaxExpiresAt.IsZero() {
+		t.Errorf("capped at %v without an absolute timeout", sess.MaxExpiresAt)
+	}
+}
+
+func TestRenewSession(t *testing.T) {
+	s := newTestServer(t, nil)
+	idle := 10 * time.Minute
+
+	t.Run("slides", func(t *testing.T) {
+		token, sess := newSession(t, s, idle, time.Hour)
+		sess.ExpiresAt = time.Now().Add(time.Second) // Nearly idle out
+		expires, ok := s.renewSession(token)
+		if !ok || !near(expires, time.Now().Add(idle)) || !sess.ExpiresAt.Equal(expires) {
+			t.Errorf("renewSession = %v, %v; want %v from now", expires, ok, idle)
+		}
+	})
+
+	t.Run("stops at the cap", func(t *testing.T) {
+		token, sess := newSession(t, s, idle, time.Hour)
+		sess.MaxExpiresAt = time.Now().Add(2 * time.Minute)
+		expires, ok := s.renewSession(token)
+		if !ok || !expires.Equal(sess.MaxExpiresAt) {
+			t.Errorf("renewSession = %v, %v; want the cap %v", expires, ok, sess.MaxExpiresAt)
+		}
+	})
+
+	t.Run("no cap", func(t *testing.T) {
+		token, sess := newSession(t, s, idle, 0)
+		sess.CreatedAt = time.Now().Add(-24 * 365 * time.Hour)
+		if expires, ok := s.renewSession(token); !ok || !near(expires, time.Now().Add(idle)) {
+			t.Errorf("renewSession = %v, %v; want %v from now", expires, ok, idle)
+		}
+	})
+
+	t.Run("idled out", func(t *testing.T) {
+		token, sess := newSession(t, s, idle, time.Hour)
+		sess.ExpiresAt = time.Now().Add(-time.Millisecond)
+		if expires, ok := s.renewSession(token); ok {
+			t.Errorf("renewed an idle session to %v", expires)
+		}
+		if s.lookupSession(token) != nil {
+			t.Error("lookupSession found an idle session")
+		}
+	})
+
+	t.Run("cap reached", func(t *testing.T) {
+		token, sess := newSession(t, s, idle, time.Hour)
+		sess.MaxExpiresAt = time.Now().Add(-time.Millisecond)
+		sess.ExpiresAt = sess.MaxExpiresAt
+		if expires, ok := s.renewSession(token); ok {
+			t.Errorf("renewed a session past its cap to %v", expires)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if _, ok := s.renewSession("no-such-token"); ok {
+			t.Error("renewed an unknown session")
+		}
+	})
+
+	t.Run("ended", func(t *testing.T) {
+		token, _ := newSession(t, s, idle, time.Hour)
+		if !s.endSession(token) {
+			t.Fatal("endSession found no session")
+		}
+		if _, ok := s.renewSession(token); ok {
+			t.Error("renewed an ended session")
+		}
+	})
+}
+
+// sessionTimeouts sets a 5 minute idle timeout and a 1 hour cap
+func sessionTimeouts(allowRemember bool) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Security.SessionTimeout = 300
+		cfg.Security.SessionAbsoluteTimeout = 3600
+		cfg.Security.AllowRememberMe = allowRemember
+	}
+}
+
+// loginResponse is the body of a successful login
+type loginResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Remember  bool      `json:"remember"`
+	CSRFToken string    `json:"csrf_token"`
+}
+
+// login signs in to the list page and returns the session cookie
+func login(t *testing.T, s *Server, body string) (*http.Cookie, loginResponse) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	rec := s.serve(r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var resp loginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			return c, resp
+		}
+	}
+	t.Fatal("login set no session cookie")
+	return nil, resp
+}
+
+// sessionCookieOf returns the session cookie a response sets
+func sessionCookieOf(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestSessionRenewedByRequests(t *testing.T) {
+	s := newTestServer(t, sessionTimeouts(false))
+	cookie, _ := login(t, s, `{"password":"`+testAdminPass+`"}`)
+	if cookie.MaxAge < 299 || cookie.MaxAge > 300 {
+		t.Errorf("login cookie Max-Age %d, want the 300s idle timeout", cookie.MaxAge)
+	}
+
+	// Most of the idle time passes; a request renews the session and cookie
+	s.lookupSession(cookie.Value).ExpiresAt = time.Now().Add(5 * time.Second)
+	r := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	r.AddCookie(cookie)
+	rec := s.serve(r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("file list: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	renewed := sessionCookieOf(rec)
+	if renewed == nil || renewed.MaxAge < 299 || renewed.MaxAge > 300 {
+		t.Fatalf("renewed cookie %v, want Max-Age 300", renewed)
+	}
+
+	// Near the cap, the cookie only lasts until the cap
+	s.lookupSession(cookie.Value).MaxExpiresAt = time.Now().Add(90 * time.Second)
+	r = httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	r.AddCookie(cookie)
+	renewed = sessionCookieOf(s.serve(r))
+	if renewed == nil || renewed.MaxAge < 89 || renewed.MaxAge > 90 {
+		t.Errorf("cookie near the cap %v, want Max-Age 90", renewed)
+	}
+
+	// Past the cap the session is over
+	sess := s.lookupSession(cookie.Value)
+	sess.MaxExpiresAt = time.Now().Add(-time.Second)
+	sess.ExpiresAt = sess.MaxExpiresAt
+	r = httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	r.AddCookie(cookie)
+	if rec := s.serve(r); rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), CodeSessionExpired) {
+		t.Errorf("after the cap: status %d, body %s; want 401 %s", rec.Code, rec.Body.String(), CodeSessionExpired)
+	}
+}
+
+func TestRememberMe(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *config.Config)
+		body      string
+		remember  bool
+		lasts     time.Duration
+	}{
+		{
+			name:      "remembered",
+			configure: sessionTimeouts(true),
+			body:      `{"password":"` + testAdminPass + `","remember":true}`,
+			remember:  true,
+			lasts:     time.Hour,
+		},
+		{
+			name:      "not asked for",
+			configure: sessionTimeouts(true),
+			body:      `{"password":"` + testAdminPass + `"}`,
+			lasts:     5 * time.Minute,
+		},
+		{
+			name:      "not allowed",
+			configure: sessionTimeouts(false),
+			body:      `{"password":"` + testAdminPass + `","remember":true}`,
+			lasts:     5 * time.Minute,
+		},
+		{
+			name: "no absolute timeout to end it",
+			configure: func(cfg *config.Config) {
+				sessionTimeouts(true)(cfg)
+				cfg.Security.SessionAbsoluteTimeout = 0
+			},
+			body:  `{"password":"` + testAdminPass + `","remember":true}`,
+			lasts: 5 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.configure)
+			cookie, resp := login(t, s, tt.body)
+			if resp.Remember != tt.remember {
+				t.Errorf("remember = %v, want %v", resp.Remember, tt.remember)
+			}
+			if !near(resp.ExpiresAt, time.Now().Add(tt.lasts)) {
+				t.Errorf("expires_at %v, want %v from now", resp.ExpiresAt, tt.lasts)
+			}
+			if want := int(tt.lasts / time.Second); cookie.MaxAge < want-1 || cookie.MaxAge > want {
+				t.Errorf("cookie Max-Age %d, want %d", cookie.MaxAge, want)
+			}
+
+			// A remembered session doesn't idle out before the cap either
+			sess := s.lookupSession(cookie.Value)
+			if tt.remember && sess.Idle != time.Hour {
+				t.Errorf("remembered session idles out after %v, want 1h", sess.Idle)
+			}
+		})
+	}
+}
+
+// attributes splits a Set-Cookie header into its attributes, by lower-case name
+func attributes(header string) map[string]string {
+	attrs := map[string]string{}
+	for i, part := range strings.Split(header, ";") {
+		if i == 0 {
+			continue // name=value
+		}
+		name, value := strings.TrimSpace(part), ""
+		if eq := strings.IndexByte(name, '='); eq >= 0 {
+			name, value = name[:eq], name[eq+1:]
+		}
+		attrs[strings.ToLower(name)] = value
+	}
+	return attrs
+}
+
+// sessionSetCookies returns a response's Set-Cookie headers for the session
+func sessionSetCookies(rec *httptest.ResponseRecorder) []string {
+	var headers []string
+	for _, header := range rec.Header().Values("Set-Cookie") {
+		if strings.HasPrefix(header, sessionCookie+"=") {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// Ways a login request can arrive
+var cookieTransports = []struct {
+	name  string
+	https bool // Whether the server should consider the request HTTPS
+	setup func(r *http.Request)
+}{
+	{"plain http", false, func(r *http.Request) {}},
+	{"tls", true, func(r *http.Request) { r.TLS = &tls.ConnectionState{} }},
+	{"trusted proxy https", true, func(r *http.Request) {
+		r.RemoteAddr = "127.0.0.1:4000"
+		r.Header.Set("X-Forwarded-Proto", "https")
+	}},
+	{"trusted proxy HTTPS", true, func(r *http.Request) {
+		r.RemoteAddr = "[::1]:4000"
+		r.Header.Set("X-Forwarded-Proto", "HTTPS")
+	}},
+	{"trusted proxy http", false, func(r *http.Request) {
+		r.RemoteAddr = "127.0.0.1:4000"
+		r.Header.Set("X-Forwarded-Proto", "http")
+	}},
+	{"untrusted https claim", false, func(r *http.Request) {
+		r.Header.Set("X-Forwarded-Proto", "https")
+	}},
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	for _, secure := range []string{"auto", "always", "never"} {
+		for _, strict := range []bool{false, true} {
+			for _, transport := range cookieTransports {
+				secure, strict, transport := secure, strict, transport
+				name := fmt.Sprintf("secure=%s/strict=%v/%s", secure, strict, transport.name)
+				t.Run(name, func(t *testing.T) {
+					s := newTestServer(t, func(cfg *config.Config) {
+						cfg.Security.CookieSecure = secure
+						cfg.Security.CookieSameSiteStrict = strict
+					})
+					r := loginRequest(testAdminPass)
+					transport.setup(r)
+					rec := s.serve(r)
+					if rec.Code != http.StatusOK {
+						t.Fatalf("login: status %d, body %s", rec.Code, rec.Body.String())
+					}
+					headers := sessionSetCookies(rec)
+					if len(headers) != 1 {
+						t.Fatalf("Set-Cookie headers %q, want one", headers)
+					}
+
+					attrs := attributes(headers[0])
+					wantSecure := secure == "always" || (secure == "auto" && transport.https)
+					if _, got := attrs["secure"]; got != wantSecure {
+						t.Errorf("Secure = %v, want %v in %q", got, wantSecure, headers[0])
+					}
+					wantSameSite := "Lax"
+					if strict {
+						wantSameSite = "Strict"
+					}
+					if attrs["samesite"] != wantSameSite {
+						t.Errorf("SameSite = %q, want %q in %q", attrs["samesite"], wantSameSite, headers[0])
+					}
+					if attrs["path"] != "/api" {
+						t.Errorf("Path = %q, want /api in %q", attrs["path"], headers[0])
+					}
+					if _, ok := attrs["httponly"]; !ok {
+						t.Errorf("no HttpOnly in %q", headers[0])
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestLogoutClearsCookie(t *testing.T) {
+	for _, secure := range []string{"auto", "always", "never"} {
+		t.Run("secure="+secure, func(t *testing.T) {
+			s := newTestServer(t, func(cfg *config.Config) {
+				cfg.Security.CookieSecure = secure
+				cfg.Security.CookieSameSiteStrict = true
+			})
+			cookie, resp := login(t, s, `{"password":"`+testAdminPass+`"}`)
+
+			r := httptest.NewRequest(http.MethodDelete, "/api/login", nil)
+			r.TLS = &tls.ConnectionState{}
+			r.AddCookie(cookie)
+			r.Header.Set(CSRFHeader, resp.CSRFToken)
+			rec := s.serve(r)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("logout: status %d, body %s", rec.Code, rec.Body.String())
+			}
+			if s.lookupSession(cookie.Value) != nil {
+				t.Error("session still active after logout")
+			}
+
+			// Cleared at the API path and at the / path of older cookies,
+			// with the attributes they were set with
+			headers := sessionSetCookies(rec)
+			paths := map[string]bool{}
+			for _, header := range headers {
+				attrs := attributes(header)
+				paths[attrs["path"]] = true
+				if !strings.HasPrefix(header, sessionCookie+"=;") || attrs["max-age"] != "0" {
+					t.Errorf("%q doesn't clear the cookie", header)
+				}
+				if _, got := attrs["secure"]; got != (secure != "never") {
+					t.Errorf("Secure = %v in %q", got, header)
+				}
+				if attrs["samesite"] != "Strict" {
+					t.Errorf("SameSite = %q in %q", attrs["samesite"], header)
+				}
+			}
+			if len(headers) != 2 || !paths["/api"] || !paths["/"] {
+				t.Errorf("Set-Cookie headers %q, want clears at /api and /", headers)
+			}
+		})
+	}
+}
+
+func TestLogoutNeedsCSRFToken(t *testing.T) {
+	s := newTestServer(t, nil)
+	cookie, _ := login(t, s, `{"password":"`+testAdminPass+`"}`)
+
+	r := httptest.NewRequest(http.MethodDelete, "/api/login", nil)
+	r.AddCookie(cookie)
+	if rec := s.serve(r); rec.Code != http.StatusForbidden || len(sessionSetCookies(rec)) != 0 {
+		t.Errorf("logout without the CSRF token: status %d, Set-Cookie %q", rec.Code, rec.Header().Values("Set-Cookie"))
+	}
+	if s.lookupSession(cookie.Value) == nil {
+		t.Error("session ended without the CSRF token")
+	}
+
+	// Without a session there is nothing to protect; the cookie is cleared
+	r = httptest.NewRequest(http.MethodDelete, "/api/login", nil)
+	if rec := s.serve(r); rec.Code != http.StatusOK || len(sessionSetCookies(rec)) != 2 {
+		t.Errorf("logout without a session: status %d, Set-Cookie %q", rec.Code, rec.Header().Values("Set-Cookie"))
+	}
+}
@@ -0,0 +1,55 @@
+package httpd
+
+import (
+	"bytes"
+	_ "embed"
+	"net/http"
+	"time"
+)
+
+var (
+	//go:embed static/favicon.ico
+	faviconICO []byte
+
+	//go:embed static/robots.txt
+	robotsTxt []byte
+
+	//go:embed static/robots-indexing.txt
+	robotsIndexingTxt []byte
+)
+
+// staticModTime is reported as Last-Modified for embedded files
+var staticModTime = time.Now()
+
+// handleRobots serves robots.txt: everything is disallowed unless
+// server.allow_indexing is set, in which case only the API and admin pages are
+func (s *Server) handleRobots(w http.ResponseWriter, r *http.Request) {
+	content := robotsTxt
+	if s.cfg().Server.AllowIndexing {
+		content = robotsIndexingTxt
+	}
+	serveStatic(w, r, "robots.txt", "text/plain; charset=utf-8", content)
+}
+
+// handleFavicon serves the embedded favicon
+func (s *Server) handleFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	serveStatic(w, r, "favicon.ico", "image/x-icon", faviconICO)
+}
+
+// serveStatic serves an embedded file
+func serveStatic(w http.ResponseWriter, r *http.Request, name, contentType string, content []byte) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, name, staticModTime, bytes.NewReader(content))
+}
+
+// setRobotsTag asks crawlers not to index a download unless server.allow_indexing is set
+func (s *Server) setRobotsTag(w http.ResponseWriter) {
+	if !s.cfg().Server.AllowIndexing {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+}
@@ -0,0 +1,216 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+)
+
+// APIKeyHeader carries the legacy API key or a scoped API token
+const APIKeyHeader = "X-API-Key"
+
+// Machine-readable reasons returned when an API key is rejected
+const (
+	ReasonInvalidToken      = "invalid_token"
+	ReasonTokenExpired      = "token_expired"
+	ReasonInsufficientScope = "insufficient_scope"
+)
+
+// legacyKeyScopes are the scopes of auth.api_key, which predates scoped tokens
+var legacyKeyScopes = []string{db.ScopeUpload, db.ScopeDelete}
+
+// authorizeKey checks the request's API key for scope. It returns the HTTP status
+// and reason to reject with, or 0 when the key is allowed.
+func (s *Server) authorizeKey(r *http.Request, scope string) (int, string) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return http.StatusUnauthorized, ReasonInvalidToken
+	}
+	if s.isLegacyAPIKey(key) {
+		for _, granted := range legacyKeyScopes {
+			if granted == scope {
+				return 0, ""
+			}
+		}
+		return http.StatusForbidden, ReasonInsufficientScope
+	}
+
+	token := s.db.LookupAPIToken(key)
+	switch {
+	case token == nil:
+		return http.StatusUnauthorized, ReasonInvalidToken
+	case token.IsExpired():
+		return http.StatusForbidden, ReasonTokenExpired
+	case !token.HasScope(scope):
+		return http.StatusForbidden, ReasonInsufficientScope
+	}
+	return 0, ""
+}
+
+// requireScope checks the request's API key for scope, writing the rejection
+// when it fails
+func (s *Server) requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	status, reason := s.authorizeKey(r, scope)
+	if status == 0 {
+		return true
+	}
+	s.writeKeyError(w, status, reason, scope)
+	return false
+}
+
+// writeKeyError rejects a request whose API key failed authorizeKey
+func (s *Server) writeKeyError(w http.ResponseWriter, status int, reason, scope string) {
+	message := "Invalid or missing API key"
+	switch reason {
+	case ReasonTokenExpired:
+		message = "API token has expired"
+	case ReasonInsufficientScope:
+		message = fmt.Sprintf("API key lacks the %s scope", scope)
+	}
+	s.writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"code":    reason,
+		"message": message,
+		"reason":  reason,
+	})
+}
+
+// checkListAccess admits file list requests from a logged-in session, an API
+// token with the list scope, or the admin
+func (s *Server) checkListAccess(w http.ResponseWriter, r *http.Request) bool {
+	if s.isAdminRequest(r) {
+		return true
+	}
+	if r.Header.Get(APIKeyHeader) != "" {
+		return s.requireScope(w, r, db.ScopeList)
+	}
+	return s.checkSession(w, r)
+}
+
+// tokenReadable reports whether an admin request is one an admin-read token may
+// make: reads that don't expose secrets or the whole database
+func tokenReadable(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.URL.Query().Get("reveal") != "1" &&
+		!strings.HasSuffix(r.URL.Path, "/export")
+}
+
+// tokenIdentity names the key a request carries for the audit trail
+func (s *Server) tokenIdentity(key string) string {
+	if s.isLegacyAPIKey(key) {
+		return "api-key"
+	}
+	if token := s.db.LookupAPIToken(key); token != nil {
+		return "token:" + token.ID
+	}
+	return "invalid-key"
+}
+
+// tokenName returns the name of the API key a request carries, as matched by
+// retention rules: the token's name, "api-key" for auth.api_key, or ""
+func (s *Server) tokenName(key string) string {
+	if s.isLegacyAPIKey(key) {
+		return "api-key"
+	}
+	if token := s.db.LookupAPIToken(key); token != nil {
+		return token.Name
+	}
+	return ""
+}
+
+// handleAdminTokens lists API tokens (GET /api/admin/tokens), mints one (POST
+// /api/admin/tokens {"name","scopes","valid_days","quota_per_day_bytes"}) or
+// revokes one (DELETE /api/admin/tokens/{id})
+func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
+	id := ""
+	if i := strings.Index(r.URL.Path, "/tokens/"); i >= 0 {
+		id = r.URL.Path[i+len("/tokens/"):]
+	}
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		tokens := s.db.ListAPITokens()
+		for i := range tokens {
+			tokens[i].Hash = ""
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"tokens":  tokens,
+		})
+	case r.Method == http.MethodPost && id == "":
+		var req struct {
+			Name      string   `json:"name"`
+			Scopes    []string `json:"scopes"`
+			ValidDays int      `json:"valid_days"`          // 0 never expires
+			Quota     int64    `json:"quota_per_day_bytes"` // 0 uses the global quota, -1 is unlimited
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ValidDays < 0 {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+			return
+		}
+		var expiresAt *time.Time
+		if req.ValidDays > 0 {
+			t := time.Now().AddDate(0, 0, req.ValidDays)
+			expiresAt = &t
+		}
+		key, token, err := s.db.CreateAPIToken(req.Name, req.Scopes, expiresAt, req.Quota)
+		if err != nil {
+			s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		s.audit(r, AuditTokenCreate, token.ID, true, strings.Join(token.Scopes, ","))
+		token.Hash = ""
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"key":     key,
+			"token":   token,
+		})
+		logging.Info("API token created", logging.Fields{"token": token.ID, "scopes": strings.Join(token.Scopes, ","), "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	case r.Method == http.MethodDelete && id != "":
+		removed, err := s.db.RevokeAPIToken(id)
+		if err != nil {
+			s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to revoke token: %v", err))
+			return
+		}
+		if !removed {
+			s.writeJSONError(w, http.StatusNotFound, CodeNotFound, "Token not found")
+			return
+		}
+		s.audit(r, AuditTokenRevoke, id, true, "")
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Token revoked",
+		})
+		logging.Info("API token revoked", logging.Fields{"token": id, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// isAdminRequest reports whether a request carries the admin credentials, and
+// the second factor once it is enabled, or comes from the local CLI
+func (s *Server) isAdminRequest(r *http.Request) bool {
+	if s.isControlRequest(r) {
+		return true
+	}
+	_, valid := s.adminBasicAuth(r)
+	return valid && s.checkTOTP(r)
+}
+
+// includeExpired reads the include_expired=1 override of the file listings,
+// which only the admin may use. It writes the rejection for anyone else.
+func (s *Server) includeExpired(w http.ResponseWriter, r *http.Request) (include, ok bool) {
+	if r.URL.Query().Get("include_expired") != "1" {
+		return false, true
+	}
+	if !s.isAdminRequest(r) {
+		s.writeJSONError(w, http.StatusForbidden, CodeInsufficientScope, "include_expired=1 requires admin credentials")
+		return false, false
+	}
+	return true, true
+}
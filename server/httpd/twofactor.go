@@ -0,0 +1,168 @@
+package httpd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"httpserver/server/logging"
+	"httpserver/server/totp"
+)
+
+// TOTPHeader carries the admin's current two-factor code (or a recovery code)
+// once two-factor authentication is enabled
+const TOTPHeader = "X-TOTP-Code"
+
+// Two-factor enrollment settings
+const (
+	TOTPIssuer        = "HttpServer"
+	TOTPRecoveryCodes = 10
+)
+
+// checkTOTP reports whether the request carries a valid second factor. It always
+// passes while two-factor authentication is disabled. A recovery code is
+// accepted once and then discarded.
+func (s *Server) checkTOTP(r *http.Request) bool {
+	secret := s.cfg().Auth.TOTPSecret
+	if secret == "" {
+		return true
+	}
+	return s.validSecondFactor(r.Header.Get(TOTPHeader))
+}
+
+// validSecondFactor checks a code against the TOTP secret, falling back to the
+// recovery codes
+func (s *Server) validSecondFactor(code string) bool {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false
+	}
+	if totp.Validate(s.cfg().Auth.TOTPSecret, code, time.Now()) {
+		return true
+	}
+
+	s.totpMux.Lock()
+	defer s.totpMux.Unlock()
+	remaining, ok := totp.MatchRecoveryCode(s.cfg().Auth.TOTPRecoveryCodes, code)
+	if !ok {
+		return false
+	}
+	if _, err := s.updateConfig("auth.totp_recovery_codes", strings.Join(remaining, ",")); err != nil {
+		logging.Error("Error discarding used recovery code", logging.Fields{"error": err})
+		return false
+	}
+	logging.Warn("Two-factor recovery code used", logging.Fields{"remaining": len(remaining)})
+	return true
+}
+
+// writeTOTPRequired rejects an admin request that lacks a valid second factor.
+// No WWW-Authenticate header is sent, so browsers keep the basic auth credentials.
+func (s *Server) writeTOTPRequired(w http.ResponseWriter) {
+	s.writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+		"success":       false,
+		"code":          CodeTOTPRequired,
+		"message":       "Two-factor code required",
+		"totp_required": true,
+	})
+}
+
+// handleAdminTwoFactor reports the two-factor status (GET /api/admin/2fa),
+// enables it (POST /api/admin/2fa/enable) or disables it with a currently valid
+// code (POST /api/admin/2fa/disable {"code"})
+func (s *Server) handleAdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/2fa/enable"):
+		s.handleEnableTwoFactor(w, r)
+	case strings.HasSuffix(r.URL.Path, "/2fa/disable"):
+		s.handleDisableTwoFactor(w, r)
+	default:
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":             true,
+			"enabled":             s.cfg().Auth.TOTPSecret != "",
+			"recovery_codes_left": len(s.cfg().Auth.TOTPRecoveryCodes),
+		})
+	}
+}
+
+func (s *Server) handleEnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.totpMux.Lock()
+	defer s.totpMux.Unlock()
+	if s.cfg().Auth.TOTPSecret != "" {
+		s.writeJSONError(w, http.StatusConflict, CodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	enrollment, err := totp.NewEnrollment(TOTPIssuer, s.cfg().Auth.AdminUsername, TOTPRecoveryCodes)
+	if err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, "Failed to generate secret")
+		return
+	}
+	// Recovery codes go first so the secret never takes effect without them
+	if _, err := s.updateConfig("auth.totp_recovery_codes", strings.Join(enrollment.RecoveryHashes, ",")); err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	if _, err := s.updateConfig("auth.totp_secret", enrollment.Secret); err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	s.audit(r, AuditTOTPEnable, s.cfg().Auth.AdminUsername, true, "")
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"enrollment": enrollment,
+	})
+	logging.Info("Two-factor authentication enabled", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
+
+func (s *Server) handleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request: a current two-factor code is required")
+		return
+	}
+	if s.cfg().Auth.TOTPSecret == "" {
+		s.writeJSONError(w, http.StatusConflict, CodeConflict, "Two-factor authentication is not enabled")
+		return
+	}
+	if !s.validSecondFactor(req.Code) {
+		s.audit(r, AuditTOTPDisable, s.cfg().Auth.AdminUsername, false, "invalid code")
+		s.writeJSONError(w, http.StatusForbidden, CodeInvalidTOTP, "Invalid two-factor code")
+		return
+	}
+
+	s.totpMux.Lock()
+	defer s.totpMux.Unlock()
+	if _, err := s.updateConfig("auth.totp_secret", ""); err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	if _, err := s.updateConfig("auth.totp_recovery_codes", ""); err != nil {
+		s.writeJSONError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	s.audit(r, AuditTOTPDisable, s.cfg().Auth.AdminUsername, true, "")
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+	logging.Info("Two-factor authentication disabled", logging.Fields{"ip": getRemoteIP(r), "request_id": RequestID(r)})
+}
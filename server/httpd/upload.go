@@ -0,0 +1,151 @@
+package httpd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"httpserver/server/cleanup"
+	"httpserver/server/storage"
+)
+
+const (
+	// maxFieldSize bounds non-file form fields, which are buffered in memory
+	maxFieldSize = 4 * 1024
+	// multipartOverhead allows for boundaries and small fields on top of the file itself
+	multipartOverhead = 1024 * 1024
+)
+
+// errFileTooLarge is returned when the file part exceeds storage.max_file_size
+var errFileTooLarge = errors.New("file exceeds the maximum allowed size")
+
+// uploadForm holds a streamed multipart upload: the form fields and the file part,
+// which has been written to a temporary file under ImagesDir
+type uploadForm struct {
+	fields   map[string]string
+	fileName string // Filename from the file part's Content-Disposition
+	tempPath string
+	size     int64
+	hash     string
+}
+
+// value returns a form field value
+func (f *uploadForm) value(name string) string {
+	return f.fields[name]
+}
+
+// discard removes the temporary file
+func (f *uploadForm) discard() {
+	if f.tempPath != "" {
+		os.Remove(f.tempPath)
+		f.tempPath = ""
+	}
+}
+
+// readUploadForm streams a multipart request part by part. Fields may arrive before or
+// after the file part; the file is hashed and size-counted while it is copied to a
+// temporary file, so memory use stays flat regardless of upload size.
+func (s *Server) readUploadForm(w http.ResponseWriter, r *http.Request) (*uploadForm, int, error) {
+	maxSize := s.cfg().Storage.MaxFileSize
+	if maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Failed to parse form: %v", err)
+	}
+
+	form := &uploadForm{fields: make(map[string]string)}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			form.discard()
+			return nil, http.StatusBadRequest, fmt.Errorf("Failed to parse form: %v", err)
+		}
+
+		name := part.FormName()
+		switch {
+		case name == "file" && form.tempPath == "":
+			form.fileName = part.FileName()
+			if err := s.receiveFilePart(form, part, maxSize); err != nil {
+				part.Close()
+				form.discard()
+				if errors.Is(err, errFileTooLarge) {
+					return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("File exceeds the maximum size of %s", formatBytes(maxSize))
+				}
+				return nil, http.StatusInternalServerError, fmt.Errorf("Failed to save file: %v", err)
+			}
+		case name != "" && part.FileName() == "":
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFieldSize+1))
+			if err != nil || len(value) > maxFieldSize {
+				part.Close()
+				form.discard()
+				return nil, http.StatusBadRequest, fmt.Errorf("Invalid form field %q", name)
+			}
+			if _, exists := form.fields[name]; !exists {
+				form.fields[name] = strings.TrimSpace(string(value))
+			}
+		}
+		part.Close()
+	}
+
+	if form.tempPath == "" {
+		return nil, http.StatusBadRequest, errors.New("Failed to get file: no file part in request")
+	}
+	if form.fileName == "" {
+		form.fileName = form.value("filename")
+	}
+
+	return form, http.StatusOK, nil
+}
+
+// receiveFilePart copies the file part into a temporary file while hashing it
+func (s *Server) receiveFilePart(form *uploadForm, part io.Reader, maxSize int64) error {
+	tempDir := filepath.Join(s.cfg().Storage.ImagesDir, cleanup.UploadTempDir)
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(tempDir, cleanup.UploadTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	form.tempPath = tmp.Name()
+
+	src := part
+	if maxSize > 0 {
+		// Read one byte past the limit so oversized files can be detected
+		src = io.LimitReader(part, maxSize+1)
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), src)
+	if err == nil {
+		// The file is moved into storage as it is
+		err = tmp.Chmod(storage.FileMode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && size > maxSize {
+		return errFileTooLarge
+	}
+
+	form.size = size
+	form.hash = hex.EncodeToString(hasher.Sum(nil))
+	return nil
+}
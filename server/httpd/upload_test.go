@@ -0,0 +1,127 @@
+package httpd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"httpserver/server/cleanup"
+	"httpserver/server/config"
+	"httpserver/server/storage"
+)
+
+// tempLeftovers returns the temporary files in the upload directory and the
+// OS temp dir, which the test points at an empty directory
+func tempLeftovers(t *testing.T, s *Server, osTemp string) []string {
+	t.Helper()
+	var files []string
+	for _, dir := range []string{filepath.Join(s.cfg().Storage.ImagesDir, cleanup.UploadTempDir), osTemp} {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() {
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return files
+}
+
+// redirectTempDir points the OS temp dir at an empty directory
+func redirectTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	if os.TempDir() != dir {
+		t.Skip("the OS temp dir can't be redirected here")
+	}
+	return dir
+}
+
+func TestUploadLeavesNoTempFiles(t *testing.T) {
+	const oneMB = 1 << 20
+	limit := func(cfg *config.Config) { cfg.Storage.MaxFileSize = 20 * oneMB }
+
+	large := strings.Repeat("x", 12*oneMB) // Well past any in-memory threshold
+	tests := []struct {
+		name    string
+		request func(t *testing.T) *http.Request
+		want    int // 0 for any failure
+	}{
+		{
+			name: "large upload",
+			request: func(t *testing.T) *http.Request {
+				return uploadRequest(t, "large.png", large, map[string]string{"ttl": "1h"})
+			},
+			want: http.StatusCreated,
+		},
+		{
+			name: "too large",
+			request: func(t *testing.T) *http.Request {
+				return uploadRequest(t, "huge.png", strings.Repeat("x", 21*oneMB), map[string]string{"ttl": "1h"})
+			},
+			want: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "rejected after receipt",
+			request: func(t *testing.T) *http.Request {
+				return uploadRequest(t, "large.png", large, map[string]string{"ttl": "forever and a day"})
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "truncated body",
+			request: func(t *testing.T) *http.Request {
+				var body bytes.Buffer
+				mw := multipart.NewWriter(&body)
+				part, _ := mw.CreateFormFile("file", "cut.png")
+				part.Write([]byte(large))
+				// No closing boundary: the client went away mid-upload
+				r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+				r.Header.Set("Content-Type", mw.FormDataContentType())
+				r.Header.Set(APIKeyHeader, testAPIKey)
+				return r
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osTemp := redirectTempDir(t)
+			s := newTestServer(t, limit)
+			rec := s.serve(tt.request(t))
+			if rec.Code != tt.want && (tt.want != 0 || rec.Code < 400) {
+				t.Fatalf("status %d, want %d; body %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if left := tempLeftovers(t, s, osTemp); len(left) != 0 {
+				t.Errorf("temporary files left: %v", left)
+			}
+		})
+	}
+}
+
+func TestUploadedFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes don't reflect access on Windows")
+	}
+	s := newTestServer(t, nil)
+	location := uploadFile(t, s, "photo.png", []byte("hello"))
+
+	stored := filepath.Join(s.cfg().Storage.ImagesDir, filepath.FromSlash(strings.TrimPrefix(location, "/files/")))
+	info, err := os.Stat(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != storage.FileMode {
+		t.Errorf("stored file has mode %04o, want %04o", info.Mode().Perm(), storage.FileMode)
+	}
+}
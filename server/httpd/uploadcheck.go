@@ -0,0 +1,136 @@
+package httpd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/logging"
+)
+
+// uploadCheckRequest asks whether the server already has some content, and
+// with a TTL, to store it again without the bytes being sent
+type uploadCheckRequest struct {
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	TTL      string `json:"ttl"`      // Stores a new file from the existing content when set
+	FileName string `json:"filename"` // Name of the new file; the existing file's when empty
+	Slug     string `json:"slug"`
+	Tag      string `json:"tag"`
+}
+
+// validSHA256 reports whether hash is a lowercase hex SHA-256
+func validSHA256(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// findContent returns a live, unexpired file with the given content, or nil
+func (s *Server) findContent(hash string, size int64) *db.FileMetadata {
+	files, err := s.db.GetFilesByHash(hash)
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	for _, meta := range files {
+		if meta.FileSize == size && !meta.IsExpired(now) {
+			return meta
+		}
+	}
+	return nil
+}
+
+// handleUploadCheck answers POST /upload/check: whether content with the given
+// sha256 and size is stored. Given a TTL, a hit is stored again as a new file
+// from the server's own copy, answered like an upload, so a client with the
+// same content never has to send it. It takes an upload-scoped API key, since
+// it tells key holders which contents the server has.
+func (s *Server) handleUploadCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireScope(w, r, db.ScopeUpload) {
+		return
+	}
+
+	var req uploadCheckRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFieldSize)).Decode(&req); err != nil {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request")
+		return
+	}
+	req.SHA256 = strings.ToLower(req.SHA256)
+	if !validSHA256(req.SHA256) || req.Size < 0 {
+		s.writeJSONError(w, http.StatusBadRequest, CodeBadRequest, "sha256 must be 64 hex digits and size a byte count")
+		return
+	}
+
+	source := s.findContent(req.SHA256, req.Size)
+	if source == nil || req.TTL == "" {
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"exists":  source != nil,
+		})
+		return
+	}
+
+	// Storing a copy is an upload in all but the transfer
+	if s.refuseIfReadOnly(w) {
+		return
+	}
+	if maxSize := s.cfg().Storage.MaxFileSize; maxSize > 0 && req.Size > maxSize {
+		s.writeJSONError(w, http.StatusRequestEntityTooLarge, CodeFileTooLarge, fmt.Sprintf("File exceeds the maximum size of %s", formatBytes(maxSize)))
+		return
+	}
+	quotas := s.uploadQuotas(r)
+	if !s.checkQuotas(w, quotas, req.Size) {
+		return
+	}
+	if !s.acquireSlot(w, r, s.uploads, "uploads") {
+		return
+	}
+	defer s.uploads.release()
+
+	fileName := req.FileName
+	if fileName == "" {
+		fileName = source.OriginalName
+	}
+	fields := map[string]string{"ttl": req.TTL, "slug": req.Slug, "tag": req.Tag}
+	params, ok := s.parseUploadParams(w, r, fields, fileName, req.Size)
+	if !ok {
+		return
+	}
+
+	// Copy the stored content into a temporary file, hashing it on the way so
+	// a damaged or vanished copy is never handed out
+	form := &uploadForm{fields: fields, fileName: fileName}
+	defer form.discard()
+	if err := s.copyStoredContent(form, source.FilePath); err != nil || form.hash != req.SHA256 || form.size != req.Size {
+		logging.Warn("Stored content no longer matches its hash", logging.Fields{"path": source.FilePath, "request_id": RequestID(r), "error": err})
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"exists":  false,
+		})
+		return
+	}
+	if s.storeUpload(w, r, form, params, quotas) {
+		logging.Info("Upload served from stored content", logging.Fields{"source": source.FilePath, "ip": getRemoteIP(r), "request_id": RequestID(r)})
+	}
+}
+
+// copyStoredContent copies a stored file into form's temporary file
+func (s *Server) copyStoredContent(form *uploadForm, relativePath string) error {
+	src, err := s.store.Get(relativePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return s.receiveFilePart(form, src, 0)
+}
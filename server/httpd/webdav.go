@@ -0,0 +1,379 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/naming"
+)
+
+// davPrefix is the mount point of the read-only WebDAV interface
+const davPrefix = "/dav"
+
+// davMaxBody bounds PROPFIND request bodies
+const davMaxBody = 64 * 1024
+
+// davProps are the DAV: properties reported for every resource, in response order
+var davProps = []string{"displayname", "resourcetype", "creationdate", "getlastmodified", "getcontentlength", "getcontenttype", "getetag"}
+
+// davResource is a collection (the root or a date directory) or a file, as
+// derived from the metadata database. Files appear directly in their date
+// directory whatever storage.path_layout nests them under, since a Depth 1
+// listing may only hold direct children.
+type davResource struct {
+	path       string // Relative path without slashes at either end; "" for the root
+	filePath   string // Stored path of a file, which differs from path in nested layouts
+	collection bool
+	size       int64
+	created    time.Time
+	modified   time.Time
+	etag       string
+}
+
+// href returns the escaped URL of the resource; collections end with a slash
+func (res davResource) href() string {
+	p := davPrefix + "/" + res.path
+	if res.collection && res.path != "" {
+		p += "/"
+	}
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// prop returns the XML content of a DAV: property, or false if the resource
+// doesn't have it
+func (res davResource) prop(name string) (string, bool) {
+	switch name {
+	case "displayname":
+		if res.path == "" {
+			return "", true
+		}
+		return xmlEscape(path.Base(res.path)), true
+	case "resourcetype":
+		if res.collection {
+			return "<D:collection/>", true
+		}
+		return "", true
+	case "creationdate":
+		if res.created.IsZero() {
+			return "", false
+		}
+		return res.created.UTC().Format(time.RFC3339), true
+	case "getlastmodified":
+		if res.modified.IsZero() {
+			return "", false
+		}
+		return res.modified.UTC().Format(http.TimeFormat), true
+	case "getcontentlength":
+		if res.collection {
+			return "", false
+		}
+		return strconv.FormatInt(res.size, 10), true
+	case "getcontenttype":
+		if res.collection {
+			return "", false
+		}
+		return xmlEscape(contentType(res.path)), true
+	case "getetag":
+		if res.collection {
+			return "", false
+		}
+		return xmlEscape(res.etag), true
+	}
+	return "", false
+}
+
+// davPropfind is a PROPFIND request body. An empty body means allprop.
+type davPropfind struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+// davAuthorize checks a WebDAV request's basic auth. WebDAV clients can't send
+// a second factor, so the admin credentials only work while two-factor
+// authentication is off. An API token with the list scope, given as the
+// password with any user name, always works: the view exposes no more than
+// the file list.
+func (s *Server) davAuthorize(r *http.Request) (presented, valid bool) {
+	_, password, ok := r.BasicAuth()
+	if !ok {
+		return false, false
+	}
+	if token := s.db.LookupAPIToken(password); token != nil && !token.IsExpired() && token.HasScope(db.ScopeList) {
+		return true, true
+	}
+	if s.cfg().Auth.TOTPSecret != "" {
+		return true, false
+	}
+	return s.adminBasicAuth(r)
+}
+
+// handleDAV serves a read-only WebDAV view of the stored files, organised by
+// date directory, for mounting in Finder or Explorer. Listings come from the
+// metadata database, so trashed and expired files are hidden. See davAuthorize
+// for the credentials it takes.
+func (s *Server) handleDAV(w http.ResponseWriter, r *http.Request) {
+	if presented, valid := s.davAuthorize(r); !valid {
+		if presented {
+			s.audit(r, AuditAdminLoginFailure, r.URL.Path, false, "")
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="Admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	relativePath := strings.Trim(strings.TrimPrefix(r.URL.Path, davPrefix), "/")
+	if strings.Contains(relativePath, "..") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("DAV", "1")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+		w.WriteHeader(http.StatusOK)
+	case "PROPFIND":
+		s.handleDAVPropfind(w, r, relativePath)
+	case http.MethodGet, http.MethodHead:
+		res, found := s.davResolve(relativePath)
+		if !found {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		if res.collection {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveFile(w, r, res.filePath)
+	case http.MethodPut, http.MethodDelete, http.MethodPost, "MKCOL", "PROPPATCH", "COPY", "MOVE", "LOCK", "UNLOCK":
+		http.Error(w, "WebDAV access is read-only", http.StatusForbidden)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDAVPropfind answers PROPFIND with depth 0 (the resource) or 1 (the
+// resource and its children)
+func (s *Server) handleDAVPropfind(w http.ResponseWriter, r *http.Request, relativePath string) {
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		// Depth infinity (also the default) would walk every file
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, xml.Header+`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+		return
+	}
+
+	var req davPropfind
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, davMaxBody))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Invalid PROPFIND body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, found := s.davResolve(relativePath)
+	if !found {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	resources := []davResource{res}
+	if depth == "1" && res.collection {
+		resources = append(resources, s.davChildren(res)...)
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(davMultistatus(resources, &req))
+}
+
+// davMultistatus renders the PROPFIND response for the given resources
+func davMultistatus(resources []davResource, req *davPropfind) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<D:multistatus xmlns:D="DAV:">`)
+	for _, res := range resources {
+		b.WriteString("<D:response><D:href>" + xmlEscape(res.href()) + "</D:href>")
+
+		var found, missing bytes.Buffer
+		switch {
+		case req.PropName != nil:
+			for _, name := range davProps {
+				if _, ok := res.prop(name); ok {
+					found.WriteString("<D:" + name + "/>")
+				}
+			}
+		case req.Prop != nil:
+			for _, n := range req.Prop.Names {
+				value, ok := "", false
+				if n.XMLName.Space == "DAV:" {
+					value, ok = res.prop(n.XMLName.Local)
+				}
+				if ok {
+					found.WriteString("<D:" + n.XMLName.Local + ">" + value + "</D:" + n.XMLName.Local + ">")
+				} else {
+					missing.WriteString(davEmptyElement(n.XMLName))
+				}
+			}
+		default:
+			for _, name := range davProps {
+				if value, ok := res.prop(name); ok {
+					found.WriteString("<D:" + name + ">" + value + "</D:" + name + ">")
+				}
+			}
+		}
+
+		if found.Len() > 0 {
+			b.WriteString("<D:propstat><D:prop>" + found.String() + "</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>")
+		}
+		if missing.Len() > 0 {
+			b.WriteString("<D:propstat><D:prop>" + missing.String() + "</D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>")
+		}
+		b.WriteString("</D:response>")
+	}
+	b.WriteString("</D:multistatus>")
+	return b.Bytes()
+}
+
+// davEmptyElement renders an empty element for a requested property, declaring
+// its namespace when it isn't DAV:
+func davEmptyElement(name xml.Name) string {
+	switch name.Space {
+	case "DAV:":
+		return "<D:" + name.Local + "/>"
+	case "":
+		return "<" + name.Local + "/>"
+	default:
+		return "<" + name.Local + ` xmlns="` + xmlEscape(name.Space) + `"/>`
+	}
+}
+
+// davResolve looks up the root, a date directory or a live file, given as
+// YYYYMMDD/<name>
+func (s *Server) davResolve(relativePath string) (davResource, bool) {
+	if relativePath == "" {
+		return davResource{collection: true}, true
+	}
+	parts := strings.Split(relativePath, "/")
+	if len(parts) > 2 {
+		return davResource{}, false
+	}
+	date := parts[0]
+	if len(parts) == 1 {
+		files := s.davFiles(date)
+		if len(files) == 0 {
+			return davResource{}, false
+		}
+		return davDateCollection(date, files), true
+	}
+
+	// In the daily layout the DAV path is the stored path; otherwise the file
+	// is found among its date's by name
+	if meta, _ := s.db.GetFileMetadata(relativePath); meta != nil && davVisible(meta, time.Now()) {
+		if res := davFile(meta); res.path == relativePath {
+			return res, true
+		}
+	}
+	for _, meta := range s.davFiles(date) {
+		if res := davFile(meta); res.path == relativePath {
+			return res, true
+		}
+	}
+	return davResource{}, false
+}
+
+// davChildren lists the date directories of the root or the files of a date directory
+func (s *Server) davChildren(parent davResource) []davResource {
+	var children []davResource
+	if parent.path == "" {
+		dates, _ := s.db.ListAllDates(false)
+		for _, date := range dates {
+			if files := s.davFiles(date); len(files) > 0 {
+				children = append(children, davDateCollection(date, files))
+			}
+		}
+		return children
+	}
+
+	for _, meta := range s.davFiles(parent.path) {
+		children = append(children, davFile(meta))
+	}
+	return children
+}
+
+// davFiles returns the visible files of a date directory
+func (s *Server) davFiles(date string) []*db.FileMetadata {
+	files, _ := s.db.ListFilesByDate(date, false)
+	now := time.Now()
+	visible := files[:0]
+	for _, meta := range files {
+		if davVisible(meta, now) {
+			visible = append(visible, meta)
+		}
+	}
+	return visible
+}
+
+// davVisible reports whether a file is live and unexpired
+func davVisible(meta *db.FileMetadata, now time.Time) bool {
+	return !meta.IsDeleted() && (meta.IsPinned() || meta.ExpiresAt.After(now))
+}
+
+// davDateCollection describes a date directory, dated by its oldest and newest uploads
+func davDateCollection(date string, files []*db.FileMetadata) davResource {
+	res := davResource{path: date, collection: true}
+	for _, meta := range files {
+		if res.created.IsZero() || meta.UploadedAt.Before(res.created) {
+			res.created = meta.UploadedAt
+		}
+		if meta.UploadedAt.After(res.modified) {
+			res.modified = meta.UploadedAt
+		}
+	}
+	return res
+}
+
+// davFile describes a stored file, placed in its date directory
+func davFile(meta *db.FileMetadata) davResource {
+	etag := meta.Hash
+	if etag == "" {
+		etag = strconv.FormatInt(meta.UploadedAt.Unix(), 36) + "-" + strconv.FormatInt(meta.FileSize, 36)
+	}
+	filePath := path.Clean(strings.ReplaceAll(meta.FilePath, "\\", "/"))
+	return davResource{
+		path:     naming.ParseDateFromPath(filePath) + "/" + path.Base(filePath),
+		filePath: filePath,
+		size:     meta.FileSize,
+		created:  meta.UploadedAt,
+		modified: meta.UploadedAt,
+		etag:     `"` + etag + `"`,
+	}
+}
+
+// xmlEscape escapes text for use in XML content or attribute values
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,286 @@
+package httpd
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// PROPFIND bodies as sent by the clients the WebDAV view is meant for
+const (
+	// macOS Finder (WebDAVFS) listing a directory or statting a file
+	finderPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:">
+<D:prop>
+<D:getlastmodified/>
+<D:getcontentlength/>
+<D:creationdate/>
+<D:resourcetype/>
+</D:prop>
+</D:propfind>
+`
+	// Finder checking the free space of the mount
+	finderQuotaPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:">
+<D:prop>
+<D:quota-available-bytes/>
+<D:quota-used-bytes/>
+<D:quota/>
+<D:quotaused/>
+</D:prop>
+</D:propfind>
+`
+	// Windows Mini-Redirector listing a folder in Explorer
+	windowsPropfind = `<?xml version="1.0" encoding="utf-8" ?><D:propfind xmlns:D="DAV:"><D:prop xmlns:Z="urn:schemas-microsoft-com:"><D:creationdate/><D:displayname/><D:getcontentlength/><D:getcontenttype/><D:getetag/><D:getlastmodified/><D:resourcetype/><Z:Win32CreationTime/><Z:Win32LastAccessTime/><Z:Win32LastModifiedTime/><Z:Win32FileAttributes/></D:prop></D:propfind>`
+	// Windows Mini-Redirector mapping a drive
+	windowsAllprop = `<?xml version="1.0" encoding="utf-8" ?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`
+)
+
+// davProp is a property of a PROPFIND response
+type davProp struct {
+	XMLName    xml.Name
+	Value      string    `xml:",chardata"`
+	Collection *struct{} `xml:"DAV: collection"`
+}
+
+// davResult is one resource of a multistatus response: the properties found,
+// the names of those reported missing, and whether its resourcetype is a
+// collection
+type davResult struct {
+	found      map[string]string
+	missing    []string
+	collection bool
+}
+
+// propfind sends a PROPFIND as the admin and returns the multistatus response
+// by href
+func propfind(t *testing.T, s *Server, target, depth, body string) map[string]davResult {
+	t.Helper()
+	r := httptest.NewRequest("PROPFIND", target, strings.NewReader(body))
+	r.SetBasicAuth(testAdminUser, testAdminPass)
+	r.Header.Set("Depth", depth)
+	r.Header.Set("Content-Type", "text/xml")
+	rec := s.serve(r)
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND %s: status %d, body %s", target, rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("PROPFIND %s: Content-Type %q", target, ct)
+	}
+
+	var ms struct {
+		XMLName   xml.Name `xml:"DAV: multistatus"`
+		Responses []struct {
+			Href     string `xml:"DAV: href"`
+			Propstat []struct {
+				Prop struct {
+					Props []davProp `xml:",any"`
+				} `xml:"DAV: prop"`
+				Status string `xml:"DAV: status"`
+			} `xml:"DAV: propstat"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &ms); err != nil {
+		t.Fatalf("PROPFIND %s: response isn't a multistatus: %v\n%s", target, err, rec.Body.String())
+	}
+
+	results := make(map[string]davResult)
+	for _, resp := range ms.Responses {
+		result := davResult{found: make(map[string]string)}
+		for _, ps := range resp.Propstat {
+			for _, p := range ps.Prop.Props {
+				name := p.XMLName.Local
+				if p.XMLName.Space != "DAV:" {
+					name = p.XMLName.Space + " " + name
+				}
+				switch ps.Status {
+				case "HTTP/1.1 200 OK":
+					result.found[name] = p.Value
+					if name == "resourcetype" && p.Collection != nil {
+						result.collection = true
+					}
+				case "HTTP/1.1 404 Not Found":
+					result.missing = append(result.missing, name)
+				default:
+					t.Errorf("%s: unexpected propstat status %q", resp.Href, ps.Status)
+				}
+			}
+		}
+		results[resp.Href] = result
+	}
+	return results
+}
+
+// hrefs returns the hrefs of a multistatus response
+func hrefs(results map[string]davResult) []string {
+	var list []string
+	for href := range results {
+		list = append(list, href)
+	}
+	return list
+}
+
+func TestWebDAVPropfind(t *testing.T) {
+	s := newTestServer(t, nil)
+	content := "hello, webdav"
+	location := uploadFile(t, s, "notes.txt", []byte(content))
+	stored := strings.TrimPrefix(location, "/files/")
+	date := path.Dir(stored)
+	fileHref := davPrefix + "/" + stored
+	dirHref := davPrefix + "/" + date + "/"
+	rootHref := davPrefix + "/"
+
+	t.Run("Finder lists a directory", func(t *testing.T) {
+		results := propfind(t, s, dirHref, "1", finderPropfind)
+		if len(results) != 2 {
+			t.Fatalf("got responses for %q, want the directory and its file", hrefs(results))
+		}
+
+		dir := results[dirHref]
+		if !dir.collection {
+			t.Error("directory resourcetype isn't a collection")
+		}
+		for _, name := range []string{"getlastmodified", "creationdate"} {
+			if dir.found[name] == "" {
+				t.Errorf("directory has no %s", name)
+			}
+		}
+		if len(dir.missing) != 1 || dir.missing[0] != "getcontentlength" {
+			t.Errorf("directory missing %q, want only getcontentlength", dir.missing)
+		}
+
+		file := results[fileHref]
+		if file.found["getcontentlength"] != strconv.Itoa(len(content)) {
+			t.Errorf("file getcontentlength = %q, want %d", file.found["getcontentlength"], len(content))
+		}
+		if _, ok := file.found["resourcetype"]; !ok || file.collection {
+			t.Errorf("file resourcetype present %v, collection %v; want an empty resourcetype", ok, file.collection)
+		}
+		if _, err := http.ParseTime(file.found["getlastmodified"]); err != nil {
+			t.Errorf("file getlastmodified %q isn't an HTTP date: %v", file.found["getlastmodified"], err)
+		}
+		if len(file.missing) != 0 {
+			t.Errorf("file missing %q, want every requested property", file.missing)
+		}
+	})
+
+	t.Run("Finder stats a file", func(t *testing.T) {
+		results := propfind(t, s, fileHref, "0", finderPropfind)
+		file, ok := results[fileHref]
+		if len(results) != 1 || !ok {
+			t.Fatalf("got responses for %q, want only %s", hrefs(results), fileHref)
+		}
+		if file.found["getcontentlength"] != strconv.Itoa(len(content)) {
+			t.Errorf("getcontentlength = %q, want %d", file.found["getcontentlength"], len(content))
+		}
+	})
+
+	t.Run("Finder asks for quota", func(t *testing.T) {
+		results := propfind(t, s, rootHref, "0", finderQuotaPropfind)
+		root := results[rootHref]
+		if len(root.found) != 0 || len(root.missing) != 4 {
+			t.Errorf("root found %v and missing %q, want all four quota properties missing", root.found, root.missing)
+		}
+	})
+
+	t.Run("Windows lists the root", func(t *testing.T) {
+		results := propfind(t, s, rootHref, "1", windowsPropfind)
+		if len(results) != 2 {
+			t.Fatalf("got responses for %q, want the root and the date directory", hrefs(results))
+		}
+		root, dir := results[rootHref], results[dirHref]
+		if !root.collection || !dir.collection {
+			t.Errorf("root collection %v, directory collection %v; want both", root.collection, dir.collection)
+		}
+		if dir.found["displayname"] != date {
+			t.Errorf("directory displayname = %q, want %q", dir.found["displayname"], date)
+		}
+
+		// Microsoft's properties come back as missing, in their own namespace
+		const ms = "urn:schemas-microsoft-com: "
+		for _, name := range []string{"Win32CreationTime", "Win32LastAccessTime", "Win32LastModifiedTime", "Win32FileAttributes"} {
+			if !contains(dir.missing, ms+name) {
+				t.Errorf("directory missing %q, want %s", dir.missing, name)
+			}
+		}
+	})
+
+	t.Run("Windows lists a directory", func(t *testing.T) {
+		results := propfind(t, s, dirHref, "1", windowsPropfind)
+		file := results[fileHref]
+		if file.found["displayname"] != path.Base(stored) {
+			t.Errorf("displayname = %q, want %q", file.found["displayname"], path.Base(stored))
+		}
+		if !strings.HasPrefix(file.found["getcontenttype"], "text/plain") {
+			t.Errorf("getcontenttype = %q, want text/plain", file.found["getcontenttype"])
+		}
+		if etag := file.found["getetag"]; !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+			t.Errorf("getetag = %q, want a quoted entity tag", etag)
+		}
+	})
+
+	for name, body := range map[string]string{"allprop": windowsAllprop, "empty body": ""} {
+		t.Run("Windows "+name+" on a file", func(t *testing.T) {
+			results := propfind(t, s, fileHref, "0", body)
+			file := results[fileHref]
+			for _, prop := range davProps {
+				if _, ok := file.found[prop]; !ok {
+					t.Errorf("%s missing from allprop", prop)
+				}
+			}
+			if len(file.missing) != 0 {
+				t.Errorf("allprop reported %q missing", file.missing)
+			}
+		})
+
+		t.Run("Windows "+name+" on a directory", func(t *testing.T) {
+			results := propfind(t, s, dirHref, "0", body)
+			if len(results) != 1 {
+				t.Fatalf("got responses for %q, want only the directory at depth 0", hrefs(results))
+			}
+			dir := results[dirHref]
+			if _, ok := dir.found["getcontentlength"]; ok {
+				t.Error("a directory reported getcontentlength")
+			}
+			if !dir.collection {
+				t.Error("directory resourcetype isn't a collection")
+			}
+		})
+	}
+
+	t.Run("depth 1 on a file", func(t *testing.T) {
+		results := propfind(t, s, fileHref, "1", finderPropfind)
+		if _, ok := results[fileHref]; len(results) != 1 || !ok {
+			t.Errorf("got responses for %q, want only the file", hrefs(results))
+		}
+	})
+}
+
+func TestWebDAVPropfindDepthInfinity(t *testing.T) {
+	s := newTestServer(t, nil)
+	for _, depth := range []string{"", "infinity"} {
+		r := httptest.NewRequest("PROPFIND", davPrefix+"/", strings.NewReader(finderPropfind))
+		r.SetBasicAuth(testAdminUser, testAdminPass)
+		if depth != "" {
+			r.Header.Set("Depth", depth)
+		}
+		rec := s.serve(r)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "propfind-finite-depth") {
+			t.Errorf("Depth %q: status %d, body %s; want 403 propfind-finite-depth", depth, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
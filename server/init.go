@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"httpserver/server/config"
+	"httpserver/server/db"
+)
+
+// initSecret is how init arrived at a credential, which decides what the
+// summary shows of it
+type initSecret int
+
+const (
+	secretKept      initSecret = iota // Already set, left alone
+	secretEntered                     // Typed at the prompt or given as a flag
+	secretGenerated                   // Random; shown once in the summary
+)
+
+// initPrompter asks for settings on the terminal, or takes them from flags
+// and defaults when there is no terminal to ask on
+type initPrompter struct {
+	in          *bufio.Reader
+	interactive bool
+}
+
+// ask prompts for a setting, offering current; a value that fails validation is
+// asked for again
+func (p *initPrompter) ask(key, question, current string) string {
+	for {
+		fmt.Printf("%s [%s]: ", question, current)
+		answer, err := p.in.ReadString('\n')
+		if err != nil && answer == "" {
+			log.Fatalf("No answer for %s: %v", key, err)
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return current
+		}
+		if err := config.ValidateValue(key, answer); err != nil {
+			fmt.Printf("  %v\n", err)
+			continue
+		}
+		return answer
+	}
+}
+
+// askSecret prompts for a credential without echoing it; an empty answer
+// returns ""
+func (p *initPrompter) askSecret(question string) string {
+	fmt.Printf("%s: ", question)
+	if err := setEcho(os.Stdin, false); err == nil {
+		defer func() {
+			setEcho(os.Stdin, true)
+			fmt.Println()
+		}()
+	}
+	answer, err := p.in.ReadString('\n')
+	if err != nil && answer == "" {
+		log.Fatalf("No answer: %v", err)
+	}
+	return strings.TrimSpace(answer)
+}
+
+// setting picks a plain setting: the flag if given, else the answer to the
+// prompt, else the stored value
+func (p *initPrompter) setting(key, question, flagValue, current string) string {
+	if flagValue != "" {
+		if err := config.ValidateValue(key, flagValue); err != nil {
+			log.Fatalf("Invalid value: %v", err)
+		}
+		return flagValue
+	}
+	if p.interactive {
+		return p.ask(key, question, current)
+	}
+	return current
+}
+
+// password picks a credential. One that is already set is kept unless a new
+// value is given; a default one is replaced by the flag, the answer to the
+// prompt, or a random value.
+func (p *initPrompter) password(key, label, flagName, flagValue, current string, generate bool) (string, initSecret) {
+	if flagValue != "" {
+		if err := config.ValidateValue(key, flagValue); err != nil {
+			log.Fatalf("Invalid value: %v", err)
+		}
+		return flagValue, secretEntered
+	}
+	isDefault := current == db.DefaultConfigValue(key)
+	if p.interactive && !generate {
+		question := label + " (Enter generates one)"
+		if !isDefault {
+			question = label + " (Enter keeps the current one)"
+		}
+		if answer := p.askSecret(question); answer != "" {
+			return answer, secretEntered
+		}
+	}
+	if !isDefault {
+		return current, secretKept
+	}
+	if !p.interactive && !generate {
+		log.Fatalf("%s is still the default: pass --%s or --generate-secrets", key, flagName)
+	}
+	secret, err := randomSecret(16)
+	if err != nil {
+		log.Fatalf("Failed to generate %s: %v", key, err)
+	}
+	return secret, secretGenerated
+}
+
+func handleInitCommand(args []string, dbPath string) {
+	flagSet := flag.NewFlagSet("init", flag.ExitOnError)
+	flagPort := flagSet.String("port", "", "Port to listen on")
+	flagImagesDir := flagSet.String("images-dir", "", "Directory uploaded files are stored in")
+	flagAdminUser := flagSet.String("admin-user", "", "Admin username")
+	flagAdminPassword := flagSet.String("admin-password", "", "Admin password (visible in the process list)")
+	flagListPassword := flagSet.String("list-password", "", "File list password (visible in the process list)")
+	flagGenerate := flagSet.Bool("generate-secrets", false, "Generate random passwords instead of asking for them")
+	flagForce := flagSet.Bool("force", false, "Run again on a database that has already been set up")
+	flagSet.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: httpserver init [-c <path>] [--port <port>] [--images-dir <dir>] [--admin-user <name>]")
+		fmt.Fprintln(os.Stderr, "                       [--admin-password <pw>] [--list-password <pw>] [--generate-secrets] [--force]")
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args[1:])
+	if flagSet.NArg() > 0 {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
+	database, err := db.Open(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		log.Fatalf("Failed to open database: %v (stop the server before running init)", err)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	// Any credential changed from its default means someone has set this up already
+	initialized := false
+	for _, key := range append([]string{"auth.admin_username"}, credentialKeys...) {
+		if database.GetConfig(key) != db.DefaultConfigValue(key) {
+			initialized = true
+		}
+	}
+	if initialized && !*flagForce {
+		log.Fatalf("This database has already been set up; run init with --force to go through it again (existing credentials are kept unless you replace them)")
+	}
+
+	p := &initPrompter{in: bufio.NewReader(os.Stdin), interactive: isTerminal(os.Stdin)}
+	if p.interactive {
+		fmt.Println("Setting up the server. Press Enter to accept the value in brackets.")
+	}
+
+	port := p.setting("server.port", "Port", *flagPort, database.GetConfig("server.port"))
+	imagesDir := p.setting("storage.images_dir", "Storage directory", *flagImagesDir, database.GetConfig("storage.images_dir"))
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		log.Fatalf("Failed to create the storage directory: %v", err)
+	}
+	adminUser := p.setting("auth.admin_username", "Admin username", *flagAdminUser, database.GetConfig("auth.admin_username"))
+	adminPassword, adminHow := p.password("auth.admin_password", "Admin password", "admin-password", *flagAdminPassword, database.GetConfig("auth.admin_password"), *flagGenerate)
+	listPassword, listHow := p.password("auth.list_password", "File list password", "list-password", *flagListPassword, database.GetConfig("auth.list_password"), *flagGenerate)
+
+	// The API key is always random; one already set is kept
+	apiKey, keyHow := database.GetConfig("auth.api_key"), secretKept
+	if apiKey == db.DefaultConfigValue("auth.api_key") {
+		if apiKey, err = randomSecret(24); err != nil {
+			log.Fatalf("Failed to generate an API key: %v", err)
+		}
+		keyHow = secretGenerated
+	}
+
+	settings := []struct{ key, value string }{
+		{"server.port", port},
+		{"storage.images_dir", imagesDir},
+		{"auth.admin_username", adminUser},
+		{"auth.admin_password", adminPassword},
+		{"auth.list_password", listPassword},
+		{"auth.api_key", apiKey},
+	}
+	for _, setting := range settings {
+		if err := config.ValidateValue(setting.key, setting.value); err != nil {
+			log.Fatalf("Invalid value: %v", err)
+		}
+	}
+	for _, setting := range settings {
+		if err := database.SetConfig(setting.key, setting.value); err != nil {
+			log.Fatalf("Failed to set config: %v", err)
+		}
+	}
+
+	describe := func(value string, how initSecret) string {
+		switch how {
+		case secretGenerated:
+			return value + " (generated; shown only now)"
+		case secretEntered:
+			return "(as entered)"
+		}
+		return "(unchanged)"
+	}
+	command := "httpserver"
+	if dbPath != getDefaultDBPath() {
+		command += " -c " + dbPath
+	}
+	serverURL := "http://localhost:" + port
+	keyText := apiKey
+	if keyHow == secretKept {
+		keyText = "<api-key>"
+	}
+
+	fmt.Println()
+	fmt.Println("Setup complete:")
+	fmt.Printf("  Port:               %s\n", port)
+	fmt.Printf("  Storage directory:  %s\n", imagesDir)
+	fmt.Printf("  Admin username:     %s\n", adminUser)
+	fmt.Printf("  Admin password:     %s\n", describe(adminPassword, adminHow))
+	fmt.Printf("  File list password: %s\n", describe(listPassword, listHow))
+	fmt.Printf("  API key:            %s\n", describe(apiKey, keyHow))
+	fmt.Println()
+	fmt.Println("Start the server:")
+	fmt.Printf("  %s start\n", command)
+	fmt.Println("Upload with curl:")
+	fmt.Printf("  curl -H 'X-API-Key: %s' -F file=@photo.jpg %s/upload\n", keyText, serverURL)
+	fmt.Println("Or with the client:")
+	fmt.Printf("  http-cli config set server %s\n", serverURL)
+	fmt.Printf("  http-cli config set auth %s\n", keyText)
+	fmt.Println("  http-cli photo.jpg")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"httpserver/server/config"
+	"httpserver/server/logging"
+)
+
+// setupLogFile sends log output to the rotating file named by logging.file,
+// also copying it to stdout when logging.stdout is set. SIGHUP reopens the file
+// so logrotate can move it away. It returns nil when no file is configured.
+func setupLogFile(cfg *config.Config) (*logging.RotatingWriter, error) {
+	if cfg.Logging.File == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Logging.File), 0755); err != nil {
+		return nil, err
+	}
+	w, err := logging.NewRotatingWriter(cfg.Logging.File, cfg.Logging.MaxSizeMB, cfg.Logging.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Logging.Stdout {
+		log.SetOutput(io.MultiWriter(w, os.Stdout))
+	} else {
+		log.SetOutput(w)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := w.Reopen(); err != nil {
+				log.SetOutput(os.Stderr)
+				log.Printf("Error reopening log file: %v", err)
+				continue
+			}
+			log.Printf("Reopened log file %s", cfg.Logging.File)
+		}
+	}()
+
+	return w, nil
+}
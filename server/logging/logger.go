@@ -0,0 +1,173 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels
+const (
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Fields are structured values attached to a log entry, e.g. path, size or ip
+type Fields map[string]interface{}
+
+var (
+	mu       sync.Mutex
+	jsonMode bool
+	out      io.Writer // Destination of JSON entries
+)
+
+// SetFormat selects "text" (the default) or "json" output. In json mode the
+// standard logger is wrapped as well, so plain log.Printf lines from elsewhere
+// become JSON entries too. It should be called once, after the log output is set.
+func SetFormat(format string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if format != "json" || jsonMode {
+		return
+	}
+	jsonMode = true
+	out = log.Writer()
+	log.SetFlags(0)
+	log.SetOutput(plainWriter{})
+}
+
+// Info logs an informational entry
+func Info(msg string, fields Fields) {
+	write(LevelInfo, msg, fields)
+}
+
+// Warn logs a warning
+func Warn(msg string, fields Fields) {
+	write(LevelWarn, msg, fields)
+}
+
+// Error logs an error
+func Error(msg string, fields Fields) {
+	write(LevelError, msg, fields)
+}
+
+// entry is one line of JSON output
+type entry struct {
+	TS     string                 `json:"ts"`
+	Level  string                 `json:"level"`
+	Msg    string                 `json:"msg"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// write emits an entry in the current format
+func write(level, msg string, fields Fields) {
+	mu.Lock()
+	isJSON := jsonMode
+	mu.Unlock()
+
+	if !isJSON {
+		log.Print(formatText(level, msg, fields))
+		return
+	}
+	writeJSON(level, msg, fields)
+}
+
+// writeJSON emits an entry as a JSON line
+func writeJSON(level, msg string, fields Fields) {
+	e := entry{
+		TS:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level: level,
+		Msg:   msg,
+	}
+	if len(fields) > 0 {
+		e.Fields = make(map[string]interface{}, len(fields))
+		for k, v := range fields {
+			e.Fields[k] = jsonValue(v)
+		}
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		line, _ = json.Marshal(entry{TS: e.TS, Level: level, Msg: msg})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	out.Write(append(line, '\n'))
+}
+
+// jsonValue converts values that don't marshal usefully; durations become seconds
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case error:
+		return x.Error()
+	case time.Duration:
+		return x.Seconds()
+	}
+	return v
+}
+
+// formatText renders an entry the way the server has always logged: the
+// message, then ": <error>" if there is one, then the other fields as key=value
+func formatText(level, msg string, fields Fields) string {
+	var b strings.Builder
+	if level == LevelWarn {
+		b.WriteString("Warning: ")
+	}
+	b.WriteString(msg)
+	if err, ok := fields["error"]; ok && err != nil {
+		fmt.Fprintf(&b, ": %v", err)
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if k != "error" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(" ")
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(textValue(fields[k]))
+	}
+	return b.String()
+}
+
+// textValue formats a field value, quoting it if it contains spaces
+func textValue(v interface{}) string {
+	if d, ok := v.(time.Duration); ok && d > time.Millisecond {
+		v = d.Round(time.Millisecond)
+	}
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// plainWriter turns lines from the standard logger into JSON entries, guessing
+// their level from the usual "Warning:"/"Error" prefixes
+type plainWriter struct{}
+
+func (plainWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := LevelInfo
+	switch {
+	case strings.HasPrefix(msg, "Warning: "):
+		level = LevelWarn
+		msg = strings.TrimPrefix(msg, "Warning: ")
+	case strings.HasPrefix(msg, "Error") || strings.HasPrefix(msg, "Failed"):
+		level = LevelError
+	}
+	writeJSON(level, msg, nil)
+	return len(p), nil
+}
@@ -0,0 +1,124 @@
+// Package logging provides the server's log output: leveled text or JSON
+// entries with structured fields, and a size-rotated log file.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingWriter is an io.Writer appending to a file that is rolled over to
+// file.1, file.2, ... once it reaches a size limit. It is safe for concurrent use.
+type RotatingWriter struct {
+	path       string
+	maxSize    int64 // Bytes; 0 means never rotate
+	maxBackups int   // Rolled-over files to keep
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingWriter opens (or creates) the log file at path. Files are rotated
+// when a write would take them past maxSizeMB, keeping maxBackups old files.
+func NewRotatingWriter(path string, maxSizeMB, maxBackups int) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends p to the log file, rotating first if p would not fit. A single
+// write larger than the limit still goes into one file.
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, fmt.Errorf("log file %s is closed", w.path)
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the log file, for use after an external tool such
+// as logrotate has moved it away
+func (w *RotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return w.open()
+}
+
+// Close closes the log file
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open opens the log file for appending (caller must hold mu, or own w)
+func (w *RotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// rotate shifts file.N-1 to file.N (dropping the oldest), moves the current
+// file to file.1 and starts a new one (caller must hold mu)
+func (w *RotatingWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	if w.maxBackups > 0 {
+		os.Remove(w.backupPath(w.maxBackups))
+		for i := w.maxBackups - 1; i >= 1; i-- {
+			os.Rename(w.backupPath(i), w.backupPath(i+1))
+		}
+		if err := os.Rename(w.path, w.backupPath(1)); err != nil && !os.IsNotExist(err) {
+			// Keep logging to the old file rather than losing output
+			w.open()
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else {
+		os.Remove(w.path)
+	}
+	return w.open()
+}
+
+// backupPath returns the name of the n-th rolled-over file
+func (w *RotatingWriter) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", w.path, n)
+}
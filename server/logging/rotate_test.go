@@ -0,0 +1,189 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestWriter opens a writer in a temporary directory with a limit in bytes
+// rather than MB
+func newTestWriter(t *testing.T, maxSize int64, maxBackups int) *RotatingWriter {
+	t.Helper()
+	w, err := NewRotatingWriter(filepath.Join(t.TempDir(), "server.log"), 0, maxBackups)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter: %v", err)
+	}
+	w.maxSize = maxSize
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return string(b)
+}
+
+func writeString(t *testing.T, w *RotatingWriter, s string) {
+	t.Helper()
+	if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+}
+
+func TestRotateAtSizeBoundary(t *testing.T) {
+	w := newTestWriter(t, 10, 3)
+
+	// Exactly filling the limit doesn't rotate
+	writeString(t, w, "12345")
+	writeString(t, w, "67890")
+	if _, err := os.Stat(w.backupPath(1)); !os.IsNotExist(err) {
+		t.Fatalf("rotated before the file passed the limit")
+	}
+
+	// One more byte does, and goes into the new file
+	writeString(t, w, "x")
+	if got := readFile(t, w.backupPath(1)); got != "1234567890" {
+		t.Errorf("backup holds %q", got)
+	}
+	if got := readFile(t, w.path); got != "x" {
+		t.Errorf("current file holds %q", got)
+	}
+}
+
+func TestOversizedWriteStaysInOneFile(t *testing.T) {
+	w := newTestWriter(t, 10, 2)
+	long := strings.Repeat("a", 25)
+	writeString(t, w, long)
+	if got := readFile(t, w.path); got != long {
+		t.Errorf("current file holds %q, want the whole write", got)
+	}
+	writeString(t, w, "b")
+	if got := readFile(t, w.backupPath(1)); got != long {
+		t.Errorf("backup holds %q", got)
+	}
+}
+
+func TestRotateKeepsMaxBackups(t *testing.T) {
+	w := newTestWriter(t, 4, 2)
+	for _, s := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		writeString(t, w, s)
+	}
+	if got := readFile(t, w.path); got != "dddd" {
+		t.Errorf("current file holds %q", got)
+	}
+	if got := readFile(t, w.backupPath(1)); got != "cccc" {
+		t.Errorf("file.1 holds %q", got)
+	}
+	if got := readFile(t, w.backupPath(2)); got != "bbbb" {
+		t.Errorf("file.2 holds %q", got)
+	}
+	if _, err := os.Stat(w.backupPath(3)); !os.IsNotExist(err) {
+		t.Errorf("file.3 exists beyond max_backups")
+	}
+}
+
+func TestRotateWithoutBackupsTruncates(t *testing.T) {
+	w := newTestWriter(t, 4, 0)
+	writeString(t, w, "aaaa")
+	writeString(t, w, "bb")
+	if got := readFile(t, w.path); got != "bb" {
+		t.Errorf("current file holds %q", got)
+	}
+	if _, err := os.Stat(w.backupPath(1)); !os.IsNotExist(err) {
+		t.Errorf("a backup was kept with max_backups 0")
+	}
+}
+
+func TestExistingFileCountsTowardsLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := ioutil.WriteFile(path, []byte("12345678"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewRotatingWriter(path, 0, 1)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter: %v", err)
+	}
+	defer w.Close()
+	w.maxSize = 10
+
+	writeString(t, w, "abc")
+	if got := readFile(t, w.backupPath(1)); got != "12345678" {
+		t.Errorf("backup holds %q, want the earlier contents", got)
+	}
+}
+
+func TestReopenAfterExternalMove(t *testing.T) {
+	w := newTestWriter(t, 0, 0)
+	writeString(t, w, "before\n")
+	moved := w.path + ".moved"
+	if err := os.Rename(w.path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reopen(); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	writeString(t, w, "after\n")
+	if got := readFile(t, moved); got != "before\n" {
+		t.Errorf("moved file holds %q", got)
+	}
+	if got := readFile(t, w.path); got != "after\n" {
+		t.Errorf("reopened file holds %q", got)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	w := newTestWriter(t, 0, 0)
+	w.Close()
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
+
+func TestConcurrentWritesRotateWithoutLosingLines(t *testing.T) {
+	const writers, lines = 16, 200
+	w := newTestWriter(t, 2048, 1000)
+
+	var wg sync.WaitGroup
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				fmt.Fprintf(w, "writer %02d line %03d\n", g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Every line lands whole in exactly one file, each file within the limit
+	seen := make(map[string]bool)
+	files, _ := filepath.Glob(w.path + "*")
+	for _, path := range files {
+		b := []byte(readFile(t, path))
+		if int64(len(b)) > w.maxSize {
+			t.Errorf("%s is %d bytes, over the limit", filepath.Base(path), len(b))
+		}
+		for _, line := range bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n")) {
+			if len(line) != len("writer 00 line 000") || seen[string(line)] {
+				t.Fatalf("%s holds a torn or repeated line %q", filepath.Base(path), line)
+			}
+			seen[string(line)] = true
+		}
+	}
+	if len(seen) != writers*lines {
+		t.Errorf("found %d lines, want %d", len(seen), writers*lines)
+	}
+	if len(files) < 2 {
+		t.Errorf("no rotation happened across %d files", len(files))
+	}
+}
@@ -1,40 +1,81 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"httpserver/server/cleanup"
 	"httpserver/server/config"
 	"httpserver/server/db"
+	"httpserver/server/events"
 	"httpserver/server/httpd"
+	"httpserver/server/logging"
+	"httpserver/server/notify"
+	"httpserver/server/replication"
+	"httpserver/server/resumable"
 	"httpserver/server/service"
+	"httpserver/server/storage"
 )
 
+// Set at build time with -ldflags "-X main.version=... -X main.buildTime=... -X main.gitCommit=..."
 var (
-	version = "1.0.0"
+	version   = "1.0.0"
+	buildTime = ""
+	gitCommit = ""
 )
 
 func main() {
 	// Parse command line arguments
 	args := os.Args[1:]
 
-	// Check for subcommands (set, get, start)
-	if len(args) > 0 {
-		switch args[0] {
+	// Check for subcommands (init, doctor, set, get, unset, reset, export, import, admin, start); -c may
+	// appear before or after the database subcommands
+	if dbFlag, rest := extractDBFlag(args); len(rest) > 0 {
+		switch rest[0] {
+		case "init":
+			handleInitCommand(rest, resolveDBPath(dbFlag))
+			return
+		case "doctor":
+			handleDoctorCommand(rest, resolveDBPath(dbFlag))
+			return
 		case "set":
-			handleSetCommand(args)
+			handleSetCommand(rest, resolveDBPath(dbFlag))
 			return
 		case "get":
-			handleGetCommand(args)
+			handleGetCommand(rest, resolveDBPath(dbFlag))
+			return
+		case "unset":
+			handleUnsetCommand(rest, resolveDBPath(dbFlag))
+			return
+		case "reset":
+			handleResetCommand(rest, resolveDBPath(dbFlag))
+			return
+		case "export":
+			handleExportCommand(rest, resolveDBPath(dbFlag))
+			return
+		case "import":
+			handleImportCommand(rest, resolveDBPath(dbFlag))
 			return
+		case "admin":
+			handleAdminCommand(rest, resolveDBPath(dbFlag))
+			return
+		}
+	}
+	if len(args) > 0 {
+		switch args[0] {
 		case "start":
 			// Remove "start" from args and continue to server start
 			args = args[1:]
@@ -43,23 +84,37 @@ func main() {
 	}
 
 	// Define command line flags (for start command)
-	flagInstall := flag.Bool("i", false, "Install as systemd service (Linux only)")
-	flagUninstall := flag.Bool("u", false, "Uninstall systemd service (Linux only)")
+	flagInstall := flag.Bool("i", false, "Install as a service (systemd on Linux, launchd on macOS, the SCM on Windows)")
+	flagUninstall := flag.Bool("u", false, "Uninstall the system service")
 	flagPort := flag.Int("p", 0, "Port to listen on (overrides config)")
 	flagConfig := flag.String("c", "", "Path to database file")
-	flagNoRestart := flag.Bool("no-restart", false, "Disable auto restart (ignored on Windows)")
+	flagNoRestart := flag.Bool("no-restart", false, "Install the service without auto restart")
+	flagUser := flag.Bool("user", false, "Install or uninstall a per-user service (systemd user unit)")
+	flagSystem := flag.Bool("system", false, "Install or uninstall a system-wide service (launchd LaunchDaemon)")
+	flagPersistEnv := flag.Bool("persist-env", false, "Save HTTPSERVER_* environment overrides to the database")
+	flagRecover := flag.Bool("recover", false, "Salvage a corrupt database instead of refusing to start")
+	flagForce := flag.Bool("force", false, "Start even if another instance seems to be running")
+	flagGenerateKey := flag.Bool("generate-key", false, "Replace the default API key with a random one and print it")
 	flagVersion := flag.Bool("v", false, "Show version information")
 	flagHelp := flag.Bool("h", false, "Show help information")
 
 	flag.Parse()
 
-	// Suppress unused warning (used in service installation)
-	_ = flagNoRestart
+	// Per-user or system-wide service; the default depends on the platform
+	userService := service.DefaultUserInstall
+	if *flagUser {
+		userService = true
+	} else if *flagSystem {
+		userService = false
+	}
 
 	// Show version
 	if *flagVersion {
 		fmt.Printf("HTTP Image Hosting Server v%s\n", version)
 		fmt.Printf("Built for %s/%s\n", runtime.GOOS, runtime.GOARCH)
+		if gitCommit != "" || buildTime != "" {
+			fmt.Printf("Commit %s, built %s\n", gitCommit, buildTime)
+		}
 		return
 	}
 
@@ -71,28 +126,65 @@ func main() {
 
 	// Handle service uninstall
 	if *flagUninstall {
-		if err := service.Uninstall(); err != nil {
+		if err := service.Uninstall(userService); err != nil {
 			log.Fatalf("Failed to uninstall service: %v", err)
 		}
 		return
 	}
 
 	// Determine database path
-	dbPath := *flagConfig
-	if dbPath == "" {
-		dbPath = getDefaultDBPath()
-	}
+	dbPath := resolveDBPath(*flagConfig)
 
 	// Open database (must be opened first to get config)
 	database, err := db.Open(dbPath)
+	var corruptErr *db.CorruptError
+	recovered := false
+	if errors.As(err, &corruptErr) {
+		if !*flagRecover {
+			printCorruptHelp(dbPath, corruptErr)
+			os.Exit(1)
+		}
+		recoverDatabase(dbPath)
+		recovered = true
+		database, err = db.Open(dbPath)
+	}
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
+	if err := database.CheckDriver(); err != nil {
+		database.Close()
+		log.Fatalf("Failed to open database: %v", err)
+	}
 	defer database.Close()
 
+	// Apply environment overrides, saving them only when asked to
+	overrides := envOverrides(database)
+	logEnvOverrides(overrides)
+	if *flagPersistEnv {
+		for key, value := range overrides {
+			if err := database.SetConfig(key, value); err != nil {
+				log.Printf("Warning: failed to save %s to database: %v", key, err)
+			}
+		}
+	}
+
 	// Build config from database
 	cfg := buildConfigFromDB(database)
 
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up storage: %v", err)
+	}
+
+	// Records lost with the corrupt file are rebuilt from what's stored
+	if recovered {
+		rebuilt, err := cleanup.RebuildRecords(database, store)
+		if err != nil {
+			log.Printf("Warning: rebuilding file records from %s storage stopped early: %v", store.Name(), err)
+		}
+		log.Printf("Rebuilt %d file records from %s storage (they never expire until updated)", rebuilt, store.Name())
+	}
+
 	// Override port from command line
 	if *flagPort > 0 {
 		cfg.Server.Port = *flagPort
@@ -115,53 +207,235 @@ func main() {
 		if *flagPort > 0 {
 			serviceCfg.Server.Port = *flagPort
 		}
+		if *flagNoRestart {
+			serviceCfg.AutoRestart.Enabled = false
+		}
 
-		if err := service.Install(serviceCfg, execPath); err != nil {
+		// Release the database lock so the service started by Install can open it
+		database.Close()
+
+		if err := service.Install(serviceCfg, execPath, dbPath, userService); err != nil {
 			log.Fatalf("Failed to install service: %v", err)
 		}
 		return
 	}
 
+	// Switch to the log file before anything else is logged
+	logFile, err := setupLogFile(cfg)
+	if err != nil {
+		log.Printf("Warning: failed to open log file %s: %v", cfg.Logging.File, err)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+	logging.SetFormat(cfg.Logging.Format)
+
+	// Credentials every install shares: offer to replace the API key, then warn
+	// about whatever is left (or refuse to start, if configured to)
+	if _, fromEnv := overrides["auth.api_key"]; !fromEnv && cfg.Auth.APIKey == db.DefaultConfigValue("auth.api_key") {
+		if *flagGenerateKey || (!service.IsService() && isTerminal(os.Stdin) &&
+			confirm("The API key is still the default. Generate a random one?")) {
+			key, err := generateAPIKey(database)
+			if err != nil {
+				log.Fatalf("Failed to generate an API key: %v", err)
+			}
+			cfg.Auth.APIKey = key
+		}
+	}
+	if defaults := defaultCredentials(cfg); len(defaults) > 0 {
+		warnDefaultCredentials(defaults, *flagConfig)
+		if cfg.Security.RequireNonDefaultCredentials {
+			log.Fatalf("Refusing to start with default credentials (security.require_non_default_credentials is set)")
+		}
+	}
+
+	// Refuse to run alongside another instance
+	pidPath := pidFilePath(dbPath, cfg)
+	if !*flagForce {
+		if err := checkRunningInstance(pidPath, cfg); err != nil {
+			log.Fatalf("%v (use --force to start anyway)", err)
+		}
+	}
+	if err := writePIDFile(pidPath); err != nil {
+		log.Printf("Warning: failed to write PID file: %v", err)
+	}
+	defer removePIDFile(pidPath)
+
 	// Ensure directories exist
 	if err := config.EnsureDirectories(cfg); err != nil {
 		log.Fatalf("Failed to create directories: %v", err)
 	}
 
+	// Start webhook notifier
+	notifier := notify.NewNotifier(&notify.Config{
+		WebhookURL:    cfg.Notifications.WebhookURL,
+		WebhookSecret: cfg.Notifications.WebhookSecret,
+		Events:        cfg.Notifications.Events,
+	})
+	notifier.Start()
+	defer notifier.Stop()
+
+	// Start replicating changes to the standby server, if one is configured
+	replicator := replication.NewReplicator(&replication.Config{
+		TargetURL: cfg.Replication.TargetURL,
+		APIKey:    cfg.Replication.APIKey,
+	}, database, store)
+	replicator.Start()
+	defer replicator.Stop()
+
+	// Live activity for the admin dashboard
+	hub := events.NewHub()
+
 	// Start cleanup manager
 	cleanupMgr := cleanup.NewCleanupManager(&cleanup.Config{
-		ImagesDir:       cfg.Storage.ImagesDir,
-		CleanupInterval: cfg.Storage.CleanupInterval,
-	}, database)
+		CleanupInterval:      cfg.Storage.CleanupInterval,
+		ExpiryWarningHours:   cfg.Notifications.ExpiryWarningHours,
+		TrashRetentionHours:  cfg.Storage.TrashRetentionHours,
+		OrphanGraceHours:     cfg.Storage.OrphanGraceHours,
+		VerifyReadRateMB:     cfg.Storage.VerifyReadRateMB,
+		BackupIntervalHours:  cfg.Backup.IntervalHours,
+		BackupDir:            cfg.Backup.Dir,
+		BackupKeepCount:      cfg.Backup.KeepCount,
+		ResumableDir:         filepath.Join(cfg.Storage.ImagesDir, cleanup.UploadTempDir, resumable.DirName),
+		ResumableExpiryHours: cfg.Storage.ResumableExpiryHours,
+		UploadTempDir:        filepath.Join(cfg.Storage.ImagesDir, cleanup.UploadTempDir),
+	}, database, store)
+	cleanupMgr.SetNotifier(notifier)
+	cleanupMgr.SetEvents(hub)
+	cleanupMgr.SetReplicator(replicator)
 	cleanupMgr.Start()
 	defer cleanupMgr.Stop()
 
 	// Create and start HTTP server
 	server := httpd.NewServer(cfg, database)
+	server.SetVersion(version)
+	server.SetBuildInfo(buildTime, gitCommit)
+	server.SetNotifier(notifier)
+	server.SetEvents(hub)
+	server.SetReplicator(replicator)
+	server.SetCleanupManager(cleanupMgr)
+	server.SetStorage(store)
+	server.SetOnReady(func() {
+		if err := service.NotifyReady(); err != nil {
+			log.Printf("Warning: failed to notify systemd: %v", err)
+		}
+	})
+
+	// Bind now so the actual addresses (and ports picked for port 0) are known
+	if err := server.Listen(); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+	addrs := boundAddresses(server)
+	if httpAddr := server.HTTPAddr(); httpAddr != nil {
+		// HTTPS with ACME; local commands use the plain HTTP listener
+		printHTTPSListening(cfg.Server.ACMEDomains, cfg.Server.HTTPSPort)
+		addrs = []config.ListenAddress{{Network: httpAddr.Network(), Address: httpAddr.String()}}
+	} else {
+		printListening(addrs)
+	}
 
-	// Handle shutdown gracefully
-	go handleShutdown(server, cleanupMgr)
+	// Let local config commands reach this server instead of the locked database
+	if token, err := newControlToken(); err == nil {
+		server.SetControlToken(token)
+		if err := writeControlFile(dbPath, addrs, token); err != nil {
+			log.Printf("Warning: failed to write control file: %v", err)
+		}
+		defer removeControlFile(dbPath)
+	}
+
+	// Handle shutdown gracefully; under the Windows service manager, stop requests
+	// take the place of signals
+	if service.IsService() {
+		go func() {
+			if err := service.Run(func() { shutdown(server, cleanupMgr, database, dbPath, pidPath) }); err != nil {
+				log.Printf("Service error: %v", err)
+			}
+			os.Exit(0)
+		}()
+	} else {
+		go handleShutdown(server, cleanupMgr, database, dbPath, pidPath)
+	}
 
 	// Start server
-	if err := server.Start(); err != nil {
+	if err := server.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server error: %v", err)
 	}
+	// Shutting down; the shutdown handler exits once the database is saved
+	select {}
+}
+
+// printCorruptHelp explains how to get going again after Open found a damaged database
+func printCorruptHelp(dbPath string, err *db.CorruptError) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+	fmt.Fprintln(os.Stderr, "The server will not start on a damaged database, since starting empty would drop")
+	fmt.Fprintln(os.Stderr, "every file record and reset the configuration, including passwords. Either:")
+	fmt.Fprintf(os.Stderr, "  - copy a backup (see backup.dir, by default %s) over %s, or\n",
+		filepath.Join(filepath.Dir(dbPath), "backups"), dbPath)
+	fmt.Fprintln(os.Stderr, "  - start with --recover to move the damaged file aside, salvage what can be read,")
+	fmt.Fprintln(os.Stderr, "    and rebuild missing file records from the images directory")
 }
 
-func handleSetCommand(args []string) {
+// recoverDatabase salvages a corrupt database and reports what happened
+func recoverDatabase(dbPath string) {
+	report, err := db.Recover(dbPath)
+	if err != nil {
+		log.Fatalf("Recovery failed: %v", err)
+	}
+
+	log.Printf("Moved damaged database to %s", report.CorruptPath)
+	log.Printf("Salvaged %d file records and %d config values", report.FilesRecovered, report.ConfigRecovered)
+	if len(report.Credentials) > 0 {
+		// Printed once, unmasked, since nobody would know them otherwise
+		fmt.Fprintln(os.Stderr, "The following credentials could not be recovered and were regenerated:")
+		for _, key := range []string{"auth.api_key", "auth.admin_password", "auth.list_password"} {
+			if value, ok := report.Credentials[key]; ok {
+				fmt.Fprintf(os.Stderr, "  %s = %s\n", key, value)
+			}
+		}
+	}
+}
+
+func handleSetCommand(args []string, dbPath string) {
+	// --force stores keys that aren't in the config schema
+	force := false
+	filtered := args[:0:0]
+	for _, arg := range args {
+		if arg == "--force" || arg == "-force" {
+			force = true
+			continue
+		}
+		filtered = append(filtered, arg)
+	}
+	args = filtered
+
 	if len(args) < 3 {
 		fmt.Fprintln(os.Stderr, "Error: 'set' command requires key and value")
-		fmt.Fprintln(os.Stderr, "Usage: httpserver set <key> <value>")
+		fmt.Fprintln(os.Stderr, "Usage: httpserver set [-c <path>] [--force] <key> <value>")
 		os.Exit(1)
 	}
 
 	key := args[1]
 	value := strings.Join(args[2:], " ")
 
-	// Determine database path
-	dbPath := getDefaultDBPath()
+	if err := config.ValidateValue(key, value); err != nil {
+		if !errors.Is(err, config.ErrUnknownKey) {
+			log.Fatalf("Invalid value: %v", err)
+		}
+		if !force {
+			log.Fatalf("Unknown config key '%s' (use --force to store it anyway)", key)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: '%s' is not a known config key; storing it anyway\n", key)
+	}
+
+	fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
 
 	// Open database
 	database, err := db.Open(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		// A server owns the database; apply the change through it
+		setViaServer(dbPath, key, value, force, err)
+		return
+	}
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
@@ -172,31 +446,70 @@ func handleSetCommand(args []string) {
 		log.Fatalf("Failed to set config: %v", err)
 	}
 
-	fmt.Printf("Config updated: %s = %s\n", key, value)
+	fmt.Printf("Config updated: %s = %s (takes effect when the server starts)\n", key, config.MaskConfigValue(key, value))
 }
 
-func handleGetCommand(args []string) {
+// setViaServer applies a config change through the running server's admin API
+func setViaServer(dbPath, key, value string, force bool, lockErr error) {
+	info, err := readControlFile(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v (and the running server could not be reached)", lockErr)
+	}
+
+	result, err := controlRequest(info, http.MethodPut, "/config", nil, map[string]interface{}{"key": key, "value": value, "force": force})
+	if err != nil {
+		log.Fatalf("Failed to set config: %v", err)
+	}
+
+	if live, _ := result["live"].(bool); live {
+		fmt.Printf("Config updated: %s = %s (applied to the running server)\n", key, config.MaskConfigValue(key, value))
+	} else {
+		fmt.Printf("Config updated: %s = %s (saved; restart the server to apply)\n", key, config.MaskConfigValue(key, value))
+	}
+}
+
+func handleGetCommand(args []string, dbPath string) {
+	// --show-secrets prints credentials in full in 'get all'
+	showSecrets := false
+	filtered := args[:0:0]
+	for _, arg := range args {
+		if arg == "--show-secrets" || arg == "-show-secrets" {
+			showSecrets = true
+			continue
+		}
+		filtered = append(filtered, arg)
+	}
+	args = filtered
+
 	if len(args) < 2 {
 		fmt.Fprintln(os.Stderr, "Error: 'get' command requires a key or 'all'")
-		fmt.Fprintln(os.Stderr, "Usage: httpserver get <key> | all")
+		fmt.Fprintln(os.Stderr, "Usage: httpserver get [-c <path>] <key> | all [--show-secrets]")
 		os.Exit(1)
 	}
 
-	// Determine database path
-	dbPath := getDefaultDBPath()
+	fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
 
-	// Open database
-	database, err := db.Open(dbPath)
-	if err != nil {
+	key := args[1]
+
+	// Open database read-only; a shared lock lets several readers run at once
+	var allConfig map[string]string
+	database, err := db.OpenReadOnly(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		// A server owns the database; read through it
+		// Asking for a single key is explicit enough to return it in full
+		allConfig = getViaServer(dbPath, showSecrets || key != "all", err)
+	} else if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
+	} else {
+		allConfig = database.GetAllConfig()
+		database.Close()
 	}
-	defer database.Close()
-
-	key := args[1]
 
 	if key == "all" {
+		if !showSecrets {
+			allConfig = config.MaskSecrets(allConfig)
+		}
 		// Show all configuration
-		allConfig := database.GetAllConfig()
 		fmt.Println("Configuration:")
 		fmt.Println("================")
 		// Group by prefix
@@ -205,8 +518,8 @@ func handleGetCommand(args []string) {
 			prefix := strings.Split(k, ".")[0]
 			groups[prefix] = append(groups[prefix], k)
 		}
-		// Print in order: server, storage, auth, security
-		order := []string{"server", "storage", "auth", "security"}
+		// Print in order: server, storage, auth, security, notifications, backup, database
+		order := []string{"server", "storage", "auth", "security", "notifications", "backup", "database"}
 		for _, prefix := range order {
 			if keys, ok := groups[prefix]; ok {
 				fmt.Printf("\n[%s]\n", strings.ToUpper(prefix))
@@ -217,7 +530,7 @@ func handleGetCommand(args []string) {
 		}
 	} else {
 		// Get single value
-		value := database.GetConfig(key)
+		value := allConfig[key]
 		if value == "" {
 			fmt.Printf("Config key '%s' not found or empty\n", key)
 			os.Exit(1)
@@ -226,47 +539,350 @@ func handleGetCommand(args []string) {
 	}
 }
 
-func buildConfigFromDB(database *db.Database) *config.Config {
+// getViaServer reads all config values through the running server's admin API;
+// secrets come back masked unless reveal is set
+func getViaServer(dbPath string, reveal bool, lockErr error) map[string]string {
+	info, err := readControlFile(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v (and the running server could not be reached)", lockErr)
+	}
+
+	allConfig, err := serverConfig(info, reveal)
+	if err != nil {
+		log.Fatalf("Failed to get config: %v", err)
+	}
+	return allConfig
+}
+
+// serverConfig reads all config values from the running server
+func serverConfig(info *controlInfo, reveal bool) (configMap, error) {
+	query := url.Values{"key": {"all"}}
+	if reveal {
+		query.Set("reveal", "1")
+	}
+	result, err := controlRequest(info, http.MethodGet, "/config", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	values, _ := result["config"].(map[string]interface{})
+	allConfig := make(configMap, len(values))
+	for k, v := range values {
+		allConfig[k], _ = v.(string)
+	}
+	return allConfig, nil
+}
+
+func handleUnsetCommand(args []string, dbPath string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Error: 'unset' command requires a key")
+		fmt.Fprintln(os.Stderr, "Usage: httpserver unset [-c <path>] <key>")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
+
+	key := args[1]
+
+	database, err := db.Open(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		// A server owns the database; apply the change through it
+		unsetViaServer(dbPath, key, err)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	oldValue := database.GetAllConfig()[key]
+	removed, err := database.DeleteConfig(key)
+	if err != nil {
+		log.Fatalf("Failed to remove config: %v", err)
+	}
+	if !removed {
+		fmt.Fprintf(os.Stderr, "Config key '%s' is not set\n", key)
+		database.Close()
+		os.Exit(1)
+	}
+
+	printUnset(key, oldValue, db.DefaultConfigValue(key), "takes effect when the server starts")
+}
+
+// unsetViaServer removes a config key through the running server's admin API
+func unsetViaServer(dbPath, key string, lockErr error) {
+	info, err := readControlFile(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v (and the running server could not be reached)", lockErr)
+	}
+
+	result, err := controlRequest(info, http.MethodDelete, "/config", url.Values{"key": {key}}, nil)
+	if err != nil {
+		log.Fatalf("Failed to remove config: %v", err)
+	}
+
+	oldValue, _ := result["old_value"].(string)
+	def, _ := result["default"].(string)
+	if live, _ := result["live"].(bool); live {
+		printUnset(key, oldValue, def, "applied to the running server")
+	} else {
+		printUnset(key, oldValue, def, "saved; restart the server to apply")
+	}
+}
+
+func printUnset(key, oldValue, def, when string) {
+	oldValue = config.MaskConfigValue(key, oldValue)
+	def = config.MaskConfigValue(key, def)
+	if def != "" {
+		fmt.Printf("Config removed: %s (was %q, default %q applies; %s)\n", key, oldValue, def, when)
+	} else {
+		fmt.Printf("Config removed: %s (was %q; %s)\n", key, oldValue, when)
+	}
+}
+
+func handleResetCommand(args []string, dbPath string) {
+	// --yes skips the confirmation prompt
+	yes := false
+	filtered := args[:0:0]
+	for _, arg := range args {
+		if arg == "--yes" || arg == "-yes" || arg == "-y" {
+			yes = true
+			continue
+		}
+		filtered = append(filtered, arg)
+	}
+	args = filtered
+
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Error: 'reset' command requires a key group, a key, or 'all'")
+		fmt.Fprintln(os.Stderr, "Usage: httpserver reset [-c <path>] [--yes] <group>|<key>|all")
+		os.Exit(1)
+	}
+
+	prefix := args[1]
+	fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
+
+	if !yes && !confirm(fmt.Sprintf("Restore built-in defaults for '%s'?", prefix)) {
+		fmt.Println("Aborted")
+		os.Exit(1)
+	}
+
+	database, err := db.Open(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		// A server owns the database; apply the change through it
+		resetViaServer(dbPath, prefix, err)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	changes, err := database.ResetConfig(prefix)
+	if err != nil {
+		log.Fatalf("Failed to reset config: %v", err)
+	}
+	printChanges(changes, nil, "takes effect when the server starts")
+}
+
+// resetViaServer restores defaults through the running server's admin API
+func resetViaServer(dbPath, prefix string, lockErr error) {
+	info, err := readControlFile(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v (and the running server could not be reached)", lockErr)
+	}
+
+	result, err := controlRequest(info, http.MethodPost, "/config/reset", nil, map[string]string{"prefix": prefix})
+	if err != nil {
+		log.Fatalf("Failed to reset config: %v", err)
+	}
+
+	var changes []db.ConfigChange
+	items, _ := result["changes"].([]interface{})
+	for _, item := range items {
+		m, _ := item.(map[string]interface{})
+		change := db.ConfigChange{}
+		change.Key, _ = m["key"].(string)
+		change.OldValue, _ = m["old_value"].(string)
+		change.NewValue, _ = m["new_value"].(string)
+		changes = append(changes, change)
+	}
+	live := make(map[string]bool)
+	keys, _ := result["live"].([]interface{})
+	for _, k := range keys {
+		if key, ok := k.(string); ok {
+			live[key] = true
+		}
+	}
+	printChanges(changes, live, "saved; restart the server to apply")
+}
+
+// printChanges lists reset values; keys in live were applied to a running server
+func printChanges(changes []db.ConfigChange, live map[string]bool, when string) {
+	if len(changes) == 0 {
+		fmt.Println("Nothing to reset: all values already match the defaults")
+		return
+	}
+	fmt.Println("Config reset:")
+	for _, change := range changes {
+		note := when
+		if live[change.Key] {
+			note = "applied to the running server"
+		}
+		fmt.Printf("  %s: %q -> %q (%s)\n", change.Key,
+			config.MaskConfigValue(change.Key, change.OldValue), config.MaskConfigValue(change.Key, change.NewValue), note)
+	}
+}
+
+// parseTTLConfig parses a stored TTL, falling back when it is invalid (values
+// set through the CLI or admin API are validated, but the database may be edited)
+func parseTTLConfig(value string, fallback config.TTL) config.TTL {
+	ttl, err := config.ParseTTL(value)
+	if err != nil {
+		return fallback
+	}
+	return ttl
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func buildConfigFromDB(database configReader) *config.Config {
 	cfg := &config.Config{}
 
+	// Environment variables (HTTPSERVER_<KEY>) take precedence over stored values
+	src := &envConfigSource{database: database}
+
 	// Server config
-	cfg.Server.Host = database.GetConfig("server.host")
-	cfg.Server.Port = database.GetConfigInt("server.port")
+	cfg.Server.Host = src.GetConfig("server.host")
+	cfg.Server.Port = src.GetConfigInt("server.port")
+	cfg.Server.MinFreeDiskMB = src.GetConfigInt("server.min_free_disk_mb")
+	cfg.Server.MaxConcurrentUploads = src.GetConfigInt("server.max_concurrent_uploads")
+	cfg.Server.MaxConcurrentDownloads = src.GetConfigInt("server.max_concurrent_downloads")
+	cfg.Server.ConcurrencyWaitSeconds = src.GetConfigInt("server.concurrency_wait_seconds")
+	cfg.Server.PIDFile = src.GetConfig("server.pid_file")
+	if listen := src.GetConfig("server.listen"); listen != "" {
+		cfg.Server.Listen = strings.Split(listen, ",")
+	}
+	cfg.Server.SocketMode = src.GetConfig("server.socket_mode")
+	cfg.Server.SocketGroup = src.GetConfig("server.socket_group")
+	cfg.Server.ReadOnly = src.GetConfig("server.read_only") == "true"
+	cfg.Server.MaintenanceMessage = src.GetConfig("server.maintenance_message")
+	cfg.Server.EnableCompression = src.GetConfig("server.enable_compression") == "true"
+	cfg.Server.TemplatesDir = src.GetConfig("server.templates_dir")
+	cfg.Server.AllowIndexing = src.GetConfig("server.allow_indexing") == "true"
+	if domains := src.GetConfig("server.acme_domains"); domains != "" {
+		cfg.Server.ACMEDomains = strings.Split(domains, ",")
+	}
+	cfg.Server.ACMEEmail = src.GetConfig("server.acme_email")
+	cfg.Server.HTTPSPort = src.GetConfigInt("server.https_port")
+	cfg.Server.HTTPPort = src.GetConfigInt("server.http_port")
+	cfg.Server.RedirectHTTP = src.GetConfig("server.redirect_http") == "true"
 
 	// Storage config
-	cfg.Storage.ImagesDir = database.GetConfig("storage.images_dir")
-	cfg.Storage.MaxFileSize = int64(database.GetConfigInt("storage.max_file_size"))
-	cfg.Storage.CleanupInterval = database.GetConfigInt("storage.cleanup_interval")
-	cfg.Storage.DefaultTTL = database.GetConfigInt("storage.default_ttl")
-	cfg.Storage.MaxTTL = database.GetConfigInt("storage.max_ttl")
+	cfg.Storage.ImagesDir = src.GetConfig("storage.images_dir")
+	cfg.Storage.MaxFileSize = int64(src.GetConfigInt("storage.max_file_size"))
+	cfg.Storage.CleanupInterval = src.GetConfigInt("storage.cleanup_interval")
+	cfg.Storage.DefaultTTL = parseTTLConfig(src.GetConfig("storage.default_ttl"), config.TTL(time.Hour))
+	cfg.Storage.MaxTTL = parseTTLConfig(src.GetConfig("storage.max_ttl"), config.TTL(365*24*time.Hour))
+	cfg.Storage.AllowPermanent = src.GetConfig("storage.allow_permanent") == "true"
+	cfg.Storage.RetentionRules, _ = config.ParseRetentionRules(src.GetConfig("storage.retention_rules"))
+	cfg.Storage.PathLayout = src.GetConfig("storage.path_layout")
+	cfg.Storage.PreserveOriginalName = src.GetConfig("storage.preserve_original_name") == "true"
+	cfg.Storage.NameEntropyBytes = src.GetConfigInt("storage.name_entropy_bytes")
+	cfg.Storage.NameStyle = src.GetConfig("storage.name_style")
+	cfg.Storage.MaxArchiveSize = src.GetConfigInt64("storage.max_archive_size")
+	cfg.Storage.TrashRetentionHours = src.GetConfigInt("storage.trash_retention_hours")
+	cfg.Storage.OrphanGraceHours = src.GetConfigInt("storage.orphan_grace_hours")
+	cfg.Storage.ResumableExpiryHours = src.GetConfigInt("storage.resumable_expiry_hours")
+	cfg.Storage.IdempotencyWindowHours = src.GetConfigInt("storage.idempotency_window_hours")
+	cfg.Storage.InlineExtensions = config.ParseExtensionList(src.GetConfig("storage.inline_extensions"))
+	cfg.Storage.ForceDownloadExtensions = config.ParseExtensionList(src.GetConfig("storage.force_download_extensions"))
+	cfg.Storage.VerifyReadRateMB = src.GetConfigInt("storage.verify_read_rate_mb")
+	cfg.Storage.DownloadRateLimitKbps = src.GetConfigInt("storage.download_rate_limit_kbps")
+	cfg.Storage.GlobalDownloadRateLimitKbps = src.GetConfigInt("storage.global_download_rate_limit_kbps")
+	cfg.Storage.Backend = src.GetConfig("storage.backend")
+	cfg.Storage.S3Endpoint = src.GetConfig("storage.s3_endpoint")
+	cfg.Storage.S3Bucket = src.GetConfig("storage.s3_bucket")
+	cfg.Storage.S3Region = src.GetConfig("storage.s3_region")
+	cfg.Storage.S3AccessKey = src.GetConfig("storage.s3_access_key")
+	cfg.Storage.S3Secret = src.GetConfig("storage.s3_secret")
+	cfg.Storage.S3Prefix = src.GetConfig("storage.s3_prefix")
+	cfg.Storage.S3PathStyle = src.GetConfig("storage.s3_path_style") == "true"
 
 	// Auth config
-	cfg.Auth.APIKey = database.GetConfig("auth.api_key")
-	cfg.Auth.AdminUsername = database.GetConfig("auth.admin_username")
-	cfg.Auth.AdminPassword = database.GetConfig("auth.admin_password")
-	cfg.Auth.ListPassword = database.GetConfig("auth.list_password")
+	cfg.Auth.APIKey = src.GetConfig("auth.api_key")
+	cfg.Auth.AdminUsername = src.GetConfig("auth.admin_username")
+	cfg.Auth.AdminPassword = src.GetConfig("auth.admin_password")
+	cfg.Auth.ListPassword = src.GetConfig("auth.list_password")
+	cfg.Auth.TOTPSecret = src.GetConfig("auth.totp_secret")
+	if codes := src.GetConfig("auth.totp_recovery_codes"); codes != "" {
+		cfg.Auth.TOTPRecoveryCodes = strings.Split(codes, ",")
+	}
 
 	// Security config
 	// IP whitelist is stored as comma-separated string
-	ipWhitelistStr := database.GetConfig("security.ip_whitelist")
+	ipWhitelistStr := src.GetConfig("security.ip_whitelist")
 	if ipWhitelistStr != "" {
 		cfg.Security.IPWhitelist = strings.Split(ipWhitelistStr, ",")
 	} else {
 		cfg.Security.IPWhitelist = []string{}
 	}
-	cfg.Security.RateLimitPerMinute = database.GetConfigInt("security.rate_limit_per_minute")
-	cfg.Security.SessionTimeout = database.GetConfigInt("security.session_timeout")
+	if proxies := src.GetConfig("security.trusted_proxies"); proxies != "" {
+		cfg.Security.TrustedProxies = strings.Split(proxies, ",")
+	}
+	cfg.Security.RateLimitPerMinute = src.GetConfigInt("security.rate_limit_per_minute")
+	cfg.Security.SessionTimeout = src.GetConfigInt("security.session_timeout")
+	cfg.Security.SessionAbsoluteTimeout = src.GetConfigInt("security.session_absolute_timeout")
+	cfg.Security.AllowRememberMe = src.GetConfig("security.allow_remember_me") == "true"
+	cfg.Security.CookieSecure = src.GetConfig("security.cookie_secure")
+	cfg.Security.CookieSameSiteStrict = src.GetConfig("security.cookie_samesite_strict") == "true"
+	cfg.Security.AllowInlineHTML = src.GetConfig("security.allow_inline_html") == "true"
+	cfg.Security.UploadQuotaPerDayBytes = src.GetConfigInt64("security.upload_quota_per_day_bytes")
+	cfg.Security.AutobanThreshold = src.GetConfigInt("security.autoban_threshold")
+	cfg.Security.AutobanWindowMinutes = src.GetConfigInt("security.autoban_window_minutes")
+	cfg.Security.AutobanDurationMinutes = src.GetConfigInt("security.autoban_duration_minutes")
+	cfg.Security.HSTSMaxAge = src.GetConfigInt("security.hsts_max_age")
+	cfg.Security.RequireNonDefaultCredentials = src.GetConfig("security.require_non_default_credentials") == "true"
 
 	// Database config
-	cfg.Database.Path = database.GetConfig("database.path")
+	cfg.Database.Path = src.GetConfig("database.path")
 	if cfg.Database.Path == "" {
 		cfg.Database.Path = getDefaultDBPath()
 	}
 
+	// Logging config
+	cfg.Logging.File = src.GetConfig("logging.file")
+	cfg.Logging.MaxSizeMB = src.GetConfigInt("logging.max_size_mb")
+	cfg.Logging.MaxBackups = src.GetConfigInt("logging.max_backups")
+	cfg.Logging.Stdout = src.GetConfig("logging.stdout") == "true"
+	cfg.Logging.Format = src.GetConfig("logging.format")
+	cfg.Logging.AccessLog = src.GetConfig("logging.access_log") == "true"
+
 	// Auto restart config
-	autoRestartStr := database.GetConfig("auto_restart.enabled")
+	autoRestartStr := src.GetConfig("auto_restart.enabled")
 	cfg.AutoRestart.Enabled = autoRestartStr == "true"
-	cfg.AutoRestart.MaxRestartCount = database.GetConfigInt("auto_restart.max_restart_count")
+	cfg.AutoRestart.MaxRestartCount = src.GetConfigInt("auto_restart.max_restart_count")
+
+	// Notifications config
+	cfg.Notifications.WebhookURL = src.GetConfig("notifications.webhook_url")
+	cfg.Notifications.WebhookSecret = src.GetConfig("notifications.webhook_secret")
+	if events := src.GetConfig("notifications.events"); events != "" {
+		cfg.Notifications.Events = strings.Split(events, ",")
+	}
+	cfg.Notifications.ExpiryWarningHours = src.GetConfigInt("notifications.expiry_warning_hours")
+	cfg.Replication.TargetURL = src.GetConfig("replication.target_url")
+	cfg.Replication.APIKey = src.GetConfig("replication.api_key")
+
+	// Backup config
+	cfg.Backup.IntervalHours = src.GetConfigInt("backup.interval_hours")
+	cfg.Backup.Dir = src.GetConfig("backup.dir")
+	cfg.Backup.KeepCount = src.GetConfigInt("backup.keep_count")
 
 	return cfg
 }
@@ -278,34 +894,151 @@ func printHelp() {
 	fmt.Println()
 	fmt.Println("Commands:")
 	fmt.Println("  start              Start the server (default)")
-	fmt.Println("  set <key> <value>  Set configuration value")
+	fmt.Println("  init               Set up a new server: port, storage directory, credentials and a")
+	fmt.Println("                     random API key (asks on a terminal; see init -h for flags)")
+	fmt.Println("  doctor             Check the database, storage directory, port, settings, disk space,")
+	fmt.Println("                     service and clock; exits 0 (pass), 1 (warnings) or 2 (failures); --json")
+	fmt.Println("  set <key> <value>  Set configuration value (--force stores unknown keys)")
 	fmt.Println("  get <key>          Get configuration value")
-	fmt.Println("  get all            Show all configuration")
+	fmt.Println("  get all            Show all configuration (secrets masked; --show-secrets reveals them)")
+	fmt.Println("  unset <key>        Remove a configuration value (keys with a default revert to it)")
+	fmt.Println("  reset <group>|all  Restore built-in defaults for a key group, e.g. security (--yes skips the prompt)")
+	fmt.Println("  export <file>      Write the database to a .tar.gz (--with-files adds the Images tree)")
+	fmt.Println("  import <file>      Restore an export (--merge keeps existing files, --replace discards them)")
+	fmt.Println("  admin enable-2fa   Require a TOTP code for the admin endpoints; prints the secret and recovery codes")
+	fmt.Println("  admin disable-2fa <code>  Turn two-factor authentication off (needs a current or recovery code)")
+	fmt.Println("                     (these commands accept -c <path> before or after the command)")
 	fmt.Println()
 	fmt.Println("Options:")
-	fmt.Println("  -i                 Install as systemd service (Linux only)")
-	fmt.Println("  -u                 Uninstall systemd service (Linux only)")
+	fmt.Println("  -i                 Install as a service (systemd on Linux, launchd on macOS, the SCM on Windows)")
+	fmt.Println("  -u                 Uninstall the system service")
 	fmt.Println("  -p <port>          Port to listen on (overrides config)")
-	fmt.Println("  -c <path>          Path to database file")
-	fmt.Println("  --no-restart       Disable auto restart (Linux only)")
+	fmt.Println("  -c <path>          Path to database file (default: $HTTPSERVER_DB, then the standard location)")
+	fmt.Println("  --no-restart       With -i, install the service without auto restart")
+	fmt.Println("  --user             With -i/-u, use a systemd user unit (no root needed)")
+	fmt.Println("  --system           With -i/-u, use a launchd LaunchDaemon instead of a LaunchAgent")
+	fmt.Println("  --persist-env      Save HTTPSERVER_* environment overrides to the database")
+	fmt.Println("  --recover          Salvage a corrupt database (the original is kept as .corrupt-<time>)")
+	fmt.Println("  --force            Start even if the PID file or port shows another running instance")
+	fmt.Println("  --generate-key     Replace the default API key with a random one and print it (offered on a terminal)")
 	fmt.Println("  -v, --version      Show version information")
 	fmt.Println("  -h, --help         Show this help message")
 	fmt.Println()
+	fmt.Println("Configuration Precedence:")
+	fmt.Println("  command-line flags > environment > database > built-in defaults")
+	fmt.Println("  Any key can be overridden with HTTPSERVER_<KEY>, upper-cased with dots as")
+	fmt.Println("  underscores, e.g. server.port -> HTTPSERVER_SERVER_PORT. Overrides are not")
+	fmt.Println("  saved unless --persist-env is given.")
+	fmt.Println()
 	fmt.Println("Configuration Keys:")
 	fmt.Println("  server.host                    Server host address")
-	fmt.Println("  server.port                    Server port")
+	fmt.Println("  server.port                    Server port (0 picks a free port at startup)")
+	fmt.Println("  server.min_free_disk_mb        Report /health as degraded below this free space")
+	fmt.Println("  server.max_concurrent_uploads  Max simultaneous uploads (0 = unlimited)")
+	fmt.Println("  server.max_concurrent_downloads Max simultaneous file downloads (0 = unlimited)")
+	fmt.Println("  server.concurrency_wait_seconds Wait this long for a free slot before returning 503")
+	fmt.Println("  server.pid_file                PID file (default: <database>.pid)")
+	fmt.Println("  server.listen                  Comma-separated listeners replacing host/port, e.g.")
+	fmt.Println("                                 tcp://127.0.0.1:8080,tcp://[::1]:8080,unix:///run/httpserver.sock")
+	fmt.Println("  server.socket_mode             Permissions for unix sockets (octal)")
+	fmt.Println("  server.socket_group            Group owning unix sockets")
+	fmt.Println("  server.read_only               Maintenance mode: refuse uploads and deletions with 503")
+	fmt.Println("  server.maintenance_message     Message returned while read-only")
+	fmt.Println("  server.enable_compression      Compress JSON and HTML responses (gzip/deflate)")
+	fmt.Println("  server.templates_dir           Directory of HTML error pages (404.html, 410.html, error.html)")
+	fmt.Println("  server.allow_indexing          Let search engines index downloads (robots.txt, X-Robots-Tag)")
+	fmt.Println("  server.acme_domains            Comma-separated domains to get Let's Encrypt certificates for;")
+	fmt.Println("                                 enables HTTPS on https_port instead of port/listen")
+	fmt.Println("  server.acme_email              Contact email for the Let's Encrypt account")
+	fmt.Println("  server.https_port              HTTPS port when acme_domains is set")
+	fmt.Println("  server.http_port               Plain HTTP port next to HTTPS (ACME challenges, redirects)")
+	fmt.Println("  server.redirect_http           Redirect plain HTTP requests to HTTPS (301) instead of serving them")
 	fmt.Println("  storage.images_dir             Images storage directory")
 	fmt.Println("  storage.max_file_size          Max file size in bytes")
 	fmt.Println("  storage.cleanup_interval       Cleanup interval in minutes")
-	fmt.Println("  storage.default_ttl            Default TTL in hours")
-	fmt.Println("  storage.max_ttl                Maximum TTL in hours")
+	fmt.Println("  storage.default_ttl            Default TTL: hours (24), a duration (45m, 36h, 14d, 2w) or never")
+	fmt.Println("  storage.max_ttl                Maximum TTL, same syntax (never = no limit)")
+	fmt.Println("  storage.allow_permanent        Allow uploads with a TTL of never (or 0)")
+	fmt.Println("  storage.retention_rules        JSON array of TTL rules; the first match applies, e.g.")
+	fmt.Println(`                                 [{"name":"videos","extensions":["mp4","mov"],"max_ttl":"1d"}]`)
+	fmt.Println("                                 criteria: extensions, min_size, ips, api_keys;")
+	fmt.Println("                                 action: max_ttl (cap) or force_ttl (replace)")
+	fmt.Println("  storage.path_layout            Directories for new uploads: daily (YYYYMMDD/), hourly")
+	fmt.Println("                                 (YYYYMMDD/HH/) or hashed (ab/cd/ from the random name part)")
+	fmt.Println("  storage.preserve_original_name Append the sanitized original name to generated names")
+	fmt.Println("  storage.name_entropy_bytes     Random bytes in generated names (default 16, minimum 4)")
+	fmt.Println("  storage.name_style             Random part encoding: hex, or short (base62, fewer characters)")
+	fmt.Println("  storage.max_archive_size       Max total bytes in a ZIP download")
+	fmt.Println("  storage.trash_retention_hours  Keep deleted files in Trash/ this long (0 = delete immediately)")
+	fmt.Println("  storage.orphan_grace_hours     Minimum age before reconcile removes untracked files")
+	fmt.Println("  storage.resumable_expiry_hours Drop chunked uploads idle this long (0 = keep them)")
+	fmt.Println("  storage.idempotency_window_hours Hours a retried upload's Idempotency-Key returns the first")
+	fmt.Println("                                 result instead of storing the file again (0 = ignore keys)")
+	fmt.Println("  storage.inline_extensions      Extensions shown in the browser, like pdf,txt; others go by")
+	fmt.Println("                                 type (images, video, audio, text and PDF inline)")
+	fmt.Println("  storage.force_download_extensions Extensions always downloaded, like zip,exe; wins over")
+	fmt.Println("                                 storage.inline_extensions")
+	fmt.Println("  storage.verify_read_rate_mb    Disk read cap for integrity verification in MB/s")
+	fmt.Println("  storage.download_rate_limit_kbps Per-download rate cap in kilobits/s (0 = unlimited)")
+	fmt.Println("  storage.global_download_rate_limit_kbps Total rate cap shared by all downloads in kilobits/s")
+	fmt.Println("  storage.backend                Where file contents are stored: local or s3")
+	fmt.Println("                                 (images_dir still holds uploads in progress)")
+	fmt.Println("  storage.s3_endpoint            S3 endpoint URL, e.g. https://s3.eu-central-1.amazonaws.com")
+	fmt.Println("  storage.s3_bucket              S3 bucket name")
+	fmt.Println("  storage.s3_region              S3 region used for request signing")
+	fmt.Println("  storage.s3_access_key          S3 access key ID")
+	fmt.Println("  storage.s3_secret              S3 secret access key")
+	fmt.Println("  storage.s3_prefix              Optional prefix for object keys")
+	fmt.Println("  storage.s3_path_style          Address the bucket in the path (true) or hostname (false)")
 	fmt.Println("  auth.api_key                   API key for upload/delete")
 	fmt.Println("  auth.admin_username            Admin username")
 	fmt.Println("  auth.admin_password            Admin password")
 	fmt.Println("  auth.list_password             File list password")
+	fmt.Println("  auth.totp_secret               Admin two-factor secret (set with admin enable-2fa); while set,")
+	fmt.Println("                                 WebDAV (/dav/) takes a list-scoped API token as the password")
+	fmt.Println("                                 instead of the admin credentials")
+	fmt.Println("  auth.totp_recovery_codes       Hashes of unused two-factor recovery codes")
 	fmt.Println("  security.ip_whitelist          Comma-separated IP whitelist")
+	fmt.Println("  security.trusted_proxies       Proxies (IPs or CIDRs) whose X-Forwarded-For is believed for the")
+	fmt.Println("                                 client address used by stats, quotas, bans and the")
+	fmt.Println("                                 whitelist, and whose X-Request-ID and X-Forwarded-Proto are kept")
 	fmt.Println("  security.rate_limit_per_minute Rate limit per IP")
-	fmt.Println("  security.session_timeout       Session timeout in seconds")
+	fmt.Println("  security.session_timeout       Seconds a list session stays valid without requests")
+	fmt.Println("  security.session_absolute_timeout Seconds after login a session ends however active (0 = no cap)")
+	fmt.Println("  security.allow_remember_me     Offer \"remember me\" at login: the session then lasts the")
+	fmt.Println("                                 absolute timeout even when idle")
+	fmt.Println("  security.cookie_secure         Secure flag on the session cookie: auto (HTTPS requests, directly or")
+	fmt.Println("                                 via a trusted proxy's X-Forwarded-Proto), always or never")
+	fmt.Println("  security.cookie_samesite_strict Send the session cookie with SameSite=Strict instead of Lax")
+	fmt.Println("  security.allow_inline_html     Let HTML, SVG and XML files render in the browser; they")
+	fmt.Println("                                 are always downloaded otherwise, whatever the lists say")
+	fmt.Println("  security.upload_quota_per_day_bytes Bytes each API key and IP may upload per rolling 24h (0 = unlimited)")
+	fmt.Println("  security.autoban_threshold     Ban an IP after this many 401/429 responses in the window (0 = off)")
+	fmt.Println("  security.autoban_window_minutes Window for counting failures towards an automatic ban")
+	fmt.Println("  security.autoban_duration_minutes How long an automatic ban lasts")
+	fmt.Println("  security.hsts_max_age          Send Strict-Transport-Security with this max-age on HTTPS (0 = off)")
+	fmt.Println("  security.require_non_default_credentials")
+	fmt.Println("                                 Refuse to start while the API key, admin password or list")
+	fmt.Println("                                 password still has its built-in default")
+	fmt.Println("  notifications.webhook_url      Webhook URL for event notifications")
+	fmt.Println("  notifications.webhook_secret   HMAC secret for the X-Webhook-Signature header")
+	fmt.Println("  notifications.events           Comma-separated events (upload,delete,cleanup,expiring)")
+	fmt.Println("  notifications.expiry_warning_hours  Warn this many hours before expiry (0 = off)")
+	fmt.Println("  replication.target_url         Standby server receiving uploads, deletions and expiry changes")
+	fmt.Println("  replication.api_key            Token with the replicate scope on the standby")
+	fmt.Println("  backup.interval_hours          Back up metadata this often (0 = off)")
+	fmt.Println("  backup.dir                     Backup directory (default: backups/ next to the database)")
+	fmt.Println("  backup.keep_count              Number of backups to keep")
+	fmt.Println("  logging.file                   Also write the log to this file (rotated by size)")
+	fmt.Println("  logging.max_size_mb            Rotate the log file at this size")
+	fmt.Println("  logging.max_backups            Number of rotated log files to keep")
+	fmt.Println("  logging.stdout                 Copy log output to stdout when logging.file is set")
+	fmt.Println("  logging.format                 text or json (one JSON object per line)")
+	fmt.Println("  logging.access_log             Log every request with its status, duration and request ID")
+	fmt.Println("  auto_restart.enabled           Restart the installed service when it exits")
+	fmt.Println("  auto_restart.max_restart_count Give up after this many restarts in 10 minutes (0 = never)")
+	fmt.Println("  database.driver                json or sqlite; sqlite migrates the JSON file on next start and")
+	fmt.Println("                                 needs a server built with -tags sqlite (make server-sqlite)")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  httpserver                    # Start server")
@@ -313,10 +1046,46 @@ func printHelp() {
 	fmt.Println("  httpserver set server.port 4900     # Set port to 4900")
 	fmt.Println("  httpserver get server.port          # Get port value")
 	fmt.Println("  httpserver get all                 # Show all config")
+	fmt.Println("  httpserver unset security.ip_whitelist  # Remove a value")
+	fmt.Println("  httpserver reset security --yes    # Restore security defaults")
+	fmt.Println("  httpserver export --with-files backup.tar.gz  # Back up database and files")
 	fmt.Println("  httpserver -p 8080 -i         # Install service on port 8080")
 	fmt.Println("  httpserver -u                 # Uninstall service")
 }
 
+// dbPathEnv overrides the default database path when -c is not given
+const dbPathEnv = "HTTPSERVER_DB"
+
+// resolveDBPath picks the database path: the -c flag, then HTTPSERVER_DB, then the default
+func resolveDBPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if env := os.Getenv(dbPathEnv); env != "" {
+		return env
+	}
+	return getDefaultDBPath()
+}
+
+// extractDBFlag removes "-c <path>" (or -c=<path>) from subcommand arguments,
+// wherever it appears, and returns the path and the remaining arguments
+func extractDBFlag(args []string) (string, []string) {
+	var dbPath string
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case (arg == "-c" || arg == "--c") && i+1 < len(args):
+			dbPath = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "-c=") || strings.HasPrefix(arg, "--c="):
+			dbPath = arg[strings.Index(arg, "=")+1:]
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return dbPath, rest
+}
+
 func getDefaultDBPath() string {
 	if runtime.GOOS == "windows" {
 		// Windows: use executable directory
@@ -334,16 +1103,34 @@ func getDefaultDBPath() string {
 	return filepath.Join(home, "HttpServer", "metadata.db")
 }
 
-func handleShutdown(server *httpd.Server, cleanupMgr *cleanup.CleanupManager) {
+func handleShutdown(server *httpd.Server, cleanupMgr *cleanup.CleanupManager, database *db.Database, dbPath, pidPath string) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	<-sigChan
+	shutdown(server, cleanupMgr, database, dbPath, pidPath)
+	os.Exit(0)
+}
+
+// shutdownTimeout bounds how long requests in flight may take to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// shutdown stops the listeners and background work, then saves and releases the database
+func shutdown(server *httpd.Server, cleanupMgr *cleanup.CleanupManager, database *db.Database, dbPath, pidPath string) {
 	log.Println("Shutting down...")
+	service.NotifyStopping()
 
-	// Note: Server doesn't have explicit Shutdown method in this simple implementation
-	// In production, you'd want to implement graceful shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error stopping server: %v", err)
+	}
+	cancel()
 	cleanupMgr.Stop()
 
-	os.Exit(0)
+	// Save and release the database lock before exiting
+	removeControlFile(dbPath)
+	removePIDFile(pidPath)
+	if err := database.Close(); err != nil {
+		log.Printf("Error closing database: %v", err)
+	}
 }
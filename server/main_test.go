@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"httpserver/server/db"
+)
+
+// captureStdout returns what fn prints to standard output
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+
+	done := make(chan []byte)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		done <- out
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+func TestExtractDBFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantPath string
+		wantRest []string
+	}{
+		{[]string{"get", "all"}, "", []string{"get", "all"}},
+		{[]string{"get", "all", "-c", "/tmp/a.db"}, "/tmp/a.db", []string{"get", "all"}},
+		{[]string{"-c", "/tmp/a.db", "get", "all"}, "/tmp/a.db", []string{"get", "all"}},
+		{[]string{"get", "-c=/tmp/a.db", "all"}, "/tmp/a.db", []string{"get", "all"}},
+		{[]string{"set", "--c", "/tmp/a.db", "server.port", "80"}, "/tmp/a.db", []string{"set", "server.port", "80"}},
+		{[]string{"get", "all", "--c=/tmp/a.db"}, "/tmp/a.db", []string{"get", "all"}},
+		// A trailing -c has no value and is passed through untouched
+		{[]string{"get", "all", "-c"}, "", []string{"get", "all", "-c"}},
+		{[]string{"get", "all", "-c="}, "", []string{"get", "all"}},
+	}
+	for _, tt := range tests {
+		path, rest := extractDBFlag(tt.args)
+		if path != tt.wantPath || !reflect.DeepEqual(rest, tt.wantRest) {
+			t.Errorf("extractDBFlag(%q) = %q, %q, want %q, %q", tt.args, path, rest, tt.wantPath, tt.wantRest)
+		}
+	}
+}
+
+func TestResolveDBPath(t *testing.T) {
+	t.Setenv(dbPathEnv, "")
+	if got, want := resolveDBPath(""), getDefaultDBPath(); got != want {
+		t.Errorf("without -c: %q, want the default %q", got, want)
+	}
+	if got := resolveDBPath("/tmp/flag.db"); got != "/tmp/flag.db" {
+		t.Errorf("with -c: %q, want /tmp/flag.db", got)
+	}
+
+	t.Setenv(dbPathEnv, "/tmp/env.db")
+	if got := resolveDBPath(""); got != "/tmp/env.db" {
+		t.Errorf("with %s: %q, want /tmp/env.db", dbPathEnv, got)
+	}
+	if got := resolveDBPath("/tmp/flag.db"); got != "/tmp/flag.db" {
+		t.Errorf("with -c and %s: %q, want the -c path", dbPathEnv, got)
+	}
+}
+
+func TestGetReadsDatabaseFromFlag(t *testing.T) {
+	t.Setenv(dbPathEnv, "")
+	path := filepath.Join(t.TempDir(), "custom.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetConfig("server.port", "4900"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"get", "all", "-c", path},
+		{"-c=" + path, "get", "all"},
+	} {
+		dbFlag, rest := extractDBFlag(args)
+		out := captureStdout(t, func() { handleGetCommand(rest, resolveDBPath(dbFlag)) })
+		if !strings.Contains(out, "server.port: 4900") {
+			t.Errorf("%q printed %q, want server.port from %s", args, out, path)
+		}
+	}
+}
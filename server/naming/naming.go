@@ -3,64 +3,274 @@ package naming
 import (
 	"crypto/rand"
 	"fmt"
+	"math"
+	"math/big"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// Directory layouts for stored files (storage.path_layout)
+const (
+	LayoutDaily  = "daily"  // YYYYMMDD/<name>
+	LayoutHourly = "hourly" // YYYYMMDD/HH/<name>
+	LayoutHashed = "hashed" // <r1r2>/<r3r4>/<name>, from the random component
+)
+
+// Layouts lists the supported directory layouts
+var Layouts = []string{LayoutDaily, LayoutHourly, LayoutHashed}
+
+// Name styles for the random component (storage.name_style)
+const (
+	StyleHex   = "hex"   // Lowercase hex, two characters per byte
+	StyleShort = "short" // Base62, about 1.34 characters per byte
+)
+
+// Random component sizes (storage.name_entropy_bytes)
+const (
+	DefaultEntropyBytes = 16
+	MinEntropyBytes     = 4
+)
+
+// maxOriginalNameLength bounds the sanitized original name kept in a file name
+const maxOriginalNameLength = 64
+
+// base62 is the alphabet of StyleShort
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Options controls how names and paths are generated. The zero value gives
+// the original format: LayoutDaily and 16 random bytes in hex.
+type Options struct {
+	Layout       string // LayoutDaily, LayoutHourly or LayoutHashed
+	PreserveName bool   // Append the sanitized original name
+	EntropyBytes int    // Random bytes in the name; 0 means DefaultEntropyBytes
+	Style        string // StyleHex or StyleShort
+}
+
 // GenerateFileName generates a new filename based on the naming rule
-// Format: YYYYMMDD-HHMMSSmmm-random16bytes.ext
-func GenerateFileName(originalName string) string {
-	// Get current time with milliseconds
-	now := time.Now()
+// Format: YYYYMMDD-HHMMSSmmm-random.ext, or with PreserveName
+// YYYYMMDD-HHMMSSmmm-random-original-name.ext
+func GenerateFileName(originalName string, opts Options) string {
+	return generateFileName(time.Now(), originalName, opts)
+}
 
-	// Format: YYYYMMDD-HHMMSSmmm-random16bytes.ext
+func generateFileName(now time.Time, originalName string, opts Options) string {
+	// Format: YYYYMMDD-HHMMSSmmm-random.ext
 	timestamp := now.Format("20060102-150405")
 	milliseconds := now.Nanosecond() / 1000000
 	timestampWithMs := fmt.Sprintf("%s%03d", timestamp, milliseconds)
 
-	// Generate 16 random bytes (32 hex characters)
-	randomBytes := make([]byte, 16)
-	if _, err := rand.Read(randomBytes); err != nil {
-		// Fallback: use timestamp-based random if crypto rand fails
-		randomBytes = []byte(fmt.Sprintf("%016x", now.UnixNano()))
-	}
-	randomStr := fmt.Sprintf("%032x", randomBytes)
+	randomStr := randomComponent(now, opts)
 
 	// Get file extension
-	ext := strings.ToLower(filepath.Ext(originalName))
+	ext := strings.ToLower(filepath.Ext(baseName(originalName)))
 	if ext == "" {
 		ext = ".bin"
 	}
 
+	if opts.PreserveName {
+		if name := SanitizeName(originalName); name != "" {
+			return fmt.Sprintf("%s-%s-%s%s", timestampWithMs, randomStr, name, ext)
+		}
+	}
 	return fmt.Sprintf("%s-%s%s", timestampWithMs, randomStr, ext)
 }
 
+// randomComponent returns EntropyBytes random bytes encoded in the requested
+// style. The length depends only on the options, so names line up.
+func randomComponent(now time.Time, opts Options) string {
+	n := opts.EntropyBytes
+	if n <= 0 {
+		n = DefaultEntropyBytes
+	} else if n < MinEntropyBytes {
+		n = MinEntropyBytes
+	}
+
+	randomBytes := make([]byte, n)
+	if _, err := rand.Read(randomBytes); err != nil {
+		// Fallback: use timestamp-based random if crypto rand fails
+		fallback := []byte(fmt.Sprintf("%016x", now.UnixNano()))
+		for i := range randomBytes {
+			randomBytes[i] = fallback[i%len(fallback)]
+		}
+	}
+
+	if opts.Style != StyleShort {
+		return fmt.Sprintf("%x", randomBytes)
+	}
+	return encodeBase62(randomBytes)
+}
+
+// encodeBase62 encodes b in base62, zero-padded to the length the largest
+// value of len(b) bytes needs
+func encodeBase62(b []byte) string {
+	width := int(math.Ceil(float64(len(b)*8) / math.Log2(float64(len(base62)))))
+	out := make([]byte, width)
+	value := new(big.Int).SetBytes(b)
+	radix := big.NewInt(int64(len(base62)))
+	digit := new(big.Int)
+	for i := width - 1; i >= 0; i-- {
+		value.DivMod(value, radix, digit)
+		out[i] = base62[digit.Int64()]
+	}
+	return string(out)
+}
+
+// SanitizeName reduces an uploaded file's name, without its extension, to
+// something safe to put in a file name and URL: path separators, control
+// characters and anything outside letters, digits, '-', '_' and '.' become
+// dashes, and the result is capped at 64 characters
+func SanitizeName(originalName string) string {
+	name := baseName(originalName)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	var b strings.Builder
+	dash := false
+	for _, c := range name {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.' {
+			b.WriteRune(c)
+			dash = false
+		} else if !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	sanitized := strings.Trim(b.String(), "-.")
+	if len(sanitized) > maxOriginalNameLength {
+		sanitized = sanitized[:maxOriginalNameLength]
+		for !utf8.ValidString(sanitized) {
+			sanitized = sanitized[:len(sanitized)-1]
+		}
+		sanitized = strings.TrimRight(sanitized, "-.")
+	}
+	return sanitized
+}
+
+// baseName returns the last element of a client-supplied path, which may use
+// either separator whatever the server's OS
+func baseName(name string) string {
+	if i := strings.LastIndexAny(name, "/\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
 // GenerateDateDir generates the date directory name (YYYYMMDD)
 func GenerateDateDir() string {
 	return time.Now().Format("20060102")
 }
 
-// GenerateFilePath generates the full relative file path
-// Returns: YYYYMMDD/YYYYMMDD-HHMMSSmmm-random16bytes.ext
-func GenerateFilePath(originalName string) (string, error) {
-	date := GenerateDateDir()
-	fileName := GenerateFileName(originalName)
+// GenerateFilePath generates the full relative file path in the options'
+// layout; an unknown layout falls back to LayoutDaily
+// Returns: YYYYMMDD/YYYYMMDD-HHMMSSmmm-random.ext for LayoutDaily
+func GenerateFilePath(originalName string, opts Options) (string, error) {
+	now := time.Now()
+	fileName := generateFileName(now, originalName, opts)
+	date := now.Format("20060102")
+
+	switch opts.Layout {
+	case LayoutHourly:
+		return filepath.Join(date, now.Format("15"), fileName), nil
+	case LayoutHashed:
+		// The random component follows the date and time
+		random := fileName[len("20060102-150405000-"):]
+		return filepath.Join(random[0:2], random[2:4], fileName), nil
+	}
 	return filepath.Join(date, fileName), nil
 }
 
-// ParseDateFromPath extracts the date directory from a file path
+// ParseDateFromPath extracts the upload date (YYYYMMDD) from a file path in
+// any layout: the date directory, or the generated name's timestamp when the
+// path doesn't start with one. It returns "" for paths that have neither.
 func ParseDateFromPath(filePath string) string {
 	// Normalize path separators to /
 	filePath = filepath.ToSlash(filePath)
 	parts := strings.Split(filePath, "/")
-	if len(parts) > 0 {
+	if len(parts) < 2 {
+		return ""
+	}
+	if isDate(parts[0]) {
 		return parts[0]
 	}
+	if name := parts[len(parts)-1]; len(name) > 8 && name[8] == '-' && isDate(name[:8]) {
+		return name[:8]
+	}
 	return ""
 }
 
-// GetStoragePath returns the full storage path for a relative file path
-func GetStoragePath(imagesDir, relativePath string) string {
-	return filepath.Join(imagesDir, relativePath)
+// IsFilePath reports whether a path looks like a stored file in any layout:
+// a dated file name with an extension below at least one directory
+func IsFilePath(filePath string) bool {
+	filePath = filepath.ToSlash(filePath)
+	return ParseDateFromPath(filePath) != "" && path.Ext(filePath) != ""
+}
+
+// isDate reports whether s is an 8-digit YYYYMMDD directory name
+func isDate(s string) bool {
+	if len(s) != 8 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Slug and tag length limits
+const (
+	MinSlugLength = 3
+	MaxSlugLength = 64
+	MinTagLength  = 1
+	MaxTagLength  = 64
+)
+
+// ValidateSlug checks that a slug only uses the safe charset [a-z0-9-_]
+func ValidateSlug(slug string) error {
+	return validateIdentifier("slug", slug, MinSlugLength, MaxSlugLength)
+}
+
+// ValidateTag checks that a tag only uses the safe charset [a-z0-9-_]
+func ValidateTag(tag string) error {
+	return validateIdentifier("tag", tag, MinTagLength, MaxTagLength)
+}
+
+// validateIdentifier enforces the safe charset so values can't smuggle path separators
+func validateIdentifier(kind, value string, minLen, maxLen int) error {
+	if len(value) < minLen || len(value) > maxLen {
+		return fmt.Errorf("%s must be between %d and %d characters", kind, minLen, maxLen)
+	}
+	for _, c := range value {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return fmt.Errorf("%s may only contain lowercase letters, digits, '-' and '_'", kind)
+		}
+	}
+	return nil
+}
+
+// MaxOriginalNameBytes bounds an original name set through the API
+const MaxOriginalNameBytes = 255
+
+// ValidateOriginalName checks a file's original name as shown in listings and
+// downloads: one path element of valid UTF-8 without control characters
+func ValidateOriginalName(name string) error {
+	if name == "" || len(name) > MaxOriginalNameBytes {
+		return fmt.Errorf("original name must be between 1 and %d bytes", MaxOriginalNameBytes)
+	}
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("original name must be valid UTF-8")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("original name may not contain path separators")
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return fmt.Errorf("original name may not contain control characters")
+		}
+	}
+	return nil
 }
@@ -0,0 +1,159 @@
+package naming
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	hexChars    = regexp.MustCompile(`^[0-9a-f]+$`)
+	base62Chars = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+	namePattern = regexp.MustCompile(`^(\d{8})-(\d{9})-([0-9A-Za-z]+)(\.[a-z0-9]+)$`)
+)
+
+// randomOf returns the random component of a generated name
+func randomOf(t *testing.T, name string) string {
+	t.Helper()
+	m := namePattern.FindStringSubmatch(name)
+	if m == nil {
+		t.Fatalf("name %q doesn't match YYYYMMDD-HHMMSSmmm-random.ext", name)
+	}
+	return m[3]
+}
+
+func TestRandomComponentCharsetAndLength(t *testing.T) {
+	tests := []struct {
+		style   string
+		entropy int
+		length  int
+		charset *regexp.Regexp
+	}{
+		{"", 0, 32, hexChars}, // Zero options keep the original format
+		{StyleHex, 16, 32, hexChars},
+		{StyleHex, 8, 16, hexChars},
+		{StyleHex, 4, 8, hexChars},
+		{StyleHex, 2, 8, hexChars}, // Raised to MinEntropyBytes
+		{StyleShort, 16, 22, base62Chars},
+		{StyleShort, 8, 11, base62Chars},
+		{StyleShort, 4, 6, base62Chars},
+		{StyleShort, 1, 6, base62Chars},
+	}
+	for _, tt := range tests {
+		opts := Options{Style: tt.style, EntropyBytes: tt.entropy}
+		for i := 0; i < 50; i++ {
+			random := randomOf(t, GenerateFileName("photo.PNG", opts))
+			if len(random) != tt.length || !tt.charset.MatchString(random) {
+				t.Fatalf("style %q, %d bytes: random component %q, want %d characters of %s",
+					tt.style, tt.entropy, random, tt.length, tt.charset)
+			}
+		}
+	}
+}
+
+func TestEncodeBase62PadsToFixedWidth(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte{0, 0, 0, 0}, "000000"},
+		{[]byte{0, 0, 0, 61}, "00000z"},
+		{[]byte{0, 0, 0, 62}, "000010"},
+		{[]byte{0xff, 0xff, 0xff, 0xff}, "4gfFC3"},
+	}
+	for _, tt := range tests {
+		if got := encodeBase62(tt.in); got != tt.want {
+			t.Errorf("encodeBase62(%x) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateFileNameExtensionAndOriginalName(t *testing.T) {
+	tests := []struct {
+		original string
+		preserve bool
+		suffix   string
+	}{
+		{"Photo.JPG", false, ".jpg"},
+		{"archive", false, ".bin"},
+		{`C:\Users\me\My Shot.png`, true, "-My-Shot.png"},
+		{"../../etc/passwd.txt", true, "-passwd.txt"},
+		{"!!!.png", true, ".png"}, // Nothing left to keep
+	}
+	for _, tt := range tests {
+		name := GenerateFileName(tt.original, Options{PreserveName: tt.preserve})
+		if !strings.HasSuffix(name, tt.suffix) || strings.ContainsAny(name, `/\ `) {
+			t.Errorf("GenerateFileName(%q, preserve %v) = %q, want suffix %q", tt.original, tt.preserve, name, tt.suffix)
+		}
+	}
+}
+
+func TestGenerateFilePathLayouts(t *testing.T) {
+	now := time.Now()
+	date := now.Format("20060102")
+	for _, layout := range []string{"", LayoutDaily, LayoutHourly, LayoutHashed} {
+		p, err := GenerateFilePath("a.png", Options{Layout: layout})
+		if err != nil {
+			t.Fatalf("GenerateFilePath(%s): %v", layout, err)
+		}
+		parts := strings.Split(filepath.ToSlash(p), "/")
+		name := parts[len(parts)-1]
+		random := randomOf(t, name)
+		switch layout {
+		case LayoutHourly:
+			if len(parts) != 3 || parts[0] != date || len(parts[1]) != 2 {
+				t.Errorf("hourly path %q", p)
+			}
+		case LayoutHashed:
+			if len(parts) != 3 || parts[0] != random[0:2] || parts[1] != random[2:4] {
+				t.Errorf("hashed path %q", p)
+			}
+		default:
+			if len(parts) != 2 || parts[0] != date {
+				t.Errorf("daily path %q", p)
+			}
+		}
+		if got := ParseDateFromPath(p); got != date {
+			t.Errorf("ParseDateFromPath(%q) = %q, want %q", p, got, date)
+		}
+		if !IsFilePath(p) {
+			t.Errorf("IsFilePath(%q) = false", p)
+		}
+	}
+}
+
+func TestParseDateFromPath(t *testing.T) {
+	tests := map[string]string{
+		"20240101/20240101-120000000-abc.png":    "20240101",
+		"20240101/12/20240101-120000000-abc.png": "20240101",
+		"ab/cd/20240101-120000000-abcd.png":      "20240101",
+		"20240101-120000000-abc.png":             "",
+		"ab/cd/photo.png":                        "",
+		"2024010/x.png":                          "",
+	}
+	for p, want := range tests {
+		if got := ParseDateFromPath(p); got != want {
+			t.Errorf("ParseDateFromPath(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"My Holiday Photo.jpg":     "My-Holiday-Photo",
+		"../../secret.txt":         "secret",
+		`C:\dir\file name.png`:     "file-name",
+		"résumé final.pdf":         "résumé-final",
+		"--.hidden.":               "hidden",
+		strings.Repeat("a", 80):    strings.Repeat("a", 64),
+		strings.Repeat("é", 40):    strings.Repeat("é", 32),
+		"control\x00\x1fchars.txt": "control-chars",
+	}
+	for in, want := range tests {
+		if got := SanitizeName(in); got != want {
+			t.Errorf("SanitizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
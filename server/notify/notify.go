@@ -0,0 +1,212 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"httpserver/server/db"
+)
+
+// Event identifies the kind of notification
+type Event string
+
+const (
+	EventUpload   Event = "upload"
+	EventDelete   Event = "delete"
+	EventCleanup  Event = "cleanup"
+	EventExpiring Event = "expiring"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body
+const SignatureHeader = "X-Webhook-Signature"
+
+const (
+	queueSize   = 256
+	maxAttempts = 5
+	baseBackoff = 2 * time.Second
+)
+
+// Payload is the JSON body posted to the webhook
+type Payload struct {
+	Event        Event       `json:"event"`
+	Timestamp    time.Time   `json:"timestamp"`
+	FilePath     string      `json:"file_path,omitempty"`
+	OriginalName string      `json:"original_name,omitempty"`
+	Size         int64       `json:"size,omitempty"`
+	UploaderIP   string      `json:"uploader_ip,omitempty"`
+	ExpiresAt    *time.Time  `json:"expires_at,omitempty"`
+	Files        []FileEntry `json:"files,omitempty"` // Batch events such as expiry warnings
+}
+
+// FileEntry describes one file inside a batch event
+type FileEntry struct {
+	FilePath     string    `json:"file_path"`
+	OriginalName string    `json:"original_name"`
+	Size         int64     `json:"size"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Config holds webhook settings
+type Config struct {
+	WebhookURL    string
+	WebhookSecret string
+	Events        []string // Empty means all events
+}
+
+// Notifier delivers webhook events asynchronously with retries
+type Notifier struct {
+	cfg      *Config
+	events   map[Event]bool
+	queue    chan Payload
+	client   *http.Client
+	stopChan chan struct{}
+}
+
+// NewNotifier creates a new notifier
+func NewNotifier(cfg *Config) *Notifier {
+	n := &Notifier{
+		cfg:      cfg,
+		events:   make(map[Event]bool),
+		queue:    make(chan Payload, queueSize),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		stopChan: make(chan struct{}),
+	}
+	for _, e := range cfg.Events {
+		if e = strings.TrimSpace(e); e != "" {
+			n.events[Event(e)] = true
+		}
+	}
+	return n
+}
+
+// Start starts the delivery worker
+func (n *Notifier) Start() {
+	if n.cfg.WebhookURL == "" {
+		return
+	}
+	log.Printf("Webhook notifications enabled (%s)", n.cfg.WebhookURL)
+	go n.deliveryLoop()
+}
+
+// Stop stops the delivery worker
+func (n *Notifier) Stop() {
+	close(n.stopChan)
+}
+
+// Enabled reports whether a webhook is configured and subscribed to the event
+func (n *Notifier) Enabled(event Event) bool {
+	if n == nil || n.cfg.WebhookURL == "" {
+		return false
+	}
+	return len(n.events) == 0 || n.events[event]
+}
+
+// Notify queues an event for delivery without blocking the caller
+func (n *Notifier) Notify(p Payload) {
+	if !n.Enabled(p.Event) {
+		return
+	}
+	if p.Timestamp.IsZero() {
+		p.Timestamp = time.Now()
+	}
+
+	select {
+	case n.queue <- p:
+	default:
+		log.Printf("Webhook queue full, dropping %s event for %s", p.Event, p.FilePath)
+	}
+}
+
+// NotifyFile queues a single-file event built from file metadata
+func (n *Notifier) NotifyFile(event Event, meta *db.FileMetadata) {
+	payload := Payload{
+		Event:        event,
+		FilePath:     meta.FilePath,
+		OriginalName: meta.OriginalName,
+		Size:         meta.FileSize,
+		UploaderIP:   meta.RemoteIP,
+	}
+	if !meta.IsPinned() {
+		expiresAt := meta.ExpiresAt
+		payload.ExpiresAt = &expiresAt
+	}
+	n.Notify(payload)
+}
+
+// deliveryLoop sends queued events one at a time
+func (n *Notifier) deliveryLoop() {
+	for {
+		select {
+		case p := <-n.queue:
+			n.deliver(p)
+		case <-n.stopChan:
+			return
+		}
+	}
+}
+
+// deliver posts an event, retrying with exponential backoff
+func (n *Notifier) deliver(p Payload) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Failed to marshal webhook payload: %v", err)
+		return
+	}
+
+	backoff := baseBackoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = n.post(body)
+		if err == nil {
+			return
+		}
+		if attempt == maxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-n.stopChan:
+			return
+		}
+	}
+	log.Printf("Webhook delivery of %s event failed after %d attempts: %v", p.Event, maxAttempts, err)
+}
+
+// post performs a single signed webhook request
+func (n *Notifier) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.cfg.WebhookSecret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(n.cfg.WebhookSecret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body using secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
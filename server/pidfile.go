@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpserver/server/config"
+)
+
+// pidFilePath returns the configured PID file, or one next to the database
+func pidFilePath(dbPath string, cfg *config.Config) string {
+	if cfg.Server.PIDFile != "" {
+		return cfg.Server.PIDFile
+	}
+	return dbPath + ".pid"
+}
+
+// readPIDFile returns the PID recorded in a PID file
+func readPIDFile(path string) (int, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid PID file %s", path)
+	}
+	return pid, nil
+}
+
+// writePIDFile records this process in the PID file
+func writePIDFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePIDFile removes the PID file if it still belongs to this process
+func removePIDFile(path string) {
+	if pid, err := readPIDFile(path); err == nil && pid == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+// checkRunningInstance refuses to start when the PID file names a live process
+// or another server already answers on the configured port. A PID file left by
+// a crash is recognized as stale and removed.
+func checkRunningInstance(pidPath string, cfg *config.Config) error {
+	if pid, err := readPIDFile(pidPath); err == nil && pid != os.Getpid() {
+		if processAlive(pid) {
+			return fmt.Errorf("another instance is already running (PID %d, from %s)", pid, pidPath)
+		}
+		log.Printf("Removing stale PID file %s (PID %d is not running)", pidPath, pid)
+		os.Remove(pidPath)
+	}
+
+	if version, ok := probeServer(cfg); ok {
+		return fmt.Errorf("a server (version %s) is already answering on %s", version, cfg.ListenAddresses()[0])
+	}
+	return nil
+}
+
+// probeServer asks whatever listens on the configured address for its health
+// status, returning its version if it is one of ours. There is nothing to ask
+// when the port is picked at startup.
+func probeServer(cfg *config.Config) (string, bool) {
+	addrs := cfg.ListenAddresses()
+	if _, port, err := net.SplitHostPort(addrs[0].Address); err == nil && port == "0" {
+		return "", false
+	}
+	endpoint, socket := localEndpoint(addrs)
+	resp, err := controlClient(socket, time.Second).Get(endpoint + "/health?verbose=1")
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil || health.Status == "" {
+		return "", false
+	}
+	if health.Version == "" {
+		health.Version = "unknown"
+	}
+	return health.Version, true
+}
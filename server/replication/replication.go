@@ -0,0 +1,366 @@
+// Package replication mirrors uploads, deletions and expiry changes to a standby
+// server. Changes are queued in the database so pending items survive a restart,
+// and are pushed in order to the standby's /api/replication endpoints.
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/storage"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	baseBackoff  = 2 * time.Second
+	maxBackoff   = 10 * time.Minute
+	apiTimeout   = 30 * time.Second
+)
+
+// FileRecord is the metadata sent along with a replicated file
+type FileRecord struct {
+	FilePath      string    `json:"file_path"`
+	OriginalName  string    `json:"original_name"`
+	UploadedAt    time.Time `json:"uploaded_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	TTL           int       `json:"ttl"`
+	RemoteIP      string    `json:"remote_ip,omitempty"`
+	Slug          string    `json:"slug,omitempty"`
+	Tag           string    `json:"tag,omitempty"`
+	Hash          string    `json:"hash,omitempty"`
+	RetentionRule string    `json:"retention_rule,omitempty"`
+}
+
+// ExpiryChange is the body of an expiry update
+type ExpiryChange struct {
+	FilePath  string    `json:"file_path"`
+	TTL       int       `json:"ttl"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Config holds replication settings
+type Config struct {
+	TargetURL string // Base URL of the standby server
+	APIKey    string // Token with the replicate scope on the standby
+}
+
+// Replicator pushes queued changes to the standby server
+type Replicator struct {
+	cfg      *Config
+	db       *db.Database
+	store    storage.Backend
+	client   *http.Client // File uploads, which may take arbitrarily long
+	api      *http.Client // Deletions and expiry changes
+	wake     chan struct{}
+	stopChan chan struct{}
+	done     chan struct{}
+
+	mu            sync.Mutex
+	lastError     string
+	lastErrorAt   time.Time
+	lastSuccessAt time.Time
+}
+
+// NewReplicator creates a new replicator
+func NewReplicator(cfg *Config, database *db.Database, store storage.Backend) *Replicator {
+	cfg.TargetURL = strings.TrimSuffix(cfg.TargetURL, "/")
+	return &Replicator{
+		cfg:      cfg,
+		db:       database,
+		store:    store,
+		client:   &http.Client{},
+		api:      &http.Client{Timeout: apiTimeout},
+		wake:     make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Enabled reports whether a replication target is configured
+func (r *Replicator) Enabled() bool {
+	return r != nil && r.cfg.TargetURL != ""
+}
+
+// Start starts the replication worker
+func (r *Replicator) Start() {
+	if !r.Enabled() {
+		return
+	}
+	if depth, _ := r.db.ReplicationQueueDepth(); depth > 0 {
+		log.Printf("Replication enabled (%s), %d pending change(s)", r.cfg.TargetURL, depth)
+	} else {
+		log.Printf("Replication enabled (%s)", r.cfg.TargetURL)
+	}
+	go r.loop()
+}
+
+// Stop stops the replication worker, waiting for an in-flight change to finish
+func (r *Replicator) Stop() {
+	if !r.Enabled() {
+		return
+	}
+	close(r.stopChan)
+	<-r.done
+}
+
+// Enqueue queues a change for replication without blocking the caller
+func (r *Replicator) Enqueue(op, filePath string) {
+	if !r.Enabled() {
+		return
+	}
+	if err := r.db.EnqueueReplication(op, filePath); err != nil {
+		log.Printf("Failed to queue replication of %s %s: %v", op, filePath, err)
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status reports the queue depth and the outcome of recent attempts, or nil
+// when replication is disabled
+func (r *Replicator) Status() *db.ReplicationStatus {
+	if !r.Enabled() {
+		return nil
+	}
+	status := &db.ReplicationStatus{Target: r.cfg.TargetURL}
+	depth, oldest := r.db.ReplicationQueueDepth()
+	status.QueueDepth = depth
+	if depth > 0 {
+		status.OldestPending = &oldest
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status.LastError = r.lastError
+	if !r.lastErrorAt.IsZero() {
+		t := r.lastErrorAt
+		status.LastErrorAt = &t
+	}
+	if !r.lastSuccessAt.IsZero() {
+		t := r.lastSuccessAt
+		status.LastSuccessAt = &t
+	}
+	return status
+}
+
+// loop drains the queue whenever a change is queued and on a timer, so items
+// waiting for a retry are picked up once their backoff has passed
+func (r *Replicator) loop() {
+	defer close(r.done)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	r.drain()
+	for {
+		select {
+		case <-r.wake:
+			r.drain()
+		case <-ticker.C:
+			r.drain()
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// drain replicates queued changes oldest first. It stops at the first change
+// that fails or is still backing off, so the standby sees changes in order.
+func (r *Replicator) drain() {
+	for _, item := range r.db.PendingReplication() {
+		select {
+		case <-r.stopChan:
+			return
+		default:
+		}
+		if time.Now().Before(item.NextAttempt) {
+			return
+		}
+
+		if err := r.replicate(item); err != nil {
+			item.Attempts++
+			item.LastError = err.Error()
+			item.NextAttempt = time.Now().Add(backoff(item.Attempts))
+			if err := r.db.UpdateReplication(item); err != nil {
+				log.Printf("Failed to update replication queue: %v", err)
+			}
+			r.recordError(err)
+			log.Printf("Replication of %s %s failed (attempt %d, retrying in %s): %v",
+				item.Op, item.FilePath, item.Attempts, backoff(item.Attempts), err)
+			return
+		}
+
+		if err := r.db.RemoveReplication(item.ID); err != nil {
+			log.Printf("Failed to update replication queue: %v", err)
+		}
+		r.recordSuccess()
+	}
+}
+
+// backoff returns the delay before the next attempt, doubling per failure
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+func (r *Replicator) recordError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastError = err.Error()
+	r.lastErrorAt = time.Now()
+}
+
+func (r *Replicator) recordSuccess() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastSuccessAt = time.Now()
+}
+
+// replicate pushes a single change to the standby
+func (r *Replicator) replicate(item db.ReplicationItem) error {
+	switch item.Op {
+	case db.ReplicateUpload:
+		return r.sendFile(item.FilePath)
+	case db.ReplicateDelete:
+		return r.sendDelete(item.FilePath)
+	case db.ReplicateExpiry:
+		return r.sendExpiry(item.FilePath)
+	default:
+		log.Printf("Dropping replication item with unknown operation %q", item.Op)
+		return nil
+	}
+}
+
+// sendFile streams a file and its metadata as a multipart upload. Files deleted
+// since the change was queued are skipped; their deletion follows in the queue.
+func (r *Replicator) sendFile(filePath string) error {
+	meta, err := r.db.GetFileMetadata(filePath)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+	record, err := json.Marshal(FileRecord{
+		FilePath:      meta.FilePath,
+		OriginalName:  meta.OriginalName,
+		UploadedAt:    meta.UploadedAt,
+		ExpiresAt:     meta.ExpiresAt,
+		TTL:           meta.TTL,
+		RemoteIP:      meta.RemoteIP,
+		Slug:          meta.Slug,
+		Tag:           meta.Tag,
+		Hash:          meta.Hash,
+		RetentionRule: meta.RetentionRule,
+	})
+	if err != nil {
+		return err
+	}
+
+	src, err := r.store.Get(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := mw.WriteField("metadata", string(record))
+		if err == nil {
+			var part io.Writer
+			part, err = mw.CreateFormFile("file", meta.OriginalName)
+			if err == nil {
+				_, err = io.Copy(part, src)
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, r.cfg.TargetURL+"/api/replication/files", pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return r.send(r.client, req, false)
+}
+
+// sendDelete removes a file from the standby; a file it doesn't have counts as deleted
+func (r *Replicator) sendDelete(filePath string) error {
+	target := r.cfg.TargetURL + "/api/replication/files?path=" + url.QueryEscape(filePath)
+	req, err := http.NewRequest(http.MethodDelete, target, nil)
+	if err != nil {
+		return err
+	}
+	return r.send(r.api, req, true)
+}
+
+// sendExpiry copies a file's current TTL and expiry time to the standby
+func (r *Replicator) sendExpiry(filePath string) error {
+	meta, err := r.db.GetFileMetadata(filePath)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+	body, err := json.Marshal(ExpiryChange{FilePath: meta.FilePath, TTL: meta.TTL, ExpiresAt: meta.ExpiresAt})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.cfg.TargetURL+"/api/replication/expiry", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return r.send(r.api, req, false)
+}
+
+// send performs an authenticated request, turning error statuses into errors
+func (r *Replicator) send(client *http.Client, req *http.Request, allowNotFound bool) error {
+	req.Header.Set("X-API-Key", r.cfg.APIKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if allowNotFound && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	raw, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(raw, &body) == nil && body.Message != "" {
+		return fmt.Errorf("standby returned status %d: %s", resp.StatusCode, body.Message)
+	}
+	return fmt.Errorf("standby returned status %d", resp.StatusCode)
+}
@@ -0,0 +1,324 @@
+// Package resumable keeps uploads that arrive in chunks over several requests.
+// Each upload is a pair of files in one directory: <id>.data, which chunks are
+// appended to, and <id>.json, its state. The state is replaced atomically after
+// every chunk, so an upload survives a server restart and resumes from the
+// last chunk recorded; bytes written past it by an interrupted request are
+// cut off before the next chunk is appended.
+package resumable
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirName is the directory holding resumable uploads, under the upload
+// temporary directory
+const DirName = "resumable"
+
+// Chunk sizes a client may ask for; DefaultChunkSize when it doesn't
+const (
+	MinChunkSize     = 256 * 1024
+	MaxChunkSize     = 64 * 1024 * 1024
+	DefaultChunkSize = 8 * 1024 * 1024
+)
+
+// Errors returned by Store methods
+var (
+	ErrNotFound         = errors.New("upload not found")
+	ErrChunkOutOfOrder  = errors.New("chunk out of order")
+	ErrChunkSize        = errors.New("chunk has the wrong size")
+	ErrChecksumMismatch = errors.New("chunk checksum mismatch")
+	ErrIncomplete       = errors.New("upload is incomplete")
+)
+
+// Upload is the state of a resumable upload
+type Upload struct {
+	ID        string            `json:"id"`
+	FileName  string            `json:"file_name"`
+	Size      int64             `json:"size"`       // Declared total size
+	SHA256    string            `json:"sha256"`     // Declared hash of the whole file
+	ChunkSize int64             `json:"chunk_size"` // Every chunk but the last has this size
+	Fields    map[string]string `json:"fields"`     // Upload form fields (ttl, slug, tag)
+	Owner     string            `json:"owner"`      // Name of the API token that started it
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+
+	Received int64    `json:"received"` // Bytes appended so far
+	Chunks   []string `json:"chunks"`   // sha256 of each chunk received, by index
+	Hasher   []byte   `json:"hasher"`   // Marshaled sha256 state over the received bytes
+}
+
+// NextIndex returns the index of the next chunk expected
+func (u *Upload) NextIndex() int {
+	return len(u.Chunks)
+}
+
+// Complete reports whether every byte has been received
+func (u *Upload) Complete() bool {
+	return u.Received == u.Size
+}
+
+// Store keeps resumable uploads in a directory
+type Store struct {
+	dir string
+	mu  sync.Mutex // Serializes changes; chunks of one upload must not interleave
+}
+
+// NewStore returns a store over dir, which is created on first use
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// DataPath returns the file an upload's chunks are appended to
+func (st *Store) DataPath(id string) string {
+	return filepath.Join(st.dir, id+".data")
+}
+
+func (st *Store) statePath(id string) string {
+	return filepath.Join(st.dir, id+".json")
+}
+
+// Create starts an upload, filling in its ID and timestamps
+func (st *Store) Create(u *Upload) error {
+	if err := os.MkdirAll(st.dir, 0755); err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	u.ID = hex.EncodeToString(id)
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	u.Received = 0
+	u.Chunks = []string{}
+	state, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	u.Hasher = state
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := ioutil.WriteFile(st.DataPath(u.ID), nil, 0644); err != nil {
+		return err
+	}
+	return st.save(u)
+}
+
+// Get returns an upload's state
+func (st *Store) Get(id string) (*Upload, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.load(id)
+}
+
+// Append adds chunk index of an upload, read from r and checked against its
+// sha256 (hex). A chunk that was already received is accepted again without
+// change when its checksum matches, so a client can resend a chunk whose
+// response it never saw.
+func (st *Store) Append(id string, index int, r io.Reader, checksum string) (*Upload, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, err := st.load(id)
+	if err != nil {
+		return nil, err
+	}
+	checksum = strings.ToLower(checksum)
+	if index < u.NextIndex() {
+		if u.Chunks[index] != checksum {
+			return u, ErrChecksumMismatch
+		}
+		io.Copy(ioutil.Discard, r)
+		return u, nil
+	}
+	if index > u.NextIndex() {
+		return u, ErrChunkOutOfOrder
+	}
+
+	expected := u.ChunkSize
+	if remaining := u.Size - u.Received; remaining < expected {
+		expected = remaining
+	}
+	if expected <= 0 {
+		return u, ErrChunkOutOfOrder
+	}
+
+	f, err := os.OpenFile(st.DataPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Drop whatever an interrupted request wrote past the last recorded chunk
+	if err := f.Truncate(u.Received); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(u.Received, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	fileHasher, err := restoreHasher(u.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	chunkHasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, fileHasher, chunkHasher), io.LimitReader(r, expected+1))
+	if err == nil && n != expected {
+		err = ErrChunkSize
+	}
+	if err == nil && hex.EncodeToString(chunkHasher.Sum(nil)) != checksum {
+		err = ErrChecksumMismatch
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(u.Received)
+		return u, err
+	}
+
+	state, err := fileHasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	u.Hasher = state
+	u.Received += n
+	u.Chunks = append(u.Chunks, checksum)
+	u.UpdatedAt = time.Now()
+	if err := st.save(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Finish checks that an upload is complete and returns the hash of the data
+// received. The upload stays in the store until Remove.
+func (st *Store) Finish(id string) (*Upload, string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, err := st.load(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if !u.Complete() {
+		return u, "", ErrIncomplete
+	}
+	hasher, err := restoreHasher(u.Hasher)
+	if err != nil {
+		return nil, "", err
+	}
+	return u, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Remove deletes an upload and its data, if still there
+func (st *Store) Remove(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	os.Remove(st.statePath(id))
+	os.Remove(st.DataPath(id))
+}
+
+func (st *Store) load(id string) (*Upload, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	raw, err := ioutil.ReadFile(st.statePath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u Upload
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return nil, fmt.Errorf("corrupt upload state %s: %v", id, err)
+	}
+	return &u, nil
+}
+
+// save replaces the state file through a temporary file
+func (st *Store) save(u *Upload) error {
+	raw, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := st.statePath(u.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.statePath(u.ID))
+}
+
+// restoreHasher rebuilds a sha256 from its marshaled state
+func restoreHasher(state []byte) (interface {
+	io.Writer
+	Sum([]byte) []byte
+}, error) {
+	hasher := sha256.New()
+	if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("corrupt upload hash state: %v", err)
+	}
+	return hasher, nil
+}
+
+// validID reports whether id has the form Create gives IDs, so it can't
+// name a file outside the store
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// PurgeExpired removes uploads in dir that haven't received a chunk for
+// maxAge, and returns how many it removed and the bytes freed
+func PurgeExpired(dir string, maxAge time.Duration) (int, int64, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	st := NewStore(dir)
+	cutoff := time.Now().Add(-maxAge)
+	removed, freed := 0, int64(0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+		u, err := st.Get(id)
+		if err != nil && err != ErrNotFound {
+			// Unreadable state: go by the file's age
+			if entry.ModTime().After(cutoff) {
+				continue
+			}
+		} else if err != nil || u.UpdatedAt.After(cutoff) {
+			continue
+		}
+		if info, err := os.Stat(st.DataPath(id)); err == nil {
+			freed += info.Size()
+		}
+		st.Remove(id)
+		removed++
+	}
+	return removed, freed, nil
+}
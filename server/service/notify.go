@@ -0,0 +1,38 @@
+package service
+
+import (
+	"net"
+	"os"
+)
+
+// NotifyReady tells systemd the server is accepting connections (sd_notify
+// READY=1). It does nothing unless started by a Type=notify unit.
+func NotifyReady() error {
+	return sdNotify("READY=1")
+}
+
+// NotifyStopping tells systemd the server is shutting down
+func NotifyStopping() error {
+	return sdNotify("STOPPING=1")
+}
+
+// sdNotify sends a state update to the socket systemd passes in NOTIFY_SOCKET
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
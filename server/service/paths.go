@@ -0,0 +1,16 @@
+package service
+
+import "path/filepath"
+
+// getAbsolutePath returns the absolute path of a file
+func getAbsolutePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
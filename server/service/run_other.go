@@ -0,0 +1,16 @@
+// +build !windows
+
+package service
+
+import "fmt"
+
+// IsService reports whether the process was started by a service manager that
+// needs to be answered in-process; only Windows has one
+func IsService() bool {
+	return false
+}
+
+// Run is only needed on Windows
+func Run(stop func()) error {
+	return fmt.Errorf("not running as a Windows service")
+}
@@ -17,33 +17,58 @@ import (
 const systemdUnitTemplate = `[Unit]
 Description=HTTP Image Hosting Server
 After=network.target
+{{- if .Restart}}
+StartLimitIntervalSec={{.StartLimitInterval}}
+{{- if .StartLimitBurst}}
+StartLimitBurst={{.StartLimitBurst}}
+{{- end}}
+{{- end}}
 
 [Service]
-Type=simple
+Type=notify
+{{- if .User}}
 User={{.User}}
+{{- end}}
 WorkingDirectory={{.WorkingDir}}
-ExecStart={{.Executable}} --config {{.ConfigPath}}
+ExecStart={{.Executable}} start -c {{.DBPath}}
+{{- if .Restart}}
 Restart=always
 RestartSec=5
+{{- else}}
+Restart=no
+{{- end}}
 
 [Install]
-WantedBy=multi-user.target
+WantedBy={{.WantedBy}}
 `
 
-// Install installs the systemd service
-func Install(cfg *config.Config, executablePath string) error {
+// DefaultUserInstall is false: -i installs a system unit unless --user is given
+const DefaultUserInstall = false
+
+// unitName is the name of the systemd unit
+const unitName = "httpserver"
+
+// systemUnitPath is where the system-wide unit file is installed
+const systemUnitPath = "/etc/systemd/system/httpserver.service"
+
+// startLimitInterval is the window in which auto_restart.max_restart_count
+// restarts are allowed before systemd gives up
+const startLimitInterval = 600
+
+// Install installs the systemd service. With userUnit set it is installed for
+// the current user under ~/.config/systemd/user instead of system-wide, which
+// doesn't need root.
+func Install(cfg *config.Config, executablePath, dbPath string, userUnit bool) error {
 	if runtime.GOOS != "linux" {
 		return fmt.Errorf("service installation is only supported on Linux")
 	}
+	if !userUnit && os.Geteuid() != 0 {
+		return fmt.Errorf("installing a system service requires root; use --user to install a user unit instead")
+	}
 
-	// Get config path
-	configPath := getConfigPath()
-
-	// Update port in config if needed
-	if cfg.Server.Port != 0 {
-		if err := config.UpdatePort(configPath, cfg.Server.Port); err != nil {
-			return fmt.Errorf("failed to update port in config: %w", err)
-		}
+	unitPath, err := getUnitPath(userUnit)
+	if err != nil {
+		return err
 	}
 
 	// Get current user
@@ -62,15 +87,30 @@ func Install(cfg *config.Config, executablePath string) error {
 
 	// Prepare template data
 	data := struct {
-		User        string
-		WorkingDir  string
-		Executable  string
-		ConfigPath  string
+		User               string
+		WorkingDir         string
+		Executable         string
+		DBPath             string
+		Restart            bool
+		StartLimitInterval int
+		StartLimitBurst    int
+		WantedBy           string
 	}{
 		User:       user,
 		WorkingDir: execDir,
 		Executable: getAbsolutePath(executablePath),
-		ConfigPath: configPath,
+		DBPath:     getAbsolutePath(dbPath),
+		Restart:    cfg.AutoRestart.Enabled,
+		WantedBy:   "multi-user.target",
+	}
+	if cfg.AutoRestart.MaxRestartCount > 0 {
+		data.StartLimitInterval = startLimitInterval
+		data.StartLimitBurst = cfg.AutoRestart.MaxRestartCount
+	}
+	if userUnit {
+		// User units always run as their owner
+		data.User = ""
+		data.WantedBy = "default.target"
 	}
 
 	// Generate unit file
@@ -79,7 +119,9 @@ func Install(cfg *config.Config, executablePath string) error {
 		return fmt.Errorf("failed to parse template: %w", err)
 	}
 
-	unitPath := "/etc/systemd/system/httpserver.service"
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0755); err != nil {
+		return fmt.Errorf("failed to create unit directory: %w", err)
+	}
 	unitFile, err := os.Create(unitPath)
 	if err != nil {
 		return fmt.Errorf("failed to create unit file: %w", err)
@@ -92,39 +134,51 @@ func Install(cfg *config.Config, executablePath string) error {
 
 	// Run systemctl commands
 	log.Println("Reloading systemd daemon...")
-	runSystemctl("daemon-reload")
+	runSystemctl(userUnit, "daemon-reload")
 
 	log.Println("Enabling httpserver service...")
-	runSystemctl("enable", "httpserver")
+	runSystemctl(userUnit, "enable", unitName)
 
 	log.Println("Starting httpserver service...")
-	runSystemctl("start", "httpserver")
+	runSystemctl(userUnit, "start", unitName)
 
 	log.Printf("Service installed and started successfully!")
-	log.Printf("Use 'systemctl status httpserver' to check service status")
-	log.Printf("Use 'journalctl -u httpserver -f' to view logs")
+	if userUnit {
+		log.Printf("Use 'systemctl --user status httpserver' to check service status")
+		log.Printf("Use 'journalctl --user -u httpserver -f' to view logs")
+		log.Printf("Run 'loginctl enable-linger' to keep it running while you're logged out")
+	} else {
+		log.Printf("Use 'systemctl status httpserver' to check service status")
+		log.Printf("Use 'journalctl -u httpserver -f' to view logs")
+	}
 
 	return nil
 }
 
-// Uninstall uninstalls the systemd service
-func Uninstall() error {
+// Uninstall uninstalls the systemd service (the user unit when userUnit is set)
+func Uninstall(userUnit bool) error {
 	if runtime.GOOS != "linux" {
 		return fmt.Errorf("service uninstallation is only supported on Linux")
 	}
 
-	unitPath := "/etc/systemd/system/httpserver.service"
+	unitPath, err := getUnitPath(userUnit)
+	if err != nil {
+		return err
+	}
 
 	// Check if service exists
 	if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+		if !userUnit && isUserUnitInstalled() {
+			return fmt.Errorf("service is installed as a user unit; use -u --user to remove it")
+		}
 		return fmt.Errorf("service is not installed")
 	}
 
 	log.Println("Stopping httpserver service...")
-	runSystemctl("stop", "httpserver")
+	runSystemctl(userUnit, "stop", unitName)
 
 	log.Println("Disabling httpserver service...")
-	runSystemctl("disable", "httpserver")
+	runSystemctl(userUnit, "disable", unitName)
 
 	log.Println("Removing unit file...")
 	if err := os.Remove(unitPath); err != nil {
@@ -132,7 +186,7 @@ func Uninstall() error {
 	}
 
 	log.Println("Reloading systemd daemon...")
-	runSystemctl("daemon-reload")
+	runSystemctl(userUnit, "daemon-reload")
 
 	log.Println("Service uninstalled successfully")
 	log.Println("Config and data files were preserved")
@@ -140,47 +194,66 @@ func Uninstall() error {
 	return nil
 }
 
-// IsInstalled checks if the service is installed
+// IsInstalled checks if the service is installed, system-wide or as a user unit
 func IsInstalled() bool {
 	if runtime.GOOS != "linux" {
 		return false
 	}
 
-	_, err := os.Stat("/etc/systemd/system/httpserver.service")
-	return err == nil
+	if _, err := os.Stat(systemUnitPath); err == nil {
+		return true
+	}
+	return isUserUnitInstalled()
 }
 
-// runSystemctl executes a systemctl command
-func runSystemctl(args ...string) error {
-	cmd := exec.Command("systemctl", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
+// IsActive asks systemd whether the installed unit is running
+func IsActive() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
 
-// getAbsolutePath returns the absolute path of a file
-func getAbsolutePath(path string) string {
-	if filepath.IsAbs(path) {
-		return path
+	args := []string{"is-active", "--quiet", unitName}
+	if _, err := os.Stat(systemUnitPath); err != nil && isUserUnitInstalled() {
+		args = append([]string{"--user"}, args...)
 	}
+	return exec.Command("systemctl", args...).Run() == nil
+}
 
-	abs, err := filepath.Abs(path)
+// isUserUnitInstalled checks if the current user has the unit installed
+func isUserUnitInstalled() bool {
+	unitPath, err := getUnitPath(true)
 	if err != nil {
-		return path
+		return false
 	}
-	return abs
+	_, err = os.Stat(unitPath)
+	return err == nil
 }
 
-// getConfigPath returns the config file path
-func getConfigPath() string {
-	if path := os.Getenv("HTTPSERVER_CONFIG"); path != "" {
-		return path
+// getUnitPath returns where the unit file lives
+func getUnitPath(userUnit bool) (string, error) {
+	if !userUnit {
+		return systemUnitPath, nil
 	}
 
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "./HttpServer/config.json"
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
 	}
+	return filepath.Join(dir, "systemd", "user", unitName+".service"), nil
+}
 
-	return filepath.Join(home, "HttpServer", "config.json")
+// runSystemctl executes a systemctl command, against the user manager when
+// userUnit is set
+func runSystemctl(userUnit bool, args ...string) error {
+	if userUnit {
+		args = append([]string{"--user"}, args...)
+	}
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
 }
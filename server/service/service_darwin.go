@@ -0,0 +1,233 @@
+// +build darwin
+
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"text/template"
+
+	"httpserver/server/config"
+)
+
+// DefaultUserInstall is true because a per-user LaunchAgent is the usual way
+// to run a background server on a Mac; --system installs a LaunchDaemon instead
+const DefaultUserInstall = true
+
+// launchdLabel identifies the job to launchd
+const launchdLabel = "local.httpserver"
+
+const launchdPlistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{.Executable}}</string>
+		<string>start</string>
+		<string>-c</string>
+		<string>{{.DBPath}}</string>
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{.WorkingDir}}</string>
+{{- if .User}}
+	<key>UserName</key>
+	<string>{{.User}}</string>
+{{- end}}
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<{{if .Restart}}true{{else}}false{{end}}/>
+	<key>ThrottleInterval</key>
+	<integer>5</integer>
+	<key>StandardOutPath</key>
+	<string>{{.LogDir}}/httpserver.log</string>
+	<key>StandardErrorPath</key>
+	<string>{{.LogDir}}/httpserver.err.log</string>
+</dict>
+</plist>
+`
+
+// Install writes a launchd plist and loads it. With userUnit set it is a
+// LaunchAgent in ~/Library/LaunchAgents, otherwise a LaunchDaemon in
+// /Library/LaunchDaemons, which needs root.
+func Install(cfg *config.Config, executablePath, dbPath string, userUnit bool) error {
+	if !userUnit && os.Geteuid() != 0 {
+		return fmt.Errorf("installing a LaunchDaemon requires root; leave out --system to install a LaunchAgent instead")
+	}
+
+	plistPath, err := getPlistPath(userUnit)
+	if err != nil {
+		return err
+	}
+	logDir, err := getLogDir(userUnit)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	// Get executable directory
+	execDir := filepath.Dir(getAbsolutePath(executablePath))
+
+	data := struct {
+		Label      string
+		Executable string
+		DBPath     string
+		WorkingDir string
+		User       string
+		Restart    bool
+		LogDir     string
+	}{
+		Label:      launchdLabel,
+		Executable: xmlEscape(getAbsolutePath(executablePath)),
+		DBPath:     xmlEscape(getAbsolutePath(dbPath)),
+		WorkingDir: xmlEscape(execDir),
+		Restart:    cfg.AutoRestart.Enabled,
+		LogDir:     xmlEscape(logDir),
+	}
+	if !userUnit {
+		// Run the daemon as whoever ran sudo rather than as root
+		data.User = xmlEscape(os.Getenv("SUDO_USER"))
+	}
+
+	tmpl, err := template.New("plist").Parse(launchdPlistTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(plistPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(plistPath), err)
+	}
+	plistFile, err := os.Create(plistPath)
+	if err != nil {
+		return fmt.Errorf("failed to create plist: %w", err)
+	}
+	defer plistFile.Close()
+
+	if err := tmpl.Execute(plistFile, data); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	log.Println("Loading launchd job...")
+	if err := runLaunchctl("bootstrap", launchdDomain(userUnit), plistPath); err != nil {
+		return fmt.Errorf("plist written to %s but launchctl failed: %w", plistPath, err)
+	}
+
+	log.Printf("Service installed and started successfully!")
+	log.Printf("Use 'launchctl print %s/%s' to check service status", launchdDomain(userUnit), launchdLabel)
+	log.Printf("Logs are written to %s", logDir)
+	return nil
+}
+
+// Uninstall unloads the launchd job and removes its plist
+func Uninstall(userUnit bool) error {
+	plistPath, err := getPlistPath(userUnit)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
+		return fmt.Errorf("service is not installed")
+	}
+
+	log.Println("Unloading launchd job...")
+	runLaunchctl("bootout", launchdDomain(userUnit)+"/"+launchdLabel)
+
+	log.Println("Removing plist...")
+	if err := os.Remove(plistPath); err != nil {
+		return fmt.Errorf("failed to remove plist: %w", err)
+	}
+
+	log.Println("Service uninstalled successfully")
+	log.Println("Config and data files were preserved")
+	return nil
+}
+
+// IsInstalled checks if the service is installed as a LaunchAgent or LaunchDaemon
+func IsInstalled() bool {
+	for _, userUnit := range []bool{true, false} {
+		plistPath, err := getPlistPath(userUnit)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(plistPath); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive asks launchd whether the installed job is running
+func IsActive() bool {
+	for _, userUnit := range []bool{true, false} {
+		plistPath, err := getPlistPath(userUnit)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(plistPath); err != nil {
+			continue
+		}
+		out, err := exec.Command("launchctl", "print", launchdDomain(userUnit)+"/"+launchdLabel).Output()
+		return err == nil && bytes.Contains(out, []byte("state = running"))
+	}
+	return false
+}
+
+// getPlistPath returns where the plist lives
+func getPlistPath(userUnit bool) (string, error) {
+	if !userUnit {
+		return filepath.Join("/Library/LaunchDaemons", launchdLabel+".plist"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+}
+
+// getLogDir returns the directory stdout and stderr are written to
+func getLogDir(userUnit bool) (string, error) {
+	if !userUnit {
+		return "/Library/Logs/HttpServer", nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, "Library", "Logs", "HttpServer"), nil
+}
+
+// launchdDomain returns the launchctl domain the job is loaded into
+func launchdDomain(userUnit bool) string {
+	if userUnit {
+		return "gui/" + strconv.Itoa(os.Getuid())
+	}
+	return "system"
+}
+
+// runLaunchctl executes a launchctl command
+func runLaunchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// xmlEscape escapes a value for the plist
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
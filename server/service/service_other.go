@@ -0,0 +1,33 @@
+// +build !linux,!windows,!darwin
+
+package service
+
+import (
+	"fmt"
+	"runtime"
+
+	"httpserver/server/config"
+)
+
+// DefaultUserInstall is false; there is no service manager integration here
+const DefaultUserInstall = false
+
+// Install is not supported on this platform
+func Install(cfg *config.Config, executablePath, dbPath string, userUnit bool) error {
+	return fmt.Errorf("service installation is not supported on %s", runtime.GOOS)
+}
+
+// Uninstall is not supported on this platform
+func Uninstall(userUnit bool) error {
+	return fmt.Errorf("service uninstallation is not supported on %s", runtime.GOOS)
+}
+
+// IsInstalled always reports false on this platform
+func IsInstalled() bool {
+	return false
+}
+
+// IsActive always reports false on this platform
+func IsActive() bool {
+	return false
+}
@@ -4,21 +4,212 @@ package service
 
 import (
 	"fmt"
-	"runtime"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+
 	"httpserver/server/config"
 )
 
-// Install is not supported on Windows
-func Install(cfg *config.Config, executablePath string) error {
-	return fmt.Errorf("service installation is not supported on %s", runtime.GOOS)
+// DefaultUserInstall is false; Windows services are always system-wide
+const DefaultUserInstall = false
+
+// ServiceName is the name the service is registered under with the SCM
+const ServiceName = "HttpImageServer"
+
+// stopTimeout bounds how long Uninstall waits for a running service to stop
+const stopTimeout = 15 * time.Second
+
+// Install registers the executable as an automatically started Windows service
+// running `start -c <dbPath>`, and starts it. userUnit must be false; per-user
+// installs are a systemd feature.
+func Install(cfg *config.Config, executablePath, dbPath string, userUnit bool) error {
+	if userUnit {
+		return fmt.Errorf("--user is only supported with systemd")
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager (run as Administrator): %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(ServiceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", ServiceName)
+	}
+
+	s, err := m.CreateService(ServiceName, getAbsolutePath(executablePath), mgr.Config{
+		DisplayName: "HTTP Image Hosting Server",
+		Description: "Private image hosting with automatic expiry",
+		StartType:   mgr.StartAutomatic,
+	}, "start", "-c", getAbsolutePath(dbPath))
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	// Match the systemd unit: restart after a crash unless auto restart is off
+	if cfg.AutoRestart.Enabled {
+		if err := s.SetRecoveryActions([]mgr.RecoveryAction{
+			{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		}, uint32((24 * time.Hour).Seconds())); err != nil {
+			log.Printf("Warning: failed to set restart policy: %v", err)
+		}
+	}
+
+	if err := eventlog.InstallAsEventCreate(ServiceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		// Usually left over from an earlier install
+		if !strings.Contains(err.Error(), "exists") {
+			log.Printf("Warning: failed to register event log source: %v", err)
+		}
+	}
+
+	log.Printf("Starting %s service...", ServiceName)
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("service installed but failed to start: %w", err)
+	}
+
+	log.Printf("Service installed and started successfully!")
+	log.Printf("Use 'sc query %s' to check service status", ServiceName)
+	log.Printf("Logs are written to the Windows event log (Application, source %s)", ServiceName)
+	return nil
 }
 
-// Uninstall is not supported on Windows
-func Uninstall() error {
-	return fmt.Errorf("service uninstallation is not supported on %s", runtime.GOOS)
+// Uninstall stops and removes the Windows service
+func Uninstall(userUnit bool) error {
+	if userUnit {
+		return fmt.Errorf("--user is only supported with systemd")
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager (run as Administrator): %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(ServiceName)
+	if err != nil {
+		return fmt.Errorf("service is not installed")
+	}
+	defer s.Close()
+
+	log.Printf("Stopping %s service...", ServiceName)
+	if status, err := s.Control(svc.Stop); err == nil {
+		deadline := time.Now().Add(stopTimeout)
+		for status.State != svc.Stopped && time.Now().Before(deadline) {
+			time.Sleep(300 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+
+	log.Println("Removing service...")
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to remove service: %w", err)
+	}
+	eventlog.Remove(ServiceName)
+
+	log.Println("Service uninstalled successfully")
+	log.Println("Config and data files were preserved")
+	return nil
 }
 
-// IsInstalled always returns false on Windows
+// IsInstalled asks the service manager whether the service is registered
 func IsInstalled() bool {
-	return false
+	m, err := mgr.Connect()
+	if err != nil {
+		return false
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(ServiceName)
+	if err != nil {
+		return false
+	}
+	s.Close()
+	return true
+}
+
+// IsActive asks the service manager whether the service is running
+func IsActive() bool {
+	m, err := mgr.Connect()
+	if err != nil {
+		return false
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(ServiceName)
+	if err != nil {
+		return false
+	}
+	defer s.Close()
+	status, err := s.Query()
+	return err == nil && status.State == svc.Running
+}
+
+// IsService reports whether the process was started by the service manager
+func IsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// Run reports the service as running to the service manager, sends logging to
+// the event log, and blocks until the service is asked to stop. stop is called
+// before the stop is acknowledged and should shut the server down gracefully.
+func Run(stop func()) error {
+	// A configured log file takes precedence over the event log
+	if elog, err := eventlog.Open(ServiceName); err == nil && log.Writer() == os.Stderr {
+		defer elog.Close()
+		log.SetFlags(0)
+		log.SetOutput(eventLogWriter{elog})
+	}
+	return svc.Run(ServiceName, &handler{stop: stop})
+}
+
+// handler answers service manager requests
+type handler struct {
+	stop func()
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			h.stop()
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// eventLogWriter sends log output to the Windows event log
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	var err error
+	switch {
+	case strings.Contains(msg, "Error") || strings.Contains(msg, "Failed"):
+		err = w.elog.Error(1, msg)
+	case strings.Contains(msg, "Warning"):
+		err = w.elog.Warning(1, msg)
+	default:
+		err = w.elog.Info(1, msg)
+	}
+	return len(p), err
 }
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileMode is the permission of stored files. Temporary files are created
+// 0600, so they are set to this before being moved into place; a reverse
+// proxy or backup job running as another user must be able to read them.
+const FileMode os.FileMode = 0644
+
+// Local stores files in a directory tree on the local filesystem
+type Local struct {
+	root string
+}
+
+// NewLocal returns a backend storing files under root
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// Name returns "local"
+func (l *Local) Name() string {
+	return BackendLocal
+}
+
+// Root returns the directory holding the files
+func (l *Local) Root() string {
+	return l.root
+}
+
+// fullPath returns the location of a relative path on disk
+func (l *Local) fullPath(relativePath string) string {
+	return filepath.Join(l.root, filepath.FromSlash(cleanPath(relativePath)))
+}
+
+// Put writes r via a temporary file next to the destination
+func (l *Local) Put(r io.Reader, relativePath string) (int64, error) {
+	dest := l.fullPath(relativePath)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".put-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Chmod(FileMode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+// moveIn renames a local file into place
+func (l *Local) moveIn(localPath, relativePath string) error {
+	dest := l.fullPath(relativePath)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return os.Rename(localPath, dest)
+}
+
+// Get opens a file; the *os.File lets http.ServeContent use sendfile
+func (l *Local) Get(relativePath string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(l.fullPath(relativePath))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, notExist("open", relativePath)
+	}
+	return f, nil
+}
+
+// Delete removes a file and, if that empties it, its directory
+func (l *Local) Delete(relativePath string) error {
+	fullPath := l.fullPath(relativePath)
+	if err := os.Remove(fullPath); err != nil {
+		return err
+	}
+	l.removeEmptyDir(filepath.Dir(fullPath))
+	return nil
+}
+
+// Exists reports whether a regular file is stored at relativePath
+func (l *Local) Exists(relativePath string) (bool, error) {
+	info, err := os.Stat(l.fullPath(relativePath))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// Stat returns a file's size and modification time
+func (l *Local) Stat(relativePath string) (FileInfo, error) {
+	info, err := os.Stat(l.fullPath(relativePath))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if info.IsDir() {
+		return FileInfo{}, notExist("stat", relativePath)
+	}
+	return FileInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Rename moves a file, creating the target directory and removing the source
+// directory once it is empty
+func (l *Local) Rename(from, to string) error {
+	src, dest := l.fullPath(from), l.fullPath(to)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(src, dest); err != nil {
+		return err
+	}
+	l.removeEmptyDir(filepath.Dir(src))
+	return nil
+}
+
+// Walk visits the regular files under the root. A missing root holds no files.
+func (l *Local) Walk(fn WalkFunc) error {
+	return filepath.Walk(l.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return nil
+		}
+		return fn(filepath.ToSlash(rel), FileInfo{Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+// removeEmptyDir removes a directory below the root if it's empty, then its
+// parents as they empty in turn, for layouts that nest directories
+func (l *Local) removeEmptyDir(dirPath string) {
+	root := filepath.Clean(l.root)
+	for dirPath = filepath.Clean(dirPath); dirPath != root && strings.HasPrefix(dirPath, root+string(filepath.Separator)); dirPath = filepath.Dir(dirPath) {
+		if entries, err := os.ReadDir(dirPath); err != nil || len(entries) > 0 {
+			return
+		}
+		if os.Remove(dirPath) != nil {
+			return
+		}
+	}
+}
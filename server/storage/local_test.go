@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLocalFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes don't reflect access on Windows")
+	}
+	root := t.TempDir()
+	l := NewLocal(root)
+
+	if _, err := l.Put(strings.NewReader("hello"), "20240101/put.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(root, "20240101", "put.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != FileMode {
+		t.Errorf("stored file has mode %04o, want %04o", info.Mode().Perm(), FileMode)
+	}
+}
@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Timeout bounds metadata requests; object bodies are streamed without a deadline
+const s3Timeout = 30 * time.Second
+
+// S3Config configures an S3-compatible backend
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // Optional key prefix, e.g. "images/"
+	PathStyle bool   // Address the bucket as endpoint/bucket rather than bucket.endpoint
+}
+
+// S3 stores files as objects in an S3-compatible bucket, talking to the REST API
+// directly with SigV4-signed requests
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client // Object transfers, which may take arbitrarily long
+	api      *http.Client // Everything else
+}
+
+// NewS3 returns an S3 backend. Endpoint and bucket are required.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("storage.s3_endpoint and storage.s3_bucket are required for the s3 backend")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid storage.s3_endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	return &S3{cfg: cfg, endpoint: endpoint, client: &http.Client{}, api: &http.Client{Timeout: s3Timeout}}, nil
+}
+
+// Name returns "s3"
+func (s *S3) Name() string {
+	return BackendS3
+}
+
+// key returns the object key for a relative path
+func (s *S3) key(relativePath string) string {
+	return path.Join(s.cfg.Prefix, cleanPath(relativePath))
+}
+
+// objectURL returns the URL of an object key, or of the bucket for an empty key
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		base += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = base + "/" + key
+	u.RawPath = uriEncode(base, false) + "/" + uriEncode(key, false)
+	return &u
+}
+
+// do signs and sends a request. Responses with an error status are turned into
+// errors, 404 into one satisfying os.IsNotExist.
+func (s *S3) do(req *http.Request, payloadHash, relativePath string) (*http.Response, error) {
+	signV4(req, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, payloadHash, time.Now())
+	client := s.api
+	if req.Body != nil || req.Header.Get("Range") != "" {
+		client = s.client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, notExist(strings.ToLower(req.Method), relativePath)
+	}
+	return nil, s3Error(resp)
+}
+
+// s3Error describes an error response, using the XML error code when present
+func s3Error(resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	raw, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(raw, &body) == nil && body.Code != "" {
+		return fmt.Errorf("s3: %s: %s", body.Code, body.Message)
+	}
+	return fmt.Errorf("s3: unexpected status %s", resp.Status)
+}
+
+// S3SpoolPrefix starts the names of the files Put spools readers to in the OS
+// temp dir
+const S3SpoolPrefix = "httpserver-s3-"
+
+// Put uploads r as one object. S3 needs the length up front, so readers that
+// can't seek are spooled to a temporary file first.
+func (s *S3) Put(r io.Reader, relativePath string) (int64, error) {
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		tmp, err := ioutil.TempFile("", S3SpoolPrefix+"*")
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, r); err != nil {
+			return 0, err
+		}
+		body = tmp
+	}
+
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(s.key(relativePath)).String(), ioutil.NopCloser(body))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req, unsignedPayload, relativePath)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+// Get returns a reader that fetches the object with ranged GETs, so seeking (and
+// with it HTTP range requests) only transfers the bytes actually read
+func (s *S3) Get(relativePath string) (io.ReadSeekCloser, error) {
+	info, err := s.Stat(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{s3: s, relativePath: relativePath, size: info.Size}, nil
+}
+
+// Delete removes an object. S3 reports success for missing objects too.
+func (s *S3) Delete(relativePath string) error {
+	req, err := s.newRequest(http.MethodDelete, s.key(relativePath))
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash, relativePath)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Exists reports whether an object is stored at relativePath
+func (s *S3) Exists(relativePath string) (bool, error) {
+	_, err := s.Stat(relativePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Stat returns an object's size and modification time with a HEAD request
+func (s *S3) Stat(relativePath string) (FileInfo, error) {
+	req, err := s.newRequest(http.MethodHead, s.key(relativePath))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp, err := s.do(req, emptyPayloadHash, relativePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+
+	info := FileInfo{Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+// Rename copies an object server-side and deletes the original
+func (s *S3) Rename(from, to string) error {
+	req, err := s.newRequest(http.MethodPut, s.key(to))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Copy-Source", uriEncode("/"+s.cfg.Bucket+"/"+s.key(from), false))
+	resp, err := s.do(req, emptyPayloadHash, from)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// A copy can fail after the 200 status has been sent; the body then holds an error
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(raw, &result) == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("s3: copy failed: %s: %s", result.Code, result.Message)
+	}
+	return s.Delete(from)
+}
+
+// listResult is a ListObjectsV2 response page
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Walk lists every object under the prefix with ListObjectsV2
+func (s *S3) Walk(fn WalkFunc) error {
+	prefix := ""
+	if s.cfg.Prefix != "" {
+		prefix = s.cfg.Prefix + "/"
+	}
+
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		u := s.objectURL("")
+		u.RawQuery = canonicalQuery(query)
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := s.do(req, emptyPayloadHash, "")
+		if err != nil {
+			return err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: invalid list response: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			rel := strings.TrimPrefix(obj.Key, prefix)
+			if rel == "" || strings.HasSuffix(rel, "/") {
+				continue
+			}
+			if err := fn(rel, FileInfo{Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// newRequest builds a bodiless request for an object key
+func (s *S3) newRequest(method, key string) (*http.Request, error) {
+	return http.NewRequest(method, s.objectURL(key).String(), nil)
+}
+
+// s3Object reads an object lazily. Each Read after a Seek to a new offset starts
+// a ranged GET from that offset; sequential reads reuse the open response.
+type s3Object struct {
+	s3           *S3
+	relativePath string
+	size         int64
+	offset       int64
+	body         io.ReadCloser // Open ranged response positioned at offset, if any
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		req, err := http.NewRequest(http.MethodGet, o.s3.objectURL(o.s3.key(o.relativePath)).String(), nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(o.offset, 10)+"-")
+		resp, err := o.s3.do(req, emptyPayloadHash, o.relativePath)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && o.offset > 0 {
+			resp.Body.Close()
+			return 0, errors.New("s3: server ignored the range request")
+		}
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = o.offset + offset
+	case io.SeekEnd:
+		next = o.size + offset
+	default:
+		return 0, errors.New("s3: invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if next != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = next
+	return next, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		err := o.body.Close()
+		o.body = nil
+		return err
+	}
+	return nil
+}
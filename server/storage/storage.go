@@ -0,0 +1,111 @@
+// Package storage holds the bytes of uploaded files. Files are addressed by the
+// relative paths recorded in the metadata database (YYYYMMDD/name); the metadata
+// itself stays in the db package whichever backend is used.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"httpserver/server/config"
+)
+
+// Backend names for storage.backend
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// FileInfo describes a stored file
+type FileInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// WalkFunc is called for every stored file with its slash-separated relative path
+type WalkFunc func(relativePath string, info FileInfo) error
+
+// Backend stores file contents. Missing files are reported with errors for which
+// os.IsNotExist returns true.
+type Backend interface {
+	// Name returns the backend name ("local" or "s3")
+	Name() string
+	// Put stores the contents of r, replacing any existing file, and returns the size written
+	Put(r io.Reader, relativePath string) (int64, error)
+	// Get opens a file for reading; the caller must close it
+	Get(relativePath string) (io.ReadSeekCloser, error)
+	// Delete removes a file
+	Delete(relativePath string) error
+	// Exists reports whether a file is stored at relativePath
+	Exists(relativePath string) (bool, error)
+	// Stat returns a file's size and modification time
+	Stat(relativePath string) (FileInfo, error)
+	// Rename moves a file, e.g. into or out of the trash
+	Rename(from, to string) error
+	// Walk calls fn for every stored file, in no particular order
+	Walk(fn WalkFunc) error
+}
+
+// New returns the backend selected by storage.backend
+func New(cfg *config.Config) (Backend, error) {
+	switch cfg.Storage.Backend {
+	case "", BackendLocal:
+		return NewLocal(cfg.Storage.ImagesDir), nil
+	case BackendS3:
+		return NewS3(S3Config{
+			Endpoint:  cfg.Storage.S3Endpoint,
+			Bucket:    cfg.Storage.S3Bucket,
+			Region:    cfg.Storage.S3Region,
+			AccessKey: cfg.Storage.S3AccessKey,
+			SecretKey: cfg.Storage.S3Secret,
+			Prefix:    cfg.Storage.S3Prefix,
+			PathStyle: cfg.Storage.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// PutFile stores a local file under relativePath. The local backend moves it into
+// place; other backends upload a copy and leave the file for the caller to remove.
+// It reports whether the file was moved.
+func PutFile(b Backend, localPath, relativePath string) (bool, error) {
+	if local, ok := b.(*Local); ok {
+		return true, local.moveIn(localPath, relativePath)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	_, err = b.Put(f, relativePath)
+	return false, err
+}
+
+// IsReserved reports whether a relative path lies in one of the given top-level
+// directories, e.g. the trash or the upload staging area
+func IsReserved(relativePath string, dirs ...string) bool {
+	first := strings.SplitN(cleanPath(relativePath), "/", 2)[0]
+	for _, dir := range dirs {
+		if first == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanPath normalizes a relative path to slash-separated form without a leading slash
+func cleanPath(relativePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(relativePath)), "/")
+}
+
+// notExist returns an error for a missing file that satisfies os.IsNotExist
+func notExist(op, relativePath string) error {
+	return &os.PathError{Op: op, Path: relativePath, Err: os.ErrNotExist}
+}
@@ -0,0 +1,96 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// chunkSize bounds how many bytes are written between rate checks
+const chunkSize = 32 * 1024
+
+// Limiter paces byte consumption to a fixed rate shared by all callers
+type Limiter struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	next        time.Time
+}
+
+// NewLimiter creates a limiter; a non-positive rate means unlimited
+func NewLimiter(bytesPerSec int) *Limiter {
+	return &Limiter{bytesPerSec: float64(bytesPerSec)}
+}
+
+// Wait blocks until n more bytes may be consumed. If ctx ends first, the
+// bytes are given back, so other callers sharing the limiter aren't held up
+// for bandwidth that was never used.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil || l.bytesPerSec <= 0 {
+		return ctx.Err()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cost := time.Duration(float64(n) / l.bytesPerSec * float64(time.Second))
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(cost)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.next = l.next.Add(-cost)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Writer paces writes through one or more limiters, e.g. a per-connection
+// limiter plus a global one shared by all connections
+type Writer struct {
+	w        io.Writer
+	ctx      context.Context
+	limiters []*Limiter
+}
+
+// NewWriter wraps w so that every write waits on each of the limiters
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) *Writer {
+	return &Writer{w: w, ctx: ctx, limiters: limiters}
+}
+
+// Write writes p in chunks, waiting on the limiters before each chunk
+func (tw *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		for _, l := range tw.limiters {
+			if err := l.Wait(tw.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		m, err := tw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
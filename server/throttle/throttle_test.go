@@ -0,0 +1,153 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUnlimited(t *testing.T) {
+	var none *Limiter
+	for _, l := range []*Limiter{none, NewLimiter(0), NewLimiter(-1)} {
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			if err := l.Wait(context.Background(), 1<<20); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("unlimited limiter waited %v", elapsed)
+		}
+	}
+}
+
+func TestWriterRate(t *testing.T) {
+	const rate = 100 * 1024
+	var out bytes.Buffer
+	w := NewWriter(context.Background(), &out, NewLimiter(rate))
+
+	// The first chunk goes at once; the other four wait 100ms each
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write(make([]byte, 10*1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed < 380*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("50 KiB at 100 KiB/s took %v, want about 400ms", elapsed)
+	}
+	if out.Len() != 50*1024 {
+		t.Errorf("wrote %d bytes, want %d", out.Len(), 50*1024)
+	}
+}
+
+func TestWriterLargeWriteChunked(t *testing.T) {
+	const rate = 256 * 1024
+	var out bytes.Buffer
+	w := NewWriter(context.Background(), &out, NewLimiter(rate))
+
+	// One write of 4 chunks is paced like 4 writes: three chunks of waiting
+	start := time.Now()
+	n, err := w.Write(make([]byte, 4*chunkSize))
+	if err != nil || n != 4*chunkSize {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	want := time.Duration(float64(3*chunkSize) / rate * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want-20*time.Millisecond || elapsed > want+time.Second {
+		t.Errorf("128 KiB at 256 KiB/s took %v, want about %v", elapsed, want)
+	}
+}
+
+func TestSharedLimiter(t *testing.T) {
+	const rate = 100 * 1024
+	shared := NewLimiter(rate)
+	a := NewWriter(context.Background(), &bytes.Buffer{}, shared)
+	b := NewWriter(context.Background(), &bytes.Buffer{}, shared)
+
+	// Two writers sharing the limiter split its rate
+	start := time.Now()
+	done := make(chan error, 2)
+	for _, w := range []*Writer{a, b} {
+		go func(w *Writer) {
+			var err error
+			for i := 0; i < 3 && err == nil; i++ {
+				_, err = w.Write(make([]byte, 10*1024))
+			}
+			done <- err
+		}(w)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 480*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("2 x 30 KiB sharing 100 KiB/s took %v, want about 500ms", elapsed)
+	}
+}
+
+func TestCancelledWaitGivesBackReservation(t *testing.T) {
+	const rate = 100 * 1024
+	l := NewLimiter(rate)
+	if err := l.Wait(context.Background(), 10*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	// A large read queues behind the first, then its download goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- l.Wait(ctx, 500*1024) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("cancelled Wait = %v, want context.Canceled", err)
+	}
+
+	// The next caller only waits out the first 10 KiB, not the 500 KiB
+	// that were never sent
+	start := time.Now()
+	if err := l.Wait(context.Background(), 1024); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("next caller waited %v for a cancelled reservation", elapsed)
+	}
+}
+
+func TestWaitOnCancelledContext(t *testing.T) {
+	l := NewLimiter(1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.Wait(ctx, 1<<20); err != context.Canceled {
+		t.Errorf("Wait = %v, want context.Canceled", err)
+	}
+	if !l.next.IsZero() {
+		t.Errorf("a cancelled caller reserved bandwidth until %v", l.next)
+	}
+
+	var out bytes.Buffer
+	n, err := NewWriter(ctx, &out, l).Write([]byte("hello"))
+	if n != 0 || err != context.Canceled || out.Len() != 0 {
+		t.Errorf("Write = %d, %v with %d bytes out; want nothing written", n, err, out.Len())
+	}
+}
+
+func TestWriterStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var out bytes.Buffer
+	w := NewWriter(ctx, &out, NewLimiter(chunkSize))
+
+	// The first chunk goes at once, the second would wait a second
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	n, err := w.Write(make([]byte, 3*chunkSize))
+	if err != context.Canceled || n != chunkSize {
+		t.Errorf("Write = %d, %v; want %d, context.Canceled", n, err, chunkSize)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Write returned %v after the cancel", elapsed)
+	}
+}
@@ -0,0 +1,181 @@
+// Package totp implements time-based one-time passwords (RFC 6238) and the
+// recovery codes that stand in for them when the authenticator is lost.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Step is the lifetime of one code
+	Step = 30 * time.Second
+	// Digits is the length of a code
+	Digits = 6
+	// Skew is how many steps either side of the current one are accepted, to
+	// allow for clock drift and slow typing
+	Skew = 1
+
+	secretBytes       = 20 // 160 bits, as RFC 4226 recommends
+	recoveryCodeBytes = 5  // 10 hex characters
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded as authenticator
+// apps expect
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// decodeSecret accepts a base32 secret with or without padding, spaces or
+// lowercase letters
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return key, nil
+}
+
+// hotp computes the RFC 4226 code for a counter value
+func hotp(key []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// Code returns the code for secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix())/uint64(Step/time.Second), Digits), nil
+}
+
+// Validate reports whether code is valid for secret at time t, within Skew
+// steps either way
+func Validate(secret, code string, t time.Time) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return false
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return false
+	}
+	counter := int64(t.Unix()) / int64(Step/time.Second)
+	for i := -Skew; i <= Skew; i++ {
+		if counter+int64(i) < 0 {
+			continue
+		}
+		expected := hotp(key, uint64(counter+int64(i)), Digits)
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// URI returns the otpauth:// URI authenticator apps import, usually as a QR code
+func URI(secret, issuer, account string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Step / time.Second))},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// GenerateRecoveryCodes returns n single-use recovery codes
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := hex.EncodeToString(b)
+		codes = append(codes, s[:5]+"-"+s[5:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the form a recovery code is stored in
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchRecoveryCode looks code up among stored hashes and returns the hashes
+// left once it is used up
+func MatchRecoveryCode(hashes []string, code string) ([]string, bool) {
+	hash := HashRecoveryCode(code)
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			remaining := append(append([]string(nil), hashes[:i]...), hashes[i+1:]...)
+			return remaining, true
+		}
+	}
+	return hashes, false
+}
+
+// Enrollment is a freshly generated secret with its recovery codes
+type Enrollment struct {
+	Secret         string   `json:"secret"`
+	URI            string   `json:"uri"`
+	RecoveryCodes  []string `json:"recovery_codes"`
+	RecoveryHashes []string `json:"-"`
+}
+
+// NewEnrollment generates a secret and recovery codes for account
+func NewEnrollment(issuer, account string, recoveryCodes int) (*Enrollment, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	codes, err := GenerateRecoveryCodes(recoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return &Enrollment{
+		Secret:         secret,
+		URI:            URI(secret, issuer, account),
+		RecoveryCodes:  codes,
+		RecoveryHashes: hashes,
+	}, nil
+}
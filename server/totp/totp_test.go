@@ -0,0 +1,137 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 4226 and RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTPRFC4226Vectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := hotp([]byte("12345678901234567890"), uint64(counter), 6); got != code {
+			t.Errorf("hotp(counter %d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string // Eight digits, as listed in RFC 6238 appendix B
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	key := []byte("12345678901234567890")
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		if got := hotp(key, uint64(tt.unix)/30, 8); got != tt.code {
+			t.Errorf("8-digit code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+		// Six-digit codes are the last six digits of the same value
+		got, err := Code(rfcSecret, at)
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		if got != tt.code[2:] {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.code[2:])
+		}
+		if !Validate(rfcSecret, tt.code[2:], at) {
+			t.Errorf("Validate rejected the code at %d", tt.unix)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	for _, tt := range []struct {
+		steps int
+		valid bool
+	}{
+		{-2, false}, {-1, true}, {0, true}, {1, true}, {2, false},
+	} {
+		code, err := Code(rfcSecret, now.Add(time.Duration(tt.steps)*Step))
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		if got := Validate(rfcSecret, code, now); got != tt.valid {
+			t.Errorf("code from %+d steps: Validate = %v, want %v", tt.steps, got, tt.valid)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := Code(rfcSecret, now)
+	tests := []struct {
+		name, secret, code string
+	}{
+		{"empty code", rfcSecret, ""},
+		{"short code", rfcSecret, code[:5]},
+		{"long code", rfcSecret, code + "0"},
+		{"wrong code", rfcSecret, "000000"},
+		{"bad secret", "not base32!", code},
+	}
+	for _, tt := range tests {
+		if Validate(tt.secret, tt.code, now) {
+			t.Errorf("%s: Validate accepted %q", tt.name, tt.code)
+		}
+	}
+	// Authenticator apps show secrets in lowercase groups; those still work
+	loose := strings.ToLower(rfcSecret[:4] + " " + rfcSecret[4:])
+	if !Validate(loose, " "+code+" ", now) {
+		t.Error("Validate rejected a lowercase, spaced secret")
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	enrollment, err := NewEnrollment("HttpServer", "admin", 3)
+	if err != nil {
+		t.Fatalf("NewEnrollment: %v", err)
+	}
+	if len(enrollment.RecoveryCodes) != 3 || len(enrollment.RecoveryHashes) != 3 {
+		t.Fatalf("got %d codes and %d hashes, want 3 each", len(enrollment.RecoveryCodes), len(enrollment.RecoveryHashes))
+	}
+
+	hashes := enrollment.RecoveryHashes
+	code := enrollment.RecoveryCodes[1]
+	remaining, ok := MatchRecoveryCode(hashes, strings.ToUpper(code))
+	if !ok {
+		t.Fatal("a fresh recovery code was rejected")
+	}
+	if len(remaining) != 2 {
+		t.Fatalf("%d hashes remain after one use, want 2", len(remaining))
+	}
+	if len(hashes) != 3 || hashes[1] != HashRecoveryCode(code) {
+		t.Error("MatchRecoveryCode modified the stored hashes")
+	}
+	if _, ok := MatchRecoveryCode(remaining, code); ok {
+		t.Error("a used recovery code was accepted again")
+	}
+	for _, other := range []string{enrollment.RecoveryCodes[0], enrollment.RecoveryCodes[2]} {
+		if _, ok := MatchRecoveryCode(remaining, other); !ok {
+			t.Errorf("unused recovery code %s was rejected", other)
+		}
+	}
+	if _, ok := MatchRecoveryCode(remaining, "00000-00000"); ok {
+		t.Error("an unknown recovery code was accepted")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("SECRET", "Http Server", "admin")
+	for _, part := range []string{"otpauth://totp/Http%20Server:admin?", "secret=SECRET", "issuer=Http+Server", "digits=6", "period=30", "algorithm=SHA1"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI %q lacks %q", uri, part)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+
+	"httpserver/server/backup"
+	"httpserver/server/db"
+	"httpserver/server/storage"
+)
+
+func handleExportCommand(args []string, dbPath string) {
+	// --with-files adds the Images tree to the archive
+	withFiles := false
+	filtered := args[:0:0]
+	for _, arg := range args {
+		if arg == "--with-files" || arg == "-with-files" {
+			withFiles = true
+			continue
+		}
+		filtered = append(filtered, arg)
+	}
+	args = filtered
+
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Error: 'export' command requires an output file")
+		fmt.Fprintln(os.Stderr, "Usage: httpserver export [-c <path>] [--with-files] <file.tar.gz>")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
+	outPath := args[1]
+
+	// The archive holds credentials, so keep it private to the owner
+	out, err := os.OpenFile(outPath+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", outPath, err)
+	}
+	defer os.Remove(outPath + ".tmp")
+
+	// A read-only open is enough for a snapshot and lets exports run in parallel
+	var files int
+	database, err := db.OpenReadOnly(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		// A server owns the database; it takes the snapshot under its lock
+		info, infoErr := readControlFile(dbPath)
+		if infoErr != nil {
+			log.Fatalf("Failed to open database: %v (and the running server could not be reached)", err)
+		}
+		query := url.Values{}
+		if withFiles {
+			query.Set("files", "1")
+		}
+		err = controlDownload(info, "/export", query, out)
+		files = -1
+	} else if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	} else {
+		var snapshot []byte
+		snapshot, err = database.Snapshot()
+		if err == nil {
+			var store storage.Backend
+			if store, err = storage.New(buildConfigFromDB(database)); err == nil {
+				files, err = backup.Write(out, snapshot, store, withFiles)
+			}
+		}
+		database.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	if err := os.Rename(outPath+".tmp", outPath); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+
+	switch {
+	case !withFiles:
+		fmt.Printf("Exported database to %s\n", outPath)
+	case files < 0:
+		fmt.Printf("Exported database and files to %s (snapshot taken by the running server)\n", outPath)
+	default:
+		fmt.Printf("Exported database and %d files to %s\n", files, outPath)
+	}
+}
+
+func handleImportCommand(args []string, dbPath string) {
+	mode := db.ImportNew
+	filtered := args[:0:0]
+	for _, arg := range args {
+		switch arg {
+		case "--merge", "-merge":
+			mode = db.ImportMerge
+		case "--replace", "-replace":
+			mode = db.ImportReplace
+		default:
+			filtered = append(filtered, arg)
+		}
+	}
+	args = filtered
+
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Error: 'import' command requires an archive")
+		fmt.Fprintln(os.Stderr, "Usage: httpserver import [-c <path>] [--merge|--replace] <file.tar.gz>")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
+
+	database, err := db.Open(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		log.Fatalf("Failed to open database: %v (stop the server before importing)", err)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	in, err := os.Open(args[1])
+	if err != nil {
+		log.Fatalf("Failed to open archive: %v", err)
+	}
+	defer in.Close()
+
+	archive, err := backup.NewReader(in)
+	if err != nil {
+		log.Fatalf("Failed to read archive: %v", err)
+	}
+	defer archive.Close()
+
+	if mode == db.ImportNew && !database.IsEmpty() {
+		log.Fatalf("Database %s already holds files; use --merge to keep both or --replace to discard the existing ones", dbPath)
+	}
+
+	// Files go to this installation's storage, whatever the source used
+	cfg := buildConfigFromDB(database)
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up storage: %v", err)
+	}
+	if src := archive.Data.Config["storage.images_dir"]; store.Name() == storage.BackendLocal && src != "" && src != cfg.Storage.ImagesDir {
+		fmt.Printf("Remapping storage.images_dir: %s -> %s\n", src, cfg.Storage.ImagesDir)
+	}
+
+	files, err := archive.ExtractFiles(store, mode == db.ImportReplace)
+	if err != nil {
+		log.Fatalf("Failed to restore files (%d restored so far): %v", files, err)
+	}
+
+	result, err := database.Import(archive.Data, mode)
+	if err != nil {
+		log.Fatalf("Failed to import database: %v", err)
+	}
+
+	fmt.Printf("Imported %d records and %d files from %s\n", result.Imported, files, args[1])
+	if result.Skipped > 0 {
+		fmt.Printf("Skipped %d records already present\n", result.Skipped)
+	}
+	if result.Replaced > 0 {
+		fmt.Printf("Replaced %d existing records\n", result.Replaced)
+	}
+}
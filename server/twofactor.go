@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"httpserver/server/db"
+	"httpserver/server/httpd"
+	"httpserver/server/totp"
+)
+
+func handleAdminCommand(args []string, dbPath string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "Usage: httpserver admin [-c <path>] enable-2fa")
+		fmt.Fprintln(os.Stderr, "       httpserver admin [-c <path>] disable-2fa <code>")
+		os.Exit(1)
+	}
+	if len(args) < 2 {
+		usage()
+	}
+
+	switch args[1] {
+	case "enable-2fa":
+		if len(args) != 2 {
+			usage()
+		}
+		fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
+		enableTwoFactor(dbPath)
+	case "disable-2fa":
+		if len(args) != 3 {
+			usage()
+		}
+		fmt.Fprintf(os.Stderr, "Using database: %s\n", dbPath)
+		disableTwoFactor(dbPath, args[2])
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown admin command '%s'\n", args[1])
+		usage()
+	}
+}
+
+// enableTwoFactor generates a TOTP secret and recovery codes for the admin
+// endpoints and prints them
+func enableTwoFactor(dbPath string) {
+	database, err := db.Open(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		info, infoErr := readControlFile(dbPath)
+		if infoErr != nil {
+			log.Fatalf("Failed to open database: %v (and the running server could not be reached)", err)
+		}
+		result, err := controlRequest(info, http.MethodPost, "/2fa/enable", nil, nil)
+		if err != nil {
+			log.Fatalf("Failed to enable two-factor authentication: %v", err)
+		}
+		enrollment, _ := result["enrollment"].(map[string]interface{})
+		secret, _ := enrollment["secret"].(string)
+		uri, _ := enrollment["uri"].(string)
+		var codes []string
+		if list, ok := enrollment["recovery_codes"].([]interface{}); ok {
+			for _, code := range list {
+				if s, ok := code.(string); ok {
+					codes = append(codes, s)
+				}
+			}
+		}
+		printEnrollment(secret, uri, codes, "active on the running server")
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	if database.GetConfig("auth.totp_secret") != "" {
+		fmt.Fprintln(os.Stderr, "Two-factor authentication is already enabled; disable it first")
+		database.Close()
+		os.Exit(1)
+	}
+	enrollment, err := totp.NewEnrollment(httpd.TOTPIssuer, database.GetConfig("auth.admin_username"), httpd.TOTPRecoveryCodes)
+	if err != nil {
+		log.Fatalf("Failed to generate secret: %v", err)
+	}
+	if err := database.SetConfig("auth.totp_recovery_codes", strings.Join(enrollment.RecoveryHashes, ",")); err != nil {
+		log.Fatalf("Failed to save recovery codes: %v", err)
+	}
+	if err := database.SetConfig("auth.totp_secret", enrollment.Secret); err != nil {
+		log.Fatalf("Failed to save secret: %v", err)
+	}
+	printEnrollment(enrollment.Secret, enrollment.URI, enrollment.RecoveryCodes, "takes effect when the server starts")
+}
+
+func printEnrollment(secret, uri string, codes []string, when string) {
+	fmt.Printf("Two-factor authentication enabled (%s)\n\n", when)
+	fmt.Println("Add this account to your authenticator app, e.g. by turning the URI into a QR code:")
+	fmt.Printf("  Secret: %s\n", secret)
+	fmt.Printf("  URI:    %s\n\n", uri)
+	fmt.Println("Recovery codes (each works once; store them somewhere safe, they are not shown again):")
+	for _, code := range codes {
+		fmt.Printf("  %s\n", code)
+	}
+	fmt.Println()
+	fmt.Printf("Admin API requests must now send the current code in the %s header.\n", httpd.TOTPHeader)
+}
+
+// disableTwoFactor turns two-factor authentication off, which needs a currently
+// valid code or an unused recovery code
+func disableTwoFactor(dbPath, code string) {
+	database, err := db.Open(dbPath)
+	if _, ok := err.(*db.LockedError); ok {
+		info, infoErr := readControlFile(dbPath)
+		if infoErr != nil {
+			log.Fatalf("Failed to open database: %v (and the running server could not be reached)", err)
+		}
+		if _, err := controlRequest(info, http.MethodPost, "/2fa/disable", nil, map[string]string{"code": code}); err != nil {
+			log.Fatalf("Failed to disable two-factor authentication: %v", err)
+		}
+		fmt.Println("Two-factor authentication disabled (applied to the running server)")
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	secret := database.GetConfig("auth.totp_secret")
+	if secret == "" {
+		fmt.Fprintln(os.Stderr, "Two-factor authentication is not enabled")
+		database.Close()
+		os.Exit(1)
+	}
+	var hashes []string
+	if stored := database.GetConfig("auth.totp_recovery_codes"); stored != "" {
+		hashes = strings.Split(stored, ",")
+	}
+	if _, ok := totp.MatchRecoveryCode(hashes, code); !ok && !totp.Validate(secret, code, time.Now()) {
+		fmt.Fprintln(os.Stderr, "Invalid two-factor code")
+		database.Close()
+		os.Exit(1)
+	}
+
+	if err := database.SetConfig("auth.totp_secret", ""); err != nil {
+		log.Fatalf("Failed to disable two-factor authentication: %v", err)
+	}
+	if err := database.SetConfig("auth.totp_recovery_codes", ""); err != nil {
+		log.Fatalf("Failed to clear recovery codes: %v", err)
+	}
+	fmt.Println("Two-factor authentication disabled (takes effect when the server starts)")
+}